@@ -5,11 +5,12 @@ require (
 	github.com/mattn/go-isatty v0.0.12
 	github.com/mattn/go-runewidth v0.0.9
 	github.com/mattn/go-shellwords v1.0.10
+	github.com/rivo/uniseg v0.4.7
 	github.com/saracen/walker v0.1.1
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
 	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9 // indirect
 	golang.org/x/sys v0.0.0-20201026173827-119d4633e4d1
-	golang.org/x/text v0.3.3 // indirect
+	golang.org/x/text v0.3.3
 )
 
-go 1.13
+go 1.16
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"embed"
+	"os"
+
 	"github.com/junegunn/fzf/src"
 	"github.com/junegunn/fzf/src/protector"
 )
@@ -8,7 +11,14 @@ import (
 var version string = "0.25"
 var revision string = "devel"
 
+//go:embed shell
+var shellFiles embed.FS
+
 func main() {
 	protector.Protect()
-	fzf.Run(fzf.ParseOptions(), version, revision)
+	options := fzf.ParseOptions()
+	if len(options.Init) > 0 {
+		fzf.PrintInit(options.Init, shellFiles, version)
+	}
+	os.Exit(fzf.Run(options, version, revision))
 }
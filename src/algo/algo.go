@@ -140,6 +140,57 @@ const (
 	bonusFirstCharMultiplier = 2
 )
 
+// Scoring is the table of the points for computing the score of a match
+type Scoring struct {
+	Match               int16
+	GapStart            int16
+	GapExtension        int16
+	Boundary            int16
+	NonWord             int16
+	Camel               int16
+	Consecutive         int16
+	FirstCharMultiplier int16
+}
+
+// DefaultScoring is the table of the default points described above
+var DefaultScoring = Scoring{
+	Match:               scoreMatch,
+	GapStart:            scoreGapStart,
+	GapExtension:        scoreGapExtention,
+	Boundary:            bonusBoundary,
+	NonWord:             bonusNonWord,
+	Camel:               bonusCamel123,
+	Consecutive:         bonusConsecutive,
+	FirstCharMultiplier: bonusFirstCharMultiplier,
+}
+
+var scoring = DefaultScoring
+
+// Init replaces the scoring table. It should be called before the matching
+// starts as the table is shared by all the algorithms.
+func Init(table Scoring) {
+	scoring = table
+}
+
+// Boundaries tells which transitions in a word are taken for the boundaries
+// of the parts of the word to give the bonus points
+type Boundaries struct {
+	Camel bool // camelCase
+	Snake bool // snake_case
+	Digit bool // letter123
+}
+
+// DefaultBoundaries is the boundaries of the words respected by default
+var DefaultBoundaries = Boundaries{Camel: true, Snake: true, Digit: true}
+
+var boundaries = DefaultBoundaries
+
+// SetBoundaries replaces the boundaries of the words. Like Init, it should be
+// called before the matching starts.
+func SetBoundaries(bounds Boundaries) {
+	boundaries = bounds
+}
+
 type charClass int
 
 const (
@@ -181,6 +232,9 @@ func charClassOfAscii(char rune) charClass {
 		return charUpper
 	} else if char >= '0' && char <= '9' {
 		return charNumber
+	} else if char == '_' && !boundaries.Snake {
+		// Part of the word
+		return charLetter
 	}
 	return charNonWord
 }
@@ -208,25 +262,33 @@ func charClassOf(char rune) charClass {
 func bonusFor(prevClass charClass, class charClass) int16 {
 	if prevClass == charNonWord && class != charNonWord {
 		// Word boundary
-		return bonusBoundary
-	} else if prevClass == charLower && class == charUpper ||
-		prevClass != charNumber && class == charNumber {
+		return scoring.Boundary
+	} else if boundaries.Camel && prevClass == charLower && class == charUpper ||
+		boundaries.Digit && prevClass != charNumber && class == charNumber {
 		// camelCase letter123
-		return bonusCamel123
+		return scoring.Camel
 	} else if class == charNonWord {
-		return bonusNonWord
+		return scoring.NonWord
 	}
 	return 0
 }
 
 func bonusAt(input *util.Chars, idx int) int16 {
 	if idx == 0 {
-		return bonusBoundary
+		return scoring.Boundary
 	}
 	return bonusFor(charClassOf(input.Get(idx-1)), charClassOf(input.Get(idx)))
 }
 
 func normalizeRune(r rune) rune {
+	if r < 0x00A0 {
+		return r
+	}
+	if compatFolded != nil {
+		if n, found := compatFolded[r]; found {
+			r = n
+		}
+	}
 	if r < 0x00C0 || r > 0x2184 {
 		return r
 	}
@@ -412,21 +474,21 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 		}
 
 		if char == pchar0 {
-			score := scoreMatch + bonus*bonusFirstCharMultiplier
+			score := scoring.Match + bonus*scoring.FirstCharMultiplier
 			H0sub[off] = score
 			C0sub[off] = 1
 			if M == 1 && (forward && score > maxScore || !forward && score >= maxScore) {
 				maxScore, maxScorePos = score, idx+off
-				if forward && bonus == bonusBoundary {
+				if forward && bonus == scoring.Boundary {
 					break
 				}
 			}
 			inGap = false
 		} else {
 			if inGap {
-				H0sub[off] = util.Max16(prevH0+scoreGapExtention, 0)
+				H0sub[off] = util.Max16(prevH0+scoring.GapExtension, 0)
 			} else {
-				H0sub[off] = util.Max16(prevH0+scoreGapStart, 0)
+				H0sub[off] = util.Max16(prevH0+scoring.GapStart, 0)
 			}
 			C0sub[off] = 0
 			inGap = true
@@ -477,20 +539,20 @@ func FuzzyMatchV2(caseSensitive bool, normalize bool, forward bool, input *util.
 			var s1, s2, consecutive int16
 
 			if inGap {
-				s2 = Hleft[off] + scoreGapExtention
+				s2 = Hleft[off] + scoring.GapExtension
 			} else {
-				s2 = Hleft[off] + scoreGapStart
+				s2 = Hleft[off] + scoring.GapStart
 			}
 
 			if pchar == char {
-				s1 = Hdiag[off] + scoreMatch
+				s1 = Hdiag[off] + scoring.Match
 				b := Bsub[off]
 				consecutive = Cdiag[off] + 1
 				// Break consecutive chunk
-				if b == bonusBoundary {
+				if b == scoring.Boundary {
 					consecutive = 1
 				} else if consecutive > 1 {
-					b = util.Max16(b, util.Max16(bonusConsecutive, B[col-int(consecutive)+1]))
+					b = util.Max16(b, util.Max16(scoring.Consecutive, B[col-int(consecutive)+1]))
 				}
 				if s1+b < s2 {
 					s1 += Bsub[off]
@@ -577,19 +639,19 @@ func calculateScore(caseSensitive bool, normalize bool, text *util.Chars, patter
 			if withPos {
 				*pos = append(*pos, idx)
 			}
-			score += scoreMatch
+			score += int(scoring.Match)
 			bonus := bonusFor(prevClass, class)
 			if consecutive == 0 {
 				firstBonus = bonus
 			} else {
 				// Break consecutive chunk
-				if bonus == bonusBoundary {
+				if bonus == scoring.Boundary {
 					firstBonus = bonus
 				}
-				bonus = util.Max16(util.Max16(bonus, firstBonus), bonusConsecutive)
+				bonus = util.Max16(util.Max16(bonus, firstBonus), scoring.Consecutive)
 			}
 			if pidx == 0 {
-				score += int(bonus * bonusFirstCharMultiplier)
+				score += int(bonus * scoring.FirstCharMultiplier)
 			} else {
 				score += int(bonus)
 			}
@@ -598,9 +660,9 @@ func calculateScore(caseSensitive bool, normalize bool, text *util.Chars, patter
 			pidx++
 		} else {
 			if inGap {
-				score += scoreGapExtention
+				score += int(scoring.GapExtension)
 			} else {
-				score += scoreGapStart
+				score += int(scoring.GapStart)
 			}
 			inGap = true
 			consecutive = 0
@@ -741,7 +803,7 @@ func ExactMatchNaive(caseSensitive bool, normalize bool, forward bool, text *uti
 				if bonus > bestBonus {
 					bestPos, bestBonus = index, bonus
 				}
-				if bonus == bonusBoundary {
+				if bonus == scoring.Boundary {
 					break
 				}
 				index -= pidx - 1
@@ -877,8 +939,8 @@ func EqualMatch(caseSensitive bool, normalize bool, forward bool, text *util.Cha
 		match = runesStr == string(pattern)
 	}
 	if match {
-		return Result{trimmedLen, trimmedLen + lenPattern, (scoreMatch+bonusBoundary)*lenPattern +
-			(bonusFirstCharMultiplier-1)*bonusBoundary}, nil
+		return Result{trimmedLen, trimmedLen + lenPattern, int(scoring.Match+scoring.Boundary)*lenPattern +
+			int((scoring.FirstCharMultiplier-1)*scoring.Boundary)}, nil
 	}
 	return Result{-1, -1, 0}, nil
 }
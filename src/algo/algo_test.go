@@ -1,7 +1,9 @@
 package algo
 
 import (
+	"fmt"
 	"math"
+	"regexp"
 	"sort"
 	"strings"
 	"testing"
@@ -9,6 +11,10 @@ import (
 	"github.com/junegunn/fzf/src/util"
 )
 
+// Each typo costs as much as a matched character at a word boundary of the
+// default scoring table (see typoPenalty)
+const scoreTypo = scoreMatch + bonusBoundary
+
 func assertMatch(t *testing.T, fun Algo, caseSensitive, forward bool, input, pattern string, sidx int, eidx int, score int) {
 	assertMatch2(t, fun, caseSensitive, false, forward, input, pattern, sidx, eidx, score)
 }
@@ -127,6 +133,129 @@ func TestExactMatchNaiveBackward(t *testing.T) {
 		scoreMatch*2+bonusConsecutive)
 }
 
+func TestTypoMatch(t *testing.T) {
+	score := scoreMatch*4 + bonusBoundary*(bonusFirstCharMultiplier+3)
+	for _, dir := range []bool{true, false} {
+		// Substitution and transposition
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "zshc", 6, 10, score)
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "zxhc", 6, 10, score-scoreTypo)
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "zhsc", 6, 10, score-scoreTypo)
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "hzsc", -1, -1, 0)
+
+		// Too short to allow typos
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "zx", -1, -1, 0)
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "zxh", 6, 9,
+			scoreMatch*3+bonusBoundary*(bonusFirstCharMultiplier+2)-scoreTypo)
+		assertMatch(t, TypoMatch(0), false, dir, "/man1/zshcompctl.1", "zxhc", -1, -1, 0)
+
+		// Number of typos
+		assertMatch(t, TypoMatch(1), false, dir, "/man1/zshcompctl.1", "zxhcompxtl", -1, -1, 0)
+		assertMatch(t, TypoMatch(2), false, dir, "/man1/zshcompctl.1", "zxhcompxtl", 6, 16,
+			scoreMatch*10+bonusBoundary*(bonusFirstCharMultiplier+9)-scoreTypo*2)
+	}
+
+	// Fewer typos are preferred to the position
+	assertMatch(t, TypoMatch(1), false, true, "fxob foob", "foob", 5, 9, score)
+	assertMatch(t, TypoMatch(1), false, true, "foob foxb", "foxb", 5, 9, score)
+	assertMatch(t, TypoMatch(1), false, true, "foob foob", "foxb", 0, 4, score-scoreTypo)
+	assertMatch(t, TypoMatch(1), false, false, "foob foob", "foxb", 5, 9, score-scoreTypo)
+}
+
+func TestFuzzyTypoMatch(t *testing.T) {
+	input := "/man1/zshcompctl.1"
+	chars := util.ToChars([]byte(input))
+	// The match of the pattern with the typo fixed
+	fixed := func(pattern string) Result {
+		res, _ := FuzzyMatchV2(false, false, true, &chars, []rune(pattern), true, nil)
+		return res
+	}
+	check := func(maxTypos int, pattern string, expected Result) {
+		t.Helper()
+		res, _ := FuzzyTypoMatch(FuzzyMatchV2, maxTypos)(false, false, true, &chars, []rune(pattern), true, nil)
+		if res != expected {
+			t.Errorf("%s: %v (expected: %v)", pattern, res, expected)
+		}
+	}
+	// No typos
+	check(1, "zscl", fixed("zscl"))
+
+	// Transposition and substitution
+	expected := fixed("zshc")
+	expected.Score -= scoreTypo
+	check(1, "zhsc", expected)
+	expected = fixed("zhc")
+	expected.Score -= scoreTypo
+	check(1, "zxhc", expected)
+
+	// Too short to allow typos
+	check(1, "zx", Result{-1, -1, 0})
+
+	// Number of typos
+	check(1, "zxhcompxtl", Result{-1, -1, 0})
+	expected = fixed("zhcomptl")
+	expected.Score -= scoreTypo * 2
+	check(2, "zxhcompxtl", expected)
+	check(2, "zxhx", Result{-1, -1, 0})
+
+	// Omission at the start and the end of the pattern
+	expected = fixed("zshc")
+	expected.Score -= scoreTypo
+	check(1, "xzshc", expected)
+	check(1, "zshcx", expected)
+	expected.Score -= scoreTypo
+	check(2, "xzshcx", expected)
+
+	// Positions of the matched characters
+	_, pos := FuzzyTypoMatch(FuzzyMatchV2, 1)(false, false, true, &chars, []rune("zhsc"), true, nil)
+	if pos == nil || fmt.Sprint(*pos) != "[9 8 7 6]" {
+		t.Errorf("zhsc: %v", pos)
+	}
+	_, pos = FuzzyTypoMatch(FuzzyMatchV2, 1)(false, false, true, &chars, []rune("zxhc"), true, nil)
+	if pos == nil || fmt.Sprint(*pos) != "[9 8 6]" {
+		t.Errorf("zxhc: %v", pos)
+	}
+}
+
+func TestRegexMatch(t *testing.T) {
+	score := scoreMatch*4 + bonusBoundary*(bonusFirstCharMultiplier+3)
+	assertMatch(t, RegexMatch(regexp.MustCompile(`zs?hc`)), false, true, "/man1/zshcompctl.1", "", 6, 10, score)
+	assertMatch(t, RegexMatch(regexp.MustCompile(`zx+hc`)), false, true, "/man1/zshcompctl.1", "", -1, -1, 0)
+
+	// Rune offsets of the multi-byte text
+	assertMatch(t, RegexMatch(regexp.MustCompile(`(?i)Z.HC`)), false, true, "한국어/zshc", "", 4, 8, score)
+
+	// The last match is taken for the backward direction
+	assertMatch(t, RegexMatch(regexp.MustCompile(`o+b`)), false, true, "foob foob", "", 1, 4,
+		scoreMatch*3+bonusConsecutive*2)
+	assertMatch(t, RegexMatch(regexp.MustCompile(`o+b`)), false, false, "foob foob", "", 6, 9,
+		scoreMatch*3+bonusConsecutive*2)
+}
+
+func TestScoring(t *testing.T) {
+	defer Init(DefaultScoring)
+	table := DefaultScoring
+	table.Boundary, table.FirstCharMultiplier = 12, 1
+	Init(table)
+	assertMatch(t, FuzzyMatchV2, false, true, "foo bar", "fb", 0, 5,
+		int(table.Match*2+table.Boundary*2+table.GapStart+table.GapExtension*2))
+	assertMatch(t, ExactMatchNaive, false, true, "foo bar", "bar", 4, 7,
+		int(table.Match*3+table.Boundary*3))
+	assertMatch(t, TypoMatch(1), false, true, "foo bar", "bxr", 4, 7,
+		int(table.Match*2+table.Boundary*2))
+}
+
+func TestBoundaries(t *testing.T) {
+	defer SetBoundaries(DefaultBoundaries)
+	SetBoundaries(Boundaries{Snake: true})
+	assertMatch(t, FuzzyMatchV2, false, true, "fooBar1", "b", 3, 4, scoreMatch)
+	assertMatch(t, FuzzyMatchV2, false, true, "foo_bar", "b", 4, 5,
+		scoreMatch+bonusBoundary*bonusFirstCharMultiplier)
+	SetBoundaries(Boundaries{Camel: true, Digit: true})
+	assertMatch(t, FuzzyMatchV2, false, true, "fooBar1", "b1", 3, 7,
+		scoreMatch*2+bonusCamel123*(bonusFirstCharMultiplier+1)+scoreGapStart+scoreGapExtention)
+	assertMatch(t, FuzzyMatchV2, false, true, "foo_bar", "b", 4, 5, scoreMatch)
+}
+
 func TestPrefixMatch(t *testing.T) {
 	score := (scoreMatch+bonusBoundary)*3 + bonusBoundary*(bonusFirstCharMultiplier-1)
 
@@ -187,6 +316,48 @@ func TestNormalize(t *testing.T) {
 	test("Danço", "danco", 0, 5, 128, FuzzyMatchV1, FuzzyMatchV2, PrefixMatch, SuffixMatch, ExactMatchNaive, EqualMatch)
 }
 
+func TestUnicodeNormalization(t *testing.T) {
+	defer SetUnicodeNormalization(false)
+	match := func(input, pattern string) bool {
+		composed, _ := ComposeBytes([]byte(input))
+		chars := util.ToChars(composed)
+		runes := NormalizeRunes([]rune(NormalizeString(strings.ToLower(pattern))))
+		for _, fun := range []Algo{FuzzyMatchV1, FuzzyMatchV2, ExactMatchNaive} {
+			if res, _ := fun(false, true, true, &chars, runes, false, nil); res.Start < 0 {
+				return false
+			}
+		}
+		return true
+	}
+	if match("ＦＵＬＬ width", "full") {
+		t.Error("full-width forms should not match unless enabled")
+	}
+
+	SetUnicodeNormalization(true)
+	for _, pair := range [][2]string{
+		{"ＦＵＬＬ width", "full"},
+		{"half width", "ｈａｌｆ"},
+		{"ﾃｽﾄ", "テスト"},
+		{"①②③", "123"},
+		{"cafe\u0301", "café"},
+		{"か\u3099き", "がき"},
+		{"Só Danço Samba", "danco"},
+	} {
+		if !match(pair[0], pair[1]) {
+			t.Errorf("%q should match %q", pair[0], pair[1])
+		}
+	}
+	if match("がき", "かき") {
+		t.Error("voiced marks should not be removed")
+	}
+	if NormalizeString("ｶ") != "カ" {
+		t.Error("query should be normalized to NFKC")
+	}
+	if _, changed := ComposeBytes([]byte("already composed")); changed {
+		t.Error("composed text should be unchanged")
+	}
+}
+
 func TestLongString(t *testing.T) {
 	bytes := make([]byte, math.MaxUint16*2)
 	for i := range bytes {
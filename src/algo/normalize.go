@@ -478,15 +478,8 @@ var normalized map[rune]rune = map[rune]rune{
 // NormalizeRunes normalizes latin script letters
 func NormalizeRunes(runes []rune) []rune {
 	ret := make([]rune, len(runes))
-	copy(ret, runes)
 	for idx, r := range runes {
-		if r < 0x00C0 || r > 0x2184 {
-			continue
-		}
-		n := normalized[r]
-		if n > 0 {
-			ret[idx] = normalized[r]
-		}
+		ret[idx] = normalizeRune(r)
 	}
 	return ret
 }
@@ -0,0 +1,37 @@
+package algo
+
+import (
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// RegexMatch returns the algorithm matching the text against the compiled
+// regular expression. The pattern given to the algorithm is ignored, and so
+// are the case sensitivity and the normalization, which are up to the flags
+// of the expression. The matched substring is scored like an exact match.
+func RegexMatch(rx *regexp.Regexp) Algo {
+	return func(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+		str := text.ToString()
+		var loc []int
+		if forward {
+			loc = rx.FindStringIndex(str)
+		} else if all := rx.FindAllStringIndex(str, -1); len(all) > 0 {
+			loc = all[len(all)-1]
+		}
+		if loc == nil {
+			return Result{-1, -1, 0}, nil
+		}
+
+		// Byte offsets to rune offsets
+		sidx := utf8.RuneCountInString(str[:loc[0]])
+		eidx := sidx + utf8.RuneCountInString(str[loc[0]:loc[1]])
+		runes := make([]rune, eidx-sidx)
+		for idx := range runes {
+			runes[idx] = foldRune(text.Get(sidx+idx), false, false)
+		}
+		score, pos := calculateScore(false, false, text, runes, sidx, eidx, withPos)
+		return Result{sidx, eidx, score}, pos
+	}
+}
@@ -0,0 +1,378 @@
+package algo
+
+import (
+	"unicode"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// typoPenalty returns the cost of a typo in the current scoring table
+func typoPenalty() int {
+	return int(scoring.Match + scoring.Boundary)
+}
+
+// TypoMatch returns the algorithm that finds the substring matching the
+// pattern with at most the given number of typos, each of which is either a
+// substitution of a character or a transposition of two adjacent characters.
+// Fewer typos are allowed for short patterns as they would match almost
+// anything; a pattern needs at least 2N+1 characters for N typos. The score of
+// the match is that of the exact match of the substring reduced for each typo.
+func TypoMatch(maxTypos int) Algo {
+	return func(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+		lenPattern := len(pattern)
+		allowed := util.Min(maxTypos, (lenPattern-1)/2)
+		if allowed <= 0 {
+			return ExactMatchNaive(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+		}
+		lenRunes := text.Length()
+		if lenRunes < lenPattern {
+			return Result{-1, -1, 0}, nil
+		}
+
+		_, runes := alloc32(0, slab, lenRunes)
+		for idx := range runes {
+			runes[idx] = foldRune(text.Get(idx), caseSensitive, normalize)
+		}
+		bestPos, bestTypos, bestBonus := -1, allowed+1, int16(-1)
+		for index := 0; index <= lenRunes-lenPattern; index++ {
+			sidx := index
+			if !forward {
+				sidx = lenRunes - lenPattern - index
+			}
+			typos := countTypos(runes[sidx:sidx+lenPattern], pattern, bestTypos)
+			if typos > bestTypos || typos > allowed {
+				continue
+			}
+			if bonus := bonusAt(text, sidx); typos < bestTypos || bonus > bestBonus {
+				bestPos, bestTypos, bestBonus = sidx, typos, bonus
+				if typos == 0 && bonus == scoring.Boundary {
+					break
+				}
+			}
+		}
+		if bestPos < 0 {
+			return Result{-1, -1, 0}, nil
+		}
+		sidx, eidx := bestPos, bestPos+lenPattern
+		// Score the substring as if it was typed correctly
+		score, _ := calculateScore(caseSensitive, normalize, text, runes[sidx:eidx], sidx, eidx, false)
+		return Result{sidx, eidx, score - bestTypos*typoPenalty()}, nil
+	}
+}
+
+// MaxFuzzyTypos is the maximum number of typos allowed by FuzzyTypoMatch.
+// The score matrix grows with each typo allowed.
+const MaxFuzzyTypos = 2
+
+// AllowedTypos returns the number of typos allowed in a pattern of the given
+// length. A pattern needs at least 2N+1 characters for N typos.
+func AllowedTypos(maxTypos int, lenPattern int) int {
+	return util.Max(0, util.Min(maxTypos, (lenPattern-1)/2))
+}
+
+// FuzzyTypoMatch returns the fuzzy matching algorithm that allows at most the
+// given number of typos in the pattern when it does not match as it is. A
+// typo is either an omission of a character of the pattern, which also
+// stands for a substitution as fuzzy matching can skip any character of the
+// text, or a transposition of two adjacent characters found next to each
+// other in the text. Each typo lowers the
+// score of the match by the same amount as in TypoMatch.
+func FuzzyTypoMatch(fuzzy Algo, maxTypos int) Algo {
+	if maxTypos <= 0 {
+		return fuzzy
+	}
+	return func(caseSensitive bool, normalize bool, forward bool, text *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+		result, pos := fuzzy(caseSensitive, normalize, forward, text, pattern, withPos, slab)
+		if result.Start >= 0 {
+			return result, pos
+		}
+		allowed := AllowedTypos(maxTypos, len(pattern))
+		if allowed <= 0 {
+			return result, pos
+		}
+		return fuzzyTypoMatch(allowed, caseSensitive, normalize, forward, text, pattern, withPos, slab)
+	}
+}
+
+// Directions of the cells of the score matrix of fuzzyTypoMatch
+const (
+	typoGap   int16 = iota // The character of the text is skipped
+	typoMatch              // The character of the pattern is matched
+	typoSkip               // The character of the pattern is omitted
+	typoSwap               // The character and the previous one of the pattern are transposed
+
+	typoDirection int16 = 3
+	typoStart     int16 = 4 // The characters of the pattern before the cell are all omitted
+)
+
+// fuzzyTypoMatch extends the score matrix of FuzzyMatchV2 with a layer for
+// each number of typos. A cell of a layer takes the score from the cell of
+// the previous layer for the previous character of the pattern when the
+// character is omitted, or from the cell of the previous layer two rows and
+// two columns before when the character is transposed with the previous one.
+// The penalties for the typos are applied to the scores of the last row of
+// each layer. Long items whose matrix does not fit in the slab are not
+// matched.
+func fuzzyTypoMatch(maxTypos int, caseSensitive bool, normalize bool, forward bool, input *util.Chars, pattern []rune, withPos bool, slab *util.Slab) (Result, *[]int) {
+	M := len(pattern)
+	N := input.Length()
+	size := (maxTypos + 1) * M * N
+	if N == 0 || slab != nil && 3*size+N > cap(slab.I16) {
+		return Result{-1, -1, 0}, nil
+	}
+
+	// The item cannot match if it lacks more characters of the pattern than
+	// the typos allowed
+	missing := 0
+	ascii := input.IsBytes() && isAscii(pattern)
+	for _, pchar := range pattern {
+		if ascii && trySkip(input, caseSensitive, byte(pchar), 0) < 0 {
+			if missing++; missing > maxTypos {
+				return Result{-1, -1, 0}, nil
+			}
+		}
+	}
+
+	offset16 := 0
+	offset16, B := alloc16(offset16, slab, N)
+	offset16, H := alloc16(offset16, slab, size)
+	offset16, C := alloc16(offset16, slab, size)
+	_, D := alloc16(offset16, slab, size)
+	offset32, T := alloc32(0, slab, N)
+	input.CopyRunes(T)
+	prevClass := charNonWord
+	for j, char := range T {
+		class := charClassOf(char)
+		B[j] = bonusFor(prevClass, class)
+		prevClass = class
+		T[j] = foldRune(char, caseSensitive, normalize)
+	}
+	if !ascii {
+		for _, pchar := range pattern {
+			found := false
+			for _, char := range T {
+				if char == pchar {
+					found = true
+					break
+				}
+			}
+			if !found {
+				if missing++; missing > maxTypos {
+					return Result{-1, -1, 0}, nil
+				}
+			}
+		}
+	}
+
+	// step returns the score and the length of the consecutive chunk of the
+	// match of the character at the column after the cell of the given score
+	// as in FuzzyMatchV2. gap is the score of the alternative of skipping the
+	// character.
+	step := func(diag int16, consecutive int16, j int, gap int16) (int16, int16) {
+		score := diag + scoring.Match
+		b := B[j]
+		consecutive++
+		// Break consecutive chunk
+		if b == scoring.Boundary {
+			consecutive = 1
+		} else if consecutive > 1 {
+			b = util.Max16(b, util.Max16(scoring.Consecutive, B[j-int(consecutive)+1]))
+		}
+		if score+b < gap {
+			return score + B[j], 0
+		}
+		return score + b, consecutive
+	}
+	first := func(j int) int16 {
+		return scoring.Match + B[j]*scoring.FirstCharMultiplier
+	}
+
+	// The first valid column of each row of the layers. The cells before it
+	// are not filled.
+	_, L := alloc32(offset32, slab, (maxTypos+1)*M)
+	gapStart, gapExtension := scoring.GapStart, scoring.GapExtension
+	prevFound := true
+	for i := 0; i < M; i++ {
+		found := false
+		for t := 0; t <= maxTypos; t++ {
+			row := (t*M + i) * N
+			Hrow, Crow, Drow := H[row:row+N], C[row:row+N], D[row:row+N]
+			// The rows of the cells to take the scores from, and the columns
+			// from which the scores are valid
+			var Hdiag, Cdiag, Hskip, Hswap, Cswap []int16
+			from, loDiag, loSkip, loSwap := N, N, N, N
+			if i == t {
+				from = 0
+			}
+			if i > 0 {
+				Hdiag, Cdiag = H[row-N:row], C[row-N:row]
+				loDiag = int(L[t*M+i-1])
+				from = util.Min(from, loDiag+1)
+			}
+			if t > 0 && i > 0 {
+				Hskip = H[row-M*N-N : row-M*N]
+				loSkip = int(L[(t-1)*M+i-1])
+				from = util.Min(from, loSkip)
+			}
+			if t > 0 && i > 1 {
+				Hswap, Cswap = H[row-M*N-2*N:row-M*N-N], C[row-M*N-2*N:row-M*N-N]
+				loSwap = int(L[(t-1)*M+i-2])
+				from = util.Min(from, loSwap+2)
+			}
+			pchar, ppchar := pattern[i], rune(-1)
+			if t > 0 && i > 0 {
+				ppchar = pattern[i-1]
+			}
+			lo := N
+			prevH, inGap := int16(-1), false
+			for j := from; j < N; j++ {
+				char := T[j]
+				h, c, d := int16(-1), int16(0), typoGap
+				if prevH >= 0 {
+					if inGap {
+						h = util.Max16(prevH+gapExtension, 0)
+					} else {
+						h = util.Max16(prevH+gapStart, 0)
+					}
+				}
+				if char == pchar {
+					if i == t {
+						if score := first(j); score >= h {
+							h, c, d = score, 1, typoMatch|typoStart
+						}
+					}
+					if j > loDiag && Hdiag[j-1] >= 0 {
+						if score, consecutive := step(Hdiag[j-1], Cdiag[j-1], j, h); score >= h {
+							h, c, d = score, consecutive, typoMatch
+						}
+					}
+				}
+				if j >= loSkip && Hskip[j] > h {
+					h, c, d = Hskip[j], 0, typoSkip
+				}
+				if char == ppchar && j > 0 && T[j-1] == pchar && pchar != ppchar {
+					score, consecutive, start := int16(-1), int16(0), false
+					if i == t {
+						score, consecutive, start = first(j-1), 1, true
+					}
+					if j-2 >= loSwap && Hswap[j-2] >= 0 {
+						if s, cons := step(Hswap[j-2], Cswap[j-2], j-1, -1); s >= score {
+							score, consecutive, start = s, cons, false
+						}
+					}
+					if score >= 0 {
+						if score, consecutive = step(score, consecutive, j, h); score > h {
+							h, c, d = score, consecutive, typoSwap
+							if start {
+								d |= typoStart
+							}
+						}
+					}
+				}
+				if h >= 0 && lo == N {
+					lo = j
+				}
+				Hrow[j], Crow[j], Drow[j] = h, c, d
+				prevH, inGap = h, d == typoGap
+			}
+			L[t*M+i] = int32(lo)
+			found = found || lo < N
+		}
+		// The following rows take the scores from the last two rows unless
+		// they start the match
+		if !found && !prevFound && i >= maxTypos {
+			return Result{-1, -1, 0}, nil
+		}
+		prevFound = found
+	}
+
+	// The last row of each layer
+	bestScore, bestTypos, bestPos := 0, -1, -1
+	for t := 0; t <= maxTypos; t++ {
+		row := (t*M + M - 1) * N
+		for j := int(L[t*M+M-1]); j < N; j++ {
+			if H[row+j] < 0 {
+				continue
+			}
+			score := int(H[row+j]) - t*typoPenalty()
+			if bestTypos < 0 || score > bestScore || score == bestScore && !forward && j > bestPos {
+				bestScore, bestTypos, bestPos = score, t, j
+			}
+		}
+	}
+	if bestTypos < 0 {
+		return Result{-1, -1, 0}, nil
+	}
+
+	// Backtrace to find the range and the positions of the matched characters
+	pos := posArray(withPos, M)
+	t, i, j := bestTypos, M-1, bestPos
+	sidx, eidx := -1, -1
+	matched := func(j int) {
+		if eidx < 0 {
+			eidx = j + 1
+		}
+		sidx = j
+		if withPos {
+			*pos = append(*pos, j)
+		}
+	}
+	for {
+		d := D[(t*M+i)*N+j]
+		switch d & typoDirection {
+		case typoGap:
+			j--
+			continue
+		case typoSkip:
+			t--
+			i--
+			continue
+		case typoMatch:
+			matched(j)
+			i--
+			j--
+		case typoSwap:
+			matched(j)
+			matched(j - 1)
+			t--
+			i -= 2
+			j -= 2
+		}
+		if d&typoStart != 0 {
+			break
+		}
+	}
+	return Result{sidx, eidx, bestScore}, pos
+}
+
+// foldRune converts the character to be compared with the pattern
+func foldRune(char rune, caseSensitive bool, normalize bool) rune {
+	if !caseSensitive {
+		if char >= 'A' && char <= 'Z' {
+			char += 32
+		} else if char > unicode.MaxASCII {
+			char = unicode.To(unicode.LowerCase, char)
+		}
+	}
+	if normalize {
+		char = normalizeRune(char)
+	}
+	return char
+}
+
+// countTypos counts the typos in the text of the same length as the pattern.
+// It stops counting when the count exceeds the limit.
+func countTypos(text []rune, pattern []rune, limit int) int {
+	typos := 0
+	for idx := 0; idx < len(pattern) && typos <= limit; idx++ {
+		if text[idx] == pattern[idx] {
+			continue
+		}
+		typos++
+		if idx+1 < len(pattern) && text[idx] == pattern[idx+1] && text[idx+1] == pattern[idx] {
+			// Transposition
+			idx++
+		}
+	}
+	return typos
+}
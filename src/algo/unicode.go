@@ -0,0 +1,58 @@
+package algo
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The characters folded to their compatibility equivalents when the
+// normalization is extended by SetUnicodeNormalization
+var compatFolded map[rune]rune
+
+// The blocks of the characters that can be folded, such as the full-width and
+// half-width forms, the enclosed alphanumerics, and the mathematical letters
+var compatBlocks = [][2]rune{{0x00A0, 0xFFEF}, {0x1D400, 0x1D7FF}, {0x1F100, 0x1F2FF}}
+
+// SetUnicodeNormalization extends the normalization of latin script letters
+// to NFKC. The characters whose compatibility decompositions are single
+// characters are folded to them. Like Init, it should be called before the
+// matching starts.
+func SetUnicodeNormalization(enabled bool) {
+	if !enabled {
+		compatFolded = nil
+		return
+	}
+	compatFolded = make(map[rune]rune)
+	for _, block := range compatBlocks {
+		for r := block[0]; r <= block[1]; r++ {
+			str := string(r)
+			if !utf8.ValidRune(r) || norm.NFKC.IsNormalString(str) {
+				continue
+			}
+			folded := norm.NFKC.String(str)
+			if f, size := utf8.DecodeRuneInString(folded); size == len(folded) && f != r {
+				compatFolded[r] = f
+			}
+		}
+	}
+}
+
+// NormalizeString converts the string to NFKC if the normalization is
+// extended by SetUnicodeNormalization
+func NormalizeString(str string) string {
+	if compatFolded == nil {
+		return str
+	}
+	return norm.NFKC.String(str)
+}
+
+// ComposeBytes converts the text to NFC if the normalization is extended by
+// SetUnicodeNormalization, so that the decomposed sequences of the characters
+// match the composed ones. It returns false if the text is unchanged.
+func ComposeBytes(text []byte) ([]byte, bool) {
+	if compatFolded == nil || norm.NFC.IsNormal(text) {
+		return text, false
+	}
+	return norm.NFC.Bytes(text), true
+}
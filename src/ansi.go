@@ -20,17 +20,24 @@ type ansiState struct {
 	bg   tui.Color
 	attr tui.Attr
 	lbg  tui.Color
+	url  *url
+}
+
+// url is the target of an OSC 8 hyperlink
+type url struct {
+	uri    string
+	params string
 }
 
 func (s *ansiState) colored() bool {
-	return s.fg != -1 || s.bg != -1 || s.attr > 0 || s.lbg >= 0
+	return s.fg != -1 || s.bg != -1 || s.attr > 0 || s.lbg >= 0 || s.url != nil
 }
 
 func (s *ansiState) equals(t *ansiState) bool {
 	if t == nil {
 		return !s.colored()
 	}
-	return s.fg == t.fg && s.bg == t.bg && s.attr == t.attr && s.lbg == t.lbg
+	return s.fg == t.fg && s.bg == t.bg && s.attr == t.attr && s.lbg == t.lbg && s.url == t.url
 }
 
 func (s *ansiState) ToString() string {
@@ -57,9 +64,16 @@ func (s *ansiState) ToString() string {
 	if s.attr&tui.Reverse > 0 {
 		ret += "7;"
 	}
+	if s.attr&tui.StrikeThrough > 0 {
+		ret += "9;"
+	}
 	ret += toAnsiString(s.fg, 30) + toAnsiString(s.bg, 40)
 
-	return "\x1b[" + strings.TrimSuffix(ret, ";") + "m"
+	ret = "\x1b[" + strings.TrimSuffix(ret, ";") + "m"
+	if s.url != nil {
+		ret = "\x1b]8;" + s.url.params + ";" + s.url.uri + "\x1b\\" + ret
+	}
+	return ret
 }
 
 func toAnsiString(color tui.Color, offset int) string {
@@ -84,6 +98,18 @@ func toAnsiString(color tui.Color, offset int) string {
 
 var ansiRegex *regexp.Regexp
 
+// OSC Ps ; Pt BEL/ST
+var oscRegex = regexp.MustCompile("\x1b]([0-9]+);([[:print:]]*)(?:\x1b\\\\|\x07)")
+
+// The OSC sequences in the preview output that are passed to the terminal;
+// the current directory (7) and the notifications (9 and 777)
+var passedOSCs = map[string]bool{"7": true, "9": true, "777": true}
+
+// The OSC sequences passed only when enabled by --preview-osc as the preview
+// command should not be able to overwrite the clipboard (52) or run the
+// custom commands of iTerm2 (1337) unless it is trusted
+var optionalOSCs = map[string]bool{"52": true, "1337": true}
+
 func init() {
 	/*
 		References:
@@ -95,7 +121,7 @@ func init() {
 	*/
 	// The following regular expression will include not all but most of the
 	// frequently used ANSI sequences
-	ansiRegex = regexp.MustCompile("(?:\x1b[\\[()][0-9;]*[a-zA-Z@]|\x1b][0-9];[[:print:]]+(?:\x1b\\\\|\x07)|\x1b.|[\x0e\x0f]|.\x08)")
+	ansiRegex = regexp.MustCompile("(?:\x1b[\\[()][0-9;]*[a-zA-Z@]|\x1b][0-9]+;[[:print:]]+(?:\x1b\\\\|\x07)|\x1b.|[\x0e\x0f]|.\x08)")
 }
 
 func findAnsiStart(str string) int {
@@ -196,12 +222,14 @@ func interpretCode(ansiCode string, prevState *ansiState) *ansiState {
 	// State
 	var state *ansiState
 	if prevState == nil {
-		state = &ansiState{-1, -1, 0, -1}
+		state = &ansiState{-1, -1, 0, -1, nil}
 	} else {
-		state = &ansiState{prevState.fg, prevState.bg, prevState.attr, prevState.lbg}
+		state = &ansiState{prevState.fg, prevState.bg, prevState.attr, prevState.lbg, prevState.url}
 	}
 	if ansiCode[0] != '\x1b' || ansiCode[1] != '[' || ansiCode[len(ansiCode)-1] != 'm' {
-		if strings.HasSuffix(ansiCode, "0K") && prevState != nil {
+		if strings.HasPrefix(ansiCode, "\x1b]8;") {
+			state.url = interpretHyperlink(ansiCode)
+		} else if strings.HasSuffix(ansiCode, "0K") && prevState != nil {
 			state.lbg = prevState.bg
 		}
 		return state
@@ -248,10 +276,14 @@ func interpretCode(ansiCode string, prevState *ansiState) *ansiState {
 					state.attr = state.attr | tui.Blink
 				case 7:
 					state.attr = state.attr | tui.Reverse
+				case 9:
+					state.attr = state.attr | tui.StrikeThrough
 				case 23: // tput rmso
 					state.attr = state.attr &^ tui.Italic
 				case 24: // tput rmul
 					state.attr = state.attr &^ tui.Underline
+				case 29:
+					state.attr = state.attr &^ tui.StrikeThrough
 				case 0:
 					init()
 				default:
@@ -294,3 +326,30 @@ func interpretCode(ansiCode string, prevState *ansiState) *ansiState {
 	}
 	return state
 }
+
+// interpretHyperlink parses OSC 8 sequence (ESC ] 8 ; params ; URI ST). It
+// returns nil if the URI is empty, which marks the end of the hyperlink.
+func interpretHyperlink(ansiCode string) *url {
+	ansiCode = strings.TrimPrefix(ansiCode, "\x1b]8;")
+	ansiCode = strings.TrimSuffix(strings.TrimSuffix(ansiCode, "\x07"), "\x1b\\")
+	tokens := strings.SplitN(ansiCode, ";", 2)
+	if len(tokens) < 2 || len(tokens[1]) == 0 {
+		return nil
+	}
+	return &url{uri: tokens[1], params: tokens[0]}
+}
+
+// passedSequences returns the OSC sequences in the line to be passed to the
+// terminal, including the optional ones enabled. The queries are excluded as
+// their responses would be read as the user input, and so are the inline
+// images of iTerm2 displayed by fzf.
+func passedSequences(line string, enabled map[string]bool) []string {
+	sequences := []string{}
+	for _, match := range oscRegex.FindAllStringSubmatch(line, -1) {
+		if !passedOSCs[match[1]] && !enabled[match[1]] || strings.HasSuffix(match[2], "?") || strings.HasPrefix(match[2], "File=") {
+			continue
+		}
+		sequences = append(sequences, match[0])
+	}
+	return sequences
+}
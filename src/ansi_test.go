@@ -185,3 +185,47 @@ func TestAnsiCodeStringConversion(t *testing.T) {
 		&ansiState{attr: tui.Dim | tui.Italic, fg: 1, bg: 1},
 		"\x1b[2;3;7;38;2;10;20;30;48;5;100m")
 }
+
+func TestExtractHyperlink(t *testing.T) {
+	src := "\x1b]8;id=1;file:///tmp/foo\x1b\\foo\x1b]8;;\x1b\\ \x1b[31m\x1b]8;;https://junegunn.kr\x07bar\x1b]8;;\x07\x1b[m"
+	output, offsets, state := extractColor(src, nil, nil)
+	if output != "foo bar" || state != nil {
+		t.Errorf("unexpected output: %q, %v", output, state)
+	}
+	if offsets == nil || len(*offsets) != 4 {
+		t.Fatalf("unexpected offsets: %v", offsets)
+	}
+	first := (*offsets)[0]
+	if first.offset != [2]int32{0, 3} || first.color.url == nil ||
+		first.color.url.uri != "file:///tmp/foo" || first.color.url.params != "id=1" {
+		t.Errorf("unexpected offset: %v", first)
+	}
+	second := (*offsets)[1]
+	if second.offset != [2]int32{4, 4} || second.color.url != nil || second.color.fg != 1 {
+		t.Errorf("unexpected offset: %v", second)
+	}
+	third := (*offsets)[2]
+	if third.offset != [2]int32{4, 7} || third.color.url == nil ||
+		third.color.url.uri != "https://junegunn.kr" || third.color.fg != 1 {
+		t.Errorf("unexpected offset: %v", third)
+	}
+	if str := third.color.ToString(); str != "\x1b]8;;https://junegunn.kr\x1b\\\x1b[31;49m" {
+		t.Errorf("unexpected string: %q", str)
+	}
+}
+
+func TestPassedSequences(t *testing.T) {
+	src := "\x1b]52;c;Zm9v\x07foo\x1b]52;c;?\x07\x1b]0;title\x07\x1b]1337;File=inline=1:AAAA\x07\x1b]777;notify;fzf;done\x1b\\"
+	if output, _, _ := extractColor(src, nil, nil); output != "foo" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	sequences := passedSequences(src, nil)
+	if len(sequences) != 1 || sequences[0] != "\x1b]777;notify;fzf;done\x1b\\" {
+		t.Errorf("unexpected sequences: %q", sequences)
+	}
+	// The clipboard is only set when enabled
+	sequences = passedSequences(src, map[string]bool{"52": true})
+	if len(sequences) != 2 || sequences[0] != "\x1b]52;c;Zm9v\x07" || sequences[1] != "\x1b]777;notify;fzf;done\x1b\\" {
+		t.Errorf("unexpected sequences: %q", sequences)
+	}
+}
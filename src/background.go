@@ -0,0 +1,62 @@
+package fzf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// backgroundResult is the output of --background-command for the size of the
+// screen
+type backgroundResult struct {
+	size   [2]int
+	output string
+}
+
+// updateBackground executes --background-command in the background when the
+// size of the screen has changed. The command still running for the previous
+// size is killed, and the output is handed to the renderer once the command
+// is finished.
+func (t *Terminal) updateBackground(width int, height int) {
+	if len(t.bgCommand) == 0 {
+		return
+	}
+	size := [2]int{width, height}
+	if t.bgSize == size {
+		return
+	}
+	t.bgSize = size
+	t.killBackground()
+	var out bytes.Buffer
+	cmd := util.ExecCommand(t.bgCommand, true)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("LINES=%d", height),
+		fmt.Sprintf("COLUMNS=%d", width))
+	cmd.Stdout = &out
+	if cmd.Start() != nil {
+		return
+	}
+	t.bgCmd = cmd
+	go func() {
+		cmd.Wait()
+		t.mutex.Lock()
+		current := t.bgCmd == cmd
+		if current {
+			t.bgCmd = nil
+		}
+		t.mutex.Unlock()
+		if current {
+			t.reqBox.Set(reqBackground, backgroundResult{size, out.String()})
+		}
+	}()
+}
+
+// killBackground kills --background-command if it is still running
+func (t *Terminal) killBackground() {
+	if t.bgCmd != nil {
+		util.KillCommand(t.bgCmd)
+		t.bgCmd = nil
+	}
+}
@@ -0,0 +1,118 @@
+package fzf
+
+import (
+	"hash"
+	"hash/fnv"
+	"sync"
+)
+
+// listChecksum holds back the output of reload until it turns out to be
+// different from the current list, so that reloading the identical output
+// does not rebuild the list. The output is compared with the list by the
+// checksums of every chunkSize lines and the number of the lines, and it is
+// known to be different as soon as a chunk of the lines differs or it has
+// more lines than the list.
+type listChecksum struct {
+	mutex    sync.Mutex
+	enabled  bool
+	push     func([]byte) bool
+	replace  func()
+	sum      hash.Hash64 // Checksum of the lines of the last chunk
+	sums     []uint64    // Checksums of the complete chunks
+	lines    int
+	listSum  uint64
+	listSums []uint64
+	listLen  int
+	holding  bool
+	pending  [][]byte
+	replaced bool
+}
+
+func newListChecksum(enabled bool, push func([]byte) bool, replace func()) *listChecksum {
+	return &listChecksum{enabled: enabled, push: push, replace: replace, sum: fnv.New64a()}
+}
+
+// hold starts holding back the lines of the new output. The list is replaced
+// right away if the comparison is disabled.
+func (c *listChecksum) hold() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.enabled {
+		c.replace()
+		c.replaced = true
+		return
+	}
+	// The list is unchanged if the previous reload was interrupted while
+	// being held back
+	if !c.holding {
+		c.listSum, c.listSums, c.listLen = c.sum.Sum64(), c.sums, c.lines
+	}
+	c.sum.Reset()
+	c.sums = nil
+	c.lines = 0
+	c.pending = nil
+	c.holding = true
+}
+
+// add takes the line read and pushes it to the list unless it is held back.
+// It returns true if an item is added to the list.
+func (c *listChecksum) add(data []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sum.Write(data)
+	c.sum.Write([]byte{0})
+	c.lines++
+	complete := c.lines%chunkSize == 0
+	if complete {
+		c.sums = append(c.sums, c.sum.Sum64())
+		c.sum.Reset()
+	}
+	if !c.holding {
+		return c.push(data)
+	}
+	c.pending = append(c.pending, data)
+	if c.lines <= c.listLen && (!complete || c.sums[len(c.sums)-1] == c.listSums[len(c.sums)-1]) {
+		return false
+	}
+	return c.flush()
+}
+
+// flush replaces the list with the lines held back
+func (c *listChecksum) flush() bool {
+	c.replace()
+	c.replaced = true
+	added := false
+	for _, data := range c.pending {
+		added = c.push(data) || added
+	}
+	c.pending = nil
+	c.holding = false
+	return added
+}
+
+// finish is called when the output is completely read. It returns true if
+// the output of reload is identical to the list, or replaces the list with
+// the lines held back otherwise.
+func (c *listChecksum) finish(success bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.holding {
+		return false
+	}
+	if success && c.lines == c.listLen && c.sum.Sum64() == c.listSum {
+		c.pending = nil
+		c.holding = false
+		return true
+	}
+	c.flush()
+	return false
+}
+
+// takeReplaced returns true once after the list is replaced
+func (c *listChecksum) takeReplaced() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	replaced := c.replaced
+	c.replaced = false
+	return replaced
+}
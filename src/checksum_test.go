@@ -0,0 +1,109 @@
+package fzf
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestListChecksum(t *testing.T) {
+	list := []string{}
+	checksum := newListChecksum(true, func(data []byte) bool {
+		list = append(list, string(data))
+		return true
+	}, func() {
+		list = []string{}
+	})
+	read := func(lines ...string) {
+		for _, line := range lines {
+			checksum.add([]byte(line))
+		}
+	}
+	read("foo", "bar")
+	if checksum.finish(true) || checksum.takeReplaced() {
+		t.Error("initial read should not be compared")
+	}
+
+	// Identical output
+	checksum.hold()
+	read("foo", "bar")
+	if !checksum.finish(true) || checksum.takeReplaced() || !reflect.DeepEqual(list, []string{"foo", "bar"}) {
+		t.Errorf("identical output should be discarded: %v", list)
+	}
+
+	// Different lines of the same length
+	checksum.hold()
+	read("foo", "baz")
+	if !reflect.DeepEqual(list, []string{"foo", "bar"}) {
+		t.Errorf("output should be held back: %v", list)
+	}
+	if checksum.finish(true) || !checksum.takeReplaced() || !reflect.DeepEqual(list, []string{"foo", "baz"}) {
+		t.Errorf("list should be replaced: %v", list)
+	}
+	if checksum.takeReplaced() {
+		t.Error("replaced should be taken only once")
+	}
+
+	// More lines are pushed as they are read
+	checksum.hold()
+	read("foo", "baz", "qux")
+	if !checksum.takeReplaced() || !reflect.DeepEqual(list, []string{"foo", "baz", "qux"}) {
+		t.Errorf("list should be replaced: %v", list)
+	}
+	read("quux")
+	if checksum.finish(true) || len(list) != 4 {
+		t.Errorf("unexpected list: %v", list)
+	}
+
+	// Interrupted reload is compared with the list
+	checksum.hold()
+	read("foo")
+	checksum.hold()
+	read("foo", "baz", "qux", "quux")
+	if !checksum.finish(true) {
+		t.Errorf("identical output should be discarded: %v", list)
+	}
+
+	// Failed command
+	checksum.hold()
+	read("foo", "baz", "qux", "quux")
+	if checksum.finish(false) || !checksum.takeReplaced() {
+		t.Error("list should be replaced on failure")
+	}
+
+	// The output is pushed as soon as a chunk of the lines differs
+	many := func(prefix string) []string {
+		lines := make([]string, chunkSize*2+1)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%s%d", prefix, i)
+		}
+		return lines
+	}
+	checksum.hold()
+	read(many("a")...)
+	checksum.finish(true)
+	checksum.takeReplaced()
+	checksum.hold()
+	read(many("a")[:chunkSize]...)
+	if checksum.takeReplaced() {
+		t.Error("identical chunk should be held back")
+	}
+	checksum.hold()
+	lines := many("a")
+	lines[chunkSize-1] = "b"
+	read(lines[:chunkSize]...)
+	if !checksum.takeReplaced() || len(list) != chunkSize || list[chunkSize-1] != "b" {
+		t.Errorf("list should be replaced at the first different chunk: %d", len(list))
+	}
+	read(lines[chunkSize:]...)
+	if checksum.finish(true) || len(list) != len(lines) {
+		t.Errorf("unexpected list: %d", len(list))
+	}
+
+	// Disabled
+	disabled := newListChecksum(false, func(data []byte) bool { return true }, func() {})
+	disabled.hold()
+	if disabled.finish(true) || !disabled.takeReplaced() {
+		t.Error("list should be replaced right away")
+	}
+}
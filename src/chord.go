@@ -0,0 +1,175 @@
+package fzf
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/junegunn/fzf/src/tui"
+	"github.com/junegunn/fzf/src/util"
+)
+
+// keyChord is the keys that can follow the keys of a chord pressed so far
+type keyChord struct {
+	name   string                 // The keys pressed so far as in ctrl-x>a
+	keymap map[tui.Event][]action // Actions bound to the following keys
+	names  map[tui.Event]string   // Names of the following keys
+	specs  map[tui.Event]string   // Definitions of the actions as given
+}
+
+func newKeyChord(name string) *keyChord {
+	return &keyChord{
+		name:   name,
+		keymap: make(map[tui.Event][]action),
+		names:  make(map[tui.Event]string),
+		specs:  make(map[tui.Event]string)}
+}
+
+// rename renames the chord and the chords nested in it after the first key
+// of the chord
+func (c *keyChord) rename(name string) {
+	c.name = name
+	for key, actions := range c.keymap {
+		if len(actions) == 1 && actions[0].t == actChord {
+			actions[0].k.rename(name + ">" + c.names[key])
+		}
+	}
+}
+
+// merge binds the keys of the other chord in this chord, replacing the
+// existing bindings of the same keys
+func (c *keyChord) merge(other *keyChord) {
+	for key, actions := range other.keymap {
+		c.keymap[key] = actions
+		c.names[key] = other.names[key]
+		if spec, prs := other.specs[key]; prs {
+			c.specs[key] = spec
+		} else {
+			delete(c.specs, key)
+		}
+	}
+	c.rename(c.name)
+}
+
+// hints returns the keys of the chord with the descriptions of their actions
+// sorted by the names of the keys. The definitions of the actions are shown
+// unless described, and the following keys for the nested chords.
+func (c *keyChord) hints() []bindingHint {
+	hints := []bindingHint{}
+	for key, actions := range c.keymap {
+		desc := actionDescription(actions)
+		if len(desc) == 0 && len(actions) == 1 && actions[0].t == actChord {
+			names := []string{}
+			for _, name := range actions[0].k.names {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			desc = "+" + strings.Join(names, " ")
+		} else if len(desc) == 0 {
+			desc = c.specs[key]
+		}
+		hints = append(hints, bindingHint{key: c.names[key], desc: desc})
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].key < hints[j].key
+	})
+	return hints
+}
+
+// bindings returns the bindings of the keys following the keys of the chord
+// including the ones of the nested chords, named after the whole sequences
+func (c *keyChord) bindings() []bindingHint {
+	hints := []bindingHint{}
+	for key, actions := range c.keymap {
+		if len(actions) == 1 && actions[0].t == actChord {
+			hints = append(hints, actions[0].k.bindings()...)
+			continue
+		}
+		desc := actionDescription(actions)
+		if len(desc) == 0 {
+			desc = c.specs[key]
+		}
+		hints = append(hints, bindingHint{key: c.name + ">" + c.names[key], desc: desc})
+	}
+	return hints
+}
+
+// printChordPopup shows the keys that can follow the pending chord in a popup
+// at the corner of the list opposite to the prompt
+func (t *Terminal) printChordPopup() {
+	t.printPopup(" "+t.chord.name+"> ", t.chord.hints(), -1)
+}
+
+// printPopup shows the keys with the descriptions in a popup with the label
+// at the corner of the list opposite to the prompt. The popup with another
+// label is closed first. The row of the current hint is highlighted and kept
+// in the popup unless current is negative.
+func (t *Terminal) printPopup(label string, hints []bindingHint, current int) {
+	if t.popup != nil && t.popupLabel != label {
+		// Restore the cells under the previous popup
+		t.closePopup()
+	}
+	t.popupLabel = label
+
+	keyWidth, descWidth := 0, 0
+	for _, hint := range hints {
+		keyWidth = util.Max(keyWidth, t.displayWidth([]rune(hint.key)))
+		descWidth = util.Max(descWidth, t.displayWidth([]rune(hint.desc)))
+	}
+	width := util.Min(util.Max(keyWidth+descWidth+6, t.displayWidth([]rune(label))+4), t.window.Width())
+	height := util.Min(len(hints)+2, t.window.Height())
+	if width < 5 || height < 3 {
+		return
+	}
+	top := t.window.Top()
+	if t.layout == layoutReverse {
+		top += t.window.Height() - height
+	}
+	left := t.window.Left() + t.window.Width() - width
+
+	borderStyle := t.popupBorderStyle()
+	t.popupBorder = t.tui.NewWindow(top, left, width, height, false, borderStyle)
+	if label, _ := t.trimRight([]rune(label), width-4); len(label) > 0 {
+		t.popupBorder.SetBorderLabels([]tui.BorderLabel{{Text: string(label), X: 2}})
+	}
+	noBorder := tui.MakeBorderStyle(tui.BorderNone, t.unicode)
+	t.popup = t.tui.NewWindow(top+1, left+2, width-4, height-2, false, noBorder)
+	offset := util.Max(0, current-(height-3))
+	for i, hint := range hints[offset : offset+height-2] {
+		keyColor, descColor := tui.ColPrompt, tui.ColHeader
+		if i+offset == current {
+			keyColor, descColor = tui.ColCurrentCursor, tui.ColCurrent
+			t.popup.Move(i, 0)
+			t.popup.CPrint(tui.ColCurrent, strings.Repeat(" ", t.popup.Width()))
+		}
+		t.popup.Move(i, 0)
+		key, _ := t.trimRight([]rune(hint.key), t.popup.Width())
+		t.popup.CPrint(keyColor, string(key))
+		if rest := t.popup.Width() - keyWidth - 2; rest > 0 {
+			t.popup.Move(i, keyWidth+2)
+			desc, _ := t.trimRight([]rune(hint.desc), rest)
+			t.popup.CPrint(descColor, string(desc))
+		}
+	}
+}
+
+// popupBorderStyle returns the style of the border of the popup. Unlike the
+// other borders, the glyphs are not probed as the terminal is not to be
+// queried while reading the keys, but the results of the previous probes are
+// respected.
+func (t *Terminal) popupBorderStyle() tui.BorderStyle {
+	if t.unicode {
+		for _, shape := range []tui.BorderShape{tui.BorderRounded, tui.BorderSharp} {
+			if supported, found := t.glyphs[shape.Sides()[0]]; supported || !found {
+				return tui.MakeBorderStyle(shape, true)
+			}
+		}
+	}
+	return tui.MakeBorderStyle(tui.BorderSharp, false)
+}
+
+// closePopup removes the popup and prints the windows again to restore the
+// cells under it
+func (t *Terminal) closePopup() {
+	t.popup, t.popupBorder, t.popupLabel = nil, nil, ""
+	t.printAll()
+}
@@ -4,8 +4,10 @@ import "sync"
 
 // Chunk is a list of Items whose size has the upper limit of chunkSize
 type Chunk struct {
-	items [chunkSize]Item
-	count int
+	items    [chunkSize]Item
+	count    int
+	masks    *[chunkSize]uint64 // Bitmasks of the characters for the prefilter
+	trigrams *trigramIndex      // Trigram index of the items of the full chunk
 }
 
 // ItemBuilder is a closure type that builds Item object from byte array
@@ -71,6 +73,41 @@ func (cl *ChunkList) Clear() {
 	cl.mutex.Unlock()
 }
 
+// Retain removes the items for which keep returns false, and returns true if
+// any item is removed. The chunks from the one of the first removed item are
+// replaced with the new ones, so that the existing snapshots are not affected.
+func (cl *ChunkList) Retain(keep func(*Item) bool) bool {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	var chunks []*Chunk
+	removed := false
+	for idx, chunk := range cl.chunks {
+		for i := 0; i < chunk.count; i++ {
+			item := &chunk.items[i]
+			if keep(item) {
+				if removed {
+					if last := chunks[len(chunks)-1]; last.IsFull() {
+						chunks = append(chunks, &Chunk{})
+					}
+					last := chunks[len(chunks)-1]
+					last.items[last.count] = *item
+					last.count++
+				}
+			} else if !removed {
+				removed = true
+				partial := &Chunk{count: i}
+				copy(partial.items[:i], chunk.items[:i])
+				chunks = append(append([]*Chunk{}, cl.chunks[:idx]...), partial)
+			}
+		}
+	}
+	if removed {
+		cl.chunks = chunks
+	}
+	return removed
+}
+
 // Snapshot returns immutable snapshot of the ChunkList
 func (cl *ChunkList) Snapshot() ([]*Chunk, int) {
 	cl.mutex.Lock()
@@ -8,9 +8,6 @@ import (
 )
 
 func TestChunkList(t *testing.T) {
-	// FIXME global
-	sortCriteria = []criterion{byScore, byLength}
-
 	cl := NewChunkList(func(item *Item, s []byte) bool {
 		item.text = util.ToChars(s)
 		return true
@@ -78,3 +75,48 @@ func TestChunkList(t *testing.T) {
 		t.Error("Unexpected number of items:", lastChunkCount)
 	}
 }
+
+func TestChunkListRetain(t *testing.T) {
+	var index int32
+	cl := NewChunkList(func(item *Item, s []byte) bool {
+		item.text = util.ToChars(s)
+		item.text.Index = index
+		index++
+		return true
+	})
+	for i := 0; i < chunkSize*2+10; i++ {
+		cl.Push([]byte(fmt.Sprint(i)))
+	}
+	before, _ := cl.Snapshot()
+	if cl.Retain(func(*Item) bool { return true }) {
+		t.Error("Nothing should be removed")
+	}
+
+	// Remove the even items from the second chunk
+	removed := func(item *Item) bool {
+		index := int(item.Index())
+		return index >= chunkSize && index < chunkSize*2 && index%2 == 0
+	}
+	if !cl.Retain(func(item *Item) bool { return !removed(item) }) {
+		t.Error("Items should be removed")
+	}
+	snapshot, count := cl.Snapshot()
+	if count != chunkSize*2+10-chunkSize/2 || snapshot[0] != before[0] {
+		t.Errorf("Unexpected snapshot: %d", count)
+	}
+	var prev int32 = -1
+	for _, chunk := range snapshot {
+		for i := 0; i < chunk.count; i++ {
+			item := &chunk.items[i]
+			if removed(item) || item.Index() <= prev || item.text.ToString() != fmt.Sprint(item.Index()) {
+				t.Errorf("Unexpected item: %d", item.Index())
+			}
+			prev = item.Index()
+		}
+	}
+
+	// The previous snapshot is not affected
+	if CountItems(before) != chunkSize*2+10 || int(before[1].items[0].Index()) != chunkSize {
+		t.Error("The previous snapshot should not change")
+	}
+}
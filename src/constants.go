@@ -19,6 +19,12 @@ const (
 	readerPollIntervalStep = 5 * time.Millisecond
 	readerPollIntervalMax  = 50 * time.Millisecond
 
+	// Source URL
+	sourceURLRetries    = 5
+	sourceURLBackoffMin = 500 * time.Millisecond
+	sourceURLBackoffMax = 8 * time.Second
+	sourceURLTimeout    = 30 * time.Second
+
 	// Terminal
 	initialDelay      = 20 * time.Millisecond
 	initialDelayTac   = 100 * time.Millisecond
@@ -28,11 +34,33 @@ const (
 	previewDelayed    = 500 * time.Millisecond
 	maxPatternLength  = 300
 	maxMulti          = math.MaxInt32
+	hscrollStep       = 4 // Columns scrolled by each horizontal wheel event
+	hintDuration      = 3 * time.Second
+	statusDuration    = 3 * time.Second
+	chordInterval     = time.Second // Default interval between the keys of a chord
+	maxNumArg         = 10000       // Upper bound of the numeric argument
+	maxRepeat         = 10000       // Upper bound of the repetitions of an action
+
+	// Render
+	renderLatencyTarget = 50 * time.Millisecond // Upper bound of the delay of the refresh
+	renderLatencyWeight = 8                     // Inverse of the weight of a refresh in the average
 
 	// Matcher
 	numPartitionsMultiplier = 8
 	maxPartitions           = 32
 	progressMinDuration     = 200 * time.Millisecond
+	scanPollInterval        = 5 * time.Millisecond
+
+	// The prefilter is used when the query has this many distinct characters
+	prefilterMinChars = 3
+
+	// The trigram index is used when there are this many items
+	defaultTrigramMin int = 1000000
+
+	// Sort the matches on disk when there are more than this many of them
+	defaultDiskSort    int = 10000000
+	diskSortRunMin     int = 1000
+	diskSortBufferSize int = 64 * 1024
 
 	// Capacity of each chunk
 	chunkSize int = 100
@@ -48,7 +76,20 @@ const (
 	mergerCacheMax int = 100000
 
 	// History
-	defaultHistoryMax int = 1000
+	defaultHistoryMax   int = 1000
+	historyPollInterval     = time.Second // Without inotify
+
+	// Rank log
+	defaultRankLogSize int = 10
+	rankLogSearchMax   int = 10000
+
+	// Number of the items accept-all action accepts without confirmation
+	defaultConfirmAll int = 1000
+
+	// Width of the characters to be measured on the terminal with the samples
+	widthAuto       int    = -1
+	ambiguousSample string = "→"
+	emojiSample     string = "😀"
 
 	// Jump labels
 	defaultJumpLabels string = "asdfghjklqwertyuiopzxcvbnm1234567890ASDFGHJKLQWERTYUIOPZXCVBNM`~;:,<.>/?'\"!@#$%^&*()[{]}-_=+"
@@ -72,13 +113,25 @@ const (
 	EvtSearchProgress
 	EvtSearchFin
 	EvtHeader
+	EvtReadResume
+	EvtReadSource
 	EvtReady
+	EvtComplete
+	EvtItemExpire
+	EvtQuit
 )
 
 const (
+	exitBecome    = -2 // fzf is replaced by a command
 	exitCancel    = -1
 	exitOk        = 0
 	exitNoMatch   = 1
 	exitError     = 2
 	exitInterrupt = 130
 )
+
+// Exit status of the shell for the commands that could not be started
+const (
+	exitNotExecutable = 126
+	exitNotFound      = 127
+)
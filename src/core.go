@@ -26,10 +26,12 @@ THE SOFTWARE.
 package fzf
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"time"
 
+	"github.com/junegunn/fzf/src/algo"
 	"github.com/junegunn/fzf/src/util"
 )
 
@@ -40,12 +42,15 @@ Terminal -> EvtSearchNew:bool -> Matcher  (restart)
 Matcher  -> EvtSearchProgress -> Terminal (update info)
 Matcher  -> EvtSearchFin      -> Terminal (update list)
 Matcher  -> EvtHeader         -> Terminal (update header)
+Reader   -> EvtReadResume     -> Terminal (update info)
+Reader   -> EvtReadSource     -> Terminal (update info)
+Terminal -> EvtQuit:int       -> Run      (shut down)
 */
 
-// Run starts fzf
-func Run(opts *Options, version string, revision string) {
+// Run starts fzf and returns the exit code when the finder is closed. The
+// process exits in the other modes, such as the filtering mode.
+func Run(opts *Options, version string, revision string) int {
 	sort := opts.Sort > 0
-	sortCriteria = opts.Criteria
 
 	if opts.Version {
 		if len(revision) > 0 {
@@ -56,6 +61,27 @@ func Run(opts *Options, version string, revision string) {
 		os.Exit(exitOk)
 	}
 
+	// The finder is displayed in a tmux popup by another process of fzf
+	if len(opts.TmuxPane) > 0 && opts.Filter == nil && opts.Renderer == nil && opts.RenderOnce == renderNone {
+		code, err := runInTmuxPane(opts.TmuxPane)
+		if err != nil {
+			errorExit(err.Error())
+		}
+		os.Exit(code)
+	}
+
+	// Compatibility mode for non-UTF-8 locales
+	if charset := util.LocaleCharset(); !util.IsUTF8Charset(charset) {
+		if !util.SetLocaleCharset(charset) {
+			fmt.Fprintln(os.Stderr, "Unsupported character set of the locale: "+charset+" (non-ASCII characters are not displayed)")
+		}
+		opts.Unicode = false
+		printer := opts.Printer
+		opts.Printer = func(str string) {
+			printer(util.EncodeLocale(str))
+		}
+	}
+
 	// Event channel
 	eventBox := util.NewEventBox()
 
@@ -83,26 +109,83 @@ func Run(opts *Options, version string, revision string) {
 		}
 	}
 
+	// The items are converted to NFC on load for the Unicode normalization
+	algo.SetUnicodeNormalization(opts.Normalize && opts.UnicodeNorm)
+
 	// Chunk list
 	var chunkList *ChunkList
 	var itemIndex int32
 	header := make([]string, 0, opts.HeaderLines)
-	if len(opts.WithNth) == 0 {
+
+	// Lines starting with the prefix are displayed without it as disabled
+	// items that cannot be selected
+	disabled := newDisabledSet()
+	disabledPrefix := []byte(opts.DisabledPfx)
+	checkDisabled := func(data []byte) []byte {
+		if len(disabledPrefix) > 0 && bytes.HasPrefix(data, disabledPrefix) {
+			disabled.add(itemIndex)
+			return data[len(disabledPrefix):]
+		}
+		return data
+	}
+
+	// The numbers in --bar-field are displayed as bars scaled to the largest
+	var bars *metricBar
+	if len(opts.BarField) > 0 {
+		bars = newMetricBar(opts.BarField, opts.Delimiter, opts.BarWidth, opts.Unicode)
+	}
+
+	// The items are removed from the list after --item-ttl
+	var expiry *itemExpiry
+	if opts.ItemTTL > 0 {
+		expiry = newItemExpiry(opts.ItemTTL, opts.KeyField, opts.Delimiter, opts.Ansi)
+	}
+
+	if len(opts.WithNth) == 0 && len(opts.SearchField) == 0 {
 		chunkList = NewChunkList(func(item *Item, data []byte) bool {
+			data = util.DecodeLocale(data)
 			if len(header) < opts.HeaderLines {
 				header = append(header, string(data))
 				eventBox.Set(EvtHeader, header)
 				return false
 			}
-			item.text, item.colors = ansiProcessor(data)
+			data = checkDisabled(data)
+			if composed, changed := algo.ComposeBytes(data); changed {
+				// The original line is printed
+				item.origText = &data
+				item.text, item.colors = ansiProcessor(composed)
+			} else {
+				item.text, item.colors = ansiProcessor(data)
+			}
 			item.text.Index = itemIndex
+			if bars != nil {
+				bars.observe(item)
+			}
+			if expiry != nil {
+				expiry.add(item, time.Now())
+			}
 			itemIndex++
 			return true
 		})
 	} else {
 		chunkList = NewChunkList(func(item *Item, data []byte) bool {
+			data = util.DecodeLocale(data)
+			if len(header) >= opts.HeaderLines {
+				data = checkDisabled(data)
+			}
 			tokens := Tokenize(string(data), opts.Delimiter)
-			if opts.Ansi && opts.Theme.Colored && len(tokens) > 1 {
+			var search string
+			if len(opts.SearchField) > 0 {
+				// The fields for --search-field are neither displayed nor printed
+				var rest string
+				rest, search = SplitFields(tokens, opts.SearchField, opts.Delimiter)
+				if opts.Ansi {
+					search, _, _ = extractColor(search, nil, nil)
+				}
+				data = []byte(rest)
+				tokens = Tokenize(rest, opts.Delimiter)
+			}
+			if opts.Ansi && opts.Theme.Colored && len(tokens) > 1 && len(opts.WithNth) > 0 {
 				var ansiState *ansiState
 				if prevLineAnsiState != nil {
 					ansiStateDup := *prevLineAnsiState
@@ -118,49 +201,117 @@ func Run(opts *Options, version string, revision string) {
 					}
 				}
 			}
-			trans := Transform(tokens, opts.WithNth)
-			transformed := joinTokens(trans)
+			transformed := string(data)
+			if len(opts.WithNth) > 0 {
+				transformed = joinTokens(Transform(tokens, opts.WithNth))
+			}
 			if len(header) < opts.HeaderLines {
 				header = append(header, transformed)
 				eventBox.Set(EvtHeader, header)
 				return false
 			}
-			item.text, item.colors = ansiProcessor([]byte(transformed))
+			composed, _ := algo.ComposeBytes([]byte(transformed))
+			item.text, item.colors = ansiProcessor(composed)
 			item.text.TrimTrailingWhitespaces()
 			item.text.Index = itemIndex
 			item.origText = &data
+			if len(search) > 0 {
+				composed, _ := algo.ComposeBytes([]byte(search))
+				chars := util.ToChars(composed)
+				item.search = &chars
+			}
+			if bars != nil {
+				bars.observe(item)
+			}
+			if expiry != nil {
+				expiry.add(item, time.Now())
+			}
 			itemIndex++
 			return true
 		})
 	}
 
+	// The output of reload identical to the list is discarded. The items
+	// removed by --item-ttl make the list differ from the output.
+	checksum := newListChecksum(expiry == nil, chunkList.Push, func() {
+		chunkList.Clear()
+		if bars != nil {
+			bars.reset()
+		}
+		if expiry != nil {
+			expiry.clear()
+		}
+		header = make([]string, 0, opts.HeaderLines)
+	})
+
 	// Reader
 	streamingFilter := opts.Filter != nil && !sort && !opts.Tac && !opts.Sync
 	var reader *Reader
 	if !streamingFilter {
-		reader = NewReader(func(data []byte) bool {
-			return chunkList.Push(data)
-		}, eventBox, opts.ReadZero, opts.Filter == nil)
+		reader = NewReader(checksum.add, eventBox, opts.ReadZero, opts.Decompress, opts.Filter == nil, opts.SourceURL, opts.Sources)
 		go reader.ReadSource()
 	}
 
 	// Matcher
+	// The scoring table of the custom scheme is kept throughout the session
+	algo.Init(opts.Scoring)
+	if opts.WordBounds != nil {
+		algo.SetBoundaries(*opts.WordBounds)
+	}
+	if opts.AmbigWidth > 0 {
+		util.SetAmbiguousWidth(opts.AmbigWidth)
+	}
+	if opts.EmojiWidth > 0 {
+		util.SetEmojiWidth(opts.EmojiWidth)
+	}
+	// The scoring scheme, the case sensitivity, and regex mode can be changed by
+	// the actions of the terminal
+	scheme, caseMode, regex := opts.Scheme, opts.Case, opts.Regex
+	var criteria []criterion
 	forward := true
-	for _, cri := range opts.Criteria[1:] {
-		if cri == byEnd {
-			forward = false
-			break
-		}
-		if cri == byBegin {
-			break
+	setScheme := func() {
+		criteria, _ = schemeCriteria(scheme, opts.Criteria)
+		forward = true
+		for _, cri := range criteria[1:] {
+			if cri == byEnd {
+				forward = false
+				break
+			}
+			if cri == byBegin {
+				break
+			}
 		}
 	}
+	setScheme()
+	// The fields to match can be changed by the actions of the terminal
+	nth, delimiter, fieldsRev := opts.Nth, opts.Delimiter, 0
+	// The fuzzy matches with typos may lack some characters of the query
+	prefilter := opts.Prefilter && opts.Filter == nil && opts.FuzzyTypos == 0
+	buildPattern := func(runes []rune) *Pattern {
+		return BuildPattern(PatternOptions{
+			Fuzzy:      opts.Fuzzy,
+			FuzzyAlgo:  opts.FuzzyAlgo,
+			Extended:   opts.Extended,
+			Regex:      regex,
+			CaseMode:   caseMode,
+			Normalize:  opts.Normalize,
+			Forward:    forward,
+			Criteria:   criteria,
+			Cacheable:  opts.Filter == nil,
+			Prefilter:  prefilter,
+			Typos:      opts.Typos,
+			FuzzyTypos: opts.FuzzyTypos,
+			Nth:        nth,
+			Delimiter:  delimiter,
+			Revision:   fieldsRev}, runes)
+	}
+	// The match set of the query can be combined with the ones of the previous
+	// queries
+	var querySets []querySet
 	patternBuilder := func(runes []rune) *Pattern {
-		return BuildPattern(
-			opts.Fuzzy, opts.FuzzyAlgo, opts.Extended, opts.Case, opts.Normalize, forward,
-			opts.Filter == nil, opts.Nth, opts.Delimiter, runes)
+		return combinePatterns(querySets, buildPattern, buildPattern(runes))
 	}
-	matcher := NewMatcher(patternBuilder, sort, opts.Tac, eventBox)
+	matcher := NewMatcher(patternBuilder, sort, opts.Tac, eventBox, opts.Threads, opts.LowPrioSort, opts.DiskSort, opts.DiskSortDir, opts.TrigramMin)
 
 	// Filtering mode
 	if opts.Filter != nil {
@@ -172,6 +323,16 @@ func Run(opts *Options, version string, revision string) {
 		matcher.sort = pattern.sortable
 
 		found := false
+		// The matches are collected only if they are passed to the exit hooks
+		hooks := len(opts.OnAccept) > 0 || len(opts.OnExit) > 0
+		selections := []string{}
+		output := func(str string) {
+			opts.Printer(str)
+			if hooks {
+				selections = append(selections, str)
+			}
+			found = true
+		}
 		if streamingFilter {
 			slab := util.MakeSlab(slab16Size, slab32Size)
 			reader := NewReader(
@@ -179,12 +340,11 @@ func Run(opts *Options, version string, revision string) {
 					item := Item{}
 					if chunkList.trans(&item, runes) {
 						if result, _, _ := pattern.MatchItem(&item, false, slab); result != nil {
-							opts.Printer(item.text.ToString())
-							found = true
+							output(item.text.ToString())
 						}
 					}
 					return false
-				}, eventBox, opts.ReadZero, false)
+				}, eventBox, opts.ReadZero, opts.Decompress, false, opts.SourceURL, opts.Sources)
 			reader.ReadSource()
 		} else {
 			eventBox.Unwatch(EvtReadNew)
@@ -195,14 +355,17 @@ func Run(opts *Options, version string, revision string) {
 				chunks:  snapshot,
 				pattern: pattern})
 			for i := 0; i < merger.Length(); i++ {
-				opts.Printer(merger.Get(i).item.AsString(opts.Ansi))
-				found = true
+				output(merger.Get(i).item.AsString(opts.Ansi))
 			}
 		}
+		code := exitNoMatch
 		if found {
-			os.Exit(exitOk)
+			code = exitOk
+		}
+		if hooks {
+			runExitHooks(opts.OnAccept, opts.OnExit, code, *opts.Filter, selections)
 		}
-		os.Exit(exitNoMatch)
+		os.Exit(code)
 	}
 
 	// Synchronous search
@@ -215,7 +378,16 @@ func Run(opts *Options, version string, revision string) {
 	go matcher.Loop()
 
 	// Terminal I/O
-	terminal := NewTerminal(opts, eventBox)
+	terminal := NewTerminal(opts, eventBox, disabled, bars)
+
+	// Listen server
+	if len(opts.Listen) > 0 {
+		listener, err := startListenServer(opts.Listen, eventBox, terminal.serverInput)
+		if err != nil {
+			errorExit("failed to start listen server: " + err.Error())
+		}
+		defer listener.Close()
+	}
 	deferred := opts.Select1 || opts.Exit0
 	go terminal.Loop()
 	if !deferred {
@@ -228,17 +400,39 @@ func Run(opts *Options, version string, revision string) {
 	clearSelection := util.Once(false)
 	ticks := 0
 	var nextCommand *string
+	// Whether the list has been searched while reloading, or the sort order
+	// or the tab has changed, so that it should be searched again even if the
+	// output of reload turns out to be identical
+	stale := false
 	restart := func(command string) {
 		reading = true
-		clearCache = util.Once(true)
-		clearSelection = util.Once(true)
-		chunkList.Clear()
-		header = make([]string, 0, opts.HeaderLines)
+		checksum.hold()
 		go reader.restart(command)
 	}
 	eventBox.Watch(EvtReadNew)
+	if expiry != nil {
+		ticker := time.NewTicker(expiry.interval())
+		done := make(chan bool)
+		defer func() {
+			ticker.Stop()
+			close(done)
+		}()
+		go func() {
+			for {
+				select {
+				case now := <-ticker.C:
+					if chunkList.Retain(expiry.alive(now)) {
+						eventBox.Set(EvtItemExpire, nil)
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
 	query := []rune{}
-	for {
+	exitCode := -1
+	for exitCode < 0 {
 		delay := true
 		ticks++
 		input := func() []rune {
@@ -255,6 +449,9 @@ func Run(opts *Options, version string, revision string) {
 			for evt, value := range *events {
 				switch evt {
 
+				case EvtQuit:
+					exitCode = value.(int)
+
 				case EvtReadNew, EvtReadFin:
 					if evt == EvtReadFin && nextCommand != nil {
 						restart(*nextCommand)
@@ -263,6 +460,21 @@ func Run(opts *Options, version string, revision string) {
 					} else {
 						reading = reading && evt == EvtReadNew
 					}
+					if evt == EvtReadFin && checksum.finish(value.(*string) == nil) {
+						// The output of reload is identical to the list
+						snapshot, count := chunkList.Snapshot()
+						terminal.UpdateCount(count, true, nil)
+						searched := string(query)
+						if clear := clearCache(); clear || stale || string(input()) != searched {
+							matcher.Reset(snapshot, query, false, true, sort, clear)
+						}
+						stale = false
+						break
+					}
+					if checksum.takeReplaced() {
+						clearCache = util.Once(true)
+						clearSelection = util.Once(true)
+					}
 					snapshot, count := chunkList.Snapshot()
 					terminal.UpdateCount(count, !reading, value.(*string))
 					if opts.Sync {
@@ -275,8 +487,24 @@ func Run(opts *Options, version string, revision string) {
 					var command *string
 					switch val := value.(type) {
 					case searchRequest:
+						stale = stale || val.sort != sort || val.restore
 						sort = val.sort
 						command = val.command
+						if val.revision != fieldsRev {
+							nth, delimiter, fieldsRev = val.nth, val.delimiter, val.revision
+							clearPatternCache()
+							clearCache = util.Once(true)
+						}
+						if !sameQuerySets(val.querySets, querySets) {
+							querySets = val.querySets
+							clearCache = util.Once(true)
+						}
+						if val.scheme != scheme || val.caseMode != caseMode || val.regex != regex {
+							scheme, caseMode, regex = val.scheme, val.caseMode, val.regex
+							setScheme()
+							clearPatternCache()
+							clearCache = util.Once(true)
+						}
 					}
 					if command != nil {
 						if reading {
@@ -289,6 +517,7 @@ func Run(opts *Options, version string, revision string) {
 					}
 					snapshot, _ := chunkList.Snapshot()
 					matcher.Reset(snapshot, input(), true, !reading, sort, clearCache())
+					stale = stale || reading
 					delay = false
 
 				case EvtSearchProgress:
@@ -297,6 +526,34 @@ func Run(opts *Options, version string, revision string) {
 						terminal.UpdateProgress(val)
 					}
 
+				case EvtReadResume:
+					terminal.UpdateResuming(value.(bool))
+
+				case EvtReadSource:
+					terminal.UpdateSources(value.(sourceProgress))
+
+				case EvtItemExpire:
+					snapshot, count := chunkList.Snapshot()
+					// The expired items are no longer selected, nor printed
+					// on accept
+					terminal.UpdateSelection(expiry.has)
+					terminal.UpdateCount(count, !reading, nil)
+					matcher.Reset(snapshot, input(), false, !reading, sort, true)
+
+				case EvtComplete:
+					query := value.(completeQuery)
+					snapshot, _ := chunkList.Snapshot()
+					matcher.Complete(snapshot, []rune(query.prefix), func(merger *Merger) {
+						candidates := []string{}
+						for i := 0; i < merger.Length() && len(candidates) < query.limit; i++ {
+							item := merger.Get(i).item
+							if !disabled.has(item) {
+								candidates = append(candidates, item.AsString(opts.Ansi))
+							}
+						}
+						query.response <- candidates
+					})
+
 				case EvtHeader:
 					headerPadded := make([]string, opts.HeaderLines)
 					copy(headerPadded, value.([]string))
@@ -318,13 +575,18 @@ func Run(opts *Options, version string, revision string) {
 									if len(opts.Expect) > 0 {
 										opts.Printer("")
 									}
+									selections := []string{}
 									for i := 0; i < count; i++ {
-										opts.Printer(val.Get(i).item.AsString(opts.Ansi))
+										selection := val.Get(i).item.AsString(opts.Ansi)
+										opts.Printer(selection)
+										selections = append(selections, selection)
 									}
+									code := exitNoMatch
 									if count > 0 {
-										os.Exit(exitOk)
+										code = exitOk
 									}
-									os.Exit(exitNoMatch)
+									runExitHooks(opts.OnAccept, opts.OnExit, code, opts.Query, selections)
+									os.Exit(code)
 								}
 								deferred = false
 								terminal.startChan <- true
@@ -336,11 +598,16 @@ func Run(opts *Options, version string, revision string) {
 			}
 			events.Clear()
 		})
-		if delay && reading {
-			dur := util.DurWithin(
-				time.Duration(ticks)*coordinatorDelayStep,
-				0, coordinatorDelayMax)
-			time.Sleep(dur)
+		if delay && reading && exitCode < 0 {
+			// Not to update the list faster than the terminal can draw it
+			time.Sleep(terminal.UpdateDelay(ticks))
 		}
 	}
+
+	// The finder is closed
+	if reading {
+		reader.terminate()
+	}
+	matcher.Stop()
+	return exitCode
 }
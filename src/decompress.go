@@ -0,0 +1,74 @@
+package fzf
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Magic numbers at the beginning of the compressed streams
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns the reader of the decompressed stream if the stream
+// starts with the magic number of gzip or zstd, otherwise the stream as is.
+// The stream is decompressed concurrently with the reading of the items.
+func decompress(src io.Reader) io.Reader {
+	reader := bufio.NewReaderSize(src, readerBufferSize)
+	if hasMagic(reader, gzipMagic) {
+		return decompressGzip(reader)
+	}
+	if hasMagic(reader, zstdMagic) {
+		return decompressZstd(reader)
+	}
+	return reader
+}
+
+// hasMagic tells if the stream starts with the magic number. The next byte is
+// only waited for while the bytes so far match, so that the first line of
+// the uncompressed stream is not held back waiting for more bytes than
+// necessary. Peek returns fewer bytes only at the end of the stream.
+func hasMagic(reader *bufio.Reader, magic []byte) bool {
+	for idx := range magic {
+		peeked, err := reader.Peek(idx + 1)
+		if err != nil || peeked[idx] != magic[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// decompressGzip decompresses the gzip stream of one or more members
+func decompressGzip(src io.Reader) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		gz, err := gzip.NewReader(src)
+		if err == nil {
+			_, err = io.Copy(writer, gz)
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// decompressZstd decompresses the zstd stream with zstd command, using as many
+// threads as it can. The stream fails with the error if the command is not
+// found.
+func decompressZstd(src io.Reader) io.Reader {
+	reader, writer := io.Pipe()
+	if _, err := exec.LookPath("zstd"); err != nil {
+		writer.CloseWithError(fmt.Errorf("zstd command required to decompress the input: %w", err))
+		return reader
+	}
+	cmd := exec.Command("zstd", "-d", "-c", "-q", "-T0")
+	cmd.Stdin = src
+	cmd.Stdout = writer
+	go func() {
+		writer.CloseWithError(cmd.Run())
+	}()
+	return reader
+}
@@ -0,0 +1,84 @@
+package fzf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecompress(t *testing.T) {
+	check := func(data []byte, expected string) {
+		t.Helper()
+		out, err := ioutil.ReadAll(decompress(bytes.NewReader(data)))
+		if err != nil || string(out) != expected {
+			t.Errorf("%q, %v (expected: %q)", out, err, expected)
+		}
+	}
+
+	// Uncompressed streams are passed through, including the ones as short as
+	// or starting like the magic numbers
+	check([]byte("foo\nbar\n"), "foo\nbar\n")
+	check([]byte{}, "")
+	check([]byte("("), "(")
+	check([]byte("(foo)\n"), "(foo)\n")
+	check([]byte{0x1f}, "\x1f")
+
+	// Concatenated gzip members
+	var data bytes.Buffer
+	for _, str := range []string{"foo\n", "bar\n"} {
+		gz := gzip.NewWriter(&data)
+		gz.Write([]byte(str))
+		gz.Close()
+	}
+	check(data.Bytes(), "foo\nbar\n")
+
+	// Truncated gzip stream
+	if _, err := ioutil.ReadAll(decompress(bytes.NewReader(data.Bytes()[:10]))); err == nil {
+		t.Error("Error expected")
+	}
+
+	// Does not wait for the rest of the magic number once a byte differs
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go writer.Write([]byte("(\n"))
+	done := make(chan io.Reader)
+	go func() { done <- decompress(reader) }()
+	select {
+	case src := <-done:
+		line := make([]byte, 2)
+		if _, err := io.ReadFull(src, line); err != nil || string(line) != "(\n" {
+			t.Errorf("%q, %v", line, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Blocked waiting for the magic number")
+	}
+}
+
+func TestDecompressZstdNotFound(t *testing.T) {
+	defer setEnv("PATH", t.TempDir())()
+	_, err := ioutil.ReadAll(decompress(bytes.NewReader([]byte{0x28, 0xb5, 0x2f, 0xfd, 0})))
+	if err == nil || !strings.Contains(err.Error(), "zstd command required") {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestDecompressZstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not found")
+	}
+	cmd := exec.Command("zstd", "-c", "-q")
+	cmd.Stdin = strings.NewReader("foo\nbar\n")
+	data, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(decompress(bytes.NewReader(data)))
+	if err != nil || string(out) != "foo\nbar\n" {
+		t.Errorf("%q, %v", out, err)
+	}
+}
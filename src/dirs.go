@@ -0,0 +1,86 @@
+package fzf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	defaultHistoryFile = "history"
+	defaultRankLogFile = "ranks.jsonl"
+)
+
+// stateDir returns the directory for the files kept across the sessions such
+// as the history. The directory given with --state-dir takes precedence over
+// $FZF_STATE_DIR and the default directory of the platform, which is
+// $XDG_STATE_HOME/fzf or ~/.local/state/fzf, ~/Library/Application Support/fzf
+// on macOS, and %LocalAppData%\fzf on Windows.
+func stateDir(dir string) (string, error) {
+	if len(dir) > 0 {
+		return dir, nil
+	}
+	if dir := os.Getenv("FZF_STATE_DIR"); len(dir) > 0 {
+		return dir, nil
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); len(dir) > 0 {
+			return filepath.Join(dir, "fzf"), nil
+		}
+		return "", errors.New("%LocalAppData% is not defined")
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Application Support", "fzf"), nil
+	}
+	// Relative paths are to be ignored as per the XDG Base Directory
+	// Specification
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "fzf"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "fzf"), nil
+}
+
+// cacheDir returns the directory for the files that can be removed at any
+// time. The directory given with --cache-dir takes precedence over
+// $FZF_CACHE_DIR and the default directory of the platform, which is
+// $XDG_CACHE_HOME/fzf or ~/.cache/fzf, ~/Library/Caches/fzf on macOS, and
+// %LocalAppData%\fzf\cache on Windows.
+func cacheDir(dir string) (string, error) {
+	if len(dir) > 0 {
+		return dir, nil
+	}
+	if dir := os.Getenv("FZF_CACHE_DIR"); len(dir) > 0 {
+		return dir, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		// Not to share the directory with the state files
+		return filepath.Join(base, "fzf", "cache"), nil
+	}
+	return filepath.Join(base, "fzf"), nil
+}
+
+// stateFile returns the path of the file in the state directory, creating the
+// directory if it does not exist
+func stateFile(dir string, name string) (string, error) {
+	dir, err := stateDir(dir)
+	if err != nil {
+		return "", errors.New("failed to locate state directory: " + err.Error())
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.New("failed to create state directory: " + err.Error())
+	}
+	return filepath.Join(dir, name), nil
+}
@@ -0,0 +1,78 @@
+package fzf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func setEnv(name string, value string) func() {
+	prev, found := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if found {
+			os.Setenv(name, prev)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	defer setEnv("FZF_STATE_DIR", "/fzf/state")()
+	if dir, _ := stateDir("/given"); dir != "/given" {
+		t.Errorf("%s", dir)
+	}
+	if dir, _ := stateDir(""); dir != "/fzf/state" {
+		t.Errorf("%s", dir)
+	}
+	defer setEnv("FZF_CACHE_DIR", "/fzf/cache")()
+	if dir, _ := cacheDir(""); dir != "/fzf/cache" {
+		t.Errorf("%s", dir)
+	}
+
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return
+	}
+	os.Unsetenv("FZF_STATE_DIR")
+	defer setEnv("HOME", "/home/fzf")()
+	defer setEnv("XDG_STATE_HOME", "/xdg/state")()
+	if dir, _ := stateDir(""); dir != filepath.Join("/xdg/state", "fzf") {
+		t.Errorf("%s", dir)
+	}
+	os.Setenv("XDG_STATE_HOME", "relative")
+	if dir, _ := stateDir(""); dir != filepath.Join("/home/fzf", ".local", "state", "fzf") {
+		t.Errorf("%s", dir)
+	}
+}
+
+func TestStateFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fzf-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--history", "--rank-log", "--history-size=10", "--state-dir", filepath.Join(dir, "fzf")})
+	postProcessOptions(opts)
+	if opts.History == nil || opts.History.path != filepath.Join(dir, "fzf", defaultHistoryFile) || opts.History.maxSize != 10 {
+		t.Errorf("%v", opts.History)
+	}
+	if opts.RankLog == nil || opts.RankLog.path != filepath.Join(dir, "fzf", defaultRankLogFile) {
+		t.Errorf("%v", opts.RankLog)
+	}
+
+	path := filepath.Join(dir, "history")
+	opts = defaultOptions()
+	parseOptions(opts, []string{"--history=" + path, "--state-dir", filepath.Join(dir, "unused")})
+	postProcessOptions(opts)
+	if opts.History == nil || opts.History.path != path {
+		t.Errorf("%v", opts.History)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unused")); !os.IsNotExist(err) {
+		t.Errorf("state directory created: %v", err)
+	}
+}
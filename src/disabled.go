@@ -0,0 +1,33 @@
+package fzf
+
+import "sync"
+
+// disabledSet is the set of the indexes of the items that cannot be selected.
+// The reader adds the indexes while the terminal looks them up.
+type disabledSet struct {
+	mutex   sync.RWMutex
+	indexes map[int32]struct{}
+}
+
+func newDisabledSet() *disabledSet {
+	return &disabledSet{indexes: make(map[int32]struct{})}
+}
+
+func (s *disabledSet) add(index int32) {
+	s.mutex.Lock()
+	s.indexes[index] = struct{}{}
+	s.mutex.Unlock()
+}
+
+func (s *disabledSet) has(item *Item) bool {
+	s.mutex.RLock()
+	_, found := s.indexes[item.Index()]
+	s.mutex.RUnlock()
+	return found
+}
+
+func (s *disabledSet) empty() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.indexes) == 0
+}
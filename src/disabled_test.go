@@ -0,0 +1,45 @@
+package fzf
+
+import (
+	"testing"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestSkipDisabled(t *testing.T) {
+	list := []Result{}
+	for i := 0; i < 6; i++ {
+		list = append(list, Result{item: &Item{text: util.Chars{Index: int32(i)}}})
+	}
+	disabled := newDisabledSet()
+	for _, index := range []int32{0, 2, 3, 5} {
+		disabled.add(index)
+	}
+	term := Terminal{merger: NewMerger(nil, [][]Result{list}, false, false), disabled: disabled}
+
+	for _, test := range []struct {
+		from int
+		to   int
+		cy   int
+	}{
+		{1, 0, 1}, // No enabled item above
+		{1, 2, 4},
+		{4, 3, 1},
+		{4, 5, 4}, // No enabled item below
+	} {
+		term.cy = test.from
+		term.vset(test.to)
+		if term.cy != test.cy {
+			t.Errorf("%d -> %d: expected %d, got %d", test.from, test.to, test.cy, term.cy)
+		}
+	}
+
+	term.multi = 6
+	term.selected = make(map[int32]selectedItem)
+	for _, result := range list {
+		term.selectItem(result.item)
+	}
+	if len(term.selected) != 2 {
+		t.Errorf("disabled items selected: %v", term.selected)
+	}
+}
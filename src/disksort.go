@@ -0,0 +1,155 @@
+package fzf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+)
+
+// Each result is written to disk as the number of the chunk of the item
+// (uint32) and the offset of the item in the chunk (uint16) followed by its
+// points (4 * uint16)
+const sortedRecordSize = 14
+
+// spillFile is a temporary file holding the sorted runs of the results of a
+// partition, so that the memory for the results is released until they are
+// merged. The file is closed and removed when all of its runs are read, or
+// when it is no longer referenced by any run.
+type spillFile struct {
+	file *os.File
+	size int64
+	runs int // Number of the runs not read to the end
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	if len(dir) > 0 {
+		// The directory is created on demand
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.CreateTemp(dir, "fzf-sort-*")
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" {
+		// The contents are kept until the file is closed
+		os.Remove(file.Name())
+	}
+	spill := &spillFile{file: file}
+	runtime.SetFinalizer(spill, (*spillFile).close)
+	return spill, nil
+}
+
+func (s *spillFile) close() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	if runtime.GOOS == "windows" {
+		os.Remove(s.file.Name())
+	}
+	s.file = nil
+	runtime.SetFinalizer(s, nil)
+}
+
+// release is called when a run of the file is read to the end or discarded
+func (s *spillFile) release() {
+	if s.runs--; s.runs <= 0 {
+		s.close()
+	}
+}
+
+// locate returns the number of the chunk of the item and the offset of the
+// item in the chunk. chunks are the chunks of the run starting from the
+// chunk numbered first. The items can be removed from the list by
+// --item-ttl, so the indexes of the items are not contiguous, but they are
+// still in ascending order.
+func locate(item *Item, chunks []*Chunk, first int) (int, int) {
+	index := item.Index()
+	idx := sort.Search(len(chunks), func(i int) bool {
+		chunk := chunks[i]
+		return chunk.items[chunk.count-1].Index() >= index
+	})
+	chunk := chunks[idx]
+	offset := sort.Search(chunk.count, func(i int) bool {
+		return chunk.items[i].Index() >= index
+	})
+	return first + idx, offset
+}
+
+// writeRun appends the sorted results to the file. chunks are the chunks of
+// the items in the results, and first is the number of the first of them.
+func (s *spillFile) writeRun(results []Result, chunks []*Chunk, first int) (*sortedRun, error) {
+	writer := bufio.NewWriterSize(s.file, diskSortBufferSize)
+	var record [sortedRecordSize]byte
+	for _, result := range results {
+		chunk, offset := locate(result.item, chunks, first)
+		binary.LittleEndian.PutUint32(record[:], uint32(chunk))
+		binary.LittleEndian.PutUint16(record[4:], uint16(offset))
+		for i, point := range result.points {
+			binary.LittleEndian.PutUint16(record[6+i*2:], point)
+		}
+		if _, err := writer.Write(record[:]); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	size := int64(len(results)) * sortedRecordSize
+	run := &sortedRun{
+		spill:   s,
+		count:   len(results),
+		section: io.NewSectionReader(s.file, s.size, size)}
+	s.size += size
+	s.runs++
+	return run, nil
+}
+
+// sortedRun is a sequence of sorted results written to a spillFile
+type sortedRun struct {
+	spill   *spillFile
+	section *io.SectionReader
+	reader  *bufio.Reader
+	count   int // Number of the results not read yet
+	head    Result
+}
+
+// next reads the next result of the run into head. It returns false when
+// there are no more results, and the file is closed when none of its runs
+// has more results.
+func (r *sortedRun) next(chunks []*Chunk) bool {
+	if r.count == 0 {
+		return false
+	}
+	if r.reader == nil {
+		r.reader = bufio.NewReaderSize(r.section, diskSortBufferSize)
+	}
+	var record [sortedRecordSize]byte
+	if _, err := io.ReadFull(r.reader, record[:]); err != nil {
+		r.discard()
+		return false
+	}
+	chunk := binary.LittleEndian.Uint32(record[:])
+	offset := binary.LittleEndian.Uint16(record[4:])
+	r.head = Result{item: &chunks[chunk].items[offset]}
+	for i := range r.head.points {
+		r.head.points[i] = binary.LittleEndian.Uint16(record[6+i*2:])
+	}
+	if r.count--; r.count == 0 {
+		r.spill.release()
+	}
+	return true
+}
+
+// discard skips the rest of the run
+func (r *sortedRun) discard() {
+	if r.count > 0 {
+		r.count = 0
+		r.spill.release()
+	}
+}
@@ -0,0 +1,195 @@
+package fzf
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// displayFormat formats the value of a field for display. It returns false if
+// the value cannot be formatted.
+type displayFormat func(value string) (string, bool)
+
+var displayFormats = map[string]displayFormat{
+	"human-size": humanSize,
+	"reltime": func(value string) (string, bool) {
+		return relativeTime(value, time.Now())
+	},
+}
+
+// humanSize formats the number of bytes as in ls -h, e.g. 512, 4.0K, or 12M
+func humanSize(value string) (string, bool) {
+	size, ok := parseMetric(value)
+	if !ok || size < 0 {
+		return "", false
+	}
+	if size < 1024 {
+		return strconv.FormatFloat(math.Round(size), 'f', -1, 64), true
+	}
+	unit := 0
+	for size >= 1024 && unit < len("KMGTPE") {
+		size /= 1024
+		unit++
+	}
+	if size < 9.95 {
+		return fmt.Sprintf("%.1f%c", size, "KMGTPE"[unit-1]), true
+	}
+	return fmt.Sprintf("%.0f%c", size, "KMGTPE"[unit-1]), true
+}
+
+// The layouts of the timestamps besides the seconds since the epoch
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// relativeTime formats the timestamp relative to the given time, e.g. 5m ago
+// or in 2d. The timestamp is either the seconds since the epoch or in one of
+// timeLayouts, taken as local time unless the offset is given.
+func relativeTime(value string, now time.Time) (string, bool) {
+	value = strings.TrimSpace(value)
+	var at time.Time
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		at = time.Unix(0, int64(secs*float64(time.Second)))
+	} else {
+		parsed := false
+		for _, layout := range timeLayouts {
+			if at, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+				parsed = true
+				break
+			}
+		}
+		if !parsed {
+			return "", false
+		}
+	}
+	diff := now.Sub(at)
+	ago := diff >= 0
+	if !ago {
+		diff = -diff
+	}
+	var str string
+	switch {
+	case diff < time.Second:
+		return "now", true
+	case diff < time.Minute:
+		str = fmt.Sprintf("%ds", int(diff/time.Second))
+	case diff < time.Hour:
+		str = fmt.Sprintf("%dm", int(diff/time.Minute))
+	case diff < 24*time.Hour:
+		str = fmt.Sprintf("%dh", int(diff/time.Hour))
+	case diff < 30*24*time.Hour:
+		str = fmt.Sprintf("%dd", int(diff/(24*time.Hour)))
+	case diff < 365*24*time.Hour:
+		str = fmt.Sprintf("%dmo", int(diff/(30*24*time.Hour)))
+	default:
+		str = fmt.Sprintf("%dy", int(diff/(365*24*time.Hour)))
+	}
+	if ago {
+		return str + " ago", true
+	}
+	return "in " + str, true
+}
+
+// fieldFormats formats the fields of the items selected by the expressions of
+// --with-nth for display. The text of the items for matching and printing is
+// left unchanged.
+type fieldFormats struct {
+	withNth   []Range
+	formats   []displayFormat // Formats of the expressions, nil if not formatted
+	delimiter Delimiter
+	ansi      bool
+}
+
+// formattedSpan is the span of the text of the item replaced with the
+// formatted value
+type formattedSpan struct {
+	from    int32
+	to      int32
+	newFrom int32
+	newTo   int32
+}
+
+func newFieldFormats(withNth []Range, formats []displayFormat, delimiter Delimiter, ansi bool) *fieldFormats {
+	for _, format := range formats {
+		if format != nil {
+			return &fieldFormats{withNth, formats, delimiter, ansi}
+		}
+	}
+	return nil
+}
+
+// format returns the text of the item to display and the function converting
+// the offsets in the text of the item to the ones in the returned text. The
+// offsets in the formatted values are moved to either end of them.
+func (f *fieldFormats) format(item *Item) ([]rune, func(offset int32, end bool) int32) {
+	text := item.text.ToRunes()
+	if item.origText == nil {
+		return text, func(offset int32, end bool) int32 { return offset }
+	}
+	formatted := make([]rune, 0, len(text))
+	spans := []formattedSpan{}
+	pos := 0
+	for idx, part := range Transform(Tokenize(string(*item.origText), f.delimiter), f.withNth) {
+		if pos >= len(text) {
+			break
+		}
+		str := part.text.ToString()
+		if f.ansi {
+			str, _, _ = extractColor(str, nil, nil)
+		}
+		end := util.Min(pos+len([]rune(str)), len(text))
+		if format := f.formats[idx]; format != nil {
+			lead, value, trail := f.split(text[pos:end])
+			if str, ok := format(string(value)); ok {
+				from := int32(pos + len(lead))
+				formatted = append(formatted, lead...)
+				newFrom := int32(len(formatted))
+				formatted = append(formatted, []rune(str)...)
+				spans = append(spans, formattedSpan{from, from + int32(len(value)), newFrom, int32(len(formatted))})
+				formatted = append(formatted, trail...)
+				pos = end
+				continue
+			}
+		}
+		formatted = append(formatted, text[pos:end]...)
+		pos = end
+	}
+	formatted = append(formatted, text[pos:]...)
+	return formatted, func(offset int32, end bool) int32 {
+		var diff int32
+		for _, span := range spans {
+			if offset <= span.from {
+				break
+			}
+			if offset < span.to {
+				if end {
+					return span.newTo
+				}
+				return span.newFrom
+			}
+			diff = span.newTo - span.to
+		}
+		return offset + diff
+	}
+}
+
+// split splits the text of a field into the leading whitespaces, the value,
+// and the trailing whitespaces and delimiter
+func (f *fieldFormats) split(text []rune) ([]rune, []rune, []rune) {
+	str := string(text)
+	var delimiter string
+	if f.delimiter.str != nil && strings.HasSuffix(str, *f.delimiter.str) {
+		delimiter = *f.delimiter.str
+	} else if f.delimiter.regex != nil {
+		if locs := f.delimiter.regex.FindAllStringIndex(str, -1); len(locs) > 0 && locs[len(locs)-1][1] == len(str) {
+			delimiter = str[locs[len(locs)-1][0]:]
+		}
+	}
+	value := strings.TrimRight(str[:len(str)-len(delimiter)], " \t")
+	trail := str[len(value):]
+	trimmed := strings.TrimLeft(value, " \t")
+	lead := value[:len(value)-len(trimmed)]
+	return []rune(lead), []rune(trimmed), []rune(trail)
+}
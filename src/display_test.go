@@ -0,0 +1,94 @@
+package fzf
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestHumanSize(t *testing.T) {
+	for value, expected := range map[string]string{
+		"0":          "0",
+		"512":        "512",
+		"1024":       "1.0K",
+		"10188":      "9.9K",
+		"10239":      "10K",
+		"1048576":    "1.0M",
+		"5368709120": "5.0G",
+		"2M":         "2.0M",
+	} {
+		if str, ok := humanSize(value); !ok || str != expected {
+			t.Errorf("%q: expected %q, got %q", value, expected, str)
+		}
+	}
+	for _, value := range []string{"", "-1", "foo"} {
+		if _, ok := humanSize(value); ok {
+			t.Errorf("%q: formatted", value)
+		}
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+	for value, expected := range map[string]string{
+		formatUnix(now):                               "now",
+		formatUnix(now.Add(-42 * time.Second)):        "42s ago",
+		formatUnix(now.Add(-5 * time.Minute)):         "5m ago",
+		formatUnix(now.Add(3 * time.Hour)):            "in 3h",
+		"2024-03-13 11:00:00":                         "2d ago",
+		"2024-01-01":                                  "2mo ago",
+		"2021-03-15T12:00:00":                         "3y ago",
+		now.UTC().Add(time.Hour).Format(time.RFC3339): "in 1h",
+	} {
+		if str, ok := relativeTime(value, now); !ok || str != expected {
+			t.Errorf("%q: expected %q, got %q", value, expected, str)
+		}
+	}
+	if _, ok := relativeTime("yesterday", now); ok {
+		t.Error("formatted")
+	}
+}
+
+func formatUnix(at time.Time) string {
+	return strconv.FormatInt(at.Unix(), 10)
+}
+
+func TestFieldFormats(t *testing.T) {
+	upper := func(value string) (string, bool) {
+		return "<" + value + ">", value != "x"
+	}
+	type conversion struct {
+		offset   int32
+		end      bool
+		expected int32
+	}
+	test := func(delimiter Delimiter, withNth string, input string, expected string, conversions []conversion) {
+		ranges, formats := splitWithNth(withNth)
+		for idx := range formats {
+			if formats[idx] != nil {
+				formats[idx] = upper
+			}
+		}
+		data := []byte(input)
+		item := Item{text: util.ToChars([]byte(joinTokens(Transform(Tokenize(input, delimiter), ranges)))), origText: &data}
+		item.text.TrimTrailingWhitespaces()
+		text, convert := newFieldFormats(ranges, formats, delimiter, false).format(&item)
+		if string(text) != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, string(text))
+		}
+		for _, c := range conversions {
+			if converted := convert(c.offset, c.end); converted != c.expected {
+				t.Errorf("%q: %d converted to %d (expected: %d)", input, c.offset, converted, c.expected)
+			}
+		}
+	}
+	// The offsets in the formatted value are moved to the beginning or to the
+	// end of it
+	test(Delimiter{}, "1,{2|human-size},3", "foo  bar baz", "foo  <bar> baz",
+		[]conversion{{3, false, 3}, {5, false, 5}, {6, false, 5}, {6, true, 10}, {8, true, 10}, {9, false, 11}, {10, true, 12}})
+	test(Delimiter{}, "{2|human-size}", "foo x", "x", nil)
+	test(delimiterRegexp(","), "{2|human-size},1", "foo,bar,baz", "<bar>,foo,", nil)
+	test(delimiterRegexp(":+"), "{..|human-size}", "foo::bar", "<foo::bar>", nil)
+}
@@ -0,0 +1,360 @@
+package fzf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junegunn/fzf/src/tui"
+)
+
+const driverTimeout = 5 * time.Second
+
+// testDriver runs fzf on a virtual renderer and feeds it the scripted events
+// for the integration tests of the rendering. The finder is closed at the end
+// of the test, and the test waits until Run returns. Only one finder can run
+// at a time as the color theme is global, so the tests using the driver
+// should not run in parallel.
+type testDriver struct {
+	t        *testing.T
+	renderer *tui.VirtualRenderer
+	done     chan int // Receives the exit code when Run returns
+}
+
+func newTestDriver(t *testing.T, width int, height int, input string, args ...string) *testDriver {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(file, []byte(input), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	parseOptions(opts, append([]string{"--source", "cat " + file}, args...))
+	postProcessOptions(opts)
+	renderer := tui.NewVirtualRenderer(width, height)
+	opts.Renderer = renderer
+	d := &testDriver{t, renderer, make(chan int, 1)}
+	go func() {
+		d.done <- Run(opts, "test", "test")
+	}()
+	t.Cleanup(d.close)
+	return d
+}
+
+// close aborts the finder and waits until Run returns. ctrl-c is sent
+// repeatedly as the first one may only cancel the pending operation.
+func (d *testDriver) close() {
+	timeout := time.After(driverTimeout)
+	for {
+		d.send(tui.CtrlC.AsEvent())
+		select {
+		case <-d.done:
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			d.t.Fatal("timed out waiting for the finder to exit")
+		}
+	}
+}
+
+// send feeds the events to the finder
+func (d *testDriver) send(events ...tui.Event) {
+	for _, event := range events {
+		d.renderer.SendEvent(event)
+	}
+}
+
+// keys feeds the events of the keys given in the format of --bind
+func (d *testDriver) keys(keys ...string) {
+	d.t.Helper()
+	for _, key := range keys {
+		chords := parseKeyChords(key, "key required")
+		if len(chords) != 1 {
+			d.t.Fatalf("invalid key: %s", key)
+		}
+		for event := range chords {
+			d.send(event)
+		}
+	}
+}
+
+// typeText feeds the characters of the text
+func (d *testDriver) typeText(text string) {
+	for _, r := range text {
+		d.send(tui.Event{Type: tui.Rune, Char: r})
+	}
+}
+
+// screen returns the lines on the screen without the trailing spaces
+func (d *testDriver) screen() []string {
+	return strings.Split(d.renderer.String(), "\n")
+}
+
+// until waits for the screen to satisfy the condition and returns the lines.
+// The test fails if it does not happen in time.
+func (d *testDriver) until(cond func(lines []string) bool) []string {
+	d.t.Helper()
+	timeout := time.After(driverTimeout)
+	for {
+		if lines := d.screen(); cond(lines) {
+			return lines
+		}
+		select {
+		case <-d.renderer.Updated():
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			d.t.Fatalf("timed out waiting for the screen:\n%s", d.renderer.String())
+		}
+	}
+}
+
+// untilLine waits for the line at the index to be the text. The negative
+// index is counted from the bottom of the screen.
+func (d *testDriver) untilLine(index int, text string) []string {
+	d.t.Helper()
+	return d.until(func(lines []string) bool {
+		if index < 0 {
+			index += len(lines)
+		}
+		return index >= 0 && index < len(lines) && lines[index] == text
+	})
+}
+
+// untilCall waits for a draw call starting with the prefix to be recorded
+func (d *testDriver) untilCall(prefix string) {
+	d.t.Helper()
+	d.until(func([]string) bool {
+		for _, call := range d.renderer.Calls() {
+			if strings.HasPrefix(call, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestDriverQuery(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse")
+	d.untilLine(1, "  3/3")
+	d.typeText("ba")
+	lines := d.untilLine(1, "  2/3")
+	if lines[0] != "> ba" || lines[2] != "> bar" || lines[3] != "  baz" {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestDriverPartialRedraw(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse")
+	d.untilLine(1, "  3/3")
+	d.typeText("ba")
+	d.untilLine(1, "  2/3")
+
+	// Only the lines changed by the cursor movement are printed again
+	d.renderer.Record(true)
+	d.keys("down")
+	d.untilLine(3, "> baz")
+	calls := d.renderer.Calls()
+	printed := false
+	for _, call := range calls {
+		if strings.Contains(call, "(2, ") {
+			printed = true
+		}
+		if strings.HasPrefix(call, "CPrint(0, ") || strings.HasPrefix(call, "CPrint(1, ") {
+			t.Errorf("unexpected call: %s", call)
+		}
+	}
+	if !printed {
+		t.Errorf("%q", calls)
+	}
+	if y, x := d.renderer.Cursor(); y != 0 || x != 4 {
+		t.Errorf("%d, %d", y, x)
+	}
+}
+
+func TestDriverRepeat(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--bind", "f1:first+repeat(2,down)")
+	d.untilLine(1, "  3/3")
+	d.keys("down")
+	d.untilLine(3, "> bar")
+	d.keys("f1")
+	d.untilLine(4, "> baz")
+}
+
+func TestDriverAcceptAllConfirm(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--multi", "--bind", "f2:accept-all", "--accept-all-confirm", "2")
+	d.untilLine(1, "  3/3 (0)")
+
+	// accept-all asks for confirmation and any other key cancels it
+	d.keys("f2")
+	d.untilLine(1, "  Accept all 3 items? [y/N]")
+	d.keys("n")
+	lines := d.untilLine(1, "  3/3 (0)")
+	if lines[0] != ">" {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestDriverMouseDrag(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--multi")
+	d.untilLine(1, "  3/3 (0)")
+
+	// Dragging the mouse toggles the items passed
+	mouse := func(y int, down bool, drag bool) {
+		d.send(tui.Event{Type: tui.Mouse, MouseEvent: &tui.MouseEvent{Y: y, X: 3, Left: true, Down: down, Drag: drag}})
+	}
+	mouse(2, true, false)
+	mouse(3, false, true)
+	mouse(4, false, true)
+	mouse(3, false, true)
+	mouse(3, false, false)
+	lines := d.untilLine(1, "  3/3 (2)")
+	if lines[2] != " >foo" || lines[3] != ">>bar" || lines[4] != "  baz" {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestDriverBell(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--bell", "visual")
+	d.untilLine(1, "  3/3")
+	d.renderer.Record(true)
+
+	// The bell rings when the cursor hits the end of the list
+	d.keys("down", "down")
+	d.untilLine(4, "> baz")
+	for _, call := range d.renderer.Calls() {
+		if strings.HasPrefix(call, "Bell") {
+			t.Errorf("unexpected call: %s", call)
+		}
+	}
+	d.keys("down")
+	d.untilCall("Bell")
+}
+
+func TestDriverPaste(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse")
+	d.untilLine(1, "  3/3")
+
+	// The pasted text is inserted into the query at once
+	d.renderer.SendPaste("ba\nr\n")
+	lines := d.untilLine(1, "  1/3")
+	if lines[0] != "> ba r" {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestDriverPasteFilter(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--paste-filter", "tr -d '\\n' | tr A-Z a-z")
+	d.untilLine(1, "  3/3")
+
+	// The output of the filter is inserted instead of the pasted text
+	d.renderer.SendPaste("BA\nZ")
+	d.untilLine(0, "> baz")
+	d.untilLine(1, "  1/3")
+}
+
+func TestDriverKeyChord(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--bind", "ctrl-x>ctrl-e:clear-query")
+	d.untilLine(1, "  3/3")
+	d.typeText("ba")
+	d.untilLine(1, "  2/3")
+
+	// The pending key chord is shown in the info line with the popup of the
+	// following keys, and cancelled by the keys not in the chord
+	d.keys("ctrl-x")
+	lines := d.untilLine(1, "  2/3 (ctrl-x>)")
+	if !strings.HasSuffix(lines[2], "╭─ ctrl-x> ───────────╮") || !strings.HasSuffix(lines[3], "│ ctrl-e  clear-query │") {
+		t.Errorf("%q", lines)
+	}
+	d.typeText("z")
+	lines = d.untilLine(1, "  2/3")
+	if lines[0] != "> ba" || strings.Contains(lines[3], "clear-query") {
+		t.Errorf("%q", lines)
+	}
+	d.keys("ctrl-x")
+	d.untilLine(1, "  2/3 (ctrl-x>)")
+	d.keys("esc")
+	lines = d.untilLine(1, "  2/3")
+	if lines[0] != "> ba" {
+		t.Errorf("%q", lines)
+	}
+	d.keys("ctrl-x", "ctrl-e")
+	d.untilLine(1, "  3/3")
+}
+
+func TestDriverZeroFlash(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse", "--bind", "zero:flash")
+	d.untilLine(1, "  3/3")
+	d.renderer.Record(true)
+
+	// zero event is triggered when the items no longer match
+	d.typeText("x")
+	d.untilLine(1, "  0/3")
+	d.untilCall("Bell")
+}
+
+func TestDriverNumericArgument(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse")
+	d.untilLine(1, "  3/3")
+	d.typeText("x")
+	d.untilLine(1, "  0/3")
+
+	// The numeric argument repeats the actions of the next key. The digits
+	// typed after it extend it, and esc cancels it.
+	d.keys("alt-1")
+	d.typeText("2")
+	d.untilLine(1, "  0/3 (arg: 12)")
+	d.keys("esc")
+	d.untilLine(1, "  0/3")
+	d.typeText("yz")
+	d.keys("alt-3", "bspace")
+	d.untilLine(1, "  3/3")
+}
+
+func TestDriverRegisters(t *testing.T) {
+	d := newTestDriver(t, 30, 5, "foo\nbar\nbaz\n", "--layout", "reverse",
+		"--bind", "ctrl-w:backward-kill-word+yank-to(a),f3:yank-to(b),f4:put-from(a),f5:put-from")
+	d.untilLine(1, "  3/3")
+
+	// yank-to stores the text killed by the previous action, or the current
+	// item, in the register, and put-from without the register picks it by
+	// the next key
+	d.typeText("ba")
+	d.keys("ctrl-w")
+	d.untilLine(1, "  3/3")
+	d.keys("f4")
+	lines := d.untilLine(1, "  2/3")
+	if lines[0] != "> ba" {
+		t.Errorf("%q", lines)
+	}
+	d.keys("f3", "ctrl-u")
+	d.untilLine(1, "  3/3")
+	d.keys("f5")
+	lines = d.until(func(lines []string) bool { return strings.Contains(lines[3], "b  bar") })
+	if !strings.HasSuffix(lines[1], "╭─ Put from ─╮") || !strings.Contains(lines[2], "a  ba") {
+		t.Errorf("%q", lines)
+	}
+	d.typeText("b")
+	lines = d.untilLine(1, "  1/3")
+	if lines[0] != "> bar" || strings.Contains(lines[2], "a  ba") {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestDriverWrapPage(t *testing.T) {
+	d := newTestDriver(t, 12, 7, "1\n234567890abcdef\n3\n4\n5\n", "--layout", "reverse", "--wrap")
+	d.untilLine(1, "  5/5")
+
+	// The page is counted in the rows of the wrapped items, and the list is
+	// scrolled to keep the current item on the screen
+	d.keys("pgdn")
+	lines := d.untilLine(6, "> 4")
+	if lines[2] != "  1" || lines[5] != "  3" {
+		t.Errorf("%q", lines)
+	}
+	d.keys("pgdn")
+	lines = d.untilLine(6, "> 5")
+	if !strings.HasPrefix(lines[2], "  234") || lines[5] != "  4" {
+		t.Errorf("%q", lines)
+	}
+}
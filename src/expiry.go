@@ -0,0 +1,85 @@
+package fzf
+
+import (
+	"sync"
+	"time"
+)
+
+type expiryEntry struct {
+	born time.Time
+	key  string
+}
+
+// itemExpiry tracks the time when each item is read for --item-ttl. Only the
+// last item of the same key is retained, so an item re-emitted by the source
+// replaces the previous one and lives longer.
+type itemExpiry struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	fields    []Range
+	delimiter Delimiter
+	ansi      bool
+	entries   map[int32]expiryEntry
+	latest    map[string]int32
+}
+
+func newItemExpiry(ttl time.Duration, fields []Range, delimiter Delimiter, ansi bool) *itemExpiry {
+	return &itemExpiry{ttl: ttl, fields: fields, delimiter: delimiter, ansi: ansi,
+		entries: make(map[int32]expiryEntry), latest: make(map[string]int32)}
+}
+
+// clear forgets the items before reloading the list
+func (e *itemExpiry) clear() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.entries = make(map[int32]expiryEntry)
+	e.latest = make(map[string]int32)
+}
+
+// interval returns how often the items are checked
+func (e *itemExpiry) interval() time.Duration {
+	if e.ttl < time.Second {
+		return e.ttl
+	}
+	return time.Second
+}
+
+func (e *itemExpiry) add(item *Item, now time.Time) {
+	key := keyOf(item, e.fields, e.delimiter, e.ansi)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.entries[item.Index()] = expiryEntry{now, key}
+	e.latest[key] = item.Index()
+}
+
+// has returns true if the item has not been removed from the list
+func (e *itemExpiry) has(item *Item) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, found := e.entries[item.Index()]
+	return found
+}
+
+// alive returns a function that tells if the item should be retained at the
+// time. The entries of the items removed from the list are forgotten.
+func (e *itemExpiry) alive(now time.Time) func(*Item) bool {
+	return func(item *Item) bool {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		index := item.Index()
+		entry, found := e.entries[index]
+		if !found {
+			return false
+		}
+		if latest := e.latest[entry.key]; latest != index {
+			delete(e.entries, index)
+			return false
+		}
+		if now.Sub(entry.born) > e.ttl {
+			delete(e.entries, index)
+			delete(e.latest, entry.key)
+			return false
+		}
+		return true
+	}
+}
@@ -0,0 +1,44 @@
+package fzf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestItemExpiry(t *testing.T) {
+	item := func(index int32, line string) *Item {
+		chars := util.ToChars([]byte(line))
+		chars.Index = index
+		return &Item{text: chars}
+	}
+	now := time.Now()
+	expiry := newItemExpiry(time.Minute, splitNth("1"), Delimiter{}, false)
+	items := []*Item{item(0, "a 1"), item(1, "b 1"), item(2, "a 2")}
+	expiry.add(items[0], now)
+	expiry.add(items[1], now.Add(-2*time.Minute))
+	expiry.add(items[2], now)
+
+	alive := expiry.alive(now)
+	for i, expected := range []bool{false, false, true} {
+		if alive(items[i]) != expected {
+			t.Errorf("%d: expected %v", i, expected)
+		}
+	}
+	if len(expiry.entries) != 1 || len(expiry.latest) != 1 {
+		t.Errorf("The removed items should be forgotten: %v, %v", expiry.entries, expiry.latest)
+	}
+	for i, expected := range []bool{false, false, true} {
+		if expiry.has(items[i]) != expected {
+			t.Errorf("%d: expected %v", i, expected)
+		}
+	}
+	if expiry.alive(now.Add(2 * time.Minute))(items[2]) {
+		t.Error("The item should expire")
+	}
+
+	if expiry.interval() != time.Second || newItemExpiry(100*time.Millisecond, nil, Delimiter{}, false).interval() != 100*time.Millisecond {
+		t.Error("Unexpected interval")
+	}
+}
@@ -0,0 +1,132 @@
+package fzf
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/junegunn/fzf/src/tui"
+)
+
+var formNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// formField is a named input field displayed below the header
+type formField struct {
+	name  string
+	value []rune
+	cx    int
+}
+
+// parseForm parses the comma-separated list of NAME=VALUE pairs
+func parseForm(str string) []formField {
+	fields := []formField{}
+	if len(str) == 0 {
+		return fields
+	}
+	names := make(map[string]bool)
+	for _, pair := range strings.Split(str, ",") {
+		tokens := strings.SplitN(pair, "=", 2)
+		name := tokens[0]
+		if !formNameRegexp.MatchString(name) {
+			errorExit("invalid form field name: " + name)
+		}
+		if names[name] {
+			errorExit("duplicate form field: " + name)
+		}
+		names[name] = true
+		value := []rune{}
+		if len(tokens) > 1 {
+			value = []rune(tokens[1])
+		}
+		fields = append(fields, formField{name: name, value: value, cx: len(value)})
+	}
+	return fields
+}
+
+// fieldEditAction returns the action to apply to the focused field in place
+// of the given one. The EOF variants only delete characters in a field.
+func fieldEditAction(t actionType) (actionType, bool) {
+	switch t {
+	case actDeleteCharEOF:
+		return actDeleteChar, true
+	case actBackwardDeleteCharEOF:
+		return actBackwardDeleteChar, true
+	case actRune, actBeginningOfLine, actEndOfLine, actBackwardChar, actForwardChar,
+		actBackwardWord, actForwardWord, actBackwardDeleteChar, actDeleteChar,
+		actKillLine, actKillWord, actBackwardKillWord, actUnixLineDiscard,
+		actUnixWordRubout, actYank, actPutFrom, actPaste:
+		return t, true
+	}
+	return t, false
+}
+
+func (t *Terminal) focusedField() *formField {
+	if t.formFocus < 0 || t.formFocus >= len(t.form) {
+		return nil
+	}
+	return &t.form[t.formFocus]
+}
+
+// focusField moves the focus to the field at the index, where -1 denotes the
+// query. The index wraps around.
+func (t *Terminal) focusField(idx int) {
+	n := len(t.form) + 1
+	t.formFocus = (idx+1+n)%n - 1
+}
+
+// swapInput exchanges the query with the value of the field so that the
+// editing actions can be applied to the field
+func (t *Terminal) swapInput(field *formField) {
+	t.input, field.value = field.value, t.input
+	t.cx, field.cx = field.cx, t.cx
+}
+
+func (t *Terminal) formValues() map[string]string {
+	values := make(map[string]string, len(t.form))
+	for _, field := range t.form {
+		values[field.name] = string(field.value)
+	}
+	return values
+}
+
+func hasFormPlaceholder(template string) bool {
+	return strings.Contains(template, "{form:")
+}
+
+// referencesField tells if the template has the placeholder of the field
+func referencesField(template string, name string) bool {
+	return strings.Contains(template, "{form:"+name+"}")
+}
+
+func (t *Terminal) headerLines() int {
+	return len(t.visibleHeader()) + len(t.form) + t.hintLines() + t.tabLines()
+}
+
+func (t *Terminal) fieldLine(idx int) int {
+	line := len(t.visibleHeader()) + idx + 2
+	if t.noInfoLine() {
+		line--
+	}
+	return line
+}
+
+func (t *Terminal) fieldLabel(field *formField) string {
+	return field.name + ": "
+}
+
+func (t *Terminal) printForm() {
+	max := t.window.Height()
+	for idx := range t.form {
+		field := &t.form[idx]
+		line := t.fieldLine(idx)
+		if line >= max {
+			continue
+		}
+		color := tui.ColHeader
+		if idx == t.formFocus {
+			color = tui.ColPrompt
+		}
+		t.move(line, 2, true)
+		t.window.CPrint(color, t.fieldLabel(field))
+		t.window.CPrint(tui.ColInput, string(field.value))
+	}
+}
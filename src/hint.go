@@ -0,0 +1,204 @@
+package fzf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/junegunn/fzf/src/tui"
+	"github.com/junegunn/fzf/src/util"
+)
+
+// namedKeys returns the names of the keys to look up the names of the default
+// bindings. The later names take precedence over the earlier ones for the
+// same key, such as enter over ctrl-m.
+func namedKeys() string {
+	names := []string{}
+	for r := 'a'; r <= 'z'; r++ {
+		names = append(names, "ctrl-"+string(r), "alt-"+string(r))
+	}
+	for r := '0'; r <= '9'; r++ {
+		names = append(names, "alt-"+string(r))
+	}
+	names = append(names, "enter", "tab", "btab", "esc", "bspace", "del", "alt-bs",
+		"up", "down", "left", "right", "home", "end", "pgup", "pgdn",
+		"shift-up", "shift-down", "shift-left", "shift-right",
+		"left-click", "right-click", "double-click")
+	return strings.Join(names, ",")
+}
+
+// defaultKeyNames returns the names of the keys of the default bindings
+// listed by show-bindings action, which are the ones with the names of the
+// actions
+func defaultKeyNames() map[tui.Event]string {
+	names := parseKeyChords(namedKeys(), "")
+	defaults := make(map[tui.Event]string)
+	for key, actions := range defaultKeymap() {
+		if name, found := names[key]; found && len(actions) == 1 && len(defaultActionNames[actions[0].t]) > 0 {
+			defaults[key] = name
+		}
+	}
+	return defaults
+}
+
+// defaultActionNames is the names of the actions of the default bindings
+var defaultActionNames = map[actionType]string{
+	actAbort:              "abort",
+	actAccept:             "accept",
+	actBackwardChar:       "backward-char",
+	actBackwardDeleteChar: "backward-delete-char",
+	actBackwardKillWord:   "backward-kill-word",
+	actBackwardWord:       "backward-word",
+	actBeginningOfLine:    "beginning-of-line",
+	actClearScreen:        "clear-screen",
+	actDeleteChar:         "delete-char",
+	actDeleteCharEOF:      "delete-char/eof",
+	actDigitArgument:      "digit-argument",
+	actDown:               "down",
+	actEndOfLine:          "end-of-line",
+	actForwardChar:        "forward-char",
+	actForwardWord:        "forward-word",
+	actKillWord:           "kill-word",
+	actNextHistory:        "next-history",
+	actPageDown:           "page-down",
+	actPageUp:             "page-up",
+	actPreviewDown:        "preview-down",
+	actPreviewUp:          "preview-up",
+	actPreviousHistory:    "previous-history",
+	actToggle:             "toggle",
+	actToggleDown:         "toggle-down",
+	actToggleUp:           "toggle-up",
+	actUnixLineDiscard:    "unix-line-discard",
+	actUnixWordRubout:     "unix-word-rubout",
+	actUp:                 "up",
+	actYank:               "yank",
+}
+
+// bindingHint is a key binding with the description of its actions
+type bindingHint struct {
+	key  string
+	desc string
+}
+
+// actionDescription returns the description given to the actions with '#'
+func actionDescription(actions []action) string {
+	for _, action := range actions {
+		if action.t == actDescription {
+			return action.a
+		}
+	}
+	return ""
+}
+
+// bindingHints returns the described bindings sorted by the names of the keys
+func bindingHints(keymap map[tui.Event][]action, names map[tui.Event]string) []bindingHint {
+	hints := []bindingHint{}
+	for key, actions := range keymap {
+		name, found := names[key]
+		if !found {
+			continue
+		}
+		if desc := actionDescription(actions); len(desc) > 0 {
+			hints = append(hints, bindingHint{key: name, desc: desc})
+		}
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].key < hints[j].key
+	})
+	return hints
+}
+
+// allBindings returns the bindings of the keymap sorted by the names of the
+// keys. The actions are described by the descriptions given to them, or by
+// their definitions. The default bindings that are not described are not
+// listed, nor are the events without the names.
+func allBindings(keymap map[tui.Event][]action, names map[tui.Event]string, specs map[tui.Event]string) []bindingHint {
+	hints := []bindingHint{}
+	defaultNames := defaultKeyNames()
+	for key, actions := range keymap {
+		if len(actions) == 1 && actions[0].t == actChord {
+			hints = append(hints, actions[0].k.bindings()...)
+			continue
+		}
+		name, found := names[key]
+		if !found {
+			name = defaultNames[key]
+		}
+		desc := actionDescription(actions)
+		if len(desc) == 0 {
+			desc = specs[key]
+		}
+		if len(desc) == 0 && len(actions) == 1 {
+			desc = defaultActionNames[actions[0].t]
+		}
+		if len(name) > 0 && len(desc) > 0 {
+			hints = append(hints, bindingHint{key: name, desc: desc})
+		}
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].key < hints[j].key
+	})
+	return hints
+}
+
+// bindingsRows returns the number of the bindings on a page of the popup
+func (t *Terminal) bindingsRows() int {
+	return util.Max(1, t.window.Height()-2)
+}
+
+// showBindings lists the bindings in the popup, or moves to the next page of
+// the list if it is already shown. The popup is closed after the last page.
+func (t *Terminal) showBindings() {
+	if t.bindings == nil {
+		t.bindings, t.bindingsAt = allBindings(t.keymap, t.keyNames, t.keySpecs), 0
+	} else {
+		t.bindingsAt += t.bindingsRows()
+	}
+	if t.bindingsAt >= len(t.bindings) {
+		t.bindings = nil
+	}
+}
+
+// printBindingsPopup shows the current page of the bindings in the popup
+func (t *Terminal) printBindingsPopup() {
+	end := util.Min(t.bindingsAt+t.bindingsRows(), len(t.bindings))
+	label := fmt.Sprintf(" Bindings %d-%d/%d ", t.bindingsAt+1, end, len(t.bindings))
+	t.printPopup(label, t.bindings[t.bindingsAt:end], -1)
+}
+
+func (t *Terminal) hintLines() int {
+	if len(t.hints) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// currentHint returns the hint to display. The hints take turns every
+// hintDuration.
+func (t *Terminal) currentHint() bindingHint {
+	idx := time.Now().UnixNano() / int64(hintDuration) % int64(len(t.hints))
+	return t.hints[idx]
+}
+
+func (t *Terminal) printHint() {
+	if t.hintLines() == 0 {
+		return
+	}
+	line := len(t.visibleHeader()) + len(t.form) + 2
+	if t.noInfoLine() {
+		line--
+	}
+	if line >= t.window.Height() {
+		return
+	}
+	hint := t.currentHint()
+	t.move(line, 2, true)
+	width := t.window.Width() - 2
+	key, _ := t.trimRight([]rune(hint.key+": "), width)
+	t.window.CPrint(tui.ColPrompt, string(key))
+	if width -= t.displayWidth(key); width > 0 {
+		desc, _ := t.trimRight([]rune(hint.desc), width)
+		t.window.CPrint(tui.ColHeader, string(desc))
+	}
+}
@@ -4,16 +4,22 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/junegunn/fzf/src/util"
 )
 
 // History struct represents input history
 type History struct {
 	path     string
 	lines    []string
+	saved    []string // Entries in the file as last read or written
 	modified map[int]string
 	maxSize  int
 	cursor   int
+	filters  map[string]string
 }
 
 // NewHistory returns the pointer to a new History struct
@@ -38,17 +44,109 @@ func NewHistory(path string, maxSize int) (*History, error) {
 			return nil, fmtError(err)
 		}
 	}
-	// Split lines and limit the maximum number of lines
-	lines := strings.Split(strings.Trim(string(data), "\n"), "\n")
-	if len(lines[len(lines)-1]) > 0 {
-		lines = append(lines, "")
+	saved := historyEntries(data)
+	lines := append(append([]string{}, saved...), "")
+	filters, err := loadFilters(filtersPath(path))
+	if err != nil {
+		return nil, fmtError(err)
 	}
 	return &History{
 		path:     path,
 		maxSize:  maxSize,
 		lines:    lines,
+		saved:    saved,
 		modified: make(map[int]string),
-		cursor:   len(lines) - 1}, nil
+		cursor:   len(lines) - 1,
+		filters:  filters}, nil
+}
+
+// historyEntries splits the content of the history file into the entries
+func historyEntries(data []byte) []string {
+	lines := strings.Split(strings.Trim(string(data), "\n"), "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// filtersPath returns the path of the file where the named filters are saved
+// along with the history
+func filtersPath(path string) string {
+	return path + ".filters"
+}
+
+// loadFilters reads the named filters written as NAME<TAB>QUERY lines
+func loadFilters(path string) (map[string]string, error) {
+	filters := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return filters, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if tokens := strings.SplitN(line, "\t", 2); len(tokens) == 2 && len(tokens[0]) > 0 {
+			filters[tokens[0]] = tokens[1]
+		}
+	}
+	return filters, nil
+}
+
+// saveFilter saves the named filter along with the ones saved by the other
+// processes since the file was read
+func (h *History) saveFilter(name string, query string) error {
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if filters, err := loadFilters(filtersPath(h.path)); err == nil {
+		for name, query := range filters {
+			h.filters[name] = query
+		}
+	}
+	h.filters[name] = query
+	names := make([]string, 0, len(h.filters))
+	for name := range h.filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + "\t" + h.filters[name]
+	}
+	return writeFile(filtersPath(h.path), []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// lock acquires the lock of the history file held by a process while it
+// reads and writes the file
+func (h *History) lock() (func(), error) {
+	return util.LockFile(h.path + ".lock")
+}
+
+// writeFile replaces the file with a temporary file holding the data, so
+// that the other processes never read a partially written file
+func writeFile(path string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
 }
 
 func (h *History) append(line string) error {
@@ -57,12 +155,77 @@ func (h *History) append(line string) error {
 		return nil
 	}
 
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Not to overwrite the entries appended by the other processes
+	h.reload()
+
 	lines := append(h.lines[:len(h.lines)-1], line)
 	if len(lines) > h.maxSize {
 		lines = lines[len(lines)-h.maxSize:]
 	}
 	h.lines = append(lines, "")
-	return ioutil.WriteFile(h.path, []byte(strings.Join(h.lines, "\n")), 0600)
+	h.saved = append([]string{}, lines...)
+	return writeFile(h.path, []byte(strings.Join(h.lines, "\n")))
+}
+
+// reload reads the history file written by the other processes sharing it
+// and returns true if the entries are updated. The file is considered
+// unchanged when it is empty or does not end with a newline, as it can be
+// read while being written by a program not taking the lock.
+func (h *History) reload() bool {
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil || len(data) == 0 || data[len(data)-1] != '\n' {
+		return false
+	}
+	return h.merge(historyEntries(data))
+}
+
+// merge replaces the entries with the ones in the file. The entries dropped
+// from the front of the file as it exceeded the maximum size are found by
+// comparing it with the entries last seen, so that the entry being browsed and
+// the modified ones keep their positions.
+func (h *History) merge(entries []string) bool {
+	dropped := len(h.saved)
+	for k := range h.saved {
+		if len(h.saved)-k <= len(entries) && equalStrings(h.saved[k:], entries[:len(h.saved)-k]) {
+			dropped = k
+			break
+		}
+	}
+	if dropped == 0 && len(entries) == len(h.saved) {
+		return false
+	}
+
+	browsing := h.cursor < len(h.lines)-1
+	h.lines = append(append([]string{}, entries...), h.lines[len(h.lines)-1])
+	h.saved = entries
+	modified := make(map[int]string)
+	for idx, str := range h.modified {
+		if idx >= dropped {
+			modified[idx-dropped] = str
+		}
+	}
+	h.modified = modified
+	if browsing {
+		h.cursor = util.Max(0, h.cursor-dropped)
+	} else {
+		h.cursor = len(h.lines) - 1
+	}
+	return true
+}
+
+func equalStrings(a []string, b []string) bool {
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return len(a) == len(b)
 }
 
 func (h *History) override(str string) {
@@ -0,0 +1,51 @@
+// +build linux
+
+package fzf
+
+import (
+	"bytes"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchHistory calls the function whenever the history file is written. The
+// directory is watched as the file may be replaced by another program.
+func watchHistory(path string, changed func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	name := []byte(filepath.Base(path))
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	go func() {
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			} else if err != nil || n <= 0 {
+				return
+			}
+			found := false
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				offset += unix.SizeofInotifyEvent
+				end := offset + int(event.Len)
+				if end > n {
+					break
+				}
+				found = found || bytes.Equal(bytes.TrimRight(buf[offset:end], "\x00"), name)
+				offset = end
+			}
+			if found {
+				changed()
+			}
+		}
+	}()
+	return nil
+}
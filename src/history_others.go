@@ -0,0 +1,30 @@
+// +build !linux
+
+package fzf
+
+import (
+	"os"
+	"time"
+)
+
+// watchHistory calls the function whenever the modification time or the size
+// of the history file changes, which is checked periodically
+func watchHistory(path string, changed func()) error {
+	prev, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range time.Tick(historyPollInterval) {
+			stat, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if !stat.ModTime().Equal(prev.ModTime()) || stat.Size() != prev.Size() {
+				prev = stat
+				changed()
+			}
+		}
+	}()
+	return nil
+}
@@ -1,10 +1,13 @@
 package fzf
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestHistory(t *testing.T) {
@@ -66,3 +69,123 @@ func TestHistory(t *testing.T) {
 		compare(maxHistory-1, "foobarbaz")
 	}
 }
+
+func TestHistoryFilters(t *testing.T) {
+	f, _ := ioutil.TempFile("", "fzf-history")
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(filtersPath(f.Name()))
+	defer os.Remove(f.Name() + ".lock")
+
+	{ // Save filters
+		h1, _ := NewHistory(f.Name(), 10)
+		h2, _ := NewHistory(f.Name(), 10)
+		if err := h1.saveFilter("todo", "'TODO !test"); err != nil {
+			t.Error(err)
+		}
+		// The filter saved by the other process is kept
+		if err := h2.saveFilter("go files", ".go$ | .mod$"); err != nil {
+			t.Error(err)
+		}
+	}
+	{ // Read filters
+		h, _ := NewHistory(f.Name(), 10)
+		if len(h.filters) != 2 || h.filters["todo"] != "'TODO !test" || h.filters["go files"] != ".go$ | .mod$" {
+			t.Errorf("Unexpected filters: %v", h.filters)
+		}
+	}
+}
+
+func TestHistorySharing(t *testing.T) {
+	f, _ := ioutil.TempFile("", "fzf-history")
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + ".lock")
+
+	h1, _ := NewHistory(f.Name(), 3)
+	h2, _ := NewHistory(f.Name(), 3)
+	h1.append("foo")
+	h1.append("bar")
+	if !h2.reload() || h2.previous() != "bar" || h2.previous() != "foo" {
+		t.Errorf("%q", h2.lines)
+	}
+
+	// The entry being browsed and the modified ones keep their positions
+	// after the first entry is dropped
+	h2.override("fooo")
+	h2.next()
+	h2.override("baar")
+	h1.append("baz")
+	h1.append("qux")
+	if !h2.reload() || h2.reload() {
+		t.Error("expected to be reloaded once")
+	}
+	if h2.current() != "baar" || h2.previous() != "baar" || h2.next() != "baz" || h2.next() != "qux" || h2.next() != "" {
+		t.Errorf("%q %v", h2.lines, h2.modified)
+	}
+
+	// The entries of the other process are not overwritten
+	h2.append("quux")
+	h1.reload()
+	if len(h1.lines) != 4 || h1.lines[0] != "baz" || h1.lines[2] != "quux" {
+		t.Errorf("%q", h1.lines)
+	}
+}
+
+func TestHistoryConcurrentAppend(t *testing.T) {
+	f, _ := ioutil.TempFile("", "fzf-history")
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + ".lock")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		h, _ := NewHistory(f.Name(), 100)
+		wg.Add(1)
+		go func(i int, h *History) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				h.append(fmt.Sprintf("%d-%d", i, j))
+			}
+		}(i, h)
+	}
+	wg.Wait()
+
+	h, _ := NewHistory(f.Name(), 100)
+	if len(h.lines) != 41 {
+		t.Errorf("entries are lost: %q", h.lines)
+	}
+
+	// A file being written is not taken as the history
+	ioutil.WriteFile(f.Name(), []byte{}, 0600)
+	if h.reload() {
+		t.Error("empty file should be ignored")
+	}
+	ioutil.WriteFile(f.Name(), []byte("0-0\n0-"), 0600)
+	if h.reload() {
+		t.Error("truncated file should be ignored")
+	}
+}
+
+func TestWatchHistory(t *testing.T) {
+	f, _ := ioutil.TempFile("", "fzf-history")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	changed := make(chan bool, 1)
+	if err := watchHistory(f.Name(), func() {
+		select {
+		case changed <- true:
+		default:
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := NewHistory(f.Name(), 10)
+	h.append("foo")
+	select {
+	case <-changed:
+	case <-time.After(3 * historyPollInterval):
+		t.Error("change not notified")
+	}
+}
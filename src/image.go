@@ -0,0 +1,187 @@
+package fzf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/gif"  // Register GIF decoder for the inline images of iTerm2
+	_ "image/jpeg" // Register JPEG decoder for the inline images of iTerm2
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/junegunn/fzf/src/tui"
+	"github.com/junegunn/fzf/src/util"
+)
+
+// Raster attributes of sixel data: "Pan;Pad;Ph;Pv
+var sixelRasterRegexp = regexp.MustCompile(`^"\d+;\d+;(\d+);(\d+)`)
+
+// The number of the base64 characters of an inline image decoded to find its
+// size, which is large enough for the header of a JPEG image with metadata
+const maxImageHeaderLength = 256 * 1024
+
+// parseImage returns the image if the line of the preview output only consists
+// of the sequences of an image protocol
+func parseImage(line string) (tui.Image, bool) {
+	if image, ok := parseSixel(line); ok {
+		return image, true
+	}
+	if image, ok := parseITerm2(line); ok {
+		return image, true
+	}
+	return parseKitty(line)
+}
+
+// parseSixel returns the image if the line of the preview output only consists
+// of a sixel sequence
+func parseSixel(line string) (tui.Image, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "\x1bP") || !strings.HasSuffix(line, "\x1b\\") {
+		return tui.Image{}, false
+	}
+	// DCS P1;P2;P3 q
+	idx := strings.IndexByte(line, 'q')
+	if idx < 0 || strings.Trim(line[2:idx], "0123456789;") != "" {
+		return tui.Image{}, false
+	}
+	body := line[idx+1 : len(line)-2]
+	image := tui.Image{Data: line}
+	if match := sixelRasterRegexp.FindStringSubmatch(body); match != nil {
+		image.Width, _ = strconv.Atoi(match[1])
+		image.Height, _ = strconv.Atoi(match[2])
+		return image, true
+	}
+	// Without the raster attributes, the size is determined by the number of
+	// the bands of six pixels and the longest band
+	for _, band := range strings.Split(body, "-") {
+		width := 0
+		for _, color := range strings.Split(band, "$") {
+			width = util.Max(width, sixelBandWidth(color))
+		}
+		image.Width = util.Max(image.Width, width)
+		image.Height += 6
+	}
+	return image, true
+}
+
+// sixelBandWidth returns the number of pixels in a row of a band
+func sixelBandWidth(data string) int {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	width := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '#':
+			// Color introducer: #Pc;Pu;Px;Py;Pz
+			for i+1 < len(data) && (isDigit(data[i+1]) || data[i+1] == ';') {
+				i++
+			}
+		case c == '!':
+			// Repeat introducer: !Pn followed by the sixel to repeat
+			j := i + 1
+			for j < len(data) && isDigit(data[j]) {
+				j++
+			}
+			count, _ := strconv.Atoi(data[i+1 : j])
+			width += count
+			i = j
+		case c >= '?' && c <= '~':
+			width++
+		}
+	}
+	return width
+}
+
+// parseKitty returns the image if the line of the preview output only consists
+// of the commands of the kitty graphics protocol transmitting and displaying
+// an image whose size is known
+func parseKitty(line string) (tui.Image, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	commands := tui.KittyCommandRegexp.FindAllStringSubmatchIndex(line, -1)
+	if len(commands) == 0 || commands[0][0] > 0 || commands[len(commands)-1][1] < len(line) {
+		return tui.Image{}, false
+	}
+	for i := 1; i < len(commands); i++ {
+		if commands[i][0] != commands[i-1][1] {
+			return tui.Image{}, false
+		}
+	}
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(line[commands[0][2]:commands[0][3]], ",") {
+		if tokens := strings.SplitN(pair, "=", 2); len(tokens) == 2 {
+			keys[tokens[0]] = tokens[1]
+		}
+	}
+	if keys["a"] != "T" {
+		return tui.Image{}, false
+	}
+	number := func(key string) int {
+		value, _ := strconv.Atoi(keys[key])
+		return value
+	}
+	image := tui.Image{Protocol: tui.ImageKitty, Data: line,
+		Width: number("s"), Height: number("v"), Cols: number("c"), Rows: number("r")}
+	if (image.Cols == 0 || image.Rows == 0) && (image.Width == 0 || image.Height == 0) {
+		// The size of a PNG image directly transmitted can be found in its header
+		direct := keys["t"] == "" || keys["t"] == "d"
+		if keys["f"] != "100" || !direct || keys["o"] != "" || commands[0][4] < 0 {
+			return tui.Image{}, false
+		}
+		payload := line[commands[0][4]:commands[0][5]]
+		data, err := base64.StdEncoding.DecodeString(payload[:len(payload)/4*4])
+		if err != nil {
+			return tui.Image{}, false
+		}
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return tui.Image{}, false
+		}
+		image.Width = config.Width
+		image.Height = config.Height
+	}
+	return image, true
+}
+
+// parseITerm2 returns the inline image of iTerm2 if the line of the preview
+// output only consists of it. The size is taken from the width and height
+// arguments in cells, or from the header of the image.
+func parseITerm2(line string) (tui.Image, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	match := tui.ITerm2FileRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return tui.Image{}, false
+	}
+	args := make(map[string]string)
+	for _, arg := range strings.Split(match[1], ";") {
+		if tokens := strings.SplitN(arg, "=", 2); len(tokens) == 2 {
+			args[tokens[0]] = tokens[1]
+		}
+	}
+	if args["inline"] != "1" {
+		return tui.Image{}, false
+	}
+	img := tui.Image{Protocol: tui.ImageITerm2, Data: line}
+	// Width and height in cells are given as plain numbers
+	img.Cols, _ = strconv.Atoi(args["width"])
+	img.Rows, _ = strconv.Atoi(args["height"])
+	if img.Cols == 0 || img.Rows == 0 {
+		payload := match[2]
+		if len(payload) > maxImageHeaderLength {
+			payload = payload[:maxImageHeaderLength]
+		}
+		data, err := base64.StdEncoding.DecodeString(payload[:len(payload)/4*4])
+		if err != nil {
+			return tui.Image{}, false
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return tui.Image{}, false
+		}
+		img.Cols, img.Rows = 0, 0
+		img.Width = config.Width
+		img.Height = config.Height
+	}
+	return img, true
+}
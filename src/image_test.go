@@ -0,0 +1,98 @@
+package fzf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/junegunn/fzf/src/tui"
+)
+
+func TestParseSixel(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		ok     bool
+		width  int
+		height int
+	}{
+		{"\x1bPq\"1;1;40;30#0;2;100;0;0#0!40~-!40~\x1b\\\n", true, 40, 30},
+		{"\x1bP0;0;8q\"1;1;16;12#0!16~\x1b\\", true, 16, 12},
+		// Size from the bands
+		{"\x1bPq#0;2;0;0;0#0!10~$#1~~~-#0??~\x1b\\", true, 10, 12},
+		{"\x1bPq#0!10~", false, 0, 0},
+		{"\x1b[31mfoo\x1b[m", false, 0, 0},
+		{"foo \x1bPq#0!10~\x1b\\", false, 0, 0},
+		{"\x1bP$qm\x1b\\", false, 0, 0},
+	} {
+		image, ok := parseSixel(test.line)
+		if ok != test.ok || image.Width != test.width || image.Height != test.height {
+			t.Errorf("%q: expected %v %dx%d, got %v %dx%d",
+				test.line, test.ok, test.width, test.height, ok, image.Width, image.Height)
+		}
+	}
+}
+
+func TestParseKitty(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+	half := len(payload) / 8 * 4
+
+	for _, test := range []struct {
+		line       string
+		ok         bool
+		width      int
+		height     int
+		cols, rows int
+	}{
+		{"\x1b_Ga=T,f=100;" + payload + "\x1b\\", true, 30, 20, 0, 0},
+		{"\x1b_Ga=T,f=100,m=1;" + payload[:half] + "\x1b\\\x1b_Gm=0;" + payload[half:] + "\x1b\\\n", true, 30, 20, 0, 0},
+		{"\x1b_Ga=T,f=24,s=10,v=5;AAAA\x1b\\", true, 10, 5, 0, 0},
+		{"\x1b_Ga=T,f=100,t=f,c=8,r=4;L3RtcC9pbWFnZS5wbmc=\x1b\\", true, 0, 0, 8, 4},
+		// Size unknown
+		{"\x1b_Ga=T,f=100,t=f;L3RtcC9pbWFnZS5wbmc=\x1b\\", false, 0, 0, 0, 0},
+		// Transmission only
+		{"\x1b_Ga=t,f=24,s=10,v=5;AAAA\x1b\\", false, 0, 0, 0, 0},
+		{"foo\x1b_Ga=T,f=24,s=10,v=5;AAAA\x1b\\", false, 0, 0, 0, 0},
+		{"\x1b_Ga=T,f=24,s=10,v=5;AAAA\x1b\\ \x1b_Gm=0;AAAA\x1b\\", false, 0, 0, 0, 0},
+	} {
+		result, ok := parseImage(test.line)
+		if ok != test.ok || ok && (result.Protocol != tui.ImageKitty || result.Width != test.width ||
+			result.Height != test.height || result.Cols != test.cols || result.Rows != test.rows) {
+			t.Errorf("%q: expected %v %dx%d (%dx%d), got %v %+v",
+				test.line, test.ok, test.width, test.height, test.cols, test.rows, ok, result)
+		}
+	}
+}
+
+func TestParseITerm2(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	for _, test := range []struct {
+		line       string
+		ok         bool
+		width      int
+		height     int
+		cols, rows int
+	}{
+		{"\x1b]1337;File=inline=1:" + payload + "\a", true, 30, 20, 0, 0},
+		{"\x1b]1337;File=name=Zm9v;size=100;inline=1:" + payload + "\x1b\\\n", true, 30, 20, 0, 0},
+		{"\x1b]1337;File=inline=1;width=10;height=5:AAAA\a", true, 0, 0, 10, 5},
+		{"\x1b]1337;File=inline=1;width=50%:" + payload + "\a", true, 30, 20, 0, 0},
+		// Not displayed inline
+		{"\x1b]1337;File=name=Zm9v:" + payload + "\a", false, 0, 0, 0, 0},
+		{"\x1b]1337;File=inline=1:AAAA\a", false, 0, 0, 0, 0},
+		{"foo\x1b]1337;File=inline=1;width=10;height=5:AAAA\a", false, 0, 0, 0, 0},
+	} {
+		result, ok := parseImage(test.line)
+		if ok != test.ok || ok && (result.Protocol != tui.ImageITerm2 || result.Width != test.width ||
+			result.Height != test.height || result.Cols != test.cols || result.Rows != test.rows) {
+			t.Errorf("%q: expected %v %dx%d (%dx%d), got %v %+v",
+				test.line, test.ok, test.width, test.height, test.cols, test.rows, ok, result)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package fzf
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"text/template"
+)
+
+// initShells is the list of the shells supported by --init
+var initShells = []string{"bash", "zsh", "fish"}
+
+// initData is passed to the template of the shell integration script
+type initData struct {
+	Version string
+}
+
+// PrintInit prints the shell integration script for the shell and exits
+func PrintInit(shell string, files fs.FS, version string) {
+	if err := renderInit(os.Stdout, shell, files, version); err != nil {
+		errorExit(err.Error())
+	}
+	os.Exit(exitOk)
+}
+
+// renderInit generates the shell integration script from the template in the
+// shell directory of the file system. The template can include the other
+// scripts in the directory with include function.
+func renderInit(w io.Writer, shell string, files fs.FS, version string) error {
+	include := func(name string) (string, error) {
+		bytes, err := fs.ReadFile(files, path.Join("shell", name))
+		return string(bytes), err
+	}
+	name := "init." + shell + ".tmpl"
+	tmpl, err := template.New(name).Funcs(template.FuncMap{"include": include}).
+		ParseFS(files, path.Join("shell", name))
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, initData{version})
+}
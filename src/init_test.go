@@ -0,0 +1,29 @@
+package fzf
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRenderInit(t *testing.T) {
+	files := fstest.MapFS{
+		"shell/init.bash.tmpl":    {Data: []byte("# fzf {{.Version}}\n{{include \"key-bindings.bash\"}}bind -x '\"\\ei\": fzf-insert-widget'\n")},
+		"shell/key-bindings.bash": {Data: []byte("__fzf_select__() { :; }\n")},
+		"shell/init.zsh.tmpl":     {Data: []byte("{{include \"key-bindings.zsh\"}}")},
+	}
+	var buf bytes.Buffer
+	if err := renderInit(&buf, "bash", files, "0.26.0"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# fzf 0.26.0\n__fzf_select__() { :; }\nbind -x '\"\\ei\": fzf-insert-widget'\n"
+	if buf.String() != expected {
+		t.Errorf("expected: %q, actual: %q", expected, buf.String())
+	}
+	if err := renderInit(&buf, "zsh", files, "0.26.0"); err == nil {
+		t.Error("missing script should be an error")
+	}
+	if err := renderInit(&buf, "fish", files, "0.26.0"); err == nil {
+		t.Error("missing template should be an error")
+	}
+}
@@ -4,12 +4,20 @@ import (
 	"github.com/junegunn/fzf/src/util"
 )
 
-// Item represents each input line. 56 bytes.
+// Item represents each input line. 64 bytes.
 type Item struct {
 	text        util.Chars    // 32 = 24 + 1 + 1 + 2 + 4
-	transformed *[]Token      // 8
+	transformed *transformed  // 8
 	origText    *[]byte       // 8
 	colors      *[]ansiOffset // 8
+	search      *util.Chars   // 8
+}
+
+// transformed is the tokens of the item for --nth computed for the revision
+// of the fields, which is changed by change-nth and change-delimiter actions
+type transformed struct {
+	revision int
+	tokens   []Token
 }
 
 // Index returns ordinal index of the Item
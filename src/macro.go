@@ -0,0 +1,79 @@
+package fzf
+
+import "github.com/junegunn/fzf/src/tui"
+
+// keyMacro is the key events recorded by record-macro to be replayed by
+// replay-macro. The keys of a chord are recorded together once the chord is
+// complete, so that the keys triggering the actions on the macro itself are
+// left out.
+type keyMacro struct {
+	recording bool
+	events    []tui.Event // Events of the macro
+	partial   []tui.Event // Events of the key sequence being processed
+	pending   []tui.Event // Events to replay
+}
+
+// recordable tells if the event is a key or a mouse event of the user. The
+// pasted text is not kept by the event.
+func recordable(event tui.Event) bool {
+	switch event.Type {
+	case tui.Invalid, tui.Resize, tui.Paste, tui.FocusGained, tui.FocusLost:
+		return false
+	}
+	return true
+}
+
+// record records the event if recording. The events of the previous key
+// sequence are added to the macro unless a chord is pending.
+func (m *keyMacro) record(event tui.Event, chord bool) {
+	if !m.recording || !recordable(event) {
+		return
+	}
+	if !chord {
+		m.events = append(m.events, m.partial...)
+		m.partial = nil
+	}
+	m.partial = append(m.partial, event)
+}
+
+// start starts recording a new macro
+func (m *keyMacro) start() {
+	m.recording = true
+	m.events = nil
+	m.partial = nil
+}
+
+// stop stops recording. It returns false if not recording.
+func (m *keyMacro) stop() bool {
+	if !m.recording {
+		return false
+	}
+	m.recording = false
+	m.partial = nil
+	return true
+}
+
+// replay schedules the events of the macro to be processed before the next
+// input. It returns false if there is nothing to replay, or while recording
+// a macro.
+func (m *keyMacro) replay() bool {
+	if m.recording {
+		m.partial = nil
+		return false
+	}
+	if len(m.events) == 0 {
+		return false
+	}
+	m.pending = append(append([]tui.Event{}, m.events...), m.pending...)
+	return true
+}
+
+// next returns the next event to replay
+func (m *keyMacro) next() (tui.Event, bool) {
+	if len(m.pending) == 0 {
+		return tui.Event{}, false
+	}
+	event := m.pending[0]
+	m.pending = m.pending[1:]
+	return event, true
+}
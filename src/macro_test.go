@@ -0,0 +1,50 @@
+package fzf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/junegunn/fzf/src/tui"
+)
+
+func TestKeyMacro(t *testing.T) {
+	key := func(char rune) tui.Event {
+		return tui.Event{Type: tui.Rune, Char: char}
+	}
+	m := keyMacro{}
+	m.record(key('a'), false)
+	if len(m.events) > 0 || len(m.partial) > 0 || m.replay() {
+		t.Error("Recorded without record-macro")
+	}
+
+	// ctrl-r starts recording, ctrl-x>s stops it
+	m.start()
+	m.record(key('a'), false)
+	m.record(tui.Resize.AsEvent(), false)
+	m.record(tui.CtrlX.AsEvent(), false)
+	m.record(key('b'), true)
+	m.record(tui.CtrlX.AsEvent(), false)
+	if m.replay() {
+		t.Error("Replayed while recording")
+	}
+	m.record(tui.CtrlX.AsEvent(), false)
+	m.record(key('s'), true)
+	if !m.stop() || m.stop() || m.recording {
+		t.Error("Not stopped")
+	}
+	expected := []tui.Event{key('a'), tui.CtrlX.AsEvent(), key('b')}
+	if !reflect.DeepEqual(m.events, expected) {
+		t.Errorf("Unexpected events: %v", m.events)
+	}
+
+	if !m.replay() || !m.replay() {
+		t.Error("Not replayed")
+	}
+	replayed := []tui.Event{}
+	for event, ok := m.next(); ok; event, ok = m.next() {
+		replayed = append(replayed, event)
+	}
+	if !reflect.DeepEqual(replayed, append(expected, expected...)) {
+		t.Errorf("Unexpected events: %v", replayed)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/junegunn/fzf/src/util"
@@ -17,6 +18,7 @@ type MatchRequest struct {
 	final      bool
 	sort       bool
 	clearCache bool
+	silent     bool
 }
 
 // Matcher is responsible for performing search
@@ -27,19 +29,42 @@ type Matcher struct {
 	eventBox       *util.EventBox
 	reqBox         *util.EventBox
 	partitions     int
+	sortSlots      chan bool
+	diskSort       int
+	diskSortDir    string
+	trigramMin     int // Minimum number of items to use the trigram index
 	slab           []*util.Slab
 	mergerCache    map[string]*Merger
+	stopped        chan bool
+}
+
+// completionRequest represents a search request from the listen server whose
+// result is handed to the callback in the matcher goroutine
+type completionRequest struct {
+	chunks   []*Chunk
+	pattern  *Pattern
+	callback func(*Merger)
 }
 
 const (
 	reqRetry util.EventType = iota
 	reqReset
+	reqComplete
+	reqStop
 )
 
 // NewMatcher returns a new Matcher
 func NewMatcher(patternBuilder func([]rune) *Pattern,
-	sort bool, tac bool, eventBox *util.EventBox) *Matcher {
+	sort bool, tac bool, eventBox *util.EventBox, threads int, lowPrioSort bool, diskSort int, diskSortDir string, trigramMin int) *Matcher {
 	partitions := util.Min(numPartitionsMultiplier*runtime.NumCPU(), maxPartitions)
+	if threads > 0 {
+		partitions = util.Min(threads, maxPartitions)
+	}
+	// Only one partition is sorted at a time in low-priority mode
+	var sortSlots chan bool
+	if lowPrioSort {
+		sortSlots = make(chan bool, 1)
+	}
 	return &Matcher{
 		patternBuilder: patternBuilder,
 		sort:           sort,
@@ -47,35 +72,64 @@ func NewMatcher(patternBuilder func([]rune) *Pattern,
 		eventBox:       eventBox,
 		reqBox:         util.NewEventBox(),
 		partitions:     partitions,
+		sortSlots:      sortSlots,
+		diskSort:       diskSort,
+		diskSortDir:    diskSortDir,
+		trigramMin:     trigramMin,
 		slab:           make([]*util.Slab, partitions),
-		mergerCache:    make(map[string]*Merger)}
+		mergerCache:    make(map[string]*Merger),
+		stopped:        make(chan bool)}
 }
 
 // Loop puts Matcher in action
 func (m *Matcher) Loop() {
+	defer close(m.stopped)
 	prevCount := 0
+	var completion *completionRequest
 
 	for {
 		var request MatchRequest
+		stop := false
 
 		m.reqBox.Wait(func(events *util.Events) {
-			for _, val := range *events {
+			for evt, val := range *events {
+				if evt == reqStop {
+					stop = true
+					continue
+				}
 				switch val := val.(type) {
 				case MatchRequest:
 					request = val
+				case completionRequest:
+					completion = &val
 				default:
 					panic(fmt.Sprintf("Unexpected type: %T", val))
 				}
 			}
 			events.Clear()
 		})
+		if stop {
+			return
+		}
 
-		if request.sort != m.sort || request.clearCache {
+		if request.pattern != nil && (request.sort != m.sort || request.clearCache) {
 			m.sort = request.sort
 			m.mergerCache = make(map[string]*Merger)
 			clearChunkCache()
 		}
 
+		if completion != nil {
+			// Retried on the next iteration if interrupted by a new search
+			if merger, cancelled := m.scan(MatchRequest{
+				chunks: completion.chunks, pattern: completion.pattern, silent: true}); !cancelled {
+				completion.callback(merger)
+				completion = nil
+			}
+		}
+		if request.pattern == nil {
+			continue
+		}
+
 		// Restart search
 		patternString := request.pattern.AsString()
 		var merger *Merger
@@ -130,11 +184,6 @@ func (m *Matcher) sliceChunks(chunks []*Chunk) [][]*Chunk {
 	return slices
 }
 
-type partialResult struct {
-	index   int
-	matches []Result
-}
-
 func (m *Matcher) scan(request MatchRequest) (*Merger, bool) {
 	startedAt := time.Now()
 
@@ -149,76 +198,145 @@ func (m *Matcher) scan(request MatchRequest) (*Merger, bool) {
 
 	cancelled := util.NewAtomicBool(false)
 
+	// Each worker writes its results to its own slot and only increments the
+	// shared counter of the scanned chunks, so that the workers do not
+	// contend with each other. The results are merged when all of them are
+	// done.
 	slices := m.sliceChunks(request.chunks)
 	numSlices := len(slices)
-	resultChan := make(chan partialResult, numSlices)
-	countChan := make(chan int, numChunks)
+	partialResults := make([][]Result, numSlices)
+	partialRuns := make([][]*sortedRun, numSlices)
+	var scanned int32
 	waitGroup := sync.WaitGroup{}
 
+	// When there are too many matches, each partition sorts them in runs of
+	// this size and writes the runs to disk instead of keeping them in memory
+	runSize := 0
+	if m.sort && m.diskSort > 0 {
+		runSize = util.Max(m.diskSort/numSlices, diskSortRunMin)
+	}
+	// The trigram index is only worth building for large inputs
+	useIndex := (len(pattern.trigrams) > 0 || pattern.mask != 0) && m.trigramMin > 0 && CountItems(request.chunks) >= m.trigramMin
+
+	firstChunk := 0
 	for idx, chunks := range slices {
 		waitGroup.Add(1)
 		if m.slab[idx] == nil {
 			m.slab[idx] = util.MakeSlab(slab16Size, slab32Size)
 		}
-		go func(idx int, slab *util.Slab, chunks []*Chunk) {
+		go func(idx int, slab *util.Slab, chunks []*Chunk, firstChunk int) {
 			defer func() { waitGroup.Done() }()
+			sortMatches := func(matches []Result) {
+				if m.sortSlots != nil {
+					m.sortSlots <- true
+					defer func() { <-m.sortSlots }()
+				}
+				if m.tac {
+					sort.Sort(ByRelevanceTac(matches))
+				} else {
+					sort.Sort(ByRelevance(matches))
+				}
+			}
+			collect := func(allMatches [][]Result, count int) []Result {
+				sliceMatches := make([]Result, 0, count)
+				for _, matches := range allMatches {
+					sliceMatches = append(sliceMatches, matches...)
+				}
+				return sliceMatches
+			}
+			var spill *spillFile
+			spillSize := runSize
 			count := 0
 			allMatches := make([][]Result, len(chunks))
-			for idx, chunk := range chunks {
-				matches := request.pattern.Match(chunk, slab)
-				allMatches[idx] = matches
+			start := 0
+			for chunkIdx, chunk := range chunks {
+				var matches []Result
+				if !useIndex || chunk.mayMatch(pattern.trigrams, pattern.mask) {
+					matches = pattern.Match(chunk, slab)
+				}
+				allMatches[chunkIdx] = matches
 				count += len(matches)
 				if cancelled.Get() {
 					return
 				}
-				countChan <- len(matches)
-			}
-			sliceMatches := make([]Result, 0, count)
-			for _, matches := range allMatches {
-				sliceMatches = append(sliceMatches, matches...)
+				atomic.AddInt32(&scanned, 1)
+
+				if spillSize > 0 && count >= spillSize {
+					var err error
+					if spill == nil {
+						spill, err = newSpillFile(m.diskSortDir)
+					}
+					var run *sortedRun
+					if err == nil {
+						runMatches := collect(allMatches[start:chunkIdx+1], count)
+						sortMatches(runMatches)
+						run, err = spill.writeRun(runMatches, chunks[start:chunkIdx+1], firstChunk+start)
+					}
+					if err != nil {
+						// Keep the matches in memory
+						if spill != nil && spill.runs == 0 {
+							spill.close()
+						}
+						spillSize = 0
+						continue
+					}
+					partialRuns[idx] = append(partialRuns[idx], run)
+					for i := start; i <= chunkIdx; i++ {
+						allMatches[i] = nil
+					}
+					start = chunkIdx + 1
+					count = 0
+				}
 			}
+			sliceMatches := collect(allMatches[start:], count)
 			if m.sort {
-				if m.tac {
-					sort.Sort(ByRelevanceTac(sliceMatches))
-				} else {
-					sort.Sort(ByRelevance(sliceMatches))
-				}
+				sortMatches(sliceMatches)
 			}
-			resultChan <- partialResult{idx, sliceMatches}
-		}(idx, m.slab[idx], chunks)
+			partialResults[idx] = sliceMatches
+		}(idx, m.slab[idx], chunks, firstChunk)
+		firstChunk += len(chunks)
 	}
 
-	wait := func() bool {
-		cancelled.Set(true)
+	done := make(chan bool)
+	go func() {
 		waitGroup.Wait()
-		return true
-	}
-
-	count := 0
-	matchCount := 0
-	for matchesInChunk := range countChan {
-		count++
-		matchCount += matchesInChunk
+		close(done)
+	}()
 
-		if count == numChunks {
-			break
+	ticker := time.NewTicker(scanPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			merger := NewMerger(pattern, partialResults, m.sort, m.tac)
+			for _, runs := range partialRuns {
+				merger.addRuns(runs, request.chunks)
+			}
+			return merger, false
+		case <-ticker.C:
 		}
 
-		if m.reqBox.Peek(reqReset) {
-			return nil, wait()
+		if m.reqBox.Peek(reqReset) || m.reqBox.Peek(reqStop) {
+			cancelled.Set(true)
+			<-done
+			for _, runs := range partialRuns {
+				for _, run := range runs {
+					run.discard()
+				}
+			}
+			return nil, true
 		}
 
-		if time.Since(startedAt) > progressMinDuration {
-			m.eventBox.Set(EvtSearchProgress, float32(count)/float32(numChunks))
+		if !request.silent && time.Since(startedAt) > progressMinDuration {
+			m.eventBox.Set(EvtSearchProgress, float32(atomic.LoadInt32(&scanned))/float32(numChunks))
 		}
 	}
+}
 
-	partialResults := make([][]Result, numSlices)
-	for range slices {
-		partialResult := <-resultChan
-		partialResults[partialResult.index] = partialResult.matches
-	}
-	return NewMerger(pattern, partialResults, m.sort, m.tac), false
+// Stop interrupts the ongoing search and waits until Loop returns
+func (m *Matcher) Stop() {
+	m.reqBox.Set(reqStop, nil)
+	<-m.stopped
 }
 
 // Reset is called to interrupt/signal the ongoing search
@@ -231,5 +349,12 @@ func (m *Matcher) Reset(chunks []*Chunk, patternRunes []rune, cancel bool, final
 	} else {
 		event = reqRetry
 	}
-	m.reqBox.Set(event, MatchRequest{chunks, pattern, final, sort && pattern.sortable, clearCache})
+	m.reqBox.Set(event, MatchRequest{chunks, pattern, final, sort && pattern.sortable, clearCache, false})
+}
+
+// Complete requests a search whose result is passed to the callback without
+// affecting the result of the ongoing search
+func (m *Matcher) Complete(chunks []*Chunk, patternRunes []rune, callback func(*Merger)) {
+	pattern := m.patternBuilder(patternRunes)
+	m.reqBox.Set(reqComplete, completionRequest{chunks, pattern, callback})
 }
@@ -0,0 +1,111 @@
+package fzf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestMatcherScan(t *testing.T) {
+	criteria := []criterion{byScore, byLength}
+	var index int32
+	cl := NewChunkList(func(item *Item, s []byte) bool {
+		item.text = util.ToChars(s)
+		item.text.Index = index
+		index++
+		return true
+	})
+	for i := 0; i < 10000; i++ {
+		cl.Push([]byte(fmt.Sprintf("item-%d", i)))
+	}
+	chunks, _ := cl.Snapshot()
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Criteria: criteria, Prefilter: true, Typos: 1}, []rune("99"))
+
+	scan := func(threads int) *Merger {
+		matcher := NewMatcher(nil, true, false, util.NewEventBox(), threads, false, 0, "", 0)
+		merger, cancelled := matcher.scan(MatchRequest{chunks: chunks, pattern: pattern, sort: true})
+		if cancelled {
+			t.Fatal("scan should not be cancelled")
+		}
+		return merger
+	}
+	expected := scan(1)
+	if expected.Length() == 0 {
+		t.Fatal("Expected matches")
+	}
+	for _, threads := range []int{4, 32} {
+		merger := scan(threads)
+		if merger.Length() != expected.Length() {
+			t.Fatalf("%d threads: %d != %d", threads, merger.Length(), expected.Length())
+		}
+		for i := 0; i < merger.Length(); i++ {
+			if merger.Get(i).item.text.ToString() != expected.Get(i).item.text.ToString() {
+				t.Errorf("%d threads: #%d differs", threads, i)
+				break
+			}
+		}
+	}
+}
+
+func TestMatcherDiskSort(t *testing.T) {
+	criteria := []criterion{byScore, byLength}
+	var index int32
+	cl := NewChunkList(func(item *Item, s []byte) bool {
+		item.text = util.ToChars(s)
+		item.text.Index = index
+		index++
+		return true
+	})
+	for i := 0; i < 10000; i++ {
+		cl.Push([]byte(fmt.Sprintf("item-%d", i)))
+	}
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Criteria: criteria, Prefilter: true, Typos: 1}, []rune("1"))
+
+	dir := t.TempDir()
+	scan := func(chunks []*Chunk, threads int, diskSort int) *Merger {
+		matcher := NewMatcher(nil, true, false, util.NewEventBox(), threads, false, diskSort, dir, 0)
+		merger, cancelled := matcher.scan(MatchRequest{chunks: chunks, pattern: pattern, sort: true})
+		if cancelled {
+			t.Fatal("scan should not be cancelled")
+		}
+		return merger
+	}
+	check := func(chunks []*Chunk) {
+		expected := scan(chunks, 1, 0)
+		if expected.Length() <= 2*diskSortRunMin {
+			t.Fatalf("Expected more than %d matches", 2*diskSortRunMin)
+		}
+		for _, threads := range []int{1, 2} {
+			merger := scan(chunks, threads, diskSortRunMin)
+			runs := append([]*sortedRun{}, merger.runs...)
+			if len(runs) == 0 {
+				t.Errorf("%d threads: expected sorted runs on disk", threads)
+			}
+			if merger.Length() != expected.Length() {
+				t.Fatalf("%d threads: %d != %d", threads, merger.Length(), expected.Length())
+			}
+			for i := 0; i < merger.Length(); i++ {
+				if merger.Get(i).item.text.ToString() != expected.Get(i).item.text.ToString() {
+					t.Errorf("%d threads: #%d differs: %s != %s", threads, i,
+						merger.Get(i).item.text.ToString(), expected.Get(i).item.text.ToString())
+					break
+				}
+			}
+			for _, run := range runs {
+				if run.spill.file != nil {
+					t.Errorf("%d threads: spill file should be closed after the merge", threads)
+					break
+				}
+			}
+		}
+	}
+	chunks, _ := cl.Snapshot()
+	check(chunks)
+
+	// The indexes of the items are no longer contiguous
+	cl.Retain(func(item *Item) bool { return item.Index()%7 != 3 })
+	chunks, _ = cl.Snapshot()
+	check(chunks)
+}
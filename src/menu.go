@@ -0,0 +1,104 @@
+package fzf
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/junegunn/fzf/src/tui"
+)
+
+// menuEntry is an operation on the current item listed in the context menu
+type menuEntry struct {
+	label   string
+	key     rune // Shortcut key, or 0 if none is left for the entry
+	spec    string
+	actions []action
+}
+
+// contextMenu is the open context menu with the cursor on one of the entries
+type contextMenu struct {
+	cursor int
+}
+
+// parseMenuEntry parses LABEL:ACTIONS. The shortcut key of the entry is the
+// first letter or digit of the label not taken by the previous entries.
+func parseMenuEntry(str string, prev []menuEntry) menuEntry {
+	masked := maskActionList(str)
+	pair := strings.SplitN(masked, ":", 2)
+	if len(pair) < 2 || len(strings.TrimSpace(pair[0])) == 0 || len(pair[1]) == 0 {
+		errorExit("invalid menu entry (expected: LABEL:ACTIONS): " + str)
+	}
+	label := str[:len(pair[0])]
+	spec := str[len(pair[0])+1:]
+	entry := menuEntry{label: label, spec: spec, actions: parseActionList(pair[1], spec, nil, errorExit)}
+
+	taken := make(map[rune]bool)
+	for _, e := range prev {
+		taken[e.key] = true
+	}
+	for _, r := range strings.ToLower(label) {
+		if (unicode.IsLetter(r) || unicode.IsDigit(r)) && !taken[r] {
+			entry.key = r
+			break
+		}
+	}
+	return entry
+}
+
+// openMenu opens the context menu for the current item. It returns false if
+// there is no entry or no current item.
+func (t *Terminal) openMenu() bool {
+	if len(t.menu) == 0 || t.currentItem() == nil {
+		return false
+	}
+	t.menuOpen = &contextMenu{}
+	return true
+}
+
+// menuKey handles the key pressed while the context menu is open. The arrow
+// keys move the cursor, and enter or the shortcut key of an entry closes the
+// menu and returns the entry picked. Any other key closes the menu, and it
+// returns false unless the key is one of the keys to cancel.
+func (t *Terminal) menuKey(event tui.Event) (*menuEntry, bool) {
+	n := len(t.menu)
+	switch event.Type {
+	case tui.Up, tui.CtrlP, tui.CtrlK, tui.BTab:
+		t.menuOpen.cursor = (t.menuOpen.cursor + n - 1) % n
+		return nil, true
+	case tui.Down, tui.CtrlN, tui.CtrlJ, tui.Tab:
+		t.menuOpen.cursor = (t.menuOpen.cursor + 1) % n
+		return nil, true
+	case tui.CtrlM:
+		entry := &t.menu[t.menuOpen.cursor]
+		t.menuOpen = nil
+		return entry, true
+	case tui.Rune:
+		key := unicode.ToLower(event.Char)
+		for idx := range t.menu {
+			if t.menu[idx].key == key {
+				t.menuOpen = nil
+				return &t.menu[idx], true
+			}
+		}
+	}
+	t.menuOpen = nil
+	switch event.Type {
+	case tui.ESC, tui.CtrlC, tui.CtrlG, tui.CtrlQ:
+		return nil, true
+	}
+	return nil, false
+}
+
+// printMenuPopup shows the entries of the context menu in the popup with the
+// cursor on the current one
+func (t *Terminal) printMenuPopup() {
+	hints := make([]bindingHint, len(t.menu))
+	for idx, entry := range t.menu {
+		key := ""
+		if entry.key > 0 {
+			key = string(entry.key)
+		}
+		hints[idx] = bindingHint{key: key, desc: entry.label}
+	}
+	t.printPopup(" Menu ", hints, t.menuOpen.cursor)
+}
@@ -13,6 +13,8 @@ type Merger struct {
 	merged  []Result
 	chunks  *[]*Chunk
 	cursors []int
+	runs    []*sortedRun // Sorted lists on disk
+	items   []*Chunk     // Chunks of the items in the runs
 	sorted  bool
 	tac     bool
 	final   bool
@@ -53,6 +55,17 @@ func NewMerger(pattern *Pattern, lists [][]Result, sorted bool, tac bool) *Merge
 	return &mg
 }
 
+// addRuns adds the sorted runs on disk to the lists to merge
+func (mg *Merger) addRuns(runs []*sortedRun, chunks []*Chunk) {
+	mg.items = chunks
+	for _, run := range runs {
+		mg.count += run.count
+		if run.next(chunks) {
+			mg.runs = append(mg.runs, run)
+		}
+	}
+}
+
 // Length returns the number of items
 func (mg *Merger) Length() int {
 	return mg.count
@@ -108,7 +121,21 @@ func (mg *Merger) mergedGet(idx int) Result {
 			}
 		}
 
-		if minIdx >= 0 {
+		minRun := -1
+		for runIdx, run := range mg.runs {
+			if run != nil && (minIdx < 0 && minRun < 0 || compareRanks(run.head, minRank, mg.tac)) {
+				minRank = run.head
+				minRun = runIdx
+			}
+		}
+
+		if minRun >= 0 {
+			run := mg.runs[minRun]
+			mg.merged = append(mg.merged, run.head)
+			if !run.next(mg.items) {
+				mg.runs[minRun] = nil
+			}
+		} else if minIdx >= 0 {
 			chosen := mg.lists[minIdx]
 			mg.merged = append(mg.merged, chosen[mg.cursors[minIdx]])
 			mg.cursors[minIdx]++
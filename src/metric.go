@@ -0,0 +1,121 @@
+package fzf
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// The characters for the fractional part of the bar in eighths
+var barEighths = []string{"▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// metricBar renders the numeric value in the fields of each item as a bar
+// scaled to the largest value of the items. The reader records the values
+// while the terminal draws the bars.
+type metricBar struct {
+	mutex     sync.RWMutex
+	fields    []Range
+	limit     int // Number of the tokens needed for the fields, or 0 for all
+	delimiter Delimiter
+	width     int
+	unicode   bool
+	max       float64
+}
+
+func newMetricBar(fields []Range, delimiter Delimiter, width int, unicode bool) *metricBar {
+	return &metricBar{fields: fields, limit: tokenLimit(fields), delimiter: delimiter, width: width, unicode: unicode}
+}
+
+// tokenLimit returns the number of the leading tokens that contain the
+// fields, so that the rest of the line is not tokenized. It is 0 if the
+// fields are counted from the end of the line.
+func tokenLimit(fields []Range) int {
+	limit := 0
+	for _, field := range fields {
+		if field.begin < 0 || field.end <= 0 {
+			return 0
+		}
+		limit = util.Max(limit, field.end)
+	}
+	return limit
+}
+
+// parseMetric parses the number optionally followed by a percent sign or
+// a binary unit such as 4.0K, 12M, or 3GiB
+func parseMetric(str string) (float64, bool) {
+	str = strings.TrimSuffix(strings.TrimSpace(str), "%")
+	if trimmed := strings.TrimSuffix(str, "B"); len(trimmed) < len(str) {
+		str = strings.TrimSuffix(trimmed, "i")
+	}
+	scale := 1.0
+	if len(str) > 0 {
+		if idx := strings.IndexByte("KMGTP", str[len(str)-1]&^0x20); idx >= 0 {
+			scale = math.Pow(1024, float64(idx+1))
+			str = str[:len(str)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value * scale, true
+}
+
+func (b *metricBar) value(item *Item) (float64, bool) {
+	tokens := tokenizeN(item.AsString(true), b.delimiter, b.limit)
+	_, str := SplitFields(tokens, b.fields, b.delimiter)
+	return parseMetric(str)
+}
+
+// observe updates the scale of the bars with the value of the item
+func (b *metricBar) observe(item *Item) {
+	if value, ok := b.value(item); ok {
+		b.mutex.Lock()
+		b.max = math.Max(b.max, value)
+		b.mutex.Unlock()
+	}
+}
+
+// reset forgets the values of the items before reloading the list
+func (b *metricBar) reset() {
+	b.mutex.Lock()
+	b.max = 0
+	b.mutex.Unlock()
+}
+
+// length returns the number of columns taken by the bars and the space after
+// them
+func (b *metricBar) length() int {
+	if b == nil {
+		return 0
+	}
+	return b.width + 1
+}
+
+// bar returns the bar of the item padded to the width. It is blank if the
+// value is not a positive number.
+func (b *metricBar) bar(item *Item) string {
+	value, ok := b.value(item)
+	b.mutex.RLock()
+	max := b.max
+	b.mutex.RUnlock()
+	if !ok || value <= 0 || max <= 0 {
+		return strings.Repeat(" ", b.width)
+	}
+	ratio := math.Min(value/max, 1)
+	if !b.unicode {
+		cells := int(math.Round(ratio * float64(b.width)))
+		return strings.Repeat("=", cells) + strings.Repeat(" ", b.width-cells)
+	}
+	eighths := int(math.Round(ratio * float64(b.width*8)))
+	bar := strings.Repeat("█", eighths/8)
+	cells := eighths / 8
+	if rest := eighths % 8; rest > 0 {
+		bar += barEighths[rest-1]
+		cells++
+	}
+	return bar + strings.Repeat(" ", b.width-cells)
+}
@@ -0,0 +1,75 @@
+package fzf
+
+import (
+	"testing"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestParseMetric(t *testing.T) {
+	for _, test := range []struct {
+		str   string
+		value float64
+		ok    bool
+	}{
+		{"42", 42, true},
+		{" 12.5% ", 12.5, true},
+		{"-3", -3, true},
+		{"4.0K", 4096, true},
+		{"2M", 2 * 1024 * 1024, true},
+		{"3GiB", 3 * 1024 * 1024 * 1024, true},
+		{"100B", 100, true},
+		{"", 0, false},
+		{"foo", 0, false},
+		{"NaN", 0, false},
+	} {
+		value, ok := parseMetric(test.str)
+		if value != test.value || ok != test.ok {
+			t.Errorf("%q: expected %v (%v), got %v (%v)", test.str, test.value, test.ok, value, ok)
+		}
+	}
+}
+
+func TestMetricBar(t *testing.T) {
+	item := func(line string) *Item {
+		return &Item{text: util.ToChars([]byte(line))}
+	}
+	bars := newMetricBar(splitNth("2"), Delimiter{}, 4, true)
+	for _, line := range []string{"a 8", "b 2", "c foo"} {
+		bars.observe(item(line))
+	}
+	for _, test := range []struct {
+		line string
+		bar  string
+	}{
+		{"a 8", "████"},
+		{"b 2", "█   "},
+		{"b 3", "█▌  "},
+		{"c foo", "    "},
+		{"d 0", "    "},
+		{"e 16", "████"},
+	} {
+		if bar := bars.bar(item(test.line)); bar != test.bar {
+			t.Errorf("%q: expected %q, got %q", test.line, test.bar, bar)
+		}
+	}
+	if bars.length() != 5 || (*metricBar)(nil).length() != 0 {
+		t.Errorf("Unexpected length")
+	}
+
+	bars.unicode = false
+	if bar := bars.bar(item("b 3")); bar != "==  " {
+		t.Errorf("Unexpected bar: %q", bar)
+	}
+	bars.reset()
+	if bar := bars.bar(item("a 8")); bar != "    " {
+		t.Errorf("Unexpected bar after reset: %q", bar)
+	}
+
+	// The line is only tokenized up to the fields
+	for expr, limit := range map[string]int{"2": 2, "1,3": 3, "..2": 2, "2..": 0, "-1": 0} {
+		if actual := tokenLimit(splitNth(expr)); actual != limit {
+			t.Errorf("%s: expected %d, got %d", expr, limit, actual)
+		}
+	}
+}
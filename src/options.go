@@ -2,14 +2,17 @@ package fzf
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/junegunn/fzf/src/algo"
 	"github.com/junegunn/fzf/src/tui"
+	"github.com/junegunn/fzf/src/util"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/mattn/go-shellwords"
@@ -21,15 +24,28 @@ const usage = `usage: fzf [options]
     -x, --extended        Extended-search mode
                           (enabled by default; +x or --no-extended to disable)
     -e, --exact           Enable Exact-match
+    --regex               Match the terms as regular expressions
     --algo=TYPE           Fuzzy matching algorithm: [v1|v2] (default: v2)
     -i                    Case-insensitive match (default: smart-case match)
     +i                    Case-sensitive match
     --literal             Do not normalize latin script letters before matching
+    --unicode-normalize   Normalize the items and the query to NFKC as well
+    --no-prefilter        Do not skip the items lacking the characters of
+                          the query before scoring them
+    --typo-tolerance=N    Number of typos allowed in ~term (default: 1)
+    --fuzzy-typos=N       Number of typos allowed in fuzzy terms (default: 0)
     -n, --nth=N[,..]      Comma-separated list of field index expressions
                           for limiting search scope. Each can be a non-zero
-                          integer or a range expression ([BEGIN]..[END]).
+                          integer or a range expression ([BEGIN]..[END]),
+                          followed by :WEIGHT to weight the matches in it
     --with-nth=N[,..]     Transform the presentation of each line using
-                          field index expressions
+                          field index expressions. Each can be given as
+                          {N|FORMAT} to format the fields for display
+                          (FORMAT: human-size, reltime)
+    --search-field=N[,..] Fields to search without displaying or printing
+    --key-field=N[,..]    Fields identifying the items across reloads
+    --bar-field=N[,..]    Fields of the numbers to display as bars
+    --bar-width=COLS      Width of the bars (default: 10)
     -d, --delimiter=STR   Field delimiter regex (default: AWK-style)
     +s, --no-sort         Do not sort the result
     --tac                 Reverse the order of the input
@@ -37,55 +53,137 @@ const usage = `usage: fzf [options]
     --tiebreak=CRI[,..]   Comma-separated list of sort criteria to apply
                           when the scores are tied [length|begin|end|index]
                           (default: length)
+    --scheme=SCHEME       Scoring scheme [default|path|history|custom:KEY=N,..]
+                          (default: default; path and history only decide
+                          the order of the tied matches)
+    --threads=N           Number of threads for matching (default: auto)
+    --low-priority-sort   Sort the matches on one thread at a time to leave
+                          CPU time for the other processes
+    --disk-sort=N         Sort the matches on disk when there are more than N
+                          of them (default: 10000000, 0 to disable)
+    --disk-sort-dir=DIR   Directory for the temporary files of --disk-sort
+                          (default: cache directory)
+    --trigram-index=N     Skip the chunks of items lacking the exact-match terms
+                          or the characters of the fuzzy terms with the trigram
+                          index when there are N or more items
+                          (default: 1000000, 0 to disable)
+    --cache-dir=DIR       Cache directory (default: $FZF_CACHE_DIR or
+                          $XDG_CACHE_HOME/fzf)
 
   Interface
     -m, --multi[=MAX]     Enable multi-select with tab/shift-tab
     --no-mouse            Disable mouse
+    --hover               Move the cursor to the item under the mouse pointer
+    --click-interval=MS   Maximum interval between the clicks of a double-click
+                          in milliseconds (default: 500)
+    --chord-interval=MS   Maximum interval between the keys of a key chord
+                          in milliseconds (default: 1000)
+    --bell=STYLE          Feedback on rejected actions
+                          [none|audible|visual|flash-border] (default: none)
+    --paste=MODE          How to insert the line breaks of the pasted text
+                          [space|strip|first-line] (default: space)
+    --paste-filter=CMD    Command to transform the pasted text given to its
+                          standard input
     --bind=KEYBINDS       Custom key bindings. Refer to the man page.
+    --leader=KEY          Key to start the bindings given as leader+KEY
+    --menu=LABEL:ACTIONS  Entry of the menu opened by context-menu (repeatable)
     --cycle               Enable cyclic scroll
     --keep-right          Keep the right end of the line visible on overflow
     --no-hscroll          Disable horizontal scroll
     --hscroll-off=COL     Number of screen columns to keep to the right of the
                           highlighted substring (default: 10)
+    --wrap                Wrap the long items across multiple lines
+    --wrap-sign=STR       Indicator for the wrapped lines (default: '↳ ')
     --filepath-word       Make word-wise movements respect path separators
+    --word-boundaries=LIST
+                          Transitions in words taken for the boundaries in
+                          matching and word-wise movements
+                          [camel,snake,digit|none]
     --jump-labels=CHARS   Label characters for jump and jump-accept
+    --accept-all-confirm=N
+                          Confirm before accept-all action accepts more than
+                          N items (default: 1000, 0 to disable)
 
   Layout
     --height=HEIGHT[%]    Display fzf window below the cursor with the given
                           height instead of using fullscreen
+                          (auto[:MIN,MAX[%]] to fit the number of matches)
     --min-height=HEIGHT   Minimum height when --height is given in percent
                           (default: 10)
     --layout=LAYOUT       Choose layout: [default|reverse|reverse-list]
     --border[=STYLE]      Draw border around the finder
-                          [rounded|sharp|horizontal|vertical|
-                           top|bottom|left|right] (default: rounded)
+                          [rounded|sharp|dashed|heavy-dashed|horizontal|
+                           vertical|top|bottom|left|right] (default: rounded)
+                          followed by SIDE:LINE pairs for each side
+    --border-chars=CHARS  Characters for the border clockwise from the top-left
+                          corner (e.g. '┏━┓┃┛━┗┃')
+    --tui=RENDERER        Renderer to use [light|tcell|auto] (default: auto)
+    --tmux-pane=TARGET    Display the finder in an existing tmux pane
+    --no-unicode-autodetect
+                          Do not fall back to simpler border characters
+                          when the terminal cannot display them
+    --border-label=LABEL  Label to print on the border (can be repeated)
+    --border-label-pos=POS
+                          Position of the last border label
+                          [N|N%][:top|:bottom] (default: 0 for center)
+    --shadow              Draw shadow behind the border
+    --scrollbar           Draw scrollbar for the list and the preview window
+    --background-command=COMMAND
+                          Command to draw the background behind the finder
     --margin=MARGIN       Screen margin (TRBL | TB,RL | T,RL,B | T,R,B,L)
     --padding=PADDING     Padding inside border (TRBL | TB,RL | T,RL,B | T,R,B,L)
+                          (N% can be followed by min=N and max=N)
     --info=STYLE          Finder info style [default|inline|hidden]
+    --separator           Draw horizontal line after the info
     --prompt=STR          Input prompt (default: '> ')
     --pointer=STR         Pointer to the current line (default: '>')
     --marker=STR          Multi-select marker (default: '>')
     --header=STR          String to print as header
     --header-lines=N      The first N lines of the input are treated as header
+    --form=FIELDS         Input fields below the header (NAME=VALUE,...)
+    --hint-bar            Cycle through the described bindings below the header
+    --tab=NAME:COMMAND    Tab of the list read from the command (repeatable;
+                          switched with next-tab and prev-tab)
+    --title=TITLE         Title row above the list ({q}, {matches}, {total},
+                          and [TEXT](KEY) to trigger the actions of KEY)
 
   Display
     --ansi                Enable processing of ANSI color codes
+    --ansi-bg=POLICY      Background color of the current line where the item
+                          has its own [item|theme|blend] (default: item)
+    --match-style=STYLE   How to render the matched characters, can be combined
+                          [color|underline|bold|block] (default: color)
     --tabstop=SPACES      Number of spaces for a tab character (default: 8)
+    --ambiguous-width=N   Width of East Asian Ambiguous characters [1|2|auto]
+                          (default: by locale)
+    --emoji-width=N       Width of emoji characters [1|2|auto] (default: 2)
     --color=COLSPEC       Base scheme (dark|light|16|bw) and/or custom colors
     --no-bold             Do not use bold text
 
   History
-    --history=FILE        History file
+    --history[=FILE]      History file (default: history in state directory)
     --history-size=N      Maximum number of history entries (default: 1000)
+    --rank-log[=FILE]     Record the ranks of accepted items to the file
+                          (default: ranks.jsonl in state directory)
+    --state-dir=DIR       State directory (default: $FZF_STATE_DIR or
+                          $XDG_STATE_HOME/fzf)
 
   Preview
     --preview=COMMAND     Command to preview highlighted line ({})
+    --preview-fallback=COMMAND
+                          Command to try when the previous one fails (repeatable)
+    --preview-osc=LIST    Also pass the OSC sequences of the numbers in the
+                          preview output to the terminal (52,1337)
     --preview-window=OPT  Preview window layout (default: right:50%)
                           [up|down|left|right][:SIZE[%]]
                           [:[no]wrap][:[no]cycle][:[no]follow][:[no]hidden]
                           [:rounded|sharp|noborder]
                           [:+SCROLL[-OFFSET]]
                           [:default]
+                          Prepend <COLS(OPT)| for narrower screens
+    --preview-label=LABEL Label to print on the preview window border
+    --preview-label-pos=POS
+                          Position of the preview label [N|N%][:top|:bottom]
 
   Scripting
     -q, --query=STR       Start the finder with the given query
@@ -95,8 +193,27 @@ const usage = `usage: fzf [options]
     --print-query         Print query as the first line
     --expect=KEYS         Comma-separated list of keys to complete fzf
     --read0               Read input delimited by ASCII NUL characters
+    --no-decompress       Do not decompress gzip or zstd input
+    --disabled-prefix=STR Display the lines starting with the prefix as
+                          disabled items that cannot be selected
+    --item-ttl=DURATION   Remove the items not read again within the duration
+                          (e.g. 30s, 5m, or seconds without unit)
+    --source-url=URL      Read input from the HTTP(S) endpoint
+    --source=SOURCE       Read input from the sources concurrently (repeatable)
+                          [stdin|walker|URL|COMMAND]
+    --listen=[ADDR:]PORT  Start HTTP server to serve completion requests
+                          and to perform actions
     --print0              Print output delimited by ASCII NUL characters
     --sync                Synchronous search for multi-staged filtering
+    --render-once[=FMT]   Print the screen after the initial search and exit
+                          [plain|ansi] (default: plain)
+    --on-accept=COMMAND   Command to execute after an item is accepted
+    --on-exit=COMMAND     Command to execute after the finder is closed
+    --log-file=FILE       Append the errors of the actions to the file
+    --dry-run             Show the commands of the actions instead of running
+                          them
+    --init=SHELL          Print the script for the shell integration and exit
+                          [bash|zsh|fish]
     --version             Display version information and exit
 
   Environment variables
@@ -124,11 +241,48 @@ const (
 	byLength
 	byBegin
 	byEnd
+	byPathname
 )
 
+// schemeCriteria returns the sort criteria of the scoring scheme. The default
+// and the custom schemes use the criteria of --tiebreak. The schemes do not
+// change the bonus points of the characters, so the score of a match is the
+// same in every scheme, and only the custom table changes it.
+func schemeCriteria(scheme string, tiebreak []criterion) ([]criterion, bool) {
+	switch scheme {
+	case "default", "custom":
+		return tiebreak, true
+	case "path":
+		// Prefer the matches in the last component of the path
+		return []criterion{byScore, byPathname, byLength}, true
+	case "history":
+		// Keep the order of the input
+		return []criterion{byScore}, true
+	}
+	return nil, false
+}
+
 type sizeSpec struct {
 	size    float64
 	percent bool
+	min     int
+	max     int
+}
+
+// bound limits the size in percent calculated for the terminal to the range
+// given by min=N and max=N. Zero max means no upper limit.
+func (s sizeSpec) bound(size int) int {
+	if s.max > 0 {
+		size = util.Min(size, s.max)
+	}
+	return util.Max(size, s.min)
+}
+
+type heightSpec struct {
+	size    float64
+	percent bool
+	auto    bool
+	min     int
 }
 
 func defaultMargin() [4]sizeSpec {
@@ -152,6 +306,29 @@ const (
 	layoutReverseList
 )
 
+// matchStyle decides how the matched characters are rendered
+type matchStyle struct {
+	color bool
+	attr  tui.Attr
+}
+
+// apply returns the color pair for the matched characters on the line
+// rendered with the base color pair
+func (s matchStyle) apply(colBase tui.ColorPair, colMatch tui.ColorPair) tui.ColorPair {
+	if !s.color {
+		colMatch = colBase
+	}
+	return colMatch.WithAttr(s.attr)
+}
+
+type tuiBackend int
+
+const (
+	tuiAuto tuiBackend = iota
+	tuiLight
+	tuiTcell
+)
+
 type infoStyle int
 
 const (
@@ -160,6 +337,25 @@ const (
 	infoHidden
 )
 
+// renderFormat is the format of the screen printed by --render-once
+type renderFormat int
+
+const (
+	renderNone renderFormat = iota
+	renderPlain
+	renderANSI
+)
+
+// pasteMode decides how the line breaks in the pasted text are inserted into
+// the query
+type pasteMode int
+
+const (
+	pasteSpace pasteMode = iota
+	pasteStrip
+	pasteFirstLine
+)
+
 type previewOpts struct {
 	command  string
 	position windowPosition
@@ -170,6 +366,23 @@ type previewOpts struct {
 	cycle    bool
 	follow   bool
 	border   tui.BorderShape
+	label    labelOpts
+	rules    []previewRule
+}
+
+// previewRule is the alternative layout of the preview window for the screen
+// narrower than the threshold, applied over the default layout
+type previewRule struct {
+	threshold int
+	spec      string
+}
+
+// labelOpts describes the label on the border and its position
+type labelOpts struct {
+	label   string
+	offset  int
+	percent bool
+	bottom  bool
 }
 
 // Options stores the values of command-line options
@@ -177,31 +390,63 @@ type Options struct {
 	Fuzzy       bool
 	FuzzyAlgo   algo.Algo
 	Extended    bool
+	Regex       bool
 	Phony       bool
 	Case        Case
 	Normalize   bool
+	UnicodeNorm bool
+	Prefilter   bool
+	Typos       int
+	FuzzyTypos  int
 	Nth         []Range
 	WithNth     []Range
+	Formats     []displayFormat
+	SearchField []Range
+	KeyField    []Range
+	BarField    []Range
+	BarWidth    int
 	Delimiter   Delimiter
 	Sort        int
 	Tac         bool
+	Threads     int
+	LowPrioSort bool
+	DiskSort    int
+	TrigramMin  int
+	DiskSortDir string
 	Criteria    []criterion
+	Scheme      string
+	Scoring     algo.Scoring
 	Multi       int
 	Ansi        bool
+	AnsiBg      ansiBgPolicy
+	MatchStyle  matchStyle
 	Mouse       bool
+	Hover       bool
+	ClickIntvl  time.Duration
+	ChordIntvl  time.Duration
+	Leader      string
+	Bell        tui.BellStyle
+	Paste       pasteMode
+	PasteFilter string
 	Theme       *tui.ColorTheme
+	NamedColors map[string]tui.Color
 	Black       bool
 	Bold        bool
-	Height      sizeSpec
+	Height      heightSpec
 	MinHeight   int
 	Layout      layoutType
 	Cycle       bool
 	KeepRight   bool
+	Wrap        bool
+	WrapSign    *string
 	Hscroll     bool
 	HscrollOff  int
 	FileWord    bool
+	WordBounds  *algo.Boundaries
 	InfoStyle   infoStyle
+	Separator   bool
 	JumpLabels  string
+	ConfirmAll  int
 	Prompt      string
 	Pointer     string
 	Marker      string
@@ -210,28 +455,68 @@ type Options struct {
 	Exit0       bool
 	Filter      *string
 	ToggleSort  bool
+	ShowScheme  bool
 	Expect      map[tui.Event]string
 	Keymap      map[tui.Event][]action
+	KeyNames    map[tui.Event]string
+	KeySpecs    map[tui.Event]string
+	HintBar     bool
 	Preview     previewOpts
+	Fallbacks   []string
+	PreviewOSCs map[string]bool
 	PrintQuery  bool
 	ReadZero    bool
+	Decompress  bool
+	DisabledPfx string
+	ItemTTL     time.Duration
 	Printer     func(string)
 	PrintSep    string
 	Sync        bool
+	RenderOnce  renderFormat
+	SourceURL   string
+	Listen      string
+	Sources     []string
+	BgCommand   string
+	OnAccept    string
+	OnExit      string
+	LogFile     string
+	DryRun      bool
+	StateDir    string
+	CacheDir    string
+	HistoryFile *string
+	HistoryMax  int
 	History     *History
+	State       *finderState
+	RankLog     *RankLog
 	Header      []string
 	HeaderLines int
+	Form        []formField
+	Tabs        []finderTab
+	Menu        []menuEntry
+	Title       []titleSegment
 	Margin      [4]sizeSpec
 	Padding     [4]sizeSpec
 	BorderShape tui.BorderShape
+	BorderSides tui.BorderSides
+	BorderChars []rune
+	BorderLabel []labelOpts
+	Shadow      bool
+	Scrollbar   bool
 	Unicode     bool
+	UnicodeAuto bool
 	Tabstop     int
+	AmbigWidth  int
+	EmojiWidth  int
 	ClearOnExit bool
+	Tui         tuiBackend
+	Renderer    tui.Renderer
+	TmuxPane    string
+	Init        string
 	Version     bool
 }
 
 func defaultPreviewOpts(command string) previewOpts {
-	return previewOpts{command, posRight, sizeSpec{50, true}, "", false, false, false, false, tui.BorderRounded}
+	return previewOpts{command, posRight, sizeSpec{size: 50, percent: true}, "", false, false, false, false, tui.BorderRounded, labelOpts{}, nil}
 }
 
 func defaultOptions() *Options {
@@ -242,16 +527,34 @@ func defaultOptions() *Options {
 		Phony:       false,
 		Case:        CaseSmart,
 		Normalize:   true,
+		Prefilter:   true,
+		Typos:       1,
 		Nth:         make([]Range, 0),
 		WithNth:     make([]Range, 0),
+		SearchField: make([]Range, 0),
+		KeyField:    make([]Range, 0),
+		BarField:    make([]Range, 0),
+		BarWidth:    10,
 		Delimiter:   Delimiter{},
 		Sort:        1000,
 		Tac:         false,
+		Threads:     0,
+		LowPrioSort: false,
+		DiskSort:    defaultDiskSort,
+		TrigramMin:  defaultTrigramMin,
+		DiskSortDir: "",
 		Criteria:    []criterion{byScore, byLength},
+		Scheme:      "default",
+		Scoring:     algo.DefaultScoring,
 		Multi:       0,
 		Ansi:        false,
+		AnsiBg:      ansiBgItem,
+		MatchStyle:  matchStyle{color: true},
 		Mouse:       true,
+		ClickIntvl:  tui.DefaultClickInterval,
+		ChordIntvl:  chordInterval,
 		Theme:       tui.EmptyTheme(),
+		NamedColors: make(map[string]tui.Color),
 		Black:       false,
 		Bold:        true,
 		MinHeight:   10,
@@ -261,8 +564,10 @@ func defaultOptions() *Options {
 		Hscroll:     true,
 		HscrollOff:  10,
 		FileWord:    false,
+		WordBounds:  nil,
 		InfoStyle:   infoDefault,
 		JumpLabels:  defaultJumpLabels,
+		ConfirmAll:  defaultConfirmAll,
 		Prompt:      "> ",
 		Pointer:     ">",
 		Marker:      ">",
@@ -271,20 +576,38 @@ func defaultOptions() *Options {
 		Exit0:       false,
 		Filter:      nil,
 		ToggleSort:  false,
+		ShowScheme:  false,
 		Expect:      make(map[tui.Event]string),
 		Keymap:      make(map[tui.Event][]action),
+		KeyNames:    make(map[tui.Event]string),
+		KeySpecs:    make(map[tui.Event]string),
+		HintBar:     false,
 		Preview:     defaultPreviewOpts(""),
 		PrintQuery:  false,
 		ReadZero:    false,
+		Decompress:  true,
 		Printer:     func(str string) { fmt.Println(str) },
 		PrintSep:    "\n",
 		Sync:        false,
+		OnAccept:    "",
+		OnExit:      "",
+		LogFile:     "",
+		DryRun:      false,
+		StateDir:    "",
+		CacheDir:    "",
+		HistoryFile: nil,
+		HistoryMax:  defaultHistoryMax,
 		History:     nil,
+		State:       nil,
+		RankLog:     nil,
 		Header:      make([]string, 0),
 		HeaderLines: 0,
+		Form:        []formField{},
+		Title:       []titleSegment{},
 		Margin:      defaultMargin(),
 		Padding:     defaultMargin(),
 		Unicode:     true,
+		UnicodeAuto: true,
 		Tabstop:     8,
 		ClearOnExit: true,
 		Version:     false}
@@ -334,6 +657,15 @@ func atoi(str string) int {
 	return num
 }
 
+// parseDuration parses the duration with a unit such as 30s and 5m, or the
+// number of seconds without a unit
+func parseDuration(str string) time.Duration {
+	if duration, err := time.ParseDuration(str); err == nil {
+		return duration
+	}
+	return time.Duration(atof(str) * float64(time.Second))
+}
+
 func atof(str string) float64 {
 	num, err := strconv.ParseFloat(str, 64)
 	if err != nil {
@@ -362,22 +694,87 @@ func optionalNumeric(args []string, i *int, defaultValue int) int {
 }
 
 func splitNth(str string) []Range {
-	if match, _ := regexp.MatchString("^[0-9,-.]+$", str); !match {
+	ranges, ok := parseNth(str)
+	if !ok {
 		errorExit("invalid format: " + str)
 	}
+	return ranges
+}
+
+// parseNth parses the comma-separated list of field index expressions
+func parseNth(str string) ([]Range, bool) {
+	if match, _ := regexp.MatchString("^[0-9,-.]+$", str); !match {
+		return nil, false
+	}
 
 	tokens := strings.Split(str, ",")
 	ranges := make([]Range, len(tokens))
 	for idx, s := range tokens {
 		r, ok := ParseRange(&s)
 		if !ok {
-			errorExit("invalid format: " + str)
+			return nil, false
 		}
 		ranges[idx] = r
 	}
+	return ranges, true
+}
+
+// parseWeightedNth parses the expressions of --nth, each of which can be
+// followed by :WEIGHT to multiply the scores of the matches in the fields
+func parseWeightedNth(str string) ([]Range, bool) {
+	exprs := []string{}
+	weights := []int{}
+	for _, expr := range strings.Split(str, ",") {
+		weight := 0
+		if idx := strings.LastIndex(expr, ":"); idx >= 0 {
+			var err error
+			if weight, err = strconv.Atoi(expr[idx+1:]); err != nil || weight < 1 {
+				return nil, false
+			}
+			expr = expr[:idx]
+		}
+		exprs = append(exprs, expr)
+		weights = append(weights, weight)
+	}
+	ranges, ok := parseNth(strings.Join(exprs, ","))
+	if !ok {
+		return nil, false
+	}
+	for idx := range ranges {
+		ranges[idx].weight = weights[idx]
+	}
+	return ranges, true
+}
+
+func splitWeightedNth(str string) []Range {
+	ranges, ok := parseWeightedNth(str)
+	if !ok {
+		errorExit("invalid format: " + str)
+	}
 	return ranges
 }
 
+// splitWithNth parses the expressions of --with-nth, each of which can be
+// given as {EXPR|FORMAT} to format the fields for display
+func splitWithNth(str string) ([]Range, []displayFormat) {
+	formatRegex := regexp.MustCompile(`^{(.+)\|([a-z-]+)}$`)
+	exprs := []string{}
+	formats := []displayFormat{}
+	for _, expr := range strings.Split(str, ",") {
+		var format displayFormat
+		if match := formatRegex.FindStringSubmatch(expr); match != nil {
+			var prs bool
+			if format, prs = displayFormats[match[2]]; !prs {
+				errorExit("invalid display format: " + match[2])
+			}
+			expr = match[1]
+		}
+		exprs = append(exprs, expr)
+		formats = append(formats, format)
+	}
+	return splitNth(strings.Join(exprs, ",")), formats
+}
+
 func delimiterRegexp(str string) Delimiter {
 	// Special handling of \t
 	str = strings.Replace(str, "\\t", "\t", -1)
@@ -417,7 +814,82 @@ func parseAlgo(str string) algo.Algo {
 	return algo.FuzzyMatchV2
 }
 
-func parseBorder(str string, optional bool) tui.BorderShape {
+func parseInit(str string) string {
+	for _, shell := range initShells {
+		if str == shell {
+			return str
+		}
+	}
+	errorExit("invalid shell: " + str + " (expected: bash|zsh|fish)")
+	return ""
+}
+
+func parseSourceURL(str string) string {
+	if !isURL(str) {
+		errorExit("invalid source URL (expected: http:// or https://): " + str)
+	}
+	return str
+}
+
+// parseListen returns the address to listen on from [ADDR:]PORT. The server
+// binds to localhost unless the address is given.
+func parseListen(str string) string {
+	host := "localhost"
+	port := str
+	if idx := strings.LastIndex(str, ":"); idx >= 0 {
+		host = strings.Trim(str[:idx], "[]")
+		port = str[idx+1:]
+	}
+	if num, err := strconv.Atoi(port); err != nil || num < 0 || num > 65535 || len(host) == 0 {
+		errorExit("invalid listen address (expected: [ADDR:]PORT): " + str)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func parseAnsiBg(str string) ansiBgPolicy {
+	switch str {
+	case "item":
+		return ansiBgItem
+	case "theme":
+		return ansiBgTheme
+	case "blend":
+		return ansiBgBlend
+	}
+	errorExit("invalid background color policy (expected: item|theme|blend)")
+	return ansiBgItem
+}
+
+func parsePreviewOSCs(str string) map[string]bool {
+	oscs := make(map[string]bool)
+	for _, token := range strings.Split(str, ",") {
+		if !optionalOSCs[token] {
+			errorExit("invalid OSC number: " + token + " (expected: 52|1337)")
+		}
+		oscs[token] = true
+	}
+	return oscs
+}
+
+func parseMatchStyle(str string) matchStyle {
+	style := matchStyle{}
+	for _, token := range strings.Split(str, ",") {
+		switch token {
+		case "color":
+			style.color = true
+		case "underline":
+			style.attr = style.attr.Merge(tui.Underline)
+		case "bold":
+			style.attr = style.attr.Merge(tui.Bold)
+		case "block":
+			style.attr = style.attr.Merge(tui.Reverse)
+		default:
+			errorExit("invalid match style: " + token + " (expected: color|underline|bold|block)")
+		}
+	}
+	return style
+}
+
+func parseBorderShape(str string) tui.BorderShape {
 	switch str {
 	case "rounded":
 		return tui.BorderRounded
@@ -435,21 +907,140 @@ func parseBorder(str string, optional bool) tui.BorderShape {
 		return tui.BorderLeft
 	case "right":
 		return tui.BorderRight
-	default:
-		if optional && str == "" {
-			return tui.BorderRounded
-		}
-		errorExit("invalid border style (expected: rounded|sharp|horizontal|vertical|top|bottom|left|right)")
+	case "dashed":
+		return tui.BorderDashed
+	case "heavy-dashed":
+		return tui.BorderHeavyDashed
 	}
+	errorExit("invalid border style (expected: rounded|sharp|dashed|heavy-dashed|horizontal|vertical|top|bottom|left|right or SIDE:LINE)")
 	return tui.BorderNone
 }
 
+func parseBorderLine(str string) tui.BorderLine {
+	switch str {
+	case "none":
+		return tui.LineNone
+	case "sharp":
+		return tui.LineSharp
+	case "rounded":
+		return tui.LineRounded
+	case "bold":
+		return tui.LineBold
+	case "double":
+		return tui.LineDouble
+	case "dashed":
+		return tui.LineDashed
+	case "heavy-dashed":
+		return tui.LineHeavyDashed
+	}
+	errorExit("invalid border line: " + str + " (expected: none|sharp|rounded|bold|double|dashed|heavy-dashed)")
+	return tui.LineNone
+}
+
+func parseBorder(str string, optional bool) (tui.BorderShape, tui.BorderSides) {
+	if optional && str == "" {
+		return tui.BorderRounded, tui.BorderRounded.Sides()
+	}
+	if !strings.Contains(str, ":") {
+		shape := parseBorderShape(str)
+		return shape, shape.Sides()
+	}
+
+	// Per-side lines on top of the base shape (default: rounded)
+	sides := tui.BorderRounded.Sides()
+	for _, token := range strings.Split(str, ",") {
+		tokens := strings.SplitN(token, ":", 2)
+		if len(tokens) == 1 {
+			sides = parseBorderShape(token).Sides()
+			continue
+		}
+		line := parseBorderLine(tokens[1])
+		switch tokens[0] {
+		case "top":
+			sides[0] = line
+		case "right":
+			sides[1] = line
+		case "bottom":
+			sides[2] = line
+		case "left":
+			sides[3] = line
+		default:
+			errorExit("invalid border side: " + tokens[0] + " (expected: top|right|bottom|left)")
+		}
+	}
+	if sides == (tui.BorderSides{}) {
+		return tui.BorderNone, sides
+	}
+	return tui.BorderCustom, sides
+}
+
+func parseBorderChars(str string) []rune {
+	chars := []rune(str)
+	if len(chars) != 8 {
+		errorExit("border characters should be 8 characters: top-left, top, top-right, right, bottom-right, bottom, bottom-left, left")
+	}
+	for _, r := range chars {
+		if !unicode.IsGraphic(r) || runewidth.RuneWidth(r) != 1 {
+			errorExit("invalid border character: " + string(r))
+		}
+	}
+	return chars
+}
+
+// parseLabelPos parses the position of the border label in the form of
+// [N|N%][:top|:bottom]
+func parseLabelPos(opts *labelOpts, str string) {
+	errorMessage := "invalid label position: " + str + " (expected: [N|N%][:top|:bottom])"
+	offset, percent, bottom := 0, false, false
+	for idx, token := range strings.Split(str, ":") {
+		switch token {
+		case "top":
+			bottom = false
+		case "bottom":
+			bottom = true
+		default:
+			if idx > 0 {
+				errorExit(errorMessage)
+			}
+			if strings.HasSuffix(token, "%") {
+				percent = true
+				token = token[:len(token)-1]
+			}
+			value, err := strconv.Atoi(token)
+			if err != nil || percent && (value < 0 || value > 100) {
+				errorExit(errorMessage)
+			}
+			offset = value
+		}
+	}
+	opts.offset, opts.percent, opts.bottom = offset, percent, bottom
+}
+
+// addBorderLabel adds the label to the border. The label takes the position
+// given before it if any.
+func addBorderLabel(labels []labelOpts, label string) []labelOpts {
+	if n := len(labels); n > 0 && len(labels[n-1].label) == 0 {
+		labels[n-1].label = label
+		return labels
+	}
+	return append(labels, labelOpts{label: label})
+}
+
+// lastBorderLabel returns the last label on the border. When no label is
+// given yet, an empty one is added so that the position applies to the next.
+func lastBorderLabel(opts *Options) *labelOpts {
+	if len(opts.BorderLabel) == 0 {
+		opts.BorderLabel = append(opts.BorderLabel, labelOpts{})
+	}
+	return &opts.BorderLabel[len(opts.BorderLabel)-1]
+}
+
 func parseKeyChords(str string, message string) map[tui.Event]string {
 	if len(str) == 0 {
 		errorExit(message)
 	}
 
-	str = regexp.MustCompile("(?i)(alt-),").ReplaceAllString(str, "$1"+string([]rune{escapedComma}))
+	str = regexp.MustCompile("(?i)(alt-|ctrl-|super-),").ReplaceAllString(str, "$1"+string([]rune{escapedComma}))
 	tokens := strings.Split(str, ",")
 	if str == "," || strings.HasPrefix(str, ",,") || strings.HasSuffix(str, ",,") || strings.Contains(str, ",,,") {
 		tokens = append(tokens, ",")
@@ -490,10 +1081,24 @@ func parseKeyChords(str string, message string) map[tui.Event]string {
 			add(tui.CtrlBackSlash)
 		case "ctrl-]":
 			add(tui.CtrlRightBracket)
+		case "ctrl-i":
+			add(tui.Tab)
+		case "ctrl-m":
+			add(tui.CtrlM)
+		case "ctrl-[":
+			add(tui.ESC)
 		case "change":
 			add(tui.Change)
+		case "paste":
+			add(tui.Paste)
+		case "focus-gained", "focus-in":
+			add(tui.FocusGained)
+		case "focus-lost", "focus-out":
+			add(tui.FocusLost)
 		case "backward-eof":
 			add(tui.BackwardEOF)
+		case "zero":
+			add(tui.Zero)
 		case "alt-enter", "alt-return":
 			chords[tui.CtrlAltKey('m')] = key
 		case "alt-space":
@@ -548,6 +1153,20 @@ func parseKeyChords(str string, message string) map[tui.Event]string {
 			add(tui.RightClick)
 		case "double-click":
 			add(tui.DoubleClick)
+		case "triple-click":
+			add(tui.TripleClick)
+		case "click-border":
+			add(tui.ClickBorder)
+		case "click-preview-border":
+			add(tui.ClickPreviewBorder)
+		case "click-scrollbar":
+			add(tui.ClickScrollbar)
+		case "click-info":
+			add(tui.ClickInfo)
+		case "scroll-left":
+			add(tui.ScrollLeft)
+		case "scroll-right":
+			add(tui.ScrollRight)
 		case "f10":
 			add(tui.F10)
 		case "f11":
@@ -560,16 +1179,15 @@ func parseKeyChords(str string, message string) map[tui.Event]string {
 				chords[tui.CtrlAltKey(rune(key[9]))] = key
 			} else if len(key) == 6 && strings.HasPrefix(lkey, "ctrl-") && isAlphabet(lkey[5]) {
 				add(tui.EventType(tui.CtrlA.Int() + int(lkey[5]) - 'a'))
-			} else if len(runes) == 5 && strings.HasPrefix(lkey, "alt-") {
-				r := runes[4]
-				switch r {
-				case escapedColon:
-					r = ':'
-				case escapedComma:
-					r = ','
-				case escapedPlus:
-					r = '+'
-				}
+			} else if r, ok := modifiedKey(runes, lkey, "ctrl-alt-"); ok {
+				chords[tui.CtrlAltKey(r)] = key
+			} else if r, ok := modifiedKey(runes, lkey, "ctrl-shift-", "shift-ctrl-"); ok {
+				chords[tui.CtrlShiftKey(unicode.ToLower(r))] = key
+			} else if r, ok := modifiedKey(runes, lkey, "super-"); ok {
+				chords[tui.SuperKey(r)] = key
+			} else if r, ok := modifiedKey(runes, lkey, "ctrl-"); ok {
+				chords[tui.CtrlKey(r)] = key
+			} else if r, ok := modifiedKey(runes, lkey, "alt-"); ok {
 				chords[tui.AltKey(r)] = key
 			} else if len(key) == 2 && strings.HasPrefix(lkey, "f") && key[1] >= '1' && key[1] <= '9' {
 				add(tui.EventType(tui.F1.Int() + int(key[1]) - '1'))
@@ -583,6 +1201,26 @@ func parseKeyChords(str string, message string) map[tui.Event]string {
 	return chords
 }
 
+// modifiedKey returns the character of the key name made of one of the
+// prefixes and a single character
+func modifiedKey(runes []rune, lkey string, prefixes ...string) (rune, bool) {
+	for _, prefix := range prefixes {
+		if len(runes) == len(prefix)+1 && strings.HasPrefix(lkey, prefix) {
+			r := runes[len(prefix)]
+			switch r {
+			case escapedColon:
+				r = ':'
+			case escapedComma:
+				r = ','
+			case escapedPlus:
+				r = '+'
+			}
+			return r, true
+		}
+	}
+	return 0, false
+}
+
 func parseTiebreak(str string) []criterion {
 	criteria := []criterion{byScore}
 	hasIndex := false
@@ -618,15 +1256,223 @@ func parseTiebreak(str string) []criterion {
 	return criteria
 }
 
+// parseScheme parses the scoring scheme. custom:KEY=N[,..] modifies the points
+// of the scoring table and is otherwise the same as the default scheme.
+func parseScheme(str string) (string, algo.Scoring) {
+	if strings.HasPrefix(str, "custom:") {
+		return "custom", parseScoring(str[len("custom:"):])
+	}
+	if _, ok := schemeCriteria(str, nil); !ok {
+		errorExit("invalid scoring scheme: " + str)
+	}
+	return str, algo.DefaultScoring
+}
+
+// parseWordBoundaries parses the comma-separated list of the transitions in
+// words taken for the boundaries. none disables all of them.
+func parseWordBoundaries(str string) *algo.Boundaries {
+	bounds := algo.Boundaries{}
+	if str == "none" {
+		return &bounds
+	}
+	for _, name := range strings.Split(str, ",") {
+		switch name {
+		case "camel":
+			bounds.Camel = true
+		case "snake":
+			bounds.Snake = true
+		case "digit":
+			bounds.Digit = true
+		default:
+			errorExit("invalid word boundary: " + name + " (expected: camel, snake, digit, or none)")
+		}
+	}
+	return &bounds
+}
+
+// parseCharWidth parses the width of the class of characters. auto is to
+// measure it on the terminal.
+func parseCharWidth(name string, str string) int {
+	switch str {
+	case "1":
+		return 1
+	case "2":
+		return 2
+	case "auto":
+		return widthAuto
+	}
+	errorExit("invalid " + name + " width: " + str + " (expected: 1, 2, or auto)")
+	return 0
+}
+
+// parseScoring parses the points of the scoring table in the form of
+// KEY=N[,..]. The camelCase and consecutive bonuses not given are derived from
+// the other points as in the default table.
+func parseScoring(str string) algo.Scoring {
+	errorMessage := "invalid scoring table: " + str
+	table := algo.DefaultScoring
+	camel, consecutive := false, false
+	for _, token := range strings.Split(str, ",") {
+		kv := strings.SplitN(token, "=", 2)
+		if len(kv) != 2 {
+			errorExit(errorMessage)
+		}
+		value, err := strconv.Atoi(kv[1])
+		if err != nil {
+			errorExit(errorMessage)
+		}
+		// The ranges keep the scores of long patterns from overflowing
+		bound := func(min int, max int) int16 {
+			if value < min || value > max {
+				errorExit(fmt.Sprintf("%s: %s should be between %d and %d", errorMessage, kv[0], min, max))
+			}
+			return int16(value)
+		}
+		switch kv[0] {
+		case "match":
+			table.Match = bound(1, 32)
+		case "gap-start":
+			table.GapStart = bound(-32, 0)
+		case "gap-extension":
+			table.GapExtension = bound(-32, 0)
+		case "boundary":
+			table.Boundary = bound(0, 32)
+		case "non-word":
+			table.NonWord = bound(0, 32)
+		case "camel":
+			table.Camel, camel = bound(0, 32), true
+		case "consecutive":
+			table.Consecutive, consecutive = bound(0, 32), true
+		case "first-char-multiplier":
+			table.FirstCharMultiplier = bound(1, 4)
+		default:
+			errorExit(errorMessage + ": unknown key: " + kv[0])
+		}
+	}
+	if !camel {
+		table.Camel = util.Max16(table.Boundary+table.GapExtension, 0)
+	}
+	if !consecutive {
+		table.Consecutive = -(table.GapStart + table.GapExtension)
+	}
+	return table
+}
+
 func dupeTheme(theme *tui.ColorTheme) *tui.ColorTheme {
 	dupe := *theme
 	return &dupe
 }
 
+var colorAttrs = map[string]tui.Attr{
+	"bold":          tui.Bold,
+	"strong":        tui.Bold,
+	"dim":           tui.Dim,
+	"italic":        tui.Italic,
+	"underline":     tui.Underline,
+	"blink":         tui.Blink,
+	"reverse":       tui.Reverse,
+	"strikethrough": tui.StrikeThrough}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var rrggbbRegexp = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+var blendRegexp = regexp.MustCompile(`^blend\(([^,()]+),([^,()]+),([^,()]+)\)$`)
+
+var colorAliasRegexp = regexp.MustCompile("^[a-z][a-z0-9_-]*$")
+
+func parseColor(str string, aliases map[string]tui.Color) (tui.Color, bool) {
+	if color, prs := aliases[str]; prs {
+		return color, true
+	}
+	if rrggbbRegexp.MatchString(str) {
+		return tui.HexToColor(str), true
+	}
+	for idx, name := range colorNames {
+		if str == name {
+			return tui.Color(idx), true
+		} else if str == "bright-"+name {
+			return tui.Color(idx + 8), true
+		}
+	}
+	ansi32, err := strconv.Atoi(str)
+	if err != nil || ansi32 < -1 || ansi32 > 255 {
+		return 0, false
+	}
+	return tui.Color(ansi32), true
+}
+
+func parseColorRef(theme *tui.ColorTheme, str string, aliases map[string]tui.Color) (tui.ColorRef, bool) {
+	if theme.Element(str) != nil {
+		return tui.ColorRef{Element: str}, true
+	}
+	color, ok := parseColor(str, aliases)
+	return tui.ColorRef{Color: color}, ok
+}
+
+// Registers the color alias given as define:NAME:COLOR
+func defineColorAlias(spec string, aliases map[string]tui.Color) {
+	components := strings.Split(spec, ":")
+	if len(components) != 3 {
+		errorExit("invalid color alias definition (expected: define:NAME:COLOR): " + spec)
+	}
+	name := components[1]
+	if !colorAliasRegexp.MatchString(name) {
+		errorExit("invalid color alias name: " + name)
+	}
+	_, builtin := parseColor(name, nil)
+	_, attr := colorAttrs[strings.TrimPrefix(name, "no")]
+	if builtin || attr || name == "regular" || tui.EmptyTheme().Element(name) != nil {
+		errorExit("reserved name cannot be used as color alias: " + name)
+	}
+	color, ok := parseColor(components[2], aliases)
+	if !ok {
+		errorExit("invalid color for alias " + name + ": " + components[2])
+	}
+	aliases[name] = color
+}
+
+// Splits the color specification by commas outside parentheses
+func splitColorSpec(str string) []string {
+	tokens := []string{}
+	depth := 0
+	begin := 0
+	for idx, r := range str {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				tokens = append(tokens, str[begin:idx])
+				begin = idx + 1
+			}
+		}
+	}
+	return append(tokens, str[begin:])
+}
+
 func parseTheme(defaultTheme *tui.ColorTheme, str string) *tui.ColorTheme {
+	return parseThemeWithAliases(defaultTheme, str, make(map[string]tui.Color))
+}
+
+// Color aliases defined in the specification are registered to the given map
+// so that they can be referenced in the later specifications
+func parseThemeWithAliases(defaultTheme *tui.ColorTheme, str string, aliases map[string]tui.Color) *tui.ColorTheme {
 	theme := dupeTheme(defaultTheme)
-	rrggbb := regexp.MustCompile("^#[0-9a-fA-F]{6}$")
-	for _, str := range strings.Split(strings.ToLower(str), ",") {
+	specs := splitColorSpec(strings.ToLower(str))
+	// Aliases are resolved first so that they can be referenced before the
+	// definitions
+	for _, str := range specs {
+		if strings.HasPrefix(str, "define:") {
+			defineColorAlias(str, aliases)
+		}
+	}
+	for _, str := range specs {
+		if strings.HasPrefix(str, "define:") {
+			continue
+		}
 		switch str {
 		case "dark":
 			theme = dupeTheme(tui.Dark256)
@@ -650,83 +1496,63 @@ func parseTheme(defaultTheme *tui.ColorTheme, str string) *tui.ColorTheme {
 				fail()
 			}
 
+			// The color is calculated on initialization as the colors of the other
+			// elements are not yet known
+			addBlend := func(args []string) {
+				base, baseOk := parseColorRef(theme, strings.TrimSpace(args[0]), aliases)
+				other, otherOk := parseColorRef(theme, strings.TrimSpace(args[1]), aliases)
+				ratio, err := strconv.ParseFloat(strings.TrimSpace(args[2]), 64)
+				if !baseOk || !otherOk || err != nil || ratio < 0 || ratio > 1 {
+					fail()
+				}
+				blends := []tui.ColorBlend{}
+				if theme.Blends != nil {
+					blends = append(blends, *theme.Blends...)
+				}
+				blends = append(blends, tui.ColorBlend{
+					Element: components[0], Base: base, Other: other, Ratio: ratio})
+				theme.Blends = &blends
+			}
+
 			mergeAttr := func(cattr *tui.ColorAttr) {
 				for _, component := range components[1:] {
+					negate := strings.HasPrefix(component, "no")
+					if attr, prs := colorAttrs[strings.TrimPrefix(component, "no")]; prs {
+						if negate {
+							attr = attr.Negate()
+						}
+						cattr.Attr = cattr.Attr.Merge(attr)
+						continue
+					}
 					switch component {
 					case "regular":
 						cattr.Attr = tui.AttrRegular
-					case "bold", "strong":
-						cattr.Attr |= tui.Bold
-					case "dim":
-						cattr.Attr |= tui.Dim
-					case "italic":
-						cattr.Attr |= tui.Italic
-					case "underline":
-						cattr.Attr |= tui.Underline
-					case "blink":
-						cattr.Attr |= tui.Blink
-					case "reverse":
-						cattr.Attr |= tui.Reverse
 					case "":
 					default:
-						if rrggbb.MatchString(component) {
-							cattr.Color = tui.HexToColor(component)
+						if blend := blendRegexp.FindStringSubmatch(component); blend != nil {
+							addBlend(blend[1:])
+						} else if color, ok := parseColor(component, aliases); ok {
+							cattr.Color = color
+						} else if colorAliasRegexp.MatchString(component) {
+							errorExit("undefined color alias: " + component)
 						} else {
-							ansi32, err := strconv.Atoi(component)
-							if err != nil || ansi32 < -1 || ansi32 > 255 {
-								fail()
-							}
-							cattr.Color = tui.Color(ansi32)
+							fail()
 						}
 					}
 				}
 			}
-			switch components[0] {
-			case "query", "input":
-				mergeAttr(&theme.Input)
-			case "disabled":
-				mergeAttr(&theme.Disabled)
-			case "fg":
-				mergeAttr(&theme.Fg)
-			case "bg":
-				mergeAttr(&theme.Bg)
-			case "preview-fg":
-				mergeAttr(&theme.PreviewFg)
-			case "preview-bg":
-				mergeAttr(&theme.PreviewBg)
-			case "fg+":
-				mergeAttr(&theme.Current)
-			case "bg+":
-				mergeAttr(&theme.DarkBg)
-			case "gutter":
-				mergeAttr(&theme.Gutter)
-			case "hl":
-				mergeAttr(&theme.Match)
-			case "hl+":
-				mergeAttr(&theme.CurrentMatch)
-			case "border":
-				mergeAttr(&theme.Border)
-			case "prompt":
-				mergeAttr(&theme.Prompt)
-			case "spinner":
-				mergeAttr(&theme.Spinner)
-			case "info":
-				mergeAttr(&theme.Info)
-			case "pointer":
-				mergeAttr(&theme.Cursor)
-			case "marker":
-				mergeAttr(&theme.Selected)
-			case "header":
-				mergeAttr(&theme.Header)
-			default:
+			cattr := theme.Element(components[0])
+			if cattr == nil {
 				fail()
 			}
+			mergeAttr(cattr)
 		}
 	}
 	return theme
 }
 
 var executeRegexp *regexp.Regexp
+var countRegexp *regexp.Regexp
 
 func firstKey(keymap map[tui.Event]string) tui.Event {
 	for k := range keymap {
@@ -745,11 +1571,14 @@ func init() {
 	// Backreferences are not supported.
 	// "~!@#$%^&*;/|".each_char.map { |c| Regexp.escape(c) }.map { |c| "#{c}[^#{c}]*#{c}" }.join('|')
 	executeRegexp = regexp.MustCompile(
-		`(?si)[:+](execute(?:-multi|-silent)?|reload|preview|change-prompt):.+|[:+](execute(?:-multi|-silent)?|reload|preview|change-prompt)(\([^)]*\)|\[[^\]]*\]|~[^~]*~|![^!]*!|@[^@]*@|\#[^\#]*\#|\$[^\$]*\$|%[^%]*%|\^[^\^]*\^|&[^&]*&|\*[^\*]*\*|;[^;]*;|/[^/]*/|\|[^\|]*\|)`)
+		`(?si)[:+](execute(?:-multi|-silent|-status)?|become(?:-with-state)?|reload|preview|change-prompt|change-nth|change-delimiter|change-scheme|transform|desc|save-filter|apply-filter|reserve-region|repeat|yank-to|put-from):.+|[:+](execute(?:-multi|-silent|-status)?|become(?:-with-state)?|reload|preview|change-prompt|change-nth|change-delimiter|change-scheme|transform|desc|save-filter|apply-filter|reserve-region|repeat|yank-to|put-from)(\([^)]*\)|\[[^\]]*\]|~[^~]*~|![^!]*!|@[^@]*@|\#[^\#]*\#|\$[^\$]*\$|%[^%]*%|\^[^\^]*\^|&[^&]*&|\*[^\*]*\*|;[^;]*;|/[^/]*/|\|[^\|]*\|)`)
+	countRegexp = regexp.MustCompile(`^([^()]+)\(([0-9]+)\)$`)
 }
 
-func parseKeymap(keymap map[tui.Event][]action, str string) {
-	masked := executeRegexp.ReplaceAllStringFunc(str, func(src string) string {
+// maskActionList masks the arguments of the actions so that the delimiters in
+// them are not taken for the ones between the bindings or the actions
+func maskActionList(str string) string {
+	return executeRegexp.ReplaceAllStringFunc(maskRepeatActions(str), func(src string) string {
 		symbol := ":"
 		if strings.HasPrefix(src, "+") {
 			symbol = "+"
@@ -761,6 +1590,32 @@ func parseKeymap(keymap map[tui.Event][]action, str string) {
 			prefix = symbol + "preview"
 		} else if strings.HasPrefix(src[1:], "change-prompt") {
 			prefix = symbol + "change-prompt"
+		} else if strings.HasPrefix(src[1:], "change-nth") {
+			prefix = symbol + "change-nth"
+		} else if strings.HasPrefix(src[1:], "change-delimiter") {
+			prefix = symbol + "change-delimiter"
+		} else if strings.HasPrefix(src[1:], "change-scheme") {
+			prefix = symbol + "change-scheme"
+		} else if strings.HasPrefix(src[1:], "transform") {
+			prefix = symbol + "transform"
+		} else if strings.HasPrefix(src[1:], "desc") {
+			prefix = symbol + "desc"
+		} else if strings.HasPrefix(src[1:], "save-filter") {
+			prefix = symbol + "save-filter"
+		} else if strings.HasPrefix(src[1:], "apply-filter") {
+			prefix = symbol + "apply-filter"
+		} else if strings.HasPrefix(src[1:], "reserve-region") {
+			prefix = symbol + "reserve-region"
+		} else if strings.HasPrefix(src[1:], "repeat") {
+			prefix = symbol + "repeat"
+		} else if strings.HasPrefix(src[1:], "yank-to") {
+			prefix = symbol + "yank-to"
+		} else if strings.HasPrefix(src[1:], "put-from") {
+			prefix = symbol + "put-from"
+		} else if strings.HasPrefix(src[1:], "become-with-state") {
+			prefix = symbol + "become-with-state"
+		} else if strings.HasPrefix(src[1:], "become") {
+			prefix = symbol + "become"
 		} else if src[len(prefix)] == '-' {
 			c := src[len(prefix)+1]
 			if c == 's' || c == 'S' {
@@ -771,6 +1626,68 @@ func parseKeymap(keymap map[tui.Event][]action, str string) {
 		}
 		return prefix + "(" + strings.Repeat(" ", len(src)-len(prefix)-2) + ")"
 	})
+}
+
+// maskRepeatActions masks the arguments of repeat(...) actions up to the
+// matching closing parenthesis, as the nested actions can have parentheses in
+// them. The nested actions are masked in the same way to find it.
+func maskRepeatActions(str string) string {
+	const open = "repeat("
+	var builder strings.Builder
+	start := 0
+	for idx := 1; idx+len(open) <= len(str); idx++ {
+		if (str[idx-1] != ':' && str[idx-1] != '+') || !strings.EqualFold(str[idx:idx+len(open)], open) {
+			continue
+		}
+		begin := idx + len(open)
+		comma := strings.IndexByte(str[begin:], ',')
+		if comma < 0 {
+			continue
+		}
+		// The closing parenthesis of the masked arguments of the nested
+		// actions are balanced
+		depth := 0
+		end := -1
+		for i, c := range maskActionList(":" + str[begin+comma+1:])[1:] {
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				if depth == 0 {
+					end = begin + comma + 1 + i
+					break
+				}
+				depth--
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		builder.WriteString(str[start:begin])
+		builder.WriteString(strings.Repeat(" ", end-begin))
+		start = end
+		idx = end
+	}
+	builder.WriteString(str[start:])
+	return builder.String()
+}
+
+// repetitions returns the largest number of times an action in the list is
+// performed with the nested repeat actions
+func repetitions(actions []action) int {
+	max := 1
+	for _, a := range actions {
+		if a.t == actRepeat {
+			max = util.Max(max, a.n*repetitions(a.c))
+		}
+	}
+	return max
+}
+
+// parseKeymap parses the bind expression into the keymap. The names of the
+// keys and the definitions of their actions are recorded in names and specs
+// unless they are nil.
+func parseKeymap(keymap map[tui.Event][]action, names map[tui.Event]string, specs map[tui.Event]string, str string) {
+	masked := maskActionList(str)
 	masked = strings.Replace(masked, "::", string([]rune{escapedColon, ':'}), -1)
 	masked = strings.Replace(masked, ",:", string([]rune{escapedComma, ':'}), -1)
 	masked = strings.Replace(masked, "+:", string([]rune{escapedPlus, ':'}), -1)
@@ -785,202 +1702,465 @@ func parseKeymap(keymap map[tui.Event][]action, str string) {
 			errorExit("bind action not specified: " + origPairStr)
 		}
 		var key tui.Event
+		var name string
 		if len(pair[0]) == 1 && pair[0][0] == escapedColon {
-			key = tui.Key(':')
+			key, name = tui.Key(':'), ":"
 		} else if len(pair[0]) == 1 && pair[0][0] == escapedComma {
-			key = tui.Key(',')
+			key, name = tui.Key(','), ","
 		} else if len(pair[0]) == 1 && pair[0][0] == escapedPlus {
-			key = tui.Key('+')
+			key, name = tui.Key('+'), "+"
+		} else if len(pair[0]) > 7 && strings.ToLower(pair[0][:7]) == "leader+" {
+			// Bound to the keys following the leader key, which is not known
+			// until all the options are parsed
+			leader := tui.Leader.AsEvent()
+			keymap[leader] = bindKeySequence(keymap[leader], "leader", splitKeySequence(pair[0][7:]), origPairStr[len(pair[0])+1:], func(prevActions []action) []action {
+				return parseActionList(pair[1], origPairStr[len(pair[0])+1:], prevActions, errorExit)
+			})
+			continue
+		} else if sequence := splitKeySequence(pair[0]); len(sequence) > 1 {
+			key, name = parseKey(sequence[0])
+			actionStr := origPairStr[len(pair[0])+1:]
+			keymap[key] = bindKeySequence(keymap[key], name, sequence[1:], actionStr, func(prevActions []action) []action {
+				return parseActionList(pair[1], actionStr, prevActions, errorExit)
+			})
+			continue
 		} else {
-			keys := parseKeyChords(pair[0], "key name required")
-			key = firstKey(keys)
-		}
-
-		idx2 := len(pair[0]) + 1
-		specs := strings.Split(pair[1], "+")
-		actions := make([]action, 0, len(specs))
-		appendAction := func(types ...actionType) {
-			actions = append(actions, toActions(types...)...)
-		}
-		prevSpec := ""
-		for specIndex, maskedSpec := range specs {
-			spec := origPairStr[idx2 : idx2+len(maskedSpec)]
-			idx2 += len(maskedSpec) + 1
-			spec = prevSpec + spec
-			specLower := strings.ToLower(spec)
-			switch specLower {
-			case "ignore":
-				appendAction(actIgnore)
-			case "beginning-of-line":
-				appendAction(actBeginningOfLine)
-			case "abort":
-				appendAction(actAbort)
-			case "accept":
-				appendAction(actAccept)
-			case "accept-non-empty":
-				appendAction(actAcceptNonEmpty)
-			case "print-query":
-				appendAction(actPrintQuery)
-			case "refresh-preview":
-				appendAction(actRefreshPreview)
-			case "replace-query":
-				appendAction(actReplaceQuery)
-			case "backward-char":
-				appendAction(actBackwardChar)
-			case "backward-delete-char":
-				appendAction(actBackwardDeleteChar)
-			case "backward-delete-char/eof":
-				appendAction(actBackwardDeleteCharEOF)
-			case "backward-word":
-				appendAction(actBackwardWord)
-			case "clear-screen":
-				appendAction(actClearScreen)
-			case "delete-char":
-				appendAction(actDeleteChar)
-			case "delete-char/eof":
-				appendAction(actDeleteCharEOF)
-			case "deselect":
-				appendAction(actDeselect)
-			case "end-of-line":
-				appendAction(actEndOfLine)
-			case "cancel":
-				appendAction(actCancel)
-			case "clear-query":
-				appendAction(actClearQuery)
-			case "clear-selection":
-				appendAction(actClearSelection)
-			case "forward-char":
-				appendAction(actForwardChar)
-			case "forward-word":
-				appendAction(actForwardWord)
-			case "jump":
-				appendAction(actJump)
-			case "jump-accept":
-				appendAction(actJumpAccept)
-			case "kill-line":
-				appendAction(actKillLine)
-			case "kill-word":
-				appendAction(actKillWord)
-			case "unix-line-discard", "line-discard":
-				appendAction(actUnixLineDiscard)
-			case "unix-word-rubout", "word-rubout":
-				appendAction(actUnixWordRubout)
-			case "yank":
-				appendAction(actYank)
-			case "backward-kill-word":
-				appendAction(actBackwardKillWord)
-			case "toggle-down":
-				appendAction(actToggle, actDown)
-			case "toggle-up":
-				appendAction(actToggle, actUp)
-			case "toggle-in":
-				appendAction(actToggleIn)
-			case "toggle-out":
-				appendAction(actToggleOut)
-			case "toggle-all":
-				appendAction(actToggleAll)
-			case "toggle-search":
-				appendAction(actToggleSearch)
-			case "select":
-				appendAction(actSelect)
-			case "select-all":
-				appendAction(actSelectAll)
-			case "deselect-all":
-				appendAction(actDeselectAll)
-			case "close":
-				appendAction(actClose)
-			case "toggle":
-				appendAction(actToggle)
-			case "down":
-				appendAction(actDown)
-			case "up":
-				appendAction(actUp)
-			case "first", "top":
-				appendAction(actFirst)
-			case "last":
-				appendAction(actLast)
-			case "page-up":
-				appendAction(actPageUp)
-			case "page-down":
-				appendAction(actPageDown)
-			case "half-page-up":
-				appendAction(actHalfPageUp)
-			case "half-page-down":
-				appendAction(actHalfPageDown)
-			case "previous-history":
-				appendAction(actPreviousHistory)
-			case "next-history":
-				appendAction(actNextHistory)
-			case "toggle-preview":
-				appendAction(actTogglePreview)
-			case "toggle-preview-wrap":
-				appendAction(actTogglePreviewWrap)
-			case "toggle-sort":
-				appendAction(actToggleSort)
-			case "preview-top":
-				appendAction(actPreviewTop)
-			case "preview-bottom":
-				appendAction(actPreviewBottom)
-			case "preview-up":
-				appendAction(actPreviewUp)
-			case "preview-down":
-				appendAction(actPreviewDown)
-			case "preview-page-up":
-				appendAction(actPreviewPageUp)
-			case "preview-page-down":
-				appendAction(actPreviewPageDown)
-			case "preview-half-page-up":
-				appendAction(actPreviewHalfPageUp)
-			case "preview-half-page-down":
-				appendAction(actPreviewHalfPageDown)
-			case "enable-search":
-				appendAction(actEnableSearch)
-			case "disable-search":
-				appendAction(actDisableSearch)
-			default:
-				t := isExecuteAction(specLower)
-				if t == actIgnore {
-					if specIndex == 0 && specLower == "" {
-						actions = append(keymap[key], actions...)
+			key, name = parseKey(pair[0])
+			key = ctrlAliasKey(key, name)
+		}
+		if names != nil {
+			names[key] = name
+		}
+		spec := origPairStr[len(pair[0])+1:]
+		if specs != nil {
+			if strings.HasPrefix(spec, "+") && len(specs[key]) > 0 {
+				specs[key] += spec
+			} else {
+				specs[key] = spec
+			}
+		}
+
+		keymap[key] = parseActionList(pair[1], spec, keymap[key], errorExit)
+	}
+}
+
+// ctrlAliasKey returns the event of ctrl-i, ctrl-m, or ctrl-[ bound by the
+// name, which is told apart from tab, enter, or esc with the keyboard
+// protocol of kitty. Without the protocol, the binding is taken for the
+// legacy key.
+func ctrlAliasKey(key tui.Event, name string) tui.Event {
+	switch strings.ToLower(name) {
+	case "ctrl-i", "ctrl-m", "ctrl-[":
+		if alias, ok := key.CtrlAlias(); ok {
+			return alias
+		}
+	}
+	return key
+}
+
+// parseKey parses the name of a single key and returns the event along with
+// the name
+func parseKey(str string) (tui.Event, string) {
+	keys := parseKeyChords(str, "key name required")
+	key := firstKey(keys)
+	return key, keys[key]
+}
+
+// splitKeySequence splits the key chord into the keys separated by '>' as in
+// ctrl-x>ctrl-e. '>' is the key itself when it is right after the separator or
+// after '-' as in alt->.
+func splitKeySequence(str string) []string {
+	keys := []string{}
+	begin := 0
+	for i := 1; i < len(str); i++ {
+		if str[i] == '>' && i > begin && str[i-1] != '-' {
+			keys = append(keys, str[begin:i])
+			begin = i + 1
+			i++
+		}
+	}
+	return append(keys, str[begin:])
+}
+
+// bindKeySequence returns the chord action that replaces the actions of the
+// first key of the chord, with the actions parsed by parse bound to the rest
+// of the keys. spec is the definition of the actions shown in the popup of the
+// chord. The existing chord is extended instead of being replaced.
+func bindKeySequence(actions []action, name string, keys []string, spec string, parse func([]action) []action) []action {
+	chord := newKeyChord(name)
+	if len(actions) == 1 && actions[0].t == actChord {
+		chord = actions[0].k
+	}
+	key, keyName := parseKey(keys[0])
+	chord.names[key] = keyName
+	if len(keys) == 1 {
+		chord.keymap[key] = parse(chord.keymap[key])
+		chord.specs[key] = spec
+	} else {
+		chord.keymap[key] = bindKeySequence(chord.keymap[key], name+">"+keyName, keys[1:], spec, parse)
+	}
+	return []action{{t: actChord, k: chord}}
+}
+
+// parseSingleActionList parses the actions separated by '+' without the key.
+// exit is called with the error message for an invalid action.
+func parseSingleActionList(str string, exit func(string)) []action {
+	// The colon is prepended as the actions are masked after it
+	return parseActionList(maskActionList(":" + str)[1:], str, nil, exit)
+}
+
+// parseActionList parses the masked list of the actions along with its
+// original form. The actions are appended to prevActions if the list starts
+// with '+'. exit is called with the error message for an invalid action, and
+// nil is returned if it returns.
+func parseActionList(masked string, original string, prevActions []action, exit func(string)) []action {
+	idx := 0
+	specs := strings.Split(masked, "+")
+	actions := make([]action, 0, len(specs))
+	appendAction := func(types ...actionType) {
+		actions = append(actions, toActions(types...)...)
+	}
+	prevSpec := ""
+	for specIndex, maskedSpec := range specs {
+		if len(prevSpec) == 0 && strings.HasPrefix(maskedSpec, "#") {
+			// The description of the actions extends to the end of the list
+			actions = append(actions, action{t: actDescription, a: original[idx+1:]})
+			break
+		}
+		spec := original[idx : idx+len(maskedSpec)]
+		idx += len(maskedSpec) + 1
+		spec = prevSpec + spec
+		specLower := strings.ToLower(spec)
+		switch specLower {
+		case "ignore":
+			appendAction(actIgnore)
+		case "beginning-of-line":
+			appendAction(actBeginningOfLine)
+		case "abort":
+			appendAction(actAbort)
+		case "accept":
+			appendAction(actAccept)
+		case "accept-non-empty":
+			appendAction(actAcceptNonEmpty)
+		case "accept-all":
+			appendAction(actAcceptAll)
+		case "print-query":
+			appendAction(actPrintQuery)
+		case "refresh-preview":
+			appendAction(actRefreshPreview)
+		case "replace-query":
+			appendAction(actReplaceQuery)
+		case "backward-char":
+			appendAction(actBackwardChar)
+		case "backward-delete-char":
+			appendAction(actBackwardDeleteChar)
+		case "backward-delete-char/eof":
+			appendAction(actBackwardDeleteCharEOF)
+		case "backward-word":
+			appendAction(actBackwardWord)
+		case "clear-screen":
+			appendAction(actClearScreen)
+		case "delete-char":
+			appendAction(actDeleteChar)
+		case "delete-char/eof":
+			appendAction(actDeleteCharEOF)
+		case "deselect":
+			appendAction(actDeselect)
+		case "end-of-line":
+			appendAction(actEndOfLine)
+		case "cancel":
+			appendAction(actCancel)
+		case "cancel-status":
+			appendAction(actCancelStatus)
+		case "record-macro":
+			appendAction(actRecordMacro)
+		case "stop-macro":
+			appendAction(actStopMacro)
+		case "replay-macro":
+			appendAction(actReplayMacro)
+		case "clear-query":
+			appendAction(actClearQuery)
+		case "flash":
+			appendAction(actFlash)
+		case "intersect-with-query":
+			appendAction(actIntersectWithQuery)
+		case "union-with-query":
+			appendAction(actUnionWithQuery)
+		case "subtract-query":
+			appendAction(actSubtractQuery)
+		case "clear-selection":
+			appendAction(actClearSelection)
+		case "forward-char":
+			appendAction(actForwardChar)
+		case "forward-word":
+			appendAction(actForwardWord)
+		case "jump":
+			appendAction(actJump)
+		case "jump-accept":
+			appendAction(actJumpAccept)
+		case "kill-line":
+			appendAction(actKillLine)
+		case "kill-word":
+			appendAction(actKillWord)
+		case "unix-line-discard", "line-discard":
+			appendAction(actUnixLineDiscard)
+		case "unix-word-rubout", "word-rubout":
+			appendAction(actUnixWordRubout)
+		case "yank":
+			appendAction(actYank)
+		case "backward-kill-word":
+			appendAction(actBackwardKillWord)
+		case "toggle-down":
+			appendAction(actToggle, actDown)
+		case "toggle-up":
+			appendAction(actToggle, actUp)
+		case "toggle-in":
+			appendAction(actToggleIn)
+		case "toggle-out":
+			appendAction(actToggleOut)
+		case "toggle-all":
+			appendAction(actToggleAll)
+		case "toggle-search":
+			appendAction(actToggleSearch)
+		case "select":
+			appendAction(actSelect)
+		case "select-all":
+			appendAction(actSelectAll)
+		case "deselect-all":
+			appendAction(actDeselectAll)
+		case "close":
+			appendAction(actClose)
+		case "toggle":
+			appendAction(actToggle)
+		case "down":
+			appendAction(actDown)
+		case "up":
+			appendAction(actUp)
+		case "first", "top":
+			appendAction(actFirst)
+		case "last":
+			appendAction(actLast)
+		case "page-up":
+			appendAction(actPageUp)
+		case "page-down":
+			appendAction(actPageDown)
+		case "half-page-up":
+			appendAction(actHalfPageUp)
+		case "half-page-down":
+			appendAction(actHalfPageDown)
+		case "previous-history":
+			appendAction(actPreviousHistory)
+		case "next-history":
+			appendAction(actNextHistory)
+		case "toggle-preview":
+			appendAction(actTogglePreview)
+		case "toggle-preview-wrap":
+			appendAction(actTogglePreviewWrap)
+		case "toggle-preview-debug":
+			appendAction(actTogglePreviewDebug)
+		case "toggle-wrap":
+			appendAction(actToggleWrap)
+		case "toggle-dry-run":
+			appendAction(actToggleDryRun)
+		case "toggle-sort":
+			appendAction(actToggleSort)
+		case "toggle-regex":
+			appendAction(actToggleRegex)
+		case "toggle-smart-case":
+			appendAction(actToggleSmartCase)
+		case "toggle-info":
+			appendAction(actToggleInfo)
+		case "toggle-separator":
+			appendAction(actToggleSeparator)
+		case "grow-header":
+			appendAction(actGrowHeader)
+		case "shrink-header":
+			appendAction(actShrinkHeader)
+		case "reload-url":
+			appendAction(actReloadURL)
+		case "next-field":
+			appendAction(actNextField)
+		case "previous-field":
+			appendAction(actPreviousField)
+		case "next-tab":
+			appendAction(actNextTab)
+		case "prev-tab":
+			appendAction(actPrevTab)
+		case "preview-top":
+			appendAction(actPreviewTop)
+		case "preview-bottom":
+			appendAction(actPreviewBottom)
+		case "preview-up":
+			appendAction(actPreviewUp)
+		case "preview-down":
+			appendAction(actPreviewDown)
+		case "preview-page-up":
+			appendAction(actPreviewPageUp)
+		case "preview-page-down":
+			appendAction(actPreviewPageDown)
+		case "preview-half-page-up":
+			appendAction(actPreviewHalfPageUp)
+		case "preview-half-page-down":
+			appendAction(actPreviewHalfPageDown)
+		case "enable-search":
+			appendAction(actEnableSearch)
+		case "disable-search":
+			appendAction(actDisableSearch)
+		case "release-region":
+			appendAction(actReleaseRegion)
+		case "digit-argument":
+			appendAction(actDigitArgument)
+		case "show-bindings":
+			appendAction(actShowBindings)
+		case "context-menu":
+			appendAction(actContextMenu)
+		case "yank-to":
+			appendAction(actYankTo)
+		case "put-from":
+			appendAction(actPutFrom)
+		default:
+			t := isExecuteAction(specLower)
+			if t == actIgnore {
+				if specIndex == 0 && specLower == "" {
+					actions = append(prevActions, actions...)
+				} else if match := countRegexp.FindStringSubmatch(spec); match != nil {
+					// ACTION(N) is the same as repeat(N,ACTION)
+					count, err := strconv.Atoi(match[2])
+					if err != nil || count < 1 || count > maxRepeat {
+						exit("invalid count: " + spec)
+						return nil
+					}
+					repeated := parseSingleActionList(match[1], exit)
+					if len(repeated) == 0 {
+						return nil
+					}
+					actions = append(actions, action{t: actRepeat, n: count, c: repeated})
+				} else {
+					exit("unknown action: " + spec)
+					return nil
+				}
+			} else {
+				var offset int
+				switch t {
+				case actReload:
+					offset = len("reload")
+				case actPreview:
+					offset = len("preview")
+				case actChangePrompt:
+					offset = len("change-prompt")
+				case actChangeNth:
+					offset = len("change-nth")
+				case actChangeDelimiter:
+					offset = len("change-delimiter")
+				case actChangeScheme:
+					offset = len("change-scheme")
+				case actTransform:
+					offset = len("transform")
+				case actDescription:
+					offset = len("desc")
+				case actSaveFilter:
+					offset = len("save-filter")
+				case actApplyFilter:
+					offset = len("apply-filter")
+				case actReserveRegion:
+					offset = len("reserve-region")
+				case actBecome:
+					offset = len("become")
+				case actBecomeWithState:
+					offset = len("become-with-state")
+				case actExecuteSilent:
+					offset = len("execute-silent")
+				case actExecuteMulti:
+					offset = len("execute-multi")
+				case actExecuteStatus:
+					offset = len("execute-status")
+				case actRepeat:
+					offset = len("repeat")
+				case actYankTo:
+					offset = len("yank-to")
+				case actPutFrom:
+					offset = len("put-from")
+				default:
+					offset = len("execute")
+				}
+				if spec[offset] == ':' {
+					if specIndex == len(specs)-1 {
+						actions = append(actions, action{t: t, a: spec[offset+1:]})
 					} else {
-						errorExit("unknown action: " + spec)
+						prevSpec = spec + "+"
+						continue
 					}
 				} else {
-					var offset int
-					switch t {
-					case actReload:
-						offset = len("reload")
-					case actPreview:
-						offset = len("preview")
-					case actChangePrompt:
-						offset = len("change-prompt")
-					case actExecuteSilent:
-						offset = len("execute-silent")
-					case actExecuteMulti:
-						offset = len("execute-multi")
-					default:
-						offset = len("execute")
+					actions = append(actions, action{t: t, a: spec[offset+1 : len(spec)-1]})
+				}
+				if t == actRepeat {
+					last := &actions[len(actions)-1]
+					tokens := strings.SplitN(last.a, ",", 2)
+					if len(tokens) < 2 {
+						exit("invalid repeat: " + spec)
+						return nil
 					}
-					if spec[offset] == ':' {
-						if specIndex == len(specs)-1 {
-							actions = append(actions, action{t: t, a: spec[offset+1:]})
-						} else {
-							prevSpec = spec + "+"
-							continue
+					count, err := strconv.Atoi(strings.TrimSpace(tokens[0]))
+					if err != nil || count < 1 || count > maxRepeat {
+						exit(fmt.Sprintf("invalid repeat count (1-%d): %s", maxRepeat, tokens[0]))
+						return nil
+					}
+					if last.c = parseSingleActionList(tokens[1], exit); len(last.c) == 0 {
+						exit("action required: " + spec)
+						return nil
+					}
+					if count*repetitions(last.c) > maxRepeat {
+						exit(fmt.Sprintf("too many repetitions (max: %d): %s", maxRepeat, spec))
+						return nil
+					}
+					last.n = count
+				}
+				if t == actReserveRegion {
+					if spec := actions[len(actions)-1].a; spec != "preview" {
+						if _, err := parseRegion(spec); err != nil {
+							exit(err.Error())
+							return nil
 						}
-					} else {
-						actions = append(actions, action{t: t, a: spec[offset+1 : len(spec)-1]})
+					}
+				}
+				if t == actChangeNth {
+					for _, expr := range strings.Split(actions[len(actions)-1].a, "|") {
+						if _, ok := parseWeightedNth(expr); !ok && len(expr) > 0 {
+							exit("invalid nth expression: " + expr)
+							return nil
+						}
+					}
+				}
+				if t == actChangeScheme {
+					for _, scheme := range strings.Split(actions[len(actions)-1].a, "|") {
+						if _, ok := schemeCriteria(scheme, nil); !ok && len(scheme) > 0 {
+							exit("invalid scoring scheme: " + scheme)
+							return nil
+						}
+					}
+				}
+				if t == actYankTo || t == actPutFrom {
+					name := actions[len(actions)-1].a
+					if _, ok := parseRegister(name); !ok {
+						exit("invalid register name: " + name)
+						return nil
+					}
+				}
+				if t == actSaveFilter || t == actApplyFilter {
+					if name := actions[len(actions)-1].a; len(name) == 0 || strings.ContainsAny(name, "\t\n") {
+						exit("invalid filter name: " + name)
+						return nil
 					}
 				}
 			}
-			prevSpec = ""
 		}
-		keymap[key] = actions
+		prevSpec = ""
 	}
+	return actions
 }
 
 func isExecuteAction(str string) actionType {
-	matches := executeRegexp.FindAllStringSubmatch(":"+str, -1)
+	matches := executeRegexp.FindAllStringSubmatch(maskRepeatActions(":"+str), -1)
 	if matches == nil || len(matches) != 1 {
 		return actIgnore
 	}
@@ -995,16 +2175,61 @@ func isExecuteAction(str string) actionType {
 		return actPreview
 	case "change-prompt":
 		return actChangePrompt
+	case "change-nth":
+		return actChangeNth
+	case "change-delimiter":
+		return actChangeDelimiter
+	case "change-scheme":
+		return actChangeScheme
+	case "transform":
+		return actTransform
+	case "desc":
+		return actDescription
+	case "save-filter":
+		return actSaveFilter
+	case "apply-filter":
+		return actApplyFilter
+	case "reserve-region":
+		return actReserveRegion
 	case "execute":
 		return actExecute
 	case "execute-silent":
 		return actExecuteSilent
 	case "execute-multi":
 		return actExecuteMulti
+	case "execute-status":
+		return actExecuteStatus
+	case "become":
+		return actBecome
+	case "become-with-state":
+		return actBecomeWithState
+	case "repeat":
+		return actRepeat
+	case "yank-to":
+		return actYankTo
+	case "put-from":
+		return actPutFrom
 	}
 	return actIgnore
 }
 
+// parseRegion parses the region given as TOP,LEFT,WIDTH,HEIGHT
+func parseRegion(str string) (*tui.Region, error) {
+	tokens := strings.Split(str, ",")
+	if len(tokens) != 4 {
+		return nil, fmt.Errorf("invalid region: %s", str)
+	}
+	var nums [4]int
+	for i, token := range tokens {
+		num, err := strconv.Atoi(strings.TrimSpace(token))
+		if err != nil || num < 0 || i >= 2 && num == 0 {
+			return nil, fmt.Errorf("invalid region: %s", str)
+		}
+		nums[i] = num
+	}
+	return &tui.Region{Top: nums[0], Left: nums[1], Width: nums[2], Height: nums[3]}, nil
+}
+
 func parseToggleSort(keymap map[tui.Event][]action, str string) {
 	keys := parseKeyChords(str, "key name required")
 	if len(keys) != 1 {
@@ -1038,12 +2263,39 @@ func parseSize(str string, maxPercent float64, label string) sizeSpec {
 			errorExit(label + " must be non-negative")
 		}
 	}
-	return sizeSpec{val, percent}
+	return sizeSpec{size: val, percent: percent}
 }
 
-func parseHeight(str string) sizeSpec {
+func parseHeight(str string) heightSpec {
+	if str == "auto" || strings.HasPrefix(str, "auto:") {
+		return parseAutoHeight(strings.TrimPrefix(str[4:], ":"))
+	}
 	size := parseSize(str, 100, "height")
-	return size
+	return heightSpec{size: size.size, percent: size.percent}
+}
+
+func parseAutoHeight(str string) heightSpec {
+	spec := heightSpec{size: 100, percent: true, auto: true}
+	if len(str) == 0 {
+		return spec
+	}
+	tokens := strings.Split(str, ",")
+	if len(tokens) > 2 {
+		errorExit("invalid height (expected: auto[:MIN,MAX[%]])")
+	}
+	if strings.HasSuffix(tokens[0], "%") {
+		errorExit("minimum height (auto) must be a non-negative integer")
+	}
+	spec.min = int(parseSize(tokens[0], 0, "minimum height").size)
+	if len(tokens) > 1 {
+		max := parseSize(tokens[1], 100, "maximum height")
+		if !max.percent && int(max.size) < spec.min {
+			errorExit("maximum height must not be smaller than minimum height")
+		}
+		spec.size = max.size
+		spec.percent = max.percent
+	}
+	return spec
 }
 
 func parseLayout(str string) layoutType {
@@ -1060,6 +2312,62 @@ func parseLayout(str string) layoutType {
 	return layoutDefault
 }
 
+func parseTui(str string) tuiBackend {
+	switch str {
+	case "auto":
+		return tuiAuto
+	case "light":
+		return tuiLight
+	case "tcell":
+		return tuiTcell
+	default:
+		errorExit("invalid renderer (expected: light / tcell / auto)")
+	}
+	return tuiAuto
+}
+
+func parseBell(str string) tui.BellStyle {
+	switch str {
+	case "none":
+		return tui.BellNone
+	case "audible":
+		return tui.BellAudible
+	case "visual":
+		return tui.BellVisual
+	case "flash-border":
+		return tui.BellFlashBorder
+	default:
+		errorExit("invalid bell style (expected: none / audible / visual / flash-border)")
+	}
+	return tui.BellNone
+}
+
+func parseRenderFormat(str string) renderFormat {
+	switch str {
+	case "plain":
+		return renderPlain
+	case "ansi":
+		return renderANSI
+	default:
+		errorExit("invalid render format (expected: plain / ansi)")
+	}
+	return renderPlain
+}
+
+func parsePasteMode(str string) pasteMode {
+	switch str {
+	case "space":
+		return pasteSpace
+	case "strip":
+		return pasteStrip
+	case "first-line":
+		return pasteFirstLine
+	default:
+		errorExit("invalid paste mode (expected: space / strip / first-line)")
+	}
+	return pasteSpace
+}
+
 func parseInfoStyle(str string) infoStyle {
 	switch str {
 	case "default":
@@ -1074,15 +2382,39 @@ func parseInfoStyle(str string) infoStyle {
 	return infoDefault
 }
 
+// parsePreviewWindow parses the layout of the preview window, which may be
+// followed or preceded by the alternative layouts for the narrow screens
+// separated by bars, e.g. <80(hidden)|<120(down:40%)|right:60%
 func parsePreviewWindow(opts *previewOpts, input string) {
-	tokens := strings.Split(input, ":")
+	ruleRegex := regexp.MustCompile(`^<([0-9]+)\((.*)\)$`)
+	rules := []previewRule{}
+	for _, spec := range strings.Split(input, "|") {
+		if match := ruleRegex.FindStringSubmatch(spec); match != nil {
+			// Validate the layout, which is applied when the screen is resized
+			parsePreviewTokens(&previewOpts{}, match[2])
+			rules = append(rules, previewRule{atoi(match[1]), match[2]})
+		} else {
+			parsePreviewTokens(opts, spec)
+		}
+	}
+	if len(rules) > 0 {
+		opts.rules = rules
+	}
+}
+
+func parsePreviewTokens(opts *previewOpts, input string) {
+	tokens := strings.FieldsFunc(input, func(r rune) bool {
+		return r == ':' || r == ','
+	})
 	sizeRegex := regexp.MustCompile("^[0-9]+%?$")
 	offsetRegex := regexp.MustCompile("^\\+([0-9]+|{-?[0-9]+})(-[0-9]+|-/[1-9][0-9]*)?$")
 	for _, token := range tokens {
 		switch token {
 		case "":
 		case "default":
+			label := opts.label
 			*opts = defaultPreviewOpts(opts.command)
+			opts.label = label
 		case "hidden":
 			opts.hidden = true
 		case "nohidden":
@@ -1126,27 +2458,46 @@ func parsePreviewWindow(opts *previewOpts, input string) {
 }
 
 func parseMargin(opt string, margin string) [4]sizeSpec {
-	margins := strings.Split(margin, ",")
-	checked := func(str string) sizeSpec {
-		return parseSize(str, 49, opt)
+	margins := []sizeSpec{}
+	for _, token := range strings.Split(margin, ",") {
+		// min=N and max=N limit the size of the preceding margin in percent
+		isMin := strings.HasPrefix(token, "min=")
+		if isMin || strings.HasPrefix(token, "max=") {
+			if len(margins) == 0 || !margins[len(margins)-1].percent {
+				errorExit(opt + " bounds require a size in percent: " + margin)
+			}
+			value := atoi(token[4:])
+			if value < 0 {
+				errorExit(opt + " bounds must be non-negative")
+			}
+			last := &margins[len(margins)-1]
+			if isMin {
+				last.min = value
+			} else {
+				last.max = value
+			}
+			if last.max > 0 && last.min > last.max {
+				errorExit(opt + " min must not be greater than max: " + margin)
+			}
+			continue
+		}
+		margins = append(margins, parseSize(token, 49, opt))
 	}
 	switch len(margins) {
 	case 1:
-		m := checked(margins[0])
+		m := margins[0]
 		return [4]sizeSpec{m, m, m, m}
 	case 2:
-		tb := checked(margins[0])
-		rl := checked(margins[1])
+		tb := margins[0]
+		rl := margins[1]
 		return [4]sizeSpec{tb, rl, tb, rl}
 	case 3:
-		t := checked(margins[0])
-		rl := checked(margins[1])
-		b := checked(margins[2])
+		t := margins[0]
+		rl := margins[1]
+		b := margins[2]
 		return [4]sizeSpec{t, rl, b, rl}
 	case 4:
-		return [4]sizeSpec{
-			checked(margins[0]), checked(margins[1]),
-			checked(margins[2]), checked(margins[3])}
+		return [4]sizeSpec{margins[0], margins[1], margins[2], margins[3]}
 	default:
 		errorExit("invalid " + opt + ": " + margin)
 	}
@@ -1154,27 +2505,11 @@ func parseMargin(opt string, margin string) [4]sizeSpec {
 }
 
 func parseOptions(opts *Options, allArgs []string) {
-	var historyMax int
-	if opts.History == nil {
-		historyMax = defaultHistoryMax
-	} else {
-		historyMax = opts.History.maxSize
-	}
-	setHistory := func(path string) {
-		h, e := NewHistory(path, historyMax)
-		if e != nil {
-			errorExit(e.Error())
-		}
-		opts.History = h
-	}
 	setHistoryMax := func(max int) {
-		historyMax = max
-		if historyMax < 1 {
+		if max < 1 {
 			errorExit("history max must be a positive integer")
 		}
-		if opts.History != nil {
-			opts.History.maxSize = historyMax
-		}
+		opts.HistoryMax = max
 	}
 	validateJumpLabels := false
 	validatePointer := false
@@ -1196,6 +2531,10 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Extended = false
 		case "+e", "--no-exact":
 			opts.Fuzzy = true
+		case "--regex":
+			opts.Regex = true
+		case "--no-regex":
+			opts.Regex = false
 		case "-q", "--query":
 			opts.Query = nextString(allArgs, &i, "query string required")
 		case "-f", "--filter":
@@ -1203,8 +2542,20 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Filter = &filter
 		case "--literal":
 			opts.Normalize = false
+		case "--prefilter":
+			opts.Prefilter = true
+		case "--no-prefilter":
+			opts.Prefilter = false
+		case "--typo-tolerance":
+			opts.Typos = nextInt(allArgs, &i, "number of typos required")
+		case "--fuzzy-typos":
+			opts.FuzzyTypos = nextInt(allArgs, &i, "number of typos required")
 		case "--no-literal":
 			opts.Normalize = true
+		case "--unicode-normalize":
+			opts.UnicodeNorm = true
+		case "--no-unicode-normalize":
+			opts.UnicodeNorm = false
 		case "--algo":
 			opts.FuzzyAlgo = parseAlgo(nextString(allArgs, &i, "algorithm required (v1|v2)"))
 		case "--expect":
@@ -1219,23 +2570,45 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Phony = true
 		case "--tiebreak":
 			opts.Criteria = parseTiebreak(nextString(allArgs, &i, "sort criterion required"))
+		case "--scheme":
+			opts.Scheme, opts.Scoring = parseScheme(nextString(allArgs, &i, "scoring scheme required"))
 		case "--bind":
-			parseKeymap(opts.Keymap, nextString(allArgs, &i, "bind expression required"))
+			parseKeymap(opts.Keymap, opts.KeyNames, opts.KeySpecs, nextString(allArgs, &i, "bind expression required"))
+		case "--leader":
+			opts.Leader = nextString(allArgs, &i, "leader key required")
+		case "--no-leader":
+			opts.Leader = ""
+		case "--menu":
+			opts.Menu = append(opts.Menu, parseMenuEntry(nextString(allArgs, &i, "menu entry required"), opts.Menu))
+		case "--no-menu":
+			opts.Menu = nil
 		case "--color":
 			_, spec := optionalNextString(allArgs, &i)
 			if len(spec) == 0 {
 				opts.Theme = tui.EmptyTheme()
 			} else {
-				opts.Theme = parseTheme(opts.Theme, spec)
+				opts.Theme = parseThemeWithAliases(opts.Theme, spec, opts.NamedColors)
 			}
 		case "--toggle-sort":
 			parseToggleSort(opts.Keymap, nextString(allArgs, &i, "key name required"))
 		case "-d", "--delimiter":
 			opts.Delimiter = delimiterRegexp(nextString(allArgs, &i, "delimiter required"))
 		case "-n", "--nth":
-			opts.Nth = splitNth(nextString(allArgs, &i, "nth expression required"))
+			opts.Nth = splitWeightedNth(nextString(allArgs, &i, "nth expression required"))
 		case "--with-nth":
-			opts.WithNth = splitNth(nextString(allArgs, &i, "nth expression required"))
+			opts.WithNth, opts.Formats = splitWithNth(nextString(allArgs, &i, "nth expression required"))
+		case "--search-field":
+			opts.SearchField = splitNth(nextString(allArgs, &i, "nth expression required"))
+		case "--key-field":
+			opts.KeyField = splitNth(nextString(allArgs, &i, "nth expression required"))
+		case "--no-key-field":
+			opts.KeyField = make([]Range, 0)
+		case "--bar-field":
+			opts.BarField = splitNth(nextString(allArgs, &i, "nth expression required"))
+		case "--no-bar-field":
+			opts.BarField = make([]Range, 0)
+		case "--bar-width":
+			opts.BarWidth = nextInt(allArgs, &i, "bar width required")
 		case "-s", "--sort":
 			opts.Sort = optionalNumeric(allArgs, &i, 1)
 		case "+s", "--no-sort":
@@ -1244,6 +2617,26 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Tac = true
 		case "--no-tac":
 			opts.Tac = false
+		case "--threads":
+			opts.Threads = nextInt(allArgs, &i, "number of threads required")
+		case "--low-priority-sort":
+			opts.LowPrioSort = true
+		case "--no-low-priority-sort":
+			opts.LowPrioSort = false
+		case "--disk-sort":
+			opts.DiskSort = nextInt(allArgs, &i, "number of matches required")
+		case "--no-disk-sort":
+			opts.DiskSort = 0
+		case "--trigram-index":
+			opts.TrigramMin = nextInt(allArgs, &i, "number of items required")
+		case "--no-trigram-index":
+			opts.TrigramMin = 0
+		case "--disk-sort-dir":
+			opts.DiskSortDir = nextString(allArgs, &i, "directory required")
+		case "--state-dir":
+			opts.StateDir = nextString(allArgs, &i, "directory required")
+		case "--cache-dir":
+			opts.CacheDir = nextString(allArgs, &i, "directory required")
 		case "-i":
 			opts.Case = CaseIgnore
 		case "+i":
@@ -1256,8 +2649,30 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Ansi = true
 		case "--no-ansi":
 			opts.Ansi = false
+		case "--ansi-bg":
+			opts.AnsiBg = parseAnsiBg(nextString(allArgs, &i, "background color policy required (item|theme|blend)"))
+		case "--match-style":
+			opts.MatchStyle = parseMatchStyle(nextString(allArgs, &i, "match style required (color|underline|bold|block)"))
 		case "--no-mouse":
 			opts.Mouse = false
+		case "--hover":
+			opts.Hover = true
+		case "--no-hover":
+			opts.Hover = false
+		case "--click-interval":
+			opts.ClickIntvl = time.Duration(nextInt(allArgs, &i, "click interval required")) * time.Millisecond
+		case "--chord-interval":
+			opts.ChordIntvl = time.Duration(nextInt(allArgs, &i, "chord interval required")) * time.Millisecond
+		case "--bell":
+			opts.Bell = parseBell(nextString(allArgs, &i, "bell style required (none / audible / visual / flash-border)"))
+		case "--no-bell":
+			opts.Bell = tui.BellNone
+		case "--paste":
+			opts.Paste = parsePasteMode(nextString(allArgs, &i, "paste mode required (space / strip / first-line)"))
+		case "--paste-filter":
+			opts.PasteFilter = nextString(allArgs, &i, "command required")
+		case "--no-paste-filter":
+			opts.PasteFilter = ""
 		case "+c", "--no-color":
 			opts.Theme = tui.NoColorTheme()
 		case "+2", "--no-256":
@@ -1291,10 +2706,19 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Hscroll = false
 		case "--hscroll-off":
 			opts.HscrollOff = nextInt(allArgs, &i, "hscroll offset required")
+		case "--wrap":
+			opts.Wrap = true
+		case "--no-wrap":
+			opts.Wrap = false
+		case "--wrap-sign":
+			str := nextString(allArgs, &i, "wrap sign required")
+			opts.WrapSign = &str
 		case "--filepath-word":
 			opts.FileWord = true
 		case "--no-filepath-word":
 			opts.FileWord = false
+		case "--word-boundaries":
+			opts.WordBounds = parseWordBoundaries(nextString(allArgs, &i, "word boundaries required (camel|snake|digit|none)"))
 		case "--info":
 			opts.InfoStyle = parseInfoStyle(
 				nextString(allArgs, &i, "info style required"))
@@ -1304,9 +2728,15 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.InfoStyle = infoInline
 		case "--no-inline-info":
 			opts.InfoStyle = infoDefault
+		case "--separator":
+			opts.Separator = true
+		case "--no-separator":
+			opts.Separator = false
 		case "--jump-labels":
 			opts.JumpLabels = nextString(allArgs, &i, "label characters required")
 			validateJumpLabels = true
+		case "--accept-all-confirm":
+			opts.ConfirmAll = nextInt(allArgs, &i, "number of items required")
 		case "-1", "--select-1":
 			opts.Select1 = true
 		case "+1", "--no-select-1":
@@ -1319,6 +2749,18 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.ReadZero = true
 		case "--no-read0":
 			opts.ReadZero = false
+		case "--decompress":
+			opts.Decompress = true
+		case "--no-decompress":
+			opts.Decompress = false
+		case "--disabled-prefix":
+			opts.DisabledPfx = nextString(allArgs, &i, "prefix required")
+		case "--no-disabled-prefix":
+			opts.DisabledPfx = ""
+		case "--item-ttl":
+			opts.ItemTTL = parseDuration(nextString(allArgs, &i, "item TTL required"))
+		case "--no-item-ttl":
+			opts.ItemTTL = 0
 		case "--print0":
 			opts.Printer = func(str string) { fmt.Print(str, "\x00") }
 			opts.PrintSep = "\x00"
@@ -1329,6 +2771,18 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.PrintQuery = true
 		case "--no-print-query":
 			opts.PrintQuery = false
+		case "--on-accept":
+			opts.OnAccept = nextString(allArgs, &i, "command required")
+		case "--on-exit":
+			opts.OnExit = nextString(allArgs, &i, "command required")
+		case "--log-file":
+			opts.LogFile = nextString(allArgs, &i, "log file path required")
+		case "--no-log-file":
+			opts.LogFile = ""
+		case "--dry-run":
+			opts.DryRun = true
+		case "--no-dry-run":
+			opts.DryRun = false
 		case "--prompt":
 			opts.Prompt = nextString(allArgs, &i, "prompt string required")
 		case "--pointer":
@@ -1337,24 +2791,59 @@ func parseOptions(opts *Options, allArgs []string) {
 		case "--marker":
 			opts.Marker = nextString(allArgs, &i, "selected sign string required")
 			validateMarker = true
+		case "--source-url":
+			opts.SourceURL = parseSourceURL(nextString(allArgs, &i, "source URL required"))
+		case "--no-source-url":
+			opts.SourceURL = ""
+		case "--listen":
+			opts.Listen = parseListen(nextString(allArgs, &i, "listen address required"))
+		case "--no-listen":
+			opts.Listen = ""
 		case "--sync":
 			opts.Sync = true
 		case "--no-sync":
 			opts.Sync = false
 		case "--async":
 			opts.Sync = false
+		case "--render-once":
+			opts.RenderOnce = renderPlain
+		case "--no-render-once":
+			opts.RenderOnce = renderNone
 		case "--no-history":
-			opts.History = nil
+			opts.HistoryFile = nil
 		case "--history":
-			setHistory(nextString(allArgs, &i, "history file path required"))
+			// The file in the state directory if not specified
+			_, path := optionalNextString(allArgs, &i)
+			opts.HistoryFile = &path
 		case "--history-size":
 			setHistoryMax(nextInt(allArgs, &i, "history max size required"))
+		case "--rank-log":
+			_, path := optionalNextString(allArgs, &i)
+			opts.RankLog = NewRankLog(path, defaultRankLogSize)
+		case "--no-rank-log":
+			opts.RankLog = nil
 		case "--no-header":
 			opts.Header = []string{}
 		case "--no-header-lines":
 			opts.HeaderLines = 0
 		case "--header":
 			opts.Header = strLines(nextString(allArgs, &i, "header string required"))
+		case "--hint-bar":
+			opts.HintBar = true
+		case "--no-hint-bar":
+			opts.HintBar = false
+		case "--form":
+			opts.Form = parseForm(nextString(allArgs, &i, "form fields required"))
+		case "--no-form":
+			opts.Form = []formField{}
+		case "--tab":
+			opts.Tabs = append(opts.Tabs, parseTab(nextString(allArgs, &i, "tab required")))
+		case "--no-tab":
+			opts.Tabs = nil
+		case "--title":
+			opts.Title = parseTitle(nextString(allArgs, &i, "title required"))
+		case "--no-title":
+			opts.Title = []titleSegment{}
 		case "--header-lines":
 			opts.HeaderLines = atoi(
 				nextString(allArgs, &i, "number of header lines required"))
@@ -1362,6 +2851,14 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.Preview.command = nextString(allArgs, &i, "preview command required")
 		case "--no-preview":
 			opts.Preview.command = ""
+		case "--preview-fallback":
+			opts.Fallbacks = append(opts.Fallbacks, nextString(allArgs, &i, "preview fallback command required"))
+		case "--no-preview-fallback":
+			opts.Fallbacks = nil
+		case "--preview-osc":
+			opts.PreviewOSCs = parsePreviewOSCs(nextString(allArgs, &i, "OSC numbers required (52,1337)"))
+		case "--no-preview-osc":
+			opts.PreviewOSCs = nil
 		case "--preview-window":
 			parsePreviewWindow(&opts.Preview,
 				nextString(allArgs, &i, "preview window layout required: [up|down|left|right][:SIZE[%]][:rounded|sharp|noborder][:wrap][:cycle][:hidden][:+SCROLL[-OFFSET]][:default]"))
@@ -1370,7 +2867,7 @@ func parseOptions(opts *Options, allArgs []string) {
 		case "--min-height":
 			opts.MinHeight = nextInt(allArgs, &i, "height required: HEIGHT")
 		case "--no-height":
-			opts.Height = sizeSpec{}
+			opts.Height = heightSpec{}
 		case "--no-margin":
 			opts.Margin = defaultMargin()
 		case "--no-padding":
@@ -1379,11 +2876,51 @@ func parseOptions(opts *Options, allArgs []string) {
 			opts.BorderShape = tui.BorderNone
 		case "--border":
 			hasArg, arg := optionalNextString(allArgs, &i)
-			opts.BorderShape = parseBorder(arg, !hasArg)
+			opts.BorderShape, opts.BorderSides = parseBorder(arg, !hasArg)
+		case "--border-chars":
+			opts.BorderChars = parseBorderChars(nextString(allArgs, &i, "border characters required"))
+		case "--no-border-chars":
+			opts.BorderChars = nil
+		case "--border-label":
+			opts.BorderLabel = addBorderLabel(opts.BorderLabel, nextString(allArgs, &i, "label required"))
+		case "--border-label-pos":
+			parseLabelPos(lastBorderLabel(opts), nextString(allArgs, &i, "label position required"))
+		case "--no-border-label":
+			opts.BorderLabel = nil
+		case "--preview-label":
+			opts.Preview.label.label = nextString(allArgs, &i, "preview label required")
+		case "--preview-label-pos":
+			parseLabelPos(&opts.Preview.label, nextString(allArgs, &i, "preview label position required"))
+		case "--source":
+			opts.Sources = append(opts.Sources, nextString(allArgs, &i, "source required"))
+		case "--no-source":
+			opts.Sources = nil
+		case "--background-command":
+			opts.BgCommand = nextString(allArgs, &i, "background command required")
+		case "--no-background-command":
+			opts.BgCommand = ""
+		case "--shadow":
+			opts.Shadow = true
+		case "--no-shadow":
+			opts.Shadow = false
+		case "--scrollbar":
+			opts.Scrollbar = true
+		case "--no-scrollbar":
+			opts.Scrollbar = false
 		case "--no-unicode":
 			opts.Unicode = false
 		case "--unicode":
 			opts.Unicode = true
+		case "--tui":
+			opts.Tui = parseTui(nextString(allArgs, &i, "renderer required (light / tcell / auto)"))
+		case "--tmux-pane":
+			opts.TmuxPane = nextString(allArgs, &i, "tmux pane required")
+		case "--no-tmux-pane":
+			opts.TmuxPane = ""
+		case "--no-unicode-autodetect":
+			opts.UnicodeAuto = false
+		case "--unicode-autodetect":
+			opts.UnicodeAuto = true
 		case "--margin":
 			opts.Margin = parseMargin(
 				"margin",
@@ -1394,10 +2931,16 @@ func parseOptions(opts *Options, allArgs []string) {
 				nextString(allArgs, &i, "padding required (TRBL / TB,RL / T,RL,B / T,R,B,L)"))
 		case "--tabstop":
 			opts.Tabstop = nextInt(allArgs, &i, "tab stop required")
+		case "--ambiguous-width":
+			opts.AmbigWidth = parseCharWidth("ambiguous", nextString(allArgs, &i, "width required (1|2|auto)"))
+		case "--emoji-width":
+			opts.EmojiWidth = parseCharWidth("emoji", nextString(allArgs, &i, "width required (1|2|auto)"))
 		case "--clear":
 			opts.ClearOnExit = true
 		case "--no-clear":
 			opts.ClearOnExit = false
+		case "--init":
+			opts.Init = parseInit(nextString(allArgs, &i, "shell required (bash|zsh|fish)"))
 		case "--version":
 			opts.Version = true
 		default:
@@ -1410,25 +2953,65 @@ func parseOptions(opts *Options, allArgs []string) {
 			} else if match, value := optString(arg, "-d", "--delimiter="); match {
 				opts.Delimiter = delimiterRegexp(value)
 			} else if match, value := optString(arg, "--border="); match {
-				opts.BorderShape = parseBorder(value, false)
+				opts.BorderShape, opts.BorderSides = parseBorder(value, false)
+			} else if match, value := optString(arg, "--border-chars="); match {
+				opts.BorderChars = parseBorderChars(value)
+			} else if match, value := optString(arg, "--border-label="); match {
+				opts.BorderLabel = addBorderLabel(opts.BorderLabel, value)
+			} else if match, value := optString(arg, "--border-label-pos="); match {
+				parseLabelPos(lastBorderLabel(opts), value)
+			} else if match, value := optString(arg, "--preview-label="); match {
+				opts.Preview.label.label = value
+			} else if match, value := optString(arg, "--preview-label-pos="); match {
+				parseLabelPos(&opts.Preview.label, value)
+			} else if match, value := optString(arg, "--match-style="); match {
+				opts.MatchStyle = parseMatchStyle(value)
+			} else if match, value := optString(arg, "--source="); match {
+				opts.Sources = append(opts.Sources, value)
+			} else if match, value := optString(arg, "--background-command="); match {
+				opts.BgCommand = value
+			} else if match, value := optString(arg, "--ansi-bg="); match {
+				opts.AnsiBg = parseAnsiBg(value)
+			} else if match, value := optString(arg, "--init="); match {
+				opts.Init = parseInit(value)
+			} else if match, value := optString(arg, "--source-url="); match {
+				opts.SourceURL = parseSourceURL(value)
+			} else if match, value := optString(arg, "--listen="); match {
+				opts.Listen = parseListen(value)
 			} else if match, value := optString(arg, "--prompt="); match {
 				opts.Prompt = value
 			} else if match, value := optString(arg, "--pointer="); match {
 				opts.Pointer = value
 				validatePointer = true
+			} else if match, value := optString(arg, "--wrap-sign="); match {
+				opts.WrapSign = &value
+			} else if match, value := optString(arg, "--disabled-prefix="); match {
+				opts.DisabledPfx = value
+			} else if match, value := optString(arg, "--item-ttl="); match {
+				opts.ItemTTL = parseDuration(value)
 			} else if match, value := optString(arg, "--marker="); match {
 				opts.Marker = value
 				validateMarker = true
 			} else if match, value := optString(arg, "-n", "--nth="); match {
-				opts.Nth = splitNth(value)
+				opts.Nth = splitWeightedNth(value)
 			} else if match, value := optString(arg, "--with-nth="); match {
-				opts.WithNth = splitNth(value)
+				opts.WithNth, opts.Formats = splitWithNth(value)
+			} else if match, value := optString(arg, "--search-field="); match {
+				opts.SearchField = splitNth(value)
+			} else if match, value := optString(arg, "--key-field="); match {
+				opts.KeyField = splitNth(value)
+			} else if match, value := optString(arg, "--bar-field="); match {
+				opts.BarField = splitNth(value)
+			} else if match, value := optString(arg, "--bar-width="); match {
+				opts.BarWidth = atoi(value)
 			} else if match, _ := optString(arg, "-s", "--sort="); match {
 				opts.Sort = 1 // Don't care
 			} else if match, value := optString(arg, "-m", "--multi="); match {
 				opts.Multi = atoi(value)
 			} else if match, value := optString(arg, "--height="); match {
 				opts.Height = parseHeight(value)
+			} else if match, value := optString(arg, "--threads="); match {
+				opts.Threads = atoi(value)
 			} else if match, value := optString(arg, "--min-height="); match {
 				opts.MinHeight = atoi(value)
 			} else if match, value := optString(arg, "--layout="); match {
@@ -1443,33 +3026,95 @@ func parseOptions(opts *Options, allArgs []string) {
 				}
 			} else if match, value := optString(arg, "--tiebreak="); match {
 				opts.Criteria = parseTiebreak(value)
+			} else if match, value := optString(arg, "--word-boundaries="); match {
+				opts.WordBounds = parseWordBoundaries(value)
+			} else if match, value := optString(arg, "--scheme="); match {
+				opts.Scheme, opts.Scoring = parseScheme(value)
 			} else if match, value := optString(arg, "--color="); match {
-				opts.Theme = parseTheme(opts.Theme, value)
+				opts.Theme = parseThemeWithAliases(opts.Theme, value, opts.NamedColors)
 			} else if match, value := optString(arg, "--bind="); match {
-				parseKeymap(opts.Keymap, value)
+				parseKeymap(opts.Keymap, opts.KeyNames, opts.KeySpecs, value)
+			} else if match, value := optString(arg, "--leader="); match {
+				opts.Leader = value
+			} else if match, value := optString(arg, "--menu="); match {
+				opts.Menu = append(opts.Menu, parseMenuEntry(value, opts.Menu))
 			} else if match, value := optString(arg, "--history="); match {
-				setHistory(value)
+				opts.HistoryFile = &value
 			} else if match, value := optString(arg, "--history-size="); match {
 				setHistoryMax(atoi(value))
+			} else if match, value := optString(arg, "--on-accept="); match {
+				opts.OnAccept = value
+			} else if match, value := optString(arg, "--on-exit="); match {
+				opts.OnExit = value
+			} else if match, value := optString(arg, "--log-file="); match {
+				opts.LogFile = value
+			} else if match, value := optString(arg, "--rank-log="); match {
+				opts.RankLog = NewRankLog(value, defaultRankLogSize)
 			} else if match, value := optString(arg, "--header="); match {
 				opts.Header = strLines(value)
+			} else if match, value := optString(arg, "--form="); match {
+				opts.Form = parseForm(value)
+			} else if match, value := optString(arg, "--tab="); match {
+				opts.Tabs = append(opts.Tabs, parseTab(value))
+			} else if match, value := optString(arg, "--tui="); match {
+				opts.Tui = parseTui(value)
+			} else if match, value := optString(arg, "--click-interval="); match {
+				opts.ClickIntvl = time.Duration(atoi(value)) * time.Millisecond
+			} else if match, value := optString(arg, "--chord-interval="); match {
+				opts.ChordIntvl = time.Duration(atoi(value)) * time.Millisecond
+			} else if match, value := optString(arg, "--bell="); match {
+				opts.Bell = parseBell(value)
+			} else if match, value := optString(arg, "--render-once="); match {
+				opts.RenderOnce = parseRenderFormat(value)
+			} else if match, value := optString(arg, "--paste="); match {
+				opts.Paste = parsePasteMode(value)
+			} else if match, value := optString(arg, "--paste-filter="); match {
+				opts.PasteFilter = value
+			} else if match, value := optString(arg, "--tmux-pane="); match {
+				opts.TmuxPane = value
+			} else if match, value := optString(arg, "--title="); match {
+				opts.Title = parseTitle(value)
 			} else if match, value := optString(arg, "--header-lines="); match {
 				opts.HeaderLines = atoi(value)
 			} else if match, value := optString(arg, "--preview="); match {
 				opts.Preview.command = value
+			} else if match, value := optString(arg, "--preview-fallback="); match {
+				opts.Fallbacks = append(opts.Fallbacks, value)
+			} else if match, value := optString(arg, "--preview-osc="); match {
+				opts.PreviewOSCs = parsePreviewOSCs(value)
 			} else if match, value := optString(arg, "--preview-window="); match {
 				parsePreviewWindow(&opts.Preview, value)
 			} else if match, value := optString(arg, "--margin="); match {
 				opts.Margin = parseMargin("margin", value)
 			} else if match, value := optString(arg, "--padding="); match {
 				opts.Padding = parseMargin("padding", value)
+			} else if match, value := optString(arg, "--typo-tolerance="); match {
+				opts.Typos = atoi(value)
+			} else if match, value := optString(arg, "--fuzzy-typos="); match {
+				opts.FuzzyTypos = atoi(value)
 			} else if match, value := optString(arg, "--tabstop="); match {
 				opts.Tabstop = atoi(value)
+			} else if match, value := optString(arg, "--ambiguous-width="); match {
+				opts.AmbigWidth = parseCharWidth("ambiguous", value)
+			} else if match, value := optString(arg, "--emoji-width="); match {
+				opts.EmojiWidth = parseCharWidth("emoji", value)
 			} else if match, value := optString(arg, "--hscroll-off="); match {
 				opts.HscrollOff = atoi(value)
 			} else if match, value := optString(arg, "--jump-labels="); match {
 				opts.JumpLabels = value
 				validateJumpLabels = true
+			} else if match, value := optString(arg, "--disk-sort="); match {
+				opts.DiskSort = atoi(value)
+			} else if match, value := optString(arg, "--trigram-index="); match {
+				opts.TrigramMin = atoi(value)
+			} else if match, value := optString(arg, "--disk-sort-dir="); match {
+				opts.DiskSortDir = value
+			} else if match, value := optString(arg, "--state-dir="); match {
+				opts.StateDir = value
+			} else if match, value := optString(arg, "--cache-dir="); match {
+				opts.CacheDir = value
+			} else if match, value := optString(arg, "--accept-all-confirm="); match {
+				opts.ConfirmAll = atoi(value)
 			} else {
 				errorExit("unknown option: " + arg)
 			}
@@ -1484,10 +3129,50 @@ func parseOptions(opts *Options, allArgs []string) {
 		errorExit("hscroll offset must be a non-negative integer")
 	}
 
+	if opts.ClickIntvl <= 0 {
+		errorExit("click interval must be a positive integer")
+	}
+
+	if opts.ChordIntvl <= 0 {
+		errorExit("chord interval must be a positive integer")
+	}
+
+	if opts.ItemTTL < 0 {
+		errorExit("item TTL must be non-negative")
+	}
+
+	if opts.BarWidth <= 0 {
+		errorExit("bar width must be a positive integer")
+	}
+
+	if opts.Typos < 0 || opts.FuzzyTypos < 0 {
+		errorExit("number of typos must be a non-negative integer")
+	}
+
+	if opts.FuzzyTypos > algo.MaxFuzzyTypos {
+		errorExit(fmt.Sprintf("number of typos in fuzzy terms must not exceed %d", algo.MaxFuzzyTypos))
+	}
+
+	if opts.DiskSort < 0 {
+		errorExit("disk sort threshold must be a non-negative integer")
+	}
+
+	if opts.TrigramMin < 0 {
+		errorExit("trigram index threshold must be a non-negative integer")
+	}
+
+	if opts.ConfirmAll < 0 {
+		errorExit("accept-all confirmation threshold must be a non-negative integer")
+	}
+
 	if opts.Tabstop < 1 {
 		errorExit("tab stop must be a positive integer")
 	}
 
+	if opts.Threads < 0 {
+		errorExit("number of threads must be a non-negative integer")
+	}
+
 	if len(opts.JumpLabels) == 0 {
 		errorExit("empty jump labels")
 	}
@@ -1534,6 +3219,49 @@ func postProcessOptions(opts *Options) {
 	if !tui.IsLightRendererSupported() && opts.Height.size > 0 {
 		errorExit("--height option is currently not supported on this platform")
 	}
+	if !tui.IsLightRendererSupported() && opts.Tui == tuiLight {
+		errorExit("--tui=light is currently not supported on this platform")
+	}
+	if opts.Tui == tuiTcell && (opts.Height.auto || opts.Height.size > 0 && !(opts.Height.percent && opts.Height.size == 100)) {
+		errorExit("--tui=tcell cannot be used with --height")
+	}
+	// The history file and the rank log are kept in the state directory unless
+	// the paths are given
+	if opts.HistoryFile != nil {
+		path := *opts.HistoryFile
+		if len(path) == 0 {
+			var err error
+			if path, err = stateFile(opts.StateDir, defaultHistoryFile); err != nil {
+				errorExit(err.Error())
+			}
+		}
+		h, err := NewHistory(path, opts.HistoryMax)
+		if err != nil {
+			errorExit(err.Error())
+		}
+		opts.History = h
+	}
+	if opts.RankLog != nil && len(opts.RankLog.path) == 0 {
+		path, err := stateFile(opts.StateDir, defaultRankLogFile)
+		if err != nil {
+			errorExit(err.Error())
+		}
+		opts.RankLog.path = path
+	}
+
+	// The list of the first tab is read unless the source is given
+	if len(opts.Tabs) > 0 && len(opts.Sources) == 0 && len(opts.SourceURL) == 0 {
+		opts.Sources = []string{opts.Tabs[0].command}
+	}
+
+	// The temporary files of --disk-sort are written to the cache directory,
+	// as the default temporary directory can be on memory
+	if opts.DiskSort > 0 && len(opts.DiskSortDir) == 0 {
+		if dir, err := cacheDir(opts.CacheDir); err == nil {
+			opts.DiskSortDir = dir
+		}
+	}
+
 	// Default actions for CTRL-N / CTRL-P when --history is set
 	if opts.History != nil {
 		if _, prs := opts.Keymap[tui.CtrlP.AsEvent()]; !prs {
@@ -1544,21 +3272,47 @@ func postProcessOptions(opts *Options) {
 		}
 	}
 
+	// Bind the keys following the leader key
+	leader := tui.Leader.AsEvent()
+	if actions, prs := opts.Keymap[leader]; prs {
+		delete(opts.Keymap, leader)
+		if len(opts.Leader) == 0 {
+			errorExit("leader key not specified (--leader)")
+		}
+		key, name := parseKey(opts.Leader)
+		chord := actions[0].k
+		if prev := opts.Keymap[key]; len(prev) == 1 && prev[0].t == actChord {
+			prev[0].k.merge(chord)
+		} else {
+			chord.rename(name)
+			opts.Keymap[key] = actions
+		}
+	}
+
 	// Extend the default key map
 	keymap := defaultKeymap()
 	for key, actions := range opts.Keymap {
-		for _, act := range actions {
-			if act.t == actToggleSort {
-				opts.ToggleSort = true
-			}
+		if hasAction(actions, actToggleSort) {
+			opts.ToggleSort = true
+		}
+		if hasAction(actions, actChangeScheme) {
+			opts.ShowScheme = true
 		}
 		keymap[key] = actions
 	}
 	opts.Keymap = keymap
+	for _, entry := range opts.Menu {
+		if hasAction(entry.actions, actToggleSort) {
+			opts.ToggleSort = true
+		}
+		if hasAction(entry.actions, actChangeScheme) {
+			opts.ShowScheme = true
+		}
+	}
 
 	// If we're not using extended search mode, --nth option becomes irrelevant
-	// if it contains the whole range
-	if !opts.Extended || len(opts.Nth) == 1 {
+	// if it contains the whole range, unless the fields are weighted
+	if (!opts.Extended || len(opts.Nth) == 1) && !weightedNth(opts.Nth) {
 		for _, r := range opts.Nth {
 			if r.begin == rangeEllipsis && r.end == rangeEllipsis {
 				opts.Nth = make([]Range, 0)
@@ -1601,5 +3355,11 @@ func ParseOptions() *Options {
 	parseOptions(opts, os.Args[1:])
 
 	postProcessOptions(opts)
+
+	// State left by become-with-state action of the previous finder
+	opts.State = loadState()
+	if opts.State != nil && len(opts.Query) == 0 {
+		opts.Query = opts.State.query
+	}
 	return opts
 }
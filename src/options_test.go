@@ -3,8 +3,12 @@ package fzf
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/junegunn/fzf/src/algo"
 	"github.com/junegunn/fzf/src/tui"
 )
 
@@ -92,6 +96,32 @@ func TestSplitNth(t *testing.T) {
 	}
 }
 
+func TestWeightedNth(t *testing.T) {
+	ranges, ok := parseWeightedNth("1:3,2..,-1:10")
+	if !ok || len(ranges) != 3 ||
+		ranges[0].begin != rangeEllipsis || ranges[0].end != 1 || ranges[0].weight != 3 ||
+		ranges[1].begin != 2 || ranges[1].weight != 0 ||
+		ranges[2].begin != -1 || ranges[2].weight != 10 {
+		t.Errorf("%v", ranges)
+	}
+	for _, expr := range []string{"1:0", "1:-1", "1:", "1:x", ":2"} {
+		if _, ok := parseWeightedNth(expr); ok {
+			t.Errorf("%q should be invalid", expr)
+		}
+	}
+	if _, ok := parseNth("1:3"); ok {
+		t.Error("weights are only allowed in --nth")
+	}
+
+	// The whole range is relevant when the fields are weighted
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--nth", "1:3,..", "+x"})
+	postProcessOptions(opts)
+	if len(opts.Nth) != 2 {
+		t.Errorf("%v", opts.Nth)
+	}
+}
+
 func TestIrrelevantNth(t *testing.T) {
 	{
 		opts := defaultOptions()
@@ -179,6 +209,21 @@ func TestParseKeys(t *testing.T) {
 	check(tui.BTab, "shift-tab")
 	check(tui.CtrlM, "Enter")
 	check(tui.BSpace, "bspace")
+
+	// Keys of the keyboard protocol of kitty
+	pairs = parseKeyChords("ctrl-m,ctrl-[,ctrl-shift-P,shift-ctrl-q,ctrl-.,ctrl-,,super-k,ctrl-alt-;,ctrl-a", "")
+	if len(pairs) != 9 {
+		t.Error(9)
+	}
+	check(tui.CtrlM, "ctrl-m")
+	check(tui.ESC, "ctrl-[")
+	checkEvent(tui.CtrlShiftKey('p'), "ctrl-shift-P")
+	checkEvent(tui.CtrlShiftKey('q'), "shift-ctrl-q")
+	checkEvent(tui.CtrlKey('.'), "ctrl-.")
+	checkEvent(tui.CtrlKey(','), "ctrl-,")
+	checkEvent(tui.SuperKey('k'), "super-k")
+	checkEvent(tui.CtrlAltKey(';'), "ctrl-alt-;")
+	check(tui.CtrlA, "ctrl-a")
 }
 
 func TestParseKeysWithComma(t *testing.T) {
@@ -249,7 +294,7 @@ func TestBind(t *testing.T) {
 		}
 	}
 	check(tui.CtrlA.AsEvent(), "", actBeginningOfLine)
-	parseKeymap(keymap,
+	parseKeymap(keymap, nil, nil,
 		"ctrl-a:kill-line,ctrl-b:toggle-sort+up+down,c:page-up,alt-z:page-down,"+
 			"f1:execute(ls {+})+abort+execute(echo {+})+select-all,f2:execute/echo {}, {}, {}/,f3:execute[echo '({})'],f4:execute;less {};,"+
 			"alt-a:execute-Multi@echo (,),[,],/,:,;,%,{}@,alt-b:execute;echo (,),[,],/,:,@,%,{};,"+
@@ -273,12 +318,168 @@ func TestBind(t *testing.T) {
 	check(tui.Key('+'), "++\nfoobar,Y:execute(baz)+up", actExecute)
 
 	for idx, char := range []rune{'~', '!', '@', '#', '$', '%', '^', '&', '*', '|', ';', '/'} {
-		parseKeymap(keymap, fmt.Sprintf("%d:execute%cfoobar%c", idx%10, char, char))
+		parseKeymap(keymap, nil, nil, fmt.Sprintf("%d:execute%cfoobar%c", idx%10, char, char))
 		check(tui.Key([]rune(fmt.Sprintf("%d", idx%10))[0]), "foobar", actExecute)
 	}
 
-	parseKeymap(keymap, "f1:abort")
+	parseKeymap(keymap, nil, nil, "f1:abort")
 	check(tui.F1.AsEvent(), "", actAbort)
+
+	parseKeymap(keymap, nil, nil, "f2:become(vim {}),f3:become-with-state[fzf --multi]+up,f4:become:less {}")
+	check(tui.F2.AsEvent(), "vim {}", actBecome)
+	check(tui.F3.AsEvent(), "fzf --multi", actBecomeWithState, actUp)
+	check(tui.F4.AsEvent(), "less {}", actBecome)
+
+	parseKeymap(keymap, nil, nil, "tab:next-field,btab:previous-field+first")
+	check(tui.Tab.AsEvent(), "", actNextField)
+	check(tui.BTab.AsEvent(), "", actPreviousField, actFirst)
+
+	parseKeymap(keymap, nil, nil, "f5:toggle-info+toggle-separator,f6:grow-header,f7:shrink-header")
+	check(tui.F5.AsEvent(), "", actToggleInfo, actToggleSeparator)
+	check(tui.F6.AsEvent(), "", actGrowHeader)
+	check(tui.F7.AsEvent(), "", actShrinkHeader)
+
+	parseKeymap(keymap, nil, nil, "f8:save-filter(todo),f9:apply-filter[go files]+first,f10:apply-filter:a,b")
+	check(tui.F8.AsEvent(), "todo", actSaveFilter)
+	check(tui.F9.AsEvent(), "go files", actApplyFilter, actFirst)
+	check(tui.F10.AsEvent(), "a,b", actApplyFilter)
+
+	parseKeymap(keymap, nil, nil, "ctrl-,:up,ctrl-i:down,super-::first")
+	check(tui.CtrlKey(','), "", actUp)
+	check(tui.CtrlKey('i'), "", actDown)
+	if alias := ctrlAliasKey(tui.CtrlM.AsEvent(), "Ctrl-M"); alias != tui.CtrlKey('m') {
+		t.Errorf("%v", alias)
+	}
+	if alias := ctrlAliasKey(tui.CtrlM.AsEvent(), "enter"); alias != tui.CtrlM.AsEvent() {
+		t.Errorf("%v", alias)
+	}
+	check(tui.SuperKey(':'), "", actFirst)
+
+	parseKeymap(keymap, nil, nil, "f11:repeat(5,toggle+down)+first,f12:repeat[2,execute(echo {})]")
+	check(tui.F11.AsEvent(), "5,toggle+down", actRepeat, actFirst)
+	if repeat := keymap[tui.F11.AsEvent()][0]; repeat.n != 5 || len(repeat.c) != 2 || repeat.c[0].t != actToggle || repeat.c[1].t != actDown {
+		t.Errorf("%v", repeat)
+	}
+	if repeat := keymap[tui.F12.AsEvent()][0]; repeat.n != 2 || len(repeat.c) != 1 || repeat.c[0].t != actExecute || repeat.c[0].a != "echo {}" {
+		t.Errorf("%v", repeat)
+	}
+
+	// The nested actions can have parentheses
+	parseKeymap(keymap, nil, nil, "f10:repeat(3,execute(echo {})+down),f9:first")
+	check(tui.F10.AsEvent(), "3,execute(echo {})+down", actRepeat)
+	if repeat := keymap[tui.F10.AsEvent()][0]; repeat.n != 3 || len(repeat.c) != 2 || repeat.c[0].a != "echo {}" || repeat.c[1].t != actDown {
+		t.Errorf("%v", repeat)
+	}
+	check(tui.F9.AsEvent(), "", actFirst)
+
+	check(tui.ScrollLeft.AsEvent(), "", actMouse)
+	parseKeymap(keymap, nil, nil, "scroll-left:preview-up,scroll-right:preview-down")
+	check(tui.ScrollLeft.AsEvent(), "", actPreviewUp)
+	check(tui.ScrollRight.AsEvent(), "", actPreviewDown)
+
+	parseKeymap(keymap, nil, nil, "ctrl-a:accept-all,ctrl-b:select-all+accept-all")
+	check(tui.CtrlA.AsEvent(), "", actAcceptAll)
+	check(tui.CtrlB.AsEvent(), "", actSelectAll, actAcceptAll)
+
+	parseKeymap(keymap, nil, nil, "triple-click:select+accept")
+	check(tui.TripleClick.AsEvent(), "", actSelect, actAccept)
+
+	parseKeymap(keymap, nil, nil, "click-border:abort,click-preview-border:toggle-preview,click-scrollbar:first,click-info:toggle-sort")
+	check(tui.ClickBorder.AsEvent(), "", actAbort)
+	check(tui.ClickPreviewBorder.AsEvent(), "", actTogglePreview)
+	check(tui.ClickScrollbar.AsEvent(), "", actFirst)
+	check(tui.ClickInfo.AsEvent(), "", actToggleSort)
+
+	parseKeymap(keymap, nil, nil, "f1:reserve-region(1,2,30,10),f2:reserve-region(preview)+release-region")
+	check(tui.F1.AsEvent(), "1,2,30,10", actReserveRegion)
+	check(tui.F2.AsEvent(), "preview", actReserveRegion, actReleaseRegion)
+
+	parseKeymap(keymap, nil, nil, "paste:first")
+	check(tui.Paste.AsEvent(), "", actFirst)
+
+	parseKeymap(keymap, nil, nil, "ctrl-x>ctrl-e:first,ctrl-x>a>b:last+up,ctrl-x>a>c:down,ctrl-x>ctrl-e:+up")
+	check(tui.CtrlX.AsEvent(), "", actChord)
+	chord := keymap[tui.CtrlX.AsEvent()][0].k
+	if chord.name != "ctrl-x" || chord.names[tui.CtrlE.AsEvent()] != "ctrl-e" || chord.specs[tui.CtrlE.AsEvent()] != "+up" {
+		t.Errorf("%v", chord)
+	}
+	if actions := chord.keymap[tui.CtrlE.AsEvent()]; len(actions) != 2 || actions[0].t != actFirst || actions[1].t != actUp {
+		t.Errorf("%v", actions)
+	}
+	if actions := chord.keymap[tui.Key('a')]; len(actions) != 1 || actions[0].k.name != "ctrl-x>a" || len(actions[0].k.keymap) != 2 ||
+		len(actions[0].k.keymap[tui.Key('b')]) != 2 || actions[0].k.keymap[tui.Key('c')][0].t != actDown {
+		t.Errorf("%v", actions)
+	}
+	if !hasAction(keymap[tui.CtrlX.AsEvent()], actLast) {
+		t.Errorf("last action not found in the chord")
+	}
+	parseKeymap(keymap, nil, nil, "ctrl-x:abort")
+	check(tui.CtrlX.AsEvent(), "", actAbort)
+
+	parseKeymap(keymap, nil, nil, "focus-gained:enable-search,focus-out:disable-search")
+	check(tui.FocusGained.AsEvent(), "", actEnableSearch)
+	check(tui.FocusLost.AsEvent(), "", actDisableSearch)
+
+	names := make(map[tui.Event]string)
+	specs := make(map[tui.Event]string)
+	parseKeymap(keymap, names, specs, "ctrl-o:execute(open {})+#Open in default app (a+b),ctrl-r:#Nothing,::up")
+	check(tui.CtrlO.AsEvent(), "open {}", actExecute, actDescription)
+	check(tui.CtrlR.AsEvent(), "Nothing", actDescription)
+	if desc := actionDescription(keymap[tui.CtrlO.AsEvent()]); desc != "Open in default app (a+b)" {
+		t.Errorf("%s", desc)
+	}
+	if names[tui.CtrlO.AsEvent()] != "ctrl-o" || names[tui.Key(':')] != ":" {
+		t.Errorf("%v", names)
+	}
+	hints := bindingHints(keymap, names)
+	if len(hints) != 2 || hints[0] != (bindingHint{"ctrl-o", "Open in default app (a+b)"}) || hints[1] != (bindingHint{"ctrl-r", "Nothing"}) {
+		t.Errorf("%v", hints)
+	}
+
+	parseKeymap(keymap, names, specs, "ctrl-t:first,ctrl-t:+desc(Go to the top),ctrl-l:reload(ls)+desc:Reload list, again")
+	check(tui.CtrlT.AsEvent(), "", actFirst, actDescription)
+	check(tui.CtrlL.AsEvent(), "ls", actReload, actDescription)
+	if desc := actionDescription(keymap[tui.CtrlL.AsEvent()]); desc != "Reload list, again" {
+		t.Errorf("%s", desc)
+	}
+	if specs[tui.CtrlO.AsEvent()] != "execute(open {})+#Open in default app (a+b)" || specs[tui.CtrlT.AsEvent()] != "first+desc(Go to the top)" {
+		t.Errorf("%v", specs)
+	}
+}
+
+func TestParseSingleActionList(t *testing.T) {
+	errorMessage := ""
+	parse := func(str string) []action {
+		errorMessage = ""
+		return parseSingleActionList(str, func(message string) {
+			errorMessage = message
+		})
+	}
+	if actions := parse("up+change-prompt(a+b> )+repeat:3,down"); len(errorMessage) > 0 || len(actions) != 3 ||
+		actions[1].a != "a+b> " || actions[2].n != 3 || actions[2].c[0].t != actDown {
+		t.Errorf("%v (%s)", actions, errorMessage)
+	}
+	if actions := parse("repeat(2,toggle+up)+first"); len(errorMessage) > 0 || len(actions) != 2 || len(actions[0].c) != 2 {
+		t.Errorf("%v (%s)", actions, errorMessage)
+	}
+	if actions := parse("down(3)+toggle-down(2)"); len(errorMessage) > 0 || len(actions) != 2 ||
+		actions[0].t != actRepeat || actions[0].n != 3 || actions[0].c[0].t != actDown || actions[1].n != 2 || len(actions[1].c) != 2 {
+		t.Errorf("%v (%s)", actions, errorMessage)
+	}
+	if actions := parse("repeat(2,change-prompt[a) ]+down(2)+repeat[3,up+change-prompt(b)])+first"); len(errorMessage) > 0 || len(actions) != 2 ||
+		actions[0].n != 2 || len(actions[0].c) != 3 || actions[0].c[0].a != "a) " || actions[0].c[1].n != 2 ||
+		actions[0].c[2].n != 3 || actions[0].c[2].c[1].a != "b" || actions[1].t != actFirst {
+		t.Errorf("%v (%s)", actions, errorMessage)
+	}
+	if actions := parse("repeat(2,repeat(3,up+repeat(4,down)))"); len(errorMessage) > 0 || len(actions) != 1 || repetitions(actions) != 24 {
+		t.Errorf("%v (%s)", actions, errorMessage)
+	}
+	for _, str := range []string{"foo", "up+bar", "repeat(0,up)", "repeat(x,up)", "repeat(3)", "repeat(3,foo)", "repeat(3,)", "down(0)", "foo(3)",
+		"repeat(10001,up)", "down(10001)", "repeat(100,repeat(101,up))", "repeat(2,up"} {
+		if actions := parse(str); len(errorMessage) == 0 || actions != nil {
+			t.Errorf("%s: %v", str, actions)
+		}
+	}
 }
 
 func TestColorSpec(t *testing.T) {
@@ -319,6 +520,47 @@ func TestColorSpec(t *testing.T) {
 	if customized.Fg != tui.Dark256.Fg || customized.Bg == tui.Dark256.Bg {
 		t.Errorf("color not customized")
 	}
+
+	customized = parseTheme(theme, "fg+:reverse:bold:strikethrough,hl+:italic:noreverse:nobold")
+	current := tui.NewColorPair(1, 2, customized.Current.Attr)
+	if current.Attr() != tui.Reverse|tui.Bold|tui.StrikeThrough {
+		t.Errorf("attributes not set: %v", current.Attr())
+	}
+	merged := current.WithAttr(customized.CurrentMatch.Attr)
+	if merged.Attr()&(tui.Reverse|tui.Bold) != 0 ||
+		merged.Attr()&(tui.Italic|tui.StrikeThrough) != tui.Italic|tui.StrikeThrough {
+		t.Errorf("negated attributes not cleared: %v", merged.Attr())
+	}
+	if merged.WithAttr(tui.Bold).Attr()&tui.Bold == 0 {
+		t.Errorf("attribute not restored")
+	}
+
+	customized = parseTheme(theme, "bg:bright-black,preview-bg:blend(bg, black, 0.2),fg:red")
+	if customized.Bg.Color != 8 || customized.Fg.Color != 1 || customized.Blends == nil {
+		t.Errorf("color not customized")
+	}
+	blends := *customized.Blends
+	if len(blends) != 1 || blends[0].Element != "preview-bg" || blends[0].Base.Element != "bg" ||
+		blends[0].Other.Element != "" || blends[0].Other.Color != 0 || blends[0].Ratio != 0.2 {
+		t.Errorf("unexpected blends: %v", blends)
+	}
+
+	customized = parseTheme(theme, "match-1:red,match-3:blue:bold")
+	if customized.TermMatch[0].Color != 1 || customized.TermMatch[2].Color != 4 || customized.TermMatch[2].Attr != tui.Bold ||
+		customized.TermMatch[1] != theme.TermMatch[1] {
+		t.Errorf("match colors not customized: %v", customized.TermMatch)
+	}
+	if customized := parseTheme(theme, "match-4:green"); customized.TermMatch[0] != theme.TermMatch[0] {
+		t.Errorf("match colors of the original theme changed: %v", theme.TermMatch)
+	}
+
+	aliases := make(map[string]tui.Color)
+	customized = parseThemeWithAliases(theme, "prompt:accent,define:accent:#87D7FF,define:dark-accent:accent", aliases)
+	customized = parseThemeWithAliases(customized, "border:dark-accent:bold,bg+:blend(accent,black,0.5)", aliases)
+	if customized.Prompt.Color != tui.HexToColor("#87d7ff") || customized.Border.Color != customized.Prompt.Color ||
+		customized.Border.Attr != tui.Bold || (*customized.Blends)[0].Base.Color != customized.Prompt.Color {
+		t.Errorf("color aliases not resolved")
+	}
 }
 
 func TestDefaultCtrlNP(t *testing.T) {
@@ -421,6 +663,24 @@ func TestPreviewOpts(t *testing.T) {
 		opts.Preview.size.size == 70) {
 		t.Error(opts.Preview)
 	}
+	opts = optsFor("--preview-window=<80(hidden)|<120(down,40%)|right,60%")
+	if !(opts.Preview.position == posRight &&
+		opts.Preview.size.size == 60 &&
+		len(opts.Preview.rules) == 2 &&
+		opts.Preview.rules[0] == previewRule{80, "hidden"} &&
+		opts.Preview.rules[1] == previewRule{120, "down,40%"}) {
+		t.Error(opts.Preview)
+	}
+	opts = optsFor("--preview-window=up|<80(hidden)", "--preview-window=40%")
+	if !(opts.Preview.position == posUp &&
+		opts.Preview.size.size == 40 &&
+		len(opts.Preview.rules) == 1) {
+		t.Error(opts.Preview)
+	}
+	opts = optsFor("--preview-window=<80(hidden)", "--preview-window=default")
+	if len(opts.Preview.rules) != 0 {
+		t.Error(opts.Preview)
+	}
 }
 
 func TestAdditiveExpect(t *testing.T) {
@@ -455,3 +715,690 @@ func TestValidateSign(t *testing.T) {
 		}
 	}
 }
+
+func TestParseHeight(t *testing.T) {
+	check := func(str string, expected heightSpec) {
+		if spec := parseHeight(str); spec != expected {
+			t.Errorf("%s: %v (expected: %v)", str, spec, expected)
+		}
+	}
+	check("10", heightSpec{size: 10})
+	check("40%", heightSpec{size: 40, percent: true})
+	check("auto", heightSpec{size: 100, percent: true, auto: true})
+	check("auto:5", heightSpec{size: 100, percent: true, auto: true, min: 5})
+	check("auto:5,20", heightSpec{size: 20, auto: true, min: 5})
+	check("auto:0,50%", heightSpec{size: 50, percent: true, auto: true})
+}
+
+func TestParseBorder(t *testing.T) {
+	if shape, sides := parseBorder("", true); shape != tui.BorderRounded || sides != tui.BorderRounded.Sides() {
+		t.Errorf("%v %v", shape, sides)
+	}
+	if shape, sides := parseBorder("horizontal", false); shape != tui.BorderHorizontal || sides != tui.BorderHorizontal.Sides() {
+		t.Errorf("%v %v", shape, sides)
+	}
+	shape, sides := parseBorder("top:double,bottom:none,left:bold", false)
+	if shape != tui.BorderCustom || sides != (tui.BorderSides{tui.LineDouble, tui.LineRounded, tui.LineNone, tui.LineBold}) {
+		t.Errorf("%v %v", shape, sides)
+	}
+	if shape, sides := parseBorder("heavy-dashed", false); shape != tui.BorderHeavyDashed || sides[0] != tui.LineHeavyDashed {
+		t.Errorf("%v %v", shape, sides)
+	}
+	shape, sides = parseBorder("vertical,top:sharp", false)
+	if shape != tui.BorderCustom || sides != (tui.BorderSides{tui.LineSharp, tui.LineSharp, tui.LineNone, tui.LineSharp}) {
+		t.Errorf("%v %v", shape, sides)
+	}
+	if shape, _ := parseBorder("top:none,right:none,bottom:none,left:none", false); shape != tui.BorderNone {
+		t.Errorf("%v", shape)
+	}
+}
+
+func TestParseLabelPos(t *testing.T) {
+	opts := labelOpts{label: "foo"}
+	parseLabelPos(&opts, "-3:bottom")
+	if opts != (labelOpts{"foo", -3, false, true}) {
+		t.Errorf("%v", opts)
+	}
+	parseLabelPos(&opts, "25%")
+	if opts != (labelOpts{"foo", 25, true, false}) {
+		t.Errorf("%v", opts)
+	}
+	parseLabelPos(&opts, "bottom")
+	if opts != (labelOpts{"foo", 0, false, true}) {
+		t.Errorf("%v", opts)
+	}
+}
+
+func TestBorderLabels(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--border-label-pos=1", "--border-label=foo",
+		"--border-label=bar", "--border-label-pos=-1:bottom"})
+	if len(opts.BorderLabel) != 2 ||
+		opts.BorderLabel[0] != (labelOpts{"foo", 1, false, false}) ||
+		opts.BorderLabel[1] != (labelOpts{"bar", -1, false, true}) {
+		t.Errorf("%v", opts.BorderLabel)
+	}
+	parseOptions(opts, []string{"--no-border-label"})
+	if len(opts.BorderLabel) != 0 {
+		t.Errorf("%v", opts.BorderLabel)
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	fields := parseForm("branch=main,author=,since=2 weeks ago")
+	if len(fields) != 3 || fields[0].name != "branch" || string(fields[0].value) != "main" || fields[0].cx != 4 ||
+		fields[1].name != "author" || len(fields[1].value) != 0 ||
+		string(fields[2].value) != "2 weeks ago" {
+		t.Errorf("%v", fields)
+	}
+	if fields := parseForm(""); len(fields) != 0 {
+		t.Errorf("%v", fields)
+	}
+	if !referencesField("git log --author={form:author}", "author") ||
+		referencesField("git log --author={form:author}", "auth") {
+		t.Error("referencesField")
+	}
+}
+
+func TestParseMatchStyle(t *testing.T) {
+	if style := defaultOptions().MatchStyle; style != (matchStyle{true, tui.AttrUndefined}) {
+		t.Errorf("%v", style)
+	}
+	style := parseMatchStyle("underline,bold")
+	if style != (matchStyle{false, tui.Underline | tui.Bold}) {
+		t.Errorf("%v", style)
+	}
+	base := tui.NewColorPair(1, 2, tui.AttrUndefined)
+	match := tui.NewColorPair(3, 2, tui.AttrUndefined)
+	if pair := style.apply(base, match); pair != tui.NewColorPair(1, 2, tui.Underline|tui.Bold) {
+		t.Errorf("%v", pair)
+	}
+	style = parseMatchStyle("color,block")
+	if pair := style.apply(base, match); pair != tui.NewColorPair(3, 2, tui.Reverse) {
+		t.Errorf("%v", pair)
+	}
+}
+
+func TestParseTitle(t *testing.T) {
+	segments := parseTitle("{q} [reload](ctrl-r) | [Preview](f2)")
+	if len(segments) != 4 || segments[0].text != "{q} " || segments[0].key != nil ||
+		segments[1].text != "reload" || *segments[1].key != tui.CtrlR.AsEvent() ||
+		segments[2].text != " | " || segments[3].text != "Preview" || *segments[3].key != tui.F2.AsEvent() {
+		t.Errorf("%v", segments)
+	}
+	if segments := parseTitle("[x] (y)"); len(segments) != 1 || segments[0].key != nil {
+		t.Errorf("%v", segments)
+	}
+}
+
+func TestParseMarginBounds(t *testing.T) {
+	margin := parseMargin("margin", "10%,min=2,max=8")
+	expected := sizeSpec{size: 10, percent: true, min: 2, max: 8}
+	if margin != [4]sizeSpec{expected, expected, expected, expected} {
+		t.Errorf("%v", margin)
+	}
+	if margin[0].bound(1) != 2 || margin[0].bound(5) != 5 || margin[0].bound(20) != 8 {
+		t.Errorf("%v", margin[0])
+	}
+
+	margin = parseMargin("padding", "1,20%,max=4")
+	if margin[0] != (sizeSpec{size: 1}) || margin[1] != (sizeSpec{size: 20, percent: true, max: 4}) ||
+		margin[2] != margin[0] || margin[3] != margin[1] {
+		t.Errorf("%v", margin)
+	}
+	if margin[1].bound(0) != 0 || margin[1].bound(10) != 4 {
+		t.Errorf("%v", margin[1])
+	}
+}
+
+func TestParseTui(t *testing.T) {
+	if opts := defaultOptions(); opts.Tui != tuiAuto {
+		t.Errorf("%v", opts.Tui)
+	}
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--tui", "tcell"})
+	if opts.Tui != tuiTcell {
+		t.Errorf("%v", opts.Tui)
+	}
+	parseOptions(opts, []string{"--tui=light"})
+	if opts.Tui != tuiLight {
+		t.Errorf("%v", opts.Tui)
+	}
+}
+
+func TestParseTmuxPane(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--tmux-pane", "%1"})
+	if opts.TmuxPane != "%1" {
+		t.Errorf("%s", opts.TmuxPane)
+	}
+	parseOptions(opts, []string{"--tmux-pane=main:0.1", "--no-tmux-pane"})
+	if len(opts.TmuxPane) > 0 {
+		t.Errorf("%s", opts.TmuxPane)
+	}
+}
+
+func TestParseAcceptAllConfirm(t *testing.T) {
+	opts := defaultOptions()
+	if opts.ConfirmAll != defaultConfirmAll {
+		t.Errorf("%d", opts.ConfirmAll)
+	}
+	parseOptions(opts, []string{"--accept-all-confirm", "10"})
+	if opts.ConfirmAll != 10 {
+		t.Errorf("%d", opts.ConfirmAll)
+	}
+	parseOptions(opts, []string{"--accept-all-confirm=0"})
+	if opts.ConfirmAll != 0 {
+		t.Errorf("%d", opts.ConfirmAll)
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	if region, err := parseRegion("1, 2,30,10"); err != nil || *region != (tui.Region{Top: 1, Left: 2, Width: 30, Height: 10}) {
+		t.Errorf("%v, %v", region, err)
+	}
+	for _, str := range []string{"", "1,2,3", "1,2,0,4", "-1,2,3,4", "a,b,c,d"} {
+		if _, err := parseRegion(str); err == nil {
+			t.Errorf("%s: error expected", str)
+		}
+	}
+}
+
+func TestParsePasteMode(t *testing.T) {
+	opts := defaultOptions()
+	if opts.Paste != pasteSpace {
+		t.Errorf("%d", opts.Paste)
+	}
+	parseOptions(opts, []string{"--paste", "strip"})
+	if opts.Paste != pasteStrip {
+		t.Errorf("%d", opts.Paste)
+	}
+	parseOptions(opts, []string{"--paste=first-line"})
+	if opts.Paste != pasteFirstLine {
+		t.Errorf("%d", opts.Paste)
+	}
+}
+
+func TestSplitKeySequence(t *testing.T) {
+	for str, expected := range map[string][]string{
+		"ctrl-x":         {"ctrl-x"},
+		">":              {">"},
+		"ctrl-x>ctrl-e":  {"ctrl-x", "ctrl-e"},
+		"a>b>c":          {"a", "b", "c"},
+		"ctrl-x>>":       {"ctrl-x", ">"},
+		">>a":            {">", "a"},
+		"alt->":          {"alt->"},
+		"ctrl-x>alt->>a": {"ctrl-x", "alt->", "a"},
+		"ctrl-x>":        {"ctrl-x", ""},
+	} {
+		if keys := splitKeySequence(str); fmt.Sprintf("%q", keys) != fmt.Sprintf("%q", expected) {
+			t.Errorf("%s: %q != %q", str, keys, expected)
+		}
+	}
+}
+
+func TestParseClickInterval(t *testing.T) {
+	opts := defaultOptions()
+	if opts.ClickIntvl != tui.DefaultClickInterval {
+		t.Errorf("%v", opts.ClickIntvl)
+	}
+	parseOptions(opts, []string{"--click-interval", "300"})
+	if opts.ClickIntvl != 300*time.Millisecond {
+		t.Errorf("%v", opts.ClickIntvl)
+	}
+	parseOptions(opts, []string{"--click-interval=1000"})
+	if opts.ClickIntvl != time.Second {
+		t.Errorf("%v", opts.ClickIntvl)
+	}
+}
+
+func TestParseItemTTL(t *testing.T) {
+	opts := defaultOptions()
+	if opts.ItemTTL != 0 {
+		t.Errorf("%v", opts.ItemTTL)
+	}
+	parseOptions(opts, []string{"--item-ttl", "30s"})
+	if opts.ItemTTL != 30*time.Second {
+		t.Errorf("%v", opts.ItemTTL)
+	}
+	parseOptions(opts, []string{"--item-ttl=1.5"})
+	if opts.ItemTTL != 1500*time.Millisecond {
+		t.Errorf("%v", opts.ItemTTL)
+	}
+	parseOptions(opts, []string{"--no-item-ttl"})
+	if opts.ItemTTL != 0 {
+		t.Errorf("%v", opts.ItemTTL)
+	}
+}
+
+func TestLeader(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-space>h:up,ctrl-space>g:down,LEADER+g:first,leader+x>y:last+#Go to the end",
+		"--leader", "ctrl-space"})
+	postProcessOptions(opts)
+	if _, prs := opts.Keymap[tui.Leader.AsEvent()]; prs {
+		t.Errorf("leader bindings not resolved")
+	}
+	chord := opts.Keymap[tui.CtrlSpace.AsEvent()][0].k
+	if chord.name != "ctrl-space" || chord.keymap[tui.Key('x')][0].k.name != "ctrl-space>x" {
+		t.Errorf("%v", chord)
+	}
+	hints := chord.hints()
+	expected := []bindingHint{{"g", "first"}, {"h", "up"}, {"x", "+y"}}
+	if fmt.Sprint(hints) != fmt.Sprint(expected) {
+		t.Errorf("%v", hints)
+	}
+	if hints := chord.keymap[tui.Key('x')][0].k.hints(); len(hints) != 1 || hints[0].desc != "Go to the end" {
+		t.Errorf("%v", hints)
+	}
+}
+
+func TestShowBindings(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-r:reload(ls)+desc:Reload list", "--bind",
+		"ctrl-a:first,ctrl-x>ctrl-e:execute(vim {}),ctrl-x>g>g:top+#Go to the top,f1:show-bindings"})
+	postProcessOptions(opts)
+	bindings := allBindings(opts.Keymap, opts.KeyNames, opts.KeySpecs)
+	found := map[string]string{}
+	for _, binding := range bindings {
+		found[binding.key] = binding.desc
+	}
+	for key, desc := range map[string]string{
+		"ctrl-r":        "Reload list",
+		"ctrl-a":        "first",
+		"ctrl-x>ctrl-e": "execute(vim {})",
+		"ctrl-x>g>g":    "Go to the top",
+		"f1":            "show-bindings",
+		"enter":         "accept",
+		"ctrl-h":        "backward-delete-char",
+		"double-click":  "accept",
+		"shift-right":   "forward-word",
+	} {
+		if found[key] != desc {
+			t.Errorf("%s: %q != %q", key, found[key], desc)
+		}
+	}
+	if !sort.SliceIsSorted(bindings, func(i, j int) bool { return bindings[i].key < bindings[j].key }) {
+		t.Errorf("%v", bindings)
+	}
+
+	// The names of the default actions are the ones to bind them
+	for actionType, name := range defaultActionNames {
+		actions := parseSingleActionList(name, func(message string) { t.Error(message) })
+		if len(actions) == 1 && actions[0].t != actionType {
+			t.Errorf("%s: %d != %d", name, actions[0].t, actionType)
+		}
+	}
+
+	// Every default binding of a key is named except the internal actions
+	keys := parseKeyChords(namedKeys(), "")
+	for key, actions := range defaultKeymap() {
+		if _, found := keys[key]; !found {
+			continue
+		}
+		switch actions[0].t {
+		case actSigStop, actIgnore:
+		default:
+			if len(defaultActionNames[actions[0].t]) == 0 {
+				t.Errorf("%s: no name for %d", keys[key], actions[0].t)
+			}
+		}
+	}
+}
+
+func TestParseDiskSort(t *testing.T) {
+	opts := defaultOptions()
+	if opts.DiskSort != defaultDiskSort || opts.DiskSortDir != "" {
+		t.Errorf("%d, %s", opts.DiskSort, opts.DiskSortDir)
+	}
+	parseOptions(opts, []string{"--disk-sort", "1000", "--disk-sort-dir", "/tmp"})
+	if opts.DiskSort != 1000 || opts.DiskSortDir != "/tmp" {
+		t.Errorf("%d, %s", opts.DiskSort, opts.DiskSortDir)
+	}
+	parseOptions(opts, []string{"--disk-sort=0", "--disk-sort-dir=/var/tmp"})
+	if opts.DiskSort != 0 || opts.DiskSortDir != "/var/tmp" {
+		t.Errorf("%d, %s", opts.DiskSort, opts.DiskSortDir)
+	}
+}
+
+func TestParseBell(t *testing.T) {
+	opts := defaultOptions()
+	if opts.Bell != tui.BellNone {
+		t.Errorf("%v", opts.Bell)
+	}
+	parseOptions(opts, []string{"--bell", "visual"})
+	if opts.Bell != tui.BellVisual {
+		t.Errorf("%v", opts.Bell)
+	}
+	parseOptions(opts, []string{"--bell=flash-border"})
+	if opts.Bell != tui.BellFlashBorder {
+		t.Errorf("%v", opts.Bell)
+	}
+	parseOptions(opts, []string{"--no-bell"})
+	if opts.Bell != tui.BellNone {
+		t.Errorf("%v", opts.Bell)
+	}
+}
+
+func TestParseListen(t *testing.T) {
+	for input, expected := range map[string]string{
+		"6266":           "localhost:6266",
+		"0.0.0.0:6266":   "0.0.0.0:6266",
+		"[::1]:6266":     "[::1]:6266",
+		"localhost:0000": "localhost:0000"} {
+		if actual := parseListen(input); actual != expected {
+			t.Errorf("%s: %s != %s", input, actual, expected)
+		}
+	}
+}
+
+func TestParsePreviewFallback(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--preview-fallback", "foo", "--preview-fallback=bar"})
+	if len(opts.Fallbacks) != 2 || opts.Fallbacks[0] != "foo" || opts.Fallbacks[1] != "bar" {
+		t.Errorf("%v", opts.Fallbacks)
+	}
+	parseOptions(opts, []string{"--no-preview-fallback"})
+	if len(opts.Fallbacks) != 0 {
+		t.Errorf("%v", opts.Fallbacks)
+	}
+}
+
+func TestRenderOnce(t *testing.T) {
+	opts := defaultOptions()
+	if opts.RenderOnce != renderNone {
+		t.Errorf("%v", opts.RenderOnce)
+	}
+	parseOptions(opts, []string{"--render-once"})
+	if opts.RenderOnce != renderPlain {
+		t.Errorf("%v", opts.RenderOnce)
+	}
+	parseOptions(opts, []string{"--render-once=ansi"})
+	if opts.RenderOnce != renderANSI {
+		t.Errorf("%v", opts.RenderOnce)
+	}
+	parseOptions(opts, []string{"--no-render-once"})
+	if opts.RenderOnce != renderNone {
+		t.Errorf("%v", opts.RenderOnce)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--wrap", "--wrap-sign", "+ ", "--bind", "ctrl-w:toggle-wrap"})
+	if !opts.Wrap || opts.WrapSign == nil || *opts.WrapSign != "+ " {
+		t.Errorf("%v, %v", opts.Wrap, opts.WrapSign)
+	}
+	if actions := opts.Keymap[tui.CtrlW.AsEvent()]; len(actions) != 1 || actions[0].t != actToggleWrap {
+		t.Errorf("%v", actions)
+	}
+	parseOptions(opts, []string{"--no-wrap"})
+	if opts.Wrap {
+		t.Errorf("wrap not disabled")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--dry-run", "--bind", "ctrl-d:toggle-dry-run"})
+	if !opts.DryRun {
+		t.Errorf("dry-run not enabled")
+	}
+	if actions := opts.Keymap[tui.CtrlD.AsEvent()]; len(actions) != 1 || actions[0].t != actToggleDryRun {
+		t.Errorf("%v", actions)
+	}
+	parseOptions(opts, []string{"--no-dry-run"})
+	if opts.DryRun {
+		t.Errorf("dry-run not disabled")
+	}
+}
+
+func TestRegexOption(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--regex", "--bind", "alt-r:toggle-regex"})
+	if !opts.Regex {
+		t.Errorf("regex mode not enabled")
+	}
+	if actions := opts.Keymap[tui.AltKey('r')]; len(actions) != 1 || actions[0].t != actToggleRegex {
+		t.Errorf("%v", actions)
+	}
+	parseOptions(opts, []string{"--no-regex"})
+	if opts.Regex {
+		t.Errorf("regex mode not disabled")
+	}
+}
+
+func TestUnicodeNormalizeOption(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--unicode-normalize"})
+	if !opts.UnicodeNorm || !opts.Normalize {
+		t.Errorf("unicode normalization not enabled")
+	}
+	parseOptions(opts, []string{"--no-unicode-normalize"})
+	if opts.UnicodeNorm {
+		t.Errorf("unicode normalization not disabled")
+	}
+}
+
+func TestTabOption(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--tab", "files:fd --type f", "--tab=dirs:fd --type d",
+		"--bind", "tab:next-tab,btab:prev-tab"})
+	if len(opts.Tabs) != 2 || opts.Tabs[0].name != "files" || opts.Tabs[0].command != "fd --type f" ||
+		opts.Tabs[1].name != "dirs" {
+		t.Errorf("%v", opts.Tabs)
+	}
+	if actions := opts.Keymap[tui.Tab.AsEvent()]; len(actions) != 1 || actions[0].t != actNextTab {
+		t.Errorf("%v", actions)
+	}
+	if actions := opts.Keymap[tui.BTab.AsEvent()]; len(actions) != 1 || actions[0].t != actPrevTab {
+		t.Errorf("%v", actions)
+	}
+	postProcessOptions(opts)
+	if len(opts.Sources) != 1 || opts.Sources[0] != "fd --type f" {
+		t.Errorf("%v", opts.Sources)
+	}
+	parseOptions(opts, []string{"--no-tab"})
+	if len(opts.Tabs) != 0 {
+		t.Errorf("%v", opts.Tabs)
+	}
+}
+
+func TestMenuOption(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--menu", "Open:execute(vim {})", "--menu=Delete:execute(rm -i {}):+reload(ls)",
+		"--menu", "Diff:become(git diff -- {+}, --stat)", "--menu", "7z:execute-silent(7z a x.7z {})+toggle-sort",
+		"--bind", "ctrl-o:context-menu"})
+	if len(opts.Menu) != 4 {
+		t.Fatalf("%v", opts.Menu)
+	}
+	for idx, expected := range []struct {
+		label string
+		key   rune
+		types []actionType
+	}{
+		{"Open", 'o', []actionType{actExecute}},
+		{"Delete", 'd', []actionType{actExecute, actReload}},
+		{"Diff", 'i', []actionType{actBecome}},
+		{"7z", '7', []actionType{actExecuteSilent, actToggleSort}},
+	} {
+		entry := opts.Menu[idx]
+		if entry.label != expected.label || entry.key != expected.key || len(entry.actions) != len(expected.types) {
+			t.Errorf("%d: %v", idx, entry)
+			continue
+		}
+		for i, typ := range expected.types {
+			if entry.actions[i].t != typ {
+				t.Errorf("%d: %v", idx, entry.actions)
+			}
+		}
+	}
+	if opts.Menu[2].actions[0].a != "git diff -- {+}, --stat" {
+		t.Errorf("%q", opts.Menu[2].actions[0].a)
+	}
+	if actions := opts.Keymap[tui.CtrlO.AsEvent()]; len(actions) != 1 || actions[0].t != actContextMenu {
+		t.Errorf("%v", actions)
+	}
+	postProcessOptions(opts)
+	if !opts.ToggleSort {
+		t.Error("toggle-sort in the menu should enable --toggle-sort")
+	}
+	parseOptions(opts, []string{"--no-menu"})
+	if len(opts.Menu) != 0 {
+		t.Errorf("%v", opts.Menu)
+	}
+}
+
+func TestExecuteStatus(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-t:execute-status(make {}),ctrl-k:cancel-status,ctrl-u:execute-status:sleep 1"})
+	check := func(key tui.EventType, expected actionType, arg string) {
+		if actions := opts.Keymap[key.AsEvent()]; len(actions) != 1 || actions[0].t != expected || actions[0].a != arg {
+			t.Errorf("%v", actions)
+		}
+	}
+	check(tui.CtrlT, actExecuteStatus, "make {}")
+	check(tui.CtrlK, actCancelStatus, "")
+	check(tui.CtrlU, actExecuteStatus, "sleep 1")
+}
+
+func TestMacroActions(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-r:record-macro,ctrl-s:stop-macro,ctrl-e:replay-macro"})
+	for key, expected := range map[tui.EventType]actionType{
+		tui.CtrlR: actRecordMacro, tui.CtrlS: actStopMacro, tui.CtrlE: actReplayMacro} {
+		if actions := opts.Keymap[key.AsEvent()]; len(actions) != 1 || actions[0].t != expected {
+			t.Errorf("%v", actions)
+		}
+	}
+}
+
+func TestWordBoundaries(t *testing.T) {
+	opts := defaultOptions()
+	if opts.WordBounds != nil {
+		t.Errorf("Unexpected word boundaries: %v", opts.WordBounds)
+	}
+	parseOptions(opts, []string{"--word-boundaries=camel,digit"})
+	if *opts.WordBounds != (algo.Boundaries{Camel: true, Digit: true}) {
+		t.Errorf("Unexpected word boundaries: %v", *opts.WordBounds)
+	}
+	parseOptions(opts, []string{"--word-boundaries", "none"})
+	if *opts.WordBounds != (algo.Boundaries{}) {
+		t.Errorf("Unexpected word boundaries: %v", *opts.WordBounds)
+	}
+}
+
+func TestCharWidths(t *testing.T) {
+	opts := defaultOptions()
+	if opts.AmbigWidth != 0 || opts.EmojiWidth != 0 {
+		t.Errorf("Unexpected widths: %d, %d", opts.AmbigWidth, opts.EmojiWidth)
+	}
+	parseOptions(opts, []string{"--ambiguous-width=2", "--emoji-width", "1"})
+	if opts.AmbigWidth != 2 || opts.EmojiWidth != 1 {
+		t.Errorf("Unexpected widths: %d, %d", opts.AmbigWidth, opts.EmojiWidth)
+	}
+	parseOptions(opts, []string{"--ambiguous-width", "auto", "--emoji-width=auto"})
+	if opts.AmbigWidth != widthAuto || opts.EmojiWidth != widthAuto {
+		t.Errorf("Unexpected widths: %d, %d", opts.AmbigWidth, opts.EmojiWidth)
+	}
+}
+
+func TestRegisterActions(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-w:backward-kill-word+yank-to(a),alt-y:put-from,alt-p:put-from:1"})
+	if actions := opts.Keymap[tui.CtrlW.AsEvent()]; len(actions) != 2 || actions[1].t != actYankTo || actions[1].a != "a" {
+		t.Errorf("%v", actions)
+	}
+	if actions := opts.Keymap[tui.AltKey('y')]; len(actions) != 1 || actions[0].t != actPutFrom || actions[0].a != "" {
+		t.Errorf("%v", actions)
+	}
+	if actions := opts.Keymap[tui.AltKey('p')]; len(actions) != 1 || actions[0].t != actPutFrom || actions[0].a != "1" {
+		t.Errorf("%v", actions)
+	}
+
+	for _, spec := range []string{"yank-to(ab)", "put-from(-)"} {
+		errorMessage := ""
+		parseSingleActionList(spec, func(message string) { errorMessage = message })
+		if !strings.HasPrefix(errorMessage, "invalid register name: ") {
+			t.Errorf("%s: %s", spec, errorMessage)
+		}
+	}
+}
+
+func TestChangeNth(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-n:change-nth(2|1..),ctrl-r:change-nth(),ctrl-d:change-delimiter:\\t"})
+	check := func(key tui.EventType, expected actionType, arg string) {
+		if actions := opts.Keymap[key.AsEvent()]; len(actions) != 1 || actions[0].t != expected || actions[0].a != arg {
+			t.Errorf("%v", actions)
+		}
+	}
+	check(tui.CtrlN, actChangeNth, "2|1..")
+	check(tui.CtrlR, actChangeNth, "")
+	check(tui.CtrlD, actChangeDelimiter, "\\t")
+
+	errorMessage := ""
+	parseSingleActionList("change-nth(2|x)", func(message string) { errorMessage = message })
+	if errorMessage != "invalid nth expression: x" {
+		t.Error(errorMessage)
+	}
+}
+
+func TestChangeScheme(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--scheme=path", "--bind", "ctrl-s:change-scheme(history|),ctrl-c:toggle-smart-case"})
+	if opts.Scheme != "path" {
+		t.Errorf("Unexpected scheme: %s", opts.Scheme)
+	}
+	if actions := opts.Keymap[tui.CtrlS.AsEvent()]; len(actions) != 1 || actions[0].t != actChangeScheme || actions[0].a != "history|" {
+		t.Errorf("%v", actions)
+	}
+	if actions := opts.Keymap[tui.CtrlC.AsEvent()]; len(actions) != 1 || actions[0].t != actToggleSmartCase {
+		t.Errorf("%v", actions)
+	}
+
+	errorMessage := ""
+	parseSingleActionList("change-scheme(path|files)", func(message string) { errorMessage = message })
+	if errorMessage != "invalid scoring scheme: files" {
+		t.Error(errorMessage)
+	}
+}
+
+func TestCustomScheme(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--scheme=custom:boundary=16,gap-start=-5,first-char-multiplier=1"})
+	expected := algo.DefaultScoring
+	expected.Boundary, expected.GapStart, expected.FirstCharMultiplier = 16, -5, 1
+	expected.Camel = 16 + expected.GapExtension
+	expected.Consecutive = 5 - expected.GapExtension
+	if opts.Scheme != "custom" || opts.Scoring != expected {
+		t.Errorf("Unexpected scheme: %s, %v", opts.Scheme, opts.Scoring)
+	}
+
+	parseOptions(opts, []string{"--scheme=custom:camel=2,consecutive=0"})
+	if opts.Scoring.Camel != 2 || opts.Scoring.Consecutive != 0 || opts.Scoring.Boundary != algo.DefaultScoring.Boundary {
+		t.Errorf("Unexpected scoring: %v", opts.Scoring)
+	}
+
+	parseOptions(opts, []string{"--scheme=path"})
+	if opts.Scheme != "path" || opts.Scoring != algo.DefaultScoring {
+		t.Errorf("Unexpected scheme: %s, %v", opts.Scheme, opts.Scoring)
+	}
+
+	errorMessage := ""
+	parseSingleActionList("change-scheme(custom:match=8)", func(message string) { errorMessage = message })
+	if errorMessage != "invalid scoring scheme: custom:match=8" {
+		t.Error(errorMessage)
+	}
+}
+
+func TestTransformAction(t *testing.T) {
+	opts := defaultOptions()
+	parseOptions(opts, []string{"--bind", "ctrl-t:transform[echo 'change-prompt(> )+first'],ctrl-u:transform:echo up"})
+	if actions := opts.Keymap[tui.CtrlT.AsEvent()]; len(actions) != 1 || actions[0].t != actTransform || actions[0].a != "echo 'change-prompt(> )+first'" {
+		t.Errorf("%v", actions)
+	}
+	if actions := opts.Keymap[tui.CtrlU.AsEvent()]; len(actions) != 1 || actions[0].t != actTransform || actions[0].a != "echo up" {
+		t.Errorf("%v", actions)
+	}
+}
@@ -0,0 +1,67 @@
+package fzf
+
+import (
+	"sync"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// renderPacer paces the refreshes of the screen by the latency of writing to
+// the terminal. The requests arriving for a while after a refresh are
+// coalesced into the next one, so that a slow terminal, e.g. over SSH, is not
+// flooded with the frames that are overwritten right away, while the delay of
+// the refresh after a key is kept under the target.
+type renderPacer struct {
+	mutex   sync.Mutex
+	target  time.Duration
+	latency time.Duration // Moving average of the time taken by the refreshes
+	last    time.Time     // When the last refresh finished
+}
+
+func newRenderPacer(target time.Duration) *renderPacer {
+	return &renderPacer{target: target}
+}
+
+// measure updates the latency with the refresh between the times
+func (p *renderPacer) measure(start time.Time, end time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	took := end.Sub(start)
+	if p.last.IsZero() {
+		p.latency = took
+	} else {
+		p.latency += (took - p.latency) / renderLatencyWeight
+	}
+	p.last = end
+}
+
+// interval returns the pause after a refresh during which the requests are
+// coalesced. It is as long as the refresh itself up to the target, so that
+// the terminal is not kept busy more than half of the time. A slower refresh
+// never shortens the pause.
+func (p *renderPacer) interval() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return util.DurWithin(p.latency, 0, p.target)
+}
+
+// delay returns how long the coordinator waits after the given number of
+// updates of the list while the input is being read. It grows by
+// coordinatorDelayStep with every update up to coordinatorDelayMax, and the
+// interval only makes it longer, so that the list is not updated faster than
+// the screen is refreshed.
+func (p *renderPacer) delay(ticks int) time.Duration {
+	return util.DurWithin(time.Duration(ticks)*coordinatorDelayStep, p.interval(), coordinatorDelayMax)
+}
+
+// wait returns how long to wait at the time before the next refresh
+func (p *renderPacer) wait(now time.Time) time.Duration {
+	interval := p.interval()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.last.IsZero() {
+		return 0
+	}
+	return util.DurWithin(interval-now.Sub(p.last), 0, interval)
+}
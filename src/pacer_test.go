@@ -0,0 +1,76 @@
+package fzf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderPacer(t *testing.T) {
+	pacer := newRenderPacer(50 * time.Millisecond)
+	now := time.Now()
+	if wait := pacer.wait(now); wait != 0 {
+		t.Errorf("should not wait before the first refresh: %v", wait)
+	}
+
+	// Local terminal
+	pacer.measure(now, now.Add(time.Millisecond))
+	now = now.Add(time.Millisecond)
+	if interval := pacer.interval(); interval != time.Millisecond {
+		t.Errorf("unexpected interval: %v", interval)
+	}
+	if wait := pacer.wait(now.Add(2 * time.Millisecond)); wait != 0 {
+		t.Errorf("should not wait after the interval: %v", wait)
+	}
+
+	// The latency follows the slow refreshes gradually
+	for i := 0; i < 50; i++ {
+		start := now.Add(time.Second)
+		now = start.Add(20 * time.Millisecond)
+		pacer.measure(start, now)
+	}
+	if interval := pacer.interval(); interval < 19*time.Millisecond || interval > 20*time.Millisecond {
+		t.Errorf("unexpected interval: %v", interval)
+	}
+	if wait := pacer.wait(now.Add(5 * time.Millisecond)); wait < 14*time.Millisecond || wait > 15*time.Millisecond {
+		t.Errorf("unexpected wait: %v", wait)
+	}
+
+	// A slower refresh makes the pause longer
+	for i := 0; i < 50; i++ {
+		start := now.Add(time.Second)
+		now = start.Add(40 * time.Millisecond)
+		pacer.measure(start, now)
+	}
+	if interval := pacer.interval(); interval < 39*time.Millisecond || interval > 40*time.Millisecond {
+		t.Errorf("unexpected interval: %v", interval)
+	}
+
+	// The pause does not exceed the target
+	pacer.measure(now, now.Add(time.Second))
+	if interval := pacer.interval(); interval != 50*time.Millisecond {
+		t.Errorf("unexpected interval: %v", interval)
+	}
+}
+
+func TestRenderPacerDelay(t *testing.T) {
+	pacer := newRenderPacer(50 * time.Millisecond)
+	check := func(ticks int, expected time.Duration) {
+		t.Helper()
+		if delay := pacer.delay(ticks); delay != expected*time.Millisecond {
+			t.Errorf("%d: unexpected delay: %v", ticks, delay)
+		}
+	}
+	check(0, 0)
+	check(1, 10)
+	check(2, 20)
+	check(10, 100)
+	check(20, 100)
+
+	// The latency of the refresh only makes the delay longer
+	now := time.Now()
+	pacer.measure(now, now.Add(25*time.Millisecond))
+	check(0, 25)
+	check(1, 25)
+	check(3, 30)
+	check(10, 100)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/junegunn/fzf/src/algo"
 	"github.com/junegunn/fzf/src/util"
@@ -17,6 +18,9 @@ import (
 // !'inverse-fuzzy
 // !^inverse-prefix-exact
 // !inverse-suffix-exact$
+//
+// In regex mode, the terms other than !inverse and the OR operator are regular
+// expressions
 
 type termType int
 
@@ -26,6 +30,8 @@ const (
 	termPrefix
 	termSuffix
 	termEqual
+	termTypo
+	termRegex
 )
 
 type term struct {
@@ -34,6 +40,7 @@ type term struct {
 	text          []rune
 	caseSensitive bool
 	normalize     bool
+	proc          algo.Algo // Algorithm of the regular expression of termRegex
 }
 
 // String returns the string representation of a term.
@@ -47,10 +54,13 @@ type termSet []term
 type Pattern struct {
 	fuzzy         bool
 	fuzzyAlgo     algo.Algo
+	fuzzyTypos    int
 	extended      bool
+	regex         bool
 	caseSensitive bool
 	normalize     bool
 	forward       bool
+	criteria      []criterion
 	text          []rune
 	termSets      []termSet
 	sortable      bool
@@ -58,7 +68,14 @@ type Pattern struct {
 	cacheKey      string
 	delimiter     Delimiter
 	nth           []Range
+	weighted      bool // Whether the fields of nth are weighted
+	revision      int
 	procFun       map[termType]algo.Algo
+	regexProc     algo.Algo // Algorithm of the whole query in regex mode
+	mask          uint64
+	trigrams      []uint32 // Hashes of the trigrams for the trigram index
+	base          *Pattern // Pattern of the previous queries
+	setOp         setOperation
 }
 
 var (
@@ -83,9 +100,30 @@ func clearChunkCache() {
 	_cache = NewChunkCache()
 }
 
-// BuildPattern builds Pattern object from the given arguments
-func BuildPattern(fuzzy bool, fuzzyAlgo algo.Algo, extended bool, caseMode Case, normalize bool, forward bool,
-	cacheable bool, nth []Range, delimiter Delimiter, runes []rune) *Pattern {
+// PatternOptions holds the settings of the search shared by the patterns
+// built for the queries
+type PatternOptions struct {
+	Fuzzy      bool
+	FuzzyAlgo  algo.Algo
+	Extended   bool
+	Regex      bool
+	CaseMode   Case
+	Normalize  bool
+	Forward    bool
+	Criteria   []criterion
+	Cacheable  bool
+	Prefilter  bool
+	Typos      int // Number of the typos allowed in the typo-tolerant terms
+	FuzzyTypos int // Number of the typos allowed in the fuzzy terms
+	Nth        []Range
+	Delimiter  Delimiter
+	Revision   int
+}
+
+// BuildPattern builds Pattern object from the given options and query
+func BuildPattern(opts PatternOptions, runes []rune) *Pattern {
+	fuzzy, extended, regex, caseMode := opts.Fuzzy, opts.Extended, opts.Regex, opts.CaseMode
+	normalize, cacheable := opts.Normalize, opts.Cacheable
 
 	var asString string
 	if extended {
@@ -96,6 +134,11 @@ func BuildPattern(fuzzy bool, fuzzyAlgo algo.Algo, extended bool, caseMode Case,
 	} else {
 		asString = string(runes)
 	}
+	if normalize && !regex {
+		// The compatibility forms of the characters in the query, such as the
+		// full-width ones, are folded as well
+		asString = algo.NormalizeString(asString)
+	}
 
 	cached, found := _patternCache[asString]
 	if found {
@@ -106,8 +149,13 @@ func BuildPattern(fuzzy bool, fuzzyAlgo algo.Algo, extended bool, caseMode Case,
 	sortable := true
 	termSets := []termSet{}
 
+	var regexProc algo.Algo
 	if extended {
-		termSets = parseTerms(fuzzy, caseMode, normalize, asString)
+		if regex {
+			termSets = parseRegexTerms(caseMode, asString)
+		} else {
+			termSets = parseTerms(fuzzy, caseMode, normalize, asString)
+		}
 		// We should not sort the result if there are only inverse search terms
 		sortable = false
 	Loop:
@@ -127,6 +175,13 @@ func BuildPattern(fuzzy bool, fuzzyAlgo algo.Algo, extended bool, caseMode Case,
 				}
 			}
 		}
+	} else if regex {
+		// The matches of a regular expression are not a subset of the matches
+		// of its prefix
+		cacheable = false
+		normalize = false
+		caseSensitive = regexCaseSensitive(caseMode, asString)
+		regexProc = algo.RegexMatch(compileRegex(asString, caseSensitive))
 	} else {
 		lowerString := strings.ToLower(asString)
 		normalize = normalize &&
@@ -140,25 +195,36 @@ func BuildPattern(fuzzy bool, fuzzyAlgo algo.Algo, extended bool, caseMode Case,
 
 	ptr := &Pattern{
 		fuzzy:         fuzzy,
-		fuzzyAlgo:     fuzzyAlgo,
+		fuzzyAlgo:     algo.FuzzyTypoMatch(opts.FuzzyAlgo, opts.FuzzyTypos),
+		fuzzyTypos:    opts.FuzzyTypos,
 		extended:      extended,
+		regex:         regex,
 		caseSensitive: caseSensitive,
 		normalize:     normalize,
-		forward:       forward,
+		forward:       opts.Forward,
+		criteria:      opts.Criteria,
 		text:          []rune(asString),
 		termSets:      termSets,
 		sortable:      sortable,
 		cacheable:     cacheable,
-		nth:           nth,
-		delimiter:     delimiter,
-		procFun:       make(map[termType]algo.Algo)}
+		nth:           opts.Nth,
+		weighted:      weightedNth(opts.Nth),
+		delimiter:     opts.Delimiter,
+		revision:      opts.Revision,
+		procFun:       make(map[termType]algo.Algo),
+		regexProc:     regexProc}
 
 	ptr.cacheKey = ptr.buildCacheKey()
-	ptr.procFun[termFuzzy] = fuzzyAlgo
+	if opts.Prefilter {
+		ptr.mask = ptr.patternMask()
+		ptr.trigrams = ptr.patternTrigrams()
+	}
+	ptr.procFun[termFuzzy] = ptr.fuzzyAlgo
 	ptr.procFun[termEqual] = algo.EqualMatch
 	ptr.procFun[termExact] = algo.ExactMatchNaive
 	ptr.procFun[termPrefix] = algo.PrefixMatch
 	ptr.procFun[termSuffix] = algo.SuffixMatch
+	ptr.procFun[termTypo] = algo.TypoMatch(opts.Typos)
 
 	_patternCache[asString] = ptr
 	return ptr
@@ -198,12 +264,18 @@ func parseTerms(fuzzy bool, caseMode Case, normalize bool, str string) []termSet
 			text = text[1:]
 		}
 
-		if text != "$" && strings.HasSuffix(text, "$") {
+		if len(text) > 1 && strings.HasPrefix(text, "~") {
+			// Typo-tolerant match, which cannot be anchored
+			typ = termTypo
+			text = text[1:]
+		} else if text != "$" && strings.HasSuffix(text, "$") {
 			typ = termSuffix
 			text = text[:len(text)-1]
 		}
 
-		if strings.HasPrefix(text, "'") {
+		if typ == termTypo {
+			// Taken literally
+		} else if strings.HasPrefix(text, "'") {
 			// Flip exactness
 			if fuzzy && !inv {
 				typ = termExact
@@ -245,8 +317,87 @@ func parseTerms(fuzzy bool, caseMode Case, normalize bool, str string) []termSet
 	return sets
 }
 
+// parseRegexTerms parses the query of regex mode. The terms are separated by
+// spaces as in extended-search mode, and may be negated with ! or combined
+// with |, but the other prefixes and suffixes are part of the expressions.
+func parseRegexTerms(caseMode Case, str string) []termSet {
+	str = strings.Replace(str, "\\ ", "\t", -1)
+	tokens := _splitRegex.Split(str, -1)
+	sets := []termSet{}
+	set := termSet{}
+	switchSet := false
+	afterBar := false
+	for _, token := range tokens {
+		inv, text := false, strings.Replace(token, "\t", " ", -1)
+		if len(set) > 0 && !afterBar && text == "|" {
+			switchSet = false
+			afterBar = true
+			continue
+		}
+		afterBar = false
+
+		if strings.HasPrefix(text, "!") {
+			inv = true
+			text = text[1:]
+		}
+		if len(text) > 0 {
+			if switchSet {
+				sets = append(sets, set)
+				set = termSet{}
+			}
+			caseSensitive := regexCaseSensitive(caseMode, text)
+			set = append(set, term{
+				typ:           termRegex,
+				inv:           inv,
+				text:          []rune(text),
+				caseSensitive: caseSensitive,
+				proc:          algo.RegexMatch(compileRegex(text, caseSensitive))})
+			switchSet = true
+		}
+	}
+	if len(set) > 0 {
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// regexCaseSensitive tells if the regular expression should be matched
+// case-sensitively. Smart-case only takes the uppercase letters that are not
+// escaped into account, so that \S or \W does not make it case-sensitive.
+func regexCaseSensitive(caseMode Case, str string) bool {
+	if caseMode != CaseSmart {
+		return caseMode == CaseRespect
+	}
+	escaped := false
+	for _, r := range str {
+		if !escaped && unicode.IsUpper(r) {
+			return true
+		}
+		escaped = !escaped && r == '\\'
+	}
+	return false
+}
+
+// compileRegex compiles the regular expression. The text is taken literally
+// if it is not a valid expression, which is often the case while typing.
+func compileRegex(str string, caseSensitive bool) *regexp.Regexp {
+	prefix := ""
+	if !caseSensitive {
+		prefix = "(?i)"
+	}
+	if rx, err := regexp.Compile(prefix + str); err == nil {
+		return rx
+	}
+	return regexp.MustCompile(prefix + regexp.QuoteMeta(str))
+}
+
 // IsEmpty returns true if the pattern is effectively empty
 func (p *Pattern) IsEmpty() bool {
+	return p.base == nil && p.queryEmpty()
+}
+
+// queryEmpty returns true if the query of the pattern is effectively empty
+func (p *Pattern) queryEmpty() bool {
 	if !p.extended {
 		return len(p.text) == 0
 	}
@@ -260,17 +411,41 @@ func (p *Pattern) AsString() string {
 
 func (p *Pattern) buildCacheKey() string {
 	if !p.extended {
+		if p.regex {
+			return ""
+		}
+		if p.fuzzy {
+			return p.fuzzyCacheKey(p.text)
+		}
 		return p.AsString()
 	}
 	cacheableTerms := []string{}
 	for _, termSet := range p.termSets {
-		if len(termSet) == 1 && !termSet[0].inv && (p.fuzzy || termSet[0].typ == termExact) {
-			cacheableTerms = append(cacheableTerms, string(termSet[0].text))
+		// The matches of a typo-tolerant term are not a subset of the matches
+		// of the term without typos
+		if len(termSet) == 1 && !termSet[0].inv && termSet[0].typ != termTypo && termSet[0].typ != termRegex && (p.fuzzy || termSet[0].typ == termExact) {
+			if termSet[0].typ == termFuzzy {
+				cacheableTerms = append(cacheableTerms, p.fuzzyCacheKey(termSet[0].text))
+			} else {
+				cacheableTerms = append(cacheableTerms, string(termSet[0].text))
+			}
 		}
 	}
 	return strings.Join(cacheableTerms, "\t")
 }
 
+// fuzzyCacheKey returns the key of the fuzzy term for the result cache. The
+// matches of a fuzzy term with typos are a subset of the matches of its prefix
+// only when the same number of typos is allowed in both of them, so the key
+// of the term starts with the number. The suffixes of the key lacking the
+// number are never found in the cache.
+func (p *Pattern) fuzzyCacheKey(text []rune) string {
+	if p.fuzzyTypos == 0 {
+		return string(text)
+	}
+	return string(rune(1+algo.AllowedTypos(p.fuzzyTypos, len(text)))) + string(text)
+}
+
 // CacheKey is used to build string to be used as the key of result cache
 func (p *Pattern) CacheKey() string {
 	return p.cacheKey
@@ -302,6 +477,9 @@ func (p *Pattern) matchChunk(chunk *Chunk, space []Result, slab *util.Slab) []Re
 
 	if space == nil {
 		for idx := 0; idx < chunk.count; idx++ {
+			if p.mask != 0 && chunk.mask(idx)&p.mask != p.mask {
+				continue
+			}
 			if match, _, _ := p.MatchItem(&chunk.items[idx], false, slab); match != nil {
 				matches = append(matches, *match)
 			}
@@ -318,9 +496,17 @@ func (p *Pattern) matchChunk(chunk *Chunk, space []Result, slab *util.Slab) []Re
 
 // MatchItem returns true if the Item is a match
 func (p *Pattern) MatchItem(item *Item, withPos bool, slab *util.Slab) (*Result, []Offset, *[]int) {
+	if p.base != nil {
+		return p.combinedMatch(item, withPos, slab)
+	}
+	return p.matchQuery(item, withPos, slab)
+}
+
+// matchQuery matches the item against the query of the pattern
+func (p *Pattern) matchQuery(item *Item, withPos bool, slab *util.Slab) (*Result, []Offset, *[]int) {
 	if p.extended {
 		if offsets, bonus, pos := p.extendedMatch(item, withPos, slab); len(offsets) == len(p.termSets) {
-			result := buildResult(item, offsets, bonus)
+			result := buildResult(item, offsets, bonus, p.criteria)
 			return &result, offsets, pos
 		}
 		return nil, nil, nil
@@ -328,18 +514,16 @@ func (p *Pattern) MatchItem(item *Item, withPos bool, slab *util.Slab) (*Result,
 	offset, bonus, pos := p.basicMatch(item, withPos, slab)
 	if sidx := offset[0]; sidx >= 0 {
 		offsets := []Offset{offset}
-		result := buildResult(item, offsets, bonus)
+		result := buildResult(item, offsets, bonus, p.criteria)
 		return &result, offsets, pos
 	}
 	return nil, nil, nil
 }
 
 func (p *Pattern) basicMatch(item *Item, withPos bool, slab *util.Slab) (Offset, int, *[]int) {
-	var input []Token
-	if len(p.nth) == 0 {
-		input = []Token{Token{text: &item.text, prefixLength: 0}}
-	} else {
-		input = p.transformInput(item)
+	input := p.input(item)
+	if p.regexProc != nil {
+		return p.iter(p.regexProc, input, p.caseSensitive, false, p.forward, p.text, withPos, slab)
 	}
 	if p.fuzzy {
 		return p.iter(p.fuzzyAlgo, input, p.caseSensitive, p.normalize, p.forward, p.text, withPos, slab)
@@ -348,12 +532,7 @@ func (p *Pattern) basicMatch(item *Item, withPos bool, slab *util.Slab) (Offset,
 }
 
 func (p *Pattern) extendedMatch(item *Item, withPos bool, slab *util.Slab) ([]Offset, int, *[]int) {
-	var input []Token
-	if len(p.nth) == 0 {
-		input = []Token{Token{text: &item.text, prefixLength: 0}}
-	} else {
-		input = p.transformInput(item)
-	}
+	input := p.input(item)
 	offsets := []Offset{}
 	var totalScore int
 	var allPos *[]int
@@ -361,56 +540,105 @@ func (p *Pattern) extendedMatch(item *Item, withPos bool, slab *util.Slab) ([]Of
 		allPos = &[]int{}
 	}
 	for _, termSet := range p.termSets {
-		var offset Offset
-		var currentScore int
-		matched := false
-		for _, term := range termSet {
-			pfun := p.procFun[term.typ]
-			off, score, pos := p.iter(pfun, input, term.caseSensitive, term.normalize, p.forward, term.text, withPos, slab)
-			if sidx := off[0]; sidx >= 0 {
-				if term.inv {
-					continue
-				}
-				offset, currentScore = off, score
-				matched = true
-				if withPos {
-					if pos != nil {
-						*allPos = append(*allPos, *pos...)
-					} else {
-						for idx := off[0]; idx < off[1]; idx++ {
-							*allPos = append(*allPos, int(idx))
-						}
-					}
-				}
-				break
-			} else if term.inv {
-				offset, currentScore = Offset{0, 0}, 0
-				matched = true
-				continue
-			}
-		}
-		if matched {
+		if offset, score, pos, matched := p.matchTermSet(termSet, input, withPos, slab); matched {
 			offsets = append(offsets, offset)
-			totalScore += currentScore
+			totalScore += score
+			if withPos {
+				*allPos = append(*allPos, pos...)
+			}
 		}
 	}
 	return offsets, totalScore, allPos
 }
 
+// matchTermSet returns the match of the first term of the set that matches
+// the input. An inverse term matches the input without the term, with no
+// positions.
+func (p *Pattern) matchTermSet(termSet termSet, input []Token, withPos bool, slab *util.Slab) (Offset, int, []int, bool) {
+	var offset Offset
+	var score int
+	matched := false
+	for _, term := range termSet {
+		pfun := p.procFun[term.typ]
+		if term.proc != nil {
+			pfun = term.proc
+		}
+		off, currentScore, pos := p.iter(pfun, input, term.caseSensitive, term.normalize, p.forward, term.text, withPos, slab)
+		if sidx := off[0]; sidx >= 0 {
+			if term.inv {
+				continue
+			}
+			var positions []int
+			if pos != nil {
+				positions = *pos
+			} else if withPos {
+				for idx := off[0]; idx < off[1]; idx++ {
+					positions = append(positions, int(idx))
+				}
+			}
+			return off, currentScore, positions, true
+		} else if term.inv {
+			offset, score = Offset{0, 0}, 0
+			matched = true
+			continue
+		}
+	}
+	return offset, score, nil, matched
+}
+
+// termPositions returns the positions of the matches of each term set of the
+// query in the item, so that they can be told apart. It returns nil unless
+// the query has multiple term sets in extended-search mode.
+func (p *Pattern) termPositions(item *Item, slab *util.Slab) [][]int {
+	if !p.extended || p.base != nil || len(p.termSets) < 2 {
+		return nil
+	}
+	input := p.input(item)
+	positions := make([][]int, len(p.termSets))
+	for idx, termSet := range p.termSets {
+		_, _, positions[idx], _ = p.matchTermSet(termSet, input, true, slab)
+	}
+	return positions
+}
+
+func (p *Pattern) input(item *Item) []Token {
+	var input []Token
+	if len(p.nth) == 0 {
+		input = []Token{Token{text: &item.text, prefixLength: 0}}
+	} else {
+		input = p.transformInput(item)
+	}
+	if item.search != nil {
+		// The hidden text of --search-field is placed after the displayed text
+		// so that the offsets of the matches in it are out of the display
+		input = append(input[:len(input):len(input)],
+			Token{text: item.search, prefixLength: int32(item.text.Length())})
+	}
+	return input
+}
+
 func (p *Pattern) transformInput(item *Item) []Token {
-	if item.transformed != nil {
-		return *item.transformed
+	if item.transformed != nil && item.transformed.revision == p.revision {
+		return item.transformed.tokens
 	}
 
 	tokens := Tokenize(item.text.ToString(), p.delimiter)
 	ret := Transform(tokens, p.nth)
-	item.transformed = &ret
+	item.transformed = &transformed{p.revision, ret}
 	return ret
 }
 
+// iter returns the match in the first token that matches. When the fields
+// are weighted, the match of the highest weighted score in all the tokens is
+// returned instead.
 func (p *Pattern) iter(pfun algo.Algo, tokens []Token, caseSensitive bool, normalize bool, forward bool, pattern []rune, withPos bool, slab *util.Slab) (Offset, int, *[]int) {
+	offset, score, positions := Offset{-1, -1}, 0, (*[]int)(nil)
 	for _, part := range tokens {
 		if res, pos := pfun(caseSensitive, normalize, forward, part.text, pattern, withPos, slab); res.Start >= 0 {
+			weightedScore := res.Score * util.Max(part.weight, 1)
+			if offset[0] >= 0 && weightedScore <= score {
+				continue
+			}
 			sidx := int32(res.Start) + part.prefixLength
 			eidx := int32(res.End) + part.prefixLength
 			if pos != nil {
@@ -418,8 +646,20 @@ func (p *Pattern) iter(pfun algo.Algo, tokens []Token, caseSensitive bool, norma
 					(*pos)[idx] += int(part.prefixLength)
 				}
 			}
-			return Offset{sidx, eidx}, res.Score, pos
+			offset, score, positions = Offset{sidx, eidx}, weightedScore, pos
+			if !p.weighted {
+				break
+			}
+		}
+	}
+	return offset, score, positions
+}
+
+func weightedNth(nth []Range) bool {
+	for _, r := range nth {
+		if r.weight > 0 {
+			return true
 		}
 	}
-	return Offset{-1, -1}, 0, nil
+	return false
 }
@@ -2,6 +2,7 @@ package fzf
 
 import (
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/junegunn/fzf/src/algo"
@@ -57,6 +58,18 @@ func TestParseTermsExtendedExact(t *testing.T) {
 	}
 }
 
+func TestParseTermsTypo(t *testing.T) {
+	terms := parseTerms(true, CaseSmart, false, "~aaa !~bbb ~^ccc$ ~'ddd ~")
+	if len(terms) != 5 ||
+		terms[0][0].typ != termTypo || terms[0][0].inv || string(terms[0][0].text) != "aaa" ||
+		terms[1][0].typ != termTypo || !terms[1][0].inv || string(terms[1][0].text) != "bbb" ||
+		terms[2][0].typ != termTypo || string(terms[2][0].text) != "^ccc$" ||
+		terms[3][0].typ != termTypo || string(terms[3][0].text) != "'ddd" ||
+		terms[4][0].typ != termFuzzy || string(terms[4][0].text) != "~" {
+		t.Errorf("%v", terms)
+	}
+}
+
 func TestParseTermsEmpty(t *testing.T) {
 	terms := parseTerms(true, CaseSmart, false, "' ^ !' !^")
 	if len(terms) != 0 {
@@ -64,11 +77,51 @@ func TestParseTermsEmpty(t *testing.T) {
 	}
 }
 
+func TestParseRegexTerms(t *testing.T) {
+	terms := parseRegexTerms(CaseSmart, "^fo+$ !ba[rz] 'qux | \\d+\\S foo\\ Bar")
+	if len(terms) != 4 ||
+		terms[0][0].typ != termRegex || terms[0][0].inv || string(terms[0][0].text) != "^fo+$" ||
+		terms[1][0].typ != termRegex || !terms[1][0].inv || string(terms[1][0].text) != "ba[rz]" ||
+		string(terms[2][0].text) != "'qux" || string(terms[2][1].text) != "\\d+\\S" ||
+		terms[2][1].caseSensitive || string(terms[3][0].text) != "foo Bar" || !terms[3][0].caseSensitive {
+		t.Errorf("%v", terms)
+	}
+}
+
+func TestRegex(t *testing.T) {
+	defer clearPatternCache()
+	match := func(extended bool, query string, str string, expected []Offset) {
+		t.Helper()
+		clearPatternCache()
+		pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: extended, Regex: true, Normalize: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune(query))
+		if pattern.cacheable || pattern.CacheKey() != "" || pattern.mask != 0 {
+			t.Errorf("Regex pattern should not be cached or prefiltered: %s", query)
+		}
+		item := Item{text: util.ToChars([]byte(str))}
+		_, offsets, _ := pattern.MatchItem(&item, true, nil)
+		if !reflect.DeepEqual(offsets, expected) {
+			t.Errorf("%s / %s: %v (expected: %v)", query, str, offsets, expected)
+		}
+	}
+	match(true, "^fo+ ba[rz]$", "foo bar", []Offset{{0, 3}, {4, 7}})
+	match(true, "^fo+ ba[rz]$", "bar foo", nil)
+	match(true, "!^fo+ ba[rz]$", "bar foo baz", []Offset{{0, 0}, {8, 11}})
+	match(true, "qux | o{2}", "foo", []Offset{{1, 3}})
+	match(false, "o b", "foo bar", []Offset{{2, 5}})
+
+	// Smart-case
+	match(true, "\\w+\\S", "FOO", []Offset{{0, 3}})
+	match(true, "F\\w+", "foo", nil)
+
+	// Invalid expressions are taken literally
+	match(true, "fo(", "fo(o", []Offset{{0, 3}})
+	match(false, "[b", "a[b", []Offset{{1, 3}})
+}
+
 func TestExact(t *testing.T) {
 	defer clearPatternCache()
 	clearPatternCache()
-	pattern := BuildPattern(true, algo.FuzzyMatchV2, true, CaseSmart, false, true, true,
-		[]Range{}, Delimiter{}, []rune("'abc"))
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("'abc"))
 	chars := util.ToChars([]byte("aabbcc abc"))
 	res, pos := algo.ExactMatchNaive(
 		pattern.caseSensitive, pattern.normalize, pattern.forward, &chars, pattern.termSets[0][0].text, true, nil)
@@ -83,7 +136,7 @@ func TestExact(t *testing.T) {
 func TestEqual(t *testing.T) {
 	defer clearPatternCache()
 	clearPatternCache()
-	pattern := BuildPattern(true, algo.FuzzyMatchV2, true, CaseSmart, false, true, true, []Range{}, Delimiter{}, []rune("^AbC$"))
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("^AbC$"))
 
 	match := func(str string, sidxExpected int, eidxExpected int) {
 		chars := util.ToChars([]byte(str))
@@ -106,17 +159,17 @@ func TestEqual(t *testing.T) {
 func TestCaseSensitivity(t *testing.T) {
 	defer clearPatternCache()
 	clearPatternCache()
-	pat1 := BuildPattern(true, algo.FuzzyMatchV2, false, CaseSmart, false, true, true, []Range{}, Delimiter{}, []rune("abc"))
+	pat1 := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("abc"))
 	clearPatternCache()
-	pat2 := BuildPattern(true, algo.FuzzyMatchV2, false, CaseSmart, false, true, true, []Range{}, Delimiter{}, []rune("Abc"))
+	pat2 := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("Abc"))
 	clearPatternCache()
-	pat3 := BuildPattern(true, algo.FuzzyMatchV2, false, CaseIgnore, false, true, true, []Range{}, Delimiter{}, []rune("abc"))
+	pat3 := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, CaseMode: CaseIgnore, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("abc"))
 	clearPatternCache()
-	pat4 := BuildPattern(true, algo.FuzzyMatchV2, false, CaseIgnore, false, true, true, []Range{}, Delimiter{}, []rune("Abc"))
+	pat4 := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, CaseMode: CaseIgnore, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("Abc"))
 	clearPatternCache()
-	pat5 := BuildPattern(true, algo.FuzzyMatchV2, false, CaseRespect, false, true, true, []Range{}, Delimiter{}, []rune("abc"))
+	pat5 := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, CaseMode: CaseRespect, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("abc"))
 	clearPatternCache()
-	pat6 := BuildPattern(true, algo.FuzzyMatchV2, false, CaseRespect, false, true, true, []Range{}, Delimiter{}, []rune("Abc"))
+	pat6 := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, CaseMode: CaseRespect, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("Abc"))
 
 	if string(pat1.text) != "abc" || pat1.caseSensitive != false ||
 		string(pat2.text) != "Abc" || pat2.caseSensitive != true ||
@@ -129,9 +182,9 @@ func TestCaseSensitivity(t *testing.T) {
 }
 
 func TestOrigTextAndTransformed(t *testing.T) {
-	pattern := BuildPattern(true, algo.FuzzyMatchV2, true, CaseSmart, false, true, true, []Range{}, Delimiter{}, []rune("jg"))
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("jg"))
 	tokens := Tokenize("junegunn", Delimiter{})
-	trans := Transform(tokens, []Range{Range{1, 1}})
+	trans := Transform(tokens, []Range{{begin: 1, end: 1}})
 
 	origBytes := []byte("junegunn.choi")
 	for _, extended := range []bool{false, true} {
@@ -139,12 +192,12 @@ func TestOrigTextAndTransformed(t *testing.T) {
 		chunk.items[0] = Item{
 			text:        util.ToChars([]byte("junegunn")),
 			origText:    &origBytes,
-			transformed: &trans}
+			transformed: &transformed{0, trans}}
 		pattern.extended = extended
 		matches := pattern.matchChunk(&chunk, nil, slab) // No cache
 		if !(matches[0].item.text.ToString() == "junegunn" &&
 			string(*matches[0].item.origText) == "junegunn.choi" &&
-			reflect.DeepEqual(*matches[0].item.transformed, trans)) {
+			reflect.DeepEqual(matches[0].item.transformed.tokens, trans)) {
 			t.Error("Invalid match result", matches)
 		}
 
@@ -152,7 +205,7 @@ func TestOrigTextAndTransformed(t *testing.T) {
 		if !(match.item.text.ToString() == "junegunn" &&
 			string(*match.item.origText) == "junegunn.choi" &&
 			offsets[0][0] == 0 && offsets[0][1] == 5 &&
-			reflect.DeepEqual(*match.item.transformed, trans)) {
+			reflect.DeepEqual(match.item.transformed.tokens, trans)) {
 			t.Error("Invalid match result", match, offsets, extended)
 		}
 		if !((*pos)[0] == 4 && (*pos)[1] == 0) {
@@ -161,10 +214,25 @@ func TestOrigTextAndTransformed(t *testing.T) {
 	}
 }
 
+func TestSearchField(t *testing.T) {
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("ali"))
+	search := util.ToChars([]byte("alias"))
+	item := Item{text: util.ToChars([]byte("name")), search: &search}
+	match, offsets, _ := pattern.MatchItem(&item, true, nil)
+	// The offsets are beyond the displayed text
+	if match == nil || offsets[0][0] != 4 || offsets[0][1] != 7 {
+		t.Error("Invalid match result", match, offsets)
+	}
+	item.search = nil
+	if match, _, _ := pattern.MatchItem(&item, false, nil); match != nil {
+		t.Error("Should not match", match)
+	}
+}
+
 func TestCacheKey(t *testing.T) {
 	test := func(extended bool, patStr string, expected string, cacheable bool) {
 		clearPatternCache()
-		pat := BuildPattern(true, algo.FuzzyMatchV2, extended, CaseSmart, false, true, true, []Range{}, Delimiter{}, []rune(patStr))
+		pat := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: extended, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune(patStr))
 		if pat.CacheKey() != expected {
 			t.Errorf("Expected: %s, actual: %s", expected, pat.CacheKey())
 		}
@@ -185,10 +253,30 @@ func TestCacheKey(t *testing.T) {
 	test(true, "| | | foo", "foo", false)
 }
 
+func TestCacheKeyFuzzyTypos(t *testing.T) {
+	test := func(extended bool, patStr string, expected string) {
+		clearPatternCache()
+		pat := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: extended, Forward: true, Cacheable: true, Typos: 1, FuzzyTypos: 2}, []rune(patStr))
+		if pat.CacheKey() != expected {
+			t.Errorf("Expected: %q, actual: %q", expected, pat.CacheKey())
+		}
+		if !pat.cacheable {
+			t.Errorf("Expected to be cacheable: %s", patStr)
+		}
+		clearPatternCache()
+	}
+	// The number of the typos allowed in each fuzzy term is a part of the key
+	test(false, "fo", "\x01fo")
+	test(false, "foo", "\x02foo")
+	test(false, "foobar", "\x03foobar")
+	test(true, "fo foo", "\x01fo\t\x02foo")
+	test(true, "foo barbaz", "\x02foo\t\x03barbaz")
+}
+
 func TestCacheable(t *testing.T) {
 	test := func(fuzzy bool, str string, expected string, cacheable bool) {
 		clearPatternCache()
-		pat := BuildPattern(fuzzy, algo.FuzzyMatchV2, true, CaseSmart, true, true, true, []Range{}, Delimiter{}, []rune(str))
+		pat := BuildPattern(PatternOptions{Fuzzy: fuzzy, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Normalize: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune(str))
 		if pat.CacheKey() != expected {
 			t.Errorf("Expected: %s, actual: %s", expected, pat.CacheKey())
 		}
@@ -207,3 +295,68 @@ func TestCacheable(t *testing.T) {
 	test(false, "foo 'bar", "foo", false)
 	test(false, "foo !bar", "foo", false)
 }
+
+func TestTransformedRevision(t *testing.T) {
+	item := Item{text: util.ToChars([]byte("foo bar"))}
+	match := func(nth []Range, revision int, query string) bool {
+		clearPatternCache()
+		pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1, Nth: nth, Revision: revision}, []rune(query))
+		result, _, _ := pattern.MatchItem(&item, false, slab)
+		return result != nil
+	}
+	if !match([]Range{{begin: 1, end: 1}}, 0, "foo") || match([]Range{{begin: 1, end: 1}}, 0, "bar") {
+		t.Error("Unexpected match on the first field")
+	}
+	// The tokens of the previous revision are not used
+	if match([]Range{{begin: 2, end: 2}}, 1, "foo") || !match([]Range{{begin: 2, end: 2}}, 1, "bar") || item.transformed.revision != 1 {
+		t.Error("Unexpected match on the second field")
+	}
+	clearPatternCache()
+}
+
+func TestWeightedNthScore(t *testing.T) {
+	match := func(nth string, text string) (*Result, []Offset) {
+		clearPatternCache()
+		ranges, _ := parseWeightedNth(nth)
+		item := Item{text: util.ToChars([]byte(text))}
+		pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Criteria: []criterion{byScore}, Cacheable: true, Prefilter: true, Typos: 1, Nth: ranges}, []rune("foo"))
+		result, offsets, _ := pattern.MatchItem(&item, false, slab)
+		return result, offsets
+	}
+	// The match in the weighted field is taken even if it is not the first one
+	if _, offsets := match("1,2:5", "foo foo"); len(offsets) != 1 || offsets[0][0] != 4 {
+		t.Errorf("Unexpected offsets: %v", offsets)
+	}
+	if _, offsets := match("1,2", "foo foo"); len(offsets) != 1 || offsets[0][0] != 0 {
+		t.Errorf("Unexpected offsets: %v", offsets)
+	}
+	// The item with the match in the heavier field ranks higher
+	first, _ := match("1:3,2", "foo bar")
+	second, _ := match("1:3,2", "bar foo")
+	if first == nil || second == nil || !(first.points[len(first.points)-1] < second.points[len(second.points)-1]) {
+		t.Errorf("Unexpected results: %v, %v", first, second)
+	}
+	clearPatternCache()
+}
+
+func TestTermPositions(t *testing.T) {
+	item := Item{text: util.ToChars([]byte("foo bar baz"))}
+	for _, query := range []string{"foo", "foo ba"} {
+		clearPatternCache()
+		pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: query == "foo", Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune(query))
+		if positions := pattern.termPositions(&item, slab); positions != nil {
+			t.Errorf("%q: no positions expected for single term: %v", query, positions)
+		}
+	}
+
+	clearPatternCache()
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune("^foo 'xyz | baz !qux"))
+	positions := pattern.termPositions(&item, slab)
+	sort.Ints(positions[0])
+	sort.Ints(positions[1])
+	if len(positions) != 3 || !reflect.DeepEqual(positions[0], []int{0, 1, 2}) ||
+		!reflect.DeepEqual(positions[1], []int{8, 9, 10}) || len(positions[2]) != 0 {
+		t.Errorf("unexpected positions: %v", positions)
+	}
+	clearPatternCache()
+}
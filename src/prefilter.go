@@ -0,0 +1,104 @@
+package fzf
+
+import (
+	"math/bits"
+	"unicode/utf8"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// The prefilter tells the items that cannot match the pattern from the bitmask
+// of the characters in them, before the expensive scoring of the items. Each
+// printable ASCII character is folded to lowercase and mapped to one of the
+// lower 63 bits; the letters and the digits to their own bits, and the other
+// symbols to the rest. The highest bit tells that the mask of the item is
+// computed.
+const (
+	maskComputed uint64 = 1 << 63
+	maskAll      uint64 = maskComputed - 1
+)
+
+func maskBit(r rune) uint64 {
+	if r <= ' ' || r > '~' {
+		return 0
+	}
+	switch {
+	case r >= 'a' && r <= 'z':
+		return 1 << uint(r-'a')
+	case r >= 'A' && r <= 'Z':
+		return 1 << uint(r-'A')
+	case r >= '0' && r <= '9':
+		return 1 << uint(26+r-'0')
+	}
+	return 1 << uint(36+r%27)
+}
+
+// charMask returns the bitmask of the characters. The non-ASCII characters
+// set all the bits as they may match the ASCII characters of the pattern when
+// normalized.
+func charMask(chars *util.Chars) uint64 {
+	var mask uint64
+	if chars.IsBytes() {
+		for _, b := range chars.Bytes() {
+			mask |= maskBit(rune(b))
+		}
+		return mask
+	}
+	for i := 0; i < chars.Length(); i++ {
+		r := chars.Get(i)
+		if r >= utf8.RuneSelf {
+			return maskAll
+		}
+		mask |= maskBit(r)
+	}
+	return mask
+}
+
+// itemMask returns the bitmask of the characters of the item that can be
+// searched, including the hidden text of --search-field
+func itemMask(item *Item) uint64 {
+	mask := charMask(&item.text)
+	if item.search != nil {
+		mask |= charMask(item.search)
+	}
+	return mask | maskComputed
+}
+
+// patternMask returns the bitmask of the characters every matching item should
+// have. Zero is returned if the prefilter is not worth it as the pattern has
+// too few distinct characters.
+func (p *Pattern) patternMask() uint64 {
+	var mask uint64
+	add := func(runes []rune) {
+		for _, r := range runes {
+			mask |= maskBit(r)
+		}
+	}
+	if !p.extended && !p.regex {
+		add(p.text)
+	}
+	for _, termSet := range p.termSets {
+		// Any of the terms of OR operator may match, and the characters of
+		// a typo-tolerant term or a regular expression may be missing in the
+		// item
+		if len(termSet) == 1 && !termSet[0].inv && termSet[0].typ != termTypo && termSet[0].typ != termRegex {
+			add(termSet[0].text)
+		}
+	}
+	if bits.OnesCount64(mask) < prefilterMinChars {
+		return 0
+	}
+	return mask
+}
+
+// mask returns the bitmask of the item at the index, which is computed on the
+// first call. A chunk is only scanned by one matcher goroutine at a time.
+func (c *Chunk) mask(idx int) uint64 {
+	if c.masks == nil {
+		c.masks = new([chunkSize]uint64)
+	}
+	if c.masks[idx] == 0 {
+		c.masks[idx] = itemMask(&c.items[idx])
+	}
+	return c.masks[idx]
+}
@@ -0,0 +1,74 @@
+package fzf
+
+import (
+	"testing"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestCharMask(t *testing.T) {
+	bytes := util.ToChars([]byte("Foo bar"))
+	runes := util.RunesToChars([]rune("foo BAR"))
+	if mask := charMask(&bytes); mask != charMask(&runes) || mask != maskBit('f')|maskBit('o')|maskBit('b')|maskBit('a')|maskBit('r') {
+		t.Errorf("%x", mask)
+	}
+	if maskBit(' ') != 0 || maskBit('F') != maskBit('f') {
+		t.Errorf("Unexpected bits")
+	}
+	nonASCII := util.ToChars([]byte("café"))
+	if mask := charMask(&nonASCII); mask != maskAll {
+		t.Errorf("%x", mask)
+	}
+}
+
+func TestPatternMask(t *testing.T) {
+	test := func(extended bool, str string, expected string) {
+		clearPatternCache()
+		pat := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: extended, Normalize: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune(str))
+		var mask uint64
+		for _, r := range expected {
+			mask |= maskBit(r)
+		}
+		if pat.mask != mask {
+			t.Errorf("%q: expected %x, got %x", str, mask, pat.mask)
+		}
+	}
+	test(true, "ab", "")
+	test(true, "abc", "abc")
+	test(true, "'Foo ^bar$", "fobar")
+	test(true, "foo !bar", "")
+	test(true, "foo !bar baz", "fobaz")
+	test(true, "foo | bar baz", "baz")
+	test(true, "~foo bar baz", "barz")
+	test(false, "a b c", "abc")
+	clearPatternCache()
+}
+
+func TestPrefilter(t *testing.T) {
+	lines := []string{"foo bar", "fob", "café au lait", "Hello World", "src/fzf/prefilter.go"}
+	search := util.ToChars([]byte("hidden"))
+	chunk := &Chunk{count: len(lines)}
+	for idx, line := range lines {
+		chunk.items[idx] = Item{text: util.ToChars([]byte(line))}
+		chunk.items[idx].text.Index = int32(idx)
+	}
+	chunk.items[1].search = &search
+	for _, query := range []string{"fbr", "cafe", "hwd", "fob hid", "srcgo", "xyz", "'fzf/ pre !foo"} {
+		clearPatternCache()
+		full := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Normalize: true, Forward: true, Typos: 1}, []rune(query))
+		expected := full.matchChunk(chunk, nil, slab)
+		clearPatternCache()
+		pat := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Normalize: true, Forward: true, Prefilter: true, Typos: 1}, []rune(query))
+		if pat.mask == 0 {
+			t.Errorf("%q: prefilter not used", query)
+		}
+		if matches := pat.matchChunk(chunk, nil, slab); len(matches) != len(expected) {
+			t.Errorf("%q: expected %d matches, got %d", query, len(expected), len(matches))
+		}
+	}
+	if chunk.masks == nil || chunk.masks[1]&maskBit('h') == 0 || chunk.masks[2] != maskAll|maskComputed {
+		t.Errorf("Unexpected masks: %v", chunk.masks)
+	}
+	clearPatternCache()
+}
@@ -0,0 +1,82 @@
+package fzf
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+)
+
+// RankLog records how the accepted items were ranked in each session
+type RankLog struct {
+	path string
+	size int
+}
+
+type rankLogItem struct {
+	Rank  int    `json:"rank"`
+	Score int    `json:"score"`
+	Text  string `json:"text"`
+}
+
+type rankLogEntry struct {
+	Query    string        `json:"query"`
+	Top      []rankLogItem `json:"top"`
+	Accepted []rankLogItem `json:"accepted"`
+}
+
+// NewRankLog returns the pointer to a new RankLog struct
+func NewRankLog(path string, size int) *RankLog {
+	return &RankLog{path: path, size: size}
+}
+
+func resultScore(merger *Merger, result Result) int {
+	if merger.pattern == nil || merger.pattern.IsEmpty() {
+		return 0
+	}
+	return math.MaxUint16 - int(result.points[3])
+}
+
+func (r *RankLog) entry(query string, merger *Merger, accepted []*Item, stripAnsi bool) rankLogEntry {
+	entry := rankLogEntry{Query: query, Top: []rankLogItem{}, Accepted: []rankLogItem{}}
+	ranks := make(map[int32]rankLogItem)
+	for _, item := range accepted {
+		ranks[item.Index()] = rankLogItem{Text: item.AsString(stripAnsi)}
+	}
+	found := 0
+	for i := 0; i < merger.Length() && i < rankLogSearchMax; i++ {
+		result := merger.Get(i)
+		logItem := rankLogItem{
+			Rank:  i + 1,
+			Score: resultScore(merger, result),
+			Text:  result.item.AsString(stripAnsi)}
+		if i < r.size {
+			entry.Top = append(entry.Top, logItem)
+		}
+		if _, prs := ranks[result.item.Index()]; prs {
+			ranks[result.item.Index()] = logItem
+			found++
+		}
+		if i >= r.size && found == len(ranks) {
+			break
+		}
+	}
+	// Items not found within the search range are recorded with rank 0
+	for _, item := range accepted {
+		entry.Accepted = append(entry.Accepted, ranks[item.Index()])
+	}
+	return entry
+}
+
+func (r *RankLog) record(query string, merger *Merger, accepted []*Item, stripAnsi bool) error {
+	data, err := json.Marshal(r.entry(query, merger, accepted, stripAnsi))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
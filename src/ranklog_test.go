@@ -0,0 +1,54 @@
+package fzf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestRankLog(t *testing.T) {
+	criteria := []criterion{byScore, byLength}
+	pattern := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Criteria: criteria, Prefilter: true, Typos: 1}, []rune("fb"))
+	results := []Result{}
+	items := []*Item{}
+	for idx, str := range []string{"foobar", "fxxxxb", "foo/bar"} {
+		chars := util.ToChars([]byte(str))
+		chars.Index = int32(idx)
+		item := &Item{text: chars}
+		items = append(items, item)
+		result, _, _ := pattern.MatchItem(item, false, nil)
+		results = append(results, *result)
+	}
+	merger := NewMerger(pattern, [][]Result{results}, false, false)
+
+	f, _ := ioutil.TempFile("", "fzf-rank-log")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	rankLog := NewRankLog(f.Name(), 2)
+	rankLog.record("fb", merger, []*Item{items[2]}, false)
+	rankLog.record("fb", merger, []*Item{}, false)
+
+	data, _ := ioutil.ReadFile(f.Name())
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries: %s", data)
+	}
+	var entry rankLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Query != "fb" || len(entry.Top) != 2 || entry.Top[0].Text != "foobar" ||
+		entry.Top[0].Score <= 0 || len(entry.Accepted) != 1 ||
+		entry.Accepted[0].Rank != 3 || entry.Accepted[0].Text != "foo/bar" {
+		t.Errorf("Unexpected entry: %s", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil || len(entry.Accepted) != 0 {
+		t.Errorf("Unexpected entry: %s", lines[1])
+	}
+}
@@ -3,10 +3,15 @@ package fzf
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,23 +20,36 @@ import (
 	"github.com/saracen/walker"
 )
 
-// Reader reads from command or standard input
+// Reader reads from command, URL, or standard input
 type Reader struct {
-	pusher   func([]byte) bool
-	eventBox *util.EventBox
-	delimNil bool
-	event    int32
-	finChan  chan bool
-	mutex    sync.Mutex
-	exec     *exec.Cmd
-	command  *string
-	killed   bool
-	wait     bool
+	pusher     func([]byte) bool
+	eventBox   *util.EventBox
+	delimNil   bool
+	decompress bool
+	event      int32
+	finChan    chan bool
+	mutex      sync.Mutex
+	exec       *exec.Cmd
+	cancel     context.CancelFunc
+	command    *string
+	killed     bool
+	wait       bool
+	url        string
+	attempt    context.CancelFunc
+	resuming   bool
+	sources    []string
+	readers    []*Reader
+}
+
+// sourceProgress is the number of the sources completely read out of all
+type sourceProgress struct {
+	loaded int
+	total  int
 }
 
 // NewReader returns new Reader object
-func NewReader(pusher func([]byte) bool, eventBox *util.EventBox, delimNil bool, wait bool) *Reader {
-	return &Reader{pusher, eventBox, delimNil, int32(EvtReady), make(chan bool, 1), sync.Mutex{}, nil, nil, false, wait}
+func NewReader(pusher func([]byte) bool, eventBox *util.EventBox, delimNil bool, decompress bool, wait bool, url string, sources []string) *Reader {
+	return &Reader{pusher, eventBox, delimNil, decompress, int32(EvtReady), make(chan bool, 1), sync.Mutex{}, nil, nil, nil, false, wait, url, nil, false, sources, nil}
 }
 
 func (r *Reader) startEventPoller() {
@@ -59,6 +77,7 @@ func (r *Reader) startEventPoller() {
 }
 
 func (r *Reader) fin(success bool) {
+	r.setResuming(false)
 	atomic.StoreInt32(&r.event, int32(EvtReadFin))
 	if r.wait {
 		<-r.finChan
@@ -79,25 +98,93 @@ func (r *Reader) terminate() {
 	defer func() { r.mutex.Unlock() }()
 
 	r.killed = true
-	if r.exec != nil && r.exec.Process != nil {
+	for _, reader := range r.readers {
+		reader.terminate()
+	}
+	if r.cancel != nil {
+		r.cancel()
+	} else if r.exec != nil && r.exec.Process != nil {
 		util.KillCommand(r.exec)
 	} else if defaultCommand != "" {
 		os.Stdin.Close()
 	}
 }
 
+// watchResume resumes the read whenever the process is continued after being
+// stopped. It returns the function to stop watching.
+func (r *Reader) watchResume() func() {
+	contChan := make(chan os.Signal, 1)
+	done := make(chan bool)
+	notifyOnCont(contChan)
+	go func() {
+		for {
+			select {
+			case <-contChan:
+				r.resume()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(contChan)
+		close(done)
+	}
+}
+
+// resume restarts the pending request to the URL from the last complete line,
+// as the connection may have gone stale while the process was stopped. The
+// command is continued as well in case it was stopped along with the process.
+func (r *Reader) resume() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.killed {
+		return
+	}
+	for _, reader := range r.readers {
+		reader.resume()
+	}
+	if r.attempt != nil {
+		r.resuming = true
+		r.eventBox.Set(EvtReadResume, true)
+		r.attempt()
+	} else if r.exec != nil && r.exec.Process != nil {
+		util.ContinueCommand(r.exec)
+	}
+}
+
+func (r *Reader) setResuming(resuming bool) {
+	r.mutex.Lock()
+	if r.resuming != resuming {
+		r.resuming = resuming
+		r.eventBox.Set(EvtReadResume, resuming)
+	}
+	r.mutex.Unlock()
+}
+
 func (r *Reader) restart(command string) {
 	r.event = int32(EvtReady)
 	r.startEventPoller()
-	success := r.readFromCommand(nil, command)
+	defer r.watchResume()()
+	var success bool
+	if len(r.url) > 0 && command == r.url {
+		success = r.readFromURL(command)
+	} else {
+		success = r.readFromCommand(nil, command)
+	}
 	r.fin(success)
 }
 
 // ReadSource reads data from the default command or from standard input
 func (r *Reader) ReadSource() {
 	r.startEventPoller()
+	defer r.watchResume()()
 	var success bool
-	if util.IsTty() {
+	if len(r.sources) > 0 {
+		success = r.readSources()
+	} else if len(r.url) > 0 {
+		success = r.readFromURL(r.url)
+	} else if util.IsTty() {
 		// The default command for *nix requires bash
 		shell := "bash"
 		cmd := os.Getenv("FZF_DEFAULT_COMMAND")
@@ -116,17 +203,81 @@ func (r *Reader) ReadSource() {
 	r.fin(success)
 }
 
-func (r *Reader) feed(src io.Reader) {
+// readSources reads from the sources concurrently. The items of a source are
+// available as soon as they are read regardless of the other sources, and
+// EvtReadSource is fired as each source completes.
+func (r *Reader) readSources() bool {
+	pusher := func(data []byte) bool {
+		if r.pusher(data) {
+			atomic.StoreInt32(&r.event, int32(EvtReadNew))
+		}
+		return false
+	}
+	readers := make([]*Reader, len(r.sources))
+	for idx := range readers {
+		readers[idx] = NewReader(pusher, r.eventBox, r.delimNil, r.decompress, false, "", nil)
+	}
+	r.mutex.Lock()
+	r.killed = false
+	r.readers = readers
+	r.mutex.Unlock()
+
+	var waitGroup sync.WaitGroup
+	var mutex sync.Mutex
+	success := true
+	progress := sourceProgress{0, len(r.sources)}
+	for idx, source := range r.sources {
+		waitGroup.Add(1)
+		go func(reader *Reader, source string) {
+			defer waitGroup.Done()
+			ok := reader.readFrom(source)
+			mutex.Lock()
+			success = success && ok
+			progress.loaded++
+			r.eventBox.Set(EvtReadSource, progress)
+			mutex.Unlock()
+		}(readers[idx], source)
+	}
+	waitGroup.Wait()
+	return success
+}
+
+// readFrom reads from the source given to --source option
+func (r *Reader) readFrom(source string) bool {
+	switch {
+	case source == "stdin":
+		if util.IsTty() {
+			// Nothing to read
+			return true
+		}
+		return r.readFromStdin()
+	case source == "walker":
+		return r.readFiles()
+	case isURL(source):
+		return r.readFromURL(source)
+	}
+	return r.readFromCommand(nil, source)
+}
+
+// feed pushes the items read from the source and returns the number of bytes
+// consumed. When the source fails with an error other than io.EOF, the
+// incomplete last line is discarded.
+func (r *Reader) feed(src io.Reader) (int64, error) {
 	delim := byte('\n')
 	if r.delimNil {
 		delim = '\000'
 	}
+	var consumed int64
 	reader := bufio.NewReaderSize(src, readerBufferSize)
 	for {
 		// ReadBytes returns err != nil if and only if the returned data does not
 		// end in delim.
 		bytea, err := reader.ReadBytes(delim)
 		byteaLen := len(bytea)
+		if err != nil && err != io.EOF {
+			return consumed, err
+		}
+		consumed += int64(byteaLen)
 		if byteaLen > 0 {
 			if err == nil {
 				// get rid of carriage return if under Windows:
@@ -141,13 +292,28 @@ func (r *Reader) feed(src io.Reader) {
 			}
 		}
 		if err != nil {
-			break
+			return consumed, nil
 		}
 	}
 }
 
+// input returns the stream decompressed unless disabled by --no-decompress
+func (r *Reader) input(src io.Reader) io.Reader {
+	if !r.decompress {
+		return src
+	}
+	return decompress(src)
+}
+
 func (r *Reader) readFromStdin() bool {
-	r.feed(os.Stdin)
+	if _, err := r.feed(r.input(os.Stdin)); err != nil {
+		// Shown in place of the failed command, such as the missing zstd
+		message := err.Error()
+		r.mutex.Lock()
+		r.command = &message
+		r.mutex.Unlock()
+		return false
+	}
 	return true
 }
 
@@ -180,6 +346,7 @@ func (r *Reader) readFiles() bool {
 func (r *Reader) readFromCommand(shell *string, command string) bool {
 	r.mutex.Lock()
 	r.killed = false
+	r.cancel = nil
 	r.command = &command
 	if shell != nil {
 		r.exec = util.ExecCommandWith(*shell, command, true)
@@ -196,6 +363,117 @@ func (r *Reader) readFromCommand(shell *string, command string) bool {
 	if err != nil {
 		return false
 	}
-	r.feed(out)
-	return r.exec.Wait() == nil
+	_, err = r.feed(r.input(out))
+	return r.exec.Wait() == nil && err == nil
+}
+
+func isURL(str string) bool {
+	return strings.HasPrefix(str, "http://") || strings.HasPrefix(str, "https://")
+}
+
+// urlClient is the HTTP client for the source URL. As the response can be a
+// stream that never ends, the timeout only applies to the connection and the
+// response header instead of the whole request.
+var urlClient = newURLClient(sourceURLTimeout)
+
+func newURLClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{Transport: transport}
+}
+
+// clientError is the status of the failed request that should not be retried
+type clientError string
+
+func (e clientError) Error() string {
+	return string(e)
+}
+
+// readFromURL streams the items from the HTTP(S) endpoint. When the request
+// fails or the connection is lost, it reconnects with exponential backoff and
+// resumes after the last complete line.
+func (r *Reader) readFromURL(url string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.mutex.Lock()
+	r.killed = false
+	r.exec = nil
+	r.cancel = cancel
+	r.command = &url
+	r.mutex.Unlock()
+
+	var offset int64
+	backoff := sourceURLBackoffMin
+	for retries := 0; ; retries++ {
+		attemptCtx, attempt := context.WithCancel(ctx)
+		r.mutex.Lock()
+		r.attempt = attempt
+		r.mutex.Unlock()
+		read, err := r.fetchURL(attemptCtx, url, offset)
+		r.mutex.Lock()
+		r.attempt = nil
+		resuming := r.resuming
+		r.mutex.Unlock()
+		attempt()
+		if err == nil {
+			return true
+		}
+		if _, ok := err.(clientError); ok {
+			// No point in retrying
+			return false
+		}
+		offset += read
+		if read > 0 || resuming {
+			// Made progress, start over
+			retries = 0
+			backoff = sourceURLBackoffMin
+		}
+		if ctx.Err() != nil || retries >= sourceURLRetries {
+			return false
+		}
+		if resuming {
+			// Reconnect right away from the checkpoint
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = util.DurWithin(backoff*2, sourceURLBackoffMin, sourceURLBackoffMax)
+	}
+}
+
+// fetchURL requests the content of the URL after the offset, and returns the
+// number of bytes of the complete lines read
+func (r *Reader) fetchURL(ctx context.Context, url string, offset int64) (int64, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range request, skip the lines already read
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			return 0, err
+		}
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return 0, clientError(resp.Status)
+	default:
+		return 0, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	r.setResuming(false)
+	return r.feed(resp.Body)
 }
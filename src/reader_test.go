@@ -1,6 +1,12 @@
 package fzf
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,7 +18,7 @@ func TestReadFromCommand(t *testing.T) {
 	eb := util.NewEventBox()
 	reader := NewReader(
 		func(s []byte) bool { strs = append(strs, string(s)); return true },
-		eb, false, true)
+		eb, false, true, true, "", nil)
 
 	reader.startEventPoller()
 
@@ -61,3 +67,120 @@ func TestReadFromCommand(t *testing.T) {
 		t.Error("EvtReadFin should be set")
 	}
 }
+
+func TestReadFromURL(t *testing.T) {
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/items":
+			if req.Header.Get("Range") == "bytes=8-" {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("ghi\n"))
+				return
+			}
+			w.Header().Set("Content-Length", "12")
+			w.Write([]byte("abc\ndef\ngh"))
+			// Connection closed before the whole content is sent
+		case "/stale":
+			if req.Header.Get("Range") == "bytes=4-" {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("def\n"))
+				return
+			}
+			w.Write([]byte("abc\nde"))
+			w.(http.Flusher).Flush()
+			// Stalled until the client gives up
+			<-req.Context().Done()
+		case "/hang":
+			// No response until the client gives up
+			<-req.Context().Done()
+		case "/flaky":
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("foo\nbar"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	strs := []string{}
+	eb := util.NewEventBox()
+	reader := NewReader(
+		func(s []byte) bool { strs = append(strs, string(s)); return true },
+		eb, false, true, false, "", nil)
+
+	if !reader.readFromURL(server.URL+"/items") || strings.Join(strs, ",") != "abc,def,ghi" {
+		t.Errorf("%s", strs)
+	}
+
+	strs = []string{}
+	if !reader.readFromURL(server.URL+"/flaky") || strings.Join(strs, ",") != "foo,bar" {
+		t.Errorf("%s", strs)
+	}
+
+	strs = []string{}
+	var stale *Reader
+	stale = NewReader(
+		func(s []byte) bool {
+			strs = append(strs, string(s))
+			if len(strs) == 1 {
+				// As if the process was stopped and continued
+				stale.resume()
+			}
+			return true
+		}, eb, false, true, false, "", nil)
+	if !stale.readFromURL(server.URL+"/stale") || strings.Join(strs, ",") != "abc,def" {
+		t.Errorf("%s", strs)
+	}
+	eb.Wait(func(events *util.Events) {
+		if resuming := (*events)[EvtReadResume]; resuming != false {
+			t.Errorf("%v", resuming)
+		}
+	})
+
+	strs = []string{}
+	if reader.readFromURL(server.URL+"/missing") || len(strs) > 0 {
+		t.Errorf("%s", strs)
+	}
+
+	defer func(client *http.Client) { urlClient = client }(urlClient)
+	urlClient = newURLClient(100 * time.Millisecond)
+	if _, err := reader.fetchURL(context.Background(), server.URL+"/hang", 0); err == nil {
+		t.Error("request should time out")
+	}
+}
+
+func TestReadSources(t *testing.T) {
+	var mutex sync.Mutex
+	strs := []string{}
+	eb := util.NewEventBox()
+	reader := NewReader(
+		func(s []byte) bool {
+			mutex.Lock()
+			strs = append(strs, string(s))
+			mutex.Unlock()
+			return true
+		}, eb, false, true, false, "", []string{"echo foo", "sleep 0.1; echo bar; echo baz"})
+
+	if !reader.readSources() {
+		t.Error("should succeed")
+	}
+	sort.Strings(strs)
+	if strings.Join(strs, ",") != "bar,baz,foo" {
+		t.Errorf("%s", strs)
+	}
+	eb.Wait(func(events *util.Events) {
+		if progress := (*events)[EvtReadSource]; progress != (sourceProgress{2, 2}) {
+			t.Errorf("%v", progress)
+		}
+	})
+
+	reader.sources = []string{"echo foo", "exit 1"}
+	if reader.readSources() {
+		t.Error("should fail")
+	}
+}
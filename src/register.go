@@ -0,0 +1,111 @@
+package fzf
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// registerSet is the texts stored by yank-to to be inserted to the query by
+// put-from, named after single characters
+type registerSet map[rune][]rune
+
+// registerPicker is the pending yank-to or put-from without the name of the
+// register, which is given by the next key
+type registerPicker struct {
+	put  bool
+	text []rune // Text to store for yank-to
+}
+
+// validRegister tells if the character can be the name of a register
+func validRegister(name rune) bool {
+	return unicode.IsLetter(name) || unicode.IsDigit(name)
+}
+
+// parseRegister returns the name of the register given as the argument of the
+// action. The empty argument means the register to be picked by the next key.
+func parseRegister(str string) (rune, bool) {
+	runes := []rune(str)
+	if len(runes) == 0 {
+		return 0, true
+	}
+	if len(runes) != 1 || !validRegister(runes[0]) {
+		return 0, false
+	}
+	return runes[0], true
+}
+
+// killAction tells if the action stores the text removed from the query to be
+// yanked
+func killAction(t actionType) bool {
+	switch t {
+	case actCancel, actUnixLineDiscard, actUnixWordRubout, actBackwardKillWord, actKillWord, actKillLine:
+		return true
+	}
+	return false
+}
+
+// yankTo stores the text in the register. The register is picked by the next
+// key if not named.
+func (t *Terminal) yankTo(name string, text []rune) {
+	register, _ := parseRegister(name)
+	if register == 0 {
+		t.picker = &registerPicker{text: text}
+		return
+	}
+	t.registers[register] = text
+}
+
+// putFrom inserts the text of the register at the cursor. The register is
+// picked by the next key if not named. It returns false if there is nothing to
+// insert.
+func (t *Terminal) putFrom(name string) bool {
+	register, _ := parseRegister(name)
+	if register == 0 {
+		if len(t.registers) == 0 {
+			return false
+		}
+		t.picker = &registerPicker{put: true}
+		return true
+	}
+	text, prs := t.registers[register]
+	if !prs {
+		return false
+	}
+	suffix := copySlice(t.input[t.cx:])
+	t.input = append(append(t.input[:t.cx], text...), suffix...)
+	t.cx += len(text)
+	return true
+}
+
+// registerHints returns the names of the registers with their texts on single
+// lines, sorted by the names
+func (t *Terminal) registerHints() []bindingHint {
+	hints := []bindingHint{}
+	for name, text := range t.registers {
+		desc := strings.Map(func(r rune) rune {
+			if r == '\n' || r == '\r' || r == '\t' {
+				return ' '
+			}
+			return r
+		}, string(text))
+		hints = append(hints, bindingHint{key: string(name), desc: desc})
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].key < hints[j].key
+	})
+	return hints
+}
+
+// printRegisterPopup shows the registers to pick in the popup
+func (t *Terminal) printRegisterPopup() {
+	label := " Yank to "
+	if t.picker.put {
+		label = " Put from "
+	}
+	hints := t.registerHints()
+	if len(hints) == 0 {
+		hints = []bindingHint{{key: "", desc: "(empty)"}}
+	}
+	t.printPopup(label, hints, -1)
+}
@@ -2,6 +2,7 @@ package fzf
 
 import (
 	"math"
+	"os"
 	"sort"
 	"unicode"
 
@@ -12,9 +13,26 @@ import (
 // Offset holds two 32-bit integers denoting the offsets of a matched substring
 type Offset [2]int32
 
+// ansiBgPolicy decides the background color of the current line where the
+// item has its own background color
+type ansiBgPolicy int
+
+const (
+	// The background color of the item takes precedence
+	ansiBgItem ansiBgPolicy = iota
+	// The background color of the current line (bg+) takes precedence
+	ansiBgTheme
+	// The two colors are blended
+	ansiBgBlend
+)
+
+// Ratio of bg+ in the blended background color
+const ansiBgBlendRatio = 0.5
+
 type colorOffset struct {
 	offset [2]int32
 	color  tui.ColorPair
+	url    *url
 }
 
 type Result struct {
@@ -22,7 +40,7 @@ type Result struct {
 	points [4]uint16
 }
 
-func buildResult(item *Item, offsets []Offset, score int) Result {
+func buildResult(item *Item, offsets []Offset, score int, criteria []criterion) Result {
 	if len(offsets) > 1 {
 		sort.Sort(ByOrder(offsets))
 	}
@@ -43,7 +61,7 @@ func buildResult(item *Item, offsets []Offset, score int) Result {
 		}
 	}
 
-	for idx, criterion := range sortCriteria {
+	for idx, criterion := range criteria {
 		val := uint16(math.MaxUint16)
 		switch criterion {
 		case byScore:
@@ -67,6 +85,20 @@ func buildResult(item *Item, offsets []Offset, score int) Result {
 					val = util.AsUint16(math.MaxUint16 - math.MaxUint16*(maxEnd-whitePrefixLen)/int(item.TrimLength()))
 				}
 			}
+		case byPathname:
+			if validOffsetFound {
+				// Distance from the last path separator, ignoring the trailing one
+				lastDelim := -1
+				for idx := numChars - 2; idx >= 0; idx-- {
+					if r := item.text.Get(idx); r == '/' || r == os.PathSeparator {
+						lastDelim = idx
+						break
+					}
+				}
+				if lastDelim < minBegin {
+					val = util.AsUint16(minBegin - lastDelim)
+				}
+			}
 		}
 		result.points[3-idx] = val
 	}
@@ -74,9 +106,6 @@ func buildResult(item *Item, offsets []Offset, score int) Result {
 	return result
 }
 
-// Sort criteria to use. Never changes once fzf is started.
-var sortCriteria []criterion
-
 // Index returns ordinal index of the Item
 func (result *Result) Index() int32 {
 	return result.item.Index()
@@ -86,14 +115,21 @@ func minRank() Result {
 	return Result{item: &minItem, points: [4]uint16{math.MaxUint16, 0, 0, 0}}
 }
 
-func (result *Result) colorOffsets(matchOffsets []Offset, theme *tui.ColorTheme, colBase tui.ColorPair, colMatch tui.ColorPair, current bool) []colorOffset {
+// colorOffsets returns the offsets of the colors to print the item with the
+// matches highlighted. matchColors, if not nil, is the colors of the matches
+// in place of colMatch, one for each of matchOffsets.
+func (result *Result) colorOffsets(matchOffsets []Offset, matchColors []tui.ColorPair, theme *tui.ColorTheme, colBase tui.ColorPair, colMatch tui.ColorPair, current bool, bgPolicy ansiBgPolicy) []colorOffset {
 	itemColors := result.item.Colors()
 
 	// No ANSI codes
 	if len(itemColors) == 0 {
 		var offsets []colorOffset
-		for _, off := range matchOffsets {
-			offsets = append(offsets, colorOffset{offset: [2]int32{off[0], off[1]}, color: colMatch})
+		for idx, off := range matchOffsets {
+			color := colMatch
+			if matchColors != nil {
+				color = matchColors[idx]
+			}
+			offsets = append(offsets, colorOffset{offset: [2]int32{off[0], off[1]}, color: color})
 		}
 		return offsets
 	}
@@ -118,11 +154,19 @@ func (result *Result) colorOffsets(matchOffsets []Offset, theme *tui.ColorTheme,
 		}
 	}
 
-	for _, off := range matchOffsets {
+	// The index of matchColors of the highlighted columns
+	var colorIndexes []int
+	if matchColors != nil {
+		colorIndexes = make([]int, maxCol)
+	}
+	for idx, off := range matchOffsets {
 		for i := off[0]; i < off[1]; i++ {
 			// Negative of 1-based index of itemColors
 			// - The extra -1 means highlighted
 			cols[i] = cols[i]*-1 - 1
+			if colorIndexes != nil {
+				colorIndexes[i] = idx
+			}
 		}
 	}
 
@@ -133,6 +177,7 @@ func (result *Result) colorOffsets(matchOffsets []Offset, theme *tui.ColorTheme,
 	//   ++++++++      ++++++++++
 	// --++++++++--  --++++++++++---
 	curr := 0
+	currColor := 0
 	start := 0
 	ansiToColorPair := func(ansi ansiOffset, base tui.ColorPair) tui.ColorPair {
 		fg := ansi.color.fg
@@ -150,6 +195,13 @@ func (result *Result) colorOffsets(matchOffsets []Offset, theme *tui.ColorTheme,
 			} else {
 				bg = theme.Bg.Color
 			}
+		} else if current {
+			switch bgPolicy {
+			case ansiBgTheme:
+				bg = theme.DarkBg.Color
+			case ansiBgBlend:
+				bg = bg.Blend(theme.DarkBg.Color, ansiBgBlendRatio)
+			}
 		}
 		return tui.NewColorPair(fg, bg, ansi.color.attr).MergeAttr(base)
 	}
@@ -158,6 +210,13 @@ func (result *Result) colorOffsets(matchOffsets []Offset, theme *tui.ColorTheme,
 		if curr != 0 && idx > start {
 			if curr < 0 {
 				color := colMatch
+				if matchColors != nil {
+					color = matchColors[currColor]
+				}
+				var url *url
+				if curr < -1 {
+					url = itemColors[-curr-2].color.url
+				}
 				if curr < -1 && theme.Colored {
 					origColor := ansiToColorPair(itemColors[-curr-2], colMatch)
 					// hl or hl+ only sets the foreground color, so colMatch is the
@@ -175,20 +234,26 @@ func (result *Result) colorOffsets(matchOffsets []Offset, theme *tui.ColorTheme,
 					}
 				}
 				colors = append(colors, colorOffset{
-					offset: [2]int32{int32(start), int32(idx)}, color: color})
+					offset: [2]int32{int32(start), int32(idx)}, color: color, url: url})
 			} else {
 				ansi := itemColors[curr-1]
 				colors = append(colors, colorOffset{
 					offset: [2]int32{int32(start), int32(idx)},
-					color:  ansiToColorPair(ansi, colBase)})
+					color:  ansiToColorPair(ansi, colBase),
+					url:    ansi.color.url})
 			}
 		}
 	}
 	for idx, col := range cols {
-		if col != curr {
+		colorIndex := 0
+		if colorIndexes != nil && col < 0 {
+			colorIndex = colorIndexes[idx]
+		}
+		if col != curr || colorIndex != currColor && matchColors[colorIndex] != matchColors[currColor] {
 			add(idx)
 			start = idx
 			curr = col
+			currColor = colorIndex
 		}
 	}
 	add(int(maxCol))
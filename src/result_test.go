@@ -4,6 +4,7 @@ package fzf
 
 import (
 	"math"
+	"reflect"
 	"sort"
 	"testing"
 
@@ -53,12 +54,11 @@ func TestRankComparison(t *testing.T) {
 
 // Match length, string length, index
 func TestResultRank(t *testing.T) {
-	// FIXME global
-	sortCriteria = []criterion{byScore, byLength}
+	criteria := []criterion{byScore, byLength}
 
 	strs := [][]rune{[]rune("foo"), []rune("foobar"), []rune("bar"), []rune("baz")}
 	item1 := buildResult(
-		withIndex(&Item{text: util.RunesToChars(strs[0])}, 1), []Offset{}, 2)
+		withIndex(&Item{text: util.RunesToChars(strs[0])}, 1), []Offset{}, 2, criteria)
 	if item1.points[3] != math.MaxUint16-2 || // Bonus
 		item1.points[2] != 3 || // Length
 		item1.points[1] != 0 || // Unused
@@ -67,7 +67,7 @@ func TestResultRank(t *testing.T) {
 		t.Error(item1)
 	}
 	// Only differ in index
-	item2 := buildResult(&Item{text: util.RunesToChars(strs[0])}, []Offset{}, 2)
+	item2 := buildResult(&Item{text: util.RunesToChars(strs[0])}, []Offset{}, 2, criteria)
 
 	items := []Result{item1, item2}
 	sort.Sort(ByRelevance(items))
@@ -84,13 +84,13 @@ func TestResultRank(t *testing.T) {
 
 	// Sort by relevance
 	item3 := buildResult(
-		withIndex(&Item{}, 2), []Offset{Offset{1, 3}, Offset{5, 7}}, 3)
+		withIndex(&Item{}, 2), []Offset{Offset{1, 3}, Offset{5, 7}}, 3, criteria)
 	item4 := buildResult(
-		withIndex(&Item{}, 2), []Offset{Offset{1, 2}, Offset{6, 7}}, 4)
+		withIndex(&Item{}, 2), []Offset{Offset{1, 2}, Offset{6, 7}}, 4, criteria)
 	item5 := buildResult(
-		withIndex(&Item{}, 2), []Offset{Offset{1, 3}, Offset{5, 7}}, 5)
+		withIndex(&Item{}, 2), []Offset{Offset{1, 3}, Offset{5, 7}}, 5, criteria)
 	item6 := buildResult(
-		withIndex(&Item{}, 2), []Offset{Offset{1, 2}, Offset{6, 7}}, 6)
+		withIndex(&Item{}, 2), []Offset{Offset{1, 2}, Offset{6, 7}}, 6, criteria)
 	items = []Result{item1, item2, item3, item4, item5, item6}
 	sort.Sort(ByRelevance(items))
 	if !(items[0] == item6 && items[1] == item5 &&
@@ -100,6 +100,23 @@ func TestResultRank(t *testing.T) {
 	}
 }
 
+func TestResultRankPathname(t *testing.T) {
+	criteria, _ := schemeCriteria("path", nil)
+	build := func(str string, offset Offset) Result {
+		return buildResult(&Item{text: util.RunesToChars([]rune(str))}, []Offset{offset}, 10, criteria)
+	}
+	// The trailing separator of a directory is ignored, and the ties are
+	// broken by the length
+	name := build("src/foo/bar.go", Offset{8, 11})
+	dir := build("src/bar/", Offset{4, 7})
+	parent := build("bar/foo.go", Offset{0, 3})
+	items := []Result{parent, name, dir}
+	sort.Sort(ByRelevance(items))
+	if items[0] != dir || items[1] != name || items[2] != parent {
+		t.Error(items)
+	}
+}
+
 func TestColorOffset(t *testing.T) {
 	// ------------ 20 ----  --  ----
 	//   ++++++++        ++++++++++
@@ -109,14 +126,14 @@ func TestColorOffset(t *testing.T) {
 	item := Result{
 		item: &Item{
 			colors: &[]ansiOffset{
-				{[2]int32{0, 20}, ansiState{1, 5, 0, -1}},
-				{[2]int32{22, 27}, ansiState{2, 6, tui.Bold, -1}},
-				{[2]int32{30, 32}, ansiState{3, 7, 0, -1}},
-				{[2]int32{33, 40}, ansiState{4, 8, tui.Bold, -1}}}}}
+				{[2]int32{0, 20}, ansiState{1, 5, 0, -1, nil}},
+				{[2]int32{22, 27}, ansiState{2, 6, tui.Bold, -1, nil}},
+				{[2]int32{30, 32}, ansiState{3, 7, 0, -1, nil}},
+				{[2]int32{33, 40}, ansiState{4, 8, tui.Bold, -1, nil}}}}}
 
 	colBase := tui.NewColorPair(89, 189, tui.AttrUndefined)
 	colMatch := tui.NewColorPair(99, 199, tui.AttrUndefined)
-	colors := item.colorOffsets(offsets, tui.Dark256, colBase, colMatch, true)
+	colors := item.colorOffsets(offsets, nil, tui.Dark256, colBase, colMatch, true, ansiBgItem)
 	assert := func(idx int, b int32, e int32, c tui.ColorPair) {
 		o := colors[idx]
 		if o.offset[0] != b || o.offset[1] != e || o.color != c {
@@ -140,7 +157,7 @@ func TestColorOffset(t *testing.T) {
 
 	colRegular := tui.NewColorPair(-1, -1, tui.AttrUndefined)
 	colUnderline := tui.NewColorPair(-1, -1, tui.Underline)
-	colors = item.colorOffsets(offsets, tui.Dark256, colRegular, colUnderline, true)
+	colors = item.colorOffsets(offsets, nil, tui.Dark256, colRegular, colUnderline, true, ansiBgItem)
 
 	// [{[0 5] {1 5 0}} {[5 15] {1 5 8}} {[15 20] {1 5 0}}
 	//  {[22 25] {2 6 1}} {[25 27] {2 6 9}} {[27 30] {-1 -1 8}}
@@ -157,3 +174,69 @@ func TestColorOffset(t *testing.T) {
 	assert(8, 33, 35, tui.NewColorPair(4, 8, tui.Bold|tui.Underline))
 	assert(9, 35, 40, tui.NewColorPair(4, 8, tui.Bold))
 }
+
+func TestColorOffsetTermColors(t *testing.T) {
+	colBase := tui.NewColorPair(89, 189, tui.AttrUndefined)
+	colMatch := tui.NewColorPair(99, 199, tui.AttrUndefined)
+	col1 := tui.NewColorPair(1, 199, tui.AttrUndefined)
+	col2 := tui.NewColorPair(2, 199, tui.AttrUndefined)
+	offsets := []Offset{{0, 1}, {1, 2}, {4, 5}, {5, 6}}
+	colors := []tui.ColorPair{col1, col1, col2, col1}
+	expected := []colorOffset{
+		{offset: [2]int32{0, 2}, color: col1},
+		{offset: [2]int32{4, 5}, color: col2},
+		{offset: [2]int32{5, 6}, color: col1}}
+
+	// The consecutive matches of the same term are merged only with ANSI
+	// codes, as the matches without them are printed one by one
+	plain := Result{item: &Item{}}
+	if offsets := plain.colorOffsets(offsets, colors, tui.Dark256, colBase, colMatch, false, ansiBgItem); len(offsets) != 4 ||
+		offsets[0].color != col1 || offsets[2].color != col2 || offsets[3].color != col1 {
+		t.Errorf("%v", offsets)
+	}
+
+	ansi := Result{item: &Item{colors: &[]ansiOffset{{[2]int32{6, 8}, ansiState{3, 7, 0, -1, nil}}}}}
+	result := ansi.colorOffsets(offsets, colors, tui.Dark256, colBase, colMatch, false, ansiBgItem)
+	if len(result) != 4 || !reflect.DeepEqual(result[:3], expected) || result[3].offset != [2]int32{6, 8} {
+		t.Errorf("%v", result)
+	}
+}
+
+func TestColorOffsetBgPolicy(t *testing.T) {
+	item := Result{
+		item: &Item{
+			colors: &[]ansiOffset{
+				{[2]int32{0, 5}, ansiState{1, tui.HexToColor("#ffffff"), 0, -1, nil}}}}}
+	theme := *tui.Dark256
+	theme.DarkBg = tui.ColorAttr{Color: tui.HexToColor("#000000"), Attr: tui.AttrUndefined}
+	colBase := tui.NewColorPair(-1, -1, tui.AttrUndefined)
+
+	for policy, bg := range map[ansiBgPolicy]tui.Color{
+		ansiBgItem:  tui.HexToColor("#ffffff"),
+		ansiBgTheme: tui.HexToColor("#000000"),
+		ansiBgBlend: tui.HexToColor("#808080")} {
+		colors := item.colorOffsets([]Offset{}, nil, &theme, colBase, colBase, true, policy)
+		if len(colors) != 1 || colors[0].color.Bg() != bg {
+			t.Errorf("%d: %v", policy, colors)
+		}
+		// Only the current line is affected
+		colors = item.colorOffsets([]Offset{}, nil, &theme, colBase, colBase, false, policy)
+		if len(colors) != 1 || colors[0].color.Bg() != tui.HexToColor("#ffffff") {
+			t.Errorf("%d: %v", policy, colors)
+		}
+	}
+}
+
+func TestColorOffsetURL(t *testing.T) {
+	link := &url{uri: "https://github.com/junegunn/fzf"}
+	item := Result{
+		item: &Item{
+			colors: &[]ansiOffset{
+				{[2]int32{0, 5}, ansiState{-1, -1, 0, -1, link}}}}}
+	colBase := tui.NewColorPair(-1, -1, tui.AttrUndefined)
+	colors := item.colorOffsets([]Offset{{3, 7}}, nil, tui.Dark256, colBase, colBase, false, ansiBgItem)
+	// The link should be kept on the highlighted part within the link
+	if len(colors) != 3 || colors[0].url != link || colors[1].url != link || colors[2].url != nil {
+		t.Errorf("%v", colors)
+	}
+}
@@ -0,0 +1,82 @@
+package fzf
+
+import "github.com/junegunn/fzf/src/util"
+
+// Lower eighth blocks from empty to full
+var scrollbarBlocks = []string{" ", "▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
+
+type scrollbarCell struct {
+	char    string
+	reverse bool
+}
+
+// scrollbarCells returns the cells of the scrollbar for the content of the
+// given number of lines whose first visible line is at the offset. The thumb
+// is positioned in eighths of a cell so that the position and the size are
+// still meaningful for a huge list. The part of the thumb at the top of a cell
+// is drawn with a reversed lower block as there are no upper eighth blocks.
+// If bottomUp is true, the first cell is the bottom one.
+func scrollbarCells(height int, total int, offset int, bottomUp bool, unicode bool) []scrollbarCell {
+	cells := make([]scrollbarCell, height)
+	for i := range cells {
+		cells[i].char = " "
+	}
+	if height <= 0 || total <= height {
+		return cells
+	}
+
+	maxOffset := total - height
+	offset = util.Constrain(offset, 0, maxOffset)
+	if bottomUp {
+		offset = maxOffset - offset
+	}
+	units := height * 8
+	length := units * height / total
+	if length < 8 {
+		length = 8
+	}
+	start := (units - length) * offset / maxOffset
+	end := start + length
+
+	for row := 0; row < height; row++ {
+		lo := row * 8
+		hi := lo + 8
+		covered := 0
+		if start < hi && end > lo {
+			covered = util.Min(end, hi) - util.Max(start, lo)
+		}
+		var cell scrollbarCell
+		switch {
+		case covered == 0:
+			cell.char = " "
+		case !unicode:
+			cell.char = " "
+			if covered*2 >= 8 {
+				cell.char = "|"
+			}
+		case covered == 8 || start > lo:
+			// Bottom part of the cell
+			cell.char = scrollbarBlocks[covered]
+		default:
+			// Top part of the cell
+			cell = scrollbarCell{scrollbarBlocks[8-covered], true}
+		}
+		if bottomUp {
+			cells[height-1-row] = cell
+		} else {
+			cells[row] = cell
+		}
+	}
+	return cells
+}
+
+// scrollbarTarget returns the index of the line at the position of the row of
+// the scrollbar in the list of the given number of lines. The first row is
+// the first line and the last row is the last line.
+func scrollbarTarget(row int, height int, total int) int {
+	if height <= 1 || total <= 1 {
+		return 0
+	}
+	row = util.Constrain(row, 0, height-1)
+	return row * (total - 1) / (height - 1)
+}
@@ -0,0 +1,52 @@
+package fzf
+
+import "testing"
+
+func TestScrollbarCells(t *testing.T) {
+	render := func(cells []scrollbarCell) string {
+		str := ""
+		for _, cell := range cells {
+			if cell.reverse {
+				str += "~"
+			}
+			str += cell.char
+		}
+		return str
+	}
+	for _, test := range []struct {
+		height, total, offset int
+		bottomUp, unicode     bool
+		expected              string
+	}{
+		{4, 4, 0, false, true, "    "},
+		{4, 8, 0, false, true, "██  "},
+		{4, 8, 4, false, true, "  ██"},
+		{4, 8, 4, true, true, "  ██"},
+		{4, 8, 1, false, true, "▄█~▄ "},
+		{4, 1000, 0, false, true, "█   "},
+		{4, 1000, 996, false, true, "   █"},
+		{4, 1000, 500, false, true, " ▄~▄ "},
+		{4, 1000, 500, false, false, " || "},
+		{4, 1000, 2000, false, true, "   █"},
+	} {
+		cells := scrollbarCells(test.height, test.total, test.offset, test.bottomUp, test.unicode)
+		if actual := render(cells); actual != test.expected {
+			t.Errorf("%v: %q", test, actual)
+		}
+	}
+}
+
+func TestScrollbarTarget(t *testing.T) {
+	for _, test := range [][4]int{
+		{0, 10, 100, 0},
+		{9, 10, 100, 99},
+		{3, 10, 100, 33},
+		{20, 10, 100, 99},
+		{0, 1, 100, 0},
+		{5, 10, 0, 0},
+	} {
+		if target := scrollbarTarget(test[0], test[1], test[2]); target != test[3] {
+			t.Errorf("%v: %d", test, target)
+		}
+	}
+}
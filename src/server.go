@@ -0,0 +1,208 @@
+package fzf
+
+import (
+	"crypto/subtle"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+const (
+	defaultCompleteLimit = 100
+	maxActionsLength     = 1024 * 1024
+)
+
+// completeQuery is sent to the main event loop to search the items for the
+// completion candidates
+type completeQuery struct {
+	prefix   string
+	limit    int
+	response chan []string
+}
+
+// listenServer serves HTTP requests to the running finder so that external
+// programs can make use of the items already loaded
+type listenServer struct {
+	mutex      sync.Mutex
+	eventBox   *util.EventBox
+	actionChan chan []action
+	apiKey     string
+	local      bool
+}
+
+func startListenServer(address string, eventBox *util.EventBox, actionChan chan []action) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	server := &listenServer{
+		eventBox:   eventBox,
+		actionChan: actionChan,
+		apiKey:     os.Getenv("FZF_API_KEY"),
+		local:      isLocalAddress(address)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.handleActions)
+	mux.HandleFunc("/complete", server.handleComplete)
+	mux.HandleFunc("/passthrough", server.handlePassThrough)
+	go http.Serve(listener, mux)
+	return listener, nil
+}
+
+// handleComplete responds with the top matches for the prefix, one per line.
+// The API key is required in the same way as the actions.
+//
+//	GET /complete?prefix=PREFIX[&limit=N]
+func (s *listenServer) handleComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	limit := defaultCompleteLimit
+	if str := params.Get("limit"); len(str) > 0 {
+		num, err := strconv.Atoi(str)
+		if err != nil || num <= 0 {
+			http.Error(w, "invalid limit: "+str, http.StatusBadRequest)
+			return
+		}
+		limit = num
+	}
+
+	// Requests are handled one at a time as the events of the same type
+	// overwrite each other
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	response := make(chan []string, 1)
+	s.eventBox.Set(EvtComplete, completeQuery{params.Get("prefix"), limit, response})
+
+	var candidates []string
+	select {
+	case candidates = <-response:
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, candidate := range candidates {
+		w.Write([]byte(candidate + "\n"))
+	}
+}
+
+func isLocalAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	return isLocalHost(host)
+}
+
+// isLocalHost tells if the host, optionally with the port, is the loopback
+// address or localhost
+func isLocalHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleActions runs the actions given in the body in the format of --bind
+// without the key. The API key in FZF_API_KEY is required in X-API-Key header
+// if it is set, and it should be set to run the actions from other hosts.
+//
+//	POST / (ACTIONS)
+func (s *listenServer) handleActions(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	body, ok := s.readPost(w, r)
+	if !ok {
+		return
+	}
+
+	errorMessage := ""
+	actions := parseSingleActionList(strings.Trim(string(body), "\r\n"), func(message string) {
+		if len(errorMessage) == 0 {
+			errorMessage = message
+		}
+	})
+	if len(errorMessage) == 0 && len(actions) == 0 {
+		errorMessage = "no action specified"
+	}
+	if len(errorMessage) > 0 {
+		http.Error(w, errorMessage, http.StatusBadRequest)
+		return
+	}
+	select {
+	case s.actionChan <- actions:
+	case <-r.Context().Done():
+	}
+}
+
+// readPost reads the body of the POST request after checking the API key. It
+// responds with the error and returns false if the request is not allowed.
+func (s *listenServer) readPost(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !s.authorize(w, r) {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxActionsLength))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// authorize checks the API key of the request. The key is required unless the
+// server only listens on the loopback interface. Without the key, the requests
+// from web pages, which have Origin header, or to the host names other than
+// the loopback addresses, as in DNS rebinding, are rejected so that a web page
+// cannot run the commands. It responds with the error and returns false if
+// the request is not allowed.
+func (s *listenServer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.apiKey) == 0 && !s.local {
+		http.Error(w, "FZF_API_KEY required", http.StatusForbidden)
+		return false
+	}
+	if len(s.apiKey) == 0 && (len(r.Header.Get("Origin")) > 0 || !isLocalHost(r.Host)) {
+		http.Error(w, "FZF_API_KEY required for the request", http.StatusForbidden)
+		return false
+	}
+	if len(s.apiKey) > 0 && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(s.apiKey)) != 1 {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handlePassThrough writes the body to the terminal as is from the top-left
+// corner of the region reserved by reserve-region action, so that an external
+// program can draw into the region without racing the refresh of the finder
+//
+//	POST /passthrough (DATA)
+func (s *listenServer) handlePassThrough(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readPost(w, r)
+	if !ok {
+		return
+	}
+	select {
+	case s.actionChan <- []action{{t: actDrawRegion, a: string(body)}}:
+	case <-r.Context().Done():
+	}
+}
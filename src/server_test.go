@@ -0,0 +1,162 @@
+package fzf
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestHandleComplete(t *testing.T) {
+	eventBox := util.NewEventBox()
+	server := &listenServer{eventBox: eventBox, local: true}
+	go func() {
+		for {
+			eventBox.Wait(func(events *util.Events) {
+				for _, value := range *events {
+					query := value.(completeQuery)
+					query.response <- []string{query.prefix, query.prefix + "bar"}[:query.limit]
+				}
+				events.Clear()
+			})
+		}
+	}()
+
+	apiKey := ""
+	request := func(url string) (int, string) {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:6266"+url, nil)
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		server.handleComplete(recorder, req)
+		body, _ := ioutil.ReadAll(recorder.Result().Body)
+		return recorder.Code, string(body)
+	}
+	if code, body := request("/complete?prefix=foo&limit=2"); code != http.StatusOK || body != "foo\nfoobar\n" {
+		t.Errorf("%d: %q", code, body)
+	}
+	if code, body := request("/complete?prefix=foo&limit=1"); code != http.StatusOK || body != "foo\n" {
+		t.Errorf("%d: %q", code, body)
+	}
+	if code, _ := request("/complete?limit=0"); code != http.StatusBadRequest {
+		t.Errorf("%d", code)
+	}
+
+	// The items are not served to the other hosts without the API key
+	server.local = false
+	if code, _ := request("/complete?prefix=foo"); code != http.StatusForbidden {
+		t.Errorf("%d", code)
+	}
+	server.apiKey = "secret"
+	if code, _ := request("/complete?prefix=foo"); code != http.StatusUnauthorized {
+		t.Errorf("%d", code)
+	}
+	apiKey = "secret"
+	if code, body := request("/complete?prefix=foo&limit=1"); code != http.StatusOK || body != "foo\n" {
+		t.Errorf("%d: %q", code, body)
+	}
+}
+
+func TestHandleActions(t *testing.T) {
+	actionChan := make(chan []action, 1)
+	server := &listenServer{actionChan: actionChan, local: true}
+	request := func(method string, body string, apiKey string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "http://127.0.0.1:6266/", strings.NewReader(body))
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		server.handleActions(recorder, req)
+		return recorder.Code
+	}
+	if code := request(http.MethodPost, "repeat(3,down)+toggle\n", ""); code != http.StatusOK {
+		t.Errorf("%d", code)
+	}
+	if actions := <-actionChan; len(actions) != 2 || actions[0].t != actRepeat || actions[1].t != actToggle {
+		t.Errorf("%v", actions)
+	}
+	for _, body := range []string{"", "foo", "repeat(0,down)"} {
+		if code := request(http.MethodPost, body, ""); code != http.StatusBadRequest {
+			t.Errorf("%s: %d", body, code)
+		}
+	}
+	if code := request(http.MethodGet, "", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("%d", code)
+	}
+
+	server.local = false
+	if code := request(http.MethodPost, "down", ""); code != http.StatusForbidden {
+		t.Errorf("%d", code)
+	}
+	server.apiKey = "secret"
+	if code := request(http.MethodPost, "down", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("%d", code)
+	}
+	if code := request(http.MethodPost, "down", "secret"); code != http.StatusOK || len(<-actionChan) != 1 {
+		t.Errorf("%d", code)
+	}
+	if isLocalAddress("0.0.0.0:6266") || !isLocalAddress("localhost:6266") || !isLocalAddress("[::1]:6266") {
+		t.Error("invalid local address")
+	}
+	if isLocalHost("evil.example.com:6266") || !isLocalHost("localhost") || !isLocalHost("[::1]") {
+		t.Error("invalid local host")
+	}
+}
+
+func TestHandleActionsFromWebPage(t *testing.T) {
+	actionChan := make(chan []action, 1)
+	server := &listenServer{actionChan: actionChan, local: true}
+	request := func(url string, origin string, apiKey string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader("execute(touch /tmp/pwned)"))
+		req.Header.Set("Content-Type", "text/plain")
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		server.handleActions(recorder, req)
+		return recorder.Code
+	}
+	// Simple request of CORS from a web page
+	if code := request("http://127.0.0.1:6266/", "https://evil.example.com", ""); code != http.StatusForbidden {
+		t.Errorf("%d", code)
+	}
+	// DNS rebinding
+	if code := request("http://evil.example.com:6266/", "", ""); code != http.StatusForbidden {
+		t.Errorf("%d", code)
+	}
+	// Allowed with the API key
+	server.apiKey = "secret"
+	if code := request("http://evil.example.com:6266/", "https://evil.example.com", "secret"); code != http.StatusOK || len(<-actionChan) != 1 {
+		t.Errorf("%d", code)
+	}
+}
+
+func TestHandlePassThrough(t *testing.T) {
+	actionChan := make(chan []action, 1)
+	server := &listenServer{actionChan: actionChan, local: true}
+	request := func(method string, body string) int {
+		recorder := httptest.NewRecorder()
+		server.handlePassThrough(recorder, httptest.NewRequest(method, "http://localhost:6266/passthrough", strings.NewReader(body)))
+		return recorder.Code
+	}
+	if code := request(http.MethodPost, "\x1b_Gfoo\x1b\\"); code != http.StatusOK {
+		t.Errorf("%d", code)
+	}
+	if actions := <-actionChan; len(actions) != 1 || actions[0].t != actDrawRegion || actions[0].a != "\x1b_Gfoo\x1b\\" {
+		t.Errorf("%v", actions)
+	}
+	if code := request(http.MethodGet, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("%d", code)
+	}
+	server.local = false
+	if code := request(http.MethodPost, "foo"); code != http.StatusForbidden {
+		t.Errorf("%d", code)
+	}
+}
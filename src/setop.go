@@ -0,0 +1,123 @@
+package fzf
+
+import (
+	"strings"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// setOperation combines the match set of the previous queries with the one
+// of the next query
+type setOperation int
+
+const (
+	setIntersect setOperation = iota
+	setUnion
+	setSubtract
+)
+
+var setOperators = map[setOperation]string{
+	setIntersect: "&",
+	setUnion:     "|",
+	setSubtract:  "-",
+}
+
+// querySet is the query whose match set is combined with the match set of the
+// next query by the operation
+type querySet struct {
+	query string
+	op    setOperation
+}
+
+// describeQuerySets returns the string describing the pending operations for
+// the info line, e.g. "foo & bar -"
+func describeQuerySets(sets []querySet) string {
+	parts := []string{}
+	for _, set := range sets {
+		parts = append(parts, set.query, setOperators[set.op])
+	}
+	return strings.Join(parts, " ")
+}
+
+func sameQuerySets(a []querySet, b []querySet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// combinePatterns returns the pattern matching the items of the match set of
+// the queries combined by the operations. The pattern of the current query is
+// returned as is when there is no operation.
+func combinePatterns(sets []querySet, build func([]rune) *Pattern, current *Pattern) *Pattern {
+	if len(sets) == 0 {
+		return current
+	}
+	base := build([]rune(sets[0].query))
+	for idx := 1; idx < len(sets); idx++ {
+		base = base.combine(sets[idx-1].op, build([]rune(sets[idx].query)))
+	}
+	return base.combine(sets[len(sets)-1].op, current)
+}
+
+// combine returns the copy of the pattern of the next query that matches the
+// match set of the receiver combined with its own. An empty query leaves the
+// match set of the receiver unchanged.
+func (p *Pattern) combine(op setOperation, next *Pattern) *Pattern {
+	combined := *next
+	combined.base = p
+	combined.setOp = op
+	combined.sortable = next.sortable || p.sortable
+	if op == setSubtract {
+		// The matches of a longer query are not a subset of the ones of the
+		// shorter query
+		combined.cacheable = false
+		combined.cacheKey = ""
+	}
+	if op != setIntersect || next.IsEmpty() {
+		combined.mask = 0
+		combined.trigrams = nil
+	}
+	return &combined
+}
+
+// combinedMatch matches the item against the query of the pattern and the
+// previous queries. The offsets of the matches of the both queries are
+// returned for the intersection.
+func (p *Pattern) combinedMatch(item *Item, withPos bool, slab *util.Slab) (*Result, []Offset, *[]int) {
+	if p.queryEmpty() {
+		return p.base.MatchItem(item, withPos, slab)
+	}
+	switch p.setOp {
+	case setIntersect:
+		baseResult, baseOffsets, basePos := p.base.MatchItem(item, withPos, slab)
+		if baseResult == nil {
+			return nil, nil, nil
+		}
+		result, offsets, pos := p.matchQuery(item, withPos, slab)
+		if result == nil {
+			return nil, nil, nil
+		}
+		if pos != nil && basePos != nil {
+			merged := make([]int, 0, len(*pos)+len(*basePos))
+			merged = append(append(merged, *pos...), *basePos...)
+			pos = &merged
+		}
+		return result, append(offsets, baseOffsets...), pos
+	case setUnion:
+		if result, offsets, pos := p.matchQuery(item, withPos, slab); result != nil {
+			return result, offsets, pos
+		}
+		return p.base.MatchItem(item, withPos, slab)
+	default:
+		if result, _, _ := p.matchQuery(item, false, slab); result != nil {
+			return nil, nil, nil
+		}
+		return p.base.MatchItem(item, withPos, slab)
+	}
+}
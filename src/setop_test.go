@@ -0,0 +1,58 @@
+package fzf
+
+import (
+	"testing"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestCombinePatterns(t *testing.T) {
+	build := func(runes []rune) *Pattern {
+		return BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, runes)
+	}
+	items := []string{"foo", "bar", "foobar", "baz"}
+	test := func(sets []querySet, query string, expected ...string) {
+		pattern := combinePatterns(sets, build, build([]rune(query)))
+		matches := []string{}
+		for _, str := range items {
+			if result, _, _ := pattern.MatchItem(&Item{text: util.RunesToChars([]rune(str))}, true, nil); result != nil {
+				matches = append(matches, str)
+			}
+		}
+		if len(matches) != len(expected) {
+			t.Errorf("%v %q: expected %v, got %v", sets, query, expected, matches)
+			return
+		}
+		for idx := range matches {
+			if matches[idx] != expected[idx] {
+				t.Errorf("%v %q: expected %v, got %v", sets, query, expected, matches)
+				return
+			}
+		}
+	}
+	test(nil, "'foo", "foo", "foobar")
+	test([]querySet{{"'foo", setIntersect}}, "'bar", "foobar")
+	test([]querySet{{"'foo", setUnion}}, "'baz", "foo", "foobar", "baz")
+	test([]querySet{{"'foo", setSubtract}}, "'bar", "foo")
+	test([]querySet{{"'foo", setUnion}, {"'baz", setSubtract}}, "'bar", "foo", "baz")
+
+	// The match set of the previous queries is left unchanged by an empty query
+	for _, op := range []setOperation{setIntersect, setUnion, setSubtract} {
+		test([]querySet{{"'foo", op}}, "", "foo", "foobar")
+	}
+	if pattern := combinePatterns([]querySet{{"'foo", setIntersect}}, build, build(nil)); pattern.IsEmpty() {
+		t.Error("Combined pattern should not be empty")
+	}
+
+	// The results of the subtraction are not cached
+	if pattern := combinePatterns([]querySet{{"'foo", setSubtract}}, build, build([]rune("'bar"))); pattern.cacheable || len(pattern.CacheKey()) > 0 {
+		t.Error("Subtraction should not be cached")
+	}
+}
+
+func TestDescribeQuerySets(t *testing.T) {
+	if str := describeQuerySets([]querySet{{"foo", setIntersect}, {"bar", setSubtract}}); str != "foo & bar -" {
+		t.Error(str)
+	}
+}
@@ -0,0 +1,130 @@
+package fzf
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// Environment variables for handing over the state of the finder to the
+// process started by become-with-state action
+const (
+	stateQuery      = "FZF_STATE_QUERY"
+	statePos        = "FZF_STATE_POS"
+	stateOffset     = "FZF_STATE_OFFSET"
+	stateSelections = "FZF_STATE_SELECTIONS"
+)
+
+// finderState is the state of the previous finder to be restored
+type finderState struct {
+	query      string
+	pos        int
+	offset     int
+	selections map[string]bool
+	current    string
+	row        int
+}
+
+// stateEnv returns the environment variables describing the current state of
+// the finder
+func (t *Terminal) stateEnv() []string {
+	selections := []string{}
+	for _, sel := range t.sortSelected() {
+		selections = append(selections, t.itemKey(sel.item))
+	}
+	return []string{
+		stateQuery + "=" + string(t.input),
+		statePos + "=" + strconv.Itoa(t.cy+1),
+		stateOffset + "=" + strconv.Itoa(t.offset),
+		stateSelections + "=" + strings.Join(selections, "\n")}
+}
+
+// loadState reads the state left by the previous finder. The variables are
+// removed so that they are not inherited by the child processes.
+func loadState() *finderState {
+	query, found := os.LookupEnv(stateQuery)
+	if !found {
+		return nil
+	}
+	state := finderState{query: query, selections: make(map[string]bool)}
+	state.pos, _ = strconv.Atoi(os.Getenv(statePos))
+	state.offset, _ = strconv.Atoi(os.Getenv(stateOffset))
+	if selections := os.Getenv(stateSelections); len(selections) > 0 {
+		for _, selection := range strings.Split(selections, "\n") {
+			state.selections[selection] = true
+		}
+	}
+	for _, name := range []string{stateQuery, statePos, stateOffset, stateSelections} {
+		os.Unsetenv(name)
+	}
+	return &state
+}
+
+// restoreState moves the cursor and selects the items as in the previous
+// finder once the whole list is available
+func (t *Terminal) restoreState(merger *Merger) {
+	state := t.state
+	t.state = nil
+	if t.multi > 0 && len(state.selections) > 0 {
+		for i := 0; i < merger.Length(); i++ {
+			item := merger.Get(i).item
+			if state.selections[t.itemKey(item)] {
+				t.selectItem(item)
+			}
+		}
+	}
+	if len(state.current) > 0 {
+		for i := 0; i < merger.Length(); i++ {
+			if t.itemKey(merger.Get(i).item) == state.current {
+				t.cy = i
+				t.offset = util.Max(0, i-state.row)
+				break
+			}
+		}
+	} else if state.pos > 0 {
+		t.cy = state.pos - 1
+		t.offset = state.offset
+	}
+	// The list can be shorter than the one of the previous finder, and the
+	// window is not created yet if the list is loaded before the first render
+	if t.window != nil {
+		t.constrain()
+	} else {
+		t.cy = util.Constrain(t.cy, 0, merger.Length()-1)
+	}
+}
+
+// itemKey returns the text identifying the item. It is the fields of
+// --key-field if specified, or the whole line otherwise.
+func (t *Terminal) itemKey(item *Item) string {
+	return keyOf(item, t.keyField, t.delimiter, t.ansi)
+}
+
+// keyOf returns the text of the fields of the item, or the whole line if no
+// field is given
+func keyOf(item *Item, fields []Range, delimiter Delimiter, stripAnsi bool) string {
+	if len(fields) == 0 {
+		return item.AsString(stripAnsi)
+	}
+	tokens := Tokenize(item.AsString(true), delimiter)
+	_, key := SplitFields(tokens, fields, delimiter)
+	return key
+}
+
+// trackKeys remembers the keys of the selected items and the current item
+// before the list is reloaded, so that they are restored on the new items
+// with the same keys once the whole list is available
+func (t *Terminal) trackKeys() {
+	if t.state == nil {
+		t.state = &finderState{selections: make(map[string]bool)}
+		if current := t.currentItem(); current != nil {
+			t.state.current = t.itemKey(current)
+			t.state.row = t.cy - t.offset
+		}
+	}
+	for _, sel := range t.selected {
+		t.state.selections[t.itemKey(sel.item)] = true
+	}
+}
@@ -0,0 +1,132 @@
+package fzf
+
+import (
+	"os"
+	"testing"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestLoadState(t *testing.T) {
+	if state := loadState(); state != nil {
+		t.Errorf("Unexpected state: %v", state)
+	}
+
+	os.Setenv(stateQuery, "foo")
+	os.Setenv(statePos, "3")
+	os.Setenv(stateOffset, "1")
+	os.Setenv(stateSelections, "foobar\nfoobaz")
+	state := loadState()
+	if state == nil || state.query != "foo" || state.pos != 3 || state.offset != 1 ||
+		len(state.selections) != 2 || !state.selections["foobaz"] {
+		t.Errorf("Unexpected state: %v", state)
+	}
+	if _, found := os.LookupEnv(stateSelections); found {
+		t.Errorf("Environment variables should be removed")
+	}
+}
+
+func TestTrackKeys(t *testing.T) {
+	merger := func(lines ...string) *Merger {
+		list := []Result{}
+		for i, line := range lines {
+			chars := util.ToChars([]byte(line))
+			chars.Index = int32(i)
+			list = append(list, Result{item: &Item{text: chars}})
+		}
+		return NewMerger(nil, [][]Result{list}, false, false)
+	}
+	term := Terminal{
+		merger:    merger("a 1", "b 2", "c 3", "d 4"),
+		multi:     maxMulti,
+		keyField:  splitNth("1"),
+		delimiter: Delimiter{},
+		disabled:  newDisabledSet(),
+		selected:  make(map[int32]selectedItem)}
+	term.selectItem(term.merger.Get(1).item)
+	term.cy = 2
+	term.offset = 1
+	term.trackKeys()
+	if term.state.current != "c" || term.state.row != 1 || !term.state.selections["b"] {
+		t.Errorf("Unexpected state: %v", term.state)
+	}
+
+	// The items have moved and their texts have changed
+	term.selected = make(map[int32]selectedItem)
+	term.merger = merger("x 0", "c 30", "a 10", "b 20")
+	term.restoreState(term.merger)
+	if term.cy != 1 || term.offset != 0 || term.state != nil {
+		t.Errorf("Unexpected cursor: %d, %d", term.cy, term.offset)
+	}
+	if len(term.selected) != 1 {
+		t.Errorf("Unexpected selection: %v", term.selected)
+	}
+	if _, found := term.selected[3]; !found {
+		t.Errorf("Unexpected selection: %v", term.selected)
+	}
+
+	// The position is beyond the end of the new list
+	term.state = &finderState{pos: 10, selections: make(map[string]bool)}
+	term.restoreState(term.merger)
+	if term.cy != 3 {
+		t.Errorf("Unexpected cursor: %d", term.cy)
+	}
+}
+
+func TestSwitchTab(t *testing.T) {
+	merger := func(lines ...string) *Merger {
+		list := []Result{}
+		for i, line := range lines {
+			chars := util.ToChars([]byte(line))
+			chars.Index = int32(i)
+			list = append(list, Result{item: &Item{text: chars}})
+		}
+		merger := NewMerger(nil, [][]Result{list}, false, false)
+		merger.final = true
+		return merger
+	}
+	term := Terminal{
+		reqBox:   util.NewEventBox(),
+		merger:   merger("a", "b", "c"),
+		multi:    maxMulti,
+		input:    []rune("foo"),
+		tabs:     []finderTab{{name: "x", command: "ls"}, {name: "y", command: "ps"}},
+		disabled: newDisabledSet(),
+		selected: make(map[int32]selectedItem)}
+	term.selectItem(term.merger.Get(0).item)
+	term.cy = 2
+
+	// Switch to the second tab
+	if command := term.switchTab(-1); command == nil || *command != "ps" || term.tab != 1 {
+		t.Errorf("Unexpected tab: %d", term.tab)
+	}
+	if len(term.input) != 0 || term.cy != 0 {
+		t.Errorf("Unexpected query: %q", string(term.input))
+	}
+	term.UpdateList(merger("d", "e"), true)
+	if len(term.selected) != 0 || term.state != nil {
+		t.Errorf("Unexpected selection: %v", term.selected)
+	}
+	term.input = []rune("bar")
+
+	// And back to the first one
+	if command := term.switchTab(2); command == nil || *command != "ls" || term.tab != 0 {
+		t.Errorf("Unexpected tab: %d", term.tab)
+	}
+	if string(term.input) != "foo" {
+		t.Errorf("Unexpected query: %q", string(term.input))
+	}
+	term.UpdateList(merger("c", "b", "a"), true)
+	if term.cy != 0 || len(term.selected) != 1 {
+		t.Errorf("Unexpected state: %d, %v", term.cy, term.selected)
+	}
+	if _, found := term.selected[2]; !found {
+		t.Errorf("Unexpected selection: %v", term.selected)
+	}
+	if term.tabs[1].state == nil || term.tabs[1].state.query != "bar" {
+		t.Errorf("Unexpected state: %v", term.tabs[1].state)
+	}
+	if command := term.switchTab(0); command != nil {
+		t.Errorf("Unexpected command: %s", *command)
+	}
+}
@@ -0,0 +1,106 @@
+package fzf
+
+import (
+	"strings"
+
+	"github.com/junegunn/fzf/src/tui"
+)
+
+// finderTab is a named list of items read from the command. The query, the
+// selections, and the cursor of the inactive tabs are kept so that they are
+// restored when switched back.
+type finderTab struct {
+	name    string
+	command string
+	state   *finderState
+}
+
+// parseTab parses NAME:COMMAND
+func parseTab(str string) finderTab {
+	tokens := strings.SplitN(str, ":", 2)
+	if len(tokens) < 2 || len(tokens[0]) == 0 || len(strings.TrimSpace(tokens[1])) == 0 {
+		errorExit("invalid tab (expected: NAME:COMMAND): " + str)
+	}
+	return finderTab{name: tokens[0], command: tokens[1]}
+}
+
+// tabLines returns the number of lines of the tab bar, which is only
+// displayed when there are multiple tabs
+func (t *Terminal) tabLines() int {
+	if len(t.tabs) > 1 {
+		return 1
+	}
+	return 0
+}
+
+// switchTab saves the state of the current tab and switches to the tab at the
+// index, which wraps around. It returns the command to reload the list with,
+// or nil if the tab is not changed. The state of the tab is restored once the
+// new list is available.
+func (t *Terminal) switchTab(idx int) *string {
+	n := len(t.tabs)
+	if n < 2 {
+		return nil
+	}
+	idx = (idx%n + n) % n
+	if idx == t.tab {
+		return nil
+	}
+
+	state := &finderState{query: string(t.input), selections: make(map[string]bool)}
+	for _, sel := range t.selected {
+		state.selections[t.itemKey(sel.item)] = true
+	}
+	if current := t.currentItem(); current != nil {
+		state.current = t.itemKey(current)
+		state.row = t.cy - t.offset
+	}
+	t.tabs[t.tab].state = state
+	t.selected = make(map[int32]selectedItem)
+
+	t.tab = idx
+	next := t.tabs[idx].state
+	t.tabs[idx].state = nil
+	if next == nil {
+		next = &finderState{selections: make(map[string]bool)}
+	}
+	t.input = []rune(next.query)
+	t.cx = len(t.input)
+	t.cy = 0
+	t.offset = 0
+	t.tabState = next
+	command := t.tabs[idx].command
+	return &command
+}
+
+// printTabBar prints the names of the tabs below the header, highlighting the
+// current one
+func (t *Terminal) printTabBar() {
+	if t.tabLines() == 0 {
+		return
+	}
+	line := t.headerLines() + 1
+	if t.noInfoLine() {
+		line--
+	}
+	if line >= t.window.Height() {
+		return
+	}
+	t.move(line, 2, true)
+	width := t.window.Width() - 2
+	for idx, tab := range t.tabs {
+		if width <= 0 {
+			break
+		}
+		label, _ := t.trimRight([]rune(" "+tab.name+" "), width)
+		color := tui.ColHeader
+		if idx == t.tab {
+			color = tui.ColCurrent
+		}
+		t.window.CPrint(color, string(label))
+		width -= t.displayWidth(label) + 1
+		if width > 0 {
+			t.window.Print(" ")
+		}
+	}
+}
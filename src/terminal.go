@@ -3,9 +3,13 @@ package fzf
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"os/exec"
 	"os/signal"
 	"regexp"
 	"sort"
@@ -14,7 +18,9 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"github.com/junegunn/fzf/src/algo"
 	"github.com/junegunn/fzf/src/tui"
 	"github.com/junegunn/fzf/src/util"
 )
@@ -30,7 +36,7 @@ const ellipsis string = ".."
 const clearCode string = "\x1b[2J"
 
 func init() {
-	placeholder = regexp.MustCompile(`\\?(?:{[+sf]*[0-9,-.]*}|{q}|{\+?f?nf?})`)
+	placeholder = regexp.MustCompile(`\\?(?:{[+sf]*[0-9,-.]*}|{q}|{\+?f?nf?}|{form:[^{}]+})`)
 	numericPrefix = regexp.MustCompile(`^[[:punct:]]*([0-9]+)`)
 	whiteSuffix = regexp.MustCompile(`\s*$`)
 	activeTempFiles = []string{}
@@ -48,17 +54,25 @@ type previewer struct {
 	version    int64
 	lines      []string
 	offset     int
+	xoffset    int // Columns scrolled horizontally
+	width      int // Width of the widest line displayed
 	enabled    bool
 	scrollable bool
-	final      bool
+	final      bool // Preview of the last request is complete
+	requested  int64
 	following  bool
 	spinner    string
+	output     []string
+	debug      []string
+	debugging  bool
+	emitted    int // Number of the lines whose OSC sequences are emitted
 }
 
 type previewed struct {
 	version  int64
 	numLines int
 	offset   int
+	xoffset  int
 	filled   bool
 }
 
@@ -71,8 +85,11 @@ type itemLine struct {
 	current  bool
 	selected bool
 	label    string
+	bar      string
 	queryLen int
+	hoffset  int
 	width    int
+	rows     int
 	result   Result
 }
 
@@ -82,6 +99,8 @@ var emptyLine = itemLine{}
 type Terminal struct {
 	initDelay    time.Duration
 	infoStyle    infoStyle
+	infoToggle   infoStyle
+	separator    bool
 	spinner      []string
 	prompt       func()
 	promptLen    int
@@ -94,9 +113,16 @@ type Terminal struct {
 	queryLen     [2]int
 	layout       layoutType
 	fullscreen   bool
+	fitHeight    func(int) func(int) int
+	fitLines     int
 	keepRight    bool
 	hscroll      bool
 	hscrollOff   int
+	wrap         bool
+	dryRun       bool
+	wrapSign     string
+	wrapSignLen  int
+	hoffset      int // Columns of the overflowing items scrolled by the user
 	wordRubout   string
 	wordNext     string
 	cx           int
@@ -104,45 +130,130 @@ type Terminal struct {
 	offset       int
 	xoffset      int
 	yanked       []rune
+	registers    registerSet
+	picker       *registerPicker
+	menu         []menuEntry
+	menuOpen     *contextMenu
 	input        []rune
 	multi        int
 	sort         bool
 	toggleSort   bool
 	delimiter    Delimiter
+	nth          []Range
+	nthExpr      string
+	nthOrig      []Range
+	delimOrig    Delimiter
+	fieldsRev    int
+	scheme       string
+	schemeOrig   string
+	showScheme   bool
+	caseMode     Case
+	caseOrig     Case
+	regex        bool
+	querySets    []querySet
+	formats      *fieldFormats
+	keyField     []Range
 	expect       map[tui.Event]string
 	keymap       map[tui.Event][]action
 	pressed      string
 	printQuery   bool
+	onAccept     string
+	onExit       string
 	history      *History
+	filters      map[string]string
+	state        *finderState
+	rankLog      *RankLog
 	cycle        bool
 	header       []string
 	header0      []string
+	headerCut    int
+	form         []formField
+	tabs         []finderTab
+	tab          int
+	tabState     *finderState
+	hints        []bindingHint
+	keyNames     map[tui.Event]string
+	keySpecs     map[tui.Event]string
+	bindings     []bindingHint
+	bindingsAt   int
+	toast        *toast
+	logFile      string
+	formFocus    int
+	formReload   string // Command of the last reload with the fields
+	title        []titleSegment
+	titleButtons []titleButton
 	ansi         bool
+	ansiBg       ansiBgPolicy
+	matchStyle   matchStyle
 	tabstop      int
 	margin       [4]sizeSpec
 	padding      [4]sizeSpec
 	strong       tui.Attr
 	unicode      bool
+	unicodeAuto  bool
+	glyphs       map[tui.BorderLine]bool
 	borderShape  tui.BorderShape
+	borderSides  tui.BorderSides
+	borderChars  []rune
+	borderLabel  []labelOpts
+	shadow       bool
+	scrollbar    bool
+	sourceURL    string
+	bgCommand    string
+	bgSize       [2]int
+	bgCmd        *exec.Cmd
+	paste        pasteMode
+	pasteFilter  string
+	chord        *keyChord
+	chordTime    time.Time
+	chordIntvl   time.Duration
+	numArg       int
+	popup        tui.Window
+	popupBorder  tui.Window
+	popupLabel   string
+	macro        keyMacro
+	region       string
 	cleanExit    bool
 	paused       bool
 	border       tui.Window
+	twindow      tui.Window
 	window       tui.Window
 	pborder      tui.Window
 	pwindow      tui.Window
 	count        int
 	progress     int
 	reading      bool
+	statusCmd    *exec.Cmd // Command of execute-status shown on the info line
+	statusLine   string
+	statusDone   bool
+	resuming     bool
+	sources      sourceProgress
 	failed       *string
 	jumping      jumpMode
 	jumpLabels   string
+	acceptAll    bool
+	confirmAll   int
+	confirming   int
+	dragFrom     int
+	dragTo       int
+	bellStyle    tui.BellStyle
+	bellsOn      map[tui.BellStyle]bool // Visual bells to be turned off
+	bellSeq      int
 	printer      func(string)
 	printsep     string
+	renderOnce   renderFormat
 	merger       *Merger
 	selected     map[int32]selectedItem
+	disabled     *disabledSet
+	bars         *metricBar
 	version      int64
 	reqBox       *util.EventBox
+	pacer        *renderPacer
 	previewOpts  previewOpts
+	previewBase  previewOpts
+	previewRule  int
+	fallbacks    []string
+	previewOSCs  map[string]bool
 	previewer    previewer
 	previewed    previewed
 	previewBox   *util.EventBox
@@ -154,6 +265,9 @@ type Terminal struct {
 	sigstop      bool
 	startChan    chan bool
 	killChan     chan int
+	killedChan   chan bool
+	done         chan bool // Closed when the finder exits
+	serverInput  chan []action
 	slab         *util.Slab
 	theme        *tui.ColorTheme
 	tui          tui.Renderer
@@ -193,12 +307,24 @@ const (
 	reqPreviewDisplay
 	reqPreviewRefresh
 	reqPreviewDelayed
+	reqPreviewDone
+	reqHistory
+	reqStatus
+	reqStatusClear
+	reqBell
+	reqBellOff
+	reqFlash
+	reqDrawRegion
+	reqBackground
 	reqQuit
 )
 
 type action struct {
 	t actionType
 	a string
+	n int       // Number of repetitions of repeat
+	c []action  // Actions to repeat
+	k *keyChord // Keys following the chord
 }
 
 type actionType int
@@ -212,12 +338,16 @@ const (
 	actAbort
 	actAccept
 	actAcceptNonEmpty
+	actAcceptAll
 	actBackwardChar
 	actBackwardDeleteChar
 	actBackwardDeleteCharEOF
 	actBackwardWord
 	actCancel
 	actChangePrompt
+	actChangeNth
+	actChangeDelimiter
+	actChangeScheme
 	actClearScreen
 	actClearQuery
 	actClearSelection
@@ -232,6 +362,8 @@ const (
 	actUnixLineDiscard
 	actUnixWordRubout
 	actYank
+	actYankTo
+	actPutFrom
 	actBackwardKillWord
 	actSelectAll
 	actDeselectAll
@@ -254,8 +386,18 @@ const (
 	actRefreshPreview
 	actReplaceQuery
 	actToggleSort
+	actToggleSmartCase
+	actToggleRegex
+	actTransform
+	actIntersectWithQuery
+	actUnionWithQuery
+	actSubtractQuery
+	actFlash
 	actTogglePreview
 	actTogglePreviewWrap
+	actToggleWrap
+	actToggleDryRun
+	actTogglePreviewDebug
 	actPreview
 	actPreviewTop
 	actPreviewBottom
@@ -270,6 +412,11 @@ const (
 	actExecute
 	actExecuteSilent
 	actExecuteMulti // Deprecated
+	actExecuteStatus
+	actCancelStatus
+	actRecordMacro
+	actStopMacro
+	actReplayMacro
 	actSigStop
 	actFirst
 	actLast
@@ -278,6 +425,29 @@ const (
 	actEnableSearch
 	actSelect
 	actDeselect
+	actBecome
+	actBecomeWithState
+	actNextField
+	actPreviousField
+	actNextTab
+	actPrevTab
+	actReloadURL
+	actToggleInfo
+	actToggleSeparator
+	actGrowHeader
+	actShrinkHeader
+	actSaveFilter
+	actApplyFilter
+	actReserveRegion
+	actReleaseRegion
+	actDrawRegion
+	actPaste
+	actChord
+	actDescription
+	actRepeat
+	actShowBindings
+	actDigitArgument
+	actContextMenu
 )
 
 type placeholderFlags struct {
@@ -289,14 +459,31 @@ type placeholderFlags struct {
 }
 
 type searchRequest struct {
-	sort    bool
-	command *string
+	sort      bool
+	command   *string
+	nth       []Range
+	delimiter Delimiter
+	revision  int // Revision of the fields to match
+	scheme    string
+	caseMode  Case
+	regex     bool
+	querySets []querySet
+	restore   bool // Whether the state is restored on the list of the command
 }
 
 type previewRequest struct {
 	template string
 	pwindow  tui.Window
 	list     []*Item
+	form     map[string]string
+	seq      int64
+}
+
+// statusUpdate is the last line of the output of execute-status command
+type statusUpdate struct {
+	cmd  *exec.Cmd
+	line string
+	done bool
 }
 
 type previewResult struct {
@@ -304,6 +491,7 @@ type previewResult struct {
 	lines   []string
 	offset  int
 	spinner string
+	debug   []string
 }
 
 func toActions(types ...actionType) []action {
@@ -357,6 +545,9 @@ func defaultKeymap() map[tui.Event][]action {
 	add(tui.SRight, actForwardWord)
 	addEvent(tui.AltKey('d'), actKillWord)
 	add(tui.AltBS, actBackwardKillWord)
+	for digit := '0'; digit <= '9'; digit++ {
+		addEvent(tui.AltKey(digit), actDigitArgument)
+	}
 
 	add(tui.Up, actUp)
 	add(tui.Down, actDown)
@@ -376,6 +567,8 @@ func defaultKeymap() map[tui.Event][]action {
 	add(tui.DoubleClick, actAccept)
 	add(tui.LeftClick, actIgnore)
 	add(tui.RightClick, actToggle)
+	add(tui.ScrollLeft, actMouse)
+	add(tui.ScrollRight, actMouse)
 	return keymap
 }
 
@@ -383,12 +576,51 @@ func trimQuery(query string) []rune {
 	return []rune(strings.Replace(query, "\t", " ", -1))
 }
 
+// pasteQuery returns the pasted text to insert into the query. The line breaks
+// are handled in the mode and the other control characters are removed.
+func pasteQuery(text string, mode pasteMode) []rune {
+	text = strings.TrimRight(strings.Replace(text, "\r\n", "\n", -1), "\r\n")
+	switch mode {
+	case pasteSpace:
+		text = strings.NewReplacer("\n", " ", "\r", " ").Replace(text)
+	case pasteStrip:
+		text = strings.NewReplacer("\n", "", "\r", "").Replace(text)
+	case pasteFirstLine:
+		if idx := strings.IndexAny(text, "\r\n"); idx >= 0 {
+			text = text[:idx]
+		}
+	}
+	runes := make([]rune, 0, len(text))
+	for _, r := range trimQuery(text) {
+		if r >= 32 && r != 127 && r != utf8.RuneError {
+			runes = append(runes, r)
+		}
+	}
+	return runes
+}
+
+// hasAction returns true if the actions contain the type of action, including
+// the ones to repeat
+func hasAction(actions []action, t actionType) bool {
+	for _, action := range actions {
+		if action.t == t || hasAction(action.c, t) {
+			return true
+		}
+		if action.k != nil {
+			for _, next := range action.k.keymap {
+				if hasAction(next, t) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func hasPreviewAction(opts *Options) bool {
 	for _, actions := range opts.Keymap {
-		for _, action := range actions {
-			if action.t == actPreview {
-				return true
-			}
+		if hasAction(actions, actPreview) {
+			return true
 		}
 	}
 	return false
@@ -401,8 +633,33 @@ func makeSpinner(unicode bool) []string {
 	return []string{`-`, `\`, `|`, `/`, `-`, `\`, `|`, `/`}
 }
 
+// renderOnceSize returns the size of the screen for --render-once taken from
+// $COLUMNS and $LINES, or 80x24 by default. --height limits the height.
+func renderOnceSize(opts *Options) (int, int) {
+	size := func(name string, defaultValue int) int {
+		if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+			return value
+		}
+		return defaultValue
+	}
+	width, height := size("COLUMNS", 80), size("LINES", 24)
+	if opts.Height.size > 0 {
+		if opts.Height.percent {
+			height = util.Min(height, util.Max(int(opts.Height.size*float64(height)/100.0), opts.MinHeight))
+		} else {
+			height = util.Min(height, int(opts.Height.size))
+		}
+	}
+	return width, height
+}
+
 // NewTerminal returns new Terminal object
-func NewTerminal(opts *Options, eventBox *util.EventBox) *Terminal {
+func NewTerminal(opts *Options, eventBox *util.EventBox, disabled *disabledSet, bars *metricBar) *Terminal {
+	// The named filters are shared with the history to be saved along with it
+	filters := make(map[string]string)
+	if opts.History != nil {
+		filters = opts.History.filters
+	}
 	input := trimQuery(opts.Query)
 	var header []string
 	switch opts.Layout {
@@ -426,39 +683,71 @@ func NewTerminal(opts *Options, eventBox *util.EventBox) *Terminal {
 		strongAttr = tui.AttrRegular
 	}
 	var renderer tui.Renderer
-	fullscreen := opts.Height.size == 0 || opts.Height.percent && opts.Height.size == 100
-	if fullscreen {
-		if tui.HasFullscreenRenderer() {
-			renderer = tui.NewFullscreenRenderer(opts.Theme, opts.Black, opts.Mouse)
+	var fitHeight func(int) func(int) int
+	fullscreen := !opts.Height.auto && (opts.Height.size == 0 || opts.Height.percent && opts.Height.size == 100)
+	if opts.Renderer != nil {
+		// The renderer given by the program embedding fzf
+		renderer = opts.Renderer
+		if virtual, ok := renderer.(*tui.VirtualRenderer); ok {
+			virtual.SetTheme(opts.Theme, opts.Black)
+		}
+	} else if opts.RenderOnce != renderNone {
+		// The screen is printed to the standard output instead of the terminal
+		renderer = tui.NewVirtualRenderer(renderOnceSize(opts))
+		renderer.(*tui.VirtualRenderer).SetTheme(opts.Theme, opts.Black)
+	} else if fullscreen {
+		// The light renderer is used on the full screen as well unless the
+		// console does not support virtual terminal sequences
+		if opts.Tui == tuiTcell || opts.Tui == tuiAuto && !tui.IsLightRendererSupported() {
+			renderer = tui.NewFullscreenRenderer(opts.Theme, opts.Black, opts.Mouse, opts.Hover, opts.ClickIntvl)
 		} else {
-			renderer = tui.NewLightRenderer(opts.Theme, opts.Black, opts.Mouse, opts.Tabstop, opts.ClearOnExit,
+			renderer = tui.NewLightRenderer(opts.Theme, opts.Black, opts.Mouse, opts.Hover, opts.ClickIntvl, opts.Tabstop, opts.ClearOnExit,
 				true, func(h int) int { return h })
 		}
 	} else {
+		effectiveMinHeight := minHeight
+		if previewBox != nil && (opts.Preview.position == posUp || opts.Preview.position == posDown) {
+			effectiveMinHeight *= 2
+		}
+		if opts.InfoStyle != infoDefault {
+			effectiveMinHeight--
+		}
+		if opts.BorderShape != tui.BorderNone {
+			effectiveMinHeight += 2
+			if opts.Shadow {
+				effectiveMinHeight++
+			}
+		}
 		maxHeightFunc := func(termHeight int) int {
 			var maxHeight int
 			if opts.Height.percent {
-				maxHeight = util.Max(int(opts.Height.size*float64(termHeight)/100.0), opts.MinHeight)
+				minPercentHeight := opts.MinHeight
+				if opts.Height.auto {
+					minPercentHeight = opts.Height.min
+				}
+				maxHeight = util.Max(int(opts.Height.size*float64(termHeight)/100.0), minPercentHeight)
 			} else {
 				maxHeight = int(opts.Height.size)
 			}
-
-			effectiveMinHeight := minHeight
-			if previewBox != nil && (opts.Preview.position == posUp || opts.Preview.position == posDown) {
-				effectiveMinHeight *= 2
-			}
-			if opts.InfoStyle != infoDefault {
-				effectiveMinHeight--
-			}
-			if opts.BorderShape != tui.BorderNone {
-				effectiveMinHeight += 2
-			}
 			return util.Min(termHeight, util.Max(maxHeight, effectiveMinHeight))
 		}
-		renderer = tui.NewLightRenderer(opts.Theme, opts.Black, opts.Mouse, opts.Tabstop, opts.ClearOnExit, false, maxHeightFunc)
+		if opts.Height.auto {
+			// Height required to display the given number of lines within the bounds
+			fitHeight = func(lines int) func(int) int {
+				return func(termHeight int) int {
+					return util.Constrain(lines, util.Min(termHeight, util.Max(opts.Height.min, effectiveMinHeight)), maxHeightFunc(termHeight))
+				}
+			}
+			renderer = tui.NewLightRenderer(opts.Theme, opts.Black, opts.Mouse, opts.Hover, opts.ClickIntvl, opts.Tabstop, opts.ClearOnExit, false, fitHeight(0))
+		} else {
+			renderer = tui.NewLightRenderer(opts.Theme, opts.Black, opts.Mouse, opts.Hover, opts.ClickIntvl, opts.Tabstop, opts.ClearOnExit, false, maxHeightFunc)
+		}
 	}
 	wordRubout := "[^\\pL\\pN][\\pL\\pN]"
 	wordNext := "[\\pL\\pN][^\\pL\\pN]|(.$)"
+	if opts.WordBounds != nil {
+		wordRubout, wordNext = wordPatterns(*opts.WordBounds)
+	}
 	if opts.FileWord {
 		sep := regexp.QuoteMeta(string(os.PathSeparator))
 		wordRubout = fmt.Sprintf("%s[^%s]", sep, sep)
@@ -466,14 +755,20 @@ func NewTerminal(opts *Options, eventBox *util.EventBox) *Terminal {
 	}
 	t := Terminal{
 		initDelay:   delay,
+		pacer:       newRenderPacer(renderLatencyTarget),
 		infoStyle:   opts.InfoStyle,
+		infoToggle:  infoHidden,
+		separator:   opts.Separator,
 		spinner:     makeSpinner(opts.Unicode),
 		queryLen:    [2]int{0, 0},
 		layout:      opts.Layout,
 		fullscreen:  fullscreen,
+		fitHeight:   fitHeight,
 		keepRight:   opts.KeepRight,
 		hscroll:     opts.Hscroll,
 		hscrollOff:  opts.HscrollOff,
+		wrap:        opts.Wrap,
+		dryRun:      opts.DryRun,
 		wordRubout:  wordRubout,
 		wordNext:    wordNext,
 		cx:          len(input),
@@ -481,40 +776,91 @@ func NewTerminal(opts *Options, eventBox *util.EventBox) *Terminal {
 		offset:      0,
 		xoffset:     0,
 		yanked:      []rune{},
+		registers:   make(registerSet),
 		input:       input,
 		multi:       opts.Multi,
 		sort:        opts.Sort > 0,
 		toggleSort:  opts.ToggleSort,
 		delimiter:   opts.Delimiter,
+		nth:         opts.Nth,
+		nthOrig:     opts.Nth,
+		delimOrig:   opts.Delimiter,
+		scheme:      opts.Scheme,
+		schemeOrig:  opts.Scheme,
+		showScheme:  opts.ShowScheme,
+		caseMode:    opts.Case,
+		caseOrig:    opts.Case,
+		regex:       opts.Regex,
+		formats:     newFieldFormats(opts.WithNth, opts.Formats, opts.Delimiter, opts.Ansi),
+		keyField:    opts.KeyField,
 		expect:      opts.Expect,
 		keymap:      opts.Keymap,
 		pressed:     "",
 		printQuery:  opts.PrintQuery,
+		onAccept:    opts.OnAccept,
+		onExit:      opts.OnExit,
 		history:     opts.History,
+		filters:     filters,
+		state:       opts.State,
+		rankLog:     opts.RankLog,
 		margin:      opts.Margin,
 		padding:     opts.Padding,
 		unicode:     opts.Unicode,
+		unicodeAuto: opts.UnicodeAuto,
+		glyphs:      make(map[tui.BorderLine]bool),
 		borderShape: opts.BorderShape,
+		borderSides: opts.BorderSides,
+		borderChars: opts.BorderChars,
+		borderLabel: opts.BorderLabel,
+		shadow:      opts.Shadow,
+		scrollbar:   opts.Scrollbar,
+		sourceURL:   opts.SourceURL,
+		bgCommand:   opts.BgCommand,
 		cleanExit:   opts.ClearOnExit,
 		paused:      opts.Phony,
 		strong:      strongAttr,
 		cycle:       opts.Cycle,
 		header:      header,
 		header0:     header,
+		form:        opts.Form,
+		tabs:        opts.Tabs,
+		menu:        opts.Menu,
+		title:       opts.Title,
+		formFocus:   -1,
 		ansi:        opts.Ansi,
+		ansiBg:      opts.AnsiBg,
+		matchStyle:  opts.MatchStyle,
 		tabstop:     opts.Tabstop,
 		reading:     true,
 		failed:      nil,
 		jumping:     jumpDisabled,
 		jumpLabels:  opts.JumpLabels,
+		confirmAll:  opts.ConfirmAll,
+		dragFrom:    -1,
+		dragTo:      -1,
+		bellStyle:   opts.Bell,
+		bellsOn:     make(map[tui.BellStyle]bool),
+		paste:       opts.Paste,
+		pasteFilter: opts.PasteFilter,
+		chordIntvl:  opts.ChordIntvl,
+		keyNames:    defaultKeyNames(),
+		keySpecs:    opts.KeySpecs,
+		logFile:     opts.LogFile,
 		printer:     opts.Printer,
+		renderOnce:  opts.RenderOnce,
 		printsep:    opts.PrintSep,
 		merger:      EmptyMerger,
 		selected:    make(map[int32]selectedItem),
+		disabled:    disabled,
+		bars:        bars,
 		reqBox:      util.NewEventBox(),
 		previewOpts: opts.Preview,
-		previewer:   previewer{0, []string{}, 0, previewBox != nil && !opts.Preview.hidden, false, true, false, ""},
-		previewed:   previewed{0, 0, 0, false},
+		previewBase: opts.Preview,
+		previewRule: len(opts.Preview.rules),
+		fallbacks:   opts.Fallbacks,
+		previewOSCs: opts.PreviewOSCs,
+		previewer:   previewer{0, []string{}, 0, 0, 0, previewBox != nil && !opts.Preview.hidden, false, true, 0, false, "", []string{}, []string{}, false, 0},
+		previewed:   previewed{0, 0, 0, 0, false},
 		previewBox:  previewBox,
 		eventBox:    eventBox,
 		mutex:       sync.Mutex{},
@@ -524,14 +870,57 @@ func NewTerminal(opts *Options, eventBox *util.EventBox) *Terminal {
 		theme:       opts.Theme,
 		startChan:   make(chan bool, 1),
 		killChan:    make(chan int),
+		killedChan:  make(chan bool),
+		done:        make(chan bool),
 		tui:         renderer,
 		initFunc:    func() { renderer.Init() }}
-	t.prompt, t.promptLen = t.parsePrompt(opts.Prompt)
-	t.pointer, t.pointerLen = t.processTabs([]rune(opts.Pointer), 0)
-	t.marker, t.markerLen = t.processTabs([]rune(opts.Marker), 0)
-	// Pre-calculated empty pointer and marker signs
-	t.pointerEmpty = strings.Repeat(" ", t.pointerLen)
-	t.markerEmpty = strings.Repeat(" ", t.markerLen)
+	wrapSign := "> "
+	if opts.WrapSign != nil {
+		wrapSign = *opts.WrapSign
+	} else if t.unicode {
+		wrapSign = "↳ "
+	}
+	// The widths can change after the terminal is probed
+	measure := func() {
+		t.prompt, t.promptLen = t.parsePrompt(opts.Prompt)
+		t.pointer, t.pointerLen = t.processTabs([]rune(opts.Pointer), 0)
+		t.marker, t.markerLen = t.processTabs([]rune(opts.Marker), 0)
+		// Pre-calculated empty pointer and marker signs
+		t.pointerEmpty = strings.Repeat(" ", t.pointerLen)
+		t.markerEmpty = strings.Repeat(" ", t.markerLen)
+		t.wrapSign, t.wrapSignLen = t.processTabs([]rune(wrapSign), 0)
+	}
+	measure()
+	// The terminal is probed right after it is initialized, before we start
+	// reading the keys, so that the replies are not taken as the user input
+	probeGlyphs := t.unicode && t.unicodeAuto && len(t.borderChars) == 0
+	if opts.AmbigWidth == widthAuto || opts.EmojiWidth == widthAuto || probeGlyphs {
+		t.initFunc = func() {
+			renderer.Init()
+			if probeGlyphs {
+				t.probeGlyphs()
+			}
+			if opts.AmbigWidth == widthAuto || opts.EmojiWidth == widthAuto {
+				t.probeWidths(opts.AmbigWidth == widthAuto, opts.EmojiWidth == widthAuto)
+				measure()
+			}
+		}
+	}
+	// toggle-info action shows the info line when it is hidden from the start
+	if t.infoStyle == infoHidden {
+		t.infoToggle = infoDefault
+	}
+	// The actions bound to zero event are passed through the same channel as
+	// the actions from the listen server
+	if _, zero := opts.Keymap[tui.Zero.AsEvent()]; zero || len(opts.Listen) > 0 {
+		t.serverInput = make(chan []action, 100)
+	}
+	if opts.HintBar {
+		t.hints = bindingHints(opts.Keymap, opts.KeyNames)
+	}
+	for key, name := range opts.KeyNames {
+		t.keyNames[key] = name
+	}
 
 	return &t
 }
@@ -546,7 +935,7 @@ func (t *Terminal) parsePrompt(prompt string) (func(), int) {
 	//             // unless the part has a non-default ANSI state
 	loc := whiteSuffix.FindStringIndex(trimmed)
 	if loc != nil {
-		blankState := ansiOffset{[2]int32{int32(loc[0]), int32(loc[1])}, ansiState{-1, -1, tui.AttrClear, -1}}
+		blankState := ansiOffset{[2]int32{int32(loc[0]), int32(loc[1])}, ansiState{-1, -1, tui.AttrClear, -1, nil}}
 		if item.colors != nil {
 			lastColor := (*item.colors)[len(*item.colors)-1]
 			if lastColor.offset[1] < int32(loc[1]) {
@@ -584,6 +973,9 @@ func (t *Terminal) UpdateCount(cnt int, final bool, failedCommand *string) {
 	t.mutex.Lock()
 	t.count = cnt
 	t.reading = !final
+	if final {
+		t.resuming = false
+	}
 	t.failed = failedCommand
 	t.mutex.Unlock()
 	t.reqBox.Set(reqInfo, nil)
@@ -601,6 +993,23 @@ func reverseStringArray(input []string) []string {
 	return reversed
 }
 
+// UpdateResuming updates the state of the read being resumed after the process
+// is continued
+func (t *Terminal) UpdateResuming(resuming bool) {
+	t.mutex.Lock()
+	t.resuming = resuming
+	t.mutex.Unlock()
+	t.reqBox.Set(reqInfo, nil)
+}
+
+// UpdateSources updates the number of the sources completely read
+func (t *Terminal) UpdateSources(progress sourceProgress) {
+	t.mutex.Lock()
+	t.sources = progress
+	t.mutex.Unlock()
+	t.reqBox.Set(reqInfo, nil)
+}
+
 // UpdateHeader updates the header
 func (t *Terminal) UpdateHeader(header []string) {
 	t.mutex.Lock()
@@ -622,17 +1031,61 @@ func (t *Terminal) UpdateProgress(progress float32) {
 	}
 }
 
+// UpdateSelection removes the items from the selection for which keep returns
+// false, as they are removed from the list
+func (t *Terminal) UpdateSelection(keep func(*Item) bool) {
+	t.mutex.Lock()
+	changed := false
+	for index, sel := range t.selected {
+		if !keep(sel.item) {
+			delete(t.selected, index)
+			changed = true
+		}
+	}
+	t.mutex.Unlock()
+	if changed {
+		t.reqBox.Set(reqInfo, nil)
+	}
+}
+
+// UpdateDelay returns the pause between the updates of the list while the
+// input is being read, adapted to the latency of the terminal
+func (t *Terminal) UpdateDelay(ticks int) time.Duration {
+	return t.pacer.delay(ticks)
+}
+
 // UpdateList updates Merger to display the list
 func (t *Terminal) UpdateList(merger *Merger, reset bool) {
 	t.mutex.Lock()
 	t.progress = 100
-	t.merger = merger
 	if reset {
+		if t.tabState == nil && len(t.keyField) > 0 {
+			t.trackKeys()
+		}
 		t.selected = make(map[int32]selectedItem)
 	}
+	// The list of the tab has been loaded. The list is not reset if it is
+	// identical to the one of the previous tab.
+	if t.tabState != nil && (reset || merger.final) {
+		t.state, t.tabState = t.tabState, nil
+	}
+	// zero event is triggered when the items no longer match
+	zero, prs := t.keymap[tui.Zero.AsEvent()]
+	prs = prs && t.count > 0 && merger.Length() == 0 && (t.merger.Length() > 0 || t.merger == EmptyMerger)
+	t.merger = merger
+	if t.state != nil && merger.final {
+		t.restoreState(merger)
+	}
 	t.mutex.Unlock()
 	t.reqBox.Set(reqInfo, nil)
 	t.reqBox.Set(reqList, nil)
+	// Dropped if the channel is full rather than blocking the caller
+	if prs {
+		select {
+		case t.serverInput <- zero:
+		default:
+		}
+	}
 }
 
 func (t *Terminal) output() bool {
@@ -642,6 +1095,13 @@ func (t *Terminal) output() bool {
 	if len(t.expect) > 0 {
 		t.printer(t.pressed)
 	}
+	if t.acceptAll {
+		items := t.matchedItems()
+		for _, item := range items {
+			t.printer(item.AsString(t.ansi))
+		}
+		return len(items) > 0
+	}
 	found := len(t.selected) > 0
 	if !found {
 		current := t.currentItem()
@@ -657,6 +1117,34 @@ func (t *Terminal) output() bool {
 	return found
 }
 
+func (t *Terminal) acceptedItems() []*Item {
+	if t.acceptAll {
+		return t.matchedItems()
+	}
+	if len(t.selected) == 0 {
+		if current := t.currentItem(); current != nil {
+			return []*Item{current}
+		}
+		return []*Item{}
+	}
+	items := []*Item{}
+	for _, sel := range t.sortSelected() {
+		items = append(items, sel.item)
+	}
+	return items
+}
+
+// matchedItems returns all the matched items except for the disabled ones
+func (t *Terminal) matchedItems() []*Item {
+	items := []*Item{}
+	for i := 0; i < t.merger.Length(); i++ {
+		if item := t.merger.Get(i).item; !t.disabled.has(item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func (t *Terminal) sortSelected() []selectedItem {
 	sels := make([]selectedItem, 0, len(t.selected))
 	for _, sel := range t.selected {
@@ -667,11 +1155,7 @@ func (t *Terminal) sortSelected() []selectedItem {
 }
 
 func (t *Terminal) displayWidth(runes []rune) int {
-	l := 0
-	for _, r := range runes {
-		l += util.RuneWidth(r, l, t.tabstop)
-	}
-	return l
+	return t.displayWidthWithLimit(runes, 0, math.MaxInt32)
 }
 
 const (
@@ -687,10 +1171,234 @@ func calculateSize(base int, size sizeSpec, occupied int, minSize int, pad int)
 	return util.Constrain(int(size.size)+pad, minSize, max)
 }
 
+// probeWidths measures the widths of the sample characters on the terminal
+// and applies them to the classes of the characters. The widths are left as
+// they are if the terminal does not report the position of the cursor.
+func (t *Terminal) probeWidths(ambiguous bool, emoji bool) {
+	if ambiguous {
+		if width := t.tui.MeasureWidth(ambiguousSample); width == 1 || width == 2 {
+			util.SetAmbiguousWidth(width)
+		}
+	}
+	if emoji {
+		if width := t.tui.MeasureWidth(emojiSample); width == 1 || width == 2 {
+			util.SetEmojiWidth(width)
+		}
+	}
+}
+
+// probeGlyphs checks if the terminal can display the characters of the kinds
+// of line used by the borders, and of the sharp line to fall back to. It
+// should be called only once on start-up as the probe may print the
+// characters and read the reply of the terminal. The kinds not probed are
+// assumed to be displayable.
+func (t *Terminal) probeGlyphs() {
+	used := map[tui.BorderLine]bool{tui.LineSharp: true}
+	addSides := func(sides tui.BorderSides) {
+		for _, line := range sides {
+			used[line] = true
+		}
+	}
+	if t.borderShape == tui.BorderCustom {
+		addSides(t.borderSides)
+	} else {
+		addSides(t.borderShape.Sides())
+	}
+	addSides(t.previewOpts.border.Sides())
+	for _, rule := range t.previewOpts.rules {
+		opts := t.previewOpts
+		parsePreviewWindow(&opts, rule.spec)
+		addSides(opts.border.Sides())
+	}
+	addSides(tui.BorderRounded.Sides())
+
+	lines := []tui.BorderLine{}
+	texts := []string{}
+	for line := tui.LineSharp; line <= tui.LineHeavyDashed; line++ {
+		if used[line] {
+			lines = append(lines, line)
+			texts = append(texts, line.Glyphs())
+		}
+	}
+	for idx, supported := range t.tui.CanDisplay(texts) {
+		t.glyphs[lines[idx]] = supported
+	}
+}
+
+// canDisplay tells if the terminal can display the characters of the line
+// according to the result of the probe on start-up
+func (t *Terminal) canDisplay(line tui.BorderLine) bool {
+	supported, found := t.glyphs[line]
+	return supported || !found
+}
+
+func (t *Terminal) makeBorderStyle(shape tui.BorderShape, sides tui.BorderSides) tui.BorderStyle {
+	unicode := t.unicode
+	if unicode && t.unicodeAuto && len(t.borderChars) == 0 && shape != tui.BorderNone {
+		// Fall back to sharp lines, and to ASCII characters if even those
+		// cannot be displayed
+		if shape != tui.BorderCustom {
+			sides = shape.Sides()
+		}
+		fallback := sides
+		for idx, line := range sides {
+			if !t.canDisplay(line) {
+				fallback[idx] = tui.LineSharp
+			}
+		}
+		unicode = t.canDisplay(tui.LineSharp)
+		if fallback != sides {
+			shape, sides = tui.BorderCustom, fallback
+		}
+	}
+	var style tui.BorderStyle
+	if shape == tui.BorderCustom {
+		style = tui.MakeCustomBorderStyle(sides, unicode)
+	} else {
+		style = tui.MakeBorderStyle(shape, unicode)
+	}
+	if len(t.borderChars) > 0 {
+		return style.WithChars(t.borderChars)
+	}
+	return style
+}
+
+// labelGap is the free range of columns on the line of the border
+type labelGap struct {
+	from int
+	to   int
+}
+
+// ellipsizeLabel truncates the label with an ellipsis to fit in the width. It
+// returns nil when the width cannot hold a character followed by the ellipsis.
+func (t *Terminal) ellipsizeLabel(runes []rune, width int) []rune {
+	if t.displayWidth(runes) <= width {
+		return runes
+	}
+	if width < len(ellipsis)+1 {
+		return nil
+	}
+	trimmed, _ := t.trimRight(runes, width-len(ellipsis))
+	return append(append([]rune{}, trimmed...), []rune(ellipsis)...)
+}
+
+// labelOffset returns the offset of the label in the free space of the line
+func labelOffset(opts labelOpts, free int) int {
+	var x int
+	if opts.percent {
+		x = free * opts.offset / 100
+	} else if opts.offset > 0 {
+		x = opts.offset - 1
+	} else if opts.offset < 0 {
+		x = free + opts.offset + 1
+	} else {
+		x = free / 2
+	}
+	return util.Constrain(x, 0, free)
+}
+
+// makeBorderLabels lays out the labels on the border of the given width. The
+// labels are placed between the corners of the border in the given order. A
+// label overlapping the ones placed before it is moved to the nearest gap that
+// can hold it, or truncated with an ellipsis to fit in the widest gap.
+func (t *Terminal) makeBorderLabels(labels []labelOpts, sides tui.BorderSides, width int) []tui.BorderLabel {
+	minX, maxX := 0, width
+	if sides[3] != tui.LineNone {
+		minX++
+	}
+	if sides[1] != tui.LineNone {
+		maxX--
+	}
+	placed := []tui.BorderLabel{}
+	for _, opts := range labels {
+		bottom := opts.bottom
+		if bottom && sides[2] == tui.LineNone {
+			bottom = false
+		} else if !bottom && sides[0] == tui.LineNone {
+			bottom = true
+		}
+		// No line on either side to draw the label on
+		if len(opts.label) == 0 || bottom && sides[2] == tui.LineNone || !bottom && sides[0] == tui.LineNone {
+			continue
+		}
+		label := []rune(opts.label)
+		runes := t.ellipsizeLabel(label, maxX-minX)
+		if runes == nil {
+			continue
+		}
+		labelWidth := t.displayWidth(runes)
+		x := minX + labelOffset(opts, maxX-minX-labelWidth)
+
+		var fit, widest *labelGap
+		fitX := 0
+		for _, gap := range t.labelGaps(placed, bottom, minX, maxX) {
+			gap := gap
+			if widest == nil || gap.to-gap.from > widest.to-widest.from {
+				widest = &gap
+			}
+			if gap.to-gap.from >= labelWidth {
+				gapX := util.Constrain(x, gap.from, gap.to-labelWidth)
+				if fit == nil || util.Max(gapX-x, x-gapX) < util.Max(fitX-x, x-fitX) {
+					fit, fitX = &gap, gapX
+				}
+			}
+		}
+		if fit != nil {
+			x = fitX
+		} else if widest != nil {
+			if runes = t.ellipsizeLabel(label, widest.to-widest.from); runes == nil {
+				continue
+			}
+			x = widest.from
+		} else {
+			continue
+		}
+		placed = append(placed, tui.BorderLabel{Text: string(runes), X: x, Bottom: bottom})
+	}
+	return placed
+}
+
+// labelGaps returns the ranges of columns on the line not occupied by the
+// labels, from left to right
+func (t *Terminal) labelGaps(labels []tui.BorderLabel, bottom bool, minX int, maxX int) []labelGap {
+	taken := []labelGap{}
+	for _, label := range labels {
+		if label.Bottom == bottom {
+			taken = append(taken, labelGap{label.X, label.X + t.displayWidth([]rune(label.Text))})
+		}
+	}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].from < taken[j].from })
+	gaps := []labelGap{}
+	from := minX
+	for _, gap := range taken {
+		if gap.from > from {
+			gaps = append(gaps, labelGap{from, gap.from})
+		}
+		from = util.Max(from, gap.to)
+	}
+	if from < maxX {
+		gaps = append(gaps, labelGap{from, maxX})
+	}
+	return gaps
+}
+
+// castShadow makes the window cast a shadow drawn by the renderer
+func (t *Terminal) castShadow(window tui.Window) {
+	if t.unicode {
+		window.SetShadow('▚')
+	} else {
+		window.SetShadow(':')
+	}
+}
+
 func (t *Terminal) resizeWindows() {
 	screenWidth := t.tui.MaxX()
 	screenHeight := t.tui.MaxY()
+	if t.hasPreviewer() {
+		t.choosePreviewLayout(screenWidth)
+	}
 	t.prevLines = make([]itemLine, screenHeight)
+	t.updateBackground(screenWidth, screenHeight)
 
 	marginInt := [4]int{}  // TRBL
 	paddingInt := [4]int{} // TRBL
@@ -702,7 +1410,7 @@ func (t *Terminal) resizeWindows() {
 			} else {
 				max = float64(screenWidth)
 			}
-			return int(max * spec.size * 0.01)
+			return spec.bound(int(max * spec.size * 0.01))
 		}
 		return int(spec.size)
 	}
@@ -710,31 +1418,19 @@ func (t *Terminal) resizeWindows() {
 		paddingInt[idx] = sizeSpecToInt(idx, sizeSpec)
 	}
 
-	extraMargin := [4]int{} // TRBL
+	borderStyle := t.makeBorderStyle(t.borderShape, t.borderSides)
+	borderSides := borderStyle.Sides()
+	borderMargin := [4]int{} // TRBL
+	extraMargin := [4]int{}  // TRBL
 	for idx, sizeSpec := range t.margin {
-		switch t.borderShape {
-		case tui.BorderHorizontal:
-			extraMargin[idx] += 1 - idx%2
-		case tui.BorderVertical:
-			extraMargin[idx] += 2 * (idx % 2)
-		case tui.BorderTop:
-			if idx == 0 {
-				extraMargin[idx]++
-			}
-		case tui.BorderRight:
-			if idx == 1 {
-				extraMargin[idx] += 2
-			}
-		case tui.BorderBottom:
-			if idx == 2 {
-				extraMargin[idx]++
-			}
-		case tui.BorderLeft:
-			if idx == 3 {
-				extraMargin[idx] += 2
-			}
-		case tui.BorderRounded, tui.BorderSharp:
-			extraMargin[idx] += 1 + idx%2
+		if t.borderShape != tui.BorderNone && borderSides[idx] != tui.LineNone {
+			// A vertical side takes two columns including the gap
+			borderMargin[idx] += 1 + idx%2
+		}
+		extraMargin[idx] = borderMargin[idx]
+		if t.shadow && t.borderShape != tui.BorderNone && (idx == 1 || idx == 2) {
+			// The shadow on the right and at the bottom
+			extraMargin[idx]++
 		}
 		marginInt[idx] = sizeSpecToInt(idx, sizeSpec) + extraMargin[idx]
 	}
@@ -768,6 +1464,10 @@ func (t *Terminal) resizeWindows() {
 	if t.border != nil {
 		t.border.Close()
 	}
+	if t.twindow != nil {
+		t.twindow.Close()
+		t.twindow = nil
+	}
 	if t.window != nil {
 		t.window.Close()
 	}
@@ -782,35 +1482,16 @@ func (t *Terminal) resizeWindows() {
 
 	width := screenWidth - marginInt[1] - marginInt[3]
 	height := screenHeight - marginInt[0] - marginInt[2]
-	switch t.borderShape {
-	case tui.BorderHorizontal:
-		t.border = t.tui.NewWindow(
-			marginInt[0]-1, marginInt[3], width, height+2,
-			false, tui.MakeBorderStyle(tui.BorderHorizontal, t.unicode))
-	case tui.BorderVertical:
-		t.border = t.tui.NewWindow(
-			marginInt[0], marginInt[3]-2, width+4, height,
-			false, tui.MakeBorderStyle(tui.BorderVertical, t.unicode))
-	case tui.BorderTop:
-		t.border = t.tui.NewWindow(
-			marginInt[0]-1, marginInt[3], width, height+1,
-			false, tui.MakeBorderStyle(tui.BorderTop, t.unicode))
-	case tui.BorderBottom:
-		t.border = t.tui.NewWindow(
-			marginInt[0], marginInt[3], width, height+1,
-			false, tui.MakeBorderStyle(tui.BorderBottom, t.unicode))
-	case tui.BorderLeft:
-		t.border = t.tui.NewWindow(
-			marginInt[0], marginInt[3]-2, width+2, height,
-			false, tui.MakeBorderStyle(tui.BorderLeft, t.unicode))
-	case tui.BorderRight:
-		t.border = t.tui.NewWindow(
-			marginInt[0], marginInt[3], width+2, height,
-			false, tui.MakeBorderStyle(tui.BorderRight, t.unicode))
-	case tui.BorderRounded, tui.BorderSharp:
-		t.border = t.tui.NewWindow(
-			marginInt[0]-1, marginInt[3]-2, width+4, height+2,
-			false, tui.MakeBorderStyle(t.borderShape, t.unicode))
+	if t.borderShape != tui.BorderNone {
+		top := marginInt[0] - borderMargin[0]
+		left := marginInt[3] - borderMargin[3]
+		w := width + borderMargin[1] + borderMargin[3]
+		h := height + borderMargin[0] + borderMargin[2]
+		t.border = t.tui.NewWindow(top, left, w, h, false, borderStyle)
+		t.border.SetBorderLabels(t.makeBorderLabels(t.borderLabel, borderStyle.Sides(), w))
+		if t.shadow {
+			t.castShadow(t.border)
+		}
 	}
 
 	// Add padding
@@ -821,13 +1502,29 @@ func (t *Terminal) resizeWindows() {
 	height = screenHeight - marginInt[0] - marginInt[2]
 
 	noBorder := tui.MakeBorderStyle(tui.BorderNone, t.unicode)
+	if len(t.title) > 0 && height > 1 {
+		// The title row spans the list and the preview window
+		t.twindow = t.tui.NewWindow(marginInt[0], marginInt[3], width, 1, false, noBorder)
+		marginInt[0]++
+		height--
+	}
 	if previewVisible {
 		createPreviewWindow := func(y int, x int, w int, h int) {
+			// Leave room for the shadow
+			shadow := t.shadow && t.previewOpts.border != tui.BorderNone
+			if shadow {
+				w--
+				h--
+			}
 			pwidth := w
 			pheight := h
 			if t.previewOpts.border != tui.BorderNone {
-				previewBorder := tui.MakeBorderStyle(t.previewOpts.border, t.unicode)
+				previewBorder := t.makeBorderStyle(t.previewOpts.border, t.previewOpts.border.Sides())
 				t.pborder = t.tui.NewWindow(y, x, w, h, true, previewBorder)
+				t.pborder.SetBorderLabels(t.makeBorderLabels([]labelOpts{t.previewOpts.label}, previewBorder.Sides(), w))
+				if shadow {
+					t.castShadow(t.pborder)
+				}
 				pwidth -= 4
 				pheight -= 2
 				x += 2
@@ -875,11 +1572,28 @@ func (t *Terminal) resizeWindows() {
 			width,
 			height, false, noBorder)
 	}
+	t.tui.Reserve(t.reservedRegion())
 	for i := 0; i < t.window.Height(); i++ {
 		t.window.MoveAndClear(i, 0)
 	}
 }
 
+// reservedRegion returns the region of the screen reserved by reserve-region
+// action. The region of the preview window follows its position and size.
+func (t *Terminal) reservedRegion() *tui.Region {
+	switch t.region {
+	case "":
+		return nil
+	case "preview":
+		if !t.hasPreviewWindow() {
+			return nil
+		}
+		return &tui.Region{Top: t.pwindow.Top(), Left: t.pwindow.Left(), Width: t.pwindow.Width(), Height: t.pwindow.Height()}
+	}
+	region, _ := parseRegion(t.region)
+	return region
+}
+
 func (t *Terminal) move(y int, x int, clear bool) {
 	h := t.window.Height()
 
@@ -887,7 +1601,7 @@ func (t *Terminal) move(y int, x int, clear bool) {
 	case layoutDefault:
 		y = h - y - 1
 	case layoutReverseList:
-		n := 2 + len(t.header)
+		n := 2 + t.headerLines()
 		if t.noInfoLine() {
 			n--
 		}
@@ -927,10 +1641,16 @@ func (t *Terminal) updatePromptOffset() ([]rune, []rune) {
 }
 
 func (t *Terminal) placeCursor() {
+	if field := t.focusedField(); field != nil {
+		x := 2 + t.displayWidth([]rune(t.fieldLabel(field))) + t.displayWidth(field.value[:field.cx])
+		t.move(t.fieldLine(t.formFocus), x, false)
+		return
+	}
 	t.move(0, t.promptLen+t.queryLen[0], false)
 }
 
 func (t *Terminal) printPrompt() {
+	defer t.printTitle()
 	t.move(0, 0, true)
 	t.prompt()
 
@@ -952,11 +1672,12 @@ func (t *Terminal) trimMessage(message string, maxWidth int) string {
 }
 
 func (t *Terminal) printInfo() {
+	defer t.printTitle()
 	pos := 0
 	switch t.infoStyle {
 	case infoDefault:
 		t.move(1, 0, true)
-		if t.reading {
+		if t.reading || t.statusRunning() {
 			duration := int64(spinnerDuration)
 			idx := (time.Now().UnixNano() % (duration * int64(len(t.spinner)))) / duration
 			t.window.CPrint(tui.ColSpinner, t.spinner[idx])
@@ -969,14 +1690,18 @@ func (t *Terminal) printInfo() {
 			return
 		}
 		t.move(0, pos, true)
-		if t.reading {
+		if t.reading || t.statusRunning() {
 			t.window.CPrint(tui.ColSpinner, " < ")
 		} else {
 			t.window.CPrint(tui.ColPrompt, " < ")
 		}
 		pos += len(" < ")
 	case infoHidden:
-		return
+		if t.confirming == 0 && t.chord == nil && t.numArg == 0 {
+			return
+		}
+		pos = t.promptLen + t.queryLen[0] + t.queryLen[1] + 1
+		t.move(0, pos, true)
 	}
 
 	found := t.merger.Length()
@@ -989,6 +1714,15 @@ func (t *Terminal) printInfo() {
 			output += " -S"
 		}
 	}
+	if t.showScheme {
+		output += " [" + t.scheme + "]"
+	}
+	if t.regex {
+		output += " (regex)"
+	}
+	if len(t.querySets) > 0 {
+		output += " (" + describeQuerySets(t.querySets) + ")"
+	}
 	if t.multi > 0 {
 		if t.multi == maxMulti {
 			output += fmt.Sprintf(" (%d)", len(t.selected))
@@ -999,20 +1733,68 @@ func (t *Terminal) printInfo() {
 	if t.progress > 0 && t.progress < 100 {
 		output += fmt.Sprintf(" (%d%%)", t.progress)
 	}
+	if t.reading && t.sources.total > 1 && t.sources.loaded < t.sources.total {
+		output += fmt.Sprintf(" (%d/%d sources)", t.sources.loaded, t.sources.total)
+	}
+	if t.resuming {
+		if t.unicode {
+			output += " (resuming read…)"
+		} else {
+			output += " (resuming read...)"
+		}
+	}
 	if t.failed != nil && t.count == 0 {
 		output = fmt.Sprintf("[Command failed: %s]", *t.failed)
 	}
+	if t.numArg > 0 {
+		output += fmt.Sprintf(" (arg: %d)", t.numArg)
+	}
+	if t.chord != nil {
+		output += fmt.Sprintf(" (%s>)", t.chord.name)
+	}
+	if t.macro.recording {
+		output += " (recording)"
+	}
+	if t.statusCmd != nil && len(t.statusLine) > 0 {
+		output += "  " + t.statusLine
+	}
+	if t.confirming > 0 {
+		output = fmt.Sprintf("Accept all %d items? [y/N]", t.confirming)
+	}
 	output = t.trimMessage(output, t.window.Width()-pos)
 	t.window.CPrint(tui.ColInfo, output)
+
+	if t.separator && t.infoStyle == infoDefault {
+		if width := t.window.Width() - pos - t.displayWidth([]rune(output)) - 1; width > 0 {
+			line := "─"
+			if !t.unicode {
+				line = "-"
+			}
+			t.window.CPrint(tui.ColBorder, " "+strings.Repeat(line, width))
+		}
+	}
+}
+
+// statusRunning tells if the command of execute-status is running
+func (t *Terminal) statusRunning() bool {
+	return t.statusCmd != nil && !t.statusDone
+}
+
+// visibleHeader returns the header lines not hidden by shrink-header action
+func (t *Terminal) visibleHeader() []string {
+	return t.header[:util.Max(0, len(t.header)-t.headerCut)]
 }
 
 func (t *Terminal) printHeader() {
-	if len(t.header) == 0 {
+	if t.headerLines() == 0 {
 		return
 	}
+	defer t.printTabBar()
+	defer t.printHint()
+	defer t.printForm()
 	max := t.window.Height()
 	var state *ansiState
-	for idx, lineStr := range t.header {
+	for idx, lineStr := range t.visibleHeader() {
 		line := idx + 2
 		if t.noInfoLine() {
 			line--
@@ -1036,26 +1818,131 @@ func (t *Terminal) printList() {
 	t.constrain()
 
 	maxy := t.maxItems()
+	if t.wrap {
+		t.printWrappedList(maxy)
+		t.printScrollbar(maxy)
+		return
+	}
 	count := t.merger.Length() - t.offset
 	for j := 0; j < maxy; j++ {
 		i := j
 		if t.layout == layoutDefault {
 			i = maxy - 1 - j
 		}
-		line := i + 2 + len(t.header)
+		line := i + 2 + t.headerLines()
 		if t.noInfoLine() {
 			line--
 		}
 		if i < count {
-			t.printItem(t.merger.Get(i+t.offset), line, i, i == t.cy-t.offset)
+			t.printItem(t.merger.Get(i+t.offset), line, i, i, 1, i == t.cy-t.offset)
 		} else if t.prevLines[i] != emptyLine {
 			t.prevLines[i] = emptyLine
 			t.move(line, 0, true)
 		}
 	}
+	t.printScrollbar(maxy)
+}
+
+// printWrappedList prints the items from the offset, each of which takes as
+// many lines as needed to show the whole text. The last item is cut off at
+// the end of the list.
+func (t *Terminal) printWrappedList(maxy int) {
+	base := 2 + t.headerLines()
+	if t.noInfoLine() {
+		base--
+	}
+	row := 0
+	for i := 0; row < maxy && t.offset+i < t.merger.Length(); i++ {
+		result := t.merger.Get(t.offset + i)
+		rows := util.Min(t.itemRows(result.item), maxy-row)
+		t.printItem(result, base+row, i, row, rows, t.offset+i == t.cy)
+		row += rows
+	}
+	for ; row < maxy; row++ {
+		if t.prevLines[row] != emptyLine {
+			t.prevLines[row] = emptyLine
+			t.move(base+row, 0, true)
+		}
+	}
+}
+
+// wrapLines splits the text of the item into the lines that fit in the width
+// of the list. The lines after the first one are indented by the wrap sign.
+func (t *Terminal) wrapLines(text []rune) [][]rune {
+	width := t.window.Width() - (t.pointerLen + t.markerLen + t.bars.length() + 1)
+	lines := [][]rune{}
+	for len(text) > 0 || len(lines) == 0 {
+		if len(lines) == 1 {
+			width -= t.wrapSignLen
+		}
+		line, _ := t.trimRight(text, width)
+		if len(line) == 0 && len(text) > 0 {
+			// The first character does not fit in the width
+			length, _ := util.NextGrapheme(text, 0, t.tabstop)
+			line = text[:length]
+		}
+		lines = append(lines, line)
+		text = text[len(line):]
+	}
+	return lines
+}
+
+// itemRows returns the number of the lines the item takes on the list
+func (t *Terminal) itemRows(item *Item) int {
+	if !t.wrap {
+		return 1
+	}
+	return len(t.wrapLines(t.displayText(item)))
+}
+
+// displayText returns the text of the item to display
+func (t *Terminal) displayText(item *Item) []rune {
+	if t.formats != nil {
+		text, _ := t.formats.format(item)
+		return text
+	}
+	return item.text.ToRunes()
+}
+
+// itemAt returns the index of the item on the row of the list
+func (t *Terminal) itemAt(row int) int {
+	if !t.wrap {
+		return t.offset + row
+	}
+	idx := t.offset
+	for ; idx < t.merger.Length(); idx++ {
+		row -= t.itemRows(t.merger.Get(idx).item)
+		if row < 0 {
+			return idx
+		}
+	}
+	return idx + row
+}
+
+// printScrollbar draws the scrollbar in the last column of the list, which is
+// not used by the items
+func (t *Terminal) printScrollbar(maxy int) {
+	if !t.scrollbar || t.window.Width() < 2 {
+		return
+	}
+	cells := scrollbarCells(maxy, t.merger.Length(), t.offset, t.layout == layoutDefault, t.unicode)
+	for i, cell := range cells {
+		line := i + 2 + t.headerLines()
+		if t.noInfoLine() {
+			line--
+		}
+		t.move(line, t.window.Width()-1, false)
+		color := tui.ColScrollbar
+		if cell.reverse {
+			color = color.WithAttr(tui.Reverse)
+		}
+		t.window.CPrint(color, cell.char)
+	}
 }
 
-func (t *Terminal) printItem(result Result, line int, i int, current bool) {
+// printItem prints the item on the lines of the list from the row. i is the
+// position of the item on the screen used for the jump labels.
+func (t *Terminal) printItem(result Result, line int, i int, row int, rows int, current bool) {
 	item := result.item
 	_, selected := t.selected[item.Index()]
 	label := ""
@@ -1068,72 +1955,132 @@ func (t *Terminal) printItem(result Result, line int, i int, current bool) {
 	} else if current {
 		label = t.pointer
 	}
+	bar := ""
+	if t.bars != nil {
+		bar = t.bars.bar(item) + " "
+	}
 
 	// Avoid unnecessary redraw
-	newLine := itemLine{current: current, selected: selected, label: label,
-		result: result, queryLen: len(t.input), width: 0}
-	prevLine := t.prevLines[i]
+	hoffset := t.hoffset
+	if t.wrap {
+		hoffset = 0
+	}
+	newLine := itemLine{current: current, selected: selected, label: label, bar: bar,
+		result: result, queryLen: len(t.input), hoffset: hoffset, width: 0, rows: rows}
+	prevLine := t.prevLines[row]
 	if prevLine.current == newLine.current &&
 		prevLine.selected == newLine.selected &&
 		prevLine.label == newLine.label &&
+		prevLine.bar == newLine.bar &&
 		prevLine.queryLen == newLine.queryLen &&
+		prevLine.hoffset == newLine.hoffset &&
+		prevLine.rows == newLine.rows &&
 		prevLine.result == newLine.result {
 		return
 	}
 
-	t.move(line, 0, false)
-	if current {
-		if len(label) == 0 {
-			t.window.CPrint(tui.ColCurrentCursorEmpty, t.pointerEmpty)
+	printPrefix := func(label string, selected bool, bar string) {
+		if current {
+			if len(label) == 0 {
+				t.window.CPrint(tui.ColCurrentCursorEmpty, t.pointerEmpty)
+			} else {
+				t.window.CPrint(tui.ColCurrentCursor, label)
+			}
+			if selected {
+				t.window.CPrint(tui.ColCurrentSelected, t.marker)
+			} else {
+				t.window.CPrint(tui.ColCurrentSelectedEmpty, t.markerEmpty)
+			}
+			if len(bar) > 0 {
+				t.window.CPrint(tui.ColCurrentBar, bar)
+			}
 		} else {
-			t.window.CPrint(tui.ColCurrentCursor, label)
+			if len(label) == 0 {
+				t.window.CPrint(tui.ColCursorEmpty, t.pointerEmpty)
+			} else {
+				t.window.CPrint(tui.ColCursor, label)
+			}
+			if selected {
+				t.window.CPrint(tui.ColSelected, t.marker)
+			} else {
+				t.window.Print(t.markerEmpty)
+			}
+			if len(bar) > 0 {
+				t.window.CPrint(tui.ColBar, bar)
+			}
 		}
-		if selected {
-			t.window.CPrint(tui.ColCurrentSelected, t.marker)
-		} else {
-			t.window.CPrint(tui.ColCurrentSelectedEmpty, t.markerEmpty)
-		}
-		newLine.width = t.printHighlighted(result, tui.ColCurrent, tui.ColCurrentMatch, true, true)
-	} else {
-		if len(label) == 0 {
-			t.window.CPrint(tui.ColCursorEmpty, t.pointerEmpty)
-		} else {
-			t.window.CPrint(tui.ColCursor, label)
+	}
+	colBase, colMatch, match := tui.ColNormal, tui.ColMatch, true
+	if current {
+		colBase, colMatch = tui.ColCurrent, tui.ColCurrentMatch
+	} else if t.disabled.has(item) {
+		colBase, colMatch, match = tui.ColDisabled, tui.ColDisabled, false
+	}
+
+	if t.wrap {
+		// The lines of the item are printed from the top regardless of the
+		// layout
+		lineAt := func(k int) int {
+			if t.layout == layoutDefault {
+				return line + rows - 1 - k
+			}
+			return line + k
 		}
-		if selected {
-			t.window.CPrint(tui.ColSelected, t.marker)
-		} else {
-			t.window.Print(t.markerEmpty)
+		t.move(lineAt(0), 0, true)
+		printPrefix(label, selected, bar)
+		blank := strings.Repeat(" ", t.displayWidth([]rune(bar)))
+		t.printWrapped(result, colBase, colMatch, current, match, rows, func(k int) {
+			t.move(lineAt(k), 0, true)
+			printPrefix("", false, blank)
+		})
+		// The lines are cleared to the end, and the wrapped lines are marked
+		// so that they are printed again when not taken by the item
+		newLine.width = t.window.Width()
+		t.prevLines[row] = newLine
+		for k := 1; k < rows; k++ {
+			t.prevLines[row+k] = itemLine{rows: -1, width: newLine.width}
 		}
-		newLine.width = t.printHighlighted(result, tui.ColNormal, tui.ColMatch, false, true)
+		return
 	}
+
+	t.move(line, 0, false)
+	printPrefix(label, selected, bar)
+	newLine.width = t.printHighlighted(result, colBase, colMatch, current, match)
 	fillSpaces := prevLine.width - newLine.width
 	if fillSpaces > 0 {
 		t.window.Print(strings.Repeat(" ", fillSpaces))
 	}
-	t.prevLines[i] = newLine
+	t.prevLines[row] = newLine
 }
 
+// The functions below process the runes by grapheme clusters so that the
+// text is not truncated in the middle of a cluster such as an emoji ZWJ
+// sequence.
+
 func (t *Terminal) trimRight(runes []rune, width int) ([]rune, int) {
 	// We start from the beginning to handle tab characters
 	l := 0
-	for idx, r := range runes {
-		l += util.RuneWidth(r, l, t.tabstop)
+	for idx := 0; idx < len(runes); {
+		length, w := util.NextGrapheme(runes[idx:], l, t.tabstop)
+		l += w
 		if l > width {
 			return runes[:idx], len(runes) - idx
 		}
+		idx += length
 	}
 	return runes, 0
 }
 
 func (t *Terminal) displayWidthWithLimit(runes []rune, prefixWidth int, limit int) int {
 	l := 0
-	for _, r := range runes {
-		l += util.RuneWidth(r, l+prefixWidth, t.tabstop)
+	for idx := 0; idx < len(runes); {
+		length, w := util.NextGrapheme(runes[idx:], l+prefixWidth, t.tabstop)
+		l += w
 		if l > limit {
 			// Early exit
 			return l
 		}
+		idx += length
 	}
 	return l
 }
@@ -1143,7 +2090,7 @@ func (t *Terminal) trimLeft(runes []rune, width int) ([]rune, int32) {
 	var trimmed int32
 	// Assume that each rune takes at least one column on screen
 	if len(runes) > width {
-		diff := len(runes) - width
+		diff := util.PrevGraphemeBoundary(runes, len(runes)-width)
 		trimmed = int32(diff)
 		runes = runes[diff:]
 	}
@@ -1151,19 +2098,69 @@ func (t *Terminal) trimLeft(runes []rune, width int) ([]rune, int32) {
 	currentWidth := t.displayWidth(runes)
 
 	for currentWidth > width && len(runes) > 0 {
-		runes = runes[1:]
-		trimmed++
+		length, _ := util.NextGrapheme(runes, 0, t.tabstop)
+		runes = runes[length:]
+		trimmed += int32(length)
 		currentWidth = t.displayWidthWithLimit(runes, 2, width)
 	}
 	return runes, trimmed
 }
 
+// skipColumns returns the runes after the given number of columns on the
+// screen, and the number of the runes skipped
+func (t *Terminal) skipColumns(runes []rune, columns int) ([]rune, int) {
+	l := 0
+	idx := 0
+	for idx < len(runes) && l < columns {
+		length, w := util.NextGrapheme(runes[idx:], l, t.tabstop)
+		l += w
+		idx += length
+	}
+	return runes[idx:], idx
+}
+
+// hscrollList scrolls the overflowing items horizontally up to the end of the
+// widest item on the screen and returns true if the offset has changed
+func (t *Terminal) hscrollList(amount int) bool {
+	if t.wrap {
+		return false
+	}
+	maxWidth := t.window.Width() - (t.pointerLen + t.markerLen + t.bars.length() + 1)
+	widest := 0
+	for idx := t.offset; idx < util.Min(t.offset+t.maxItems(), t.merger.Length()); idx++ {
+		widest = util.Max(widest, t.displayWidth(t.merger.Get(idx).item.text.ToRunes()))
+	}
+	hoffset := util.Constrain(t.hoffset+amount, 0, util.Max(0, widest-(maxWidth-2)))
+	if hoffset == t.hoffset {
+		return false
+	}
+	t.hoffset = hoffset
+	return true
+}
+
+// hscrollPreview scrolls the preview window horizontally up to the end of the
+// widest line displayed unless the lines are wrapped
+func (t *Terminal) hscrollPreview(amount int) bool {
+	if t.previewOpts.wrap {
+		return false
+	}
+	xoffset := util.Constrain(t.previewer.xoffset+amount, 0, util.Max(0, t.previewer.width-t.pwindow.Width()))
+	if xoffset == t.previewer.xoffset {
+		return false
+	}
+	t.previewer.xoffset = xoffset
+	return true
+}
+
 func (t *Terminal) overflow(runes []rune, max int) bool {
 	return t.displayWidthWithLimit(runes, 0, max) > max
 }
 
-func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMatch tui.ColorPair, current bool, match bool) int {
+// highlightOffsets returns the text of the item and the offsets of the colors
+// to print it with. maxe is the end of the last match.
+func (t *Terminal) highlightOffsets(result Result, colBase tui.ColorPair, colMatch tui.ColorPair, current bool, match bool) ([]rune, []colorOffset, int, *[]int) {
 	item := result.item
+	colMatch = t.matchStyle.apply(colBase, colMatch)
 
 	// Overflow
 	text := make([]rune, item.text.Length())
@@ -1182,14 +2179,99 @@ func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMat
 		}
 		sort.Sort(ByOrder(charOffsets))
 	}
+	if item.search != nil {
+		// Matches in the hidden text of --search-field are not highlighted
+		visible := []Offset{}
+		for _, offset := range charOffsets {
+			if int(offset[0]) < len(text) {
+				visible = append(visible, offset)
+			}
+		}
+		charOffsets = visible
+	}
 	var maxe int
 	for _, offset := range charOffsets {
 		maxe = util.Max(maxe, int(offset[1]))
 	}
 
-	offsets := result.colorOffsets(charOffsets, t.theme, colBase, colMatch, current)
-	maxWidth := t.window.Width() - (t.pointerLen + t.markerLen + 1)
-	maxe = util.Constrain(maxe+util.Min(maxWidth/2-2, t.hscrollOff), 0, len(text))
+	var matchColors []tui.ColorPair
+	if pos != nil {
+		charOffsets, matchColors = t.termMatchColors(item, charOffsets, colBase, current)
+	}
+	offsets := result.colorOffsets(charOffsets, matchColors, t.theme, colBase, colMatch, current, t.ansiBg)
+	if t.formats != nil {
+		var convert func(int32, bool) int32
+		text, convert = t.formats.format(item)
+		for idx, offset := range offsets {
+			offsets[idx].offset = [2]int32{convert(offset.offset[0], false), convert(offset.offset[1], true)}
+		}
+		maxe = int(convert(int32(maxe), true))
+	}
+	return text, offsets, maxe, pos
+}
+
+// termMatchColors returns the colors of the matched characters in the order
+// of the offsets, which are from the palette of match-N colors by the term of
+// the query that matched each of them. The characters matched by multiple
+// terms are colored by the first one. It returns nil colors if the palette is
+// not defined or the query does not have multiple terms.
+func (t *Terminal) termMatchColors(item *Item, charOffsets []Offset, colBase tui.ColorPair, current bool) ([]Offset, []tui.ColorPair) {
+	palette := tui.ColTermMatches
+	if current {
+		palette = tui.ColCurrentTermMatches
+	}
+	if len(palette) == 0 {
+		return charOffsets, nil
+	}
+	termPos := t.merger.pattern.termPositions(item, t.slab)
+	if termPos == nil {
+		return charOffsets, nil
+	}
+	terms := make(map[int32]int)
+	for idx := len(termPos) - 1; idx >= 0; idx-- {
+		for _, p := range termPos[idx] {
+			terms[int32(p)] = idx
+		}
+	}
+	offsets := make([]Offset, 0, len(charOffsets))
+	colors := make([]tui.ColorPair, 0, len(charOffsets))
+	for idx, offset := range charOffsets {
+		if idx > 0 && offset == charOffsets[idx-1] {
+			continue
+		}
+		offsets = append(offsets, offset)
+		colors = append(colors, t.matchStyle.apply(colBase, palette[terms[offset[0]]%len(palette)]))
+	}
+	return offsets, colors
+}
+
+// printWrapped prints the text of the item on the given number of lines.
+// nextLine is called to move to the beginning of each line after the first.
+func (t *Terminal) printWrapped(result Result, colBase tui.ColorPair, colMatch tui.ColorPair, current bool, match bool, rows int, nextLine func(int)) {
+	text, offsets, _, _ := t.highlightOffsets(result, colBase, colMatch, current, match)
+	start := int32(0)
+	for k, line := range t.wrapLines(text) {
+		if k >= rows {
+			break
+		}
+		if k > 0 {
+			nextLine(k)
+			t.window.CPrint(colBase, t.wrapSign)
+		}
+		shifted := make([]colorOffset, len(offsets))
+		for idx, offset := range offsets {
+			shifted[idx] = offset
+			shifted[idx].offset = [2]int32{offset.offset[0] - start, offset.offset[1] - start}
+		}
+		t.printColored(line, shifted, colBase)
+		start += int32(len(line))
+	}
+}
+
+func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMatch tui.ColorPair, current bool, match bool) int {
+	text, offsets, maxe, pos := t.highlightOffsets(result, colBase, colMatch, current, match)
+	maxWidth := t.window.Width() - (t.pointerLen + t.markerLen + t.bars.length() + 1)
+	maxe = util.NextGraphemeBoundary(text, util.Constrain(maxe+util.Min(maxWidth/2-2, t.hscrollOff), 0, len(text)))
 	displayWidth := t.displayWidthWithLimit(text, 0, maxWidth)
 	if displayWidth > maxWidth {
 		transformOffsets := func(diff int32) {
@@ -1202,7 +2284,19 @@ func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMat
 				offsets[idx].offset[1] = util.Max32(b, e)
 			}
 		}
-		if t.hscroll {
+		if t.hoffset > 0 {
+			// ..ri.. (Scrolled horizontally by the user, but not beyond
+			// the end of the item)
+			skip := util.Min(t.hoffset, t.displayWidth(text)-(maxWidth-2))
+			var diff int
+			text, diff = t.skipColumns(text, skip)
+			transformOffsets(int32(diff))
+			if t.overflow(text, maxWidth-2) {
+				text, _ = t.trimRight(text, maxWidth-4)
+				text = append(text, []rune(ellipsis)...)
+			}
+			text = append([]rune(ellipsis), text...)
+		} else if t.hscroll {
 			if t.keepRight && pos == nil {
 				trimmed, diff := t.trimLeft(text, maxWidth-2)
 				transformOffsets(diff)
@@ -1235,7 +2329,13 @@ func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMat
 		}
 		displayWidth = t.displayWidthWithLimit(text, 0, displayWidth)
 	}
+	t.printColored(text, offsets, colBase)
+	return displayWidth
+}
 
+// printColored prints the text with the colors of the offsets. The parts of
+// the offsets out of the text are ignored.
+func (t *Terminal) printColored(text []rune, offsets []colorOffset, colBase tui.ColorPair) {
 	var index int32
 	var substr string
 	var prefixWidth int
@@ -1249,7 +2349,13 @@ func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMat
 
 		if b < e {
 			substr, prefixWidth = t.processTabs(text[b:e], prefixWidth)
+			if offset.url != nil {
+				t.window.LinkBegin(offset.url.uri, offset.url.params)
+			}
 			t.window.CPrint(offset.color, substr)
+			if offset.url != nil {
+				t.window.LinkEnd()
+			}
 		}
 
 		index = e
@@ -1261,7 +2367,6 @@ func (t *Terminal) printHighlighted(result Result, colBase tui.ColorPair, colMat
 		substr, _ = t.processTabs(text[index:], prefixWidth)
 		t.window.CPrint(colBase, substr)
 	}
-	return displayWidth
 }
 
 func (t *Terminal) renderPreviewSpinner() {
@@ -1291,14 +2396,43 @@ func (t *Terminal) renderPreviewSpinner() {
 	}
 }
 
+// renderPreviewScrollbar draws the scrollbar in the column between the
+// preview window and the right side of its border
+func (t *Terminal) renderPreviewScrollbar() {
+	if !t.scrollbar || t.pborder.Width() < 2 {
+		return
+	}
+	top := 0
+	if t.previewOpts.border != tui.BorderNone {
+		top = 1
+	}
+	height := t.pwindow.Height()
+	cells := scrollbarCells(height, len(t.previewer.lines), t.previewer.offset, false, t.unicode)
+	for i, cell := range cells {
+		t.pborder.Move(top+i, t.pborder.Width()-2)
+		color := tui.ColPreviewScrollbar
+		if cell.reverse {
+			color = color.WithAttr(tui.Reverse)
+		}
+		t.pborder.CPrint(color, cell.char)
+	}
+}
+
 func (t *Terminal) renderPreviewText(unchanged bool) {
 	maxWidth := t.pwindow.Width()
 	lineNo := -t.previewer.offset
 	height := t.pwindow.Height()
 	if unchanged {
+		// Redrawing the image on the first line would cause flickering
+		if offset := t.previewer.offset; offset >= 0 && offset < len(t.previewer.lines) {
+			if _, ok := parseImage(t.previewer.lines[offset]); ok {
+				return
+			}
+		}
 		t.pwindow.MoveAndClear(0, 0)
 	} else {
 		t.previewed.filled = false
+		t.previewer.width = 0
 		t.pwindow.Erase()
 	}
 	var ansi *ansiState
@@ -1312,21 +2446,47 @@ func (t *Terminal) renderPreviewText(unchanged bool) {
 			t.previewed.filled = true
 			break
 		} else if lineNo >= 0 {
+			if image, ok := parseImage(line); ok && t.pwindow.X() == 0 {
+				if t.pwindow.DrawImage(image) {
+					lineNo++
+					continue
+				}
+				// Cannot be displayed within the preview window
+				line = "[image]"
+			}
 			var fillRet tui.FillReturn
 			prefixWidth := 0
 			_, _, ansi = extractColor(line, ansi, func(str string, ansi *ansiState) bool {
 				trimmed := []rune(str)
 				if !t.previewOpts.wrap {
+					// Expand the tabs before skipping the columns scrolled
+					// horizontally
+					expanded, width := t.processTabs(trimmed, prefixWidth)
+					trimmed = []rune(expanded)
+					if skip := t.previewer.xoffset - prefixWidth; skip > 0 {
+						trimmed, _ = t.skipColumns(trimmed, skip)
+					}
+					prefixWidth = width
+					t.previewer.width = util.Max(t.previewer.width, width)
 					trimmed, _ = t.trimRight(trimmed, maxWidth-t.pwindow.X())
+					str = string(trimmed)
+				} else {
+					var width int
+					str, width = t.processTabs(trimmed, prefixWidth)
+					prefixWidth += width
+				}
+				if ansi != nil && ansi.url != nil {
+					t.pwindow.LinkBegin(ansi.url.uri, ansi.url.params)
 				}
-				str, width := t.processTabs(trimmed, prefixWidth)
-				prefixWidth += width
 				if t.theme.Colored && ansi != nil && ansi.colored() {
 					lbg = ansi.lbg
 					fillRet = t.pwindow.CFill(ansi.fg, ansi.bg, ansi.attr, str)
 				} else {
 					fillRet = t.pwindow.CFill(tui.ColPreview.Fg(), tui.ColPreview.Bg(), tui.AttrRegular, str)
 				}
+				if ansi != nil && ansi.url != nil {
+					t.pwindow.LinkEnd()
+				}
 				return fillRet == tui.FillContinue
 			})
 			t.previewer.scrollable = t.previewer.scrollable || t.pwindow.Y() == height-1 && t.pwindow.X() == t.pwindow.Width()
@@ -1361,13 +2521,16 @@ func (t *Terminal) printPreview() {
 	height := t.pwindow.Height()
 	unchanged := (t.previewed.filled || numLines == t.previewed.numLines) &&
 		t.previewer.version == t.previewed.version &&
-		t.previewer.offset == t.previewed.offset
+		t.previewer.offset == t.previewed.offset &&
+		t.previewer.xoffset == t.previewed.xoffset
 	t.previewer.scrollable = t.previewer.offset > 0 || numLines > height
 	t.renderPreviewText(unchanged)
 	t.renderPreviewSpinner()
+	t.renderPreviewScrollbar()
 	t.previewed.numLines = numLines
 	t.previewed.version = t.previewer.version
 	t.previewed.offset = t.previewer.offset
+	t.previewed.xoffset = t.previewer.xoffset
 }
 
 func (t *Terminal) printPreviewDelayed() {
@@ -1387,14 +2550,15 @@ func (t *Terminal) printPreviewDelayed() {
 func (t *Terminal) processTabs(runes []rune, prefixWidth int) (string, int) {
 	var strbuf bytes.Buffer
 	l := prefixWidth
-	for _, r := range runes {
-		w := util.RuneWidth(r, l, t.tabstop)
+	for idx := 0; idx < len(runes); {
+		length, w := util.NextGrapheme(runes[idx:], l, t.tabstop)
 		l += w
-		if r == '\t' {
+		if runes[idx] == '\t' {
 			strbuf.WriteString(strings.Repeat(" ", w))
 		} else {
-			strbuf.WriteRune(r)
+			strbuf.WriteString(string(runes[idx : idx+length]))
 		}
+		idx += length
 	}
 	return strbuf.String(), l
 }
@@ -1415,6 +2579,9 @@ func (t *Terminal) refresh() {
 		if t.borderShape != tui.BorderNone {
 			windows = append(windows, t.border)
 		}
+		if t.twindow != nil {
+			windows = append(windows, t.twindow)
+		}
 		if t.hasPreviewWindow() {
 			if t.pborder != nil {
 				windows = append(windows, t.pborder)
@@ -1422,6 +2589,9 @@ func (t *Terminal) refresh() {
 			windows = append(windows, t.pwindow)
 		}
 		windows = append(windows, t.window)
+		if t.popup != nil {
+			windows = append(windows, t.popupBorder, t.popup)
+		}
 		t.tui.RefreshWindows(windows)
 	}
 }
@@ -1434,6 +2604,25 @@ func (t *Terminal) delChar() bool {
 	return false
 }
 
+// wordPatterns returns the patterns of the beginnings and the ends of the
+// words for the word-wise movements with the boundaries in the words
+func wordPatterns(bounds algo.Boundaries) (string, string) {
+	word := "\\pL\\pN"
+	if !bounds.Snake {
+		word += "_"
+	}
+	transitions := ""
+	if bounds.Camel {
+		transitions += "|\\p{Ll}\\p{Lu}"
+	}
+	if bounds.Digit {
+		transitions += "|\\pL\\pN|\\pN\\pL"
+	}
+	rubout := fmt.Sprintf("[^%s][%s]%s", word, word, transitions)
+	next := fmt.Sprintf("[%s][^%s]%s|(.$)", word, word, transitions)
+	return rubout, next
+}
+
 func findLastMatch(pattern string, str string) int {
 	rx, err := regexp.Compile(pattern)
 	if err != nil {
@@ -1476,7 +2665,8 @@ func (t *Terminal) rubout(pattern string) {
 
 func keyMatch(key tui.Event, event tui.Event) bool {
 	return event.Type == key.Type && event.Char == key.Char ||
-		key.Type == tui.DoubleClick && event.Type == tui.Mouse && event.MouseEvent.Double
+		key.Type == tui.DoubleClick && event.Type == tui.Mouse && event.MouseEvent.Double ||
+		key.Type == tui.TripleClick && event.Type == tui.Mouse && event.MouseEvent.Triple
 }
 
 func quoteEntryCmd(entry string) string {
@@ -1503,6 +2693,10 @@ func parsePlaceholder(match string) (bool, string, placeholderFlags) {
 		return true, match[1:], flags
 	}
 
+	if strings.HasPrefix(match, "{form:") {
+		return false, match, flags
+	}
+
 	skipChars := 1
 	for _, char := range match[1:] {
 		switch char {
@@ -1532,7 +2726,11 @@ func parsePlaceholder(match string) (bool, string, placeholderFlags) {
 
 func hasPreviewFlags(template string) (slot bool, plus bool, query bool) {
 	for _, match := range placeholder.FindAllString(template, -1) {
-		_, _, flags := parsePlaceholder(match)
+		_, match, flags := parsePlaceholder(match)
+		if strings.HasPrefix(match, "{form:") {
+			// Not a slot for the items
+			continue
+		}
 		if flags.plus {
 			plus = true
 		}
@@ -1565,8 +2763,12 @@ func cleanTemporaryFiles() {
 }
 
 func (t *Terminal) replacePlaceholder(template string, forcePlus bool, input string, list []*Item) string {
-	return replacePlaceholder(
-		template, t.ansi, t.delimiter, t.printsep, forcePlus, input, list)
+	return t.replacePlaceholderWithForm(template, forcePlus, input, t.formValues(), list)
+}
+
+func (t *Terminal) replacePlaceholderWithForm(template string, forcePlus bool, input string, form map[string]string, list []*Item) string {
+	return replacePlaceholderWithForm(
+		template, t.ansi, t.delimiter, t.printsep, forcePlus, input, form, list)
 }
 
 // Ascii to positive integer
@@ -1582,8 +2784,8 @@ func atopi(s string) int {
 	return n
 }
 
-func (t *Terminal) evaluateScrollOffset(list []*Item, height int) int {
-	offsetExpr := t.replacePlaceholder(t.previewOpts.scroll, false, "", list)
+func (t *Terminal) evaluateScrollOffset(list []*Item, form map[string]string, height int) int {
+	offsetExpr := t.replacePlaceholderWithForm(t.previewOpts.scroll, false, "", form, list)
 	nums := strings.Split(offsetExpr, "-")
 	switch len(nums) {
 	case 0:
@@ -1608,7 +2810,7 @@ func (t *Terminal) evaluateScrollOffset(list []*Item, height int) int {
 	}
 }
 
-func replacePlaceholder(template string, stripAnsi bool, delimiter Delimiter, printsep string, forcePlus bool, query string, allItems []*Item) string {
+func replacePlaceholderWithForm(template string, stripAnsi bool, delimiter Delimiter, printsep string, forcePlus bool, query string, form map[string]string, allItems []*Item) string {
 	current := allItems[:1]
 	selected := allItems[1:]
 	if current[0] == nil {
@@ -1629,6 +2831,14 @@ func replacePlaceholder(template string, stripAnsi bool, delimiter Delimiter, pr
 			return quoteEntry(query)
 		}
 
+		// Value of the form field
+		if strings.HasPrefix(match, "{form:") {
+			if value, prs := form[match[6:len(match)-1]]; prs {
+				return quoteEntry(value)
+			}
+			return match
+		}
+
 		items := current
 		if flags.plus || forcePlus {
 			items = selected
@@ -1695,34 +2905,263 @@ func replacePlaceholder(template string, stripAnsi bool, delimiter Delimiter, pr
 	})
 }
 
+// Resizes the finder to fit the current list with --height=auto. Returns true
+// if the whole screen is redrawn.
+func (t *Terminal) fitToList() bool {
+	if t.fitHeight == nil {
+		return false
+	}
+	// Lines for the prompt, the info, the header, margins, and borders
+	lines := t.tui.MaxY() - t.maxItems() + t.merger.Length()
+	if lines == t.fitLines {
+		return false
+	}
+	t.fitLines = lines
+	if !t.tui.Resize(t.fitHeight(lines)) {
+		return false
+	}
+	t.redraw()
+	return true
+}
+
 func (t *Terminal) redraw() {
 	t.tui.Clear()
 	t.tui.Refresh()
 	t.printAll()
 }
 
-func (t *Terminal) executeCommand(template string, forcePlus bool, background bool) {
+// Runs --on-accept and --on-exit commands with the query and the selected
+// items exported as environment variables
+func runExitHooks(onAccept string, onExit string, code int, query string, selections []string) {
+	run := func(command string) {
+		cmd := util.ExecCommand(command, false)
+		cmd.Env = append(os.Environ(),
+			"FZF_QUERY="+query,
+			"FZF_SELECTIONS="+strings.Join(selections, "\n"),
+			"FZF_EXIT_CODE="+strconv.Itoa(code))
+		// Standard output is reserved for the output of fzf
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Run()
+	}
+	if code == exitOk && len(onAccept) > 0 {
+		run(onAccept)
+	}
+	if len(onExit) > 0 {
+		run(onExit)
+	}
+}
+
+// exitSelections returns the items passed to the exit hooks. Nothing is
+// selected when fzf is interrupted or exits with an error.
+func (t *Terminal) exitSelections(code int) []string {
+	selections := []string{}
+	if code > exitNoMatch {
+		return selections
+	}
+	for _, item := range t.acceptedItems() {
+		selections = append(selections, item.AsString(t.ansi))
+	}
+	return selections
+}
+
+// executeCommand runs the command and returns the error if it could not be
+// started. The exit status of the command is not an error except for the ones
+// of the shell for the commands not found or not executable, as the other
+// statuses are common in normal use, such as grep without a match or a pager
+// quit with ctrl-c.
+func (t *Terminal) executeCommand(template string, forcePlus bool, background bool) error {
 	valid, list := t.buildPlusList(template, forcePlus)
 	if !valid {
-		return
+		return nil
 	}
 	command := t.replacePlaceholder(template, forcePlus, string(t.input), list)
 	cmd := util.ExecCommand(command, false)
+	var err error
 	if !background {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		t.tui.Pause(true)
-		cmd.Run()
+		err = cmd.Run()
 		t.tui.Resume(true, false)
 		t.redraw()
 		t.refresh()
 	} else {
 		t.tui.Pause(false)
-		cmd.Run()
+		err = cmd.Run()
 		t.tui.Resume(false, false)
 	}
 	cleanTemporaryFiles()
+	if exitError, exited := err.(*exec.ExitError); exited {
+		if code := exitError.ExitCode(); code == exitNotFound || code == exitNotExecutable {
+			return fmt.Errorf("%s: %s", err.Error(), command)
+		}
+		return nil
+	}
+	return err
+}
+
+// executeStatus runs the command in the background and shows the last line of
+// its output on the info line until a while after it exits. The command
+// running is killed.
+func (t *Terminal) executeStatus(template string) {
+	valid, list := t.buildPlusList(template, false)
+	if !valid {
+		return
+	}
+	t.cancelStatus()
+	command := t.replacePlaceholder(template, false, string(t.input), list)
+	cmd := util.ExecCommand(command, true)
+	out, _ := cmd.StdoutPipe()
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		t.statusCmd, t.statusLine, t.statusDone = cmd, err.Error(), true
+		go t.clearStatus(cmd)
+		return
+	}
+	t.statusCmd, t.statusLine, t.statusDone = cmd, "", false
+	go func() {
+		scanner := bufio.NewScanner(out)
+		scanner.Split(scanStatusLines)
+		last := ""
+		for scanner.Scan() {
+			line, _, _ := extractColor(string(util.DecodeLocale(scanner.Bytes())), nil, nil)
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				last = line
+				t.reqBox.Set(reqStatus, statusUpdate{cmd, last, false})
+			}
+		}
+		// Not to block the command on the line too long to scan
+		io.Copy(ioutil.Discard, out)
+		if err := cmd.Wait(); err != nil {
+			last = strings.TrimSpace(last + " [" + err.Error() + "]")
+		}
+		cleanTemporaryFiles()
+		t.reqBox.Set(reqStatus, statusUpdate{cmd, last, true})
+		t.clearStatus(cmd)
+	}()
+}
+
+// scanStatusLines splits the output into the lines ending with a carriage
+// return or a line feed, so that the progress overwriting the line is shown
+func scanStatusLines(data []byte, atEOF bool) (int, []byte, error) {
+	if idx := bytes.IndexAny(data, "\r\n"); idx >= 0 {
+		return idx + 1, data[:idx], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// clearStatus removes the output of the command from the info line after a
+// while
+func (t *Terminal) clearStatus(cmd *exec.Cmd) {
+	time.Sleep(statusDuration)
+	t.reqBox.Set(reqStatusClear, cmd)
+}
+
+// cancelStatus kills the command of execute-status if running
+func (t *Terminal) cancelStatus() bool {
+	if t.statusCmd == nil || t.statusDone {
+		return false
+	}
+	util.KillCommand(t.statusCmd)
+	return true
+}
+
+// Replaces fzf with the command. The state of the finder is passed to the
+// command via environment variables if requested. The error is returned only
+// if the command could not replace fzf, after the screen is closed.
+func (t *Terminal) become(template string, withState bool) error {
+	valid, list := t.buildPlusList(template, false)
+	if !valid {
+		return nil
+	}
+	// Tear down the finder as we do on exit. The temporary files are removed
+	// before the command is built as it may refer to its own files.
+	t.bellOff()
+	t.tui.Close()
+	t.cancelStatus()
+	t.killPreview(exitBecome)
+	cleanTemporaryFiles()
+	command := t.replacePlaceholder(template, false, string(t.input), list)
+	env := os.Environ()
+	if withState {
+		env = append(env, t.stateEnv()...)
+	}
+	if t.history != nil {
+		t.history.append(string(t.input))
+	}
+	// The command replacing fzf is not an accepted item, so only the command
+	// of --on-exit is executed
+	if len(t.onExit) > 0 {
+		runExitHooks("", t.onExit, exitOk, string(t.input), t.exitSelections(exitOk))
+	}
+	return util.Become(command, env)
+}
+
+// transformEnv returns the environment variables describing the current state
+// of the finder for the command of transform action
+func (t *Terminal) transformEnv() []string {
+	env := []string{
+		"FZF_QUERY=" + string(t.input),
+		"FZF_POS=" + strconv.Itoa(util.Min(t.cy+1, t.merger.Length())),
+		"FZF_MATCH_COUNT=" + strconv.Itoa(t.merger.Length()),
+		"FZF_SELECT_COUNT=" + strconv.Itoa(len(t.selected)),
+		"FZF_TOTAL_COUNT=" + strconv.Itoa(t.count)}
+	if len(t.tabs) > 0 {
+		env = append(env, "FZF_TAB="+t.tabs[t.tab].name)
+	}
+	return env
+}
+
+// commandError returns the error of the command with the first line of its
+// error message if any
+func commandError(err error) error {
+	if exitError, ok := err.(*exec.ExitError); ok && len(exitError.Stderr) > 0 {
+		message := strings.Trim(string(util.DecodeLocale(exitError.Stderr)), "\r\n")
+		return errors.New(err.Error() + ": " + strings.SplitN(message, "\n", 2)[0])
+	}
+	return err
+}
+
+// transform runs the command and returns the actions printed to its standard
+// output. The error is returned if the command failed or printed invalid
+// actions.
+func (t *Terminal) transform(template string) ([]action, error) {
+	valid, list := t.buildPlusList(template, false)
+	if !valid {
+		return nil, nil
+	}
+	command := t.replacePlaceholder(template, false, string(t.input), list)
+	cmd := util.ExecCommand(command, false)
+	cmd.Env = append(os.Environ(), t.transformEnv()...)
+	out, err := cmd.Output()
+	cleanTemporaryFiles()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	var parseError error
+	output := strings.Trim(string(util.DecodeLocale(out)), "\r\n")
+	actions := parseSingleActionList(output, func(message string) {
+		parseError = errors.New(message)
+	})
+	return actions, parseError
+}
+
+// filterPaste runs --paste-filter command with the pasted text given to its
+// standard input, and returns its output to insert instead
+func (t *Terminal) filterPaste(text string) (string, error) {
+	cmd := util.ExecCommand(t.pasteFilter, false)
+	cmd.Env = append(os.Environ(), t.transformEnv()...)
+	cmd.Stdin = strings.NewReader(text)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	return string(util.DecodeLocale(out)), nil
 }
 
 func (t *Terminal) hasPreviewer() bool {
@@ -1737,6 +3176,142 @@ func (t *Terminal) hasPreviewWindow() bool {
 	return t.pwindow != nil && t.isPreviewEnabled()
 }
 
+// changeNth changes the fields to match to the expression following the
+// current one in the expressions separated by bars. An empty expression
+// restores --nth.
+func (t *Terminal) changeNth(exprs string) {
+	alternatives := strings.Split(exprs, "|")
+	next := alternatives[0]
+	for idx, expr := range alternatives {
+		if expr == t.nthExpr {
+			next = alternatives[(idx+1)%len(alternatives)]
+			break
+		}
+	}
+	t.nthExpr = next
+	t.nth = t.nthOrig
+	if len(next) > 0 {
+		t.nth, _ = parseWeightedNth(next)
+	}
+	t.fieldsRev++
+}
+
+// combineQuery starts a new query whose match set is combined with the one of
+// the current query by the operation
+func (t *Terminal) combineQuery(op setOperation) bool {
+	if len(t.input) == 0 {
+		return false
+	}
+	// The slice is shared with the search requests
+	sets := make([]querySet, len(t.querySets), len(t.querySets)+1)
+	copy(sets, t.querySets)
+	t.querySets = append(sets, querySet{string(t.input), op})
+	t.input = []rune{}
+	t.cx = 0
+	return true
+}
+
+// uncombineQuery cancels the last operation and restores its query
+func (t *Terminal) uncombineQuery() bool {
+	if len(t.querySets) == 0 {
+		return false
+	}
+	last := t.querySets[len(t.querySets)-1]
+	t.querySets = t.querySets[:len(t.querySets)-1]
+	t.input = []rune(last.query)
+	t.cx = len(t.input)
+	return true
+}
+
+// changeScheme changes the scoring scheme to the one following the current
+// one in the names separated by bars. An empty name restores --scheme.
+func (t *Terminal) changeScheme(schemes string) {
+	alternatives := strings.Split(schemes, "|")
+	next := alternatives[0]
+	for idx, scheme := range alternatives {
+		if scheme == t.scheme {
+			next = alternatives[(idx+1)%len(alternatives)]
+			break
+		}
+	}
+	if len(next) == 0 {
+		next = t.schemeOrig
+	}
+	t.scheme = next
+}
+
+// choosePreviewLayout applies the first alternative layout of the preview
+// window whose threshold is greater than the width of the screen, or the
+// default layout. The visibility of the window only follows the layout when
+// the layout changes, so that the window can still be toggled.
+func (t *Terminal) choosePreviewLayout(width int) {
+	rules := t.previewBase.rules
+	index := len(rules)
+	for idx, rule := range rules {
+		if width < rule.threshold {
+			index = idx
+			break
+		}
+	}
+	if index == t.previewRule {
+		return
+	}
+	t.previewRule = index
+	t.previewOpts = t.previewBase
+	if index < len(rules) {
+		parsePreviewTokens(&t.previewOpts, rules[index].spec)
+	}
+	if enabled := !t.previewOpts.hidden; enabled != t.previewer.enabled {
+		t.previewer.enabled = enabled
+		if valid, list := t.buildPlusList(t.previewOpts.command, false); enabled && valid {
+			t.cancelPreview()
+			t.enqueuePreview(t.previewOpts.command, list)
+		}
+	}
+}
+
+// previewLines returns the output of the preview command, or the error
+// messages of the failed commands before it if debugging
+func (t *Terminal) previewLines() []string {
+	if !t.previewer.debugging {
+		return t.previewer.output
+	}
+	if len(t.previewer.debug) == 0 {
+		return []string{"No failed preview commands"}
+	}
+	return t.previewer.debug
+}
+
+// emitSequences passes the OSC sequences in the new lines of the preview
+// output to the terminal, such as the ones to set the clipboard
+func (t *Terminal) emitSequences(lines []string) {
+	for _, line := range lines[util.Min(t.previewer.emitted, len(lines)):] {
+		for _, sequence := range passedSequences(line, t.previewOSCs) {
+			t.tui.Emit(sequence)
+		}
+	}
+	t.previewer.emitted = len(lines)
+}
+
+// startChord waits for the next key of the chord, which is cancelled unless
+// the key is pressed within the interval
+func (t *Terminal) startChord(chord *keyChord) {
+	started := time.Now()
+	t.chord, t.chordTime = chord, started
+	go func() {
+		time.Sleep(t.chordIntvl)
+		t.mutex.Lock()
+		expired := t.chord != nil && t.chordTime == started
+		if expired {
+			t.chord = nil
+		}
+		t.mutex.Unlock()
+		if expired {
+			t.reqBox.Set(reqInfo, nil)
+		}
+	}()
+}
+
 func (t *Terminal) currentItem() *Item {
 	cnt := t.merger.Length()
 	if t.cy >= 0 && cnt > 0 && cnt > t.cy {
@@ -1774,6 +3349,11 @@ func (t *Terminal) buildPlusList(template string, forcePlus bool) (bool, []*Item
 }
 
 func (t *Terminal) selectItem(item *Item) bool {
+	// Disabled items are silently skipped so that the items after them can
+	// still be selected by select-all
+	if t.disabled.has(item) {
+		return true
+	}
 	if len(t.selected) >= t.multi {
 		return false
 	}
@@ -1811,18 +3391,124 @@ func (t *Terminal) toggleItem(item *Item) bool {
 	if _, found := t.selected[item.Index()]; !found {
 		return t.selectItem(item)
 	}
-	t.deselectItem(item)
-	return true
+	t.deselectItem(item)
+	return true
+}
+
+// dragSelect toggles the items passed by the mouse drag from the anchor to the
+// given index. The items no longer in the range are toggled back when the
+// drag turns back, so each item in the range is toggled exactly once.
+func (t *Terminal) dragSelect(idx int) bool {
+	count := t.merger.Length()
+	if t.dragFrom >= count {
+		// The list has shrunk since the drag started
+		t.dragFrom = -1
+		return false
+	}
+	idx = util.Constrain(idx, 0, count-1)
+	if idx == t.dragTo || t.dragTo < 0 && idx == t.dragFrom {
+		return false
+	}
+	within := func(i int, to int) bool {
+		return to >= 0 && i >= util.Min(t.dragFrom, to) && i <= util.Max(t.dragFrom, to)
+	}
+	from := util.Min(t.dragFrom, idx)
+	to := util.Max(t.dragFrom, idx)
+	if t.dragTo >= 0 {
+		from = util.Min(from, t.dragTo)
+		to = util.Max(to, t.dragTo)
+	}
+	for i := from; i <= to; i++ {
+		if within(i, t.dragTo) != within(i, idx) {
+			t.toggleItem(t.merger.Get(i).item)
+		}
+	}
+	t.dragTo = idx
+	t.vset(idx)
+	return true
+}
+
+// enqueuePreview requests the preview of the items with the command
+func (t *Terminal) enqueuePreview(command string, list []*Item) {
+	t.previewer.requested++
+	t.previewer.final = false
+	t.previewBox.Set(reqPreviewEnqueue, previewRequest{command, t.pwindow, list, t.formValues(), t.previewer.requested})
+}
+
+// frameComplete tells if the screen shows the final result of the search and
+// the preview of the current item, if any
+func (t *Terminal) frameComplete(listed *Merger) bool {
+	return !t.suppress && !t.reading && listed == t.merger && t.merger.final &&
+		(t.previewer.requested == 0 || t.previewer.final)
+}
+
+// printFrame prints the screen to the standard output for --render-once and
+// returns the exit code. The exit hooks are not run.
+func (t *Terminal) printFrame() int {
+	t.tui.Close()
+	if virtual, ok := t.tui.(*tui.VirtualRenderer); ok {
+		if t.renderOnce == renderANSI {
+			t.printer(virtual.ANSI())
+		} else {
+			t.printer(virtual.String())
+		}
+	}
+	if t.merger.Length() == 0 {
+		return exitNoMatch
+	}
+	return exitOk
+}
+
+// killPreview kills the running preview command and waits until it is
+// killed. exitCancel only cancels the command, which is killed if it does not
+// finish in time.
+func (t *Terminal) killPreview(code int) {
+	select {
+	case t.killChan <- code:
+		if code != exitCancel {
+			<-t.killedChan
+		}
+	default:
+	}
+}
+
+// ringBell gives the feedback in the style. The visual feedback is turned
+// off by a request after a while, which is postponed by the following bells,
+// so that the requests are not blocked in the meantime.
+func (t *Terminal) ringBell(style tui.BellStyle) {
+	t.tui.Bell(style)
+	if style != tui.BellVisual && style != tui.BellFlashBorder {
+		return
+	}
+	t.bellsOn[style] = true
+	t.bellSeq++
+	seq := t.bellSeq
+	time.AfterFunc(tui.BellDuration, func() {
+		t.reqBox.Set(reqBellOff, seq)
+	})
+}
+
+// bellOff turns off the visual bells
+func (t *Terminal) bellOff() {
+	for style := range t.bellsOn {
+		t.tui.BellOff(style)
+	}
+	t.bellsOn = make(map[tui.BellStyle]bool)
 }
 
-func (t *Terminal) killPreview(code int) {
-	select {
-	case t.killChan <- code:
-	default:
-		if code != exitCancel {
-			os.Exit(code)
-		}
+// quit stops the goroutines of the finder and hands the exit code to the
+// event loop of Run. It is called by the goroutine processing the requests
+// as the last thing it does.
+func (t *Terminal) quit(code int) {
+	t.killPreview(code)
+	t.mutex.Lock()
+	t.killBackground()
+	t.mutex.Unlock()
+	close(t.done)
+	if t.hasPreviewer() {
+		t.previewBox.Set(reqQuit, nil)
 	}
+	t.eventBox.Set(EvtQuit, code)
 }
 
 func (t *Terminal) cancelPreview() {
@@ -1836,26 +3522,25 @@ func (t *Terminal) Loop() {
 	{ // Late initialization
 		intChan := make(chan os.Signal, 1)
 		signal.Notify(intChan, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-intChan
-			t.reqBox.Set(reqQuit, nil)
-		}()
-
 		contChan := make(chan os.Signal, 1)
 		notifyOnCont(contChan)
-		go func() {
-			for {
-				<-contChan
-				t.reqBox.Set(reqReinit, nil)
-			}
-		}()
-
 		resizeChan := make(chan os.Signal, 1)
 		notifyOnResize(resizeChan) // Non-portable
 		go func() {
+			defer signal.Stop(intChan)
+			defer signal.Stop(contChan)
+			defer signal.Stop(resizeChan)
 			for {
-				<-resizeChan
-				t.reqBox.Set(reqRedraw, nil)
+				select {
+				case <-intChan:
+					t.reqBox.Set(reqQuit, nil)
+				case <-contChan:
+					t.reqBox.Set(reqReinit, nil)
+				case <-resizeChan:
+					t.reqBox.Set(reqRedraw, nil)
+				case <-t.done:
+					return
+				}
 			}
 		}()
 
@@ -1869,22 +3554,56 @@ func (t *Terminal) Loop() {
 		t.mutex.Unlock()
 		go func() {
 			timer := time.NewTimer(t.initDelay)
-			<-timer.C
-			t.reqBox.Set(reqRefresh, nil)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				t.reqBox.Set(reqRefresh, nil)
+			case <-t.done:
+			}
 		}()
 
+		// Turn the hints over
+		if len(t.hints) > 1 {
+			go func() {
+				ticker := time.NewTicker(hintDuration)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						t.reqBox.Set(reqHeader, nil)
+					case <-t.done:
+						return
+					}
+				}
+			}()
+		}
+
 		// Keep the spinner spinning
 		go func() {
+			ticker := time.NewTicker(spinnerDuration)
+			defer ticker.Stop()
 			for {
 				t.mutex.Lock()
-				reading := t.reading
+				reading := t.reading || t.statusRunning()
 				t.mutex.Unlock()
-				time.Sleep(spinnerDuration)
+				select {
+				case <-ticker.C:
+				case <-t.done:
+					return
+				}
 				if reading {
 					t.reqBox.Set(reqInfo, nil)
 				}
 			}
 		}()
+
+		// Share the history with the other processes using the same file.
+		// It is not an error if the file cannot be watched.
+		if t.history != nil {
+			watchHistory(t.history.path, func() {
+				t.reqBox.Set(reqHistory, nil)
+			})
+		}
 	}
 
 	if t.hasPreviewer() {
@@ -1892,54 +3611,92 @@ func (t *Terminal) Loop() {
 			var version int64
 			for {
 				var items []*Item
+				var form map[string]string
 				var commandTemplate string
 				var pwindow tui.Window
+				var seq int64
+				quit := false
 				t.previewBox.Wait(func(events *util.Events) {
 					for req, value := range *events {
 						switch req {
+						case reqQuit:
+							quit = true
 						case reqPreviewEnqueue:
 							request := value.(previewRequest)
 							commandTemplate = request.template
 							items = request.list
+							form = request.form
 							pwindow = request.pwindow
+							seq = request.seq
 						}
 					}
 					events.Clear()
 				})
+				if quit {
+					return
+				}
 				version++
 				// We don't display preview window if no match
 				if items[0] != nil {
 					_, query := t.Input()
-					command := t.replacePlaceholder(commandTemplate, false, string(query), items)
 					initialOffset := 0
-					cmd := util.ExecCommand(command, true)
 					if pwindow != nil {
-						height := pwindow.Height()
-						initialOffset = util.Max(0, t.evaluateScrollOffset(items, height))
-						env := os.Environ()
-						lines := fmt.Sprintf("LINES=%d", height)
-						columns := fmt.Sprintf("COLUMNS=%d", pwindow.Width())
-						env = append(env, lines)
-						env = append(env, "FZF_PREVIEW_"+lines)
-						env = append(env, columns)
-						env = append(env, "FZF_PREVIEW_"+columns)
-						cmd.Env = env
+						initialOffset = util.Max(0, t.evaluateScrollOffset(items, form, pwindow.Height()))
 					}
+					// The commands are tried in order until one of them succeeds
+					templates := append([]string{commandTemplate}, t.fallbacks...)
+					debug := []string{}
+					for idx, template := range templates {
+						command := t.replacePlaceholderWithForm(template, false, string(query), form, items)
+						cmd := util.ExecCommand(command, true)
+						if pwindow != nil {
+							height := pwindow.Height()
+							env := os.Environ()
+							lines := fmt.Sprintf("LINES=%d", height)
+							columns := fmt.Sprintf("COLUMNS=%d", pwindow.Width())
+							env = append(env, lines)
+							env = append(env, "FZF_PREVIEW_"+lines)
+							env = append(env, columns)
+							env = append(env, "FZF_PREVIEW_"+columns)
+							cmd.Env = env
+						}
 
-					out, _ := cmd.StdoutPipe()
-					cmd.Stderr = cmd.Stdout
-					reader := bufio.NewReader(out)
-					eofChan := make(chan bool)
-					finishChan := make(chan bool, 1)
-					err := cmd.Start()
-					if err == nil {
+						// The error messages of the command followed by a fallback are
+						// not displayed but kept for toggle-preview-debug
+						last := idx == len(templates)-1
+						var stderr bytes.Buffer
+						out, _ := cmd.StdoutPipe()
+						if last {
+							cmd.Stderr = cmd.Stdout
+						} else {
+							cmd.Stderr = &stderr
+						}
+						failed := func(status string) {
+							debug = append(debug, fmt.Sprintf("[%d/%d] %s (%s)", idx+1, len(templates), command, status))
+							debug = append(debug, strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")...)
+						}
+						reader := bufio.NewReader(out)
+						eofChan := make(chan bool)
+						finishChan := make(chan bool, 1)
+						err := cmd.Start()
+						if err != nil {
+							if !last {
+								failed(err.Error())
+								continue
+							}
+							// Failed to start the command. Report the error immediately.
+							t.reqBox.Set(reqPreviewDisplay, previewResult{version, []string{err.Error()}, 0, "", debug})
+							break
+						}
+						killed := false
+						var buffered *previewResult
 						reapChan := make(chan bool)
 						lineChan := make(chan eachLine)
 						// Goroutine 1 reads process output
 						go func() {
 							for {
 								line, err := reader.ReadString('\n')
-								lineChan <- eachLine{line, err}
+								lineChan <- eachLine{string(util.DecodeLocale([]byte(line))), err}
 								if err != nil {
 									break
 								}
@@ -1947,8 +3704,9 @@ func (t *Terminal) Loop() {
 							eofChan <- true
 						}()
 
-						// Goroutine 2 periodically requests rendering
-						go func(version int64) {
+						// Goroutine 2 periodically requests rendering. The output of the
+						// command followed by a fallback is buffered until it succeeds.
+						go func(version int64, debug []string) {
 							lines := []string{}
 							spinner := makeSpinner(t.unicode)
 							spinnerIndex := -1 // Delay initial rendering by an extra tick
@@ -1958,10 +3716,10 @@ func (t *Terminal) Loop() {
 							for {
 								select {
 								case <-ticker.C:
-									if len(lines) > 0 && len(lines) >= initialOffset {
+									if last && len(lines) > 0 && len(lines) >= initialOffset {
 										if spinnerIndex >= 0 {
 											spin := spinner[spinnerIndex%len(spinner)]
-											t.reqBox.Set(reqPreviewDisplay, previewResult{version, lines, offset, spin})
+											t.reqBox.Set(reqPreviewDisplay, previewResult{version, lines, offset, spin, debug})
 											offset = -1
 										}
 										spinnerIndex++
@@ -1980,14 +3738,19 @@ func (t *Terminal) Loop() {
 										lines = append(lines, line)
 									}
 									if err != nil {
-										t.reqBox.Set(reqPreviewDisplay, previewResult{version, lines, offset, ""})
+										result := previewResult{version, lines, offset, "", debug}
+										if last {
+											t.reqBox.Set(reqPreviewDisplay, result)
+										} else {
+											buffered = &result
+										}
 										break Loop
 									}
 								}
 							}
 							ticker.Stop()
 							reapChan <- true
-						}(version)
+						}(version, debug)
 
 						// Goroutine 3 is responsible for cancelling running preview command
 						go func(version int64) {
@@ -1998,9 +3761,10 @@ func (t *Terminal) Loop() {
 								case <-timer.C:
 									t.reqBox.Set(reqPreviewDelayed, version)
 								case code := <-t.killChan:
+									killed = true
 									if code != exitCancel {
 										util.KillCommand(cmd)
-										os.Exit(code)
+										t.killedChan <- true
 									} else {
 										timer := time.NewTimer(previewCancelWait)
 										select {
@@ -2024,45 +3788,75 @@ func (t *Terminal) Loop() {
 						finishChan <- true // Tell Goroutine 3 to stop
 						<-reapChan         // Goroutine 2 and 3 finished
 						<-reapChan
-					} else {
-						// Failed to start the command. Report the error immediately.
-						t.reqBox.Set(reqPreviewDisplay, previewResult{version, []string{err.Error()}, 0, ""})
+						if last || killed {
+							break
+						}
+						if cmd.ProcessState.Success() {
+							t.reqBox.Set(reqPreviewDisplay, *buffered)
+							break
+						}
+						failed(cmd.ProcessState.String())
+						version++
 					}
 
 					cleanTemporaryFiles()
 				} else {
-					t.reqBox.Set(reqPreviewDisplay, previewResult{version, nil, 0, ""})
+					t.reqBox.Set(reqPreviewDisplay, previewResult{version, nil, 0, "", nil})
 				}
+				t.reqBox.Set(reqPreviewDone, seq)
 			}
 		}()
 	}
 
+	// The exit code is set by the request closing the finder
+	var exitCode *int
 	exit := func(getCode func() int) {
+		t.bellOff()
 		t.tui.Close()
+		t.cancelStatus()
 		code := getCode()
 		if code <= exitNoMatch && t.history != nil {
 			t.history.append(string(t.input))
 		}
+		if code <= exitNoMatch && t.rankLog != nil {
+			t.rankLog.record(string(t.input), t.merger, t.acceptedItems(), t.ansi)
+		}
+		if len(t.onAccept) > 0 || len(t.onExit) > 0 {
+			runExitHooks(t.onAccept, t.onExit, code, string(t.input), t.exitSelections(code))
+		}
 		// prof.Stop()
-		t.killPreview(code)
+		exitCode = &code
 	}
 
 	refreshPreview := func(command string) {
 		if len(command) > 0 && t.isPreviewEnabled() {
 			_, list := t.buildPlusList(command, false)
 			t.cancelPreview()
-			t.previewBox.Set(reqPreviewEnqueue, previewRequest{command, t.pwindow, list})
+			t.enqueuePreview(command, list)
 		}
 	}
 
 	go func() {
 		var focusedIndex int32 = minItem.Index()
 		var version int64 = -1
-		for {
+		var listed *Merger // The result of the search on the screen
+		for exitCode == nil {
+			if wait := t.pacer.wait(time.Now()); wait > 0 {
+				// The requests in the meantime are coalesced into the next
+				// refresh
+				time.Sleep(wait)
+			}
 			t.reqBox.Wait(func(events *util.Events) {
 				defer events.Clear()
 				t.mutex.Lock()
+				bell := false
+				bellOff := false
+				flash := false
+				var drawn *string // Only the latest one as they overwrite each other
 				for req, value := range *events {
+					if exitCode != nil {
+						break
+					}
 					switch req {
 					case reqPrompt:
 						t.printPrompt()
@@ -2072,7 +3866,10 @@ func (t *Terminal) Loop() {
 					case reqInfo:
 						t.printInfo()
 					case reqList:
-						t.printList()
+						if !t.fitToList() {
+							t.printList()
+						}
+						listed = t.merger
 						var currentIndex int32 = minItem.Index()
 						currentItem := t.currentItem()
 						if currentItem != nil {
@@ -2109,8 +3906,13 @@ func (t *Terminal) Loop() {
 						if t.previewer.version != result.version {
 							t.previewer.version = result.version
 							t.previewer.following = t.previewOpts.follow
+							t.previewer.xoffset = 0
+							t.previewer.emitted = 0
 						}
-						t.previewer.lines = result.lines
+						t.emitSequences(result.lines)
+						t.previewer.output = result.lines
+						t.previewer.debug = result.debug
+						t.previewer.lines = t.previewLines()
 						t.previewer.spinner = result.spinner
 						if t.previewer.following {
 							t.previewer.offset = len(t.previewer.lines) - t.pwindow.Height()
@@ -2123,6 +3925,20 @@ func (t *Terminal) Loop() {
 					case reqPreviewDelayed:
 						t.previewer.version = value.(int64)
 						t.printPreviewDelayed()
+					case reqHistory:
+						t.history.reload()
+					case reqStatus:
+						if update := value.(statusUpdate); update.cmd == t.statusCmd {
+							t.statusLine, t.statusDone = update.line, update.done
+							t.printInfo()
+						}
+					case reqStatusClear:
+						if value.(*exec.Cmd) == t.statusCmd {
+							t.statusCmd = nil
+							t.printInfo()
+						}
+					case reqPreviewDone:
+						t.previewer.final = value.(int64) == t.previewer.requested
 					case reqPrintQuery:
 						exit(func() int {
 							t.printer(string(t.input))
@@ -2130,31 +3946,140 @@ func (t *Terminal) Loop() {
 						})
 					case reqQuit:
 						exit(func() int { return exitInterrupt })
+					case reqBell:
+						bell = true
+					case reqBellOff:
+						bellOff = value.(int) == t.bellSeq
+					case reqFlash:
+						flash = true
+					case reqDrawRegion:
+						str := value.(string)
+						drawn = &str
+					case reqBackground:
+						if result := value.(backgroundResult); result.size == t.bgSize {
+							t.tui.Background(result.output)
+						}
 					}
 				}
+				if exitCode != nil {
+					t.mutex.Unlock()
+					return
+				}
+				if t.chord != nil {
+					t.printChordPopup()
+				} else if t.toast != nil {
+					t.printToast()
+				} else if t.bindings != nil {
+					t.printBindingsPopup()
+				} else if t.picker != nil {
+					t.printRegisterPopup()
+				} else if t.menuOpen != nil {
+					t.printMenuPopup()
+				} else if t.popup != nil {
+					t.closePopup()
+				}
+				start := time.Now()
 				t.refresh()
+				t.pacer.measure(start, time.Now())
+				if t.renderOnce != renderNone && t.frameComplete(listed) {
+					code := t.printFrame()
+					exitCode = &code
+					t.mutex.Unlock()
+					return
+				}
+				if bellOff {
+					t.bellOff()
+				}
+				if bell {
+					// After the refresh so that the feedback is given on the
+					// updated screen
+					t.ringBell(t.bellStyle)
+				}
+				if flash {
+					t.ringBell(tui.BellVisual)
+				}
+				if drawn != nil {
+					t.tui.DrawReserved(*drawn)
+				}
 				t.mutex.Unlock()
 			})
 		}
+		t.quit(*exitCode)
 	}()
 
+	// With the listen server or zero event, the input is waited for in a
+	// separate goroutine so that their actions are also processed while
+	// waiting for the input. The goroutine does not read the input, so that
+	// it is not taken from the commands executed by the actions in the
+	// meantime, and the next wait only starts after the previous event has
+	// been processed.
+	var inputReady chan bool
+	var needEvent chan bool
+	if t.serverInput != nil {
+		// The goroutine is not blocked by the result after the loop ends
+		inputReady = make(chan bool, 1)
+		needEvent = make(chan bool)
+		defer close(needEvent)
+		go func() {
+			for range needEvent {
+				inputReady <- t.tui.WaitInput(true)
+			}
+		}()
+	}
+	waiting := false
+	nextEvent := func() (tui.Event, []action) {
+		if event, ok := t.macro.next(); ok {
+			return event, nil
+		}
+		if t.serverInput == nil {
+			return t.tui.GetChar(), nil
+		}
+		for {
+			if !waiting {
+				needEvent <- true
+				waiting = true
+			}
+			select {
+			case <-inputReady:
+				waiting = false
+				// The input may have been read by a command in the meantime
+				if t.tui.WaitInput(false) {
+					return t.tui.GetChar(), nil
+				}
+			case actions := <-t.serverInput:
+				return tui.Invalid.AsEvent(), actions
+			}
+		}
+	}
+
 	looping := true
 	for looping {
 		var newCommand *string
 		changed := false
 		beof := false
 		queryChanged := false
+		binding, spec := "", "" // The binding of the actions for the errors
 
-		event := t.tui.GetChar()
+		event, serverActions := nextEvent()
+		if event.Type == tui.FocusGained || event.Type == tui.FocusLost {
+			// The focus changes are reported by the terminal regardless of
+			// the bindings, and they should not cancel the pending operations
+			if _, prs := t.keymap[event.Comparable()]; !prs {
+				continue
+			}
+		}
 
 		t.mutex.Lock()
+		if serverActions == nil {
+			t.macro.record(event, t.chord != nil || t.picker != nil || t.menuOpen != nil)
+		}
 		previousInput := t.input
 		previousCx := t.cx
 		events := []util.EventType{}
 		req := func(evts ...util.EventType) {
 			for _, event := range evts {
 				events = append(events, event)
-				if event == reqClose || event == reqQuit {
+				if event == reqClose || event == reqQuit || event == reqPrintQuery {
 					looping = false
 				}
 			}
@@ -2167,11 +4092,20 @@ func (t *Terminal) Loop() {
 				req(reqPrompt, reqList, reqInfo, reqHeader)
 			}
 		}
+		bell := func() {
+			if t.bellStyle != tui.BellNone {
+				req(reqBell)
+			}
+		}
 		toggle := func() bool {
 			current := t.currentItem()
-			if current != nil && t.toggleItem(current) {
-				req(reqInfo)
-				return true
+			if current != nil {
+				if t.toggleItem(current) {
+					req(reqInfo)
+					return true
+				}
+				// Reached the limit of the selection
+				bell()
 			}
 			return false
 		}
@@ -2193,8 +4127,16 @@ func (t *Terminal) Loop() {
 		scrollPreviewBy := func(amount int) {
 			scrollPreviewTo(t.previewer.offset + amount)
 		}
+		// The keys only reported with the keyboard protocol of kitty fall back
+		// to the legacy keys unless they are bound by themselves
+		expected := event
+		if legacy, ok := event.Legacy(); ok {
+			if _, prs := t.expect[event.Comparable()]; !prs {
+				expected = legacy
+			}
+		}
 		for key, ret := range t.expect {
-			if keyMatch(key, event) {
+			if keyMatch(key, expected) {
 				t.pressed = ret
 				t.reqBox.Set(reqClose, nil)
 				t.mutex.Unlock()
@@ -2206,6 +4148,20 @@ func (t *Terminal) Loop() {
 			return t.keymap[eventType.AsEvent()]
 		}
 
+		// Shows the command instead of running it in dry-run mode
+		dryRun := func(template string, forcePlus bool) bool {
+			if !t.dryRun {
+				return false
+			}
+			if valid, list := t.buildPlusList(template, forcePlus); valid {
+				t.showDryRun(binding, t.replacePlaceholder(template, forcePlus, string(t.input), list))
+				req(reqInfo)
+			}
+			return true
+		}
+
+		// The text killed by the previous action is stored by yank-to
+		var lastAction actionType
 		var doAction func(action) bool
 		doActions := func(actions []action) bool {
 			for _, action := range actions {
@@ -2215,13 +4171,154 @@ func (t *Terminal) Loop() {
 			}
 			return true
 		}
+		// reloadCommand returns the command of reload action, or nil if there
+		// is nothing to run it for
+		reloadCommand := func(template string) *string {
+			valid, list := t.buildPlusList(template, false)
+			if !valid {
+				// We run the command even when there's no match
+				// 1. If the template doesn't have any slots
+				// 2. If the template has {q}
+				slot, _, query := hasPreviewFlags(template)
+				valid = !slot || query
+			}
+			if !valid {
+				return nil
+			}
+			command := t.replacePlaceholder(template, false, string(t.input), list)
+			return &command
+		}
 		doAction = func(a action) bool {
+			previousAction := lastAction
+			lastAction = a.t
+			if field := t.focusedField(); field != nil {
+				if editAction, ok := fieldEditAction(a.t); ok {
+					// Apply the editing action to the focused field instead of the query
+					value := string(field.value)
+					a.t = editAction
+					t.swapInput(field)
+					defer func() {
+						t.swapInput(field)
+						if value != string(field.value) {
+							// Run the commands referring to the field again
+							if referencesField(t.previewOpts.command, field.name) && t.isPreviewEnabled() {
+								t.version++
+								req(reqList)
+							}
+							if referencesField(t.formReload, field.name) {
+								if command := reloadCommand(t.formReload); command != nil {
+									newCommand = command
+								}
+							}
+						}
+						req(reqHeader)
+					}()
+				}
+			}
 			switch a.t {
-			case actIgnore:
+			case actIgnore, actDescription:
+			case actChord:
+				t.startChord(a.k)
+				req(reqInfo)
+			case actShowBindings:
+				t.showBindings()
+				req(reqInfo)
+			case actContextMenu:
+				if !t.openMenu() {
+					bell()
+				}
+				req(reqInfo)
+			case actDigitArgument:
+				// The digit of the key as in alt-3
+				if event.Char < '0' || event.Char > '9' || t.numArg >= maxNumArg/10 {
+					bell()
+				} else {
+					t.numArg = t.numArg*10 + int(event.Char-'0')
+					req(reqInfo)
+				}
 			case actExecute, actExecuteSilent:
-				t.executeCommand(a.a, false, a.t == actExecuteSilent)
+				if dryRun(a.a, false) {
+					break
+				}
+				if err := t.executeCommand(a.a, false, a.t == actExecuteSilent); err != nil {
+					t.reportError(binding, spec, err.Error())
+					req(reqInfo)
+				}
+			case actExecuteStatus:
+				if !dryRun(a.a, false) {
+					t.executeStatus(a.a)
+					req(reqInfo)
+				}
+			case actCancelStatus:
+				if !t.cancelStatus() {
+					return false
+				}
+			case actRecordMacro:
+				t.macro.start()
+				req(reqInfo)
+			case actStopMacro:
+				if t.macro.stop() {
+					req(reqInfo)
+				}
+			case actReplayMacro:
+				if !t.macro.replay() {
+					bell()
+				}
 			case actExecuteMulti:
-				t.executeCommand(a.a, true, false)
+				if dryRun(a.a, true) {
+					break
+				}
+				if err := t.executeCommand(a.a, true, false); err != nil {
+					t.reportError(binding, spec, err.Error())
+					req(reqInfo)
+				}
+			case actTransform:
+				if dryRun(a.a, false) {
+					break
+				}
+				actions, err := t.transform(a.a)
+				if err != nil {
+					t.reportError(binding, spec, err.Error())
+					bell()
+					req(reqInfo)
+				} else if !doActions(actions) {
+					return false
+				}
+			case actBecome, actBecomeWithState:
+				if dryRun(a.a, false) {
+					break
+				}
+				if err := t.become(a.a, a.t == actBecomeWithState); err != nil {
+					// Not to be shown as the screen is already closed
+					t.reportError(binding, spec, err.Error())
+					errorExit(err.Error())
+				}
+			case actRepeat:
+				for i := 0; i < a.n; i++ {
+					if !doActions(a.c) {
+						return false
+					}
+				}
+			case actNextField, actPreviousField:
+				if len(t.form) > 0 {
+					if a.t == actNextField {
+						t.focusField(t.formFocus + 1)
+					} else {
+						t.focusField(t.formFocus - 1)
+					}
+					req(reqPrompt, reqHeader)
+				}
+			case actNextTab, actPrevTab:
+				offset := 1
+				if a.t == actPrevTab {
+					offset = -1
+				}
+				if command := t.switchTab(t.tab + offset); command != nil {
+					t.failed = nil
+					t.formReload = ""
+					newCommand = command
+					req(reqPrompt, reqHeader, reqList)
+				}
 			case actInvalid:
 				t.mutex.Unlock()
 				return false
@@ -2232,11 +4329,15 @@ func (t *Terminal) Loop() {
 						valid, list := t.buildPlusList(t.previewOpts.command, false)
 						if valid {
 							t.cancelPreview()
-							t.previewBox.Set(reqPreviewEnqueue,
-								previewRequest{t.previewOpts.command, t.pwindow, list})
+							t.enqueuePreview(t.previewOpts.command, list)
 						}
 					}
 				}
+			case actToggleWrap:
+				t.wrap = !t.wrap
+				req(reqList)
+			case actToggleDryRun:
+				t.dryRun = !t.dryRun
 			case actTogglePreviewWrap:
 				if t.hasPreviewWindow() {
 					t.previewOpts.wrap = !t.previewOpts.wrap
@@ -2244,9 +4345,62 @@ func (t *Terminal) Loop() {
 					t.previewed.version = 0
 					req(reqPreviewRefresh)
 				}
+			case actTogglePreviewDebug:
+				if t.hasPreviewWindow() {
+					t.previewer.debugging = !t.previewer.debugging
+					t.previewer.lines = t.previewLines()
+					t.previewer.offset = 0
+					t.previewed.version = 0
+					req(reqPreviewRefresh)
+				}
 			case actToggleSort:
 				t.sort = !t.sort
 				changed = true
+			case actFlash:
+				req(reqFlash)
+			case actIntersectWithQuery, actUnionWithQuery, actSubtractQuery:
+				op := setIntersect
+				if a.t == actUnionWithQuery {
+					op = setUnion
+				} else if a.t == actSubtractQuery {
+					op = setSubtract
+				}
+				if t.combineQuery(op) {
+					changed = true
+					req(reqInfo)
+				}
+			case actToggleSmartCase:
+				// Switch back to the case mode of the options, or to
+				// case-insensitive match if it is smart-case
+				if t.caseMode != CaseSmart {
+					t.caseMode = CaseSmart
+				} else if t.caseOrig != CaseSmart {
+					t.caseMode = t.caseOrig
+				} else {
+					t.caseMode = CaseIgnore
+				}
+				changed = true
+			case actToggleRegex:
+				t.regex = !t.regex
+				changed = true
+				req(reqInfo)
+			case actToggleInfo:
+				t.infoStyle, t.infoToggle = t.infoToggle, t.infoStyle
+				req(reqRedraw)
+			case actToggleSeparator:
+				t.separator = !t.separator
+				req(reqInfo)
+			case actGrowHeader, actShrinkHeader:
+				cut := t.headerCut
+				if a.t == actGrowHeader {
+					cut--
+				} else {
+					cut++
+				}
+				if cut = util.Constrain(cut, 0, len(t.header)); cut != t.headerCut {
+					t.headerCut = cut
+					req(reqRedraw)
+				}
 			case actPreviewTop:
 				if t.hasPreviewWindow() {
 					scrollPreviewTo(0)
@@ -2290,11 +4444,41 @@ func (t *Terminal) Loop() {
 			case actChangePrompt:
 				t.prompt, t.promptLen = t.parsePrompt(a.a)
 				req(reqPrompt)
+			case actChangeNth:
+				t.changeNth(a.a)
+				changed = true
+			case actChangeDelimiter:
+				t.delimiter = t.delimOrig
+				if len(a.a) > 0 {
+					t.delimiter = delimiterRegexp(a.a)
+				}
+				t.fieldsRev++
+				changed = true
+			case actChangeScheme:
+				t.changeScheme(a.a)
+				changed = true
+			case actReserveRegion, actReleaseRegion:
+				// The whole screen is redrawn to release the previous region
+				t.region = a.a
+				req(reqRedraw)
+			case actDrawRegion:
+				t.reqBox.Set(reqDrawRegion, a.a)
 			case actPreview:
 				togglePreview(true)
 				refreshPreview(a.a)
 			case actRefreshPreview:
 				refreshPreview(t.previewOpts.command)
+			case actSaveFilter:
+				if t.history != nil {
+					t.history.saveFilter(a.a, string(t.input))
+				} else {
+					t.filters[a.a] = string(t.input)
+				}
+			case actApplyFilter:
+				if query, found := t.filters[a.a]; found {
+					t.input = []rune(query)
+					t.cx = len(t.input)
+				}
 			case actReplaceQuery:
 				current := t.currentItem()
 				if current != nil {
@@ -2321,7 +4505,11 @@ func (t *Terminal) Loop() {
 				}
 			case actBackwardDeleteCharEOF:
 				if len(t.input) == 0 {
-					req(reqQuit)
+					if t.uncombineQuery() {
+						changed = true
+					} else {
+						req(reqQuit)
+					}
 				} else if t.cx > 0 {
 					t.input = append(t.input[:t.cx-1], t.input[t.cx:]...)
 					t.cx--
@@ -2332,7 +4520,10 @@ func (t *Terminal) Loop() {
 				}
 			case actBackwardDeleteChar:
 				beof = len(t.input) == 0
-				if t.cx > 0 {
+				if beof && t.uncombineQuery() {
+					beof = false
+					changed = true
+				} else if t.cx > 0 {
 					t.input = append(t.input[:t.cx-1], t.input[t.cx:]...)
 					t.cx--
 				}
@@ -2414,16 +4605,31 @@ func (t *Terminal) Loop() {
 					req(reqList)
 				}
 			case actDown:
-				t.vmove(-1, true)
+				if !t.vmove(-1, true) {
+					bell()
+				}
 				req(reqList)
 			case actUp:
-				t.vmove(1, true)
+				if !t.vmove(1, true) {
+					bell()
+				}
 				req(reqList)
 			case actAccept:
 				req(reqClose)
 			case actAcceptNonEmpty:
 				if len(t.selected) > 0 || t.merger.Length() > 0 || !t.reading && t.count == 0 {
 					req(reqClose)
+				} else {
+					bell()
+				}
+			case actAcceptAll:
+				if count := len(t.matchedItems()); t.confirmAll > 0 && count > t.confirmAll {
+					// Ask before accepting too many items by mistake
+					t.confirming = count
+					req(reqPrompt, reqInfo)
+				} else {
+					t.acceptAll = true
+					req(reqClose)
 				}
 			case actClearScreen:
 				req(reqRedraw)
@@ -2463,17 +4669,39 @@ func (t *Terminal) Loop() {
 				suffix := copySlice(t.input[t.cx:])
 				t.input = append(append(t.input[:t.cx], t.yanked...), suffix...)
 				t.cx += len(t.yanked)
+			case actYankTo:
+				if killAction(previousAction) {
+					t.yankTo(a.a, copySlice(t.yanked))
+				} else if current := t.currentItem(); current != nil {
+					t.yankTo(a.a, current.text.ToRunes())
+				} else {
+					bell()
+				}
+				req(reqInfo)
+			case actPutFrom:
+				if !t.putFrom(a.a) {
+					bell()
+				}
+				req(reqInfo)
 			case actPageUp:
-				t.vmove(t.maxItems()-1, false)
+				if !t.vmoveRows(t.maxItems() - 1) {
+					bell()
+				}
 				req(reqList)
 			case actPageDown:
-				t.vmove(-(t.maxItems() - 1), false)
+				if !t.vmoveRows(-(t.maxItems() - 1)) {
+					bell()
+				}
 				req(reqList)
 			case actHalfPageUp:
-				t.vmove(t.maxItems()/2, false)
+				if !t.vmoveRows(t.maxItems() / 2) {
+					bell()
+				}
 				req(reqList)
 			case actHalfPageDown:
-				t.vmove(-(t.maxItems() / 2), false)
+				if !t.vmoveRows(-(t.maxItems() / 2)) {
+					bell()
+				}
 				req(reqList)
 			case actJump:
 				t.jumping = jumpEnabled
@@ -2501,6 +4729,11 @@ func (t *Terminal) Loop() {
 				prefix := copySlice(t.input[:t.cx])
 				t.input = append(append(prefix, event.Char), t.input[t.cx:]...)
 				t.cx++
+			case actPaste:
+				runes := []rune(a.a)
+				prefix := copySlice(t.input[:t.cx])
+				t.input = append(append(prefix, runes...), t.input[t.cx:]...)
+				t.cx += len(runes)
 			case actPreviousHistory:
 				if t.history != nil {
 					t.history.override(string(t.input))
@@ -2537,7 +4770,25 @@ func (t *Terminal) Loop() {
 			case actMouse:
 				me := event.MouseEvent
 				mx, my := me.X, me.Y
-				if me.S != 0 {
+				if event.Type == tui.Mouse && !me.Down && !me.Drag && !me.Hover && me.S == 0 {
+					// Release of the button ends the drag
+					t.dragFrom = -1
+					t.dragTo = -1
+				}
+				if event.Type == tui.ScrollLeft || event.Type == tui.ScrollRight {
+					// Horizontal scroll
+					amount := hscrollStep
+					if event.Type == tui.ScrollLeft {
+						amount = -amount
+					}
+					if t.hasPreviewWindow() && t.pwindow.Enclose(my, mx) {
+						if t.hscrollPreview(amount) {
+							req(reqPreviewRefresh)
+						}
+					} else if t.window.Enclose(my, mx) && t.hscrollList(amount) {
+						req(reqList)
+					}
+				} else if me.S != 0 {
 					// Scroll
 					if t.window.Enclose(my, mx) && t.merger.Length() > 0 {
 						if t.multi > 0 && me.Mod {
@@ -2548,11 +4799,16 @@ func (t *Terminal) Loop() {
 					} else if t.hasPreviewWindow() && t.pwindow.Enclose(my, mx) {
 						scrollPreviewBy(-me.S)
 					}
+				} else if t.twindow != nil && t.twindow.Enclose(my, mx) {
+					if key, found := t.titleButtonAt(mx - t.twindow.Left()); found && me.Down && !me.Double {
+						return doActions(t.keymap[key])
+					}
 				} else if t.window.Enclose(my, mx) {
 					mx -= t.window.Left()
 					my -= t.window.Top()
+					col := mx
 					mx = util.Constrain(mx-t.promptLen, 0, len(t.input))
-					min := 2 + len(t.header)
+					min := 2 + t.headerLines()
 					if t.noInfoLine() {
 						min--
 					}
@@ -2567,10 +4823,26 @@ func (t *Terminal) Loop() {
 							my = h - my - 1
 						}
 					}
-					if me.Double {
+					if me.Drag {
+						// Drag from the item
+						if t.dragFrom >= 0 && my >= min && t.dragSelect(t.itemAt(my-min)) {
+							req(reqList, reqInfo)
+						}
+					} else if me.Triple {
+						// Triple-click is handled as double-click unless it is bound
+						actions := actionsFor(tui.TripleClick)
+						if len(actions) == 0 {
+							actions = actionsFor(tui.DoubleClick)
+						}
+						if my >= min {
+							if t.vset(t.itemAt(my-min)) && t.cy < t.merger.Length() {
+								return doActions(actions)
+							}
+						}
+					} else if me.Double {
 						// Double-click
 						if my >= min {
-							if t.vset(t.offset+my-min) && t.cy < t.merger.Length() {
+							if t.vset(t.itemAt(my-min)) && t.cy < t.merger.Length() {
 								return doActions(actionsFor(tui.DoubleClick))
 							}
 						}
@@ -2578,10 +4850,33 @@ func (t *Terminal) Loop() {
 						if my == 0 && mx >= 0 {
 							// Prompt
 							t.cx = mx + t.xoffset
+							if t.formFocus >= 0 {
+								t.focusField(-1)
+								req(reqHeader)
+							}
+						} else if my == 1 && !t.noInfoLine() {
+							// Info
+							return doActions(actionsFor(tui.ClickInfo))
+						} else if idx := my - min + t.hintLines() + t.tabLines() + len(t.form); idx >= 0 && idx < len(t.form) {
+							// Form field
+							t.focusField(idx)
+							req(reqPrompt, reqHeader)
+						} else if maxy := t.maxItems(); my >= min && t.scrollbar && col == t.window.Width()-1 && t.merger.Length() > maxy {
+							// Scrollbar jumps to the position unless it is bound
+							if actions := actionsFor(tui.ClickScrollbar); len(actions) > 0 {
+								return doActions(actions)
+							}
+							t.vset(scrollbarTarget(my-min, maxy, t.merger.Length()))
+							req(reqList)
 						} else if my >= min {
 							// List
-							if t.vset(t.offset+my-min) && t.multi > 0 && me.Mod {
-								toggle()
+							if t.vset(t.itemAt(my-min)) && t.multi > 0 {
+								if me.Mod {
+									toggle()
+								} else if me.Left {
+									t.dragFrom = t.cy
+									t.dragTo = -1
+								}
 							}
 							req(reqList)
 							if me.Left {
@@ -2589,30 +4884,171 @@ func (t *Terminal) Loop() {
 							}
 							return doActions(actionsFor(tui.RightClick))
 						}
+					} else if me.Hover {
+						// The cursor follows the hovered item
+						if idx := t.itemAt(my - min); my >= min && idx < t.merger.Length() && idx != t.cy {
+							t.vset(idx)
+							req(reqList)
+						}
+					}
+				} else if t.hasPreviewWindow() && t.pborder != nil && t.pborder.Enclose(my, mx) {
+					if me.Down && !t.pwindow.Enclose(my, mx) {
+						return doActions(actionsFor(tui.ClickPreviewBorder))
 					}
+				} else if me.Down && t.borderShape != tui.BorderNone && t.border != nil && t.border.Enclose(my, mx) {
+					return doActions(actionsFor(tui.ClickBorder))
+				}
+			case actReloadURL:
+				if len(t.sourceURL) > 0 {
+					t.failed = nil
+					url := t.sourceURL
+					t.formReload = ""
+					newCommand = &url
 				}
 			case actReload:
 				t.failed = nil
 
-				valid, list := t.buildPlusList(a.a, false)
-				if !valid {
-					// We run the command even when there's no match
-					// 1. If the template doesn't have any slots
-					// 2. If the template has {q}
-					slot, _, query := hasPreviewFlags(a.a)
-					valid = !slot || query
-				}
-				if valid {
-					command := t.replacePlaceholder(a.a, false, string(t.input), list)
-					newCommand = &command
+				if command := reloadCommand(a.a); command != nil {
+					if t.dryRun {
+						t.showDryRun(binding, *command)
+						req(reqInfo)
+					} else {
+						newCommand = command
+						t.formReload = ""
+						if hasFormPlaceholder(a.a) {
+							t.formReload = a.a
+						}
+					}
 				}
 			}
 			return true
 		}
 
-		if t.jumping == jumpDisabled {
-			actions := t.keymap[event.Comparable()]
-			if len(actions) == 0 && event.Type == tui.Rune {
+		if t.confirming > 0 && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid && event.Type != tui.Mouse &&
+			event.Type != tui.ScrollLeft && event.Type != tui.ScrollRight {
+			// Any other key cancels the confirmation of accept-all
+			if event.Type == tui.Rune && (event.Char == 'y' || event.Char == 'Y') || hasAction(t.keymap[event.Comparable()], actAcceptAll) {
+				t.acceptAll = true
+				req(reqClose)
+			}
+			t.confirming = 0
+			req(reqPrompt, reqInfo)
+		} else if t.bindings != nil && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid && event.Type != tui.Mouse &&
+			event.Type != tui.ScrollLeft && event.Type != tui.ScrollRight && !hasAction(t.keymap[event.Comparable()], actShowBindings) {
+			// Any other key than the ones bound to show-bindings closes the list
+			t.bindings = nil
+			req(reqInfo)
+		} else if t.toast != nil && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid && event.Type != tui.Mouse &&
+			event.Type != tui.ScrollLeft && event.Type != tui.ScrollRight && event.Type != tui.FocusGained && event.Type != tui.FocusLost {
+			// Any key dismisses the toast
+			t.toast = nil
+			req(reqInfo)
+		} else if t.jumping == jumpDisabled || serverActions != nil {
+			key := event.Comparable()
+			actions, prs := t.keymap[key]
+			if legacy, ok := event.Legacy(); ok && !prs {
+				key = legacy
+				actions = t.keymap[key]
+			} else if alias, ok := event.CtrlAlias(); ok && !t.tui.KeyboardProtocol() {
+				// ctrl-i, ctrl-m, and ctrl-[ are the same keys as tab, enter,
+				// and esc without the keyboard protocol
+				if aliasActions, prs := t.keymap[alias]; prs {
+					key, actions = alias, aliasActions
+				}
+			}
+			chord := t.chord != nil && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid &&
+				event.Type != tui.Mouse && event.Type != tui.FocusGained && event.Type != tui.FocusLost
+			picking := t.picker != nil && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid &&
+				event.Type != tui.Mouse && event.Type != tui.FocusGained && event.Type != tui.FocusLost
+			menuing := t.menuOpen != nil && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid &&
+				event.Type != tui.Mouse && event.Type != tui.FocusGained && event.Type != tui.FocusLost
+			binding, spec = t.keyNames[key], t.keySpecs[key]
+			if serverActions != nil {
+				actions = serverActions
+				binding, spec = "", ""
+			} else if chord {
+				// The key following the chord. Any other key than the ones of
+				// the chord cancels it.
+				key = event.Comparable()
+				actions, prs = t.chord.keymap[key]
+				if legacy, ok := event.Legacy(); ok && !prs {
+					key = legacy
+					actions, prs = t.chord.keymap[key]
+				}
+				binding, spec = t.chord.name+">"+t.chord.names[key], t.chord.specs[key]
+				t.chord = nil
+				if !prs && event.Type != tui.ESC {
+					bell()
+				}
+				req(reqInfo)
+			} else if picking {
+				// The key following yank-to or put-from without the register
+				// names it. Any other key cancels it.
+				picker := t.picker
+				t.picker = nil
+				actions = nil
+				if event.Type == tui.Rune && validRegister(event.Char) {
+					if picker.put {
+						actions = []action{{t: actPutFrom, a: string(event.Char)}}
+					} else {
+						t.registers[event.Char] = picker.text
+					}
+				} else if event.Type != tui.ESC {
+					bell()
+				}
+				req(reqInfo)
+			} else if menuing {
+				// The key in the context menu moves the cursor or picks the
+				// entry to run its actions. Any other key closes the menu.
+				entry, ok := t.menuKey(event)
+				actions = nil
+				if entry != nil {
+					actions = entry.actions
+					binding, spec = entry.label, entry.spec
+				} else if !ok {
+					bell()
+				}
+				req(reqInfo)
+			} else if event.Type == tui.Paste {
+				// The pasted text is inserted before the actions bound to the event
+				text := t.tui.Pasted()
+				if len(t.pasteFilter) > 0 {
+					var err error
+					if text, err = t.filterPaste(text); err != nil {
+						t.reportError("paste", t.pasteFilter, err.Error())
+						bell()
+						req(reqInfo)
+					}
+				}
+				if runes := pasteQuery(text, t.paste); len(runes) > 0 {
+					doAction(action{t: actPaste, a: string(runes)})
+				}
+			}
+			if t.numArg > 0 && serverActions == nil && event.Type != tui.Resize && event.Type != tui.Invalid && event.Type != tui.Mouse &&
+				event.Type != tui.ScrollLeft && event.Type != tui.ScrollRight && event.Type != tui.FocusGained && event.Type != tui.FocusLost &&
+				!hasAction(actions, actDigitArgument) && !hasAction(actions, actChord) {
+				// The actions of the next key are repeated as many times as the
+				// numeric argument. The digits extend the argument and esc
+				// cancels it.
+				if len(actions) == 0 && event.Type == tui.Rune && !chord && !picking && !menuing {
+					actions = toActions(actRune)
+					if event.Char >= '0' && event.Char <= '9' {
+						actions = toActions(actDigitArgument)
+					}
+				}
+				if event.Type == tui.ESC {
+					actions = nil
+				}
+				if !hasAction(actions, actDigitArgument) {
+					if len(actions) > 0 {
+						n := util.Max(util.Min(t.numArg, maxRepeat/repetitions(actions)), 1)
+						actions = []action{{t: actRepeat, n: n, c: actions}}
+					}
+					t.numArg = 0
+					req(reqInfo)
+				}
+			}
+			if len(actions) == 0 && event.Type == tui.Rune && !chord && !picking && !menuing {
 				doAction(action{t: actRune})
 			} else if !doActions(actions) {
 				continue
@@ -2621,11 +5057,13 @@ func (t *Terminal) Loop() {
 			queryChanged = string(previousInput) != string(t.input)
 			changed = changed || queryChanged
 			if onChanges, prs := t.keymap[tui.Change.AsEvent()]; queryChanged && prs {
+				binding, spec = "change", t.keySpecs[tui.Change.AsEvent()]
 				if !doActions(onChanges) {
 					continue
 				}
 			}
 			if onEOFs, prs := t.keymap[tui.BackwardEOF.AsEvent()]; beof && prs {
+				binding, spec = "backward-eof", t.keySpecs[tui.BackwardEOF.AsEvent()]
 				if !doActions(onEOFs) {
 					continue
 				}
@@ -2637,6 +5075,9 @@ func (t *Terminal) Loop() {
 					if t.jumping == jumpAcceptEnabled {
 						req(reqClose)
 					}
+				} else {
+					// Not a label of the items on the screen
+					bell()
 				}
 			}
 			t.jumping = jumpDisabled
@@ -2659,7 +5100,10 @@ func (t *Terminal) Loop() {
 		t.mutex.Unlock() // Must be unlocked before touching reqBox
 
 		if changed || newCommand != nil {
-			t.eventBox.Set(EvtSearchNew, searchRequest{sort: t.sort, command: newCommand})
+			t.eventBox.Set(EvtSearchNew, searchRequest{
+				sort: t.sort, command: newCommand, nth: t.nth, delimiter: t.delimiter, revision: t.fieldsRev,
+				scheme: t.scheme, caseMode: t.caseMode, regex: t.regex, querySets: t.querySets,
+				restore: t.tabState != nil})
 		}
 		for _, event := range events {
 			t.reqBox.Set(event, nil)
@@ -2674,13 +5118,64 @@ func (t *Terminal) constrain() {
 	height := t.maxItems()
 
 	t.cy = util.Constrain(t.cy, 0, count-1)
-
+	t.skipDisabled(1)
+
+	if t.wrap {
+		// Keep the whole current item on the screen, and fill the screen
+		// with the items when scrolled to the end
+		minOffset := t.fitFrom(t.cy, height)
+		maxOffset := util.Max(util.Min(t.fitFrom(count-1, height), t.cy), 0)
+		t.offset = util.Constrain(t.offset, minOffset, maxOffset)
+		return
+	}
 	minOffset := t.cy - height + 1
 	maxOffset := util.Max(util.Min(count-height, t.cy), 0)
 	t.offset = util.Constrain(t.offset, minOffset, maxOffset)
 }
 
-func (t *Terminal) vmove(o int, allowCycle bool) {
+// fitFrom returns the smallest index of the item from which the items up to
+// the given index fit in the rows. Only the items on the screen are looked
+// at as every item takes at least one row.
+func (t *Terminal) fitFrom(to int, rows int) int {
+	idx := to
+	for ; idx >= 0; idx-- {
+		rows -= t.itemRows(t.merger.Get(idx).item)
+		if rows < 0 {
+			break
+		}
+	}
+	return util.Min(idx+1, to)
+}
+
+// vmoveRows moves the cursor by the number of the rows on the list, which
+// can be smaller than the number of the items when they are wrapped
+func (t *Terminal) vmoveRows(rows int) bool {
+	if !t.wrap || rows == 0 {
+		return t.vmove(rows, false)
+	}
+	sign := 1
+	if rows < 0 {
+		sign = -1
+		rows = -rows
+	}
+	dir := sign
+	if t.layout != layoutDefault {
+		dir *= -1
+	}
+	items := 0
+	for idx := t.cy + dir; idx >= 0 && idx < t.merger.Length(); idx += dir {
+		rows -= t.itemRows(t.merger.Get(idx).item)
+		if rows < 0 {
+			break
+		}
+		items++
+	}
+	return t.vmove(sign*util.Max(items, 1), false)
+}
+
+// vmove moves the cursor by the offset and returns false if the cursor could
+// not move as it was already at the end of the list
+func (t *Terminal) vmove(o int, allowCycle bool) bool {
 	if t.layout != layoutDefault {
 		o *= -1
 	}
@@ -2697,16 +5192,40 @@ func (t *Terminal) vmove(o int, allowCycle bool) {
 			}
 		}
 	}
+	prev := t.cy
 	t.vset(dest)
+	return t.cy != prev || o == 0
 }
 
 func (t *Terminal) vset(o int) bool {
+	dir := 1
+	if o < t.cy {
+		dir = -1
+	}
 	t.cy = util.Constrain(o, 0, t.merger.Length()-1)
+	t.skipDisabled(dir)
 	return t.cy == o
 }
 
+// skipDisabled moves the cursor off a disabled item to the nearest enabled
+// item in the direction, or in the other direction if there is none
+func (t *Terminal) skipDisabled(dir int) {
+	if t.disabled.empty() {
+		return
+	}
+	count := t.merger.Length()
+	for _, d := range []int{dir, -dir} {
+		for cy := t.cy; cy >= 0 && cy < count; cy += d {
+			if !t.disabled.has(t.merger.Get(cy).item) {
+				t.cy = cy
+				return
+			}
+		}
+	}
+}
+
 func (t *Terminal) maxItems() int {
-	max := t.window.Height() - 2 - len(t.header)
+	max := t.window.Height() - 2 - t.headerLines()
 	if t.noInfoLine() {
 		max++
 	}
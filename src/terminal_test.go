@@ -1,9 +1,17 @@
 package fzf
 
 import (
+	"bufio"
+	"math/rand"
+	"os"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/quick"
 
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/tui"
 	"github.com/junegunn/fzf/src/util"
 )
 
@@ -32,90 +40,98 @@ func TestReplacePlaceholder(t *testing.T) {
 	}
 	printsep := "\n"
 	// {}, preserve ansi
-	result = replacePlaceholder("echo {}", false, Delimiter{}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {}", false, Delimiter{}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar \x1b[31mbaz\x1b[m'")
 
 	// {}, strip ansi
-	result = replacePlaceholder("echo {}", true, Delimiter{}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {}", true, Delimiter{}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar baz'")
 
 	// {}, with multiple items
-	result = replacePlaceholder("echo {}", true, Delimiter{}, printsep, false, "query", items2)
+	result = replacePlaceholderWithForm("echo {}", true, Delimiter{}, printsep, false, "query", nil, items2)
 	check("echo 'foo'\\''bar baz'")
 
 	// {..}, strip leading whitespaces, preserve ansi
-	result = replacePlaceholder("echo {..}", false, Delimiter{}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {..}", false, Delimiter{}, printsep, false, "query", nil, items1)
 	check("echo 'foo'\\''bar \x1b[31mbaz\x1b[m'")
 
 	// {..}, strip leading whitespaces, strip ansi
-	result = replacePlaceholder("echo {..}", true, Delimiter{}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {..}", true, Delimiter{}, printsep, false, "query", nil, items1)
 	check("echo 'foo'\\''bar baz'")
 
 	// {q}
-	result = replacePlaceholder("echo {} {q}", true, Delimiter{}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {} {q}", true, Delimiter{}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar baz' 'query'")
 
 	// {q}, multiple items
-	result = replacePlaceholder("echo {+}{q}{+}", true, Delimiter{}, printsep, false, "query 'string'", items2)
+	result = replacePlaceholderWithForm("echo {+}{q}{+}", true, Delimiter{}, printsep, false, "query 'string'", nil, items2)
 	check("echo 'foo'\\''bar baz' 'FOO'\\''BAR BAZ''query '\\''string'\\''''foo'\\''bar baz' 'FOO'\\''BAR BAZ'")
 
-	result = replacePlaceholder("echo {}{q}{}", true, Delimiter{}, printsep, false, "query 'string'", items2)
+	result = replacePlaceholderWithForm("echo {}{q}{}", true, Delimiter{}, printsep, false, "query 'string'", nil, items2)
 	check("echo 'foo'\\''bar baz''query '\\''string'\\''''foo'\\''bar baz'")
 
-	result = replacePlaceholder("echo {1}/{2}/{2,1}/{-1}/{-2}/{}/{..}/{n.t}/\\{}/\\{1}/\\{q}/{3}", true, Delimiter{}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {1}/{2}/{2,1}/{-1}/{-2}/{}/{..}/{n.t}/\\{}/\\{1}/\\{q}/{3}", true, Delimiter{}, printsep, false, "query", nil, items1)
 	check("echo 'foo'\\''bar'/'baz'/'bazfoo'\\''bar'/'baz'/'foo'\\''bar'/'  foo'\\''bar baz'/'foo'\\''bar baz'/{n.t}/{}/{1}/{q}/''")
 
-	result = replacePlaceholder("echo {1}/{2}/{-1}/{-2}/{..}/{n.t}/\\{}/\\{1}/\\{q}/{3}", true, Delimiter{}, printsep, false, "query", items2)
+	result = replacePlaceholderWithForm("echo {1}/{2}/{-1}/{-2}/{..}/{n.t}/\\{}/\\{1}/\\{q}/{3}", true, Delimiter{}, printsep, false, "query", nil, items2)
 	check("echo 'foo'\\''bar'/'baz'/'baz'/'foo'\\''bar'/'foo'\\''bar baz'/{n.t}/{}/{1}/{q}/''")
 
-	result = replacePlaceholder("echo {+1}/{+2}/{+-1}/{+-2}/{+..}/{n.t}/\\{}/\\{1}/\\{q}/{+3}", true, Delimiter{}, printsep, false, "query", items2)
+	result = replacePlaceholderWithForm("echo {+1}/{+2}/{+-1}/{+-2}/{+..}/{n.t}/\\{}/\\{1}/\\{q}/{+3}", true, Delimiter{}, printsep, false, "query", nil, items2)
 	check("echo 'foo'\\''bar' 'FOO'\\''BAR'/'baz' 'BAZ'/'baz' 'BAZ'/'foo'\\''bar' 'FOO'\\''BAR'/'foo'\\''bar baz' 'FOO'\\''BAR BAZ'/{n.t}/{}/{1}/{q}/'' ''")
 
 	// forcePlus
-	result = replacePlaceholder("echo {1}/{2}/{-1}/{-2}/{..}/{n.t}/\\{}/\\{1}/\\{q}/{3}", true, Delimiter{}, printsep, true, "query", items2)
+	result = replacePlaceholderWithForm("echo {1}/{2}/{-1}/{-2}/{..}/{n.t}/\\{}/\\{1}/\\{q}/{3}", true, Delimiter{}, printsep, true, "query", nil, items2)
 	check("echo 'foo'\\''bar' 'FOO'\\''BAR'/'baz' 'BAZ'/'baz' 'BAZ'/'foo'\\''bar' 'FOO'\\''BAR'/'foo'\\''bar baz' 'FOO'\\''BAR BAZ'/{n.t}/{}/{1}/{q}/'' ''")
 
 	// Whitespace preserving flag with "'" delimiter
-	result = replacePlaceholder("echo {s1}", true, Delimiter{str: &delim}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s1}", true, Delimiter{str: &delim}, printsep, false, "query", nil, items1)
 	check("echo '  foo'")
 
-	result = replacePlaceholder("echo {s2}", true, Delimiter{str: &delim}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s2}", true, Delimiter{str: &delim}, printsep, false, "query", nil, items1)
 	check("echo 'bar baz'")
 
-	result = replacePlaceholder("echo {s}", true, Delimiter{str: &delim}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s}", true, Delimiter{str: &delim}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar baz'")
 
-	result = replacePlaceholder("echo {s..}", true, Delimiter{str: &delim}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s..}", true, Delimiter{str: &delim}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar baz'")
 
 	// Whitespace preserving flag with regex delimiter
 	regex = regexp.MustCompile(`\w+`)
 
-	result = replacePlaceholder("echo {s1}", true, Delimiter{regex: regex}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s1}", true, Delimiter{regex: regex}, printsep, false, "query", nil, items1)
 	check("echo '  '")
 
-	result = replacePlaceholder("echo {s2}", true, Delimiter{regex: regex}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s2}", true, Delimiter{regex: regex}, printsep, false, "query", nil, items1)
 	check("echo ''\\'''")
 
-	result = replacePlaceholder("echo {s3}", true, Delimiter{regex: regex}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {s3}", true, Delimiter{regex: regex}, printsep, false, "query", nil, items1)
 	check("echo ' '")
 
 	// No match
-	result = replacePlaceholder("echo {}/{+}", true, Delimiter{}, printsep, false, "query", []*Item{nil, nil})
+	result = replacePlaceholderWithForm("echo {}/{+}", true, Delimiter{}, printsep, false, "query", nil, []*Item{nil, nil})
 	check("echo /")
 
 	// No match, but with selections
-	result = replacePlaceholder("echo {}/{+}", true, Delimiter{}, printsep, false, "query", []*Item{nil, item1})
+	result = replacePlaceholderWithForm("echo {}/{+}", true, Delimiter{}, printsep, false, "query", nil, []*Item{nil, item1})
 	check("echo /'  foo'\\''bar baz'")
 
+	// Form fields
+	form := map[string]string{"branch": "main", "q": ""}
+	result = replacePlaceholderWithForm("echo {form:branch}/{form:q}/{form:none}/\\{form:branch}", true, Delimiter{}, printsep, false, "query", form, items1)
+	check("echo 'main'/''/{form:none}/{form:branch}")
+	if slot, _, query := hasPreviewFlags("echo {form:q}"); slot || query {
+		t.Errorf("form field should not be a slot: %v %v", slot, query)
+	}
+
 	// String delimiter
-	result = replacePlaceholder("echo {}/{1}/{2}", true, Delimiter{str: &delim}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {}/{1}/{2}", true, Delimiter{str: &delim}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar baz'/'foo'/'bar baz'")
 
 	// Regex delimiter
 	regex = regexp.MustCompile("[oa]+")
 	// foo'bar baz
-	result = replacePlaceholder("echo {}/{1}/{3}/{2..3}", true, Delimiter{regex: regex}, printsep, false, "query", items1)
+	result = replacePlaceholderWithForm("echo {}/{1}/{3}/{2..3}", true, Delimiter{regex: regex}, printsep, false, "query", nil, items1)
 	check("echo '  foo'\\''bar baz'/'f'/'r b'/''\\''bar b'")
 }
 
@@ -137,3 +153,244 @@ func TestQuoteEntryCmd(t *testing.T) {
 		}
 	}
 }
+
+func TestPasteQuery(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		mode     pasteMode
+		expected string
+	}{
+		{"foo\r\nbar\nbaz\n", pasteSpace, "foo bar baz"},
+		{"foo\r\nbar\nbaz\n", pasteStrip, "foobarbaz"},
+		{"foo\r\nbar\nbaz\n", pasteFirstLine, "foo"},
+		{"\nfoo", pasteFirstLine, ""},
+		{"", pasteFirstLine, ""},
+		{"a\tb\x1b[A\x7f", pasteSpace, "a b[A"},
+	} {
+		if runes := pasteQuery(test.text, test.mode); string(runes) != test.expected {
+			t.Errorf("%q, %d: %q", test.text, test.mode, string(runes))
+		}
+	}
+}
+
+func TestMakeBorderLabels(t *testing.T) {
+	term := Terminal{tabstop: 8}
+	sides := tui.BorderRounded.Sides()
+	label := func(text string, x int, bottom bool) tui.BorderLabel {
+		return tui.BorderLabel{Text: text, X: x, Bottom: bottom}
+	}
+	tests := []struct {
+		opts     []labelOpts
+		sides    tui.BorderSides
+		width    int
+		expected []tui.BorderLabel
+	}{
+		{[]labelOpts{{label: "foo"}}, sides, 12, []tui.BorderLabel{label("foo", 4, false)}},
+		{[]labelOpts{{label: "foo", offset: 2}}, sides, 12, []tui.BorderLabel{label("foo", 2, false)}},
+		{[]labelOpts{{label: "foo", offset: -1, bottom: true}}, sides, 12, []tui.BorderLabel{label("foo", 8, true)}},
+		{[]labelOpts{{label: "foo", offset: 100, percent: true}}, sides, 12, []tui.BorderLabel{label("foo", 8, false)}},
+		{[]labelOpts{{label: "foo", offset: 50, percent: true}}, tui.BorderBottom.Sides(), 12, []tui.BorderLabel{label("foo", 4, true)}},
+		{[]labelOpts{{label: "foo", offset: 20}}, sides, 12, []tui.BorderLabel{label("foo", 8, false)}},
+		{[]labelOpts{{label: "foobarbaz"}}, sides, 8, []tui.BorderLabel{label("foob..", 1, false)}},
+		{[]labelOpts{{label: "foo"}}, sides, 4, []tui.BorderLabel{}},
+		{[]labelOpts{{label: "foo"}}, tui.BorderVertical.Sides(), 12, []tui.BorderLabel{}},
+		{[]labelOpts{{label: "foo", bottom: true}}, tui.BorderVertical.Sides(), 12, []tui.BorderLabel{}},
+
+		// Multiple labels
+		{[]labelOpts{{label: "left", offset: 1}, {label: "right", offset: -1}}, sides, 20,
+			[]tui.BorderLabel{label("left", 1, false), label("right", 14, false)}},
+		{[]labelOpts{{label: "abcdef"}, {label: "xyz"}}, sides, 20,
+			[]tui.BorderLabel{label("abcdef", 7, false), label("xyz", 4, false)}},
+		{[]labelOpts{{label: "abc"}, {label: "abc", bottom: true}}, sides, 20,
+			[]tui.BorderLabel{label("abc", 8, false), label("abc", 8, true)}},
+		{[]labelOpts{{label: "abcdefgh", offset: 1}, {label: "wxyz12"}}, sides, 14,
+			[]tui.BorderLabel{label("abcdefgh", 1, false), label("wx..", 9, false)}},
+		{[]labelOpts{{label: "abcdefgh", offset: 1}, {label: "wxyz"}}, sides, 12,
+			[]tui.BorderLabel{label("abcdefgh", 1, false)}},
+	}
+	for _, test := range tests {
+		if labels := term.makeBorderLabels(test.opts, test.sides, test.width); !reflect.DeepEqual(labels, test.expected) {
+			t.Errorf("%v: expected %v, actual %v", test.opts, test.expected, labels)
+		}
+	}
+}
+
+// Text made of tricky grapheme clusters
+type graphemeText []rune
+
+func (graphemeText) Generate(rand *rand.Rand, size int) reflect.Value {
+	samples := []string{
+		"a", "Z", " ", "日", "e\u0301", "\u1112\u1161\u11ab",
+		"\U0001F468\u200d\U0001F469\u200d\U0001F467", "\U0001F1F0\U0001F1F7",
+		"\U0001F44D\U0001F3FD", "\u2764\ufe0f", "\u0915\u094d\u0937"}
+	text := []rune{}
+	for i := rand.Intn(size + 1); i > 0; i-- {
+		text = append(text, []rune(samples[rand.Intn(len(samples))])...)
+	}
+	return reflect.ValueOf(graphemeText(text))
+}
+
+func TestTrimGraphemes(t *testing.T) {
+	term := Terminal{tabstop: 8}
+	isBoundary := func(runes []rune, idx int) bool {
+		return util.NextGraphemeBoundary(runes, idx) == idx
+	}
+	trimRight := func(text graphemeText, width uint8) bool {
+		runes := []rune(text)
+		trimmed, diff := term.trimRight(runes, int(width))
+		return len(trimmed)+diff == len(runes) && isBoundary(runes, len(trimmed)) &&
+			term.displayWidth(trimmed) <= int(width) &&
+			(diff == 0 || term.displayWidth(runes[:util.NextGraphemeBoundary(runes, len(trimmed)+1)]) > int(width))
+	}
+	trimLeft := func(text graphemeText, width uint8) bool {
+		runes := []rune(text)
+		trimmed, diff := term.trimLeft(runes, int(width))
+		return len(trimmed)+int(diff) == len(runes) && isBoundary(runes, int(diff)) &&
+			term.displayWidth(trimmed) <= int(width)
+	}
+	skipColumns := func(text graphemeText, columns uint8) bool {
+		runes := []rune(text)
+		rest, skipped := term.skipColumns(runes, int(columns))
+		return len(rest)+skipped == len(runes) && isBoundary(runes, skipped) &&
+			(skipped == len(runes) || term.displayWidth(runes[:skipped]) >= int(columns))
+	}
+	for _, f := range []interface{}{trimRight, trimLeft, skipColumns} {
+		if err := quick.Check(f, nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	runes := []rune("ab\U0001F468\u200d\U0001F469\u200d\U0001F467cd")
+	if trimmed, _ := term.trimRight(runes, 3); string(trimmed) != "ab" {
+		t.Errorf("%q", string(trimmed))
+	}
+	if trimmed, diff := term.trimLeft(runes, 3); string(trimmed) != "cd" || diff != 7 {
+		t.Errorf("%q, %d", string(trimmed), diff)
+	}
+	if rest, skipped := term.skipColumns(runes, 3); string(rest) != "cd" || skipped != 7 {
+		t.Errorf("%q, %d", string(rest), skipped)
+	}
+	if width := term.displayWidth(runes); width != 6 {
+		t.Errorf("%d", width)
+	}
+}
+
+func TestRenderOnceSize(t *testing.T) {
+	os.Setenv("COLUMNS", "100")
+	os.Setenv("LINES", "30")
+	defer os.Unsetenv("COLUMNS")
+	defer os.Unsetenv("LINES")
+	opts := defaultOptions()
+	if width, height := renderOnceSize(opts); width != 100 || height != 30 {
+		t.Errorf("%d, %d", width, height)
+	}
+	parseOptions(opts, []string{"--height", "50%"})
+	if _, height := renderOnceSize(opts); height != 15 {
+		t.Errorf("%d", height)
+	}
+	parseOptions(opts, []string{"--height", "10"})
+	if _, height := renderOnceSize(opts); height != 10 {
+		t.Errorf("%d", height)
+	}
+}
+
+func TestWrapLines(t *testing.T) {
+	renderer := tui.NewVirtualRenderer(12, 5)
+	renderer.Init()
+	term := Terminal{tabstop: 8, pointerLen: 2, markerLen: 1, wrap: true, wrapSign: "> ", wrapSignLen: 2,
+		window: renderer.NewWindow(0, 0, 12, 5, false, tui.MakeBorderStyle(tui.BorderNone, false))}
+	wrap := func(text string) []string {
+		lines := []string{}
+		for _, line := range term.wrapLines([]rune(text)) {
+			lines = append(lines, string(line))
+		}
+		return lines
+	}
+	// 8 columns for the first line and 6 for the rest
+	for text, expected := range map[string][]string{
+		"":                  {""},
+		"abcdefgh":          {"abcdefgh"},
+		"abcdefghijklmnopq": {"abcdefgh", "ijklmn", "opq"},
+		"한글한글한글":            {"한글한글", "한글"},
+		"abc한글한글":           {"abc한글", "한글"},
+	} {
+		if lines := wrap(text); !reflect.DeepEqual(lines, expected) {
+			t.Errorf("%q: expected %q, got %q", text, expected, lines)
+		}
+	}
+	item := newItem("abcdefghijklmnopq")
+	if rows := term.itemRows(item); rows != 3 {
+		t.Errorf("%d", rows)
+	}
+	term.wrap = false
+	if rows := term.itemRows(item); rows != 1 {
+		t.Errorf("%d", rows)
+	}
+}
+
+func TestScanStatusLines(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("foo\nbar 1%\rbar 2%\r\nbaz"))
+	scanner.Split(scanStatusLines)
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if expected := []string{"foo", "bar 1%", "bar 2%", "", "baz"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("%q", lines)
+	}
+}
+
+func TestWordPatterns(t *testing.T) {
+	check := func(bounds algo.Boundaries, str string, last int, first int) {
+		t.Helper()
+		rubout, next := wordPatterns(bounds)
+		if idx := findLastMatch(rubout, str) + 1; idx != last {
+			t.Errorf("%v: backward-word on %q: %d (expected: %d)", bounds, str, idx, last)
+		}
+		if idx := findFirstMatch(next, str) + 1; idx != first {
+			t.Errorf("%v: forward-word on %q: %d (expected: %d)", bounds, str, idx, first)
+		}
+	}
+	check(algo.Boundaries{}, "foo_barBaz1", 0, 11)
+	check(algo.Boundaries{Snake: true}, "foo_barBaz1", 4, 3)
+	check(algo.Boundaries{Camel: true}, "foo_barBaz1", 7, 7)
+	check(algo.Boundaries{Digit: true}, "foo_barBaz1", 10, 10)
+	check(algo.DefaultBoundaries, "foo_barBaz1", 10, 3)
+}
+
+func TestMenuKey(t *testing.T) {
+	term := &Terminal{menu: []menuEntry{{label: "Open", key: 'o'}, {label: "Delete", key: 'd'}, {label: "Rename", key: 'r'}}}
+	press := func(event tui.Event) (string, bool) {
+		t.Helper()
+		entry, ok := term.menuKey(event)
+		if entry == nil {
+			return "", ok
+		}
+		return entry.label, ok
+	}
+
+	term.menuOpen = &contextMenu{}
+	press(tui.Up.AsEvent())
+	if term.menuOpen.cursor != 2 {
+		t.Errorf("cursor should wrap around: %d", term.menuOpen.cursor)
+	}
+	press(tui.Down.AsEvent())
+	if label, ok := press(tui.CtrlM.AsEvent()); label != "Open" || !ok || term.menuOpen != nil {
+		t.Errorf("enter should pick the entry under the cursor: %q", label)
+	}
+
+	term.menuOpen = &contextMenu{}
+	if label, ok := press(tui.Key('D')); label != "Delete" || !ok || term.menuOpen != nil {
+		t.Errorf("shortcut should pick the entry: %q", label)
+	}
+
+	term.menuOpen = &contextMenu{}
+	if label, ok := press(tui.ESC.AsEvent()); label != "" || !ok || term.menuOpen != nil {
+		t.Error("esc should close the menu")
+	}
+
+	term.menuOpen = &contextMenu{}
+	if label, ok := press(tui.Key('x')); label != "" || ok || term.menuOpen != nil {
+		t.Error("other key should close the menu with feedback")
+	}
+}
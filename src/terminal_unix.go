@@ -3,9 +3,18 @@
 package fzf
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+
+	"github.com/junegunn/fzf/src/util"
 )
 
 func notifyOnResize(resizeChan chan<- os.Signal) {
@@ -19,3 +28,83 @@ func notifyStop(p *os.Process) {
 func notifyOnCont(resizeChan chan<- os.Signal) {
 	signal.Notify(resizeChan, syscall.SIGCONT)
 }
+
+// runInTmuxPane runs fzf again in a popup of tmux over the given pane instead
+// of taking over the terminal of the pane, which is owned by the process
+// running in it. The control mode of tmux is not used, as it would require
+// fzf to render through tmux commands instead of its own renderer. The standard input is passed to the popup through a named
+// pipe, and the output and the exit status are read back from the files
+// after the popup is closed.
+func runInTmuxPane(target string) (int, error) {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_id} #{pane_width} #{pane_height}").Output()
+	pane := strings.Fields(string(out))
+	if err != nil || len(pane) != 3 {
+		return exitError, errors.New("cannot find tmux pane: " + target)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return exitError, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return exitError, err
+	}
+	dir, err := ioutil.TempDir("", "fzf-tmux-")
+	if err != nil {
+		return exitError, err
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+	status := filepath.Join(dir, "status")
+
+	// The popup does not inherit the environment of this process
+	var command strings.Builder
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "FZF_") || strings.HasPrefix(env, "SHELL=") {
+			command.WriteString("export " + quoteEntry(env) + "; ")
+		}
+	}
+	command.WriteString(quoteEntry(executable))
+	for _, arg := range os.Args[1:] {
+		command.WriteString(" " + quoteEntry(arg))
+	}
+	command.WriteString(" --no-tmux-pane")
+	if !util.IsTty() {
+		input := filepath.Join(dir, "input")
+		if err := syscall.Mkfifo(input, 0600); err != nil {
+			return exitError, err
+		}
+		command.WriteString(" < " + quoteEntry(input))
+		go func() {
+			if fifo, err := os.OpenFile(input, os.O_WRONLY, 0); err == nil {
+				io.Copy(fifo, os.Stdin)
+				fifo.Close()
+			}
+		}()
+	}
+	command.WriteString(" > " + quoteEntry(output) + "; echo $? > " + quoteEntry(status))
+
+	// The popup covers the pane, and the command returns when it is closed.
+	// The script is run by sh as it is written in POSIX syntax, while tmux
+	// would run a single command string with default-shell of the user. The
+	// arguments after the first one are given to the command as they are.
+	popup := exec.Command("tmux", "display-popup", "-E", "-B", "-t", pane[0],
+		"-x", "P", "-y", "P", "-w", pane[1], "-h", pane[2], "-d", cwd, "sh", "-c", command.String())
+	popup.Stderr = os.Stderr
+	if err := popup.Run(); err != nil {
+		return exitError, errors.New("failed to open tmux popup: " + err.Error())
+	}
+	if result, err := ioutil.ReadFile(output); err == nil {
+		os.Stdout.Write(result)
+	}
+	code, err := ioutil.ReadFile(status)
+	if err != nil {
+		// The popup is closed before fzf exits
+		return exitInterrupt, nil
+	}
+	exit, err := strconv.Atoi(strings.TrimSpace(string(code)))
+	if err != nil {
+		return exitError, nil
+	}
+	return exit, nil
+}
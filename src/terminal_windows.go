@@ -3,6 +3,7 @@
 package fzf
 
 import (
+	"errors"
 	"os"
 )
 
@@ -17,3 +18,7 @@ func notifyStop(p *os.Process) {
 func notifyOnCont(resizeChan chan<- os.Signal) {
 	// NOOP
 }
+
+func runInTmuxPane(target string) (int, error) {
+	return exitError, errors.New("--tmux-pane is not supported on this platform")
+}
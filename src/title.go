@@ -0,0 +1,91 @@
+package fzf
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/junegunn/fzf/src/tui"
+	"github.com/junegunn/fzf/src/util"
+)
+
+var titleButtonRegexp = regexp.MustCompile(`\[([^\[\]]*)\]\(([^()]+)\)`)
+
+// titleSegment is a part of the title. Clicking a segment with a key triggers
+// the actions bound to the key.
+type titleSegment struct {
+	text string
+	key  *tui.Event
+}
+
+// titleButton is the range of columns of a clickable segment on the title row
+type titleButton struct {
+	from int
+	to   int
+	key  tui.Event
+}
+
+// parseTitle splits the title into plain text and clickable segments written
+// as [TEXT](KEY)
+func parseTitle(str string) []titleSegment {
+	segments := []titleSegment{}
+	prev := 0
+	for _, loc := range titleButtonRegexp.FindAllStringSubmatchIndex(str, -1) {
+		if loc[0] > prev {
+			segments = append(segments, titleSegment{text: str[prev:loc[0]]})
+		}
+		name := str[loc[4]:loc[5]]
+		chords := parseKeyChords(name, "key name required")
+		if len(chords) != 1 {
+			errorExit("invalid key in title: " + name)
+		}
+		for key := range chords {
+			key := key
+			segments = append(segments, titleSegment{text: str[loc[2]:loc[3]], key: &key})
+		}
+		prev = loc[1]
+	}
+	if prev < len(str) {
+		segments = append(segments, titleSegment{text: str[prev:]})
+	}
+	return segments
+}
+
+// expandTitle replaces {q}, {matches}, and {total} in the text of a segment
+func (t *Terminal) expandTitle(text string) string {
+	found := t.merger.Length()
+	return strings.NewReplacer(
+		"{q}", string(t.input),
+		"{matches}", strconv.Itoa(found),
+		"{total}", strconv.Itoa(util.Max(found, t.count))).Replace(text)
+}
+
+func (t *Terminal) printTitle() {
+	if t.twindow == nil {
+		return
+	}
+	t.titleButtons = t.titleButtons[:0]
+	t.twindow.MoveAndClear(0, 0)
+	x := 0
+	for _, segment := range t.title {
+		runes, _ := t.trimRight([]rune(t.expandTitle(segment.text)), t.twindow.Width()-x)
+		width := t.displayWidth(runes)
+		if segment.key == nil {
+			t.twindow.CPrint(tui.ColHeader, string(runes))
+		} else {
+			t.twindow.CPrint(tui.ColPrompt, string(runes))
+			t.titleButtons = append(t.titleButtons, titleButton{x, x + width, *segment.key})
+		}
+		x += width
+	}
+}
+
+// titleButtonAt returns the key of the clickable segment at the column
+func (t *Terminal) titleButtonAt(x int) (tui.Event, bool) {
+	for _, button := range t.titleButtons {
+		if x >= button.from && x < button.to {
+			return button.key, true
+		}
+	}
+	return tui.Event{}, false
+}
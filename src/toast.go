@@ -0,0 +1,93 @@
+package fzf
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// actionError is the error of the actions of a binding shown in the toast. It
+// is also appended to the file given with --log-file as a line of JSON.
+type actionError struct {
+	Time    time.Time `json:"time"`
+	Binding string    `json:"binding"`
+	Spec    string    `json:"action"`
+	Message string    `json:"error"`
+}
+
+// write appends the error to the log file
+func (e actionError) write(path string) error {
+	// The commands are not to be escaped for HTML
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(e); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data.Bytes())
+	return err
+}
+
+// toast is the message shown in a popup until the next key is pressed
+type toast struct {
+	label   string
+	binding string
+	text    string
+}
+
+// reportError shows the error of the actions of the binding in the toast until
+// the next key is pressed, and records it in the log file. The error of the
+// log file is shown in the toast below the error.
+func (t *Terminal) reportError(binding string, spec string, message string) {
+	t.toast = &toast{label: " Error ", binding: binding, text: message}
+	if len(t.logFile) > 0 {
+		if err := (actionError{Time: time.Now(), Binding: binding, Spec: spec, Message: message}).write(t.logFile); err != nil {
+			t.toast.text += "\n" + err.Error()
+		}
+	}
+}
+
+// showDryRun shows the command of the binding in the toast instead of running
+// it. The temporary files of the placeholders are kept until the next command
+// so that they can be inspected.
+func (t *Terminal) showDryRun(binding string, command string) {
+	t.toast = &toast{label: " Dry run ", binding: binding, text: command}
+}
+
+// printToast shows the toast in the popup. The lines of the text are wrapped
+// to fit in the width of the list.
+func (t *Terminal) printToast() {
+	keyWidth := t.displayWidth([]rune(t.toast.binding))
+	width := util.Max(t.window.Width()-keyWidth-6, 1)
+	hints := []bindingHint{}
+	for _, line := range strings.Split(t.toast.text, "\n") {
+		text := []rune(line)
+		for {
+			chunk, _ := t.trimRight(text, width)
+			if len(chunk) == 0 && len(text) > 0 {
+				// The first character does not fit in the width
+				length, _ := util.NextGrapheme(text, 0, t.tabstop)
+				chunk = text[:length]
+			}
+			key := ""
+			if len(hints) == 0 {
+				key = t.toast.binding
+			}
+			hints = append(hints, bindingHint{key: key, desc: string(chunk)})
+			text = text[len(chunk):]
+			if len(text) == 0 {
+				break
+			}
+		}
+	}
+	t.printPopup(t.toast.label, hints, -1)
+}
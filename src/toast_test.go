@@ -0,0 +1,65 @@
+package fzf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReportError(t *testing.T) {
+	f, _ := ioutil.TempFile("", "fzf-log-file")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	term := Terminal{logFile: f.Name()}
+	term.reportError("ctrl-x>ctrl-e", "execute(vim {} < /dev/tty)", "exit status 127: vim foo")
+	term.reportError("enter", "transform:echo foo", "unknown action: foo")
+	if term.toast == nil || term.toast.binding != "enter" || term.toast.text != "unknown action: foo" {
+		t.Errorf("Unexpected toast: %v", term.toast)
+	}
+
+	data, _ := ioutil.ReadFile(f.Name())
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries: %s", data)
+	}
+	if !strings.Contains(lines[0], "{} < /dev/tty") {
+		t.Errorf("The command should not be escaped: %s", lines[0])
+	}
+	var entry actionError
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Binding != "ctrl-x>ctrl-e" || entry.Spec != "execute(vim {} < /dev/tty)" ||
+		entry.Message != "exit status 127: vim foo" || entry.Time.IsZero() {
+		t.Errorf("Unexpected entry: %s", lines[0])
+	}
+}
+
+func TestReportErrorWithoutLogFile(t *testing.T) {
+	term := Terminal{}
+	term.reportError("ctrl-o", "execute(foo)", "exit status 127: foo")
+	if term.toast == nil || term.toast.binding != "ctrl-o" || term.toast.label != " Error " {
+		t.Errorf("Unexpected toast: %v", term.toast)
+	}
+}
+
+func TestReportErrorLogFileError(t *testing.T) {
+	term := Terminal{logFile: t.TempDir()}
+	term.reportError("ctrl-o", "execute(foo)", "exit status 127: foo")
+	lines := strings.Split(term.toast.text, "\n")
+	if len(lines) != 2 || lines[0] != "exit status 127: foo" || !strings.Contains(lines[1], term.logFile) {
+		t.Errorf("Unexpected toast: %v", term.toast)
+	}
+}
+
+func TestShowDryRun(t *testing.T) {
+	term := Terminal{}
+	term.showDryRun("enter", "vim 'foo bar'")
+	if term.toast == nil || term.toast.label != " Dry run " || term.toast.binding != "enter" ||
+		term.toast.text != "vim 'foo bar'" {
+		t.Errorf("Unexpected toast: %v", term.toast)
+	}
+}
@@ -14,14 +14,16 @@ const rangeEllipsis = 0
 
 // Range represents nth-expression
 type Range struct {
-	begin int
-	end   int
+	begin  int
+	end    int
+	weight int // Weight of the scores of the matches in the fields, or 0
 }
 
 // Token contains the tokenized part of the strings and its prefix length
 type Token struct {
 	text         *util.Chars
 	prefixLength int32
+	weight       int
 }
 
 // String returns the string representation of a Token.
@@ -47,7 +49,7 @@ func newRange(begin int, end int) Range {
 	if end == -1 {
 		end = rangeEllipsis
 	}
-	return Range{begin, end}
+	return Range{begin: begin, end: end}
 }
 
 // ParseRange parses nth-expression and returns the corresponding Range object
@@ -92,7 +94,7 @@ func withPrefixLengths(tokens []string, begin int) []Token {
 	prefixLength := begin
 	for idx := range tokens {
 		chars := util.ToChars([]byte(tokens[idx]))
-		ret[idx] = Token{&chars, int32(prefixLength)}
+		ret[idx] = Token{text: &chars, prefixLength: int32(prefixLength)}
 		prefixLength += chars.Length()
 	}
 	return ret
@@ -104,7 +106,9 @@ const (
 	awkWhite
 )
 
-func awkTokenizer(input string) ([]string, int) {
+// awkTokenizer splits the input into the tokens of AWK-style. It stops at the
+// limit of the number of the tokens if it is positive.
+func awkTokenizer(input string, limit int) ([]string, int) {
 	// 9, 32
 	ret := []string{}
 	prefixLength := 0
@@ -131,6 +135,9 @@ func awkTokenizer(input string) ([]string, int) {
 				end = idx + 1
 			} else {
 				ret = append(ret, input[begin:end])
+				if len(ret) == limit {
+					return ret, prefixLength
+				}
 				state, begin, end = awkBlack, idx, idx+1
 			}
 		}
@@ -143,20 +150,31 @@ func awkTokenizer(input string) ([]string, int) {
 
 // Tokenize tokenizes the given string with the delimiter
 func Tokenize(text string, delimiter Delimiter) []Token {
+	return tokenizeN(text, delimiter, 0)
+}
+
+// tokenizeN tokenizes the given string with the delimiter up to the limit of
+// the number of the tokens. The rest of the string is discarded. There is no
+// limit if it is not positive.
+func tokenizeN(text string, delimiter Delimiter, limit int) []Token {
 	if delimiter.str == nil && delimiter.regex == nil {
 		// AWK-style (\S+\s*)
-		tokens, prefixLength := awkTokenizer(text)
+		tokens, prefixLength := awkTokenizer(text, limit)
 		return withPrefixLengths(tokens, prefixLength)
 	}
 
 	if delimiter.str != nil {
-		return withPrefixLengths(strings.SplitAfter(text, *delimiter.str), 0)
+		if limit <= 0 {
+			return withPrefixLengths(strings.SplitAfter(text, *delimiter.str), 0)
+		}
+		tokens := strings.SplitAfterN(text, *delimiter.str, limit+1)
+		return withPrefixLengths(tokens[:util.Min(len(tokens), limit)], 0)
 	}
 
 	// FIXME performance
 	var tokens []string
 	if delimiter.regex != nil {
-		for len(text) > 0 {
+		for len(text) > 0 && (limit <= 0 || len(tokens) < limit) {
 			loc := delimiter.regex.FindStringIndex(text)
 			if len(loc) < 2 {
 				loc = []int{0, len(text)}
@@ -177,6 +195,67 @@ func joinTokens(tokens []Token) string {
 	return output.String()
 }
 
+// contains returns true if the 1-based index of the token is in the range
+func (r Range) contains(idx int, numTokens int) bool {
+	begin, end := r.begin, r.end
+	if begin == rangeEllipsis {
+		begin = 1
+	} else if begin < 0 {
+		begin += numTokens + 1
+	}
+	if end == rangeEllipsis {
+		end = numTokens
+	} else if end < 0 {
+		end += numTokens + 1
+	}
+	return idx >= begin && idx <= end
+}
+
+// StripLastDelimiter removes the trailing delimiter from the string
+func StripLastDelimiter(str string, delimiter Delimiter) string {
+	if delimiter.str != nil {
+		return strings.TrimSuffix(str, *delimiter.str)
+	} else if delimiter.regex != nil {
+		locs := delimiter.regex.FindAllStringIndex(str, -1)
+		if len(locs) > 0 && locs[len(locs)-1][1] == len(str) {
+			return str[:locs[len(locs)-1][0]]
+		}
+		return str
+	}
+	return strings.TrimRight(str, " \t")
+}
+
+// SplitFields separates the tokens in the ranges from the others, and returns
+// both of them joined. The delimiter left at the end of each part after the
+// separation is removed.
+func SplitFields(tokens []Token, ranges []Range, delimiter Delimiter) (string, string) {
+	var rest, selected bytes.Buffer
+	restLast, selectedLast := false, false
+	for idx, token := range tokens {
+		in := false
+		for _, r := range ranges {
+			if r.contains(idx+1, len(tokens)) {
+				in = true
+				break
+			}
+		}
+		if in {
+			selected.WriteString(token.text.ToString())
+		} else {
+			rest.WriteString(token.text.ToString())
+		}
+		restLast, selectedLast = !in, in
+	}
+	restStr, selectedStr := rest.String(), selected.String()
+	if !restLast {
+		restStr = StripLastDelimiter(restStr, delimiter)
+	}
+	if !selectedLast {
+		selectedStr = StripLastDelimiter(selectedStr, delimiter)
+	}
+	return restStr, selectedStr
+}
+
 // Transform is used to transform the input when --with-nth option is given
 func Transform(tokens []Token, withNth []Range) []Token {
 	transTokens := make([]Token, len(withNth))
@@ -247,7 +326,7 @@ func Transform(tokens []Token, withNth []Range) []Token {
 		} else {
 			prefixLength = 0
 		}
-		transTokens[idx] = Token{&merged, prefixLength}
+		transTokens[idx] = Token{text: &merged, prefixLength: prefixLength, weight: r.weight}
 	}
 	return transTokens
 }
@@ -2,6 +2,8 @@ package fzf
 
 import (
 	"testing"
+
+	"github.com/junegunn/fzf/src/util"
 )
 
 func TestParseRange(t *testing.T) {
@@ -64,6 +66,23 @@ func TestTokenize(t *testing.T) {
 		tokens[3].text.ToString() != "ghi  " || tokens[3].prefixLength != 14 {
 		t.Errorf("%s", tokens)
 	}
+
+	// Only the leading tokens
+	for _, delimiter := range []Delimiter{{}, delimiterRegexp(":"), delimiterRegexp("\\s+")} {
+		all := Tokenize(input, delimiter)
+		for limit := 1; limit <= len(all)+1; limit++ {
+			tokens = tokenizeN(input, delimiter, limit)
+			if len(tokens) != util.Min(limit, len(all)) {
+				t.Errorf("%d: %s", limit, tokens)
+				continue
+			}
+			for idx, token := range tokens {
+				if token.text.ToString() != all[idx].text.ToString() || token.prefixLength != all[idx].prefixLength {
+					t.Errorf("%d: %s != %s", limit, tokens, all)
+				}
+			}
+		}
+	}
 }
 
 func TestTransform(t *testing.T) {
@@ -107,6 +126,21 @@ func TestTransform(t *testing.T) {
 	}
 }
 
+func TestSplitFields(t *testing.T) {
+	test := func(input string, nth string, delimiter Delimiter, rest string, selected string) {
+		r, s := SplitFields(Tokenize(input, delimiter), splitNth(nth), delimiter)
+		if r != rest || s != selected {
+			t.Errorf("%s / %s: %q, %q", input, nth, r, s)
+		}
+	}
+	test("abc  def  ghi", "2", Delimiter{}, "abc  ghi", "def")
+	test("abc  def  ghi", "-1", Delimiter{}, "abc  def", "ghi")
+	test("abc  def  ghi", "2..", Delimiter{}, "abc", "def  ghi")
+	test("abc:def:ghi", "1,3", delimiterRegexp(":"), "def", "abc:ghi")
+	test("abc::def", "2", delimiterRegexp(":+"), "abc", "def")
+	test("abc", "2", Delimiter{}, "abc", "")
+}
+
 func TestTransformIndexOutOfBounds(t *testing.T) {
 	Transform([]Token{}, splitNth("1"))
 }
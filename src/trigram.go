@@ -0,0 +1,130 @@
+package fzf
+
+import (
+	"unicode"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+// The trigram index of a chunk is the bitmap of the hashes of the sequences of
+// three characters in its items, folded to lowercase and normalized. The
+// chunks whose bitmaps lack any trigram of the substrings the pattern requires
+// cannot have matching items and are skipped. As the trigrams only tell the
+// substrings, the index also has the bitmask of the characters of the items
+// for the prefilter, so that the chunks lacking any character of the fuzzy
+// terms are skipped as well. It is only built for the full chunks of large
+// inputs, as it takes 1KB for each chunk.
+const trigramBits = 8192
+
+type trigramIndex struct {
+	mask uint64 // Union of the bitmasks of the characters of the items
+	bits [trigramBits / 64]uint64
+}
+
+// foldTrigramRune converts the character as the case-insensitive matching
+// with normalization does
+func foldTrigramRune(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 32
+	}
+	if r > unicode.MaxASCII {
+		r = unicode.To(unicode.LowerCase, r)
+	}
+	return r
+}
+
+func trigramHash(a rune, b rune, c rune) uint32 {
+	h := (uint32(a)*31+uint32(b))*31 + uint32(c)
+	// Fibonacci hashing to the upper bits
+	return (h * 0x9E3779B1) >> 19
+}
+
+// trigrams returns the hashes of the trigrams of the runes
+func trigrams(runes []rune) []uint32 {
+	folded := make([]rune, len(runes))
+	for idx, r := range runes {
+		folded[idx] = foldTrigramRune(r)
+	}
+	folded = algo.NormalizeRunes(folded)
+	hashes := []uint32{}
+	for idx := 2; idx < len(folded); idx++ {
+		hashes = append(hashes, trigramHash(folded[idx-2], folded[idx-1], folded[idx]))
+	}
+	return hashes
+}
+
+func (index *trigramIndex) add(chars *util.Chars) {
+	if chars.IsBytes() {
+		bytes := chars.Bytes()
+		for idx := 2; idx < len(bytes); idx++ {
+			h := trigramHash(
+				foldTrigramRune(rune(bytes[idx-2])), foldTrigramRune(rune(bytes[idx-1])), foldTrigramRune(rune(bytes[idx])))
+			index.bits[h/64] |= 1 << (h % 64)
+		}
+		return
+	}
+	for _, h := range trigrams(chars.ToRunes()) {
+		index.bits[h/64] |= 1 << (h % 64)
+	}
+}
+
+// buildTrigramIndex builds the trigram index of the chunk from the texts of
+// the items that can be searched, including the hidden text of --search-field
+func buildTrigramIndex(chunk *Chunk) *trigramIndex {
+	index := new(trigramIndex)
+	for idx := 0; idx < chunk.count; idx++ {
+		item := &chunk.items[idx]
+		index.add(&item.text)
+		if item.search != nil {
+			index.add(item.search)
+		}
+		index.mask |= chunk.mask(idx)
+	}
+	return index
+}
+
+// mayMatch tells if the chunk may have the items containing the trigrams and
+// the characters of the mask. The trigram index is built on the first call if
+// the chunk is full. A chunk is only scanned by one matcher goroutine at a
+// time.
+func (c *Chunk) mayMatch(hashes []uint32, mask uint64) bool {
+	if !c.IsFull() {
+		return true
+	}
+	if c.trigrams == nil {
+		c.trigrams = buildTrigramIndex(c)
+	}
+	if c.trigrams.mask&mask != mask {
+		return false
+	}
+	for _, h := range hashes {
+		if c.trigrams.bits[h/64]&(1<<(h%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// patternTrigrams returns the hashes of the trigrams every matching item
+// should have. They are only taken from the terms matched as substrings.
+func (p *Pattern) patternTrigrams() []uint32 {
+	var hashes []uint32
+	if !p.extended {
+		if !p.fuzzy && !p.regex {
+			hashes = trigrams(p.text)
+		}
+		return hashes
+	}
+	for _, termSet := range p.termSets {
+		// Any of the terms of OR operator may match
+		if len(termSet) > 1 || termSet[0].inv {
+			continue
+		}
+		switch termSet[0].typ {
+		case termExact, termPrefix, termSuffix, termEqual:
+			hashes = append(hashes, trigrams(termSet[0].text)...)
+		}
+	}
+	return hashes
+}
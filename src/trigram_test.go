@@ -0,0 +1,71 @@
+package fzf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+func TestPatternTrigrams(t *testing.T) {
+	test := func(fuzzy bool, extended bool, str string, expected int) {
+		clearPatternCache()
+		pat := BuildPattern(PatternOptions{Fuzzy: fuzzy, FuzzyAlgo: algo.FuzzyMatchV2, Extended: extended, Normalize: true, Forward: true, Cacheable: true, Prefilter: true, Typos: 1}, []rune(str))
+		if len(pat.trigrams) != expected {
+			t.Errorf("%q: expected %d trigrams, got %d", str, expected, len(pat.trigrams))
+		}
+	}
+	test(true, true, "foobar", 0)
+	test(true, true, "'foobar", 4)
+	test(true, true, "^foo bar$ ^baz$", 3)
+	test(true, true, "'foo | 'bar !'baz ~qux", 0)
+	test(false, true, "foo 'bar", 1)
+	test(false, false, "foo bar", 5)
+	test(true, false, "foo bar", 0)
+	clearPatternCache()
+}
+
+func TestTrigramIndex(t *testing.T) {
+	search := util.ToChars([]byte("hidden"))
+	chunk := &Chunk{count: chunkSize}
+	for idx := 0; idx < chunkSize; idx++ {
+		chunk.items[idx] = Item{text: util.ToChars([]byte(fmt.Sprintf("src/item%d.go", idx)))}
+		chunk.items[idx].text.Index = int32(idx)
+	}
+	chunk.items[10].text = util.ToChars([]byte("Café au lait"))
+	chunk.items[20].search = &search
+
+	for _, query := range []string{"'ITEM1 'go", "^src/ 'cafe", "'Café", "'hidd", "^item", "'lait$", "'xyz", "'fo 'bar", "sigo", "hdn", "xyz", "qux"} {
+		clearPatternCache()
+		pat := BuildPattern(PatternOptions{Fuzzy: true, FuzzyAlgo: algo.FuzzyMatchV2, Extended: true, Normalize: true, Forward: true, Prefilter: true, Typos: 1}, []rune(query))
+		matches := pat.matchChunk(chunk, nil, slab)
+		if !chunk.mayMatch(pat.trigrams, pat.mask) && len(matches) > 0 {
+			t.Errorf("%q: chunk skipped with %d matches", query, len(matches))
+		}
+	}
+	if chunk.trigrams == nil {
+		t.Error("trigram index not built")
+	}
+	if hashes := trigrams([]rune("xyz")); chunk.mayMatch(hashes, 0) {
+		t.Error("chunk without xyz not skipped")
+	}
+	// The non-ASCII characters may match any character of the pattern
+	if !chunk.mayMatch(nil, maskBit('x')) {
+		t.Error("chunk with non-ASCII characters skipped")
+	}
+	chunk.items[10].text = util.ToChars([]byte("cafe au lait"))
+	chunk.trigrams = nil
+	chunk.masks = nil
+	if !chunk.mayMatch(nil, maskBit('s')|maskBit('g')) || chunk.mayMatch(nil, maskBit('s')|maskBit('x')) {
+		t.Error("chunk should only be skipped when it lacks the characters")
+	}
+
+	// The index is not built for the chunk that is not full
+	partial := &Chunk{count: 1}
+	partial.items[0] = Item{text: util.ToChars([]byte("foo"))}
+	if !partial.mayMatch(trigrams([]rune("xyz")), maskBit('x')) || partial.trigrams != nil {
+		t.Error("partial chunk should not be indexed")
+	}
+	clearPatternCache()
+}
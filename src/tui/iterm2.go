@@ -0,0 +1,43 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// ITerm2FileRegexp matches an inline image of iTerm2:
+// OSC 1337 ; File = <arguments> : <payload> BEL/ST
+var ITerm2FileRegexp = regexp.MustCompile("^\x1b]1337;File=([^:\x07\x1b]*):([^\x07\x1b]*)(?:\x07|\x1b\\\\)$")
+
+// iterm2Transmit rewrites the arguments of the inline image so that iTerm2
+// scales it to the given number of cells
+func iterm2Transmit(data string, cols int, rows int) string {
+	loc := ITerm2FileRegexp.FindStringSubmatchIndex(data)
+	if loc == nil {
+		return data
+	}
+	args := []string{}
+	for _, arg := range strings.Split(data[loc[2]:loc[3]], ";") {
+		key := strings.SplitN(arg, "=", 2)[0]
+		if len(arg) > 0 && key != "width" && key != "height" && key != "preserveAspectRatio" {
+			args = append(args, arg)
+		}
+	}
+	args = append(args, fmt.Sprintf("width=%d", cols), fmt.Sprintf("height=%d", rows), "preserveAspectRatio=1")
+	return passthrough("\x1b]1337;File=" + strings.Join(args, ";") + data[loc[3]:])
+}
+
+// fitImage scales down the size of the image in cells to fit in the given
+// bounds while keeping its aspect ratio
+func fitImage(cols int, rows int, maxCols int, maxRows int) (int, int) {
+	if cols <= 0 || rows <= 0 || maxCols <= 0 || maxRows <= 0 {
+		return 0, 0
+	}
+	if cols*maxRows > rows*maxCols {
+		return maxCols, util.Max(1, rows*maxCols/cols)
+	}
+	return util.Max(1, cols*maxRows/rows), maxRows
+}
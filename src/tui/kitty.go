@@ -0,0 +1,43 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KittyCommandRegexp matches a command of the kitty graphics protocol:
+// APC G <control data> [; <payload>] ST
+var KittyCommandRegexp = regexp.MustCompile("\x1b_G([^;\x1b]*)(?:;([^\x1b]*))?\x1b\\\\")
+
+// kittyTransmit rewrites the commands of the kitty graphics protocol to
+// transmit and display the image with the given ID. The terminal is asked not
+// to respond to the commands as the response would be read as user input.
+func kittyTransmit(data string, id int) string {
+	first := true
+	return KittyCommandRegexp.ReplaceAllStringFunc(data, func(command string) string {
+		match := KittyCommandRegexp.FindStringSubmatch(command)
+		// The payload along with the semicolon before it
+		payload := command[len("\x1b_G")+len(match[1]) : len(command)-len("\x1b\\")]
+		keys := []string{}
+		for _, pair := range strings.Split(match[1], ",") {
+			key := strings.SplitN(pair, "=", 2)[0]
+			if len(pair) == 0 || key == "q" || first && (key == "i" || key == "I" || key == "p") {
+				continue
+			}
+			keys = append(keys, pair)
+		}
+		if first {
+			keys = append(keys, fmt.Sprintf("i=%d", id))
+			first = false
+		}
+		keys = append(keys, "q=2")
+		return passthrough("\x1b_G" + strings.Join(keys, ",") + payload + "\x1b\\")
+	})
+}
+
+// kittyDelete returns the command to delete the image with the given ID and
+// to free its data
+func kittyDelete(id int) string {
+	return passthrough(fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", id))
+}
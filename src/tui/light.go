@@ -8,9 +8,11 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/junegunn/fzf/src/util"
+	"github.com/mattn/go-runewidth"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -23,12 +25,19 @@ const (
 	escPollInterval = 5
 	offsetPollTries = 10
 	maxInputBuffer  = 10 * 1024
+	maxPasteLength  = 1024 * 1024 // The rest of the pasted text is discarded
 )
 
 const consoleDevice string = "/dev/tty"
 
 var offsetRegexp *regexp.Regexp = regexp.MustCompile("(.*)\x1b\\[([0-9]+);([0-9]+)R")
+var cursorRegexp *regexp.Regexp = regexp.MustCompile("\x1b\\[([0-9]+);([0-9]+)R")
 var offsetRegexpBegin *regexp.Regexp = regexp.MustCompile("^\x1b\\[[0-9]+;[0-9]+R")
+var paletteRegexp *regexp.Regexp = regexp.MustCompile("\x1b\\]4;([0-9]+);rgb:([0-9a-fA-F]+)/([0-9a-fA-F]+)/([0-9a-fA-F]+)(?:\x07|\x1b\\\\)")
+var keyboardRegexp *regexp.Regexp = regexp.MustCompile("\x1b\\[\\?[0-9]+u")
+
+// CSI code[:alternate-codes] [; modifiers[:event-type] [; text]] u
+var kittyKeyRegexp *regexp.Regexp = regexp.MustCompile("^\x1b\\[([0-9]+)(?::[0-9]*)*(?:;([0-9]*)(?::[0-9]+)?(?:;[0-9:]*)?)?u")
 
 func (r *LightRenderer) stderr(str string) {
 	r.stderrInternal(str, true)
@@ -59,7 +68,7 @@ func (r *LightRenderer) csi(code string) {
 
 func (r *LightRenderer) flush() {
 	if len(r.queued) > 0 {
-		fmt.Fprint(os.Stderr, r.queued)
+		fmt.Fprint(os.Stderr, util.EncodeLocale(r.queued))
 		r.queued = ""
 	}
 }
@@ -68,8 +77,10 @@ func (r *LightRenderer) flush() {
 type LightRenderer struct {
 	theme         *ColorTheme
 	mouse         bool
+	hover         bool
 	forceBlack    bool
 	clearOnExit   bool
+	doubleClick   time.Duration
 	prevDownTime  time.Time
 	clickY        []int
 	ttyin         *os.File
@@ -77,6 +88,7 @@ type LightRenderer struct {
 	origState     *terminal.State
 	width         int
 	height        int
+	ttyHeight     int
 	yoffset       int
 	tabstop       int
 	escDelay      int
@@ -85,7 +97,21 @@ type LightRenderer struct {
 	queued        string
 	y             int
 	x             int
+	cellWidth     int // in pixels, 0 if unknown
+	cellHeight    int // in pixels, 0 if unknown
+	imageID       int
+	images        map[int]bool
 	maxHeightFunc func(int) int
+	screen        *lightScreen
+	kitty         bool // Keyboard protocol of kitty enabled
+	noOffset      bool // The terminal did not report the cursor position
+	pasted        string
+	pasting       bool   // Bracketed paste in progress
+	pasteText     []byte // Text of the paste read so far
+	pasteTail     []byte // Last bytes that can be the beginning of the end of the paste
+	windows       []Window
+	shadow        shadowStyle
+	mux           multiplexer
 
 	// Windows only
 	ttyinChannel    chan byte
@@ -100,6 +126,7 @@ type LightWindow struct {
 	colored  bool
 	preview  bool
 	border   BorderStyle
+	labels   []BorderLabel
 	top      int
 	left     int
 	width    int
@@ -109,20 +136,28 @@ type LightWindow struct {
 	tabstop  int
 	fg       Color
 	bg       Color
+	images   []int
+	flash    bool
+	shadow   rune
 }
 
-func NewLightRenderer(theme *ColorTheme, forceBlack bool, mouse bool, tabstop int, clearOnExit bool, fullscreen bool, maxHeightFunc func(int) int) Renderer {
+func NewLightRenderer(theme *ColorTheme, forceBlack bool, mouse bool, hover bool, clickInterval time.Duration, tabstop int, clearOnExit bool, fullscreen bool, maxHeightFunc func(int) int) Renderer {
 	r := LightRenderer{
 		theme:         theme,
 		forceBlack:    forceBlack,
 		mouse:         mouse,
+		hover:         hover,
+		doubleClick:   clickInterval,
 		clearOnExit:   clearOnExit,
 		ttyin:         openTtyIn(),
+		images:        make(map[int]bool),
 		yoffset:       0,
 		tabstop:       tabstop,
 		fullscreen:    fullscreen,
 		upOneLine:     false,
-		maxHeightFunc: maxHeightFunc}
+		maxHeightFunc: maxHeightFunc,
+		mux:           detectMultiplexer(),
+		screen:        &lightScreen{}}
 	return &r
 }
 
@@ -148,7 +183,15 @@ func (r *LightRenderer) Init() {
 		errorExit(err.Error())
 	}
 	r.updateTerminalSize()
-	initTheme(r.theme, r.defaultTheme(), r.forceBlack)
+	r.resizeScreen()
+	baseTheme := r.defaultTheme()
+	var palette *Palette
+	if r.needsPalette(baseTheme) {
+		palette = r.queryPalette()
+	}
+	initTheme(r.theme, baseTheme, r.forceBlack, palette)
+	r.shadow = makeShadowStyle(r.theme)
+	r.kitty = r.queryKeyboard()
 
 	if r.fullscreen {
 		r.smcup()
@@ -172,9 +215,17 @@ func (r *LightRenderer) Init() {
 			r.makeSpace()
 		}
 	}
+	// The main and the alternate screens have their own keyboard modes
+	r.pushKeyboard()
 
 	if r.mouse {
 		r.csi("?1000h")
+		// Button-event tracking to receive the motion while dragging
+		r.csi("?1002h")
+		if r.hover {
+			// Any-event tracking to receive the motion without a button
+			r.csi("?1003h")
+		}
 	}
 	r.csi(fmt.Sprintf("%dA", r.MaxY()-1))
 	r.csi("G")
@@ -187,6 +238,203 @@ func (r *LightRenderer) Init() {
 	}
 }
 
+// The palette is only needed to derive the gutter color from a base color,
+// which requires 24-bit color support
+func (r *LightRenderer) needsPalette(baseTheme *ColorTheme) bool {
+	if !r.theme.Colored || r.theme.Gutter.Color != colUndefined || baseTheme.Gutter.Color != colUndefined {
+		return false
+	}
+	darkBg := r.theme.DarkBg.Color
+	if darkBg == colUndefined {
+		darkBg = baseTheme.DarkBg.Color
+	}
+	return darkBg.isBase16() && supportsTrueColor()
+}
+
+// Windows Terminal does not set COLORTERM, but it supports 24-bit colors
+func supportsTrueColor() bool {
+	colorTerm := os.Getenv("COLORTERM")
+	return colorTerm == "truecolor" || colorTerm == "24bit" || os.Getenv("WT_SESSION") != ""
+}
+
+// Scales a hexadecimal color component of arbitrary length to 8 bits
+func scaleHexComponent(hex []byte) int {
+	value, err := strconv.ParseInt(string(hex), 16, 64)
+	if err != nil || len(hex) > 4 {
+		return -1
+	}
+	return int(value * 255 / (1<<(4*uint(len(hex))) - 1))
+}
+
+// Extracts OSC 4 replies from the input and returns the palette and the rest
+// of the input. Nil is returned if the terminal did not report every color.
+func parsePalette(input []byte) (*Palette, []byte) {
+	palette := Palette{}
+	found := 0
+	for _, match := range paletteRegexp.FindAllSubmatch(input, -1) {
+		index := atoi(string(match[1]), -1)
+		if index < 0 || index >= len(palette) || palette[index] != 0 {
+			continue
+		}
+		red, green, blue := scaleHexComponent(match[2]), scaleHexComponent(match[3]), scaleHexComponent(match[4])
+		if red < 0 || green < 0 || blue < 0 {
+			continue
+		}
+		palette[index] = rgbColor(red, green, blue)
+		found++
+	}
+	rest := paletteRegexp.ReplaceAll(input, []byte{})
+	if found < len(palette) {
+		return nil, rest
+	}
+	return &palette, rest
+}
+
+// The queries below are answered by the terminal through the standard input,
+// which is also the case on Windows where the console is in virtual terminal
+// mode.
+
+func (r *LightRenderer) findOffset() (row int, col int) {
+	r.csi("6n")
+	r.flush()
+	bytes := []byte{}
+	for tries := 0; tries < offsetPollTries; tries++ {
+		bytes = r.getBytesInternal(bytes, tries > 0)
+		offsets := offsetRegexp.FindSubmatch(bytes)
+		if len(offsets) > 3 {
+			// Add anything we skipped over to the input buffer
+			r.buffer = append(r.buffer, offsets[1]...)
+			return atoi(string(offsets[2]), 0) - 1, atoi(string(offsets[3]), 0) - 1
+		}
+	}
+	return -1, -1
+}
+
+// Queries the RGB values of the 16 base colors using OSC 4. Terminals that do
+// not support the sequence ignore it, so we send a cursor position request
+// afterwards to know when to stop waiting.
+func (r *LightRenderer) queryPalette() *Palette {
+	for i := 0; i < len(Palette{}); i++ {
+		r.stderr(fmt.Sprintf("\x1b]4;%d;?\x1b\\", i))
+	}
+	r.csi("6n")
+	r.flush()
+	bytes := []byte{}
+	for tries := 0; tries < offsetPollTries; tries++ {
+		bytes = r.getBytesInternal(bytes, tries > 0)
+		if loc := offsetRegexp.FindSubmatchIndex(bytes); loc != nil {
+			palette, rest := parsePalette(append(bytes[:loc[3]:loc[3]], bytes[loc[1]:]...))
+			r.buffer = append(r.buffer, rest...)
+			return palette
+		}
+	}
+	_, rest := parsePalette(bytes)
+	r.buffer = append(r.buffer, rest...)
+	return nil
+}
+
+// Queries the flags of the keyboard protocol of kitty to know if the terminal
+// supports it. Terminals that do not support the protocol ignore the query, so
+// we send a cursor position request afterwards to know when to stop waiting.
+func (r *LightRenderer) queryKeyboard() bool {
+	r.csi("?u")
+	r.csi("6n")
+	r.flush()
+	bytes := []byte{}
+	for tries := 0; tries < offsetPollTries; tries++ {
+		bytes = r.getBytesInternal(bytes, tries > 0)
+		if loc := offsetRegexp.FindSubmatchIndex(bytes); loc != nil {
+			rest := append(bytes[:loc[3]:loc[3]], bytes[loc[1]:]...)
+			supported := keyboardRegexp.Match(rest)
+			r.buffer = append(r.buffer, keyboardRegexp.ReplaceAll(rest, []byte{})...)
+			return supported
+		}
+	}
+	r.buffer = append(r.buffer, keyboardRegexp.ReplaceAll(bytes, []byte{})...)
+	return false
+}
+
+// pushKeyboard enables the keyboard protocol of kitty if supported, which
+// makes the terminal report the keys with modifiers in CSI u sequences instead
+// of the legacy ones. It also enables the bracketed paste mode so that the
+// pasted text is received as a whole, and the reporting of the focus changes.
+func (r *LightRenderer) pushKeyboard() {
+	if r.kitty {
+		r.csi(">1u")
+	}
+	r.csi("?2004h")
+	r.csi("?1004h")
+}
+
+func (r *LightRenderer) popKeyboard() {
+	r.csi("?1004l")
+	r.csi("?2004l")
+	if r.kitty {
+		r.csi("<u")
+	}
+}
+
+// CanDisplay compares the width of each text on the terminal with the
+// expected width. Terminals whose fonts lack the glyphs tend to render them
+// with unexpected widths. We stop probing once the terminal fails to report
+// the position of the cursor, so that we do not wait for the reply every time.
+func (r *LightRenderer) CanDisplay(texts []string) []bool {
+	supported := make([]bool, len(texts))
+	for idx := range supported {
+		supported[idx] = true
+	}
+	if r.noOffset || len(texts) == 0 {
+		return supported
+	}
+	widths := r.measureWidths(texts)
+	for idx, width := range widths {
+		r.noOffset = r.noOffset || width < 0
+		supported[idx] = width < 0 || width == runewidth.StringWidth(texts[idx])
+	}
+	return supported
+}
+
+// measureWidths prints each text at the beginning of the first line followed
+// by a cursor position request, and reads the replies at once so that it
+// takes a single round trip to the terminal. The line is cleared afterwards.
+// The width is -1 if the terminal does not reply.
+func (r *LightRenderer) measureWidths(texts []string) []int {
+	r.origin()
+	for _, text := range texts {
+		r.stderr("\r" + text)
+		r.csi("6n")
+	}
+	r.stderr("\r")
+	r.csi("K")
+	r.flush()
+	widths := []int{}
+	bytes := []byte{}
+	for tries := 0; tries < offsetPollTries && len(widths) < len(texts); tries++ {
+		bytes = r.getBytesInternal(bytes, tries > 0)
+		for len(widths) < len(texts) {
+			loc := cursorRegexp.FindSubmatchIndex(bytes)
+			if loc == nil {
+				break
+			}
+			widths = append(widths, atoi(string(bytes[loc[4]:loc[5]]), 0)-1)
+			bytes = append(bytes[:loc[0]:loc[0]], bytes[loc[1]:]...)
+		}
+	}
+	// Add anything we skipped over to the input buffer
+	r.buffer = append(r.buffer, bytes...)
+	for len(widths) < len(texts) {
+		widths = append(widths, -1)
+	}
+	return widths
+}
+
+// MeasureWidth prints the text at the beginning of the first line of the
+// finder and returns the position of the cursor. The line is cleared
+// afterwards.
+func (r *LightRenderer) MeasureWidth(text string) int {
+	return r.measureWidths([]string{text})[0]
+}
+
 func (r *LightRenderer) makeSpace() {
 	r.stderr("\n")
 	r.csi("G")
@@ -278,6 +526,10 @@ func (r *LightRenderer) GetChar() Event {
 		r.buffer = r.buffer[sz:]
 	}()
 
+	if r.pasting {
+		return r.pasteSequence(&sz, r.buffer)
+	}
+
 	switch r.buffer[0] {
 	case CtrlC.Byte():
 		return Event{CtrlC, 0, nil}
@@ -300,7 +552,7 @@ func (r *LightRenderer) GetChar() Event {
 	case ESC.Byte():
 		ev := r.escSequence(&sz)
 		// Second chance
-		if ev.Type == Invalid {
+		if ev.Type == Invalid && !r.pasting {
 			r.buffer = r.getBytes()
 			ev = r.escSequence(&sz)
 		}
@@ -311,7 +563,7 @@ func (r *LightRenderer) GetChar() Event {
 	if r.buffer[0] <= CtrlZ.Byte() {
 		return Event{EventType(r.buffer[0]), 0, nil}
 	}
-	char, rsz := utf8.DecodeRune(r.buffer)
+	char, rsz := util.DecodeLocaleRune(r.buffer)
 	if char == utf8.RuneError {
 		return Event{ESC, 0, nil}
 	}
@@ -329,6 +581,15 @@ func (r *LightRenderer) escSequence(sz *int) Event {
 		*sz = loc[1]
 		return Event{Invalid, 0, nil}
 	}
+	if match := kittyKeyRegexp.FindSubmatch(r.buffer); match != nil {
+		*sz = len(match[0])
+		return kittyKey(atoi(string(match[1]), 0), atoi(string(match[2]), 1))
+	}
+	// Late response to the query of the keyboard protocol
+	if loc := keyboardRegexp.FindIndex(r.buffer); loc != nil && loc[0] == 0 {
+		*sz = loc[1]
+		return Event{Invalid, 0, nil}
+	}
 
 	*sz = 2
 	if r.buffer[1] >= 1 && r.buffer[1] <= 'z'-'a'+1 {
@@ -373,6 +634,15 @@ func (r *LightRenderer) escSequence(sz *int) Event {
 			return Event{Up, 0, nil}
 		case 'Z':
 			return Event{BTab, 0, nil}
+		case 'I', 'O':
+			// Focus reporting: \e[I and \e[O
+			if r.buffer[1] != '[' {
+				return Event{Invalid, 0, nil}
+			}
+			if r.buffer[2] == 'I' {
+				return Event{FocusGained, 0, nil}
+			}
+			return Event{FocusLost, 0, nil}
 		case 'H':
 			return Event{Home, 0, nil}
 		case 'F':
@@ -411,8 +681,12 @@ func (r *LightRenderer) escSequence(sz *int) Event {
 					}
 				}
 				// Bracketed paste mode: \e[200~ ... \e[201~
-				if len(r.buffer) > 5 && r.buffer[3] == '0' && (r.buffer[4] == '0' || r.buffer[4] == '1') && r.buffer[5] == '~' {
-					// Immediately discard the sequence from the buffer and reread input
+				if len(r.buffer) > 5 && r.buffer[3] == '0' && r.buffer[4] == '0' && r.buffer[5] == '~' {
+					return r.pasteSequence(sz, r.buffer[6:])
+				}
+				if len(r.buffer) > 5 && r.buffer[3] == '0' && r.buffer[4] == '1' && r.buffer[5] == '~' {
+					// Immediately discard the stray end of the paste from the
+					// buffer and reread input
 					r.buffer = r.buffer[6:]
 					*sz = 0
 					return r.GetChar()
@@ -518,6 +792,126 @@ func (r *LightRenderer) escSequence(sz *int) Event {
 	return Event{Invalid, 0, nil}
 }
 
+// Modifiers of the keyboard protocol of kitty
+const (
+	kittyShift = 1 << iota
+	kittyAlt
+	kittyCtrl
+	kittySuper
+)
+
+// kittyKey returns the event for the key reported in CSI u sequence. The
+// modifiers are encoded as 1 + the bits of the modifiers. The keys that can be
+// reported without the protocol are mapped to the same events as before.
+func kittyKey(code int, modifiers int) Event {
+	mods := (modifiers - 1) & (kittyShift | kittyAlt | kittyCtrl | kittySuper)
+	switch code {
+	case 9:
+		if mods&kittyShift > 0 {
+			return Event{BTab, 0, nil}
+		}
+		return Event{Tab, 0, nil}
+	case 13:
+		if mods&kittyAlt > 0 {
+			return CtrlAltKey('m')
+		}
+		return Event{CtrlM, 0, nil}
+	case 27:
+		return Event{ESC, 0, nil}
+	case 127:
+		if mods&kittyAlt > 0 {
+			return Event{AltBS, 0, nil}
+		}
+		return Event{BSpace, 0, nil}
+	}
+	if code < 32 || code >= 0xe000 && code <= 0xf8ff || !utf8.ValidRune(rune(code)) {
+		// Functional keys in the private use area are not reported as we only
+		// ask for the disambiguation
+		return Event{Invalid, 0, nil}
+	}
+
+	r := rune(code)
+	shifted := r
+	if mods&kittyShift > 0 {
+		shifted = unicode.ToUpper(r)
+	}
+	switch mods &^ kittyShift {
+	case 0:
+		return Event{Rune, shifted, nil}
+	case kittyAlt:
+		return AltKey(shifted)
+	case kittySuper:
+		return SuperKey(shifted)
+	case kittyCtrl | kittyAlt:
+		if mods&kittyShift == 0 {
+			return CtrlAltKey(r)
+		}
+	case kittyCtrl:
+		if mods&kittyShift > 0 {
+			return CtrlShiftKey(r)
+		}
+		switch {
+		case r == 'i' || r == 'm' || r == '[':
+			return CtrlKey(r)
+		case r >= 'a' && r <= 'z':
+			return Event{EventType(CtrlA.Int() + int(r-'a')), 0, nil}
+		case r == ' ':
+			return Event{CtrlSpace, 0, nil}
+		case r == '\\':
+			return Event{CtrlBackSlash, 0, nil}
+		case r == ']':
+			return Event{CtrlRightBracket, 0, nil}
+		case r == '^' || r == '6':
+			return Event{CtrlCaret, 0, nil}
+		case r == '/' || r == '_':
+			return Event{CtrlSlash, 0, nil}
+		}
+		return CtrlKey(r)
+	}
+	return Event{Invalid, 0, nil}
+}
+
+// pasteSequence reads the text pasted in the bracketed paste mode until the end
+// of the sequence. Not to block the event loop while the terminal is writing a
+// large paste, Invalid event is returned when the end is not in the data yet,
+// and the rest is read on the next calls. The text is kept in the renderer
+// until the next paste.
+func (r *LightRenderer) pasteSequence(sz *int, data []byte) Event {
+	end := []byte("\x1b[201~")
+	data = append(r.pasteTail, data...)
+	appendText := func(text []byte) {
+		if room := maxPasteLength - len(r.pasteText); room > 0 {
+			r.pasteText = append(r.pasteText, text[:util.Min(len(text), room)]...)
+		}
+	}
+	*sz = 0
+	if idx := bytes.Index(data, end); idx >= 0 {
+		appendText(data[:idx])
+		r.pasted = string(util.DecodeLocale(r.pasteText))
+		r.pasting, r.pasteText, r.pasteTail = false, nil, nil
+		// The input after the sequence is processed on the next call
+		r.buffer = data[idx+len(end):]
+		return Event{Paste, 0, nil}
+	}
+	// The last bytes are kept as they can be the beginning of the end of the
+	// sequence
+	keep := util.Min(len(end)-1, len(data))
+	appendText(data[:len(data)-keep])
+	r.pasting = true
+	r.pasteTail = append([]byte{}, data[len(data)-keep:]...)
+	r.buffer = nil
+	return Event{Invalid, 0, nil}
+}
+
+// Pasted returns the text of the last paste
+func (r *LightRenderer) Pasted() string {
+	return r.pasted
+}
+
+func (r *LightRenderer) KeyboardProtocol() bool {
+	return r.kitty
+}
+
 func (r *LightRenderer) mouseSequence(sz *int) Event {
 	if len(r.buffer) < 6 || !r.mouse {
 		return Event{Invalid, 0, nil}
@@ -531,32 +925,58 @@ func (r *LightRenderer) mouseSequence(sz *int) Event {
 		down := r.buffer[3]%2 == 0
 		x := int(r.buffer[4] - 33)
 		y := int(r.buffer[5]-33) - r.yoffset
-		double := false
+		double, triple := false, false
 		if down {
 			now := time.Now()
 			if !left { // Right double click is not allowed
 				r.clickY = []int{}
-			} else if now.Sub(r.prevDownTime) < doubleClickDuration {
+			} else if now.Sub(r.prevDownTime) < r.doubleClick {
 				r.clickY = append(r.clickY, y)
 			} else {
 				r.clickY = []int{y}
 			}
 			r.prevDownTime = now
-		} else {
-			if len(r.clickY) > 1 && r.clickY[0] == r.clickY[1] &&
-				time.Since(r.prevDownTime) < doubleClickDuration {
+		} else if time.Since(r.prevDownTime) < r.doubleClick {
+			if len(r.clickY) == 3 && r.clickY[0] == r.clickY[1] && r.clickY[1] == r.clickY[2] {
+				triple = true
+			} else if len(r.clickY) > 1 && r.clickY[0] == r.clickY[1] {
 				double = true
 			}
 		}
 
-		return Event{Mouse, 0, &MouseEvent{y, x, 0, left, down, double, mod}}
+		return Event{Mouse, 0, &MouseEvent{y, x, 0, left, down, double, triple, mod, false, false}}
+	case 64, 68, 72, 80, // motion with left button / shift / cmd / ctrl
+		66, 70, 74, 82: // motion with right button / shift / cmd / ctrl
+		mod := r.buffer[3] >= 68
+		left := r.buffer[3]%4 == 0
+		x := int(r.buffer[4] - 33)
+		y := int(r.buffer[5]-33) - r.yoffset
+		return Event{Mouse, 0, &MouseEvent{y, x, 0, left, false, false, false, mod, false, true}}
 	case 96, 100, 104, 112, // scroll-up / shift / cmd / ctrl
 		97, 101, 105, 113: // scroll-down / shift / cmd / ctrl
 		mod := r.buffer[3] >= 100
 		s := 1 - int(r.buffer[3]%2)*2
 		x := int(r.buffer[4] - 33)
 		y := int(r.buffer[5]-33) - r.yoffset
-		return Event{Mouse, 0, &MouseEvent{y, x, s, false, false, false, mod}}
+		return Event{Mouse, 0, &MouseEvent{y, x, s, false, false, false, false, mod, false, false}}
+	case 98, 102, 106, 114, // scroll-left / shift / cmd / ctrl
+		99, 103, 107, 115: // scroll-right / shift / cmd / ctrl
+		mod := r.buffer[3] >= 102
+		x := int(r.buffer[4] - 33)
+		y := int(r.buffer[5]-33) - r.yoffset
+		scroll := ScrollLeft
+		if r.buffer[3]%2 == 1 {
+			scroll = ScrollRight
+		}
+		return Event{scroll, 0, &MouseEvent{y, x, 0, false, false, false, false, mod, false, false}}
+	case 67, 71, 75, 83: // motion without button / shift / cmd / ctrl
+		if !r.hover {
+			break
+		}
+		mod := r.buffer[3] >= 71
+		x := int(r.buffer[4] - 33)
+		y := int(r.buffer[5]-33) - r.yoffset
+		return Event{Mouse, 0, &MouseEvent{y, x, 0, false, false, false, false, mod, true, false}}
 	}
 	return Event{Invalid, 0, nil}
 }
@@ -570,6 +990,9 @@ func (r *LightRenderer) rmcup() {
 }
 
 func (r *LightRenderer) Pause(clear bool) {
+	// The commands executed expect the legacy keys
+	r.popKeyboard()
+	r.flush()
 	r.restoreTerminal()
 	if clear {
 		if r.fullscreen {
@@ -591,13 +1014,19 @@ func (r *LightRenderer) Resume(clear bool, sigcont bool) {
 			r.rmcup()
 		}
 		r.flush()
+		r.screen.invalidate()
 	} else if sigcont && !r.fullscreen && r.mouse {
 		// NOTE: SIGCONT (Coming back from CTRL-Z):
 		// It's highly likely that the offset we obtained at the beginning is
 		// no longer correct, so we simply disable mouse input.
+		if r.hover {
+			r.csi("?1003l")
+		}
+		r.csi("?1002l")
 		r.csi("?1000l")
 		r.mouse = false
 	}
+	r.pushKeyboard()
 }
 
 func (r *LightRenderer) Clear() {
@@ -608,17 +1037,200 @@ func (r *LightRenderer) Clear() {
 	r.origin()
 	r.csi("J")
 	r.flush()
+	r.screen.clear()
+}
+
+// Emit queues the sequence that prints nothing, wrapped for the terminal
+// multiplexer so that it reaches the outer terminal
+func (r *LightRenderer) Emit(sequence string) {
+	r.queued += wrapSequence(r.mux, sequence)
+}
+
+// PassThrough writes the string to the terminal as is from the top-left corner
+// of the finder. The lines beyond the height of the finder are discarded, and
+// the cursor is restored afterwards.
+func (r *LightRenderer) PassThrough(str string) {
+	lines := strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+	if len(lines) > r.height {
+		lines = lines[:r.height]
+	}
+	r.render()
+	r.screen.forget(0, 0, r.height, r.width)
+	r.origin()
+	r.queued += "\x1b7" + strings.Join(lines, "\r\n") + "\x1b8"
+}
+
+// Background keeps the string to draw underneath the blank cells of the
+// windows. The cells of it are printed on the next refresh, and again
+// whenever a cell printed over them becomes blank.
+func (r *LightRenderer) Background(str string) {
+	r.screen.setBackground(str)
+}
+
+// Reserve keeps the cells of the region from being printed
+func (r *LightRenderer) Reserve(region *Region) {
+	r.screen.reserve(region)
+}
+
+// DrawReserved writes the lines of the string from the top-left corner of
+// the reserved region. The lines beyond the height of the region are
+// discarded, and the characters beyond its width are removed from the lines.
+// The escape sequences are written as they are, so the images drawn by them
+// are not clipped. The cursor is restored afterwards.
+func (r *LightRenderer) DrawReserved(str string) {
+	if r.screen.region == nil {
+		return
+	}
+	region := *r.screen.region
+	lines := strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+	if len(lines) > region.Height {
+		lines = lines[:region.Height]
+	}
+	r.render()
+	y, x := r.y, r.x
+	r.queued += "\x1b7"
+	for i, line := range lines {
+		r.move(region.Top+i, region.Left)
+		r.queued += clipLine(strings.TrimSuffix(line, "\r"), region.Width)
+	}
+	r.queued += "\x1b8"
+	r.y, r.x = y, x
+	r.flush()
+}
+
+// clipLine removes the characters of the line beyond the width. The escape
+// sequences are kept as they take no columns.
+func clipLine(line string, width int) string {
+	var builder strings.Builder
+	columns := 0
+	full := false
+	for len(line) > 0 {
+		idx := strings.IndexByte(line, '\x1b')
+		if idx != 0 {
+			text := line
+			if idx > 0 {
+				text = line[:idx]
+			}
+			for _, r := range text {
+				if full || r < 32 && r != '\t' {
+					continue
+				}
+				w := util.RuneWidth(r, columns, 8)
+				if columns+w > width {
+					full = true
+					continue
+				}
+				builder.WriteRune(r)
+				columns += w
+			}
+			if idx < 0 {
+				break
+			}
+			line = line[idx:]
+		}
+		length, _ := escapeSequence(line)
+		builder.WriteString(line[:length])
+		line = line[length:]
+	}
+	return builder.String()
 }
 
 func (r *LightRenderer) RefreshWindows(windows []Window) {
+	r.windows = windows
+	drawShadows(r, windows, r.shadow)
+	r.render()
+	r.flush()
+}
+
+func (r *LightRenderer) Bell(style BellStyle) {
+	switch style {
+	case BellAudible:
+		r.queued += "\a"
+		r.flush()
+	case BellVisual:
+		// Reverse video of the whole screen
+		r.csi("?5h")
+		r.flush()
+	case BellFlashBorder:
+		r.flashBorders(true)
+	}
+}
+
+func (r *LightRenderer) BellOff(style BellStyle) {
+	switch style {
+	case BellVisual:
+		r.csi("?5l")
+		r.flush()
+	case BellFlashBorder:
+		r.flashBorders(false)
+	}
+}
+
+// flashBorders redraws the borders of the windows in the color of the flash
+// or in the normal color
+func (r *LightRenderer) flashBorders(flash bool) {
+	y, x := r.screen.y, r.screen.x
+	for _, w := range r.windows {
+		if lw, ok := w.(*LightWindow); ok && lw.border.shape != BorderNone {
+			lw.flash = flash
+			lw.drawBorder()
+		}
+	}
+	r.screen.move(y, x)
+	r.render()
 	r.flush()
 }
 
 func (r *LightRenderer) Refresh() {
+	prevHeight := r.height
 	r.updateTerminalSize()
+	r.resizeScreen()
+	r.makeRoom(prevHeight)
+}
+
+// Resize replaces the function for calculating the height of the finder and
+// returns true if the height has changed
+func (r *LightRenderer) Resize(maxHeightFunc func(int) int) bool {
+	r.maxHeightFunc = maxHeightFunc
+	prevHeight := r.height
+	r.updateTerminalSize()
+	r.resizeScreen()
+	r.makeRoom(prevHeight)
+	return r.height != prevHeight
+}
+
+func (r *LightRenderer) resizeScreen() {
+	r.screen.resize(r.height, r.width)
+}
+
+// Secures the extra lines below the region when the height has increased
+func (r *LightRenderer) makeRoom(prevHeight int) {
+	if r.fullscreen || r.height <= prevHeight {
+		return
+	}
+	r.move(prevHeight-1, 0)
+	for i := prevHeight; i < r.height; i++ {
+		r.makeSpace()
+	}
+	r.csi(fmt.Sprintf("%dA", r.height-1))
+	r.y = 0
+	r.x = 0
+	if !r.clearOnExit {
+		r.csi("s")
+	}
+	// The screen scrolls up if there is not enough space below the region
+	r.yoffset = util.Min(r.yoffset, util.Max(0, r.ttyHeight-r.height))
+	r.flush()
 }
 
 func (r *LightRenderer) Close() {
+	r.render()
+	// Images should be deleted before leaving the alternate screen
+	for id := range r.images {
+		r.queued += kittyDelete(id)
+	}
+	r.images = make(map[int]bool)
+	r.popKeyboard()
 	// r.csi("u")
 	if r.clearOnExit {
 		if r.fullscreen {
@@ -634,6 +1246,10 @@ func (r *LightRenderer) Close() {
 		r.csi("u")
 	}
 	if r.mouse {
+		if r.hover {
+			r.csi("?1003l")
+		}
+		r.csi("?1002l")
 		r.csi("?1000l")
 	}
 	r.flush()
@@ -673,76 +1289,110 @@ func (r *LightRenderer) NewWindow(top int, left int, width int, height int, prev
 	return w
 }
 
+func (w *LightWindow) SetShadow(shade rune) {
+	w.shadow = shade
+}
+
+func (w *LightWindow) shadowShade() rune {
+	return w.shadow
+}
+
 func (w *LightWindow) drawBorder() {
-	switch w.border.shape {
-	case BorderRounded, BorderSharp:
-		w.drawBorderAround()
-	case BorderHorizontal:
-		w.drawBorderHorizontal(true, true)
-	case BorderVertical:
-		w.drawBorderVertical(true, true)
-	case BorderTop:
-		w.drawBorderHorizontal(true, false)
-	case BorderBottom:
-		w.drawBorderHorizontal(false, true)
-	case BorderLeft:
-		w.drawBorderVertical(true, false)
-	case BorderRight:
-		w.drawBorderVertical(false, true)
-	}
-}
-
-func (w *LightWindow) drawBorderHorizontal(top, bottom bool) {
+	if w.border.shape == BorderNone {
+		return
+	}
+	drawBorder(w, w.border, w.preview, w.flash)
+	w.drawBorderLabels()
+}
+
+// drawBorder draws the border of the window with the methods of Window
+func drawBorder(w Window, border BorderStyle, preview bool, flash bool) {
+	color := ColBorder
+	if preview {
+		color = ColPreviewBorder
+	}
+	if flash {
+		color = color.WithAttr(Reverse)
+	}
+	top := border.sides[0] != LineNone
+	right := border.sides[1] != LineNone
+	bottom := border.sides[2] != LineNone
+	left := border.sides[3] != LineNone
+
+	width := w.Width()
+	if left {
+		width--
+	}
+	if right {
+		width--
+	}
+	drawHorizontal := func(y int, line rune, leftCorner rune, rightCorner rune) {
+		w.Move(y, 0)
+		str := repeat(line, width)
+		if left {
+			str = string(leftCorner) + str
+		}
+		if right {
+			str += string(rightCorner)
+		}
+		w.CPrint(color, str)
+	}
+
+	minY := 0
+	maxY := w.Height()
 	if top {
-		w.Move(0, 0)
-		w.CPrint(ColBorder, repeat(w.border.horizontal, w.width))
+		drawHorizontal(0, border.top, border.topLeft, border.topRight)
+		minY++
 	}
 	if bottom {
-		w.Move(w.height-1, 0)
-		w.CPrint(ColBorder, repeat(w.border.horizontal, w.width))
+		drawHorizontal(w.Height()-1, border.bottom, border.bottomLeft, border.bottomRight)
+		maxY--
 	}
-}
-
-func (w *LightWindow) drawBorderVertical(left, right bool) {
-	width := w.width - 2
-	if !left || !right {
-		width++
+	if !left && !right {
+		return
 	}
-	for y := 0; y < w.height; y++ {
+	for y := minY; y < maxY; y++ {
 		w.Move(y, 0)
 		if left {
-			w.CPrint(ColBorder, string(w.border.vertical))
+			w.CPrint(color, string(border.left))
 		}
-		w.CPrint(ColBorder, repeat(' ', width))
+		w.CPrint(color, repeat(' ', width))
 		if right {
-			w.CPrint(ColBorder, string(w.border.vertical))
+			w.CPrint(color, string(border.right))
 		}
 	}
 }
 
-func (w *LightWindow) drawBorderAround() {
-	w.Move(0, 0)
-	color := ColBorder
-	if w.preview {
-		color = ColPreviewBorder
+func (w *LightWindow) SetBorderLabels(labels []BorderLabel) {
+	w.labels = labels
+	w.drawBorderLabels()
+}
+
+func (w *LightWindow) drawBorderLabels() {
+	drawBorderLabels(w, w.labels, w.preview)
+}
+
+func drawBorderLabels(w Window, labels []BorderLabel, preview bool) {
+	color := ColBorderLabel
+	if preview {
+		color = ColPreviewLabel
 	}
-	w.CPrint(color, string(w.border.topLeft)+repeat(w.border.horizontal, w.width-2)+string(w.border.topRight))
-	for y := 1; y < w.height-1; y++ {
-		w.Move(y, 0)
-		w.CPrint(color, string(w.border.vertical))
-		w.CPrint(color, repeat(' ', w.width-2))
-		w.CPrint(color, string(w.border.vertical))
+	for _, label := range labels {
+		y := 0
+		if label.Bottom {
+			y = w.Height() - 1
+		}
+		w.Move(y, label.X)
+		w.CPrint(color, label.Text)
 	}
-	w.Move(w.height-1, 0)
-	w.CPrint(color, string(w.border.bottomLeft)+repeat(w.border.horizontal, w.width-2)+string(w.border.bottomRight))
 }
 
 func (w *LightWindow) csi(code string) {
-	w.renderer.csi(code)
+	w.renderer.screen.setStyle(code)
 }
 
-func (w *LightWindow) stderrInternal(str string, allowNLCR bool) {
-	w.renderer.stderrInternal(str, allowNLCR)
+func (w *LightWindow) stderrInternal(str string) {
+	w.renderer.screen.print(str)
 }
 
 func (w *LightWindow) Top() int {
@@ -765,6 +1415,7 @@ func (w *LightWindow) Refresh() {
 }
 
 func (w *LightWindow) Close() {
+	w.deleteImages()
 }
 
 func (w *LightWindow) X() int {
@@ -784,7 +1435,7 @@ func (w *LightWindow) Move(y int, x int) {
 	w.posx = x
 	w.posy = y
 
-	w.renderer.move(w.Top()+y, w.Left()+x)
+	w.renderer.screen.move(w.Top()+y, w.Left()+x)
 }
 
 func (w *LightWindow) MoveAndClear(y int, x int) {
@@ -818,6 +1469,9 @@ func attrCodes(attr Attr) []string {
 	if (attr & Reverse) > 0 {
 		codes = append(codes, "7")
 	}
+	if (attr & StrikeThrough) > 0 {
+		codes = append(codes, "9")
+	}
 	return codes
 }
 
@@ -861,7 +1515,7 @@ func cleanse(str string) string {
 
 func (w *LightWindow) CPrint(pair ColorPair, text string) {
 	w.csiColor(pair.Fg(), pair.Bg(), pair.Attr())
-	w.stderrInternal(cleanse(text), false)
+	w.stderrInternal(cleanse(text))
 	w.csi("m")
 }
 
@@ -869,7 +1523,7 @@ func (w *LightWindow) cprint2(fg Color, bg Color, attr Attr, text string) {
 	if w.csiColor(fg, bg, attr) {
 		defer w.csi("m")
 	}
-	w.stderrInternal(cleanse(text), false)
+	w.stderrInternal(cleanse(text))
 }
 
 type wrappedLine struct {
@@ -912,7 +1566,7 @@ func (w *LightWindow) fill(str string, onMove func()) FillReturn {
 				}
 				return FillNextLine
 			}
-			w.stderrInternal(wl.text, false)
+			w.stderrInternal(wl.text)
 			w.posx += wl.displayWidth
 
 			// Wrap line
@@ -963,7 +1617,81 @@ func (w *LightWindow) FinishFill() {
 	}
 }
 
+func (w *LightWindow) LinkBegin(uri string, params string) {
+	w.renderer.screen.setLink(params + ";" + uri)
+}
+
+func (w *LightWindow) LinkEnd() {
+	w.renderer.screen.setLink("")
+}
+
+func (w *LightWindow) DrawImage(image Image) bool {
+	r := w.renderer
+	if w.posx > 0 {
+		return false
+	}
+	cols, rows := image.Cols, image.Rows
+	if cols == 0 || rows == 0 {
+		if r.cellWidth <= 0 || r.cellHeight <= 0 {
+			return false
+		}
+		if cols == 0 {
+			cols = (image.Width + r.cellWidth - 1) / r.cellWidth
+		}
+		if rows == 0 {
+			rows = (image.Height + r.cellHeight - 1) / r.cellHeight
+		}
+	}
+	// The image should not touch the last line of the finder as the terminal
+	// scrolls up the screen when the cursor moves below it
+	maxRows := util.Min(w.height-w.posy, r.MaxY()-1-w.top-w.posy)
+	if image.Protocol == ImageITerm2 && (cols > w.width || rows > maxRows) {
+		cols, rows = fitImage(cols, rows, w.width, maxRows)
+	}
+	if rows <= 0 || cols > w.width || rows > maxRows {
+		return false
+	}
+	top := w.posy
+	for y := top; y < top+rows; y++ {
+		w.MoveAndClear(y, 0)
+	}
+	w.Move(top, 0)
+	// The cursor is saved and restored around the image as the terminals
+	// differ in where they leave it
+	data := image.Data
+	if image.Protocol == ImageKitty {
+		r.imageID++
+		data = kittyTransmit(image.Data, r.imageID)
+		r.images[r.imageID] = true
+		w.images = append(w.images, r.imageID)
+	} else if image.Protocol == ImageITerm2 {
+		data = iterm2Transmit(image.Data, cols, rows)
+	}
+	// The pending cells should be printed before the image, and the cells
+	// covered by the image should not be printed over it
+	r.render()
+	r.screen.forget(w.top+top, w.left, rows, cols)
+	r.queued += "\x1b7" + data + "\x1b8"
+	if top+rows < w.height {
+		w.Move(top+rows, 0)
+	} else {
+		w.Move(top+rows-1, w.width)
+	}
+	return true
+}
+
+// deleteImages deletes the placements of the kitty images drawn on the
+// window as they are not erased by the text printed over them
+func (w *LightWindow) deleteImages() {
+	for _, id := range w.images {
+		w.renderer.queued += kittyDelete(id)
+		delete(w.renderer.images, id)
+	}
+	w.images = nil
+}
+
 func (w *LightWindow) Erase() {
+	w.deleteImages()
 	w.drawBorder()
 	// We don't erase the window here to avoid flickering during scroll
 	w.Move(0, 0)
@@ -0,0 +1,355 @@
+package tui
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// Contents of the cells other than the printable characters
+const (
+	cellUnknown = ""     // Not printed by fzf, or covered by an image
+	cellWide    = "\x00" // Right half of a wide character
+)
+
+// Unchanged cells between the changed ones are printed again instead of
+// moving the cursor over them if there are no more than this many of them
+const maxCellGap = 4
+
+type lightCell struct {
+	text  string
+	style string
+	link  string
+}
+
+// lightScreen holds the cells to display in the back buffer, and the cells
+// already printed on the terminal in the front buffer, so that the renderer
+// only prints the cells that have changed since the last refresh
+type lightScreen struct {
+	width  int
+	height int
+	back   [][]lightCell
+	front  [][]lightCell
+	dirty  []bool
+	region *Region // Not printed as it is drawn by another program
+	// Drawn underneath the blank cells. The cells are parsed from the string
+	// for the size of the screen.
+	background string
+	bg         [][]lightCell
+	y          int
+	x          int
+	style      string
+	link       string
+}
+
+var blankCell = lightCell{text: " "}
+
+func makeCells(height int, width int, cell lightCell) [][]lightCell {
+	cells := make([][]lightCell, height)
+	for y := range cells {
+		cells[y] = make([]lightCell, width)
+		for x := range cells[y] {
+			cells[y][x] = cell
+		}
+	}
+	return cells
+}
+
+// resize discards the cells if the size of the screen has changed
+func (s *lightScreen) resize(height int, width int) {
+	if s.height == height && s.width == width {
+		return
+	}
+	s.height = height
+	s.width = width
+	s.back = makeCells(height, width, lightCell{})
+	s.front = makeCells(height, width, lightCell{})
+	s.dirty = make([]bool, height)
+	s.setBackground(s.background)
+}
+
+// clear should be called when the screen is cleared
+func (s *lightScreen) clear() {
+	s.back = makeCells(s.height, s.width, blankCell)
+	s.front = makeCells(s.height, s.width, blankCell)
+	s.dirty = make([]bool, s.height)
+	s.reserve(s.region)
+	if s.bg != nil {
+		s.touch()
+	}
+}
+
+// invalidate makes the cells printed again on the next refresh
+func (s *lightScreen) invalidate() {
+	s.front = makeCells(s.height, s.width, lightCell{})
+	s.touch()
+}
+
+// touch makes every line compared with the front buffer on the next refresh
+func (s *lightScreen) touch() {
+	for y := range s.dirty {
+		s.dirty[y] = true
+	}
+}
+
+// forget marks the area as unknown so that it is not printed until fzf
+// prints over it
+func (s *lightScreen) forget(top int, left int, height int, width int) {
+	for y := util.Max(0, top); y < util.Min(s.height, top+height); y++ {
+		for x := util.Max(0, left); x < util.Min(s.width, left+width); x++ {
+			s.back[y][x] = lightCell{}
+			s.front[y][x] = lightCell{}
+		}
+	}
+}
+
+// reserve forgets the cells of the region and keeps them from being printed
+// until it is released with nil
+func (s *lightScreen) reserve(region *Region) {
+	s.region = region
+	if region != nil {
+		s.forget(region.Top, region.Left, region.Height, region.Width)
+	}
+}
+
+func (s *lightScreen) reserved(y int, x int) bool {
+	return s.region != nil && s.region.Contains(y, x)
+}
+
+func (s *lightScreen) move(y int, x int) {
+	s.y = y
+	s.x = x
+}
+
+// setStyle takes the SGR sequence the renderer would print
+func (s *lightScreen) setStyle(code string) {
+	s.style = strings.TrimPrefix(strings.TrimSuffix(code, "m"), ";")
+}
+
+func (s *lightScreen) setLink(link string) {
+	s.link = link
+}
+
+func (s *lightScreen) print(str string) {
+	for _, r := range str {
+		if r == '\n' || r == '\r' || r == utf8.RuneError {
+			r = ' '
+		} else if r < 32 {
+			continue
+		}
+		w := util.RuneWidth(r, 0, 8)
+		if w == 0 {
+			s.combine(r)
+			continue
+		}
+		s.set(string(r), w)
+		s.x += w
+	}
+}
+
+// combine appends the zero-width character to the last printed one
+func (s *lightScreen) combine(r rune) {
+	x := s.x - 1
+	if x > 0 && x < s.width && s.y >= 0 && s.y < s.height && s.back[s.y][x].text == cellWide {
+		x--
+	}
+	if x < 0 || x >= s.width || s.y < 0 || s.y >= s.height || s.back[s.y][x].text == cellUnknown || s.reserved(s.y, x) {
+		return
+	}
+	s.back[s.y][x].text += string(r)
+	s.dirty[s.y] = true
+}
+
+func (s *lightScreen) set(text string, w int) {
+	if s.y < 0 || s.y >= s.height || s.x < 0 || s.x+w > s.width || s.reserved(s.y, s.x) || s.reserved(s.y, s.x+w-1) {
+		return
+	}
+	row := s.back[s.y]
+	// A wide character partially overwritten is no longer displayed
+	if row[s.x].text == cellWide && s.x > 0 {
+		row[s.x-1].text = " "
+	}
+	if end := s.x + w; end < s.width && row[end].text == cellWide {
+		row[end].text = " "
+	}
+	row[s.x] = lightCell{text, s.style, s.link}
+	if w > 1 {
+		row[s.x+1] = lightCell{cellWide, s.style, s.link}
+	}
+	s.dirty[s.y] = true
+}
+
+// setBackground parses the string into the cells drawn underneath the blank
+// cells. The lines are clipped to the size of the screen. Only the cells whose
+// appearance has changed are printed on the next refresh.
+func (s *lightScreen) setBackground(str string) {
+	s.background = str
+	s.bg = nil
+	if len(str) > 0 {
+		s.bg = parseCells(str, s.height, s.width)
+	}
+	s.touch()
+}
+
+// parseCells returns the cells of the string with ANSI escape sequences as
+// it would be displayed from the top-left corner of the screen. SGR sequences
+// are applied to the cells, and the other sequences are ignored.
+func parseCells(str string, height int, width int) [][]lightCell {
+	p := lightScreen{height: height, width: width, back: makeCells(height, width, blankCell), dirty: make([]bool, height)}
+	for y, line := range strings.Split(strings.TrimSuffix(str, "\n"), "\n") {
+		if y >= height {
+			break
+		}
+		p.move(y, 0)
+		line = strings.TrimSuffix(line, "\r")
+		for len(line) > 0 {
+			idx := strings.IndexByte(line, '\x1b')
+			if idx < 0 {
+				p.print(line)
+				break
+			}
+			p.print(line[:idx])
+			line = line[idx:]
+			length, sgr := escapeSequence(line)
+			if sgr {
+				params := line[2 : length-1]
+				if params == "" || params == "0" {
+					p.style = ""
+				} else if strings.HasPrefix(params, "0;") || len(p.style) == 0 {
+					p.style = strings.TrimPrefix(params, "0;")
+				} else {
+					p.style += ";" + params
+				}
+			}
+			line = line[length:]
+		}
+	}
+	return p.back
+}
+
+// escapeSequence returns the length of the escape sequence at the start of
+// the string, and whether it is an SGR sequence. The string sequences such as
+// the images of sixel and kitty graphics protocol are taken as a whole.
+func escapeSequence(str string) (int, bool) {
+	if len(str) < 2 {
+		return len(str), false
+	}
+	switch str[1] {
+	case '[':
+		for i := 2; i < len(str); i++ {
+			if str[i] >= 0x40 && str[i] <= 0x7e {
+				return i + 1, str[i] == 'm'
+			}
+		}
+	case ']', 'P', '_', '^', 'X':
+		// OSC, DCS, APC, PM, and SOS sequences end with BEL or ST
+		for i := 2; i < len(str); i++ {
+			if str[i] == '\a' {
+				return i + 1, false
+			}
+			if str[i] == '\x1b' && i+1 < len(str) && str[i+1] == '\\' {
+				return i + 2, false
+			}
+		}
+	default:
+		return 2, false
+	}
+	return len(str), false
+}
+
+// cell returns the cell to display, which is the cell of the background if
+// the back buffer has a blank cell over it. Half of a wide character of the
+// background is displayed as blank if the other half is covered.
+func (s *lightScreen) cell(y int, x int) lightCell {
+	cell := s.back[y][x]
+	if s.bg == nil || cell != blankCell {
+		return cell
+	}
+	bg := s.bg[y][x]
+	if bg.text == cellWide && s.back[y][x-1] != blankCell ||
+		x+1 < s.width && s.bg[y][x+1].text == cellWide && s.back[y][x+1] != blankCell {
+		return blankCell
+	}
+	return bg
+}
+
+func (s *lightScreen) changed(y int, x int) bool {
+	cell := s.cell(y, x)
+	return cell.text != cellUnknown && cell != s.front[y][x]
+}
+
+// render queues the escape sequences for the cells changed since the last
+// time and moves the cursor to where the windows have left it
+func (r *LightRenderer) render() {
+	s := r.screen
+	style := "\x00"
+	link := ""
+	for y := 0; y < s.height; y++ {
+		if !s.dirty[y] {
+			continue
+		}
+		s.dirty[y] = false
+		front := s.front[y]
+		for x := 0; x < s.width; {
+			if !s.changed(y, x) {
+				x++
+				continue
+			}
+			// Start from the left half of a wide character
+			if s.cell(y, x).text == cellWide && x > 0 {
+				x--
+			}
+			if r.y != y || r.x != x {
+				r.move(y, x)
+			}
+			for x < s.width && s.cell(y, x).text != cellUnknown {
+				cell := s.cell(y, x)
+				front[x] = cell
+				x++
+				if cell.text != cellWide {
+					if cell.style != style {
+						if len(cell.style) > 0 {
+							r.csi(";" + cell.style + "m")
+						} else {
+							r.csi("m")
+						}
+						style = cell.style
+					}
+					if cell.link != link {
+						if len(link) > 0 {
+							r.queued += r.hyperlink(";")
+						}
+						if len(cell.link) > 0 {
+							r.queued += r.hyperlink(cell.link)
+						}
+						link = cell.link
+					}
+					r.queued += cell.text
+					if x < s.width && s.cell(y, x).text == cellWide {
+						front[x] = s.cell(y, x)
+						x++
+					}
+				}
+				r.x = x
+
+				next := x
+				for next < s.width && next-x < maxCellGap && !s.changed(y, next) && s.cell(y, next).text != cellUnknown {
+					next++
+				}
+				if next >= s.width || !s.changed(y, next) {
+					break
+				}
+			}
+		}
+	}
+	if len(link) > 0 {
+		r.queued += r.hyperlink(";")
+	}
+	if style != "\x00" && len(style) > 0 {
+		r.csi("m")
+	}
+	if r.y != s.y || r.x != s.x {
+		r.move(s.y, s.x)
+	}
+}
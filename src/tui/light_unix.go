@@ -11,6 +11,7 @@ import (
 
 	"github.com/junegunn/fzf/src/util"
 	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
 )
 
 func IsLightRendererSupported() bool {
@@ -75,27 +76,39 @@ func (r *LightRenderer) updateTerminalSize() {
 
 	if err == nil {
 		r.width = width
-		r.height = r.maxHeightFunc(height)
+		r.ttyHeight = height
 	} else {
 		r.width = getEnv("COLUMNS", defaultWidth)
-		r.height = r.maxHeightFunc(getEnv("LINES", defaultHeight))
+		r.ttyHeight = getEnv("LINES", defaultHeight)
+	}
+	r.height = r.maxHeightFunc(r.ttyHeight)
+
+	r.cellWidth, r.cellHeight = 0, 0
+	if ws, err := unix.IoctlGetWinsize(r.fd(), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 {
+		r.cellWidth = int(ws.Xpixel) / int(ws.Col)
+		r.cellHeight = int(ws.Ypixel) / int(ws.Row)
 	}
 }
 
-func (r *LightRenderer) findOffset() (row int, col int) {
-	r.csi("6n")
-	r.flush()
-	bytes := []byte{}
-	for tries := 0; tries < offsetPollTries; tries++ {
-		bytes = r.getBytesInternal(bytes, tries > 0)
-		offsets := offsetRegexp.FindSubmatch(bytes)
-		if len(offsets) > 3 {
-			// Add anything we skipped over to the input buffer
-			r.buffer = append(r.buffer, offsets[1]...)
-			return atoi(string(offsets[2]), 0) - 1, atoi(string(offsets[3]), 0) - 1
+// WaitInput polls the terminal without reading the input, so that the input
+// typed while a command is executed goes to the command
+func (r *LightRenderer) WaitInput(block bool) bool {
+	if len(r.buffer) > 0 {
+		return true
+	}
+	timeout := 0
+	if block {
+		timeout = -1
+	}
+	fds := []unix.PollFd{{Fd: int32(r.fd()), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, timeout)
+		if err == unix.EINTR {
+			continue
 		}
+		// GetChar reports the error
+		return n > 0 || err != nil
 	}
-	return -1, -1
 }
 
 func (r *LightRenderer) getch(nonblock bool) (int, bool) {
@@ -109,20 +109,22 @@ func (r *LightRenderer) updateTerminalSize() {
 	var bufferInfo windows.ConsoleScreenBufferInfo
 	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(r.outHandle), &bufferInfo); err != nil {
 		r.width = getEnv("COLUMNS", defaultWidth)
-		r.height = r.maxHeightFunc(getEnv("LINES", defaultHeight))
-
+		r.ttyHeight = getEnv("LINES", defaultHeight)
 	} else {
-		r.width = int(bufferInfo.Window.Right - bufferInfo.Window.Left)
-		r.height = r.maxHeightFunc(int(bufferInfo.Window.Bottom - bufferInfo.Window.Top))
+		// The coordinates of the window are inclusive
+		r.width = int(bufferInfo.Window.Right-bufferInfo.Window.Left) + 1
+		r.ttyHeight = int(bufferInfo.Window.Bottom-bufferInfo.Window.Top) + 1
 	}
+	r.height = r.maxHeightFunc(r.ttyHeight)
 }
 
-func (r *LightRenderer) findOffset() (row int, col int) {
-	var bufferInfo windows.ConsoleScreenBufferInfo
-	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(r.outHandle), &bufferInfo); err != nil {
-		return -1, -1
+// WaitInput reads the input ahead as it is already taken from the console
+// by the goroutine started on initialization
+func (r *LightRenderer) WaitInput(block bool) bool {
+	if len(r.buffer) == 0 && (block || len(r.ttyinChannel) > 0) {
+		r.buffer = r.getBytes()
 	}
-	return int(bufferInfo.CursorPosition.X), int(bufferInfo.CursorPosition.Y)
+	return len(r.buffer) > 0
 }
 
 func (r *LightRenderer) getch(nonblock bool) (int, bool) {
@@ -0,0 +1,70 @@
+package tui
+
+import (
+	"os"
+	"strings"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+type multiplexer int
+
+const (
+	muxNone multiplexer = iota
+	muxTmux
+	muxScreen
+)
+
+// GNU screen discards the DCS sequences longer than this
+const screenChunkSize = 768
+
+// detectMultiplexer tells the terminal multiplexer fzf is running in. TERM is
+// not looked at as tmux also sets it to screen.
+func detectMultiplexer() multiplexer {
+	if len(os.Getenv("TMUX")) > 0 {
+		return muxTmux
+	}
+	if len(os.Getenv("STY")) > 0 {
+		return muxScreen
+	}
+	return muxNone
+}
+
+// passthrough wraps the sequence so that the terminal multiplexer passes it
+// through to the outer terminal. tmux requires allow-passthrough option.
+func passthrough(sequence string) string {
+	return wrapSequence(detectMultiplexer(), sequence)
+}
+
+func wrapSequence(mux multiplexer, sequence string) string {
+	switch mux {
+	case muxTmux:
+		return "\x1bPtmux;" + strings.ReplaceAll(sequence, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case muxScreen:
+		// screen ends the DCS sequence at the first ST, so only the OSC
+		// sequences are passed with BEL terminator, in chunks.
+		if !strings.HasPrefix(sequence, "\x1b]") {
+			return sequence
+		}
+		sequence = strings.TrimSuffix(strings.TrimSuffix(sequence, "\a"), "\x1b\\") + "\a"
+		var wrapped strings.Builder
+		for len(sequence) > 0 {
+			chunk := sequence[:util.Min(len(sequence), screenChunkSize)]
+			sequence = sequence[len(chunk):]
+			wrapped.WriteString("\x1bP" + chunk + "\x1b\\")
+		}
+		return wrapped.String()
+	}
+	return sequence
+}
+
+// hyperlink returns OSC 8 sequence of the parameters and the URI separated by
+// a semicolon. It is passed through GNU screen, which does not support
+// hyperlinks, while tmux handles them by itself.
+func (r *LightRenderer) hyperlink(link string) string {
+	sequence := "\x1b]8;" + link + "\x1b\\"
+	if r.mux == muxScreen {
+		return wrapSequence(muxScreen, sequence)
+	}
+	return sequence
+}
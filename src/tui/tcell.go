@@ -1,5 +1,3 @@
-// +build tcell windows
-
 package tui
 
 import (
@@ -15,10 +13,6 @@ import (
 	"github.com/mattn/go-runewidth"
 )
 
-func HasFullscreenRenderer() bool {
-	return true
-}
-
 func (p ColorPair) style() tcell.Style {
 	style := tcell.StyleDefault
 	return style.Foreground(tcell.Color(p.Fg())).Background(tcell.Color(p.Bg()))
@@ -38,6 +32,9 @@ type TcellWindow struct {
 	lastY       int
 	moveCursor  bool
 	borderStyle BorderStyle
+	labels      []BorderLabel
+	flash       bool
+	shadow      rune
 }
 
 func (w *TcellWindow) Top() int {
@@ -78,12 +75,17 @@ const (
 	Reverse        = Attr(tcell.AttrReverse)
 	Underline      = Attr(tcell.AttrUnderline)
 	Italic         = Attr(tcell.AttrItalic)
+
+	// Not supported by tcell
+	StrikeThrough = Attr(1 << 31)
 )
 
 const (
 	AttrUndefined = Attr(0)
 	AttrRegular   = Attr(1 << 7)
 	AttrClear     = Attr(1 << 8)
+
+	attrNegationShift = 8
 )
 
 func (r *FullscreenRenderer) defaultTheme() *ColorTheme {
@@ -117,11 +119,13 @@ func (c Color) Style() tcell.Color {
 }
 
 func (a Attr) Merge(b Attr) Attr {
-	return a | b
+	return mergeAttr(a, b)
 }
 
 var (
-	_screen tcell.Screen
+	_screen     tcell.Screen
+	_bellStyles []tcell.Style // Styles of the cells before the visual bell
+	_pending    tcell.Event   // Read ahead by WaitInput
 )
 
 func (r *FullscreenRenderer) initScreen() {
@@ -147,7 +151,8 @@ func (r *FullscreenRenderer) Init() {
 	encoding.Register()
 
 	r.initScreen()
-	initTheme(r.theme, r.defaultTheme(), r.forceBlack)
+	initTheme(r.theme, r.defaultTheme(), r.forceBlack, nil)
+	r.shadow = makeShadowStyle(r.theme)
 }
 
 func (r *FullscreenRenderer) MaxX() int {
@@ -177,8 +182,26 @@ func (r *FullscreenRenderer) Refresh() {
 	// noop
 }
 
+func (r *FullscreenRenderer) Resize(maxHeightFunc func(int) int) bool {
+	// Always fullscreen
+	return false
+}
+
+// WaitInput reads the next event ahead as tcell does not tell if there is
+// one without taking it
+func (r *FullscreenRenderer) WaitInput(block bool) bool {
+	if _pending == nil && block {
+		_pending = _screen.PollEvent()
+	}
+	return _pending != nil
+}
+
 func (r *FullscreenRenderer) GetChar() Event {
-	ev := _screen.PollEvent()
+	ev := _pending
+	if ev == nil {
+		ev = _screen.PollEvent()
+	}
+	_pending = nil
 	switch ev := ev.(type) {
 	case *tcell.EventResize:
 		return Event{Resize, 0, nil}
@@ -188,10 +211,24 @@ func (r *FullscreenRenderer) GetChar() Event {
 		x, y := ev.Position()
 		button := ev.Buttons()
 		mod := ev.Modifiers() != 0
+		// tcell reports the motion of the mouse with no button pressed in
+		// the same way as the release of a button
+		hover := button == tcell.ButtonNone && !r.pressed
+		// and the motion with a button pressed in the same way as the press
+		pressed := r.pressed
+		r.pressed = button&(tcell.Button1|tcell.Button2|tcell.Button3) != 0
 		if button&tcell.WheelDown != 0 {
-			return Event{Mouse, 0, &MouseEvent{y, x, -1, false, false, false, mod}}
+			return Event{Mouse, 0, &MouseEvent{y, x, -1, false, false, false, false, mod, false, false}}
 		} else if button&tcell.WheelUp != 0 {
-			return Event{Mouse, 0, &MouseEvent{y, x, +1, false, false, false, mod}}
+			return Event{Mouse, 0, &MouseEvent{y, x, +1, false, false, false, false, mod, false, false}}
+		} else if button&tcell.WheelLeft != 0 {
+			return Event{ScrollLeft, 0, &MouseEvent{y, x, 0, false, false, false, false, mod, false, false}}
+		} else if button&tcell.WheelRight != 0 {
+			return Event{ScrollRight, 0, &MouseEvent{y, x, 0, false, false, false, false, mod, false, false}}
+		} else if hover {
+			if r.hover {
+				return Event{Mouse, 0, &MouseEvent{y, x, 0, false, false, false, false, mod, true, false}}
+			}
 		} else if runtime.GOOS != "windows" {
 			// double and single taps on Windows don't quite work due to
 			// the console acting on the events and not allowing us
@@ -199,25 +236,29 @@ func (r *FullscreenRenderer) GetChar() Event {
 
 			left := button&tcell.Button1 != 0
 			down := left || button&tcell.Button3 != 0
-			double := false
+			if down && pressed {
+				return Event{Mouse, 0, &MouseEvent{y, x, 0, left, false, false, false, mod, false, true}}
+			}
+			double, triple := false, false
 			if down {
 				now := time.Now()
 				if !left {
 					r.clickY = []int{}
-				} else if now.Sub(r.prevDownTime) < doubleClickDuration {
+				} else if now.Sub(r.prevDownTime) < r.doubleClick {
 					r.clickY = append(r.clickY, x)
 				} else {
 					r.clickY = []int{x}
 					r.prevDownTime = now
 				}
-			} else {
-				if len(r.clickY) > 1 && r.clickY[0] == r.clickY[1] &&
-					time.Now().Sub(r.prevDownTime) < doubleClickDuration {
+			} else if time.Now().Sub(r.prevDownTime) < r.doubleClick {
+				if len(r.clickY) == 3 && r.clickY[0] == r.clickY[1] && r.clickY[1] == r.clickY[2] {
+					triple = true
+				} else if len(r.clickY) > 1 && r.clickY[0] == r.clickY[1] {
 					double = true
 				}
 			}
 
-			return Event{Mouse, 0, &MouseEvent{y, x, 0, left, down, double, mod}}
+			return Event{Mouse, 0, &MouseEvent{y, x, 0, left, down, double, triple, mod, false, false}}
 		}
 
 		// process keyboard:
@@ -418,11 +459,126 @@ func (r *FullscreenRenderer) Close() {
 	_screen.Fini()
 }
 
+func (r *FullscreenRenderer) PassThrough(str string) {
+	// Not supported as tcell owns the contents of the screen
+}
+
+func (r *FullscreenRenderer) Background(str string) {
+	// Not supported as tcell owns the contents of the screen
+}
+
+func (r *FullscreenRenderer) Emit(sequence string) {
+	// tcell does not allow us to write raw sequences to the terminal
+}
+
+func (r *FullscreenRenderer) KeyboardProtocol() bool {
+	return false
+}
+
+func (r *FullscreenRenderer) Pasted() string {
+	// Bracketed paste is not supported by this version of tcell
+	return ""
+}
+
+func (r *FullscreenRenderer) Reserve(region *Region) {
+	// Not supported as tcell owns the contents of the screen
+}
+
+func (r *FullscreenRenderer) DrawReserved(str string) {
+	// Not supported as tcell owns the contents of the screen and redraws
+	// them without knowing what has been written to the terminal. The string
+	// is discarded.
+}
+
+// CanDisplay tells if every character of each text can be displayed in the
+// character set of the terminal
+func (r *FullscreenRenderer) CanDisplay(texts []string) []bool {
+	supported := make([]bool, len(texts))
+	for idx, text := range texts {
+		supported[idx] = true
+		for _, char := range text {
+			if !_screen.CanDisplay(char, false) {
+				supported[idx] = false
+				break
+			}
+		}
+	}
+	return supported
+}
+
+// MeasureWidth is not supported as tcell owns the screen
+func (r *FullscreenRenderer) MeasureWidth(text string) int {
+	return -1
+}
+
 func (r *FullscreenRenderer) RefreshWindows(windows []Window) {
 	// TODO
+	r.windows = windows
 	for _, w := range windows {
 		w.Refresh()
 	}
+	drawShadows(r, windows, r.shadow)
+	_screen.Show()
+}
+
+func (r *FullscreenRenderer) Bell(style BellStyle) {
+	switch style {
+	case BellAudible:
+		_screen.Beep()
+	case BellVisual:
+		// Reverse the style of every cell
+		width, height := _screen.Size()
+		_bellStyles = make([]tcell.Style, width*height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				mainc, combc, style, _ := _screen.GetContent(x, y)
+				_bellStyles[y*width+x] = style
+				_screen.SetContent(x, y, mainc, combc, reverseStyle(style))
+			}
+		}
+		_screen.Show()
+	case BellFlashBorder:
+		r.flashBorders(true)
+	}
+}
+
+func (r *FullscreenRenderer) BellOff(style BellStyle) {
+	switch style {
+	case BellVisual:
+		// Restore the style of the cells unless they are redrawn in the
+		// meantime
+		width, height := _screen.Size()
+		if len(_bellStyles) == width*height {
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					mainc, combc, style, _ := _screen.GetContent(x, y)
+					if original := _bellStyles[y*width+x]; style == reverseStyle(original) {
+						_screen.SetContent(x, y, mainc, combc, original)
+					}
+				}
+			}
+		}
+		_bellStyles = nil
+		_screen.Show()
+	case BellFlashBorder:
+		r.flashBorders(false)
+	}
+}
+
+func reverseStyle(style tcell.Style) tcell.Style {
+	_, _, attrs := style.Decompose()
+	return style.Reverse(attrs&tcell.AttrReverse == 0)
+}
+
+// flashBorders redraws the borders of the windows in the color of the flash
+// or in the normal color
+func (r *FullscreenRenderer) flashBorders(flash bool) {
+	for _, w := range r.windows {
+		if tw, ok := w.(*TcellWindow); ok {
+			tw.flash = flash
+			tw.drawBorder()
+		}
+	}
 	_screen.Show()
 }
 
@@ -458,6 +614,19 @@ func (w *TcellWindow) Erase() {
 	fill(w.left-1, w.top, w.width+1, w.height, w.normal, ' ')
 }
 
+func (w *TcellWindow) LinkBegin(uri string, params string) {
+	// Not supported
+}
+
+func (w *TcellWindow) LinkEnd() {
+	// Not supported
+}
+
+func (w *TcellWindow) DrawImage(image Image) bool {
+	// tcell does not allow us to write raw sequences to the terminal
+	return false
+}
+
 func (w *TcellWindow) Enclose(y int, x int) bool {
 	return x >= w.left && x < (w.left+w.width) &&
 		y >= w.top && y < (w.top+w.height)
@@ -598,6 +767,18 @@ func (w *TcellWindow) CFill(fg Color, bg Color, a Attr, str string) FillReturn {
 	return w.fillString(str, NewColorPair(fg, bg, a))
 }
 
+func (w *TcellWindow) SetBorderLabels(labels []BorderLabel) {
+	w.labels = labels
+}
+
+func (w *TcellWindow) SetShadow(shade rune) {
+	w.shadow = shade
+}
+
+func (w *TcellWindow) shadowShade() rune {
+	return w.shadow
+}
+
 func (w *TcellWindow) drawBorder() {
 	shape := w.borderStyle.shape
 	if shape == BorderNone {
@@ -619,36 +800,60 @@ func (w *TcellWindow) drawBorder() {
 	} else {
 		style = w.normal.style()
 	}
+	if w.flash {
+		style = style.Reverse(true)
+	}
 
-	switch shape {
-	case BorderRounded, BorderSharp, BorderHorizontal, BorderTop:
+	sides := w.borderStyle.sides
+	if sides[0] != LineNone {
 		for x := left; x < right; x++ {
-			_screen.SetContent(x, top, w.borderStyle.horizontal, nil, style)
+			_screen.SetContent(x, top, w.borderStyle.top, nil, style)
 		}
 	}
-	switch shape {
-	case BorderRounded, BorderSharp, BorderHorizontal, BorderBottom:
+	if sides[2] != LineNone {
 		for x := left; x < right; x++ {
-			_screen.SetContent(x, bot-1, w.borderStyle.horizontal, nil, style)
+			_screen.SetContent(x, bot-1, w.borderStyle.bottom, nil, style)
 		}
 	}
-	switch shape {
-	case BorderRounded, BorderSharp, BorderVertical, BorderLeft:
+	if sides[3] != LineNone {
 		for y := top; y < bot; y++ {
-			_screen.SetContent(left, y, w.borderStyle.vertical, nil, style)
+			_screen.SetContent(left, y, w.borderStyle.left, nil, style)
 		}
 	}
-	switch shape {
-	case BorderRounded, BorderSharp, BorderVertical, BorderRight:
+	if sides[1] != LineNone {
 		for y := top; y < bot; y++ {
-			_screen.SetContent(right-1, y, w.borderStyle.vertical, nil, style)
+			_screen.SetContent(right-1, y, w.borderStyle.right, nil, style)
 		}
 	}
-	switch shape {
-	case BorderRounded, BorderSharp:
+	if sides[0] != LineNone && sides[3] != LineNone {
 		_screen.SetContent(left, top, w.borderStyle.topLeft, nil, style)
+	}
+	if sides[0] != LineNone && sides[1] != LineNone {
 		_screen.SetContent(right-1, top, w.borderStyle.topRight, nil, style)
+	}
+	if sides[2] != LineNone && sides[3] != LineNone {
 		_screen.SetContent(left, bot-1, w.borderStyle.bottomLeft, nil, style)
+	}
+	if sides[2] != LineNone && sides[1] != LineNone {
 		_screen.SetContent(right-1, bot-1, w.borderStyle.bottomRight, nil, style)
 	}
+
+	if w.color {
+		if w.preview {
+			style = ColPreviewLabel.style()
+		} else {
+			style = ColBorderLabel.style()
+		}
+	}
+	for _, label := range w.labels {
+		y := top
+		if label.Bottom {
+			y = bot - 1
+		}
+		x := left + label.X
+		for _, r := range label.Text {
+			_screen.SetContent(x, y, r, nil, style)
+			x += runewidth.RuneWidth(r)
+		}
+	}
 }
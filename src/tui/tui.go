@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -52,8 +53,15 @@ const (
 	Resize
 	Mouse
 	DoubleClick
+	TripleClick
+	ClickBorder
+	ClickPreviewBorder
+	ClickScrollbar
+	ClickInfo
 	LeftClick
 	RightClick
+	ScrollLeft
+	ScrollRight
 
 	BTab
 	BSpace
@@ -90,6 +98,11 @@ const (
 
 	Change
 	BackwardEOF
+	Zero
+	Paste
+	FocusGained
+	FocusLost
+	Leader // Placeholder of the key given by --leader
 
 	AltBS
 
@@ -105,6 +118,11 @@ const (
 
 	Alt
 	CtrlAlt
+
+	// Only reported by the terminals supporting the keyboard protocol of kitty
+	Ctrl
+	CtrlShift
+	Super
 )
 
 func (t EventType) AsEvent() Event {
@@ -136,8 +154,67 @@ func CtrlAltKey(r rune) Event {
 	return Event{CtrlAlt, r, nil}
 }
 
+func CtrlKey(r rune) Event {
+	return Event{Ctrl, r, nil}
+}
+
+func CtrlShiftKey(r rune) Event {
+	return Event{CtrlShift, r, nil}
+}
+
+func SuperKey(r rune) Event {
+	return Event{Super, r, nil}
+}
+
+// Legacy returns the event the terminal would send for the key without the
+// keyboard protocol of kitty, so that the key works with the bindings for the
+// legacy event when it is not bound by itself
+func (e Event) Legacy() (Event, bool) {
+	switch e.Type {
+	case Ctrl:
+		switch e.Char {
+		case 'i':
+			return Tab.AsEvent(), true
+		case 'm':
+			return CtrlM.AsEvent(), true
+		case '[':
+			return ESC.AsEvent(), true
+		}
+	case CtrlShift:
+		if e.Char >= 'a' && e.Char <= 'z' {
+			return EventType(CtrlA.Int() + int(e.Char-'a')).AsEvent(), true
+		}
+	}
+	return e, false
+}
+
+// CtrlAlias returns the event of ctrl-i, ctrl-m, or ctrl-[ that the terminal
+// reports as tab, enter, or esc without the keyboard protocol of kitty
+func (e Event) CtrlAlias() (Event, bool) {
+	switch e.Type {
+	case Tab:
+		return CtrlKey('i'), true
+	case CtrlM:
+		return CtrlKey('m'), true
+	case ESC:
+		return CtrlKey('['), true
+	}
+	return e, false
+}
+
 const (
-	doubleClickDuration = 500 * time.Millisecond
+	// DefaultClickInterval is the maximum interval between the clicks of a
+	// double-click
+	DefaultClickInterval = 500 * time.Millisecond
+
+	// BellDuration is how long the visual bell lasts
+	BellDuration = 100 * time.Millisecond
+
+	// Ratio for deriving the gutter color from the background of the current line
+	gutterDarkenRatio = 0.2
+
+	// Ratio for deriving the color of the shadow from the background
+	shadowDarkenRatio = 0.4
 )
 
 type Color int32
@@ -150,11 +227,106 @@ func (c Color) is24() bool {
 	return c > 0 && (c&(1<<24)) > 0
 }
 
+func (c Color) isBase16() bool {
+	return c >= colBlack && c < 16
+}
+
+// Palette holds the RGB values of the 16 base colors reported by the terminal
+type Palette [16]Color
+
+func rgbColor(r int, g int, b int) Color {
+	return Color((1 << 24) + (r << 16) + (g << 8) + b)
+}
+
+// RGB returns the red, green, and blue components of the color. The RGB values
+// of the 16 base colors are only known when the palette is given.
+func (c Color) RGB(palette *Palette) (int, int, int, bool) {
+	switch {
+	case c.is24():
+		return int((c >> 16) & 0xff), int((c >> 8) & 0xff), int(c & 0xff), true
+	case c.isBase16():
+		if palette == nil {
+			return 0, 0, 0, false
+		}
+		return palette[c].RGB(nil)
+	case c >= 16 && c < 232:
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		i := int(c) - 16
+		return level(i / 36), level(i / 6 % 6), level(i % 6), true
+	case c >= 232 && c < 256:
+		gray := 8 + (int(c)-232)*10
+		return gray, gray, gray, true
+	}
+	return 0, 0, 0, false
+}
+
+// Darken returns the 24-bit color darkened by the given ratio. The color is
+// returned as is if its RGB value is unknown.
+func (c Color) Darken(ratio float64, palette *Palette) Color {
+	r, g, b, ok := c.RGB(palette)
+	if !ok {
+		return c
+	}
+	scale := func(v int) int {
+		return int(float64(v) * (1 - ratio))
+	}
+	return rgbColor(scale(r), scale(g), scale(b))
+}
+
+// Default RGB values of the 16 base colors of xterm, used for blending when the
+// actual palette of the terminal is unknown
+var xtermPalette = Palette{
+	rgbColor(0, 0, 0), rgbColor(205, 0, 0), rgbColor(0, 205, 0), rgbColor(205, 205, 0),
+	rgbColor(0, 0, 238), rgbColor(205, 0, 205), rgbColor(0, 205, 205), rgbColor(229, 229, 229),
+	rgbColor(127, 127, 127), rgbColor(255, 0, 0), rgbColor(0, 255, 0), rgbColor(255, 255, 0),
+	rgbColor(92, 92, 255), rgbColor(255, 0, 255), rgbColor(0, 255, 255), rgbColor(255, 255, 255)}
+
+// Blend returns the 24-bit color obtained by mixing the other color into the
+// color by the given ratio. The color is returned as is if the RGB value of
+// either color is unknown.
+func (c Color) Blend(other Color, ratio float64) Color {
+	if blended, ok := c.blend(other, ratio, &xtermPalette); ok {
+		return blended
+	}
+	return c
+}
+
+func (c Color) blend(other Color, ratio float64, palette *Palette) (Color, bool) {
+	r1, g1, b1, ok1 := c.RGB(palette)
+	r2, g2, b2, ok2 := other.RGB(palette)
+	if !ok1 || !ok2 {
+		return c, false
+	}
+	mix := func(v1 int, v2 int) int {
+		return int(float64(v1)*(1-ratio) + float64(v2)*ratio + 0.5)
+	}
+	return rgbColor(mix(r1, r2), mix(g1, g2), mix(b1, b2)), true
+}
+
 type ColorAttr struct {
 	Color Color
 	Attr  Attr
 }
 
+// ColorRef refers to either a color or the color of an element of the theme
+type ColorRef struct {
+	Element string
+	Color   Color
+}
+
+// ColorBlend describes the color of an element derived by blending two colors
+type ColorBlend struct {
+	Element string
+	Base    ColorRef
+	Other   ColorRef
+	Ratio   float64
+}
+
 func NewColorAttr() ColorAttr {
 	return ColorAttr{Color: colUndefined, Attr: AttrUndefined}
 }
@@ -175,6 +347,27 @@ const (
 	colWhite
 )
 
+type ImageProtocol int
+
+const (
+	ImageSixel ImageProtocol = iota
+	ImageKitty
+	ImageITerm2
+)
+
+// Image is a graphic encoded in a terminal image protocol. Sixel data is
+// passed through to the terminal as is, while the commands of the kitty
+// graphics protocol are rewritten so that the placement can be deleted later,
+// and the size of an iTerm2 inline image is rewritten to fit in the window.
+type Image struct {
+	Protocol ImageProtocol
+	Data     string
+	Width    int // in pixels
+	Height   int // in pixels
+	Cols     int // in cells, overrides Width if not zero
+	Rows     int // in cells, overrides Height if not zero
+}
+
 type FillReturn int
 
 const (
@@ -183,6 +376,18 @@ const (
 	FillSuspend
 )
 
+// Negate returns the attribute that clears the given attributes when merged
+// into another attribute
+func (a Attr) Negate() Attr {
+	return a << attrNegationShift
+}
+
+// Attributes in b take precedence over the ones in a. Negated attributes in b
+// clear the corresponding attributes in a, and vice versa.
+func mergeAttr(a Attr, b Attr) Attr {
+	return a&^(b>>attrNegationShift)&^b.Negate() | b
+}
+
 type ColorPair struct {
 	fg   Color
 	bg   Color
@@ -193,7 +398,7 @@ func HexToColor(rrggbb string) Color {
 	r, _ := strconv.ParseInt(rrggbb[1:3], 16, 0)
 	g, _ := strconv.ParseInt(rrggbb[3:5], 16, 0)
 	b, _ := strconv.ParseInt(rrggbb[5:7], 16, 0)
-	return Color((1 << 24) + (r << 16) + (g << 8) + b)
+	return rgbColor(int(r), int(g), int(b))
 }
 
 func NewColorPair(fg Color, bg Color, attr Attr) ColorPair {
@@ -247,6 +452,10 @@ func (p ColorPair) MergeNonDefault(other ColorPair) ColorPair {
 	return p.merge(other, colDefault)
 }
 
+// MatchColors is the number of the colors of the palette for the matches of
+// the different terms of the query
+const MatchColors = 8
+
 type ColorTheme struct {
 	Colored      bool
 	Input        ColorAttr
@@ -261,12 +470,79 @@ type ColorTheme struct {
 	Match        ColorAttr
 	Current      ColorAttr
 	CurrentMatch ColorAttr
+	TermMatch    [MatchColors]ColorAttr
 	Spinner      ColorAttr
+	Bar          ColorAttr
 	Info         ColorAttr
 	Cursor       ColorAttr
 	Selected     ColorAttr
 	Header       ColorAttr
 	Border       ColorAttr
+	BorderLabel  ColorAttr
+	Scrollbar    ColorAttr
+	Blends       *[]ColorBlend
+}
+
+// Element returns the pointer to the attribute of the element with the given
+// name, or nil if there is no such element
+func (theme *ColorTheme) Element(name string) *ColorAttr {
+	switch name {
+	case "query", "input":
+		return &theme.Input
+	case "disabled":
+		return &theme.Disabled
+	case "fg":
+		return &theme.Fg
+	case "bg":
+		return &theme.Bg
+	case "preview-fg":
+		return &theme.PreviewFg
+	case "preview-bg":
+		return &theme.PreviewBg
+	case "fg+":
+		return &theme.Current
+	case "bg+":
+		return &theme.DarkBg
+	case "gutter":
+		return &theme.Gutter
+	case "hl":
+		return &theme.Match
+	case "hl+":
+		return &theme.CurrentMatch
+	case "border":
+		return &theme.Border
+	case "label":
+		return &theme.BorderLabel
+	case "scrollbar":
+		return &theme.Scrollbar
+	case "prompt":
+		return &theme.Prompt
+	case "spinner":
+		return &theme.Spinner
+	case "bar":
+		return &theme.Bar
+	case "info":
+		return &theme.Info
+	case "pointer":
+		return &theme.Cursor
+	case "marker":
+		return &theme.Selected
+	case "header":
+		return &theme.Header
+	}
+	if strings.HasPrefix(name, "match-") {
+		if n, err := strconv.Atoi(name[6:]); err == nil && n >= 1 && n <= MatchColors {
+			return &theme.TermMatch[n-1]
+		}
+	}
+	return nil
+}
+
+func (theme *ColorTheme) resolve(ref ColorRef) Color {
+	if len(ref.Element) > 0 {
+		return theme.Element(ref.Element).Color
+	}
+	return ref.Color
 }
 
 type Event struct {
@@ -282,9 +558,23 @@ type MouseEvent struct {
 	Left   bool
 	Down   bool
 	Double bool
+	Triple bool
 	Mod    bool
+	Hover  bool
+	Drag   bool // Motion with a button pressed
 }
 
+// BellStyle is the way the renderer notifies the user of the events like
+// rejected actions
+type BellStyle int
+
+const (
+	BellNone BellStyle = iota
+	BellAudible
+	BellVisual
+	BellFlashBorder
+)
+
 type BorderShape int
 
 const (
@@ -297,59 +587,209 @@ const (
 	BorderBottom
 	BorderLeft
 	BorderRight
+	BorderDashed
+	BorderHeavyDashed
+	BorderCustom
+)
+
+// BorderLine is the kind of line drawn on a side of the border
+type BorderLine int
+
+const (
+	LineNone BorderLine = iota
+	LineSharp
+	LineRounded
+	LineBold
+	LineDouble
+	LineDashed
+	LineHeavyDashed
+)
+
+// BorderSides holds the kind of line of each side of the border (TRBL)
+type BorderSides [4]BorderLine
+
+// Sides returns the kind of line of each side of the predefined shape
+func (shape BorderShape) Sides() BorderSides {
+	switch shape {
+	case BorderRounded:
+		return BorderSides{LineRounded, LineRounded, LineRounded, LineRounded}
+	case BorderSharp:
+		return BorderSides{LineSharp, LineSharp, LineSharp, LineSharp}
+	case BorderDashed:
+		return BorderSides{LineDashed, LineDashed, LineDashed, LineDashed}
+	case BorderHeavyDashed:
+		return BorderSides{LineHeavyDashed, LineHeavyDashed, LineHeavyDashed, LineHeavyDashed}
+	case BorderHorizontal:
+		return BorderSides{LineSharp, LineNone, LineSharp, LineNone}
+	case BorderVertical:
+		return BorderSides{LineNone, LineSharp, LineNone, LineSharp}
+	case BorderTop:
+		return BorderSides{LineSharp, LineNone, LineNone, LineNone}
+	case BorderRight:
+		return BorderSides{LineNone, LineSharp, LineNone, LineNone}
+	case BorderBottom:
+		return BorderSides{LineNone, LineNone, LineSharp, LineNone}
+	case BorderLeft:
+		return BorderSides{LineNone, LineNone, LineNone, LineSharp}
+	}
+	return BorderSides{}
+}
+
+// weight is the index of the line in the tables of box-drawing characters
+func (line BorderLine) weight() int {
+	switch line {
+	case LineBold, LineHeavyDashed:
+		return 1
+	case LineDouble:
+		return 2
+	}
+	return 0
+}
+
+func (line BorderLine) horizontal() rune {
+	switch line {
+	case LineDashed:
+		return '╌'
+	case LineHeavyDashed:
+		return '╍'
+	}
+	return horizontalLines[line.weight()]
+}
+
+func (line BorderLine) vertical() rune {
+	switch line {
+	case LineDashed:
+		return '╎'
+	case LineHeavyDashed:
+		return '╏'
+	}
+	return verticalLines[line.weight()]
+}
+
+// Glyphs returns the characters used to draw the kind of line
+func (line BorderLine) Glyphs() string {
+	switch line {
+	case LineNone:
+		return ""
+	case LineRounded:
+		return string(roundedCorners)
+	}
+	weight := line.weight()
+	return string([]rune{line.horizontal(), line.vertical(), borderCorners[0][weight*3+weight]})
+}
+
+var (
+	horizontalLines = []rune("─━═")
+	verticalLines   = []rune("│┃║")
+	roundedCorners  = []rune("╭╮╰╯")
+
+	// Corners indexed by the weights of the horizontal and the vertical sides.
+	// There are no characters joining a bold line and a double line, so the
+	// corner of the horizontal side is used instead.
+	borderCorners = [4][]rune{
+		[]rune("┌┎╓┍┏┏╒╔╔"),
+		[]rune("┐┒╖┑┓┓╕╗╗"),
+		[]rune("└┖╙┕┗┗╘╚╚"),
+		[]rune("┘┚╜┙┛┛╛╝╝")}
 )
 
 type BorderStyle struct {
 	shape       BorderShape
-	horizontal  rune
-	vertical    rune
+	sides       BorderSides
+	top         rune
+	right       rune
+	bottom      rune
+	left        rune
 	topLeft     rune
 	topRight    rune
 	bottomLeft  rune
 	bottomRight rune
 }
 
+// Sides returns the kind of line of each side of the border (TRBL)
+func (s BorderStyle) Sides() BorderSides {
+	return s.sides
+}
+
 type BorderCharacter int
 
+// BorderLabel is the text drawn on the top or the bottom line of the border
+type BorderLabel struct {
+	Text   string
+	X      int
+	Bottom bool
+}
+
 func MakeBorderStyle(shape BorderShape, unicode bool) BorderStyle {
-	if unicode {
-		if shape == BorderRounded {
-			return BorderStyle{
-				shape:       shape,
-				horizontal:  '─',
-				vertical:    '│',
-				topLeft:     '╭',
-				topRight:    '╮',
-				bottomLeft:  '╰',
-				bottomRight: '╯',
-			}
-		}
+	return makeBorderStyle(shape, shape.Sides(), unicode)
+}
+
+// MakeCustomBorderStyle returns a BorderStyle whose sides are drawn with the
+// given kinds of lines. LineNone omits the side.
+func MakeCustomBorderStyle(sides BorderSides, unicode bool) BorderStyle {
+	return makeBorderStyle(BorderCustom, sides, unicode)
+}
+
+func makeBorderStyle(shape BorderShape, sides BorderSides, unicode bool) BorderStyle {
+	if !unicode {
 		return BorderStyle{
 			shape:       shape,
-			horizontal:  '─',
-			vertical:    '│',
-			topLeft:     '┌',
-			topRight:    '┐',
-			bottomLeft:  '└',
-			bottomRight: '┘',
+			sides:       sides,
+			top:         '-',
+			right:       '|',
+			bottom:      '-',
+			left:        '|',
+			topLeft:     '+',
+			topRight:    '+',
+			bottomLeft:  '+',
+			bottomRight: '+',
 		}
 	}
+	corner := func(idx int, horizontal BorderLine, vertical BorderLine) rune {
+		h := horizontal.weight()
+		v := vertical.weight()
+		if h == 0 && v == 0 && (horizontal == LineRounded || vertical == LineRounded) {
+			return roundedCorners[idx]
+		}
+		return borderCorners[idx][h*3+v]
+	}
 	return BorderStyle{
 		shape:       shape,
-		horizontal:  '-',
-		vertical:    '|',
-		topLeft:     '+',
-		topRight:    '+',
-		bottomLeft:  '+',
-		bottomRight: '+',
+		sides:       sides,
+		top:         sides[0].horizontal(),
+		right:       sides[1].vertical(),
+		bottom:      sides[2].horizontal(),
+		left:        sides[3].vertical(),
+		topLeft:     corner(0, sides[0], sides[3]),
+		topRight:    corner(1, sides[0], sides[1]),
+		bottomLeft:  corner(2, sides[2], sides[3]),
+		bottomRight: corner(3, sides[2], sides[1]),
 	}
 }
 
+// WithChars returns a copy of the style drawn with the given characters in
+// the order of top-left, top, top-right, right, bottom-right, bottom,
+// bottom-left, and left
+func (s BorderStyle) WithChars(chars []rune) BorderStyle {
+	s.topLeft = chars[0]
+	s.top = chars[1]
+	s.topRight = chars[2]
+	s.right = chars[3]
+	s.bottomRight = chars[4]
+	s.bottom = chars[5]
+	s.bottomLeft = chars[6]
+	s.left = chars[7]
+	return s
+}
+
 func MakeTransparentBorder() BorderStyle {
 	return BorderStyle{
 		shape:       BorderRounded,
-		horizontal:  ' ',
-		vertical:    ' ',
+		sides:       BorderRounded.Sides(),
+		top:         ' ',
+		right:       ' ',
+		bottom:      ' ',
+		left:        ' ',
 		topLeft:     ' ',
 		topRight:    ' ',
 		bottomLeft:  ' ',
@@ -363,9 +803,53 @@ type Renderer interface {
 	Clear()
 	RefreshWindows(windows []Window)
 	Refresh()
+	Resize(maxHeightFunc func(int) int) bool
 	Close()
+	PassThrough(str string)
+	// Background draws the string underneath the windows. It is visible
+	// through the blank cells of the default colors.
+	Background(str string)
+	// CanDisplay tells if the terminal can display each of the texts
+	CanDisplay(texts []string) []bool
+	// MeasureWidth returns the width of the text displayed on the terminal,
+	// or -1 if unknown
+	MeasureWidth(text string) int
+
+	// Emit writes the sequence that prints nothing, such as OSC 52 to set
+	// the clipboard, to the terminal. It is wrapped so that the terminal
+	// multiplexer passes it through to the outer terminal.
+	Emit(sequence string)
+
+	// Pasted returns the text of the last Paste event
+	Pasted() string
+
+	// KeyboardProtocol tells if the keyboard protocol of kitty is enabled, so
+	// that ctrl-i, ctrl-m, and ctrl-[ are told apart from tab, enter, and esc
+	KeyboardProtocol() bool
+
+	// Reserve keeps the renderer from printing over the region so that an
+	// external program can draw into it. nil releases the region.
+	Reserve(region *Region)
+	// DrawReserved writes the string to the terminal as is from the top-left
+	// corner of the reserved region. The text is clipped to the region, but
+	// the images drawn by the escape sequences are not. Neither of them is
+	// supported by the tcell renderer.
+	DrawReserved(str string)
+
+	// Bell gives the feedback in the style. It should be called after
+	// RefreshWindows as the flash of the border redraws the windows given.
+	// The visual feedback lasts until BellOff is called, so that the caller
+	// is not blocked in the meantime.
+	Bell(style BellStyle)
+	BellOff(style BellStyle)
 
 	GetChar() Event
+	// WaitInput blocks until the input is available to GetChar, and tells
+	// whether it is available without blocking if block is false. The light
+	// renderer does not read the input in the meantime so that it is not
+	// taken from the commands executed while waiting. The other renderers
+	// read it ahead for the next GetChar.
+	WaitInput(block bool) bool
 
 	MaxX() int
 	MaxY() int
@@ -373,6 +857,20 @@ type Renderer interface {
 	NewWindow(top int, left int, width int, height int, preview bool, borderStyle BorderStyle) Window
 }
 
+// Region is a rectangular area of the screen relative to the top-left corner
+// of the finder
+type Region struct {
+	Top    int
+	Left   int
+	Width  int
+	Height int
+}
+
+// Contains tells if the cell is in the region
+func (r Region) Contains(y int, x int) bool {
+	return y >= r.Top && y < r.Top+r.Height && x >= r.Left && x < r.Left+r.Width
+}
+
 type Window interface {
 	Top() int
 	Left() int
@@ -394,21 +892,45 @@ type Window interface {
 	Fill(text string) FillReturn
 	CFill(fg Color, bg Color, attr Attr, text string) FillReturn
 	Erase()
+
+	// LinkBegin and LinkEnd surround the text printed in between with an OSC 8
+	// hyperlink. Renderers that cannot emit the sequence ignore them.
+	LinkBegin(uri string, params string)
+	LinkEnd()
+
+	// DrawImage draws the image at the beginning of the current line and
+	// moves the cursor to the line below it. It returns false without drawing
+	// anything if the renderer cannot display the image within the window.
+	DrawImage(image Image) bool
+
+	SetBorderLabels(labels []BorderLabel)
+
+	// SetShadow makes the window cast a shadow on the right and at the bottom,
+	// offset by one cell. The renderer draws it with the shade character if
+	// it cannot darken the background color. Zero removes the shadow.
+	SetShadow(shade rune)
 }
 
 type FullscreenRenderer struct {
 	theme        *ColorTheme
 	mouse        bool
+	hover        bool
+	pressed      bool
 	forceBlack   bool
+	doubleClick  time.Duration
 	prevDownTime time.Time
 	clickY       []int
+	windows      []Window
+	shadow       shadowStyle
 }
 
-func NewFullscreenRenderer(theme *ColorTheme, forceBlack bool, mouse bool) Renderer {
+func NewFullscreenRenderer(theme *ColorTheme, forceBlack bool, mouse bool, hover bool, clickInterval time.Duration) Renderer {
 	r := &FullscreenRenderer{
 		theme:        theme,
 		mouse:        mouse,
+		hover:        hover,
 		forceBlack:   forceBlack,
+		doubleClick:  clickInterval,
 		prevDownTime: time.Unix(0, 0),
 		clickY:       []int{}}
 	return r
@@ -429,16 +951,24 @@ var (
 	ColSelected             ColorPair
 	ColCurrent              ColorPair
 	ColCurrentMatch         ColorPair
+	ColTermMatches          []ColorPair
+	ColCurrentTermMatches   []ColorPair
 	ColCurrentCursor        ColorPair
 	ColCurrentCursorEmpty   ColorPair
 	ColCurrentSelected      ColorPair
 	ColCurrentSelectedEmpty ColorPair
 	ColSpinner              ColorPair
+	ColBar                  ColorPair
+	ColCurrentBar           ColorPair
 	ColInfo                 ColorPair
 	ColHeader               ColorPair
 	ColBorder               ColorPair
 	ColPreview              ColorPair
 	ColPreviewBorder        ColorPair
+	ColBorderLabel          ColorPair
+	ColPreviewLabel         ColorPair
+	ColScrollbar            ColorPair
+	ColPreviewScrollbar     ColorPair
 )
 
 func EmptyTheme() *ColorTheme {
@@ -456,12 +986,16 @@ func EmptyTheme() *ColorTheme {
 		Match:        ColorAttr{colUndefined, AttrUndefined},
 		Current:      ColorAttr{colUndefined, AttrUndefined},
 		CurrentMatch: ColorAttr{colUndefined, AttrUndefined},
+		TermMatch:    undefinedMatchColors(),
 		Spinner:      ColorAttr{colUndefined, AttrUndefined},
+		Bar:          ColorAttr{colUndefined, AttrUndefined},
 		Info:         ColorAttr{colUndefined, AttrUndefined},
 		Cursor:       ColorAttr{colUndefined, AttrUndefined},
 		Selected:     ColorAttr{colUndefined, AttrUndefined},
 		Header:       ColorAttr{colUndefined, AttrUndefined},
-		Border:       ColorAttr{colUndefined, AttrUndefined}}
+		Border:       ColorAttr{colUndefined, AttrUndefined},
+		BorderLabel:  ColorAttr{colUndefined, AttrUndefined},
+		Scrollbar:    ColorAttr{colUndefined, AttrUndefined}}
 }
 
 func NoColorTheme() *ColorTheme {
@@ -479,12 +1013,16 @@ func NoColorTheme() *ColorTheme {
 		Match:        ColorAttr{colDefault, Underline},
 		Current:      ColorAttr{colDefault, Reverse},
 		CurrentMatch: ColorAttr{colDefault, Reverse | Underline},
+		TermMatch:    undefinedMatchColors(),
 		Spinner:      ColorAttr{colDefault, AttrRegular},
+		Bar:          ColorAttr{colDefault, AttrRegular},
 		Info:         ColorAttr{colDefault, AttrRegular},
 		Cursor:       ColorAttr{colDefault, AttrRegular},
 		Selected:     ColorAttr{colDefault, AttrRegular},
 		Header:       ColorAttr{colDefault, AttrRegular},
-		Border:       ColorAttr{colDefault, AttrRegular}}
+		Border:       ColorAttr{colDefault, AttrRegular},
+		BorderLabel:  ColorAttr{colDefault, AttrRegular},
+		Scrollbar:    ColorAttr{colDefault, AttrRegular}}
 }
 
 func errorExit(message string) {
@@ -507,12 +1045,16 @@ func init() {
 		Match:        ColorAttr{colGreen, AttrUndefined},
 		Current:      ColorAttr{colYellow, AttrUndefined},
 		CurrentMatch: ColorAttr{colGreen, AttrUndefined},
+		TermMatch:    undefinedMatchColors(),
 		Spinner:      ColorAttr{colGreen, AttrUndefined},
+		Bar:          ColorAttr{colBlue, AttrUndefined},
 		Info:         ColorAttr{colWhite, AttrUndefined},
 		Cursor:       ColorAttr{colRed, AttrUndefined},
 		Selected:     ColorAttr{colMagenta, AttrUndefined},
 		Header:       ColorAttr{colCyan, AttrUndefined},
-		Border:       ColorAttr{colBlack, AttrUndefined}}
+		Border:       ColorAttr{colBlack, AttrUndefined},
+		BorderLabel:  ColorAttr{colUndefined, AttrUndefined},
+		Scrollbar:    ColorAttr{colUndefined, AttrUndefined}}
 	Dark256 = &ColorTheme{
 		Colored:      true,
 		Input:        ColorAttr{colDefault, AttrUndefined},
@@ -527,12 +1069,16 @@ func init() {
 		Match:        ColorAttr{108, AttrUndefined},
 		Current:      ColorAttr{254, AttrUndefined},
 		CurrentMatch: ColorAttr{151, AttrUndefined},
+		TermMatch:    undefinedMatchColors(),
 		Spinner:      ColorAttr{148, AttrUndefined},
+		Bar:          ColorAttr{74, AttrUndefined},
 		Info:         ColorAttr{144, AttrUndefined},
 		Cursor:       ColorAttr{161, AttrUndefined},
 		Selected:     ColorAttr{168, AttrUndefined},
 		Header:       ColorAttr{109, AttrUndefined},
-		Border:       ColorAttr{59, AttrUndefined}}
+		Border:       ColorAttr{59, AttrUndefined},
+		BorderLabel:  ColorAttr{colUndefined, AttrUndefined},
+		Scrollbar:    ColorAttr{colUndefined, AttrUndefined}}
 	Light256 = &ColorTheme{
 		Colored:      true,
 		Input:        ColorAttr{colDefault, AttrUndefined},
@@ -547,15 +1093,19 @@ func init() {
 		Match:        ColorAttr{66, AttrUndefined},
 		Current:      ColorAttr{237, AttrUndefined},
 		CurrentMatch: ColorAttr{23, AttrUndefined},
+		TermMatch:    undefinedMatchColors(),
 		Spinner:      ColorAttr{65, AttrUndefined},
+		Bar:          ColorAttr{67, AttrUndefined},
 		Info:         ColorAttr{101, AttrUndefined},
 		Cursor:       ColorAttr{161, AttrUndefined},
 		Selected:     ColorAttr{168, AttrUndefined},
 		Header:       ColorAttr{31, AttrUndefined},
-		Border:       ColorAttr{145, AttrUndefined}}
+		Border:       ColorAttr{145, AttrUndefined},
+		BorderLabel:  ColorAttr{colUndefined, AttrUndefined},
+		Scrollbar:    ColorAttr{colUndefined, AttrUndefined}}
 }
 
-func initTheme(theme *ColorTheme, baseTheme *ColorTheme, forceBlack bool) {
+func initTheme(theme *ColorTheme, baseTheme *ColorTheme, forceBlack bool, palette *Palette) {
 	if forceBlack {
 		theme.Bg = ColorAttr{colBlack, AttrUndefined}
 	}
@@ -577,17 +1127,40 @@ func initTheme(theme *ColorTheme, baseTheme *ColorTheme, forceBlack bool) {
 	theme.PreviewFg = o(theme.Fg, o(baseTheme.PreviewFg, theme.PreviewFg))
 	theme.PreviewBg = o(theme.Bg, o(baseTheme.PreviewBg, theme.PreviewBg))
 	theme.DarkBg = o(baseTheme.DarkBg, theme.DarkBg)
-	theme.Gutter = o(theme.DarkBg, o(baseTheme.Gutter, theme.Gutter))
+	gutter := o(baseTheme.Gutter, theme.Gutter)
+	if gutter.Color == colUndefined && palette != nil && theme.DarkBg.Color.isBase16() {
+		// Derive a slightly darker shade from the actual color of the terminal
+		gutter.Color = theme.DarkBg.Color.Darken(gutterDarkenRatio, palette)
+	}
+	theme.Gutter = o(theme.DarkBg, gutter)
 	theme.Prompt = o(baseTheme.Prompt, theme.Prompt)
 	theme.Match = o(baseTheme.Match, theme.Match)
 	theme.Current = o(baseTheme.Current, theme.Current)
 	theme.CurrentMatch = o(baseTheme.CurrentMatch, theme.CurrentMatch)
+	for idx := range theme.TermMatch {
+		theme.TermMatch[idx] = o(baseTheme.TermMatch[idx], theme.TermMatch[idx])
+	}
 	theme.Spinner = o(baseTheme.Spinner, theme.Spinner)
+	theme.Bar = o(baseTheme.Bar, theme.Bar)
 	theme.Info = o(baseTheme.Info, theme.Info)
 	theme.Cursor = o(baseTheme.Cursor, theme.Cursor)
 	theme.Selected = o(baseTheme.Selected, theme.Selected)
 	theme.Header = o(baseTheme.Header, theme.Header)
 	theme.Border = o(baseTheme.Border, theme.Border)
+	theme.BorderLabel = o(theme.Fg, o(baseTheme.BorderLabel, theme.BorderLabel))
+	theme.Scrollbar = o(theme.Border, o(baseTheme.Scrollbar, theme.Scrollbar))
+
+	if theme.Blends != nil {
+		if palette == nil {
+			palette = &xtermPalette
+		}
+		for _, blend := range *theme.Blends {
+			base := theme.resolve(blend.Base)
+			if blended, ok := base.blend(theme.resolve(blend.Other), blend.Ratio, palette); ok {
+				theme.Element(blend.Element).Color = blended
+			}
+		}
+	}
 
 	initPalette(theme)
 }
@@ -612,14 +1185,113 @@ func initPalette(theme *ColorTheme) {
 	ColSelected = pair(theme.Selected, theme.Gutter)
 	ColCurrent = pair(theme.Current, theme.DarkBg)
 	ColCurrentMatch = pair(theme.CurrentMatch, theme.DarkBg)
+	initTermMatchPalette(theme, pair)
 	ColCurrentCursor = pair(theme.Cursor, theme.DarkBg)
 	ColCurrentCursorEmpty = pair(blank, theme.DarkBg)
 	ColCurrentSelected = pair(theme.Selected, theme.DarkBg)
 	ColCurrentSelectedEmpty = pair(blank, theme.DarkBg)
 	ColSpinner = pair(theme.Spinner, theme.Bg)
+	ColBar = pair(theme.Bar, theme.Bg)
+	ColCurrentBar = pair(theme.Bar, theme.DarkBg)
 	ColInfo = pair(theme.Info, theme.Bg)
 	ColHeader = pair(theme.Header, theme.Bg)
 	ColBorder = pair(theme.Border, theme.Bg)
 	ColPreview = pair(theme.PreviewFg, theme.PreviewBg)
 	ColPreviewBorder = pair(theme.Border, theme.PreviewBg)
+
+	ColBorderLabel = pair(theme.BorderLabel, theme.Bg)
+	ColPreviewLabel = pair(theme.BorderLabel, theme.PreviewBg)
+	ColScrollbar = pair(theme.Scrollbar, theme.Bg)
+	ColPreviewScrollbar = pair(theme.Scrollbar, theme.PreviewBg)
+}
+
+// undefinedMatchColors returns the palette of the colors of the term matches
+// none of which is defined
+func undefinedMatchColors() [MatchColors]ColorAttr {
+	var colors [MatchColors]ColorAttr
+	for idx := range colors {
+		colors[idx] = ColorAttr{colUndefined, AttrUndefined}
+	}
+	return colors
+}
+
+// initTermMatchPalette sets up the colors of the matches of the terms up to
+// the last one defined. The undefined attributes are taken from hl and hl+.
+// The palette is empty if none is defined, so that all the matches are
+// printed in the same colors.
+func initTermMatchPalette(theme *ColorTheme, pair func(ColorAttr, ColorAttr) ColorPair) {
+	ColTermMatches, ColCurrentTermMatches = nil, nil
+	count := 0
+	for idx, attr := range theme.TermMatch {
+		if attr.Color != colUndefined || attr.Attr != AttrUndefined {
+			count = idx + 1
+		}
+	}
+	merge := func(base ColorAttr, attr ColorAttr) ColorAttr {
+		if attr.Color != colUndefined {
+			base.Color = attr.Color
+		}
+		if attr.Attr != AttrUndefined {
+			base.Attr = attr.Attr
+		}
+		return base
+	}
+	for _, attr := range theme.TermMatch[:count] {
+		ColTermMatches = append(ColTermMatches, pair(merge(theme.Match, attr), theme.Bg))
+		ColCurrentTermMatches = append(ColCurrentTermMatches, pair(merge(theme.CurrentMatch, attr), theme.DarkBg))
+	}
+}
+
+// shadowStyle is how the renderer draws the shadows of the windows
+type shadowStyle struct {
+	color ColorPair
+	shade bool // Drawn with the shade character of the window
+}
+
+// makeShadowStyle derives the style of the shadow from the theme. The shadow
+// is a darker shade of the background color, or drawn with the shade
+// characters if the RGB value of the background color is unknown.
+func makeShadowStyle(theme *ColorTheme) shadowStyle {
+	if _, _, _, ok := theme.Bg.Color.RGB(nil); ok {
+		shadow := theme.Bg.Color.Darken(shadowDarkenRatio, nil)
+		return shadowStyle{ColorPair{shadow, shadow, AttrRegular}, false}
+	}
+	return shadowStyle{ColorPair{theme.Border.Color, theme.Bg.Color, theme.Border.Attr}, true}
+}
+
+// text returns a row of the shadow of the given width
+func (s shadowStyle) text(width int, shade rune) string {
+	if !s.shade {
+		shade = ' '
+	}
+	return strings.Repeat(string(shade), width)
+}
+
+// shadowCaster is the window whose shadow is drawn by the renderer
+type shadowCaster interface {
+	Window
+	shadowShade() rune
+}
+
+// drawShadows is the compositing pass of RefreshWindows. The shadow of each
+// window set by SetShadow is drawn over the other windows on the right and at
+// the bottom of the window, offset by one cell.
+func drawShadows(r Renderer, windows []Window, style shadowStyle) {
+	noBorder := MakeBorderStyle(BorderNone, false)
+	for _, w := range windows {
+		caster, ok := w.(shadowCaster)
+		if !ok || caster.shadowShade() == 0 {
+			continue
+		}
+		top, left, width, height := w.Top(), w.Left(), w.Width(), w.Height()
+		for _, region := range []Region{
+			{Top: top + 1, Left: left + width, Width: 1, Height: height},
+			{Top: top + height, Left: left + 1, Width: width - 1, Height: 1}} {
+			shadow := r.NewWindow(region.Top, region.Left, region.Width, region.Height, false, noBorder)
+			for y := 0; y < region.Height; y++ {
+				shadow.Move(y, 0)
+				shadow.CPrint(style.color, style.text(region.Width, caster.shadowShade()))
+			}
+		}
+	}
 }
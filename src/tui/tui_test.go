@@ -1,6 +1,12 @@
 package tui
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestHexToColor(t *testing.T) {
 	assert := func(expr string, r, g, b int) {
@@ -18,3 +24,454 @@ func TestHexToColor(t *testing.T) {
 	assert("#102030", 16, 32, 48)
 	assert("#ffffff", 255, 255, 255)
 }
+
+func TestColorRGB(t *testing.T) {
+	palette := Palette{}
+	palette[colRed] = HexToColor("#cc0000")
+	check := func(color Color, palette *Palette, er, eg, eb int, eok bool) {
+		r, g, b, ok := color.RGB(palette)
+		if r != er || g != eg || b != eb || ok != eok {
+			t.Errorf("%d: %d %d %d %v", color, r, g, b, ok)
+		}
+	}
+	check(HexToColor("#123456"), nil, 0x12, 0x34, 0x56, true)
+	check(colRed, nil, 0, 0, 0, false)
+	check(colRed, &palette, 0xcc, 0, 0, true)
+	check(16, nil, 0, 0, 0, true)
+	check(196, nil, 255, 0, 0, true)
+	check(67, nil, 95, 135, 175, true)
+	check(244, nil, 128, 128, 128, true)
+	check(colDefault, &palette, 0, 0, 0, false)
+
+	if darkened := colRed.Darken(0.5, &palette); darkened != HexToColor("#660000") {
+		t.Errorf("%x", darkened)
+	}
+	if darkened := colRed.Darken(0.5, nil); darkened != colRed {
+		t.Errorf("%x", darkened)
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	input := "a"
+	for i := 0; i < 16; i++ {
+		input += fmt.Sprintf("\x1b]4;%d;rgb:%02x%02x/0000/ffff\x1b\\", i, i, i)
+	}
+	palette, rest := parsePalette([]byte(input + "b\x1b]4;1;rgb:ff/ff/ff\x07"))
+	if palette == nil || string(rest) != "ab" {
+		t.Fatalf("%v, %q", palette, rest)
+	}
+	if palette[colBlue] != HexToColor("#0400ff") {
+		t.Errorf("%x", palette[colBlue])
+	}
+
+	palette, rest = parsePalette([]byte("\x1b]4;0;rgb:00/00/00\x07x"))
+	if palette != nil || string(rest) != "x" {
+		t.Errorf("%v, %q", palette, rest)
+	}
+}
+
+func TestColorBlend(t *testing.T) {
+	if blended := HexToColor("#ff8000").Blend(colBlack, 0.25); blended != HexToColor("#bf6000") {
+		t.Errorf("%x", blended)
+	}
+	if blended := colDefault.Blend(colBlack, 0.25); blended != colDefault {
+		t.Errorf("%x", blended)
+	}
+
+	theme := EmptyTheme()
+	theme.Bg = ColorAttr{HexToColor("#ffffff"), AttrUndefined}
+	theme.Blends = &[]ColorBlend{
+		{"preview-bg", ColorRef{Element: "bg"}, ColorRef{Color: colBlack}, 0.5},
+		{"bg+", ColorRef{Element: "fg"}, ColorRef{Color: colBlack}, 0.5}}
+	initTheme(theme, Dark256, false, nil)
+	if theme.PreviewBg.Color != HexToColor("#808080") {
+		t.Errorf("%x", theme.PreviewBg.Color)
+	}
+	// The default foreground color cannot be blended
+	if theme.DarkBg.Color != Dark256.DarkBg.Color {
+		t.Errorf("%x", theme.DarkBg.Color)
+	}
+}
+
+func TestMakeCustomBorderStyle(t *testing.T) {
+	style := MakeCustomBorderStyle(BorderSides{LineDouble, LineRounded, LineNone, LineBold}, true)
+	if style.top != '═' || style.right != '│' || style.left != '┃' ||
+		style.topLeft != '╔' || style.topRight != '╕' {
+		t.Errorf("%q", []rune{style.top, style.right, style.left, style.topLeft, style.topRight})
+	}
+
+	style = MakeCustomBorderStyle(BorderSides{LineRounded, LineSharp, LineBold, LineRounded}, true)
+	if style.topLeft != '╭' || style.topRight != '╮' || style.bottomLeft != '┕' || style.bottomRight != '┙' {
+		t.Errorf("%q", []rune{style.topLeft, style.topRight, style.bottomLeft, style.bottomRight})
+	}
+
+	style = MakeBorderStyle(BorderDashed, true)
+	if style.top != '╌' || style.left != '╎' || style.topLeft != '┌' || style.bottomRight != '┘' {
+		t.Errorf("%q", []rune{style.top, style.left, style.topLeft, style.bottomRight})
+	}
+	style = MakeBorderStyle(BorderHeavyDashed, true)
+	if style.bottom != '╍' || style.right != '╏' || style.topRight != '┓' || style.bottomLeft != '┗' {
+		t.Errorf("%q", []rune{style.bottom, style.right, style.topRight, style.bottomLeft})
+	}
+
+	if MakeBorderStyle(BorderSharp, true) != makeBorderStyle(BorderSharp, BorderSides{LineSharp, LineSharp, LineSharp, LineSharp}, true) {
+		t.Error("sharp border should be made of sharp lines")
+	}
+}
+
+func TestBorderLineGlyphs(t *testing.T) {
+	for line, glyphs := range map[BorderLine]string{
+		LineNone:        "",
+		LineSharp:       "─│┌",
+		LineRounded:     "╭╮╰╯",
+		LineBold:        "━┃┏",
+		LineDouble:      "═║╔",
+		LineDashed:      "╌╎┌",
+		LineHeavyDashed: "╍╏┏"} {
+		if line.Glyphs() != glyphs {
+			t.Errorf("%d: %q", line, line.Glyphs())
+		}
+	}
+}
+
+func TestBorderStyleWithChars(t *testing.T) {
+	style := MakeBorderStyle(BorderRounded, true).WithChars([]rune("12345678"))
+	if style.topLeft != '1' || style.top != '2' || style.topRight != '3' || style.right != '4' ||
+		style.bottomRight != '5' || style.bottom != '6' || style.bottomLeft != '7' || style.left != '8' {
+		t.Errorf("%v", style)
+	}
+	if style.sides != BorderRounded.Sides() {
+		t.Errorf("%v", style.sides)
+	}
+}
+
+func TestShadowStyle(t *testing.T) {
+	theme := *Dark256
+	theme.Bg = ColorAttr{HexToColor("#505050"), AttrUndefined}
+	style := makeShadowStyle(&theme)
+	if text := style.text(3, '▚'); text != "   " || style.color.Bg() != HexToColor("#303030") {
+		t.Errorf("%q, %x", text, style.color.Bg())
+	}
+
+	// Shade characters when the RGB value of the background is unknown
+	style = makeShadowStyle(Dark256)
+	if text := style.text(3, '▚'); text != "▚▚▚" {
+		t.Errorf("%q", text)
+	}
+	if text := style.text(2, ':'); text != "::" {
+		t.Errorf("%q", text)
+	}
+}
+
+func TestDrawShadows(t *testing.T) {
+	r := NewVirtualRenderer(5, 4)
+	r.Init()
+	w := r.NewWindow(0, 0, 3, 2, false, MakeBorderStyle(BorderNone, false))
+	w.SetShadow(':')
+	r.RefreshWindows([]Window{w})
+	<-r.Updated()
+	if text := r.String(); text != "\n   :\n :::\n" {
+		t.Errorf("%q", text)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	r := LightRenderer{height: 2, screen: &lightScreen{}}
+	r.PassThrough("foo\nbar\nbaz\n")
+	if r.queued != "\r\x1b7foo\r\nbar\x1b8" {
+		t.Errorf("%q", r.queued)
+	}
+}
+
+func TestWrapSequence(t *testing.T) {
+	osc := "\x1b]52;c;Zm9v\x1b\\"
+	if wrapped := wrapSequence(muxNone, osc); wrapped != osc {
+		t.Errorf("%q", wrapped)
+	}
+	if wrapped := wrapSequence(muxTmux, osc); wrapped != "\x1bPtmux;\x1b\x1b]52;c;Zm9v\x1b\x1b\\\x1b\\" {
+		t.Errorf("%q", wrapped)
+	}
+	if wrapped := wrapSequence(muxScreen, osc); wrapped != "\x1bP\x1b]52;c;Zm9v\a\x1b\\" {
+		t.Errorf("%q", wrapped)
+	}
+	long := "\x1b]52;c;" + strings.Repeat("A", screenChunkSize) + "\a"
+	if wrapped := wrapSequence(muxScreen, long); wrapped != "\x1bP"+long[:screenChunkSize]+"\x1b\\\x1bP"+long[screenChunkSize:]+"\x1b\\" {
+		t.Errorf("%q", wrapped)
+	}
+	// Only OSC sequences can be passed through screen
+	apc := "\x1b_Ga=d,i=1\x1b\\"
+	if wrapped := wrapSequence(muxScreen, apc); wrapped != apc {
+		t.Errorf("%q", wrapped)
+	}
+
+	r := LightRenderer{mux: muxScreen}
+	if link := r.hyperlink(";"); link != "\x1bP\x1b]8;;\a\x1b\\" {
+		t.Errorf("%q", link)
+	}
+	r.mux = muxTmux
+	if link := r.hyperlink(";"); link != "\x1b]8;;\x1b\\" {
+		t.Errorf("%q", link)
+	}
+}
+
+func TestKittyTransmit(t *testing.T) {
+	os.Unsetenv("TMUX")
+	os.Unsetenv("STY")
+	data := "\x1b_Ga=T,f=100,i=7,q=1,m=1;AAAA\x1b\\\x1b_Gm=0;BBBB\x1b\\"
+	expected := "\x1b_Ga=T,f=100,m=1,i=42,q=2;AAAA\x1b\\\x1b_Gm=0,q=2;BBBB\x1b\\"
+	if actual := kittyTransmit(data, 42); actual != expected {
+		t.Errorf("%q != %q", actual, expected)
+	}
+
+	os.Setenv("TMUX", "/tmp/tmux-1000/default,1,0")
+	defer os.Unsetenv("TMUX")
+	expected = "\x1bPtmux;\x1b\x1b_Ga=d,d=I,i=42,q=2\x1b\x1b\\\x1b\\"
+	if actual := kittyDelete(42); actual != expected {
+		t.Errorf("%q != %q", actual, expected)
+	}
+}
+
+func TestITerm2Transmit(t *testing.T) {
+	os.Unsetenv("TMUX")
+	os.Unsetenv("STY")
+	data := "\x1b]1337;File=name=Zm9v;inline=1;width=100%;preserveAspectRatio=0:AAAA\a"
+	expected := "\x1b]1337;File=name=Zm9v;inline=1;width=40;height=10;preserveAspectRatio=1:AAAA\a"
+	if actual := iterm2Transmit(data, 40, 10); actual != expected {
+		t.Errorf("%q != %q", actual, expected)
+	}
+}
+
+func TestFitImage(t *testing.T) {
+	for _, test := range [][6]int{
+		{80, 40, 40, 30, 40, 20},
+		{20, 40, 40, 10, 5, 10},
+		{100, 1, 10, 10, 10, 1},
+		{10, 10, 0, 10, 0, 0},
+	} {
+		if cols, rows := fitImage(test[0], test[1], test[2], test[3]); cols != test[4] || rows != test[5] {
+			t.Errorf("%v: %d, %d", test, cols, rows)
+		}
+	}
+}
+
+func TestKittyKey(t *testing.T) {
+	r := LightRenderer{buffer: []byte("\x1b[105;5u\x1b[112;6ux\x1b[44;5u\x1b[107;9u\x1b[97;3u\x1b[27u\x1b[9;2u\x1b[99:67;5:1u\x1b[A")}
+	for _, expected := range []Event{
+		CtrlKey('i'), CtrlShiftKey('p'), Key('x'), CtrlKey(','), SuperKey('k'), AltKey('a'),
+		ESC.AsEvent(), BTab.AsEvent(), CtrlC.AsEvent(), Up.AsEvent()} {
+		if event := r.GetChar(); event != expected {
+			t.Errorf("%v != %v", event, expected)
+		}
+	}
+	if len(r.buffer) > 0 {
+		t.Errorf("%q", r.buffer)
+	}
+
+	for event, expected := range map[Event]EventType{
+		CtrlKey('i'):      Tab,
+		CtrlKey('m'):      CtrlM,
+		CtrlShiftKey('p'): CtrlP,
+	} {
+		if legacy, ok := event.Legacy(); !ok || legacy != expected.AsEvent() {
+			t.Errorf("%v: %v", event, legacy)
+		}
+	}
+	if _, ok := CtrlKey(',').Legacy(); ok {
+		t.Error("ctrl-, should not have the legacy key")
+	}
+}
+
+func TestPasteSequence(t *testing.T) {
+	r := LightRenderer{buffer: []byte("\x1b[200~foo\nbar\x1b[201~x\x1b[201~y")}
+	if event := r.GetChar(); event.Type != Paste || r.Pasted() != "foo\nbar" {
+		t.Errorf("%v: %q", event, r.Pasted())
+	}
+	// The stray end of the paste is discarded
+	for _, expected := range []Event{Key('x'), Key('y')} {
+		if event := r.GetChar(); event != expected {
+			t.Errorf("%v != %v", event, expected)
+		}
+	}
+
+	// The paste is read in parts without waiting for the end
+	r = LightRenderer{buffer: []byte("\x1b[200~foo\x1b[2")}
+	if event := r.GetChar(); event.Type != Invalid || !r.pasting {
+		t.Errorf("%v", event)
+	}
+	for _, part := range []string{"0", "2~bar\x1b[20", "1~z"} {
+		r.buffer = []byte(part)
+		r.GetChar()
+	}
+	if r.pasting || r.Pasted() != "foo\x1b[202~bar" {
+		t.Errorf("%q", r.Pasted())
+	}
+	if event := r.GetChar(); event != Key('z') {
+		t.Errorf("%v", event)
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	for _, test := range []struct {
+		line     string
+		width    int
+		expected string
+	}{
+		{"abcdef", 3, "abc"},
+		{"\x1b[31mab\x1b[m日本", 3, "\x1b[31mab\x1b[m"},
+		{"日a\x1b[1mbc\x1b[m", 3, "日a\x1b[1m\x1b[m"},
+		{"a\x1b_Gf=100;AAAA\x1b\\bc", 2, "a\x1b_Gf=100;AAAA\x1b\\b"},
+		{"ab\x1bPq#0;2;0;0;0#0~~\x1b\\", 1, "a\x1bPq#0;2;0;0;0#0~~\x1b\\"},
+	} {
+		if clipped := clipLine(test.line, test.width); clipped != test.expected {
+			t.Errorf("%q: %q != %q", test.line, clipped, test.expected)
+		}
+	}
+}
+
+func TestFocusSequence(t *testing.T) {
+	r := LightRenderer{buffer: []byte("\x1b[Ix\x1b[O")}
+	for _, expected := range []Event{FocusGained.AsEvent(), Key('x'), FocusLost.AsEvent()} {
+		if event := r.GetChar(); event != expected {
+			t.Errorf("%v != %v", event, expected)
+		}
+	}
+}
+
+func TestMouseClicks(t *testing.T) {
+	click := "\x1b[M\x20\x25\x25\x1b[M\x23\x25\x25"
+	r := LightRenderer{buffer: []byte(strings.Repeat(click, 4)), mouse: true, doubleClick: DefaultClickInterval}
+	for i, expected := range [][2]bool{
+		{false, false}, {false, false}, {false, false}, {true, false},
+		{false, false}, {false, true}, {false, false}, {true, false}} {
+		event := r.GetChar()
+		if event.Type != Mouse || event.MouseEvent.Y != 4 || event.MouseEvent.X != 4 {
+			t.Fatalf("#%d: %v", i, event)
+		}
+		if me := event.MouseEvent; me.Down != (i%2 == 0) || me.Double != expected[0] || me.Triple != expected[1] {
+			t.Errorf("#%d: %v", i, me)
+		}
+	}
+}
+
+func TestLightScreen(t *testing.T) {
+	r := LightRenderer{theme: Default16, width: 10, height: 2, screen: &lightScreen{}}
+	r.resizeScreen()
+	r.screen.clear()
+	w := r.NewWindow(0, 0, 10, 2, false, MakeBorderStyle(BorderNone, false))
+	render := func(text string, expected string) {
+		r.queued = ""
+		w.Move(0, 0)
+		w.Print(text)
+		r.render()
+		if r.queued != expected {
+			t.Errorf("%s: %q", text, r.queued)
+		}
+	}
+	render("foo", "\x1b[mfoo")
+	// Only the changed cells are printed
+	render("fox", "\r\x1b[2C\x1b[mx")
+	render("fox", "")
+	// Unchanged cells in between are printed again instead of moving the cursor
+	render("bob", "\r\x1b[mbob")
+	// Wide characters
+	render("한o", "\r\x1b[m한o")
+	render("a한", "\r\x1b[ma한")
+
+	// Reserved cells are not printed
+	r.Reserve(&Region{Top: 0, Left: 1, Width: 2, Height: 1})
+	render("xyzw", "\r\x1b[mx\r\x1b[3Cw")
+	r.Reserve(nil)
+	render("xyzw", "\r\x1b[1C\x1b[myz\r\x1b[4C")
+}
+
+func TestLightScreenBackground(t *testing.T) {
+	r := LightRenderer{theme: Default16, width: 5, height: 1, screen: &lightScreen{}}
+	r.resizeScreen()
+	r.screen.clear()
+	w := r.NewWindow(0, 0, 5, 1, false, MakeBorderStyle(BorderNone, false))
+	render := func(text string, expected string) {
+		r.queued = ""
+		w.Move(0, 0)
+		w.Print(text)
+		r.render()
+		if r.queued != expected {
+			t.Errorf("%s: %q", text, r.queued)
+		}
+	}
+	r.Background("#####")
+	// The background is visible through the blank cells
+	render("fo   ", "\x1b[mfo###")
+	render("fox  ", "\r\x1b[2C\x1b[mx\r\x1b[5C")
+	// Only the cells printed over it are drawn again when they become blank
+	render("f    ", "\r\x1b[1C\x1b[m##\r\x1b[5C")
+
+	// The lines are clipped to the width, and the styles are kept
+	r.Background("\x1b[31m#\x1b[1m#\x1b[0;32m#\x1b]8;;x\x1b\\####\n#####")
+	render("f    ", "\r\x1b[1C\x1b[;31;1m#\x1b[;32m###\x1b[m")
+	// Half of a wide character is not drawn when the other half is covered
+	r.Background("a한한")
+	render("    x", "\r\x1b[ma한 x")
+}
+
+func TestVirtualRenderer(t *testing.T) {
+	r := NewVirtualRenderer(10, 3)
+	r.Init()
+	w := r.NewWindow(0, 0, 10, 3, false, MakeBorderStyle(BorderRounded, true))
+	w.Move(1, 1)
+	w.CPrint(ColNormal, "한글abcdefgh")
+	if r.String() != "" {
+		t.Errorf("Should not be displayed before refresh: %q", r.String())
+	}
+	r.RefreshWindows(nil)
+	<-r.Updated()
+	if text := r.String(); text != "╭────────╮\n│한글abcde\n╰────────╯" {
+		t.Errorf("%q", text)
+	}
+	if y, x := r.Cursor(); y != 1 || x != 1 {
+		t.Errorf("%d, %d", y, x)
+	}
+	if cells := r.Cells(); cells[1][1].Text != "한" || cells[1][2].Text != "" || cells[1][1].Color != ColNormal {
+		t.Errorf("%v", cells[1])
+	}
+
+	r.SendEvent(Event{Type: Rune, Char: 'a'})
+	if event := r.GetChar(); event.Type != Rune || event.Char != 'a' {
+		t.Errorf("%v", event)
+	}
+	r.SetSize(20, 5)
+	if event := r.GetChar(); event.Type != Resize {
+		t.Errorf("%v", event)
+	}
+	r.Refresh()
+	if r.MaxX() != 20 || r.MaxY() != 5 {
+		t.Errorf("%d, %d", r.MaxX(), r.MaxY())
+	}
+
+	r.Record(true)
+	w = r.NewWindow(1, 2, 10, 3, false, MakeBorderStyle(BorderNone, true))
+	w.Move(1, 3)
+	w.Print("foo")
+	r.RefreshWindows(nil)
+	r.Record(false)
+	w.Print("bar")
+	if calls := r.Calls(); !reflect.DeepEqual(calls, []string{`Print(2, 5) "foo"`, `RefreshWindows(0, 0)`}) {
+		t.Errorf("%q", calls)
+	}
+	if calls := r.Calls(); len(calls) > 0 {
+		t.Errorf("%q", calls)
+	}
+}
+
+func TestVirtualRendererANSI(t *testing.T) {
+	r := NewVirtualRenderer(10, 2)
+	r.Init()
+	w := r.NewWindow(0, 0, 10, 2, false, MakeBorderStyle(BorderNone, true))
+	w.CPrint(NewColorPair(colRed, colDefault, Bold), "ab")
+	w.CPrint(NewColorPair(colDefault, colDefault, AttrRegular), "c")
+	w.Move(1, 1)
+	w.CPrint(NewColorPair(Color(200), colBlue, AttrRegular), "d ")
+	r.RefreshWindows(nil)
+	if ansi := r.ANSI(); ansi != "\x1b[0;1;31mab\x1b[mc\n \x1b[0;38;5;200;44md \x1b[m" {
+		t.Errorf("%q", ansi)
+	}
+}
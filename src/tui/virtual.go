@@ -0,0 +1,555 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// VirtualCell is a cell on the screen of VirtualRenderer
+type VirtualCell struct {
+	Text  string // Empty for the right half of a wide character
+	Color ColorPair
+}
+
+// VirtualRenderer is a Renderer that draws on the cells in memory instead of
+// the terminal, so that other programs can display fzf in their own layout.
+// The input events are given with SendEvent, and the screen is read with
+// Cells after each update notified through Updated. It can also record the
+// draw calls for testing.
+type VirtualRenderer struct {
+	mutex      sync.Mutex
+	theme      *ColorTheme
+	forceBlack bool
+	size       [2]int
+	width      int
+	height     int
+	cells      [][]VirtualCell
+	frame      [][]VirtualCell
+	pos        [2]int
+	cursor     [2]int
+	events     chan Event
+	pending    *Event // Read ahead by WaitInput
+	updated    chan bool
+	recording  bool
+	calls      []string
+	pasted     []string
+	shadow     shadowStyle
+}
+
+// VirtualWindow is a Window of VirtualRenderer
+type VirtualWindow struct {
+	renderer *VirtualRenderer
+	preview  bool
+	border   BorderStyle
+	labels   []BorderLabel
+	top      int
+	left     int
+	width    int
+	height   int
+	posx     int
+	posy     int
+	fg       Color
+	bg       Color
+	shadow   rune
+}
+
+// NewVirtualRenderer creates a renderer on the screen of the given size
+func NewVirtualRenderer(width int, height int) *VirtualRenderer {
+	return &VirtualRenderer{
+		theme:   EmptyTheme(),
+		size:    [2]int{width, height},
+		events:  make(chan Event, 64),
+		updated: make(chan bool, 1)}
+}
+
+// SetTheme sets the color theme to use. It should be called before the
+// renderer is initialized.
+func (r *VirtualRenderer) SetTheme(theme *ColorTheme, forceBlack bool) {
+	r.theme = theme
+	r.forceBlack = forceBlack
+}
+
+// SendEvent injects the event as if it were given from the terminal
+func (r *VirtualRenderer) SendEvent(event Event) {
+	r.events <- event
+}
+
+// SetSize changes the size of the screen and notifies it with Resize event
+func (r *VirtualRenderer) SetSize(width int, height int) {
+	r.mutex.Lock()
+	r.size = [2]int{width, height}
+	r.mutex.Unlock()
+	r.SendEvent(Event{Resize, 0, nil})
+}
+
+// Updated returns the channel notified when the screen is updated
+func (r *VirtualRenderer) Updated() <-chan bool {
+	return r.updated
+}
+
+// Record starts or stops recording the draw calls
+func (r *VirtualRenderer) Record(enabled bool) {
+	r.mutex.Lock()
+	r.recording = enabled
+	r.mutex.Unlock()
+}
+
+// Calls returns the draw calls recorded so far and clears them. Each call is
+// formatted as NAME(Y, X) with the position on the screen, followed by the
+// text printed.
+func (r *VirtualRenderer) Calls() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	calls := r.calls
+	r.calls = nil
+	return calls
+}
+
+func (r *VirtualRenderer) record(name string, y int, x int, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.recording {
+		return
+	}
+	call := fmt.Sprintf("%s(%d, %d)", name, y, x)
+	for _, arg := range args {
+		call += fmt.Sprintf(" %q", arg)
+	}
+	r.calls = append(r.calls, call)
+}
+
+// Cells returns a copy of the cells on the screen
+func (r *VirtualRenderer) Cells() [][]VirtualCell {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return copyCells(r.frame)
+}
+
+// Cursor returns the position of the cursor on the screen
+func (r *VirtualRenderer) Cursor() (int, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cursor[0], r.cursor[1]
+}
+
+// String returns the text on the screen without the trailing spaces
+func (r *VirtualRenderer) String() string {
+	lines := []string{}
+	for _, row := range r.Cells() {
+		var line strings.Builder
+		for _, cell := range row {
+			line.WriteString(cell.Text)
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ANSI returns the screen with the colors and the attributes of the cells
+// as ANSI escape sequences, without the trailing blank cells
+func (r *VirtualRenderer) ANSI() string {
+	lines := []string{}
+	blank := r.blank()
+	for _, row := range r.Cells() {
+		end := len(row)
+		for end > 0 && row[end-1] == blank {
+			end--
+		}
+		var line strings.Builder
+		color := blank.Color
+		for _, cell := range row[:end] {
+			if cell.Color != color {
+				color = cell.Color
+				codes := append(attrCodes(color.attr), colorCodes(color.fg, color.bg)...)
+				if len(codes) > 0 {
+					line.WriteString("\x1b[0;" + strings.Join(codes, ";") + "m")
+				} else {
+					line.WriteString("\x1b[m")
+				}
+			}
+			line.WriteString(cell.Text)
+		}
+		if color != blank.Color {
+			line.WriteString("\x1b[m")
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func copyCells(cells [][]VirtualCell) [][]VirtualCell {
+	copied := make([][]VirtualCell, len(cells))
+	for y, row := range cells {
+		copied[y] = append([]VirtualCell{}, row...)
+	}
+	return copied
+}
+
+func (r *VirtualRenderer) blank() VirtualCell {
+	return VirtualCell{" ", ColorPair{colDefault, colDefault, AttrRegular}}
+}
+
+// applySize resizes the screen to the size given by SetSize
+func (r *VirtualRenderer) applySize() {
+	r.mutex.Lock()
+	width, height := r.size[0], r.size[1]
+	r.mutex.Unlock()
+	if width == r.width && height == r.height && r.cells != nil {
+		return
+	}
+	r.width = width
+	r.height = height
+	r.Clear()
+}
+
+func (r *VirtualRenderer) Init() {
+	r.applySize()
+	initTheme(r.theme, Dark256, r.forceBlack, nil)
+	r.shadow = makeShadowStyle(r.theme)
+}
+
+func (r *VirtualRenderer) Pause(clear bool)                {}
+func (r *VirtualRenderer) Resume(clear bool, sigcont bool) {}
+
+func (r *VirtualRenderer) Clear() {
+	r.record("Clear", 0, 0)
+	r.cells = make([][]VirtualCell, r.height)
+	for y := range r.cells {
+		r.cells[y] = make([]VirtualCell, r.width)
+		for x := range r.cells[y] {
+			r.cells[y][x] = r.blank()
+		}
+	}
+}
+
+func (r *VirtualRenderer) RefreshWindows(windows []Window) {
+	r.record("RefreshWindows", 0, 0)
+	drawShadows(r, windows, r.shadow)
+	r.mutex.Lock()
+	r.frame = copyCells(r.cells)
+	r.cursor = r.pos
+	r.mutex.Unlock()
+	select {
+	case r.updated <- true:
+	default:
+	}
+}
+
+func (r *VirtualRenderer) Refresh() {
+	r.applySize()
+}
+
+// Resize returns false as the finder always takes the whole screen
+func (r *VirtualRenderer) Resize(maxHeightFunc func(int) int) bool {
+	return false
+}
+
+func (r *VirtualRenderer) Close() {}
+
+func (r *VirtualRenderer) PassThrough(str string) {}
+
+func (r *VirtualRenderer) Background(str string) {
+	r.record("Background", 0, 0, str)
+}
+
+// Emit is only recorded as the sequence prints nothing
+func (r *VirtualRenderer) Emit(sequence string) {
+	r.record("Emit", 0, 0, sequence)
+}
+
+// SendPaste queues a Paste event of the text
+func (r *VirtualRenderer) SendPaste(text string) {
+	r.mutex.Lock()
+	r.pasted = append(r.pasted, text)
+	r.mutex.Unlock()
+	r.SendEvent(Paste.AsEvent())
+}
+
+// KeyboardProtocol returns false as the scripted events are already decoded
+func (r *VirtualRenderer) KeyboardProtocol() bool {
+	return false
+}
+
+// Pasted returns the text of the Paste events in the order they were sent
+func (r *VirtualRenderer) Pasted() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.pasted) == 0 {
+		return ""
+	}
+	text := r.pasted[0]
+	r.pasted = r.pasted[1:]
+	return text
+}
+
+func (r *VirtualRenderer) Reserve(region *Region) {
+	if region == nil {
+		r.record("Reserve", 0, 0)
+	} else {
+		r.record("Reserve", region.Top, region.Left, region.Width, region.Height)
+	}
+}
+
+func (r *VirtualRenderer) DrawReserved(str string) {
+	r.record("DrawReserved", 0, 0, str)
+}
+
+// Bell is only recorded as the screen is not displayed by itself
+func (r *VirtualRenderer) Bell(style BellStyle) {
+	if style != BellNone {
+		r.record("Bell", 0, 0)
+	}
+}
+
+func (r *VirtualRenderer) BellOff(style BellStyle) {}
+
+func (r *VirtualRenderer) CanDisplay(texts []string) []bool {
+	supported := make([]bool, len(texts))
+	for idx := range supported {
+		supported[idx] = true
+	}
+	return supported
+}
+
+func (r *VirtualRenderer) MeasureWidth(text string) int {
+	return -1
+}
+
+func (r *VirtualRenderer) GetChar() Event {
+	if event := r.pending; event != nil {
+		r.pending = nil
+		return *event
+	}
+	return <-r.events
+}
+
+// WaitInput reads the next event ahead as the scripted events are not read
+// by other programs
+func (r *VirtualRenderer) WaitInput(block bool) bool {
+	if r.pending == nil {
+		select {
+		case event := <-r.events:
+			r.pending = &event
+		default:
+			if block {
+				event := <-r.events
+				r.pending = &event
+			}
+		}
+	}
+	return r.pending != nil
+}
+
+func (r *VirtualRenderer) MaxX() int {
+	return r.width
+}
+
+func (r *VirtualRenderer) MaxY() int {
+	return r.height
+}
+
+func (r *VirtualRenderer) NewWindow(top int, left int, width int, height int, preview bool, borderStyle BorderStyle) Window {
+	w := &VirtualWindow{
+		renderer: r,
+		preview:  preview,
+		border:   borderStyle,
+		top:      top,
+		left:     left,
+		width:    width,
+		height:   height,
+		fg:       r.theme.Fg.Color,
+		bg:       r.theme.Bg.Color}
+	if preview {
+		w.fg = r.theme.PreviewFg.Color
+		w.bg = r.theme.PreviewBg.Color
+	}
+	w.drawBorder()
+	return w
+}
+
+func (w *VirtualWindow) drawBorder() {
+	if w.border.shape == BorderNone {
+		return
+	}
+	drawBorder(w, w.border, w.preview, false)
+	drawBorderLabels(w, w.labels, w.preview)
+}
+
+func (w *VirtualWindow) SetShadow(shade rune) {
+	w.shadow = shade
+}
+
+func (w *VirtualWindow) shadowShade() rune {
+	return w.shadow
+}
+
+func (w *VirtualWindow) SetBorderLabels(labels []BorderLabel) {
+	w.labels = labels
+	drawBorderLabels(w, w.labels, w.preview)
+}
+
+func (w *VirtualWindow) Top() int {
+	return w.top
+}
+
+func (w *VirtualWindow) Left() int {
+	return w.left
+}
+
+func (w *VirtualWindow) Width() int {
+	return w.width
+}
+
+func (w *VirtualWindow) Height() int {
+	return w.height
+}
+
+func (w *VirtualWindow) Refresh() {}
+
+func (w *VirtualWindow) Close() {}
+
+func (w *VirtualWindow) X() int {
+	return w.posx
+}
+
+func (w *VirtualWindow) Y() int {
+	return w.posy
+}
+
+func (w *VirtualWindow) Enclose(y int, x int) bool {
+	return x >= w.left && x < (w.left+w.width) &&
+		y >= w.top && y < (w.top+w.height)
+}
+
+func (w *VirtualWindow) Move(y int, x int) {
+	w.posx = x
+	w.posy = y
+	w.renderer.pos = [2]int{w.top + y, w.left + x}
+}
+
+func (w *VirtualWindow) MoveAndClear(y int, x int) {
+	w.Move(y, x)
+	w.Print(repeat(' ', w.width-x))
+	w.Move(y, x)
+}
+
+// print writes the text on the current line within the window and moves the
+// cursor to the right
+func (w *VirtualWindow) print(text string, color ColorPair) {
+	cells := w.renderer.cells
+	y := w.top + w.posy
+	for _, r := range text {
+		if r == '\n' || r == '\r' {
+			r = ' '
+		} else if r < 32 {
+			continue
+		}
+		width := util.RuneWidth(r, 0, 8)
+		x := w.left + w.posx
+		if w.posy < 0 || w.posy >= w.height || y >= len(cells) || w.posx < 0 {
+			return
+		}
+		if width == 0 {
+			// Combine with the previous character
+			for px := x - 1; px >= w.left && px < len(cells[y]); px-- {
+				if len(cells[y][px].Text) > 0 {
+					cells[y][px].Text += string(r)
+					break
+				}
+			}
+			continue
+		}
+		if w.posx+width > w.width || x+width > len(cells[y]) {
+			return
+		}
+		cells[y][x] = VirtualCell{string(r), color}
+		if width > 1 {
+			cells[y][x+1] = VirtualCell{"", color}
+		}
+		w.posx += width
+	}
+}
+
+func (w *VirtualWindow) record(name string, args ...interface{}) {
+	w.renderer.record(name, w.top+w.posy, w.left+w.posx, args...)
+}
+
+func (w *VirtualWindow) Print(text string) {
+	w.record("Print", text)
+	w.print(text, ColorPair{colDefault, w.bg, AttrRegular})
+}
+
+func (w *VirtualWindow) CPrint(color ColorPair, text string) {
+	w.record("CPrint", text)
+	w.print(text, color)
+}
+
+func (w *VirtualWindow) fill(str string, color ColorPair) FillReturn {
+	allLines := strings.Split(str, "\n")
+	for i, line := range allLines {
+		lines := wrapLine(line, w.posx, w.width, 8)
+		for j, wl := range lines {
+			if w.posx >= w.width-1 && wl.displayWidth == 0 {
+				if w.posy < w.height-1 {
+					w.Move(w.posy+1, 0)
+				}
+				return FillNextLine
+			}
+			w.print(wl.text, color)
+
+			// Wrap line
+			if j < len(lines)-1 || i < len(allLines)-1 {
+				if w.posy+1 >= w.height {
+					return FillSuspend
+				}
+				w.MoveAndClear(w.posy, w.posx)
+				w.Move(w.posy+1, 0)
+			}
+		}
+	}
+	return FillContinue
+}
+
+func (w *VirtualWindow) Fill(text string) FillReturn {
+	w.record("Fill", text)
+	return w.fill(text, ColorPair{colDefault, w.bg, AttrRegular})
+}
+
+func (w *VirtualWindow) CFill(fg Color, bg Color, attr Attr, text string) FillReturn {
+	if fg == colDefault {
+		fg = w.fg
+	}
+	if bg == colDefault {
+		bg = w.bg
+	}
+	w.record("CFill", text)
+	return w.fill(text, ColorPair{fg, bg, attr})
+}
+
+func (w *VirtualWindow) FinishFill() {
+	w.record("FinishFill")
+	w.MoveAndClear(w.posy, w.posx)
+	for y := w.posy + 1; y < w.height; y++ {
+		w.MoveAndClear(y, 0)
+	}
+}
+
+func (w *VirtualWindow) LinkBegin(uri string, params string) {}
+
+func (w *VirtualWindow) LinkEnd() {}
+
+func (w *VirtualWindow) DrawImage(image Image) bool {
+	return false
+}
+
+func (w *VirtualWindow) Erase() {
+	w.renderer.record("Erase", w.top, w.left)
+	for y := 0; y < w.height; y++ {
+		w.MoveAndClear(y, 0)
+	}
+	w.drawBorder()
+	w.Move(0, 0)
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// Grapheme clusters longer than this are split. It is long enough for the
+// emoji ZWJ sequences in practice.
+const maxGraphemeRunes = 16
+
+// NextGrapheme returns the number of runes of the first grapheme cluster of
+// the runes and its width on screen. The width of a cluster made of a single
+// rune is the same as RuneWidth.
+func NextGrapheme(runes []rune, prefixWidth int, tabstop int) (int, int) {
+	if len(runes) == 0 {
+		return 0, 0
+	}
+	// Fast path for ASCII characters. CR-LF is not treated as a cluster as
+	// the characters are displayed separately.
+	if runes[0] < utf8.RuneSelf && (len(runes) == 1 || runes[1] < utf8.RuneSelf) {
+		return 1, RuneWidth(runes[0], prefixWidth, tabstop)
+	}
+	cluster, _, width, _ := uniseg.FirstGraphemeClusterInString(string(runes[:Min(len(runes), maxGraphemeRunes)]), -1)
+	length := utf8.RuneCountInString(cluster)
+	if length <= 1 {
+		return 1, RuneWidth(runes[0], prefixWidth, tabstop)
+	}
+	return length, emojiWidth(runes[0], width)
+}
+
+// PrevGraphemeBoundary returns the start of the grapheme cluster containing
+// the rune at the index
+func PrevGraphemeBoundary(runes []rune, idx int) int {
+	boundary := 0
+	for boundary < len(runes) {
+		length, _ := NextGrapheme(runes[boundary:], 0, 8)
+		if boundary+length > idx {
+			break
+		}
+		boundary += length
+	}
+	return boundary
+}
+
+// NextGraphemeBoundary returns the smallest index not less than the given
+// index where a grapheme cluster starts, or the length of the runes
+func NextGraphemeBoundary(runes []rune, idx int) int {
+	boundary := 0
+	for boundary < idx && boundary < len(runes) {
+		length, _ := NextGrapheme(runes[boundary:], 0, 8)
+		boundary += length
+	}
+	return boundary
+}
@@ -0,0 +1,44 @@
+package util
+
+import "testing"
+
+func TestNextGrapheme(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		length int
+		width  int
+	}{
+		{"ab", 1, 1},
+		{"\tb", 1, 8},
+		{"\r\nb", 1, 1},
+		{"e\u0301b", 2, 1},            // Combining acute accent
+		{"\ud55c\uad6d", 1, 2},        // Precomposed Hangul syllable
+		{"\u1112\u1161\u11abx", 3, 2}, // Conjoining Hangul jamo
+		{"\U0001F468\u200d\U0001F469\u200d\U0001F467x", 5, 2}, // Emoji ZWJ sequence
+		{"\U0001F1F0\U0001F1F7\U0001F1EF\U0001F1F5", 2, 2},    // Regional indicators
+		{"\U0001F44D\U0001F3FDx", 2, 2},                       // Emoji modifier
+		{"\u2764\ufe0fx", 2, 2},                               // Variation selector-16
+	} {
+		length, width := NextGrapheme([]rune(test.text), 0, 8)
+		if length != test.length || width != test.width {
+			t.Errorf("%q: expected (%d, %d), got (%d, %d)", test.text, test.length, test.width, length, width)
+		}
+	}
+	if length, width := NextGrapheme([]rune{}, 0, 8); length != 0 || width != 0 {
+		t.Errorf("(%d, %d)", length, width)
+	}
+}
+
+func TestGraphemeBoundary(t *testing.T) {
+	runes := []rune("a\U0001F468\u200d\U0001F469\u200d\U0001F467b")
+	for idx, expected := range []int{0, 1, 1, 1, 1, 1, 6, 7} {
+		if boundary := PrevGraphemeBoundary(runes, idx); boundary != expected {
+			t.Errorf("PrevGraphemeBoundary(%d): %d != %d", idx, boundary, expected)
+		}
+	}
+	for idx, expected := range []int{0, 1, 6, 6, 6, 6, 6, 7} {
+		if boundary := NextGraphemeBoundary(runes, idx); boundary != expected {
+			t.Errorf("NextGraphemeBoundary(%d): %d != %d", idx, boundary, expected)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Names of the character sets in the locales that are not recognized by
+// htmlindex as they are
+var charsetAliases = map[string]string{
+	"eucjp":     "euc-jp",
+	"ujis":      "euc-jp",
+	"euckr":     "euc-kr",
+	"euccn":     "gb2312",
+	"euctw":     "big5",
+	"big5hkscs": "big5",
+	"sjis":      "shift_jis",
+	"cp932":     "shift_jis",
+	"koi8r":     "koi8-r",
+	"koi8u":     "koi8-u",
+}
+
+// Character set of the locale when it is not UTF-8. Texts are converted
+// between the character set and UTF-8 when they are read and written. If the
+// character set is not supported, only ASCII characters are written.
+var (
+	localeCompat    bool
+	localeCharset   encoding.Encoding
+	localeMutex     sync.Mutex
+	localeEncodable = make(map[rune]bool)
+)
+
+// LocaleCharset returns the character set of the current locale given by
+// LC_ALL, LC_CTYPE, or LANG. It returns an empty string if the locale does not
+// specify the character set.
+func LocaleCharset() string {
+	locale := ""
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale = os.Getenv(name); len(locale) > 0 {
+			break
+		}
+	}
+	idx := strings.Index(locale, ".")
+	if idx < 0 {
+		return ""
+	}
+	charset := locale[idx+1:]
+	if idx := strings.Index(charset, "@"); idx >= 0 {
+		charset = charset[:idx]
+	}
+	return charset
+}
+
+// IsUTF8Charset returns true if the character set is UTF-8 or unknown
+func IsUTF8Charset(charset string) bool {
+	charset = strings.Replace(strings.ToLower(charset), "-", "", -1)
+	return len(charset) == 0 || charset == "utf8"
+}
+
+// SetLocaleCharset enables the conversion of the texts from and to the
+// character set. It returns false if the character set is not supported, in
+// which case the non-ASCII characters are written as question marks.
+func SetLocaleCharset(charset string) bool {
+	name := strings.ToLower(charset)
+	if alias, found := charsetAliases[strings.Replace(name, "-", "", -1)]; found {
+		name = alias
+	}
+	enc, err := htmlindex.Get(name)
+	localeMutex.Lock()
+	localeCompat = true
+	localeCharset = enc
+	localeEncodable = make(map[rune]bool)
+	localeMutex.Unlock()
+	return err == nil
+}
+
+// DecodeLocale converts the bytes in the character set of the locale to UTF-8
+func DecodeLocale(data []byte) []byte {
+	if localeCharset == nil || isASCII(data) {
+		return data
+	}
+	if decoded, err := localeCharset.NewDecoder().Bytes(data); err == nil {
+		return decoded
+	}
+	return data
+}
+
+// DecodeLocaleRune decodes the first character of the bytes in the character
+// set of the locale and returns it with its size in bytes
+func DecodeLocaleRune(data []byte) (rune, int) {
+	if localeCharset == nil {
+		return utf8.DecodeRune(data)
+	}
+	for size := 1; size <= Min(utf8.UTFMax, len(data)); size++ {
+		if decoded, err := localeCharset.NewDecoder().Bytes(data[:size]); err == nil {
+			if r, rsz := utf8.DecodeRune(decoded); r != utf8.RuneError && rsz == len(decoded) {
+				return r, size
+			}
+		}
+	}
+	return utf8.RuneError, 1
+}
+
+// EncodeLocale converts the UTF-8 string to the character set of the locale.
+// The characters that cannot be represented in the character set are
+// replaced with question marks.
+func EncodeLocale(str string) string {
+	if !localeCompat || isASCII([]byte(str)) {
+		return str
+	}
+	if localeCharset != nil {
+		if encoded, err := localeCharset.NewEncoder().String(str); err == nil {
+			return encoded
+		}
+	}
+	var builder strings.Builder
+	for _, r := range str {
+		if encoded, ok := encodeRune(r); ok {
+			builder.WriteString(encoded)
+		} else {
+			builder.WriteByte('?')
+		}
+	}
+	return builder.String()
+}
+
+func encodeRune(r rune) (string, bool) {
+	if r < utf8.RuneSelf {
+		return string(r), true
+	}
+	if localeCharset == nil {
+		return "", false
+	}
+	encoded, err := localeCharset.NewEncoder().String(string(r))
+	return encoded, err == nil
+}
+
+// encodableInLocale returns false if the character cannot be represented in
+// the character set of the locale and is displayed as a question mark
+func encodableInLocale(r rune) bool {
+	if !localeCompat || r < utf8.RuneSelf {
+		return true
+	}
+	localeMutex.Lock()
+	defer localeMutex.Unlock()
+	encodable, found := localeEncodable[r]
+	if !found {
+		_, encodable = encodeRune(r)
+		localeEncodable[r] = encodable
+	}
+	return encodable
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package util
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLocaleCharset(t *testing.T) {
+	envs := []string{"LC_ALL", "LC_CTYPE", "LANG"}
+	saved := make(map[string]string)
+	for _, name := range envs {
+		saved[name] = os.Getenv(name)
+	}
+	defer func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}()
+
+	for _, test := range [][4]string{
+		{"", "", "ja_JP.eucJP", "eucJP"},
+		{"", "ru_RU.KOI8-R", "en_US.UTF-8", "KOI8-R"},
+		{"de_DE.ISO-8859-1@euro", "", "en_US.UTF-8", "ISO-8859-1"},
+		{"C", "", "ja_JP.eucJP", ""},
+		{"", "", "", ""},
+	} {
+		for idx, name := range envs {
+			os.Setenv(name, test[idx])
+		}
+		if charset := LocaleCharset(); charset != test[3] {
+			t.Errorf("%v: %s", test, charset)
+		}
+	}
+
+	for charset, expected := range map[string]bool{
+		"": true, "UTF-8": true, "utf8": true, "eucJP": false, "ISO-8859-1": false} {
+		if IsUTF8Charset(charset) != expected {
+			t.Errorf("%s: %v", charset, !expected)
+		}
+	}
+}
+
+func TestLocaleConversion(t *testing.T) {
+	defer func() {
+		localeCompat = false
+		localeCharset = nil
+	}()
+
+	if !SetLocaleCharset("eucJP") {
+		t.Fatal("eucJP should be supported")
+	}
+	euc := []byte{0xc6, 0xfc, 0xcb, 0xdc} // "日本"
+	if str := string(DecodeLocale(euc)); str != "日本" {
+		t.Errorf("%q", str)
+	}
+	if r, sz := DecodeLocaleRune(append(euc, 'a')); r != '日' || sz != 2 {
+		t.Errorf("%q %d", r, sz)
+	}
+	if str := EncodeLocale("日本 ok"); str != string(euc)+" ok" {
+		t.Errorf("%q", str)
+	}
+	// Not in EUC-JP
+	if str := EncodeLocale("日本😀"); str != string(euc)+"?" {
+		t.Errorf("%q", str)
+	}
+	if encodableInLocale('😀') || !encodableInLocale('日') {
+		t.Error("unexpected encodability")
+	}
+
+	if SetLocaleCharset("unknown") {
+		t.Error("unknown charset should not be supported")
+	}
+	if str := EncodeLocale("日本 ok"); str != "?? ok" {
+		t.Errorf("%q", str)
+	}
+}
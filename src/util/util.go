@@ -20,7 +20,11 @@ func RuneWidth(r rune, prefixWidth int, tabstop int) int {
 	} else if r == '\n' || r == '\r' {
 		return 1
 	}
-	w := runewidth.RuneWidth(r)
+	w := emojiWidth(r, runewidth.RuneWidth(r))
+	if w != 1 && !encodableInLocale(r) {
+		// Displayed as a question mark
+		w = 1
+	}
 	_runeWidths[r] = w
 	return w
 }
@@ -19,18 +19,38 @@ func ExecCommand(command string, setpgid bool) *exec.Cmd {
 
 // ExecCommandWith executes the given command with the specified shell
 func ExecCommandWith(shell string, command string, setpgid bool) *exec.Cmd {
-	cmd := exec.Command(shell, "-c", command)
+	cmd := exec.Command(shell, "-c", EncodeLocale(command))
 	if setpgid {
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	}
 	return cmd
 }
 
+// Become replaces the current process with the given command executed with
+// $SHELL
+func Become(command string, env []string) error {
+	shell := os.Getenv("SHELL")
+	if len(shell) == 0 {
+		shell = "sh"
+	}
+	path, err := exec.LookPath(shell)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, []string{shell, "-c", EncodeLocale(command)}, env)
+}
+
 // KillCommand kills the process for the given command
 func KillCommand(cmd *exec.Cmd) error {
 	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 }
 
+// ContinueCommand resumes the process group of the given command when it is
+// stopped
+func ContinueCommand(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGCONT)
+}
+
 // IsWindows returns true on Windows
 func IsWindows() bool {
 	return false
@@ -45,3 +65,21 @@ func SetNonblock(file *os.File, nonblock bool) {
 func Read(fd int, b []byte) (int, error) {
 	return syscall.Read(int(fd), b)
 }
+
+// LockFile acquires an exclusive advisory lock on the file of the given path,
+// which is created if it does not exist, and returns the function to release
+// it
+func LockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
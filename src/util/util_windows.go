@@ -7,6 +7,8 @@ import (
 	"os"
 	"os/exec"
 	"syscall"
+
+	"golang.org/x/sys/windows"
 )
 
 // ExecCommand executes the given command with cmd
@@ -28,11 +30,34 @@ func ExecCommandWith(_shell string, command string, setpgid bool) *exec.Cmd {
 	return cmd
 }
 
+// Become executes the given command with cmd and exits with its exit status
+// as Windows does not support replacing the current process
+func Become(command string, env []string) error {
+	cmd := ExecCommand(command, false)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitError.ExitCode())
+		}
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
 // KillCommand kills the process for the given command
 func KillCommand(cmd *exec.Cmd) error {
 	return cmd.Process.Kill()
 }
 
+// ContinueCommand is a no-op on Windows
+func ContinueCommand(cmd *exec.Cmd) error {
+	return nil
+}
+
 // IsWindows returns true on Windows
 func IsWindows() bool {
 	return true
@@ -47,3 +72,22 @@ func SetNonblock(file *os.File, nonblock bool) {
 func Read(fd int, b []byte) (int, error) {
 	return syscall.Read(syscall.Handle(fd), b)
 }
+
+// LockFile acquires an exclusive lock on the file of the given path, which is
+// created if it does not exist, and returns the function to release it
+func LockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	handle := windows.Handle(file.Fd())
+	overlapped := new(windows.Overlapped)
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		windows.UnlockFileEx(handle, 0, 1, 0, overlapped)
+		file.Close()
+	}, nil
+}
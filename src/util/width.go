@@ -0,0 +1,43 @@
+package util
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// The width of the emoji characters, or zero to follow the table of
+// East Asian Width
+var _emojiWidth = 0
+
+// SetAmbiguousWidth sets the width of the characters of East Asian Ambiguous
+// width, which are displayed as wide characters by the terminals for CJK users
+func SetAmbiguousWidth(width int) {
+	runewidth.DefaultCondition.EastAsianWidth = width == 2
+	uniseg.EastAsianAmbiguousWidth = width
+	_runeWidths = make(map[rune]int)
+}
+
+// SetEmojiWidth sets the width of the emoji characters, which some terminals
+// display in a single column
+func SetEmojiWidth(width int) {
+	_emojiWidth = width
+	_runeWidths = make(map[rune]int)
+}
+
+// emojiWidth returns the width of the character of the given width in the
+// table, which is overridden if it is an emoji character
+func emojiWidth(r rune, width int) int {
+	if _emojiWidth > 0 && width == 2 && isEmoji(r) {
+		return _emojiWidth
+	}
+	return width
+}
+
+// isEmoji tells if the character is in the blocks of the emoji characters with
+// the default emoji presentation
+func isEmoji(r rune) bool {
+	return r >= 0x1F000 && r <= 0x1FAFF ||
+		r >= 0x231A && r <= 0x23FF ||
+		r >= 0x2600 && r <= 0x27BF ||
+		r >= 0x2B00 && r <= 0x2BFF
+}
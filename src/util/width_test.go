@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+func TestAmbiguousWidth(t *testing.T) {
+	// The width can be 2 by default depending on the locale
+	defer func(eastAsian bool, ambiguous int) {
+		runewidth.DefaultCondition.EastAsianWidth = eastAsian
+		uniseg.EastAsianAmbiguousWidth = ambiguous
+		_runeWidths = make(map[rune]int)
+	}(runewidth.DefaultCondition.EastAsianWidth, uniseg.EastAsianAmbiguousWidth)
+	SetAmbiguousWidth(1)
+	if w := RuneWidth('→', 0, 8); w != 1 {
+		t.Errorf("expected: 1, actual: %d", w)
+	}
+	SetAmbiguousWidth(2)
+	if w := RuneWidth('→', 0, 8); w != 2 {
+		t.Errorf("expected: 2, actual: %d", w)
+	}
+	// Not ambiguous
+	if w := RuneWidth('a', 0, 8); w != 1 {
+		t.Errorf("expected: 1, actual: %d", w)
+	}
+}
+
+func TestEmojiWidth(t *testing.T) {
+	defer SetEmojiWidth(_emojiWidth)
+	SetEmojiWidth(1)
+	for _, text := range []string{"😀", "❤️", "\U0001F468‍\U0001F469‍\U0001F467"} {
+		if _, w := NextGrapheme([]rune(text), 0, 8); w != 1 {
+			t.Errorf("%q: expected: 1, actual: %d", text, w)
+		}
+	}
+	// Wide characters other than emoji
+	if w := RuneWidth('日', 0, 8); w != 2 {
+		t.Errorf("expected: 2, actual: %d", w)
+	}
+	SetEmojiWidth(0)
+	if w := RuneWidth('😀', 0, 8); w != 2 {
+		t.Errorf("expected: 2, actual: %d", w)
+	}
+}